@@ -0,0 +1,89 @@
+package circuit
+
+import (
+	"fmt"
+
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/std/algebra/emulated/sw_bn254"
+	"github.com/consensys/gnark/std/math/emulated"
+	stdgroth16 "github.com/consensys/gnark/std/recursion/groth16"
+)
+
+// LowerProof is a Groth16 proof of a lower level proof, as verified in an AggregationCircuit.
+type LowerProof = stdgroth16.Proof[sw_bn254.G1Affine, sw_bn254.G2Affine]
+
+// LowerVerifyingKey is the verification key of a lower level proof, as fixed in an AggregationCircuit.
+type LowerVerifyingKey = stdgroth16.VerifyingKey[sw_bn254.G1Affine, sw_bn254.G2Affine, sw_bn254.GTEl]
+
+// AggregationCircuit is a Circuit whose accounts are the pseudo-accounts of lower level proofs, which it also
+// verifies recursively: the lower level proof of each account must be a valid Groth16 proof for its verification key,
+// with the account's WalletId as merkle root, the account's hash as MerkleRootWithAssetSumHash, its account count,
+// and the epoch and circuit version of the circuit as public inputs. A valid aggregation proof therefore attests every proof below it, so
+// a top level proof alone attests the whole hierarchy.
+// Verifying a Groth16 proof over BN254 inside a BN254 circuit emulates the pairing, which takes over a million
+// constraints per lower level proof, so aggregation is only practical with small tree depths (see
+// Config.ValidateAggregation).
+type AggregationCircuit struct {
+	// Circuit holds the accounts and public inputs, which are the same as those of a Circuit.
+	Circuit Circuit
+	// LowerProofs are the proofs of the lower level proofs, one per account.
+	LowerProofs []LowerProof
+	// LowerAccountCounts are the account counts of the lower level proofs, one per account.
+	LowerAccountCounts []frontend.Variable
+	// LowerVerifyingKeys are the verification keys of the lower level proofs, one per account. They are constants of
+	// the circuit rather than part of the witness, so the verification key of the aggregation proof commits to them.
+	LowerVerifyingKeys []LowerVerifyingKey `gnark:"-"`
+}
+
+// Define defines the constraints of the Circuit, and verifies the lower level proof of every account.
+func (c *AggregationCircuit) Define(api frontend.API) error {
+	if err := c.Circuit.Define(api); err != nil {
+		return err
+	}
+	accounts := c.Circuit.Accounts
+	if len(c.LowerProofs) != len(accounts) || len(c.LowerAccountCounts) != len(accounts) || len(c.LowerVerifyingKeys) != len(accounts) {
+		return fmt.Errorf("aggregation circuit needs a lower level proof, account count, and verification key for each of its %d accounts", len(accounts))
+	}
+
+	config := c.Circuit.getConfig()
+	hasher, err := newHasher(api, config.GetHashFunction())
+	if err != nil {
+		return fmt.Errorf("error while instantiating %s hasher: %w", config.GetHashFunction(), err)
+	}
+	scalarField, err := emulated.NewField[sw_bn254.ScalarField](api)
+	if err != nil {
+		return fmt.Errorf("error while instantiating emulated scalar field: %w", err)
+	}
+	verifier, err := stdgroth16.NewVerifier[sw_bn254.ScalarField, sw_bn254.G1Affine, sw_bn254.G2Affine, sw_bn254.GTEl](api)
+	if err != nil {
+		return fmt.Errorf("error while instantiating recursive verifier: %w", err)
+	}
+
+	for i, account := range accounts {
+		// the salt of a pseudo-account is the epoch of its proof (see hashRootWithAssetSum), which must be ours
+		api.AssertIsEqual(account.Salt, c.Circuit.Epoch)
+
+		// the public inputs of the lower level proof, in the order of Circuit, as elements of its (emulated) field
+		publicInputs := []frontend.Variable{account.WalletId, hashPseudoAccount(api, hasher, account, config), c.LowerAccountCounts[i], c.Circuit.Epoch, c.Circuit.CircuitVersion}
+		witness := stdgroth16.Witness[sw_bn254.ScalarField]{Public: make([]emulated.Element[sw_bn254.ScalarField], len(publicInputs))}
+		for j, input := range publicInputs {
+			witness.Public[j] = *scalarField.FromBits(api.ToBinary(input)...)
+		}
+		if err := verifier.AssertProof(c.LowerVerifyingKeys[i], c.LowerProofs[i], witness); err != nil {
+			return fmt.Errorf("error verifying lower level proof %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// ConvertGroth16ProofToLowerProof converts a Groth16 proof of a lower level proof for an AggregationCircuit.
+func ConvertGroth16ProofToLowerProof(proof groth16.Proof) (LowerProof, error) {
+	return stdgroth16.ValueOfProof[sw_bn254.G1Affine, sw_bn254.G2Affine](proof)
+}
+
+// ConvertGroth16VerifyingKeyToLowerVerifyingKey converts the Groth16 verification key of a lower level proof for an
+// AggregationCircuit, which fixes it in the circuit.
+func ConvertGroth16VerifyingKeyToLowerVerifyingKey(vk groth16.VerifyingKey) (LowerVerifyingKey, error) {
+	return stdgroth16.ValueOfVerifyingKeyFixed[sw_bn254.G1Affine, sw_bn254.G2Affine, sw_bn254.GTEl](vk)
+}
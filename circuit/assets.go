@@ -0,0 +1,295 @@
+package circuit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Asset is a cryptocurrency balances are tracked for.
+type Asset struct {
+	Symbol string `json:"symbol" yaml:"symbol"`
+	// Decimals is the number of decimal places of the asset, i.e. the number of base units in one display unit is
+	// 10^Decimals. Balances are always committed in base units.
+	Decimals int `json:"decimals" yaml:"decimals"`
+	// BalanceBits is the number of bits the balances and asset sums of the asset are range checked to at every level,
+	// instead of those of the circuit configuration (see Config.BalanceBits), e.g. fewer for an asset whose total supply
+	// is small (0 for those of the configuration). As balances are non-negative, every sum of balances is bounded by the
+	// total, so one range fits every level.
+	BalanceBits int `json:"balanceBits,omitempty" yaml:"balanceBits,omitempty"`
+}
+
+// AssetRegistry lists the assets of every balance: the balance at index i is the balance of Assets[i]. Accounts,
+// proofs, and verifiers must use the same registry, so the hash of the registry is recorded in every proof.
+type AssetRegistry struct {
+	Assets []Asset `json:"assets" yaml:"assets"`
+}
+
+// DefaultAssetRegistry returns the registry of the assets supported before the registry was configurable.
+func DefaultAssetRegistry() AssetRegistry {
+	symbols := []string{"ALGO", "ARBETH", "AVAXC", "BTC", "BCH", "ADA", "CSPR", "TIA",
+		"ATOM", "DASH", "DOGE", "EOS", "ETH", "ETC", "HBAR", "LTC", "NEAR",
+		"OSMO", "DOT", "POLYGON", "SEI", "SOL", "STX", "XLM", "SUI", "TRX", "XRP",
+		"ZEC", "ZETA", "BLD", "BSC", "TON", "COREDAO", "BERA", "TAO", "APT"}
+	decimals := []int{6, 18, 18, 8, 8, 6, 9, 6,
+		6, 8, 8, 4, 18, 18, 8, 8, 24,
+		6, 10, 18, 6, 9, 6, 7, 9, 6, 6,
+		8, 18, 6, 18, 9, 18, 18, 9, 8}
+	assets := make([]Asset, len(symbols))
+	for i := range symbols {
+		assets[i] = Asset{Symbol: symbols[i], Decimals: decimals[i]}
+	}
+	return AssetRegistry{Assets: assets}
+}
+
+// ReadAssetRegistry reads an asset registry from a JSON or YAML file (by extension, .json, .yaml, or .yml), and
+// validates it.
+func ReadAssetRegistry(path string) (AssetRegistry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return AssetRegistry{}, fmt.Errorf("error reading asset registry: %w", err)
+	}
+	var registry AssetRegistry
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(data, &registry)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &registry)
+	default:
+		return AssetRegistry{}, fmt.Errorf("asset registry %s must be a .json, .yaml, or .yml file", path)
+	}
+	if err != nil {
+		return AssetRegistry{}, fmt.Errorf("error decoding asset registry: %w", err)
+	}
+	return registry, registry.Validate()
+}
+
+// Validate returns an error if the registry has no assets, an asset without a symbol, a symbol more than once,
+// negative decimals, or balance bits out of range.
+func (r AssetRegistry) Validate() error {
+	if len(r.Assets) == 0 {
+		return fmt.Errorf("asset registry has no assets")
+	}
+	seen := make(map[string]bool, len(r.Assets))
+	for i, asset := range r.Assets {
+		if asset.Symbol == "" {
+			return fmt.Errorf("asset %d has no symbol", i)
+		}
+		if seen[asset.Symbol] {
+			return fmt.Errorf("asset %s is listed more than once", asset.Symbol)
+		}
+		seen[asset.Symbol] = true
+		if asset.Decimals < 0 {
+			return fmt.Errorf("asset %s has negative decimals %d", asset.Symbol, asset.Decimals)
+		}
+		if asset.BalanceBits < 0 || asset.BalanceBits > MAX_BALANCE_BITS {
+			return fmt.Errorf("asset %s has balance bits %d, which must be between 1 and %d (or 0 for those of the circuit configuration)",
+				asset.Symbol, asset.BalanceBits, MAX_BALANCE_BITS)
+		}
+	}
+	return nil
+}
+
+// Hash returns the hex encoded SHA-256 hash of the symbols and decimals (and balance bits, if any) of the assets, in
+// order.
+func (r AssetRegistry) Hash() string {
+	hasher := sha256.New()
+	for _, asset := range r.Assets {
+		// symbols can't contain newlines when read from a file, and the separator keeps the encoding unambiguous
+		if asset.BalanceBits == 0 {
+			fmt.Fprintf(hasher, "%s:%d\n", asset.Symbol, asset.Decimals)
+		} else {
+			fmt.Fprintf(hasher, "%s:%d:%d\n", asset.Symbol, asset.Decimals, asset.BalanceBits)
+		}
+	}
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// SetAssetRegistry sets the registry of the assets of every balance. Like the deployment domain, it must be set before
+// any proofs are generated or verified, and the registry is unchanged if it is invalid. It clears the asset subset,
+// whose indices are indices of the previous registry.
+func SetAssetRegistry(r AssetRegistry) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+	return updateSettings(func(s *settings) error {
+		s.assetRegistry = AssetRegistry{Assets: append([]Asset{}, r.Assets...)}
+		s.assetSubset = nil
+		return nil
+	})
+}
+
+func GetAssetRegistry() AssetRegistry {
+	return getSettings().assetRegistry
+}
+
+// ValidateAssetSubset returns an error if the indices are not increasing indices of the asset registry. A nil subset
+// is every asset, but an empty one is invalid.
+func ValidateAssetSubset(indices []int) error {
+	return GetAssetRegistry().ValidateSubset(indices)
+}
+
+// ValidateSubset returns an error if the indices are not increasing indices of the registry, like
+// ValidateAssetSubset.
+func (r AssetRegistry) ValidateSubset(indices []int) error {
+	if indices != nil && len(indices) == 0 {
+		return fmt.Errorf("asset subset has no assets")
+	}
+	for i, index := range indices {
+		if index < 0 || index >= len(r.Assets) {
+			return fmt.Errorf("no asset at index %d of the asset registry", index)
+		}
+		if i > 0 && index <= indices[i-1] {
+			return fmt.Errorf("asset subset %v is not in increasing order", indices)
+		}
+	}
+	return nil
+}
+
+// SetAssetSubset restricts every balance to the assets at the given indices of the asset registry (nil for every
+// asset), e.g. to prove the liabilities in BTC and ETH only. Balances read from batch files still have every asset of
+// the registry, and are restricted with SelectAssetSubset. Like the asset registry, it must be set before any proofs
+// are generated or verified, and the subset is unchanged if it is invalid.
+func SetAssetSubset(indices []int) error {
+	return updateSettings(func(s *settings) error {
+		// the subset is validated against the registry it is set with, in case the registry is set concurrently
+		if err := s.assetRegistry.ValidateSubset(indices); err != nil {
+			return err
+		}
+		if indices == nil {
+			s.assetSubset = nil
+		} else {
+			s.assetSubset = append([]int{}, indices...)
+		}
+		return nil
+	})
+}
+
+// GetAssetSubset returns the indices of the asset registry that every balance has (nil for every asset).
+func GetAssetSubset() []int {
+	assetSubset := getSettings().assetSubset
+	if assetSubset == nil {
+		return nil
+	}
+	return append([]int{}, assetSubset...)
+}
+
+// SelectAssetSubset returns the balances of the assets of the asset subset, out of a balance of every asset of the
+// registry. The balance is returned as is if there is no subset.
+func SelectAssetSubset(balance GoBalance) (GoBalance, error) {
+	s := getSettings()
+	return s.assetRegistry.SelectSubset(s.assetSubset, balance)
+}
+
+// SelectSubset returns the balances of the assets at the given indices of the registry (nil for every asset), out of
+// a balance of every asset of the registry, like SelectAssetSubset. The indices must be a valid asset subset (see
+// ValidateAssetSubset).
+func (r AssetRegistry) SelectSubset(indices []int, balance GoBalance) (GoBalance, error) {
+	if len(balance) != len(r.Assets) {
+		return nil, fmt.Errorf("balance has %d assets, but the asset registry has %d", len(balance), len(r.Assets))
+	}
+	if indices == nil {
+		return balance, nil
+	}
+	selected := make(GoBalance, len(indices))
+	for i, index := range indices {
+		selected[i] = balance[index]
+	}
+	return selected, nil
+}
+
+// SubsetAssets returns the assets at the given indices of the registry (every asset for nil indices), i.e. the assets
+// of the balances of proofs of that asset subset. The indices must be a valid asset subset (see ValidateAssetSubset).
+func (r AssetRegistry) SubsetAssets(indices []int) []Asset {
+	if indices == nil {
+		return r.Assets
+	}
+	assets := make([]Asset, len(indices))
+	for i, index := range indices {
+		assets[i] = r.Assets[index]
+	}
+	return assets
+}
+
+// balanceAssets returns the assets of every balance: the assets of the subset, or of the registry if there is none.
+func balanceAssets() []Asset {
+	s := getSettings()
+	return s.assetRegistry.SubsetAssets(s.assetSubset)
+}
+
+// GetNumberOfAssets returns the number of assets of every balance.
+func GetNumberOfAssets() int {
+	return len(balanceAssets())
+}
+
+// GetAssetSymbols returns the symbols of the assets of every balance, in order.
+func GetAssetSymbols() []string {
+	assets := balanceAssets()
+	symbols := make([]string, len(assets))
+	for i, asset := range assets {
+		symbols[i] = asset.Symbol
+	}
+	return symbols
+}
+
+// GetAssetDecimals returns the decimals of the assets of every balance, in order.
+func GetAssetDecimals() []int {
+	assets := balanceAssets()
+	decimals := make([]int, len(assets))
+	for i, asset := range assets {
+		decimals[i] = asset.Decimals
+	}
+	return decimals
+}
+
+// GetAssetBalanceBits returns the number of bits the balances of each asset of every balance are range checked to, in
+// order, by a circuit that range checks balances to bits: the balance bits of the asset, or bits if it has none.
+func GetAssetBalanceBits(bits int) []int {
+	assets := balanceAssets()
+	assetBits := make([]int, len(assets))
+	for i, asset := range assets {
+		assetBits[i] = bits
+		if asset.BalanceBits != 0 {
+			assetBits[i] = asset.BalanceBits
+		}
+	}
+	return assetBits
+}
+
+// ParseAssetAmount converts an amount of the asset at the given index of the registry (not of the asset subset, as
+// batch files have every asset), written as a decimal string in display units optionally followed by the symbol of
+// the asset (e.g. "1.25 BTC" or "1.25"), to base units. Returns an error if the symbol is not the asset's, or if the
+// amount is not a valid display amount of the asset (see ConvertDisplayAmountToBaseUnits).
+func ParseAssetAmount(amount string, asset int) (*big.Int, error) {
+	assetRegistry := GetAssetRegistry()
+	if asset < 0 || asset >= len(assetRegistry.Assets) {
+		return nil, fmt.Errorf("no asset at index %d of the asset registry", asset)
+	}
+	symbol := assetRegistry.Assets[asset].Symbol
+	fields := strings.Fields(amount)
+	switch {
+	case len(fields) == 2 && fields[1] != symbol:
+		return nil, fmt.Errorf("amount %q is not in %s, the asset at index %d", amount, symbol, asset)
+	case len(fields) != 1 && len(fields) != 2:
+		return nil, fmt.Errorf("invalid amount of %s: %q", symbol, amount)
+	}
+	baseUnits, err := ConvertDisplayAmountToBaseUnits(fields[0], assetRegistry.Assets[asset].Decimals)
+	if err != nil {
+		return nil, fmt.Errorf("invalid amount of %s: %w", symbol, err)
+	}
+	return baseUnits, nil
+}
+
+// FormatAssetAmount converts an amount in base units of the asset at the given index of the registry to a decimal
+// string in display units followed by the symbol of the asset (e.g. "1.25 BTC"), which ParseAssetAmount reads back.
+func FormatAssetAmount(amount *big.Int, asset int) string {
+	assetRegistry := GetAssetRegistry()
+	return ConvertBaseUnitsToDisplayAmount(amount, assetRegistry.Assets[asset].Decimals) + " " + assetRegistry.Assets[asset].Symbol
+}
@@ -3,8 +3,10 @@
 package circuit
 
 import (
+	"math/big"
+
 	"github.com/consensys/gnark/frontend"
-	"github.com/consensys/gnark/std/hash/mimc"
+	"github.com/consensys/gnark/std/hash"
 	"github.com/consensys/gnark/std/rangecheck"
 )
 
@@ -29,7 +31,7 @@ func ConstructBalance(initialBalances ...frontend.Variable) Balance {
 
 // Returns sum of 2 balances.
 func addBalance(api frontend.API, a, b Balance) Balance {
-	// Enforce balances have same length as AssetSymbols. This is done as a panic instead of a circuit
+	// Enforce balances have same length as the asset registry. This is done as a panic instead of a circuit
 	// constraint as it is not necessary for the proofs to be valid - the worst an exchange can do is
 	// add fake accounts with a different size balance array, which will only increase the liabilities.
 	// (User account balances cannot be tampered with due to the additional Merkle Tree hash verification).
@@ -43,9 +45,9 @@ func addBalance(api frontend.API, a, b Balance) Balance {
 	return summedBalance
 }
 
-// hashBalance computes the MiMC hash of the balance.
-func hashBalance(hasher mimc.MiMC, balances Balance) (hash frontend.Variable) {
-	// enforce balances have same length as AssetSymbols (see note in addBalance)
+// hashBalance computes the hash of the balance.
+func hashBalance(hasher hash.FieldHasher, balances Balance) (hash frontend.Variable) {
+	// enforce balances have same length as the asset registry (see note in addBalance)
 	if len(balances) != GetNumberOfAssets() {
 		panic(INVALID_BALANCE_LENGTH_MESSAGE)
 	}
@@ -54,30 +56,106 @@ func hashBalance(hasher mimc.MiMC, balances Balance) (hash frontend.Variable) {
 	return hasher.Sum()
 }
 
-// hashAccount computes the MiMC hash of the account. GoComputeMiMCHashForAccount is the Go equivalent for general use.
-func hashAccount(hasher mimc.MiMC, account Account) (hash frontend.Variable) {
+// writeDeploymentDomain writes the deployment domain of the configuration (if any) to the hasher as a constant.
+// goWriteDeploymentDomain is the Go equivalent.
+func writeDeploymentDomain(hasher hash.FieldHasher, config Config) {
+	if domain := config.GetDeploymentDomainElement(); domain != nil {
+		hasher.Write(new(big.Int).SetBytes(domain))
+	}
+}
+
+// writeHashTag writes the tag (LEAF_HASH_TAG, NODE_HASH_TAG, or ROOT_HASH_TAG) to the hasher if the hash version of
+// the configuration tags hashes. It must be called right after writeDeploymentDomain. goWriteHashTag is the Go
+// equivalent.
+func writeHashTag(hasher hash.FieldHasher, tag int, config Config) {
+	if config.GetHashVersion().IsTagged() {
+		hasher.Write(tag)
+	}
+}
+
+// hashAccount computes the hash of the account. GoComputeMiMCHashForAccount is the Go equivalent for general use.
+// Accounts with a zero salt (and pseudo-accounts without one) are hashed as H(WalletId, H(balances)), and salted
+// accounts as H(WalletId, Salt, H(balances)), so balances of a known WalletId can't be brute-forced from its leaf.
+// With tagged hash versions, LEAF_HASH_TAG is hashed before WalletId. With LEAF_HASH_SHA256, the account is hashed with
+// SHA-256 instead (see sha256HashAccount).
+func hashAccount(api frontend.API, hasher hash.FieldHasher, account Account, config Config) (hash frontend.Variable) {
+	return hashAccountWithTag(api, hasher, account, LEAF_HASH_TAG, config)
+}
+
+// hashAccountWithTag computes the hash of the account like hashAccount, with the given tag instead of LEAF_HASH_TAG.
+func hashAccountWithTag(api frontend.API, hasher hash.FieldHasher, account Account, tag int, config Config) (hash frontend.Variable) {
+	if config.GetLeafHashMode() == LEAF_HASH_SHA256 {
+		return sha256HashAccount(api, account, tag, config)
+	}
+	balanceHash := hashBalance(hasher, account.Balance)
 	hasher.Reset()
-	hasher.Write(account.WalletId, hashBalance(hasher, account.Balance))
-	return hasher.Sum()
+	writeDeploymentDomain(hasher, config)
+	writeHashTag(hasher, tag, config)
+	hasher.Write(account.WalletId, balanceHash)
+	unsaltedHash := hasher.Sum()
+	if account.Salt == nil {
+		return unsaltedHash
+	}
+
+	hasher.Reset()
+	writeDeploymentDomain(hasher, config)
+	writeHashTag(hasher, tag, config)
+	hasher.Write(account.WalletId, account.Salt, balanceHash)
+	return api.Select(api.IsZero(account.Salt), unsaltedHash, hasher.Sum())
 }
 
-// computeMerkleRootFromAccounts computes the Merkle root from the accounts.
-// GoComputeMerkleRootFromAccounts is the Go equivalent for general use.
-func computeMerkleRootFromAccounts(hasher mimc.MiMC, accounts []Account) (rootHash frontend.Variable) {
-	// store hashes of accounts in an array (pad with 0's to reach 2^TREE_DEPTH nodes)
-	nodes := make([]frontend.Variable, PowOfTwo(TREE_DEPTH))
-	for i := 0; i < PowOfTwo(TREE_DEPTH); i++ {
-		if i < len(accounts) {
-			nodes[i] = hashAccount(hasher, accounts[i])
+// hashRootWithAssetSum computes the hash binding the merkle root to the asset sum and the epoch, which is the hash of
+// the pseudo-account of the proof (see hashPseudoAccount), salted with the epoch (so epoch zero gives the unsalted
+// hash). GoComputeRootWithAssetSumHash is the Go equivalent for general use.
+func hashRootWithAssetSum(api frontend.API, hasher hash.FieldHasher, merkleRoot frontend.Variable, assetSum Balance, epoch frontend.Variable, config Config) (hash frontend.Variable) {
+	return hashPseudoAccount(api, hasher, Account{WalletId: merkleRoot, Balance: assetSum, Salt: epoch}, config)
+}
+
+// hashPseudoAccount computes the hash of the pseudo-account of a lower level proof, with its merkle root as WalletId,
+// asset sum as Balance, and epoch as Salt, which is its leaf in the upper level proof. With HASH_VERSION_ROOT_TAGGED,
+// it is tagged with ROOT_HASH_TAG, so it never equals the hash of an account.
+func hashPseudoAccount(api frontend.API, hasher hash.FieldHasher, account Account, config Config) (hash frontend.Variable) {
+	return hashAccountWithTag(api, hasher, account, config.pseudoAccountHashTag(), config)
+}
+
+// hashLeaf computes the leaf of the account in a proof with the configuration: the hash of the account, or of the
+// pseudo-account of a lower level proof in upper level proofs (see Config.UpperLevel).
+func hashLeaf(api frontend.API, hasher hash.FieldHasher, account Account, config Config) (hash frontend.Variable) {
+	if config.UpperLevel {
+		return hashPseudoAccount(api, hasher, account, config)
+	}
+	return hashAccount(api, hasher, account, config)
+}
+
+// computeMerkleRootFromAccounts computes the Merkle root of a tree of depth config.TreeDepth from the leaves of the
+// accounts (see hashLeaf). GoComputeMerkleRootFromAccounts is the Go equivalent for general use.
+func computeMerkleRootFromAccounts(api frontend.API, hasher hash.FieldHasher, accounts []Account, config Config) (rootHash frontend.Variable) {
+	leaves := make([]frontend.Variable, len(accounts))
+	for i, account := range accounts {
+		leaves[i] = hashLeaf(api, hasher, account, config)
+	}
+	return computeMerkleRootFromLeaves(api, hasher, leaves, config)
+}
+
+// computeMerkleRootFromLeaves computes the Merkle root of a tree of depth config.TreeDepth from its first leaves.
+func computeMerkleRootFromLeaves(api frontend.API, hasher hash.FieldHasher, leaves []frontend.Variable, config Config) (rootHash frontend.Variable) {
+	treeDepth := config.TreeDepth
+	// store the leaves in an array (pad with 0's to reach 2^treeDepth nodes)
+	nodes := make([]frontend.Variable, PowOfTwo(treeDepth))
+	for i := 0; i < PowOfTwo(treeDepth); i++ {
+		if i < len(leaves) {
+			nodes[i] = leaves[i]
 		} else {
 			nodes[i] = 0
 		}
 	}
 
 	// iteratively calculate hashes of parent nodes from bottom level to root
-	for i := TREE_DEPTH - 1; i >= 0; i-- {
+	for i := treeDepth - 1; i >= 0; i-- {
 		for j := 0; j < PowOfTwo(i); j++ {
 			hasher.Reset()
+			writeDeploymentDomain(hasher, config)
+			writeHashTag(hasher, NODE_HASH_TAG, config)
 			hasher.Write(nodes[j*2], nodes[j*2+1])
 			nodes[j] = hasher.Sum()
 		}
@@ -85,9 +163,32 @@ func computeMerkleRootFromAccounts(hasher mimc.MiMC, accounts []Account) (rootHa
 	return nodes[0]
 }
 
+// computeMerkleRootOfPaddedAccounts computes the Merkle root of a tree of depth config.TreeDepth from the first
+// accountCount accounts, asserting the accounts after them are padding: their balances are zero, and their leaves are
+// zero like those of the positions past the accounts (see Config.PaddedBatches). accountCount must be between 1 and
+// the number of accounts.
+func computeMerkleRootOfPaddedAccounts(api frontend.API, hasher hash.FieldHasher, accounts []Account, accountCount frontend.Variable, config Config) (rootHash frontend.Variable) {
+	// the account at position i is padding once accountCount is i or less, i.e. equal to one of 1 to i, and
+	// accountCount must equal exactly one of 1 to len(accounts)
+	leaves := make([]frontend.Variable, len(accounts))
+	padding := frontend.Variable(0)
+	for i, account := range accounts {
+		if i > 0 {
+			padding = api.Add(padding, api.IsZero(api.Sub(accountCount, i)))
+		}
+		for _, balance := range account.Balance {
+			api.AssertIsEqual(api.Mul(padding, balance), 0)
+		}
+		leaves[i] = api.Mul(api.Sub(1, padding), hashLeaf(api, hasher, account, config))
+	}
+	padding = api.Add(padding, api.IsZero(api.Sub(accountCount, len(accounts))))
+	api.AssertIsEqual(padding, 1)
+	return computeMerkleRootFromLeaves(api, hasher, leaves, config)
+}
+
 // Adds constraints to verify the given balances are equal.
 func assertBalancesAreEqual(api frontend.API, a, b Balance) {
-	// enforce balances have same length as AssetSymbols (see note in addBalance)
+	// enforce balances have same length as the asset registry (see note in addBalance)
 	if len(a) != GetNumberOfAssets() || len(b) != GetNumberOfAssets() {
 		panic(INVALID_BALANCE_LENGTH_MESSAGE)
 	}
@@ -98,53 +199,97 @@ func assertBalancesAreEqual(api frontend.API, a, b Balance) {
 	}
 }
 
-// Adds constraints to verify each balance is a value between [0, 2^128 - 1].
-func assertBalanceNonNegativeAndNonOverflow(api frontend.API, balances Balance) {
-	// enforce balances have same length as AssetSymbols (see note in addBalance)
+// plainRangechecker range checks values by decomposing them into bits, without the commitment of the range checks of
+// rangecheck.New (see Config.PlainRangeChecks).
+type plainRangechecker struct {
+	api frontend.API
+}
+
+func (r plainRangechecker) Check(v frontend.Variable, bits int) {
+	r.api.ToBinary(v, bits)
+}
+
+// newRangechecker returns the range checker of circuits with the configuration.
+func newRangechecker(api frontend.API, config Config) frontend.Rangechecker {
+	if config.PlainRangeChecks {
+		return plainRangechecker{api}
+	}
+	return rangecheck.New(api)
+}
+
+// Adds constraints to verify each balance is a value between [0, 2^bits - 1], or [0, 2^Asset.BalanceBits - 1] for
+// assets with their own balance bits.
+func assertBalanceNonNegativeAndNonOverflow(api frontend.API, ranger frontend.Rangechecker, balances Balance, bits int) {
+	// enforce balances have same length as the asset registry (see note in addBalance)
 	if len(balances) != GetNumberOfAssets() {
 		panic(INVALID_BALANCE_LENGTH_MESSAGE)
 	}
 
 	// add constraints
-	ranger := rangecheck.New(api)
-	for _, balance := range balances {
-		ranger.Check(balance, 128)
+	for i, assetBits := range GetAssetBalanceBits(bits) {
+		ranger.Check(balances[i], assetBits)
+	}
+}
+
+// getConfig returns the configuration of the circuit, or the configured one if it has none.
+func (circuit *Circuit) getConfig() Config {
+	if circuit.Config == (Config{}) {
+		return GetConfig()
 	}
+	return circuit.Config
 }
 
 // Define defines the actual circuit.
 func (circuit *Circuit) Define(api frontend.API) error {
+	config := circuit.getConfig()
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
 	// This is not an essential part of the proof, because adding additional accounts
 	// can only increase the AssetSum and not decrease it.
 	// The creator of the proof can already do that by adding phony accounts with arbitrary balances,
 	// so violating this does not affect the security of the proof and does not introduce additional caveats.
 	// Thus, it is an inline check and not a constraint.
-	if len(circuit.Accounts) > PowOfTwo(TREE_DEPTH) {
+	if len(circuit.Accounts) > config.AccountsPerBatch() {
 		panic(MERKLE_TREE_LEAF_LIMIT_EXCEEDED_MESSAGE)
 	}
 
 	// initialize running balance
 	var runningBalance = ConstructBalance()
 
-	// create hasher
-	hasher, err := mimc.NewMiMC(api)
+	// create hasher of the hash function of the configuration
+	hasher, err := newHasher(api, config.GetHashFunction())
 	if err != nil {
-		panic("error while instantiating MiMC hasher" + err.Error())
+		panic("error while instantiating " + string(config.GetHashFunction()) + " hasher" + err.Error())
 	}
 
 	// for each account, add balance to running balance and assert balance in correct range
+	ranger := newRangechecker(api, config)
 	for i := 0; i < len(circuit.Accounts); i++ {
 		account := circuit.Accounts[i]
-		assertBalanceNonNegativeAndNonOverflow(api, account.Balance)
+		assertBalanceNonNegativeAndNonOverflow(api, ranger, account.Balance, config.GetBalanceBits())
 		runningBalance = addBalance(api, runningBalance, account.Balance)
 	}
 
-	// assert total balance = sum, merkle root matches, and merkle root with sum matches
+	// the asset sum becomes the balance of a pseudo-account at the next level, where it is range checked like any
+	// balance, so range check it here too: a proof whose asset sum overflows the range could not be aggregated
+	assertBalanceNonNegativeAndNonOverflow(api, ranger, circuit.AssetSum, config.GetAssetSumBits())
+
+	// assert total balance = sum, merkle root matches, merkle root with sum and epoch matches, account count matches,
+	// and circuit version matches
 	assertBalancesAreEqual(api, runningBalance, circuit.AssetSum)
-	root := computeMerkleRootFromAccounts(hasher, circuit.Accounts)
+	var root frontend.Variable
+	if config.PaddedBatches {
+		root = computeMerkleRootOfPaddedAccounts(api, hasher, circuit.Accounts, circuit.AccountCount, config)
+	} else {
+		root = computeMerkleRootFromAccounts(api, hasher, circuit.Accounts, config)
+		api.AssertIsEqual(circuit.AccountCount, len(circuit.Accounts))
+	}
 	api.AssertIsEqual(root, circuit.MerkleRoot)
-	rootWithSum := hashAccount(hasher, Account{WalletId: circuit.MerkleRoot, Balance: circuit.AssetSum})
+	rootWithSum := hashRootWithAssetSum(api, hasher, circuit.MerkleRoot, circuit.AssetSum, circuit.Epoch, config)
 	api.AssertIsEqual(rootWithSum, circuit.MerkleRootWithAssetSumHash)
+	api.AssertIsEqual(circuit.CircuitVersion, CIRCUIT_VERSION)
 
 	return nil
 }
@@ -1,13 +1,20 @@
 package circuit
 
 import (
+	"crypto/sha256"
 	"math/big"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/consensys/gnark-crypto/ecc"
 	"github.com/consensys/gnark/backend"
+	"github.com/consensys/gnark/backend/groth16"
 	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+	stdgroth16 "github.com/consensys/gnark/std/recursion/groth16"
 	"github.com/consensys/gnark/test"
 )
 
@@ -46,6 +53,9 @@ func TestCircuitWorks(t *testing.T) {
 			AssetSum:                   ConvertGoBalanceToBalance(GO_ASSET_SUM),
 			MerkleRoot:                 MERKLE_ROOT,
 			MerkleRootWithAssetSumHash: MERKLE_ROOT_WITH_ASSET_SUM_HASH,
+			AccountCount:               NUM_ACCOUNTS,
+			Epoch:                      0,
+			CircuitVersion:             CIRCUIT_VERSION,
 		},
 		test.WithCurves(ecc.BN254),
 		test.WithBackends(backend.GROTH16),
@@ -63,8 +73,8 @@ func TestCircuitDoesNotAcceptAccountsWithOverflow(t *testing.T) {
 
 	// create account with overflow balance, based on first generated go account
 	badBalanceAccount := GoAccount{
-		WalletId:  GO_ACCOUNTS[0].WalletId,
-		Balance: append(GO_ACCOUNTS[0].Balance[1:], new(big.Int).SetBytes(overflowBalance)),
+		WalletId: GO_ACCOUNTS[0].WalletId,
+		Balance:  append(GO_ACCOUNTS[0].Balance[1:], new(big.Int).SetBytes(overflowBalance)),
 	}
 
 	// add account with rest of generated accounts (remove first and add bad one to end)
@@ -80,7 +90,58 @@ func TestCircuitDoesNotAcceptAccountsWithOverflow(t *testing.T) {
 			Accounts:                   ConvertGoAccountsToAccounts(badGoAccounts),
 			AssetSum:                   ConvertGoBalanceToBalance(goAssetSum),
 			MerkleRoot:                 merkleRoot,
-			MerkleRootWithAssetSumHash: GoComputeMiMCHashForAccount(GoAccount{merkleRoot, goAssetSum}),
+			MerkleRootWithAssetSumHash: GoComputeRootWithAssetSumHash(merkleRoot, goAssetSum, 0),
+			AccountCount:               NUM_ACCOUNTS,
+			Epoch:                      0,
+			CircuitVersion:             CIRCUIT_VERSION,
+		},
+		test.WithCurves(ecc.BN254),
+		test.WithBackends(backend.GROTH16),
+	)
+}
+
+func TestCircuitDoesNotAcceptAssetSumWithOverflow(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// two balances within range whose sum (the asset sum) is not, so the asset sum could not be a balance at the next level
+	maxBalance := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), BALANCE_BITS), big.NewInt(1))
+	goAccounts := make([]GoAccount, NUM_ACCOUNTS)
+	copy(goAccounts, GO_ACCOUNTS)
+	for i := 0; i < 2; i++ {
+		goAccounts[i].Balance = append(GoBalance{maxBalance}, GO_ACCOUNTS[i].Balance[1:]...)
+	}
+	goAssetSum := SumGoAccountBalances(goAccounts)
+	merkleRoot := GoComputeMerkleRootFromAccounts(goAccounts)
+
+	assert.ProverFailed(
+		BASE_CIRCUIT,
+		&Circuit{
+			Accounts:                   ConvertGoAccountsToAccounts(goAccounts),
+			AssetSum:                   ConvertGoBalanceToBalance(goAssetSum),
+			MerkleRoot:                 merkleRoot,
+			MerkleRootWithAssetSumHash: GoComputeRootWithAssetSumHash(merkleRoot, goAssetSum, 0),
+			AccountCount:               NUM_ACCOUNTS,
+			Epoch:                      0,
+			CircuitVersion:             CIRCUIT_VERSION,
+		},
+		test.WithCurves(ecc.BN254),
+		test.WithBackends(backend.GROTH16),
+	)
+}
+
+func TestCircuitDoesNotAcceptOtherCircuitVersion(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	assert.ProverFailed(
+		BASE_CIRCUIT,
+		&Circuit{
+			Accounts:                   ConvertGoAccountsToAccounts(GO_ACCOUNTS),
+			AssetSum:                   ConvertGoBalanceToBalance(GO_ASSET_SUM),
+			MerkleRoot:                 MERKLE_ROOT,
+			MerkleRootWithAssetSumHash: MERKLE_ROOT_WITH_ASSET_SUM_HASH,
+			AccountCount:               NUM_ACCOUNTS,
+			Epoch:                      0,
+			CircuitVersion:             CIRCUIT_VERSION - 1,
 		},
 		test.WithCurves(ecc.BN254),
 		test.WithBackends(backend.GROTH16),
@@ -101,6 +162,9 @@ func TestCircuitDoesNotAcceptIncorrectAssetSum(t *testing.T) {
 			AssetSum:                   ConvertGoBalanceToBalance(badAssetSum),
 			MerkleRoot:                 MERKLE_ROOT,
 			MerkleRootWithAssetSumHash: MERKLE_ROOT_WITH_ASSET_SUM_HASH,
+			AccountCount:               NUM_ACCOUNTS,
+			Epoch:                      0,
+			CircuitVersion:             CIRCUIT_VERSION,
 		},
 		test.WithCurves(ecc.BN254),
 		test.WithBackends(backend.GROTH16),
@@ -117,6 +181,9 @@ func TestCircuitDoesNotAcceptInvalidMerkleRoot(t *testing.T) {
 			AssetSum:                   ConvertGoBalanceToBalance(GO_ASSET_SUM),
 			MerkleRoot:                 18724,
 			MerkleRootWithAssetSumHash: MERKLE_ROOT_WITH_ASSET_SUM_HASH,
+			AccountCount:               NUM_ACCOUNTS,
+			Epoch:                      0,
+			CircuitVersion:             CIRCUIT_VERSION,
 		},
 		test.WithCurves(ecc.BN254),
 		test.WithBackends(backend.GROTH16),
@@ -133,6 +200,9 @@ func TestCircuitDoesNotAcceptInvalidMerkleRootWithSumHash(t *testing.T) {
 			AssetSum:                   ConvertGoBalanceToBalance(GO_ASSET_SUM),
 			MerkleRoot:                 MERKLE_ROOT,
 			MerkleRootWithAssetSumHash: 18724,
+			AccountCount:               NUM_ACCOUNTS,
+			Epoch:                      0,
+			CircuitVersion:             CIRCUIT_VERSION,
 		},
 		test.WithCurves(ecc.BN254),
 		test.WithBackends(backend.GROTH16),
@@ -176,6 +246,9 @@ func TestCircuitPanicsOnAccountWithWrongBalanceLength(t *testing.T) {
 			AssetSum:                   assetSum,
 			MerkleRoot:                 MERKLE_ROOT,
 			MerkleRootWithAssetSumHash: MERKLE_ROOT_WITH_ASSET_SUM_HASH,
+			AccountCount:               NUM_ACCOUNTS,
+			Epoch:                      0,
+			CircuitVersion:             CIRCUIT_VERSION,
 		},
 		{
 			Accounts: func() []Account {
@@ -188,6 +261,9 @@ func TestCircuitPanicsOnAccountWithWrongBalanceLength(t *testing.T) {
 			AssetSum:                   assetSum,
 			MerkleRoot:                 MERKLE_ROOT,
 			MerkleRootWithAssetSumHash: MERKLE_ROOT_WITH_ASSET_SUM_HASH,
+			AccountCount:               NUM_ACCOUNTS,
+			Epoch:                      0,
+			CircuitVersion:             CIRCUIT_VERSION,
 		},
 	}
 
@@ -229,3 +305,629 @@ func TestCircuitPanicsWhenTooManyAccounts(t *testing.T) {
 		t.Errorf("Expected panic, but got error: %v", err)
 	}
 }
+
+func TestCircuitWithDeploymentDomain(t *testing.T) {
+	assert := test.NewAssert(t)
+	defer SetDeploymentDomain("")
+
+	// generate data for the testnet domain
+	SetDeploymentDomain("testnet")
+	accounts, assetSum, merkleRoot, merkleRootWithAssetSumHash := GenerateTestData(NUM_ACCOUNTS, 0)
+	assert.NotEqual(MERKLE_ROOT, merkleRoot, "domain should change the merkle root")
+	assert.NotEqual(MERKLE_ROOT_WITH_ASSET_SUM_HASH, merkleRootWithAssetSumHash, "domain should change the merkle root with asset sum hash")
+
+	testnetWitness := &Circuit{
+		Accounts:                   ConvertGoAccountsToAccounts(accounts),
+		AssetSum:                   ConvertGoBalanceToBalance(assetSum),
+		MerkleRoot:                 merkleRoot,
+		MerkleRootWithAssetSumHash: merkleRootWithAssetSumHash,
+		AccountCount:               NUM_ACCOUNTS,
+		Epoch:                      0,
+		CircuitVersion:             CIRCUIT_VERSION,
+	}
+	legacyWitness := &Circuit{
+		Accounts:                   ConvertGoAccountsToAccounts(GO_ACCOUNTS),
+		AssetSum:                   ConvertGoBalanceToBalance(GO_ASSET_SUM),
+		MerkleRoot:                 MERKLE_ROOT,
+		MerkleRootWithAssetSumHash: MERKLE_ROOT_WITH_ASSET_SUM_HASH,
+		AccountCount:               NUM_ACCOUNTS,
+		Epoch:                      0,
+		CircuitVersion:             CIRCUIT_VERSION,
+	}
+
+	// the testnet circuit only accepts testnet data
+	assert.NoError(test.IsSolved(BASE_CIRCUIT, testnetWitness, ecc.BN254.ScalarField()))
+	assert.Error(test.IsSolved(BASE_CIRCUIT, legacyWitness, ecc.BN254.ScalarField()))
+
+	// a production circuit does not accept testnet data
+	SetDeploymentDomain("production")
+	assert.Error(test.IsSolved(BASE_CIRCUIT, testnetWitness, ecc.BN254.ScalarField()))
+
+	// the empty domain restores the original hashes
+	SetDeploymentDomain("")
+	assert.Nil(GetDeploymentDomainElement())
+	assert.Equal(MERKLE_ROOT, GoComputeMerkleRootFromAccounts(GO_ACCOUNTS))
+	assert.NoError(test.IsSolved(BASE_CIRCUIT, legacyWitness, ecc.BN254.ScalarField()))
+}
+
+func TestCircuitWithProductionProfile(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// production-shaped data (empty accounts, sparse assets, large balances and hashed user IDs) is proven like any data
+	accounts, assetSum, merkleRoot, merkleRootWithAssetSumHash := GenerateTestDataWithProfile(NUM_ACCOUNTS, 0, PRODUCTION_TEST_DATA_PROFILE)
+	witness := &Circuit{
+		Accounts:                   ConvertGoAccountsToAccounts(accounts),
+		AssetSum:                   ConvertGoBalanceToBalance(assetSum),
+		MerkleRoot:                 merkleRoot,
+		MerkleRootWithAssetSumHash: merkleRootWithAssetSumHash,
+		AccountCount:               NUM_ACCOUNTS,
+		Epoch:                      0,
+		CircuitVersion:             CIRCUIT_VERSION,
+	}
+	assert.NoError(test.IsSolved(BASE_CIRCUIT, witness, ecc.BN254.ScalarField()))
+}
+
+func TestCircuitWithPoseidon2(t *testing.T) {
+	assert := test.NewAssert(t)
+	defer SetDeploymentDomain("")
+	defer SetHashFunction(HASH_MIMC)
+
+	// generate data with poseidon2, with and without a deployment domain
+	assert.NoError(SetHashFunction(HASH_POSEIDON2))
+	accounts, assetSum, merkleRoot, merkleRootWithAssetSumHash := GenerateTestData(NUM_ACCOUNTS, 0)
+	assert.NotEqual(MERKLE_ROOT, merkleRoot, "hash function should change the merkle root")
+	poseidon2Witness := &Circuit{
+		Accounts:                   ConvertGoAccountsToAccounts(accounts),
+		AssetSum:                   ConvertGoBalanceToBalance(assetSum),
+		MerkleRoot:                 merkleRoot,
+		MerkleRootWithAssetSumHash: merkleRootWithAssetSumHash,
+		AccountCount:               NUM_ACCOUNTS,
+		Epoch:                      0,
+		CircuitVersion:             CIRCUIT_VERSION,
+	}
+	SetDeploymentDomain("testnet")
+	accounts, assetSum, merkleRoot, merkleRootWithAssetSumHash = GenerateTestData(NUM_ACCOUNTS, 0)
+	testnetWitness := &Circuit{
+		Accounts:                   ConvertGoAccountsToAccounts(accounts),
+		AssetSum:                   ConvertGoBalanceToBalance(assetSum),
+		MerkleRoot:                 merkleRoot,
+		MerkleRootWithAssetSumHash: merkleRootWithAssetSumHash,
+		AccountCount:               NUM_ACCOUNTS,
+		Epoch:                      0,
+		CircuitVersion:             CIRCUIT_VERSION,
+	}
+	legacyWitness := &Circuit{
+		Accounts:                   ConvertGoAccountsToAccounts(GO_ACCOUNTS),
+		AssetSum:                   ConvertGoBalanceToBalance(GO_ASSET_SUM),
+		MerkleRoot:                 MERKLE_ROOT,
+		MerkleRootWithAssetSumHash: MERKLE_ROOT_WITH_ASSET_SUM_HASH,
+		AccountCount:               NUM_ACCOUNTS,
+		Epoch:                      0,
+		CircuitVersion:             CIRCUIT_VERSION,
+	}
+
+	// the poseidon2 circuit is consistent with the Go hashes, and does not accept MiMC data
+	assert.NoError(test.IsSolved(BASE_CIRCUIT, testnetWitness, ecc.BN254.ScalarField()))
+	SetDeploymentDomain("")
+	assert.NoError(test.IsSolved(BASE_CIRCUIT, poseidon2Witness, ecc.BN254.ScalarField()))
+	assert.Error(test.IsSolved(BASE_CIRCUIT, legacyWitness, ecc.BN254.ScalarField()))
+
+	// the poseidon2 circuit has fewer constraints than the MiMC circuit
+	poseidon2Circuit, err := frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, initBaseCircuit(NUM_ACCOUNTS))
+	assert.NoError(err)
+	assert.NoError(SetHashFunction(HASH_MIMC))
+	mimcCircuit, err := frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, initBaseCircuit(NUM_ACCOUNTS))
+	assert.NoError(err)
+	assert.Less(poseidon2Circuit.GetNbConstraints(), mimcCircuit.GetNbConstraints())
+
+	// the MiMC circuit does not accept poseidon2 data
+	assert.Error(test.IsSolved(BASE_CIRCUIT, poseidon2Witness, ecc.BN254.ScalarField()))
+}
+
+func TestSetHashFunction(t *testing.T) {
+	assert := test.NewAssert(t)
+	defer SetHashFunction(HASH_MIMC)
+
+	assert.Error(SetHashFunction("sha256"))
+	assert.Equal(HASH_MIMC, GetHashFunction(), "an unknown hash function should not change the hash function")
+	assert.NoError(SetHashFunction(HASH_POSEIDON2))
+	assert.Equal(HASH_POSEIDON2, GetHashFunction())
+
+	// the empty hash function is MiMC, the hash function of proofs that do not record one
+	assert.NoError(SetHashFunction(""))
+	assert.Equal(HASH_MIMC, GetHashFunction())
+}
+
+func TestCircuitWithHashVersion(t *testing.T) {
+	assert := test.NewAssert(t)
+	defer SetHashVersion(HASH_VERSION_ROOT_TAGGED)
+
+	// untagged hashes change every account and node hash, and the circuit is consistent with the Go hashes of its version
+	taggedLeaf := GoComputeMiMCHashForAccount(GO_ACCOUNTS[0])
+	assert.NoError(SetHashVersion(HASH_VERSION_UNTAGGED))
+	accounts, assetSum, merkleRoot, merkleRootWithAssetSumHash := GenerateTestData(NUM_ACCOUNTS, 0)
+	assert.NotEqual(MERKLE_ROOT, merkleRoot, "hash version should change the merkle root")
+	assert.NotEqual(taggedLeaf, GoComputeMiMCHashForAccount(accounts[0]), "hash version should change the account hash")
+	untaggedWitness := &Circuit{
+		Accounts:                   ConvertGoAccountsToAccounts(accounts),
+		AssetSum:                   ConvertGoBalanceToBalance(assetSum),
+		MerkleRoot:                 merkleRoot,
+		MerkleRootWithAssetSumHash: merkleRootWithAssetSumHash,
+		AccountCount:               NUM_ACCOUNTS,
+		Epoch:                      0,
+		CircuitVersion:             CIRCUIT_VERSION,
+	}
+	taggedWitness := &Circuit{
+		Accounts:                   ConvertGoAccountsToAccounts(GO_ACCOUNTS),
+		AssetSum:                   ConvertGoBalanceToBalance(GO_ASSET_SUM),
+		MerkleRoot:                 MERKLE_ROOT,
+		MerkleRootWithAssetSumHash: MERKLE_ROOT_WITH_ASSET_SUM_HASH,
+		AccountCount:               NUM_ACCOUNTS,
+		Epoch:                      0,
+		CircuitVersion:             CIRCUIT_VERSION,
+	}
+	assert.NoError(test.IsSolved(BASE_CIRCUIT, untaggedWitness, ecc.BN254.ScalarField()))
+	assert.Error(test.IsSolved(BASE_CIRCUIT, taggedWitness, ecc.BN254.ScalarField()))
+
+	// the tagged circuit does not accept untagged data
+	assert.NoError(SetHashVersion(HASH_VERSION_ROOT_TAGGED))
+	assert.NoError(test.IsSolved(BASE_CIRCUIT, taggedWitness, ecc.BN254.ScalarField()))
+	assert.Error(test.IsSolved(BASE_CIRCUIT, untaggedWitness, ecc.BN254.ScalarField()))
+
+	// HASH_VERSION_TAGGED hashes accounts and nodes alike, but binds the merkle root to the asset sum like an account
+	assert.NoError(SetHashVersion(HASH_VERSION_TAGGED))
+	assert.Equal(taggedLeaf, GoComputeMiMCHashForAccount(GO_ACCOUNTS[0]))
+	assert.Equal(MERKLE_ROOT, GoComputeMerkleRootFromAccounts(GO_ACCOUNTS))
+	assert.Error(test.IsSolved(BASE_CIRCUIT, taggedWitness, ecc.BN254.ScalarField()))
+	leafTaggedWitness := *taggedWitness
+	leafTaggedWitness.MerkleRootWithAssetSumHash = GoComputeRootWithAssetSumHash(MERKLE_ROOT, GO_ASSET_SUM, 0)
+	assert.NotEqual(MERKLE_ROOT_WITH_ASSET_SUM_HASH, leafTaggedWitness.MerkleRootWithAssetSumHash)
+	assert.NoError(test.IsSolved(BASE_CIRCUIT, &leafTaggedWitness, ecc.BN254.ScalarField()))
+
+	// a leaf and a node of the same children no longer hash alike
+	left, right := padToModBytes(big.NewInt(1)), padToModBytes(big.NewInt(2))
+	node, err := GoComputeHashOfTwoNodes(NewGoHasher(GetHashFunction()), left, right, "left", "right")
+	assert.NoError(err)
+	hasher := NewGoHasher(GetHashFunction())
+	_, err = hasher.Write(append(append([]byte{}, left...), right...))
+	assert.NoError(err)
+	assert.NotEqual(hasher.Sum(nil), node)
+}
+
+func TestSetHashVersion(t *testing.T) {
+	assert := test.NewAssert(t)
+	defer SetHashVersion(HASH_VERSION_ROOT_TAGGED)
+
+	assert.Equal(HASH_VERSION_ROOT_TAGGED, GetHashVersion(), "new proofs should be root tagged by default")
+	assert.Error(SetHashVersion(4))
+	assert.Equal(HASH_VERSION_ROOT_TAGGED, GetHashVersion(), "an unknown hash version should not change the hash version")
+
+	// hash version 0 is untagged, the hash version of proofs that do not record one
+	assert.NoError(SetHashVersion(0))
+	assert.Equal(HASH_VERSION_UNTAGGED, GetHashVersion())
+}
+
+func TestCircuitWithSHA256Leaves(t *testing.T) {
+	assert := test.NewAssert(t)
+	defer SetAssetRegistry(DefaultAssetRegistry())
+	defer SetLeafHashMode(LEAF_HASH_NATIVE)
+
+	// SHA-256 leaves are expensive, so use two assets and a few accounts
+	assert.NoError(SetAssetRegistry(AssetRegistry{Assets: []Asset{{Symbol: "BTC", Decimals: 8}, {Symbol: "ETH", Decimals: 18}}}))
+	const count = 4
+	accounts, assetSum, _, _ := GenerateTestData(count, 0)
+	accounts[0].Salt = NewSalt()
+	nativeRoot := GoComputeMerkleRootFromAccounts(accounts)
+	assert.NoError(SetLeafHashMode(LEAF_HASH_SHA256))
+
+	// the leaf is SHA-256 of the 32 byte values, reduced modulo the scalar field, as any SHA-256 tool computes it
+	preimage := append(padToModBytes(big.NewInt(LEAF_HASH_TAG)), padToModBytes(new(big.Int).SetBytes(accounts[0].WalletId))...)
+	preimage = append(preimage, accounts[0].Salt...)
+	preimage = append(preimage, padToModBytes(accounts[0].Balance[0])...)
+	preimage = append(preimage, padToModBytes(accounts[0].Balance[1])...)
+	digest := sha256.Sum256(preimage)
+	expectedLeaf := padToModBytes(new(big.Int).Mod(new(big.Int).SetBytes(digest[:]), ecc.BN254.ScalarField()))
+	assert.Equal(expectedLeaf, []byte(GoComputeMiMCHashForAccount(accounts[0])))
+
+	// the circuit is consistent with the Go leaves, and does not accept native leaves
+	merkleRoot := GoComputeMerkleRootFromAccounts(accounts)
+	assert.NotEqual(nativeRoot, merkleRoot, "leaf hash mode should change the merkle root")
+	witness := &Circuit{
+		Accounts:                   ConvertGoAccountsToAccounts(accounts),
+		AssetSum:                   ConvertGoBalanceToBalance(assetSum),
+		MerkleRoot:                 merkleRoot,
+		MerkleRootWithAssetSumHash: GoComputeRootWithAssetSumHash(merkleRoot, assetSum, 0),
+		AccountCount:               count,
+		Epoch:                      0,
+		CircuitVersion:             CIRCUIT_VERSION,
+	}
+	assert.NoError(test.IsSolved(initBaseCircuit(count), witness, ecc.BN254.ScalarField()))
+	assert.NoError(SetLeafHashMode(LEAF_HASH_NATIVE))
+	nativeWitness := *witness
+	nativeWitness.MerkleRoot = nativeRoot
+	nativeWitness.MerkleRootWithAssetSumHash = GoComputeRootWithAssetSumHash(nativeRoot, assetSum, 0)
+	assert.NoError(SetLeafHashMode(LEAF_HASH_SHA256))
+	assert.Error(test.IsSolved(initBaseCircuit(count), &nativeWitness, ecc.BN254.ScalarField()))
+}
+
+func TestSetLeafHashMode(t *testing.T) {
+	assert := test.NewAssert(t)
+	defer SetLeafHashMode(LEAF_HASH_NATIVE)
+
+	assert.Equal(LEAF_HASH_NATIVE, GetLeafHashMode(), "leaves should use the hash function by default")
+	assert.Error(SetLeafHashMode("sha3"))
+	assert.Equal(LEAF_HASH_NATIVE, GetLeafHashMode(), "an unknown leaf hash mode should not change the leaf hash mode")
+	assert.NoError(SetLeafHashMode(LEAF_HASH_SHA256))
+	assert.Equal(LEAF_HASH_SHA256, GetLeafHashMode())
+
+	// the empty mode is native, the mode of proofs that do not record one
+	assert.NoError(SetLeafHashMode(""))
+	assert.Equal(LEAF_HASH_NATIVE, GetLeafHashMode())
+}
+
+func TestCircuitWithTreeDepth(t *testing.T) {
+	assert := test.NewAssert(t)
+	defer SetConfig(DefaultConfig())
+
+	// generate data for trees of depth 4 (up to 16 accounts)
+	assert.NoError(SetConfig(Config{TreeDepth: 4}))
+	assert.Equal(16, GetAccountsPerBatch())
+	accounts, assetSum, merkleRoot, merkleRootWithAssetSumHash := GenerateTestData(NUM_ACCOUNTS, 0)
+	assert.Equal(4+1, len(GoComputeMerkleTreeNodesFromAccounts(accounts)))
+	assert.NotEqual(MERKLE_ROOT, merkleRoot, "tree depth should change the merkle root")
+	assert.NoError(SetConfig(DefaultConfig()))
+
+	shallowWitness := &Circuit{
+		Accounts:                   ConvertGoAccountsToAccounts(accounts),
+		AssetSum:                   ConvertGoBalanceToBalance(assetSum),
+		MerkleRoot:                 merkleRoot,
+		MerkleRootWithAssetSumHash: merkleRootWithAssetSumHash,
+		AccountCount:               NUM_ACCOUNTS,
+		Epoch:                      0,
+		CircuitVersion:             CIRCUIT_VERSION,
+	}
+	legacyWitness := &Circuit{
+		Accounts:                   ConvertGoAccountsToAccounts(GO_ACCOUNTS),
+		AssetSum:                   ConvertGoBalanceToBalance(GO_ASSET_SUM),
+		MerkleRoot:                 MERKLE_ROOT,
+		MerkleRootWithAssetSumHash: MERKLE_ROOT_WITH_ASSET_SUM_HASH,
+		AccountCount:               NUM_ACCOUNTS,
+		Epoch:                      0,
+		CircuitVersion:             CIRCUIT_VERSION,
+	}
+
+	// the configuration of the circuit takes precedence over the configured one
+	shallowCircuit := initBaseCircuit(NUM_ACCOUNTS)
+	shallowCircuit.Config = Config{TreeDepth: 4}
+	assert.NoError(test.IsSolved(shallowCircuit, shallowWitness, ecc.BN254.ScalarField()))
+	assert.Error(test.IsSolved(shallowCircuit, legacyWitness, ecc.BN254.ScalarField()))
+	assert.Error(test.IsSolved(BASE_CIRCUIT, shallowWitness, ecc.BN254.ScalarField()))
+
+	// a tree of depth 3 can't hold the accounts
+	tooShallowCircuit := initBaseCircuit(NUM_ACCOUNTS)
+	tooShallowCircuit.Config = Config{TreeDepth: 3}
+	assert.Error(test.IsSolved(tooShallowCircuit, shallowWitness, ecc.BN254.ScalarField()))
+}
+
+func TestCircuitWithPaddedBatches(t *testing.T) {
+	assert := test.NewAssert(t)
+	defer SetConfig(DefaultConfig())
+
+	// a padded circuit of 4 accounts proves 3 accounts and a zero account with the merkle root of the 3 accounts
+	assert.NoError(SetConfig(Config{TreeDepth: 2}))
+	accounts, assetSum, merkleRoot, merkleRootWithAssetSumHash := GenerateTestData(3, 0)
+	fullAccounts, fullAssetSum, fullMerkleRoot, fullMerkleRootWithAssetSumHash := GenerateTestData(4, 0)
+	assert.NoError(SetConfig(DefaultConfig()))
+	paddedCircuit := initBaseCircuit(4)
+	paddedCircuit.Config = Config{TreeDepth: 2, PaddedBatches: true}
+	newWitness := func(accountCount int) *Circuit {
+		return &Circuit{
+			Accounts:                   append(ConvertGoAccountsToAccounts(accounts), Account{WalletId: 0, Balance: ConstructBalance(), Salt: 0}),
+			AssetSum:                   ConvertGoBalanceToBalance(assetSum),
+			MerkleRoot:                 merkleRoot,
+			MerkleRootWithAssetSumHash: merkleRootWithAssetSumHash,
+			AccountCount:               accountCount,
+			Epoch:                      0,
+			CircuitVersion:             CIRCUIT_VERSION,
+		}
+	}
+	assert.NoError(test.IsSolved(paddedCircuit, newWitness(3), ecc.BN254.ScalarField()))
+	unpaddedCircuit := initBaseCircuit(4)
+	unpaddedCircuit.Config = Config{TreeDepth: 2}
+	assert.Error(test.IsSolved(unpaddedCircuit, newWitness(3), ecc.BN254.ScalarField()))
+
+	// the account count must be that of the accounts, between 1 and the size of the circuit
+	for _, accountCount := range []int{0, 2, 4, 5} {
+		assert.Error(test.IsSolved(paddedCircuit, newWitness(accountCount), ecc.BN254.ScalarField()), accountCount)
+	}
+
+	// padding has no balance
+	withBalance := newWitness(3)
+	withBalance.Accounts[3].Balance = ConstructBalance(1)
+	paddedAssetSum := GoBalance{new(big.Int).Add(assetSum[0], big.NewInt(1))}
+	paddedAssetSum = append(paddedAssetSum, assetSum[1:]...)
+	withBalance.AssetSum = ConvertGoBalanceToBalance(paddedAssetSum)
+	withBalance.MerkleRootWithAssetSumHash = GoComputeRootWithAssetSumHash(merkleRoot, paddedAssetSum, 0)
+	assert.Error(test.IsSolved(paddedCircuit, withBalance, ecc.BN254.ScalarField()))
+
+	// full batches are proven like with an unpadded circuit
+	fullWitness := &Circuit{
+		Accounts:                   ConvertGoAccountsToAccounts(fullAccounts),
+		AssetSum:                   ConvertGoBalanceToBalance(fullAssetSum),
+		MerkleRoot:                 fullMerkleRoot,
+		MerkleRootWithAssetSumHash: fullMerkleRootWithAssetSumHash,
+		AccountCount:               4,
+		Epoch:                      0,
+		CircuitVersion:             CIRCUIT_VERSION,
+	}
+	assert.NoError(test.IsSolved(paddedCircuit, fullWitness, ecc.BN254.ScalarField()))
+	assert.NoError(test.IsSolved(unpaddedCircuit, fullWitness, ecc.BN254.ScalarField()))
+}
+
+func TestCircuitWithBalanceBits(t *testing.T) {
+	assert := test.NewAssert(t)
+	defer SetAssetRegistry(DefaultAssetRegistry())
+
+	// test balances are below 2^14, but their sums are not
+	witness := &Circuit{
+		Accounts:                   ConvertGoAccountsToAccounts(GO_ACCOUNTS),
+		AssetSum:                   ConvertGoBalanceToBalance(GO_ASSET_SUM),
+		MerkleRoot:                 MERKLE_ROOT,
+		MerkleRootWithAssetSumHash: MERKLE_ROOT_WITH_ASSET_SUM_HASH,
+		AccountCount:               NUM_ACCOUNTS,
+		Epoch:                      0,
+		CircuitVersion:             CIRCUIT_VERSION,
+	}
+	assert.False(GO_ASSET_SUM.IsWithinBits(14))
+	withBits := func(config Config) *Circuit {
+		c := initBaseCircuit(NUM_ACCOUNTS)
+		c.Config = config
+		return c
+	}
+	assert.NoError(test.IsSolved(withBits(Config{TreeDepth: TREE_DEPTH, BalanceBits: 14, AssetSumBits: 32}), witness, ecc.BN254.ScalarField()))
+	assert.Error(test.IsSolved(withBits(Config{TreeDepth: TREE_DEPTH, BalanceBits: 14}), witness, ecc.BN254.ScalarField()))
+	assert.Error(test.IsSolved(withBits(Config{TreeDepth: TREE_DEPTH, BalanceBits: 8, AssetSumBits: 32}), witness, ecc.BN254.ScalarField()))
+
+	// plain range checks check the same ranges
+	assert.NoError(test.IsSolved(withBits(Config{TreeDepth: TREE_DEPTH, BalanceBits: 14, AssetSumBits: 32, PlainRangeChecks: true}), witness, ecc.BN254.ScalarField()))
+	assert.Error(test.IsSolved(withBits(Config{TreeDepth: TREE_DEPTH, BalanceBits: 14, PlainRangeChecks: true}), witness, ecc.BN254.ScalarField()))
+	assert.Error(test.IsSolved(withBits(Config{TreeDepth: TREE_DEPTH, BalanceBits: 8, AssetSumBits: 32, PlainRangeChecks: true}), witness, ecc.BN254.ScalarField()))
+
+	// an asset with its own balance bits is range checked to them at every level
+	registry := DefaultAssetRegistry()
+	registry.Assets[0].BalanceBits = 8
+	assert.NoError(SetAssetRegistry(registry))
+	assert.NotEqual(DefaultAssetRegistry().Hash(), GetAssetRegistry().Hash(), "balance bits should change the registry hash")
+	assert.Equal(8, GetAssetBalanceBits(BALANCE_BITS)[0])
+	assert.Equal(BALANCE_BITS, GetAssetBalanceBits(BALANCE_BITS)[1])
+	assert.Error(test.IsSolved(BASE_CIRCUIT, witness, ecc.BN254.ScalarField()))
+	registry.Assets[0].BalanceBits = MAX_BALANCE_BITS + 1
+	assert.Error(SetAssetRegistry(registry))
+}
+
+func TestSetConfig(t *testing.T) {
+	assert := test.NewAssert(t)
+	defer SetConfig(DefaultConfig())
+
+	assert.Equal(TREE_DEPTH, GetTreeDepth())
+	assert.Equal(ACCOUNTS_PER_BATCH, GetAccountsPerBatch())
+	assert.Error(SetConfig(Config{TreeDepth: 0}))
+	assert.Error(SetConfig(Config{TreeDepth: MAX_TREE_DEPTH + 1}))
+	assert.Equal(TREE_DEPTH, GetTreeDepth(), "an invalid configuration should not change the configuration")
+	assert.NoError(SetConfig(Config{TreeDepth: 12}))
+	assert.Equal(1<<12, GetAccountsPerBatch())
+
+	// balances and asset sums are range checked to BALANCE_BITS by default, and upper level balances to the asset sum bits
+	assert.Equal(BALANCE_BITS, GetConfig().GetBalanceBits())
+	assert.Equal(BALANCE_BITS, GetConfig().GetAssetSumBits())
+	assert.Equal(Config{TreeDepth: 12, UpperLevel: true}, GetConfig().ForUpperLevel())
+	assert.NoError(SetConfig(Config{TreeDepth: 12, BalanceBits: 64, AssetSumBits: 96}))
+	assert.Equal(Config{TreeDepth: 12, BalanceBits: 96, AssetSumBits: 96, UpperLevel: true}, GetConfig().ForUpperLevel())
+	assert.Error(SetConfig(Config{TreeDepth: 12, BalanceBits: -1}))
+	assert.Error(SetConfig(Config{TreeDepth: 12, BalanceBits: MAX_BALANCE_BITS + 1}))
+	assert.Error(SetConfig(Config{TreeDepth: 12, BalanceBits: 64, AssetSumBits: 32}))
+	assert.Error(SetConfig(Config{TreeDepth: 12, AssetSumBits: MAX_BALANCE_BITS + 1}))
+
+	// aggregation circuits are limited to small tree depths
+	assert.NoError(Config{TreeDepth: MAX_AGGREGATION_TREE_DEPTH}.ValidateAggregation())
+	assert.Error(Config{TreeDepth: MAX_AGGREGATION_TREE_DEPTH + 1}.ValidateAggregation())
+	assert.Error(DefaultConfig().ValidateAggregation())
+	assert.Error(Config{TreeDepth: 0}.ValidateAggregation())
+}
+
+func TestSetConfigConcurrently(t *testing.T) {
+	assert := test.NewAssert(t)
+	defer SetConfig(DefaultConfig())
+	defer SetAssetRegistry(DefaultAssetRegistry())
+	defer SetHashWorkers(GetHashWorkers())
+
+	// the settings can be changed while they are read, which see either the old or the new ones
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			SetDeploymentDomain(strconv.Itoa(i % 2))
+			assert.NoError(SetHashWorkers(1 + i%4))
+			assert.NoError(SetAssetSubset([]int{0, 1}))
+			assert.NoError(SetAssetRegistry(DefaultAssetRegistry()))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			assert.Contains([]string{"", "0", "1"}, GetDeploymentDomain())
+			assert.Contains([]int{2, len(DefaultAssetRegistry().Assets)}, GetNumberOfAssets())
+			assert.Contains([]int{1, 2, 3, 4, runtime.NumCPU()}, GetHashWorkers())
+		}
+	}()
+	wg.Wait()
+}
+
+func TestCircuitWithSaltedAccounts(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// salt every other account, unsalted accounts keep their leaves
+	accounts := make([]GoAccount, len(GO_ACCOUNTS))
+	copy(accounts, GO_ACCOUNTS)
+	for i := 0; i < len(accounts); i += 2 {
+		accounts[i].Salt = NewSalt()
+		assert.True(accounts[i].IsSalted())
+		assert.NotEqual(GoComputeMiMCHashForAccount(GO_ACCOUNTS[i]), GoComputeMiMCHashForAccount(accounts[i]))
+	}
+	assert.Equal(GoComputeMiMCHashForAccount(GO_ACCOUNTS[1]), GoComputeMiMCHashForAccount(accounts[1]))
+	zeroSalt := GO_ACCOUNTS[1]
+	zeroSalt.Salt = make([]byte, ModBytes)
+	assert.False(zeroSalt.IsSalted())
+	assert.Equal(GoComputeMiMCHashForAccount(GO_ACCOUNTS[1]), GoComputeMiMCHashForAccount(zeroSalt))
+
+	merkleRoot := GoComputeMerkleRootFromAccounts(accounts)
+	assert.NotEqual(MERKLE_ROOT, merkleRoot)
+	saltedWitness := &Circuit{
+		Accounts:                   ConvertGoAccountsToAccounts(accounts),
+		AssetSum:                   ConvertGoBalanceToBalance(GO_ASSET_SUM),
+		MerkleRoot:                 merkleRoot,
+		MerkleRootWithAssetSumHash: GoComputeRootWithAssetSumHash(merkleRoot, GO_ASSET_SUM, 0),
+		AccountCount:               NUM_ACCOUNTS,
+		Epoch:                      0,
+		CircuitVersion:             CIRCUIT_VERSION,
+	}
+	assert.NoError(test.IsSolved(BASE_CIRCUIT, saltedWitness, ecc.BN254.ScalarField()))
+
+	// the leaves can't be recomputed without the salts
+	unsaltedWitness := &Circuit{
+		Accounts:                   ConvertGoAccountsToAccounts(GO_ACCOUNTS),
+		AssetSum:                   ConvertGoBalanceToBalance(GO_ASSET_SUM),
+		MerkleRoot:                 merkleRoot,
+		MerkleRootWithAssetSumHash: GoComputeRootWithAssetSumHash(merkleRoot, GO_ASSET_SUM, 0),
+		AccountCount:               NUM_ACCOUNTS,
+		Epoch:                      0,
+		CircuitVersion:             CIRCUIT_VERSION,
+	}
+	assert.Error(test.IsSolved(BASE_CIRCUIT, unsaltedWitness, ecc.BN254.ScalarField()))
+
+	// salts survive the conversion to raw accounts, and unsalted accounts have no salt
+	rawAccounts := ConvertGoAccountsToRawGoAccounts(accounts)
+	assert.NotEqual("", rawAccounts[0].Salt)
+	assert.Equal("", rawAccounts[1].Salt)
+	assert.Equal(merkleRoot, GoComputeMerkleRootFromAccounts(ConvertRawGoAccountsToGoAccounts(rawAccounts)))
+}
+
+func TestCircuitBindsEpoch(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	epochWitness := func(epoch uint64, hashEpoch uint64) *Circuit {
+		return &Circuit{
+			Accounts:                   ConvertGoAccountsToAccounts(GO_ACCOUNTS),
+			AssetSum:                   ConvertGoBalanceToBalance(GO_ASSET_SUM),
+			MerkleRoot:                 MERKLE_ROOT,
+			MerkleRootWithAssetSumHash: GoComputeRootWithAssetSumHash(MERKLE_ROOT, GO_ASSET_SUM, hashEpoch),
+			AccountCount:               NUM_ACCOUNTS,
+			Epoch:                      epoch,
+			CircuitVersion:             CIRCUIT_VERSION,
+		}
+	}
+	assert.NoError(test.IsSolved(BASE_CIRCUIT, epochWitness(20240131, 20240131), ecc.BN254.ScalarField()))
+
+	// the merkle root with asset sum hash of one epoch does not prove another epoch
+	assert.Error(test.IsSolved(BASE_CIRCUIT, epochWitness(20240131, 20240229), ecc.BN254.ScalarField()))
+	assert.Error(test.IsSolved(BASE_CIRCUIT, epochWitness(20240131, 0), ecc.BN254.ScalarField()))
+	assert.Error(test.IsSolved(BASE_CIRCUIT, epochWitness(0, 20240131), ecc.BN254.ScalarField()))
+}
+
+// proveLowerLevelProof proves the accounts with a Circuit bound to the epoch, the way the proofs an AggregationCircuit
+// verifies are proven, and returns the proof, its verification key, and the pseudo-account of the proof.
+func proveLowerLevelProof(t *testing.T, accounts []GoAccount, epoch uint64) (groth16.Proof, groth16.VerifyingKey, GoAccount) {
+	field := ecc.BN254.ScalarField()
+	ccs, err := frontend.Compile(field, r1cs.NewBuilder, initBaseCircuit(len(accounts)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, vk, err := groth16.Setup(ccs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assetSum := SumGoAccountBalances(accounts)
+	merkleRoot := GoComputeMerkleRootFromAccounts(accounts)
+	witness, err := frontend.NewWitness(&Circuit{
+		Accounts:                   ConvertGoAccountsToAccounts(accounts),
+		AssetSum:                   ConvertGoBalanceToBalance(assetSum),
+		MerkleRoot:                 merkleRoot,
+		MerkleRootWithAssetSumHash: GoComputeRootWithAssetSumHash(merkleRoot, assetSum, epoch),
+		AccountCount:               len(accounts),
+		Epoch:                      epoch,
+		CircuitVersion:             CIRCUIT_VERSION,
+	}, field)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := groth16.Prove(ccs, pk, witness, stdgroth16.GetNativeProverOptions(field, field))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return proof, vk, GoAccount{WalletId: merkleRoot, Balance: assetSum, Salt: EpochSalt(epoch)}
+}
+
+func TestAggregationCircuit(t *testing.T) {
+	assert := test.NewAssert(t)
+	accounts := GO_ACCOUNTS[:3]
+	defer SetConfig(DefaultConfig())
+	assert.NoError(SetConfig(Config{TreeDepth: 1}))
+
+	// prove two lower level proofs, and aggregate them
+	const epoch = 20240131
+	lowerAccountCounts := []int{2, 1}
+	lowerProofs := make([]LowerProof, 2)
+	lowerVerifyingKeys := make([]LowerVerifyingKey, 2)
+	pseudoAccounts := make([]GoAccount, 2)
+	for i, batch := range [][]GoAccount{accounts[:2], accounts[2:]} {
+		proof, vk, pseudoAccount := proveLowerLevelProof(t, batch, epoch)
+		var err error
+		lowerProofs[i], err = ConvertGroth16ProofToLowerProof(proof)
+		assert.NoError(err)
+		lowerVerifyingKeys[i], err = ConvertGroth16VerifyingKeyToLowerVerifyingKey(vk)
+		assert.NoError(err)
+		pseudoAccounts[i] = pseudoAccount
+	}
+
+	// the aggregation circuit is an upper level circuit, whose leaves are the hashes of the pseudo-accounts
+	upperLevelCircuit := *initBaseCircuit(2)
+	upperLevelCircuit.Config = GetConfig().ForUpperLevel()
+	placeholder := &AggregationCircuit{
+		Circuit:            upperLevelCircuit,
+		LowerProofs:        lowerProofs,
+		LowerAccountCounts: make([]frontend.Variable, 2),
+		LowerVerifyingKeys: lowerVerifyingKeys,
+	}
+	aggregationWitness := func(pseudoAccounts []GoAccount, lowerAccountCounts []int) *AggregationCircuit {
+		assetSum := SumGoAccountBalances(pseudoAccounts)
+		merkleRoot := GoComputeMerkleTreeNodesFromPseudoAccounts(pseudoAccounts)[0][0]
+		counts := make([]frontend.Variable, len(lowerAccountCounts))
+		for i, count := range lowerAccountCounts {
+			counts[i] = count
+		}
+		return &AggregationCircuit{
+			Circuit: Circuit{
+				Accounts:                   ConvertGoAccountsToAccounts(pseudoAccounts),
+				AssetSum:                   ConvertGoBalanceToBalance(assetSum),
+				MerkleRoot:                 merkleRoot,
+				MerkleRootWithAssetSumHash: GoComputeRootWithAssetSumHash(merkleRoot, assetSum, epoch),
+				AccountCount:               len(pseudoAccounts),
+				Epoch:                      epoch,
+				CircuitVersion:             CIRCUIT_VERSION,
+			},
+			LowerProofs:        lowerProofs,
+			LowerAccountCounts: counts,
+			LowerVerifyingKeys: lowerVerifyingKeys,
+		}
+	}
+	assert.NoError(test.IsSolved(placeholder, aggregationWitness(pseudoAccounts, lowerAccountCounts), ecc.BN254.ScalarField()))
+
+	// the lower level proofs must prove the pseudo-accounts, with their account counts
+	assert.Error(test.IsSolved(placeholder, aggregationWitness(pseudoAccounts, []int{1, 2}), ecc.BN254.ScalarField()))
+	inflatedAccounts := append([]GoAccount{}, pseudoAccounts...)
+	inflatedAccounts[1].Balance = SumGoAccountBalances(pseudoAccounts)
+	assert.Error(test.IsSolved(placeholder, aggregationWitness(inflatedAccounts, lowerAccountCounts), ecc.BN254.ScalarField()))
+	swappedAccounts := []GoAccount{pseudoAccounts[1], pseudoAccounts[0]}
+	assert.Error(test.IsSolved(placeholder, aggregationWitness(swappedAccounts, []int{1, 2}), ecc.BN254.ScalarField()))
+}
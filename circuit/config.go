@@ -0,0 +1,262 @@
+package circuit
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// MAX_TREE_DEPTH bounds the configurable tree depth, as the circuit and the Go helpers hash 2^TreeDepth leaves.
+const MAX_TREE_DEPTH = 20
+
+// MAX_BALANCE_BITS bounds the configurable balance bits, so the sum of the 2^MAX_TREE_DEPTH balances of a proof can't
+// wrap around the scalar field (of about 2^253.6).
+const MAX_BALANCE_BITS = 232
+
+// MAX_AGGREGATION_TREE_DEPTH bounds the tree depth of rounds whose upper level proofs verify their lower level proofs
+// in the circuit (see AggregationCircuit). Each lower level proof is verified with an emulated BN254 pairing of over a
+// million constraints, so an aggregation circuit of 2^MAX_AGGREGATION_TREE_DEPTH lower level proofs already has tens of
+// millions of constraints, and the default tree depth would take over a billion.
+const MAX_AGGREGATION_TREE_DEPTH = 4
+
+// Config is the configuration of the circuit that can be chosen at runtime. It is recorded in every proof, so proofs
+// are verified with the configuration they were generated with.
+type Config struct {
+	// TreeDepth is the depth of the Merkle trees, i.e. a proof includes up to 2^TreeDepth accounts (or lower level
+	// proofs).
+	TreeDepth int
+	// BalanceBits is the number of bits the balances of the accounts are range checked to, i.e. to
+	// [0, 2^BalanceBits - 1] (BALANCE_BITS if zero). Assets of the asset registry can have their own (see
+	// Asset.BalanceBits).
+	BalanceBits int
+	// AssetSumBits is the number of bits the asset sum is range checked to (BalanceBits if zero). The asset sum of a
+	// proof is the balance of its pseudo-account at the next level, so upper level proofs range check their balances to
+	// AssetSumBits too (see ForUpperLevel), which lets them aggregate wider sums than the bottom level accounts hold.
+	AssetSumBits int
+	// PlainRangeChecks range checks the balances by decomposing them into bits instead of with a lookup argument,
+	// which takes more constraints but needs no commitment in the circuit, as the keys of an MPC setup ceremony can't
+	// have (see core.InitCeremony). Proofs are the same either way, so it is not recorded in them.
+	PlainRangeChecks bool
+	// PaddedBatches proves every batch with the circuit of AccountsPerBatch accounts, padding the partial ones (e.g. the
+	// last batch of a round) with zero accounts, so they need no setup of their own. The circuit then proves the
+	// accounts past the AccountCount of the proof are padding, whose leaves are zero like those past the accounts of an
+	// unpadded proof, so proofs have the same public inputs either way and it is not recorded in them.
+	PaddedBatches bool
+	// UpperLevel is set in the configuration of upper level proofs (see ForUpperLevel), whose accounts are the
+	// pseudo-accounts of lower level proofs. Their leaves are the hashes binding the merkle roots of the lower level
+	// proofs to their asset sums (see hashPseudoAccount), which are tagged apart from account hashes with
+	// HASH_VERSION_ROOT_TAGGED, so upper level proofs have circuits of their own.
+	UpperLevel bool
+	// HashFunction, HashVersion, AccountBytesVersion, and LeafHashMode are how accounts and nodes are hashed, both in
+	// the circuit and by the Go helpers (see SetHashFunction, SetHashVersion, SetAccountBytesVersion, and
+	// SetLeafHashMode). They are recorded in every proof. Zero values are the defaults, see the getters of Config.
+	HashFunction        HashFunction
+	HashVersion         HashVersion
+	AccountBytesVersion AccountBytesVersion
+	LeafHashMode        LeafHashMode
+	// DeploymentDomain is the domain of the deployment (e.g. "testnet" or "production"), which is mixed into every
+	// account and node hash, both in the circuit and by the Go helpers, so proofs of different deployments are not
+	// interchangeable (see SetDeploymentDomain). The empty domain leaves hashes unchanged.
+	DeploymentDomain string
+	// AssetCount is the number of assets of the balances the Go helpers hash (GetNumberOfAssets() if zero), so proofs
+	// over an asset subset can be verified without configuring it.
+	AssetCount int
+}
+
+// settings are the process-wide settings of the package: the configuration of the circuit (see SetConfig), the asset
+// registry and subset of every balance (see SetAssetRegistry and SetAssetSubset), the encoding of WalletIds (see
+// SetWalletIdEncoding), and the number of hash workers (see SetHashWorkers). They are only read and replaced as a
+// whole, with settingsMutex held, so they are consistent even if they are set while accounts are hashed or circuits
+// are compiled. Their slices are never modified in place, so copies of the settings share them.
+type settings struct {
+	config           Config
+	assetRegistry    AssetRegistry
+	assetSubset      []int
+	walletIdEncoding WalletIdEncoding
+	hashWorkers      int
+}
+
+// currentSettings are the settings of the package, guarded by settingsMutex.
+var currentSettings = settings{
+	config:           DefaultConfig(),
+	assetRegistry:    DefaultAssetRegistry(),
+	walletIdEncoding: WALLET_ID_BASE36,
+	hashWorkers:      runtime.NumCPU(),
+}
+
+var settingsMutex sync.RWMutex
+
+// getSettings returns the settings of the package.
+func getSettings() settings {
+	settingsMutex.RLock()
+	defer settingsMutex.RUnlock()
+	return currentSettings
+}
+
+// updateSettings updates the settings of the package with update, which is given a copy of the settings, unless it
+// returns an error.
+func updateSettings(update func(s *settings) error) error {
+	settingsMutex.Lock()
+	defer settingsMutex.Unlock()
+	updated := currentSettings
+	if err := update(&updated); err != nil {
+		return err
+	}
+	currentSettings = updated
+	return nil
+}
+
+// DefaultConfig returns the default configuration, with a tree depth of TREE_DEPTH.
+func DefaultConfig() Config {
+	return Config{TreeDepth: TREE_DEPTH}
+}
+
+// Validate returns an error if the configuration can't be used to generate or verify proofs.
+func (c Config) Validate() error {
+	if c.TreeDepth < 1 || c.TreeDepth > MAX_TREE_DEPTH {
+		return fmt.Errorf("tree depth must be between 1 and %d, got %d", MAX_TREE_DEPTH, c.TreeDepth)
+	}
+	if c.BalanceBits < 0 || c.BalanceBits > MAX_BALANCE_BITS {
+		return fmt.Errorf("balance bits must be between 1 and %d (or 0 for %d), got %d", MAX_BALANCE_BITS, BALANCE_BITS, c.BalanceBits)
+	}
+	if c.AssetSumBits != 0 && (c.AssetSumBits < c.GetBalanceBits() || c.AssetSumBits > MAX_BALANCE_BITS) {
+		return fmt.Errorf("asset sum bits must be between the balance bits (%d) and %d (or 0 for the balance bits), got %d",
+			c.GetBalanceBits(), MAX_BALANCE_BITS, c.AssetSumBits)
+	}
+	if _, err := ParseHashFunction(string(c.HashFunction)); err != nil {
+		return err
+	}
+	if _, err := ParseHashVersion(int(c.HashVersion)); err != nil {
+		return err
+	}
+	if _, err := ParseAccountBytesVersion(int(c.AccountBytesVersion)); err != nil {
+		return err
+	}
+	if _, err := ParseLeafHashMode(string(c.LeafHashMode)); err != nil {
+		return err
+	}
+	if c.AssetCount < 0 {
+		return fmt.Errorf("asset count must not be negative, got %d", c.AssetCount)
+	}
+	return nil
+}
+
+// ValidateAggregation returns an error if the configuration can't be used to generate proofs with aggregation circuits
+// (see AggregationCircuit), i.e. if it is invalid, or its tree depth exceeds MAX_AGGREGATION_TREE_DEPTH.
+func (c Config) ValidateAggregation() error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	if c.TreeDepth > MAX_AGGREGATION_TREE_DEPTH {
+		return fmt.Errorf("tree depth of aggregation circuits must be at most %d, got %d", MAX_AGGREGATION_TREE_DEPTH, c.TreeDepth)
+	}
+	return nil
+}
+
+// GetBalanceBits returns the number of bits the balances of the accounts are range checked to.
+func (c Config) GetBalanceBits() int {
+	if c.BalanceBits == 0 {
+		return BALANCE_BITS
+	}
+	return c.BalanceBits
+}
+
+// GetAssetSumBits returns the number of bits the asset sum is range checked to.
+func (c Config) GetAssetSumBits() int {
+	if c.AssetSumBits == 0 {
+		return c.GetBalanceBits()
+	}
+	return c.AssetSumBits
+}
+
+// GetHashFunction returns the hash function of account and node hashes (HASH_MIMC if zero).
+func (c Config) GetHashFunction() HashFunction {
+	if c.HashFunction == "" {
+		return HASH_MIMC
+	}
+	return c.HashFunction
+}
+
+// GetHashVersion returns the hash version of account and node hashes (HASH_VERSION_ROOT_TAGGED if zero). Unlike the
+// hash version of a proof, zero is the default rather than HASH_VERSION_UNTAGGED, which must be set explicitly.
+func (c Config) GetHashVersion() HashVersion {
+	if c.HashVersion == 0 {
+		return HASH_VERSION_ROOT_TAGGED
+	}
+	return c.HashVersion
+}
+
+// GetAccountBytesVersion returns the byte layout accounts are hashed from (ACCOUNT_BYTES_VERSION_1 if zero).
+func (c Config) GetAccountBytesVersion() AccountBytesVersion {
+	if c.AccountBytesVersion == 0 {
+		return ACCOUNT_BYTES_VERSION_1
+	}
+	return c.AccountBytesVersion
+}
+
+// GetLeafHashMode returns the leaf hash mode of account hashes (LEAF_HASH_NATIVE if zero).
+func (c Config) GetLeafHashMode() LeafHashMode {
+	if c.LeafHashMode == "" {
+		return LEAF_HASH_NATIVE
+	}
+	return c.LeafHashMode
+}
+
+// GetNumberOfAssets returns the number of assets of the balances the Go helpers hash.
+func (c Config) GetNumberOfAssets() int {
+	if c.AssetCount == 0 {
+		return GetNumberOfAssets()
+	}
+	return c.AssetCount
+}
+
+// ForUpperLevel returns the configuration of the upper level proofs of proofs with this configuration, whose accounts
+// are the pseudo-accounts of lower level proofs (see UpperLevel), and whose balances are asset sums of lower level
+// proofs, so they are range checked to the asset sum bits.
+func (c Config) ForUpperLevel() Config {
+	if c.GetAssetSumBits() != c.GetBalanceBits() {
+		c.BalanceBits = c.GetAssetSumBits()
+	}
+	c.UpperLevel = true
+	return c
+}
+
+// AccountsPerBatch returns the maximum number of accounts (or lower level proofs) of a proof.
+func (c Config) AccountsPerBatch() int {
+	return PowOfTwo(c.TreeDepth)
+}
+
+// SetConfig sets the configuration of the circuit, including how accounts and nodes are hashed and the deployment
+// domain, which it replaces too. It must be set before any proofs are generated, and the configuration is unchanged if
+// it is invalid.
+func SetConfig(c Config) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	return updateSettings(func(s *settings) error {
+		s.config = c
+		return nil
+	})
+}
+
+func GetConfig() Config {
+	return getSettings().config
+}
+
+// updateConfig updates the configuration of the circuit with update, which is given a copy of the configuration.
+func updateConfig(update func(c *Config)) {
+	updateSettings(func(s *settings) error {
+		update(&s.config)
+		return nil
+	})
+}
+
+// GetTreeDepth returns the configured tree depth (TREE_DEPTH by default).
+func GetTreeDepth() int {
+	return GetConfig().TreeDepth
+}
+
+// GetAccountsPerBatch returns the configured maximum number of accounts per batch (ACCOUNTS_PER_BATCH by default).
+func GetAccountsPerBatch() int {
+	return GetConfig().AccountsPerBatch()
+}
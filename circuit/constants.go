@@ -1,23 +1,41 @@
 package circuit
 
 import (
+	"sync"
+
 	"github.com/consensys/gnark-crypto/ecc"
 )
 
 const (
 	// TREE_DEPTH corresponds to a maximum of 1024 leaf nodes per Merkle tree.
-	// (1024 is the maximum number of accounts per batch).
+	// (1024 is the maximum number of accounts per batch). It is the default tree depth, see Config.
 	TREE_DEPTH                              = 10
 	ACCOUNTS_PER_BATCH                      = 1 << TREE_DEPTH
 	INVALID_BALANCE_LENGTH_MESSAGE          = "balance must have the same length as assets"
 	MERKLE_TREE_LEAF_LIMIT_EXCEEDED_MESSAGE = "number of hashes exceeds the maximum number of leaves in the Merkle tree"
+	// BALANCE_BITS is the default number of bits of every balance and asset sum in the circuit, which range checks them
+	// to [0, 2^BALANCE_BITS - 1]. See Config.BalanceBits.
+	BALANCE_BITS = 128
+	// MAX_BASE36_WALLET_ID_LENGTH is the longest (cleaned) WalletId whose base36 value always fits in the BN254 scalar
+	// field. Longer WalletIds are hashed to a field element instead (see convertRawWalletIdToBytes).
+	MAX_BASE36_WALLET_ID_LENGTH = 48
+	// CIRCUIT_VERSION must be incremented whenever the constraints of the circuit change, as verification keys of
+	// different versions are not interchangeable. Version 2 added account salts, version 3 the public account count, version 4
+	// the public epoch, version 5 the tags of account and node hashes (see HASH_VERSION_TAGGED), version 6 the range
+	// check of the asset sum, and version 7 the public circuit version.
+	CIRCUIT_VERSION = 7
+	// LEAF_HASH_TAG and NODE_HASH_TAG are the first elements of account hashes and node hashes with
+	// HASH_VERSION_TAGGED (after the deployment domain, if any), and ROOT_HASH_TAG that of the hashes binding merkle
+	// roots to their asset sums and epochs with HASH_VERSION_ROOT_TAGGED. The hash version is recorded in proofs and
+	// part of the key of compiled circuits, so the tags of a new hash version don't change CIRCUIT_VERSION.
+	LEAF_HASH_TAG = 1
+	NODE_HASH_TAG = 2
+	ROOT_HASH_TAG = 3
 )
 
 // ModBytes is needed to calculate the number of bytes needed to replicate hashing in the circuit.
 var ModBytes = len(ecc.BN254.ScalarField().Bytes())
 
-// AssetSymbols is an array storing symbols for cryptocurrencies (i.e. mapping indices to cryptocurrencies)
-var AssetSymbols = []string{"ALGO", "ARBETH", "AVAXC", "BTC", "BCH", "ADA", "CSPR", "TIA",
-	"ATOM", "DASH", "DOGE", "EOS", "ETH", "ETC", "HBAR", "LTC", "NEAR",
-	"OSMO", "DOT", "POLYGON", "SEI", "SOL", "STX", "XLM", "SUI", "TRX", "XRP",
-	"ZEC", "ZETA", "BLD", "BSC", "TON", "COREDAO", "BERA", "TAO", "APT"}
+// deploymentDomainElements caches the field elements deployment domains are hashed as, by domain (see
+// Config.GetDeploymentDomainElement).
+var deploymentDomainElements sync.Map
@@ -0,0 +1,124 @@
+package circuit
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/test"
+)
+
+// FUZZ_TREE_DEPTH is the tree depth of the Merkle roots compared by FuzzMerkleRootParity, small enough for the
+// circuit to be solved many times.
+const FUZZ_TREE_DEPTH = 3
+
+// accountHashCircuit asserts that hashAccount hashes Account to Hash.
+type accountHashCircuit struct {
+	Account Account
+	Hash    frontend.Variable `gnark:",public"`
+}
+
+func (c *accountHashCircuit) Define(api frontend.API) error {
+	hasher, err := newHasher(api, GetHashFunction())
+	if err != nil {
+		return err
+	}
+	api.AssertIsEqual(hashAccount(api, hasher, c.Account, GetConfig()), c.Hash)
+	return nil
+}
+
+// merkleRootCircuit asserts that computeMerkleRootFromAccounts computes MerkleRoot from Accounts, in a tree of depth
+// FUZZ_TREE_DEPTH.
+type merkleRootCircuit struct {
+	Accounts   []Account
+	MerkleRoot frontend.Variable `gnark:",public"`
+}
+
+func (c *merkleRootCircuit) Define(api frontend.API) error {
+	hasher, err := newHasher(api, GetHashFunction())
+	if err != nil {
+		return err
+	}
+	api.AssertIsEqual(computeMerkleRootFromAccounts(api, hasher, c.Accounts, Config{TreeDepth: FUZZ_TREE_DEPTH}), c.MerkleRoot)
+	return nil
+}
+
+// useFuzzHashFunction sets the hash function selected by the fuzzer until the returned function is called.
+func useFuzzHashFunction(t *testing.T, poseidon2 bool) (restore func()) {
+	if poseidon2 {
+		if err := SetHashFunction(HASH_POSEIDON2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return func() { SetHashFunction(HASH_MIMC) }
+}
+
+// fuzzBalance splits data into a balance of up to 16 bytes per asset (missing bytes are 0).
+func fuzzBalance(data []byte) GoBalance {
+	balance := make(GoBalance, GetNumberOfAssets())
+	for i := range balance {
+		balance[i] = big.NewInt(0)
+		if start := i * 16; start < len(data) {
+			balance[i].SetBytes(data[start:min(start+16, len(data))])
+		}
+	}
+	return balance
+}
+
+// emptyAccounts returns count accounts with zero-valued balances, the shape of the circuits to solve.
+func emptyAccounts(count int) []Account {
+	accounts := make([]Account, count)
+	for i := range accounts {
+		accounts[i].Balance = ConstructBalance()
+	}
+	return accounts
+}
+
+func FuzzAccountHashParity(f *testing.F) {
+	f.Add([]byte("user1"), []byte{}, []byte{1, 2, 3}, false)
+	f.Add([]byte("user2"), []byte{0x5a, 0x17}, make([]byte, 16*GetNumberOfAssets()), false)
+	f.Add([]byte{}, []byte{1}, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, true)
+	f.Fuzz(func(t *testing.T, walletId []byte, salt []byte, balance []byte, poseidon2 bool) {
+		defer useFuzzHashFunction(t, poseidon2)()
+
+		// accounts that can't be hashed (e.g. a WalletId or salt out of the field) are rejected before proving
+		account := GoAccount{WalletId: walletId, Balance: fuzzBalance(balance), Salt: salt}
+		hash, err := GoComputeMiMCHashForAccountWithError(account)
+		if err != nil {
+			t.Skip(err)
+		}
+
+		circuit := &accountHashCircuit{Account: Account{Balance: ConstructBalance()}}
+		witness := &accountHashCircuit{Account: convertGoAccountToAccount(account), Hash: hash}
+		if err := test.IsSolved(circuit, witness, ecc.BN254.ScalarField()); err != nil {
+			t.Fatalf("hash of account %+v differs between Go and the circuit: %v", account, err)
+		}
+	})
+}
+
+func FuzzMerkleRootParity(f *testing.F) {
+	f.Add(int64(0), uint8(1), false, false)
+	f.Add(int64(1), uint8(PowOfTwo(FUZZ_TREE_DEPTH)), true, false)
+	f.Add(int64(2), uint8(5), false, true)
+	f.Fuzz(func(t *testing.T, seed int64, count uint8, salted bool, poseidon2 bool) {
+		defer SetConfig(GetConfig())
+		defer useFuzzHashFunction(t, poseidon2)()
+		if err := SetConfig(Config{TreeDepth: FUZZ_TREE_DEPTH}); err != nil {
+			t.Fatal(err)
+		}
+
+		// generate up to a full tree of accounts, with production-shaped data
+		accounts, _, merkleRoot, _ := GenerateTestDataWithProfile(int(count)%(PowOfTwo(FUZZ_TREE_DEPTH)+1), int(seed), PRODUCTION_TEST_DATA_PROFILE)
+		if salted {
+			SaltAccounts(accounts)
+			merkleRoot = GoComputeMerkleRootFromAccounts(accounts)
+		}
+
+		circuit := &merkleRootCircuit{Accounts: emptyAccounts(len(accounts))}
+		witness := &merkleRootCircuit{Accounts: ConvertGoAccountsToAccounts(accounts), MerkleRoot: merkleRoot}
+		if err := test.IsSolved(circuit, witness, ecc.BN254.ScalarField()); err != nil {
+			t.Fatalf("merkle root of %d accounts differs between Go and the circuit: %v", len(accounts), err)
+		}
+	})
+}
@@ -0,0 +1,352 @@
+package circuit
+
+import (
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/mimc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/poseidon2"
+	"github.com/consensys/gnark-crypto/hash"
+	"github.com/consensys/gnark/frontend"
+	stdhash "github.com/consensys/gnark/std/hash"
+	stdmimc "github.com/consensys/gnark/std/hash/mimc"
+	"github.com/consensys/gnark/std/hash/sha2"
+	"github.com/consensys/gnark/std/math/uints"
+)
+
+// HashFunction identifies the hash function of account and node hashes, both in the circuit and in Go. It is
+// recorded in every proof, so proofs are verified with the hash function they were generated with.
+type HashFunction string
+
+const (
+	// HASH_MIMC is the BN254 MiMC hash (the default, and the hash of proofs that do not record a hash function).
+	HASH_MIMC HashFunction = "mimc"
+	// HASH_POSEIDON2 is the BN254 Poseidon2 hash in the Merkle-Damgard construction of gnark-crypto (width 2, 6 full
+	// rounds, 50 partial rounds), which needs fewer constraints than MiMC.
+	HASH_POSEIDON2 HashFunction = "poseidon2"
+)
+
+// ParseHashFunction returns the hash function with the given name. The empty name is MiMC, the hash function of
+// proofs generated before the hash function was recorded.
+func ParseHashFunction(name string) (HashFunction, error) {
+	switch HashFunction(name) {
+	case "", HASH_MIMC:
+		return HASH_MIMC, nil
+	case HASH_POSEIDON2:
+		return HASH_POSEIDON2, nil
+	}
+	return "", fmt.Errorf("unknown hash function %q (expected %s or %s)", name, HASH_MIMC, HASH_POSEIDON2)
+}
+
+// SetHashFunction sets the hash function of account and node hashes of the configuration (see Config.HashFunction).
+// Like the deployment domain, it must be set before any proofs are generated.
+func SetHashFunction(name HashFunction) error {
+	parsed, err := ParseHashFunction(string(name))
+	if err != nil {
+		return err
+	}
+	updateConfig(func(c *Config) { c.HashFunction = parsed })
+	return nil
+}
+
+func GetHashFunction() HashFunction {
+	return GetConfig().GetHashFunction()
+}
+
+// HashVersion identifies how account hashes and node hashes are told apart. It is recorded in every proof, so proofs
+// are verified with the hash version they were generated with.
+type HashVersion int
+
+const (
+	// HASH_VERSION_UNTAGGED hashes accounts and nodes the same way (the version of proofs that do not record one), so
+	// an account hash and a node hash can only be told apart by their level.
+	HASH_VERSION_UNTAGGED HashVersion = 1
+	// HASH_VERSION_TAGGED writes LEAF_HASH_TAG first to account hashes and NODE_HASH_TAG first to node hashes (after
+	// the deployment domain, if any), so a leaf can't be passed off as an internal node or vice versa.
+	HASH_VERSION_TAGGED HashVersion = 2
+	// HASH_VERSION_ROOT_TAGGED (the default) tags hashes like HASH_VERSION_TAGGED, but writes ROOT_HASH_TAG instead of
+	// LEAF_HASH_TAG first to the hashes binding merkle roots to their asset sums and epochs (see hashRootWithAssetSum),
+	// which are the leaves of upper level proofs, so a lower level proof can't be passed off as an account or vice versa.
+	HASH_VERSION_ROOT_TAGGED HashVersion = 3
+)
+
+// ParseHashVersion returns the hash version with the given number. Zero is HASH_VERSION_UNTAGGED, the version of
+// proofs generated before the hash version was recorded.
+func ParseHashVersion(version int) (HashVersion, error) {
+	switch HashVersion(version) {
+	case 0, HASH_VERSION_UNTAGGED:
+		return HASH_VERSION_UNTAGGED, nil
+	case HASH_VERSION_TAGGED:
+		return HASH_VERSION_TAGGED, nil
+	case HASH_VERSION_ROOT_TAGGED:
+		return HASH_VERSION_ROOT_TAGGED, nil
+	}
+	return 0, fmt.Errorf("unknown hash version %d (expected %d, %d, or %d)", version, HASH_VERSION_UNTAGGED, HASH_VERSION_TAGGED, HASH_VERSION_ROOT_TAGGED)
+}
+
+// SetHashVersion sets the hash version of account and node hashes of the configuration (see Config.HashVersion).
+func SetHashVersion(version HashVersion) error {
+	parsed, err := ParseHashVersion(int(version))
+	if err != nil {
+		return err
+	}
+	updateConfig(func(c *Config) { c.HashVersion = parsed })
+	return nil
+}
+
+func GetHashVersion() HashVersion {
+	return GetConfig().GetHashVersion()
+}
+
+// IsTagged returns whether account and node hashes of the hash version are tagged (see HASH_VERSION_TAGGED).
+func (version HashVersion) IsTagged() bool {
+	return version >= HASH_VERSION_TAGGED
+}
+
+// pseudoAccountHashTag returns the tag of the hashes of pseudo-accounts, which bind the merkle roots of proofs to their
+// asset sums and epochs: ROOT_HASH_TAG with HASH_VERSION_ROOT_TAGGED, and LEAF_HASH_TAG before, when they were hashed
+// like accounts.
+func (c Config) pseudoAccountHashTag() int {
+	if c.GetHashVersion() >= HASH_VERSION_ROOT_TAGGED {
+		return ROOT_HASH_TAG
+	}
+	return LEAF_HASH_TAG
+}
+
+// AccountBytesVersion identifies the canonical byte layout accounts are hashed from (see CanonicalAccountBytes). It is
+// recorded in every proof, so accounts are hashed with the layout their proofs were generated with, and new layouts can
+// coexist with the ones of earlier proofs.
+type AccountBytesVersion int
+
+const (
+	// ACCOUNT_BYTES_VERSION_1 lays out the WalletId followed by the balance of each asset in the order of the asset
+	// registry, each a big-endian field element left-padded to ModBytes (the default, and the version of proofs that do
+	// not record one).
+	ACCOUNT_BYTES_VERSION_1 AccountBytesVersion = 1
+)
+
+// ParseAccountBytesVersion returns the account bytes version with the given number. Zero is ACCOUNT_BYTES_VERSION_1,
+// the version of proofs generated before the account bytes version was recorded.
+func ParseAccountBytesVersion(version int) (AccountBytesVersion, error) {
+	switch AccountBytesVersion(version) {
+	case 0, ACCOUNT_BYTES_VERSION_1:
+		return ACCOUNT_BYTES_VERSION_1, nil
+	}
+	return 0, fmt.Errorf("unknown account bytes version %d (expected %d)", version, ACCOUNT_BYTES_VERSION_1)
+}
+
+// SetAccountBytesVersion sets the byte layout accounts are hashed from of the configuration (see
+// Config.AccountBytesVersion).
+func SetAccountBytesVersion(version AccountBytesVersion) error {
+	parsed, err := ParseAccountBytesVersion(int(version))
+	if err != nil {
+		return err
+	}
+	updateConfig(func(c *Config) { c.AccountBytesVersion = parsed })
+	return nil
+}
+
+func GetAccountBytesVersion() AccountBytesVersion {
+	return GetConfig().GetAccountBytesVersion()
+}
+
+// LeafHashMode identifies how account hashes, the leaves of the Merkle trees, are computed. It is recorded in every
+// proof, so proofs are verified with the leaf hash mode they were generated with. Node hashes always use the
+// configured hash function.
+type LeafHashMode string
+
+const (
+	// LEAF_HASH_NATIVE hashes accounts with the configured hash function (the default, and the mode of proofs that do
+	// not record one).
+	LEAF_HASH_NATIVE LeafHashMode = "native"
+	// LEAF_HASH_SHA256 hashes accounts with SHA-256 (see CanonicalAccountBytes), so leaves can be recomputed with
+	// off-the-shelf tooling. It needs far more constraints than the native hash functions.
+	LEAF_HASH_SHA256 LeafHashMode = "sha256"
+)
+
+// ParseLeafHashMode returns the leaf hash mode with the given name. The empty name is LEAF_HASH_NATIVE, the mode of
+// proofs generated before the leaf hash mode was recorded.
+func ParseLeafHashMode(name string) (LeafHashMode, error) {
+	switch LeafHashMode(name) {
+	case "", LEAF_HASH_NATIVE:
+		return LEAF_HASH_NATIVE, nil
+	case LEAF_HASH_SHA256:
+		return LEAF_HASH_SHA256, nil
+	}
+	return "", fmt.Errorf("unknown leaf hash mode %q (expected %s or %s)", name, LEAF_HASH_NATIVE, LEAF_HASH_SHA256)
+}
+
+// SetLeafHashMode sets the leaf hash mode of account hashes of the configuration (see Config.LeafHashMode).
+func SetLeafHashMode(mode LeafHashMode) error {
+	parsed, err := ParseLeafHashMode(string(mode))
+	if err != nil {
+		return err
+	}
+	updateConfig(func(c *Config) { c.LeafHashMode = parsed })
+	return nil
+}
+
+func GetLeafHashMode() LeafHashMode {
+	return GetConfig().GetLeafHashMode()
+}
+
+// NewGoHasher returns a new Go hasher of the hash function, consistent with newHasher in the circuit.
+func NewGoHasher(hashFunction HashFunction) hash.StateStorer {
+	if hashFunction == HASH_POSEIDON2 {
+		return poseidon2.NewMerkleDamgardHasher()
+	}
+	return mimc.NewMiMC()
+}
+
+// newHasher returns a new hasher of the hash function for the circuit. NewGoHasher is the Go equivalent.
+func newHasher(api frontend.API, hashFunction HashFunction) (stdhash.FieldHasher, error) {
+	if hashFunction == HASH_POSEIDON2 {
+		return &poseidon2Hasher{api: api, state: 0}, nil
+	}
+	hasher, err := stdmimc.NewMiMC(api)
+	if err != nil {
+		return nil, err
+	}
+	return &hasher, nil
+}
+
+// poseidon2RoundKeys are the round keys of the default Poseidon2 parameters of gnark-crypto, as big integers.
+var poseidon2RoundKeys = sync.OnceValue(func() [][]*big.Int {
+	parameters := poseidon2.GetDefaultParameters()
+	roundKeys := make([][]*big.Int, len(parameters.RoundKeys))
+	for i, keys := range parameters.RoundKeys {
+		roundKeys[i] = make([]*big.Int, len(keys))
+		for j := range keys {
+			roundKeys[i][j] = keys[j].BigInt(new(big.Int))
+		}
+	}
+	return roundKeys
+})
+
+// poseidon2Hasher is the circuit equivalent of the Merkle-Damgard construction of the Poseidon2 permutation of
+// gnark-crypto: each written element is compressed into the state, starting from zero.
+type poseidon2Hasher struct {
+	api   frontend.API
+	state frontend.Variable
+	data  []frontend.Variable
+}
+
+func (h *poseidon2Hasher) Write(data ...frontend.Variable) {
+	h.data = append(h.data, data...)
+}
+
+func (h *poseidon2Hasher) Reset() {
+	h.data = nil
+	h.state = 0
+}
+
+func (h *poseidon2Hasher) Sum() frontend.Variable {
+	for _, element := range h.data {
+		h.state = h.compress(h.state, element)
+	}
+	h.data = nil
+	return h.state
+}
+
+// compress is the Poseidon2 compression function of gnark-crypto: the second element of the permutation of
+// (left, right), plus right.
+func (h *poseidon2Hasher) compress(left, right frontend.Variable) frontend.Variable {
+	state := [2]frontend.Variable{left, right}
+	h.permute(&state)
+	return h.api.Add(state[1], right)
+}
+
+// permute applies the Poseidon2 permutation of width 2 (see poseidon2.Permutation in gnark-crypto).
+func (h *poseidon2Hasher) permute(state *[2]frontend.Variable) {
+	api := h.api
+	roundKeys := poseidon2RoundKeys()
+	parameters := poseidon2.GetDefaultParameters()
+	sBox := func(x frontend.Variable) frontend.Variable {
+		x2 := api.Mul(x, x)
+		return api.Mul(api.Mul(x2, x2), x)
+	}
+	// the external matrix is circ(2, 1), and the internal matrix is [[2, 1], [1, 3]]
+	matMulExternal := func() {
+		sum := api.Add(state[0], state[1])
+		state[0], state[1] = api.Add(sum, state[0]), api.Add(sum, state[1])
+	}
+	matMulInternal := func() {
+		sum := api.Add(state[0], state[1])
+		state[0], state[1] = api.Add(state[0], sum), api.Add(api.Mul(state[1], 2), sum)
+	}
+	fullRound := func(round int) {
+		for j := range state {
+			state[j] = sBox(api.Add(state[j], roundKeys[round][j]))
+		}
+		matMulExternal()
+	}
+
+	matMulExternal()
+	halfFullRounds := parameters.NbFullRounds / 2
+	for i := 0; i < halfFullRounds; i++ {
+		fullRound(i)
+	}
+	for i := halfFullRounds; i < halfFullRounds+parameters.NbPartialRounds; i++ {
+		state[0] = sBox(api.Add(state[0], roundKeys[i][0]))
+		matMulInternal()
+	}
+	for i := halfFullRounds + parameters.NbPartialRounds; i < parameters.NbFullRounds+parameters.NbPartialRounds; i++ {
+		fullRound(i)
+	}
+}
+
+// sha256HashAccount computes the leaf of the account with LEAF_HASH_SHA256, which is SHA-256 of the deployment domain
+// element (if any), the tag (with tagged hash versions), WalletId, Salt (if non-zero), and the balances, each as 32
+// big-endian bytes, read as a big-endian integer modulo the scalar field. goComputeSHA256HashForAccountWithError is the
+// Go equivalent.
+func sha256HashAccount(api frontend.API, account Account, tag int, config Config) frontend.Variable {
+	uapi, err := uints.New[uints.U32](api)
+	if err != nil {
+		panic("error while instantiating uints: " + err.Error())
+	}
+	digest := func(salted bool) frontend.Variable {
+		hasher, err := sha2.New(api)
+		if err != nil {
+			panic("error while instantiating sha256 hasher: " + err.Error())
+		}
+		if domain := config.GetDeploymentDomainElement(); domain != nil {
+			hasher.Write(uints.NewU8Array(domain))
+		}
+		if config.GetHashVersion().IsTagged() {
+			hasher.Write(uints.NewU8Array(padToModBytes(big.NewInt(int64(tag)))))
+		}
+		hasher.Write(elementBytes(api, uapi, account.WalletId))
+		if salted {
+			hasher.Write(elementBytes(api, uapi, account.Salt))
+		}
+		for _, balance := range account.Balance {
+			hasher.Write(elementBytes(api, uapi, balance))
+		}
+
+		// the field reduces the big-endian digest modulo its order
+		var leaf frontend.Variable = 0
+		for _, b := range hasher.Sum() {
+			leaf = api.Add(api.Mul(leaf, 256), b.Val)
+		}
+		return leaf
+	}
+
+	unsaltedLeaf := digest(false)
+	if account.Salt == nil {
+		return unsaltedLeaf
+	}
+	return api.Select(api.IsZero(account.Salt), unsaltedLeaf, digest(true))
+}
+
+// elementBytes returns the field element as ModBytes big-endian bytes, like padToModBytes.
+func elementBytes(api frontend.API, uapi *uints.BinaryField[uints.U32], element frontend.Variable) []uints.U8 {
+	// the little-endian bits of the element, which are checked to be less than the modulus
+	bits := api.ToBinary(element)
+	elementBytes := make([]uints.U8, ModBytes)
+	for i := range elementBytes {
+		low := 8 * (ModBytes - 1 - i)
+		elementBytes[i] = uapi.ByteValueOf(api.FromBinary(bits[low:min(low+8, len(bits))]...))
+	}
+	return elementBytes
+}
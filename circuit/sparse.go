@@ -0,0 +1,237 @@
+package circuit
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+
+	"github.com/consensys/gnark-crypto/hash"
+)
+
+// SPARSE_TREE_DEPTH is the depth of a SparseMerkleTree, the number of bits of its keys (see SparseTreeKey).
+const SPARSE_TREE_DEPTH = 128
+
+// SparseTreeKey is the position of a user in a SparseMerkleTree, SPARSE_TREE_DEPTH bits read from the most significant
+// bit of its first byte (the root's child is the first bit).
+type SparseTreeKey [SPARSE_TREE_DEPTH / 8]byte
+
+// ComputeSparseTreeKey returns the key of the user with the given WalletId (as in GoAccount) in a SparseMerkleTree,
+// the first SPARSE_TREE_DEPTH bits of the SHA-256 hash of the WalletId padded to ModBytes bytes.
+func ComputeSparseTreeKey(walletId []byte) (SparseTreeKey, error) {
+	paddedWalletId, err := PadToModBytesWithError(new(big.Int).SetBytes(walletId))
+	if err != nil {
+		return SparseTreeKey{}, fmt.Errorf("error padding WalletId: %w", err)
+	}
+	digest := sha256.Sum256(paddedWalletId)
+	var key SparseTreeKey
+	copy(key[:], digest[:])
+	return key, nil
+}
+
+// bit returns the bit of the key deciding the side of the node at depth (1 to SPARSE_TREE_DEPTH), 1 for a right node.
+func (k SparseTreeKey) bit(depth int) int {
+	i := depth - 1
+	return int(k[i/8]>>(7-i%8)) & 1
+}
+
+// prefix returns the key of the node at depth on the key's path, i.e. the key with every bit after depth cleared.
+func (k SparseTreeKey) prefix(depth int) SparseTreeKey {
+	var prefix SparseTreeKey
+	copy(prefix[:depth/8], k[:depth/8])
+	if depth%8 != 0 {
+		prefix[depth/8] = k[depth/8] & ^byte(0xff>>(depth%8))
+	}
+	return prefix
+}
+
+// sibling returns the key of the sibling of the node at depth on the key's path.
+func (k SparseTreeKey) sibling(depth int) SparseTreeKey {
+	sibling := k.prefix(depth)
+	i := depth - 1
+	sibling[i/8] ^= 1 << (7 - i%8)
+	return sibling
+}
+
+// SparseMerkleTree is a Merkle tree of depth SPARSE_TREE_DEPTH with the hash of each account (see
+// GoComputeMiMCHashForAccount) at the leaf of its user's key (see ComputeSparseTreeKey), and zero leaves everywhere
+// else. Unlike the tree of a batch, every user has a fixed position, so a user that is not in the tree can be shown
+// to be missing with the path of the zero leaf at their key (see NonInclusionPath). Nodes are hashed like the nodes
+// of a batch (see GoComputeHashOfTwoNodes), and only the nodes with a non-zero leaf below them are stored.
+type SparseMerkleTree struct {
+	config Config
+	hasher hash.StateStorer
+	// nodes[depth] are the nodes at depth with a non-zero leaf below them, by the prefix of their keys
+	nodes []map[SparseTreeKey]Hash
+	// emptyNodes[depth] is the node at depth with only zero leaves below it
+	emptyNodes []Hash
+	// computed is set once the nodes above the leaves are computed, and cleared when a leaf is added
+	computed bool
+}
+
+// NewSparseMerkleTree returns an empty SparseMerkleTree, whose nodes are hashed with the configured hash function.
+func NewSparseMerkleTree() *SparseMerkleTree {
+	tree, err := NewSparseMerkleTreeWithError()
+	if err != nil {
+		panic(err.Error())
+	}
+	return tree
+}
+
+// NewSparseMerkleTreeWithError returns an empty SparseMerkleTree (see NewSparseMerkleTree), or an error if its empty
+// nodes can't be hashed.
+func NewSparseMerkleTreeWithError() (*SparseMerkleTree, error) {
+	return NewSparseMerkleTreeWithConfig(GetConfig())
+}
+
+// NewSparseMerkleTreeWithConfig returns an empty SparseMerkleTree like NewSparseMerkleTreeWithError, whose accounts and
+// nodes are hashed as the configuration hashes them.
+func NewSparseMerkleTreeWithConfig(config Config) (*SparseMerkleTree, error) {
+	tree := &SparseMerkleTree{
+		config:     config,
+		hasher:     NewGoHasher(config.GetHashFunction()),
+		nodes:      make([]map[SparseTreeKey]Hash, SPARSE_TREE_DEPTH+1),
+		emptyNodes: make([]Hash, SPARSE_TREE_DEPTH+1),
+	}
+	for depth := range tree.nodes {
+		tree.nodes[depth] = make(map[SparseTreeKey]Hash)
+	}
+	tree.emptyNodes[SPARSE_TREE_DEPTH] = padToModBytes(big.NewInt(0))
+	for depth := SPARSE_TREE_DEPTH - 1; depth >= 0; depth-- {
+		label := "empty node at depth " + strconv.Itoa(depth+1)
+		node, err := tree.config.GoComputeHashOfTwoNodes(tree.hasher, tree.emptyNodes[depth+1], tree.emptyNodes[depth+1], label, label)
+		if err != nil {
+			return nil, err
+		}
+		tree.emptyNodes[depth] = node
+	}
+	return tree, nil
+}
+
+// AddAccount adds the hash of the account at the leaf of its user's key. The leaf of a user with several accounts (or
+// of users whose keys collide) is the hash of the leaf and the account hash, in the order the accounts are added.
+func (t *SparseMerkleTree) AddAccount(account GoAccount) error {
+	key, err := ComputeSparseTreeKey(account.WalletId)
+	if err != nil {
+		return err
+	}
+	accountHash, err := t.config.goComputeHashForAccountWithError(t.hasher, account)
+	if err != nil {
+		return err
+	}
+	if leaf, ok := t.nodes[SPARSE_TREE_DEPTH][key]; ok {
+		if accountHash, err = t.config.GoComputeHashOfTwoNodes(t.hasher, leaf, accountHash, "leaf", "account hash"); err != nil {
+			return err
+		}
+	}
+	t.nodes[SPARSE_TREE_DEPTH][key] = accountHash
+	t.computed = false
+	return nil
+}
+
+// AddAccounts adds every account to the tree (see AddAccount).
+func (t *SparseMerkleTree) AddAccounts(accounts []GoAccount) error {
+	for i, account := range accounts {
+		if err := t.AddAccount(account); err != nil {
+			return fmt.Errorf("error adding account %d to sparse merkle tree: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// compute computes the nodes above the leaves, from the leaves to the root, if a leaf was added since they were last
+// computed.
+func (t *SparseMerkleTree) compute() error {
+	if t.computed {
+		return nil
+	}
+	for depth := SPARSE_TREE_DEPTH - 1; depth >= 0; depth-- {
+		children := t.nodes[depth+1]
+		parents := make(map[SparseTreeKey]Hash, len(children))
+		for key := range children {
+			parentKey := key.prefix(depth)
+			if _, ok := parents[parentKey]; ok {
+				continue
+			}
+			left, right := t.node(depth+1, key), t.node(depth+1, key.sibling(depth+1))
+			if key.bit(depth+1) == 1 {
+				left, right = right, left
+			}
+			label := strconv.Itoa(depth + 1)
+			parent, err := t.config.GoComputeHashOfTwoNodes(t.hasher, left, right, "left node at depth "+label, "right node at depth "+label)
+			if err != nil {
+				return err
+			}
+			parents[parentKey] = parent
+		}
+		t.nodes[depth] = parents
+	}
+	t.computed = true
+	return nil
+}
+
+// node returns the node at depth with the given prefix, which is an empty node if no leaf below it was added.
+func (t *SparseMerkleTree) node(depth int, prefix SparseTreeKey) Hash {
+	if node, ok := t.nodes[depth][prefix]; ok {
+		return node
+	}
+	return t.emptyNodes[depth]
+}
+
+// Root returns the root of the tree.
+func (t *SparseMerkleTree) Root() (Hash, error) {
+	if err := t.compute(); err != nil {
+		return nil, err
+	}
+	return t.node(0, SparseTreeKey{}), nil
+}
+
+// NonInclusionPath returns the siblings of the path from the zero leaf at the key of the user with the given WalletId
+// to the root, from the leaves up (like ComputeMerklePath), or an error if the user is in the tree.
+func (t *SparseMerkleTree) NonInclusionPath(walletId []byte) ([]Hash, error) {
+	key, err := ComputeSparseTreeKey(walletId)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := t.nodes[SPARSE_TREE_DEPTH][key]; ok {
+		return nil, errors.New("user is included in the sparse merkle tree")
+	}
+	if err := t.compute(); err != nil {
+		return nil, err
+	}
+	path := make([]Hash, 0, SPARSE_TREE_DEPTH)
+	for depth := SPARSE_TREE_DEPTH; depth > 0; depth-- {
+		path = append(path, t.node(depth, key.sibling(depth)))
+	}
+	return path, nil
+}
+
+// GoComputeSparseMerkleRoot computes the root of a SparseMerkleTree from the leaf at the key and the siblings of its
+// path (see NonInclusionPath).
+func GoComputeSparseMerkleRoot(key SparseTreeKey, leaf Hash, path []Hash) (Hash, error) {
+	return GetConfig().GoComputeSparseMerkleRoot(key, leaf, path)
+}
+
+// GoComputeSparseMerkleRoot computes the root of a SparseMerkleTree like GoComputeSparseMerkleRoot, with its nodes
+// hashed as the configuration hashes nodes.
+func (c Config) GoComputeSparseMerkleRoot(key SparseTreeKey, leaf Hash, path []Hash) (Hash, error) {
+	if len(path) != SPARSE_TREE_DEPTH {
+		return nil, fmt.Errorf("sparse merkle path is not of depth of tree: expected length %d, found %d", SPARSE_TREE_DEPTH, len(path))
+	}
+	hasher := NewGoHasher(c.GetHashFunction())
+	node := leaf
+	for i, sibling := range path {
+		depth := SPARSE_TREE_DEPTH - i
+		left, right := node, sibling
+		if key.bit(depth) == 1 {
+			left, right = right, left
+		}
+		label := strconv.Itoa(depth)
+		var err error
+		if node, err = c.GoComputeHashOfTwoNodes(hasher, left, right, "left node at depth "+label, "right node at depth "+label); err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
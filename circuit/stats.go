@@ -0,0 +1,107 @@
+package circuit
+
+import (
+	"fmt"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+)
+
+// ComponentConstraints is the number of R1CS constraints of each component of the circuit (see
+// CountComponentConstraints).
+type ComponentConstraints struct {
+	// RangeChecks is the number of constraints of the range checks of the balances and the asset sum.
+	RangeChecks int
+	// Merkle is the number of constraints of the account hashes, the Merkle root, and the hash binding the root to the
+	// asset sum and the epoch.
+	Merkle int
+	// Sum is the number of constraints of the sum of the balances and its comparison with the asset sum.
+	Sum int
+}
+
+// rangeCheckComponent is the range checks of Circuit.
+type rangeCheckComponent struct {
+	Accounts []Account
+	AssetSum Balance
+	Config   Config `gnark:"-"`
+}
+
+func (c *rangeCheckComponent) Define(api frontend.API) error {
+	ranger := newRangechecker(api, c.Config)
+	for _, account := range c.Accounts {
+		assertBalanceNonNegativeAndNonOverflow(api, ranger, account.Balance, c.Config.GetBalanceBits())
+	}
+	assertBalanceNonNegativeAndNonOverflow(api, ranger, c.AssetSum, c.Config.GetAssetSumBits())
+	return nil
+}
+
+// merkleComponent is the hashes of Circuit.
+type merkleComponent struct {
+	Accounts                   []Account
+	AssetSum                   Balance
+	MerkleRoot                 frontend.Variable
+	MerkleRootWithAssetSumHash frontend.Variable
+	Epoch                      frontend.Variable
+	Config                     Config `gnark:"-"`
+}
+
+func (c *merkleComponent) Define(api frontend.API) error {
+	hasher, err := newHasher(api, c.Config.GetHashFunction())
+	if err != nil {
+		return err
+	}
+	api.AssertIsEqual(computeMerkleRootFromAccounts(api, hasher, c.Accounts, c.Config), c.MerkleRoot)
+	api.AssertIsEqual(hashRootWithAssetSum(api, hasher, c.MerkleRoot, c.AssetSum, c.Epoch, c.Config), c.MerkleRootWithAssetSumHash)
+	return nil
+}
+
+// sumComponent is the sum of the balances of Circuit.
+type sumComponent struct {
+	Accounts []Account
+	AssetSum Balance
+}
+
+func (c *sumComponent) Define(api frontend.API) error {
+	runningBalance := ConstructBalance()
+	for _, account := range c.Accounts {
+		runningBalance = addBalance(api, runningBalance, account.Balance)
+	}
+	assertBalancesAreEqual(api, runningBalance, c.AssetSum)
+	return nil
+}
+
+// CountComponentConstraints compiles each component of the circuit for accountCount accounts with the given
+// configuration (and the configured asset registry), and returns their number of R1CS constraints. Each component is
+// compiled on its own, so the components do not add up to the constraints of the circuit exactly (e.g. the circuit also
+// checks the account count and circuit version).
+func CountComponentConstraints(accountCount int, config Config) (ComponentConstraints, error) {
+	if err := config.Validate(); err != nil {
+		return ComponentConstraints{}, err
+	}
+	if accountCount < 1 || accountCount > config.AccountsPerBatch() {
+		return ComponentConstraints{}, fmt.Errorf("account count must be between 1 and %d, got %d", config.AccountsPerBatch(), accountCount)
+	}
+	accounts := make([]Account, accountCount)
+	for i := range accounts {
+		accounts[i].Balance = ConstructBalance()
+	}
+
+	var counts ComponentConstraints
+	components := []struct {
+		circuit frontend.Circuit
+		count   *int
+	}{
+		{&rangeCheckComponent{Accounts: accounts, AssetSum: ConstructBalance(), Config: config}, &counts.RangeChecks},
+		{&merkleComponent{Accounts: accounts, AssetSum: ConstructBalance(), Config: config}, &counts.Merkle},
+		{&sumComponent{Accounts: accounts, AssetSum: ConstructBalance()}, &counts.Sum},
+	}
+	for _, component := range components {
+		cs, err := frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, component.circuit)
+		if err != nil {
+			return ComponentConstraints{}, fmt.Errorf("error compiling circuit component: %w", err)
+		}
+		*component.count = cs.GetNbConstraints()
+	}
+	return counts, nil
+}
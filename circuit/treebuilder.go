@@ -0,0 +1,235 @@
+package circuit
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/consensys/gnark-crypto/hash"
+)
+
+// GoAccountIterator yields accounts one at a time, e.g. from a database cursor. It has the same method as
+// core.AccountIterator, so either can be used for the other.
+type GoAccountIterator interface {
+	// Next returns the next account, or io.EOF once every account has been returned.
+	Next(ctx context.Context) (GoAccount, error)
+}
+
+// MerkleNodeWriter receives the nodes of a Merkle tree as a MerkleTreeBuilder computes them. The nodes of each depth
+// are written in order, from position 0 to 2^depth-1, and the root (depth 0) is written last.
+type MerkleNodeWriter interface {
+	WriteNode(depth int, node Hash) error
+}
+
+// MerkleTreeBuilder builds a Merkle tree from a stream of leaves, writing each node to a MerkleNodeWriter as soon as
+// it is computed. It only keeps one pending node per depth in memory, so trees can be built from accounts that are
+// never all held in memory at once. The nodes are the same as those of GoComputeMerkleTreeNodesFromAccounts.
+type MerkleTreeBuilder struct {
+	treeDepth int
+	config    Config
+	nodes     MerkleNodeWriter
+	hasher    hash.StateStorer
+	// pending[depth] is the left node at depth waiting for its right sibling (nil if there is none)
+	pending []Hash
+	leaves  int
+	root    Hash
+}
+
+// NewMerkleTreeBuilder returns a builder of a Merkle tree of depth treeDepth that writes its nodes to nodes.
+func NewMerkleTreeBuilder(treeDepth int, nodes MerkleNodeWriter) *MerkleTreeBuilder {
+	config := GetConfig()
+	config.TreeDepth = treeDepth
+	return &MerkleTreeBuilder{
+		treeDepth: treeDepth,
+		config:    config,
+		nodes:     nodes,
+		hasher:    NewGoHasher(config.GetHashFunction()),
+		pending:   make([]Hash, treeDepth+1),
+	}
+}
+
+// AddAccount adds the hash of the account (see GoComputeMiMCHashForAccount) as the next leaf of the tree.
+func (b *MerkleTreeBuilder) AddAccount(account GoAccount) error {
+	leaf, err := b.config.goComputeHashForAccountWithError(b.hasher, account)
+	if err != nil {
+		return err
+	}
+	return b.AddLeaf(leaf)
+}
+
+// AddLeaf adds the next leaf of the tree, and writes every node it completes.
+func (b *MerkleTreeBuilder) AddLeaf(leaf Hash) error {
+	if b.leaves >= PowOfTwo(b.treeDepth) {
+		return errors.New(MERKLE_TREE_LEAF_LIMIT_EXCEEDED_MESSAGE)
+	}
+	b.leaves++
+
+	// write the node, and hash it with its pending left sibling (if it is a right node) until a left node is reached
+	node := leaf
+	for depth := b.treeDepth; ; depth-- {
+		if err := b.nodes.WriteNode(depth, node); err != nil {
+			return fmt.Errorf("error writing node at depth %d: %w", depth, err)
+		}
+		if depth == 0 {
+			b.root = node
+			return nil
+		}
+		if b.pending[depth] == nil {
+			b.pending[depth] = node
+			return nil
+		}
+		parent, err := b.config.GoComputeHashOfTwoNodes(b.hasher, b.pending[depth], node, "left node", "right node")
+		if err != nil {
+			return err
+		}
+		b.pending[depth] = nil
+		node = parent
+	}
+}
+
+// Finish pads the tree with zero leaves (like GoComputeMerkleTreeNodesFromAccounts), writes the remaining nodes, and
+// returns the root of the tree.
+func (b *MerkleTreeBuilder) Finish() (Hash, error) {
+	for b.leaves < PowOfTwo(b.treeDepth) {
+		if err := b.AddLeaf(padToModBytes(big.NewInt(0))); err != nil {
+			return nil, err
+		}
+	}
+	return b.root, nil
+}
+
+// BuildMerkleTree builds the Merkle tree of depth treeDepth of every account of the iterator, writing its nodes to
+// nodes as they are computed. Returns the root of the tree and the number of accounts.
+func BuildMerkleTree(ctx context.Context, accounts GoAccountIterator, treeDepth int, nodes MerkleNodeWriter) (root Hash, accountCount int, err error) {
+	builder := NewMerkleTreeBuilder(treeDepth, nodes)
+	for {
+		account, err := accounts.Next(ctx)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("error reading account %d: %w", accountCount, err)
+		}
+		if err := builder.AddAccount(account); err != nil {
+			return nil, 0, err
+		}
+		accountCount++
+	}
+	root, err = builder.Finish()
+	return root, accountCount, err
+}
+
+// MerkleNodeFiles stores the nodes of a Merkle tree in a directory, with one file per depth holding the nodes of that
+// depth in order as ModBytes-byte values, so the tree can be written and read without holding it in memory.
+type MerkleNodeFiles struct {
+	treeDepth int
+	files     []*os.File
+	writers   []*bufio.Writer
+}
+
+// merkleNodeFilePath returns the path of the file of the nodes at depth.
+func merkleNodeFilePath(dir string, depth int) string {
+	return filepath.Join(dir, "merkle_nodes_"+strconv.Itoa(depth)+".bin")
+}
+
+// CreateMerkleNodeFiles creates the node files of a Merkle tree of depth treeDepth in dir, to write with a
+// MerkleTreeBuilder. Close must be called once the tree is built.
+func CreateMerkleNodeFiles(dir string, treeDepth int) (*MerkleNodeFiles, error) {
+	nodeFiles := &MerkleNodeFiles{treeDepth: treeDepth}
+	for depth := 0; depth <= treeDepth; depth++ {
+		file, err := os.Create(merkleNodeFilePath(dir, depth))
+		if err != nil {
+			nodeFiles.Close()
+			return nil, fmt.Errorf("error creating merkle node file: %w", err)
+		}
+		nodeFiles.files = append(nodeFiles.files, file)
+		nodeFiles.writers = append(nodeFiles.writers, bufio.NewWriter(file))
+	}
+	return nodeFiles, nil
+}
+
+// WriteNode appends the node to the file of its depth.
+func (f *MerkleNodeFiles) WriteNode(depth int, node Hash) error {
+	if depth < 0 || depth > f.treeDepth {
+		return fmt.Errorf("depth %d is outside a tree of depth %d", depth, f.treeDepth)
+	}
+	if len(node) != ModBytes {
+		return fmt.Errorf("node is %d bytes, expected %d", len(node), ModBytes)
+	}
+	_, err := f.writers[depth].Write(node)
+	return err
+}
+
+// Close flushes and closes the node files. Returns the first error encountered.
+func (f *MerkleNodeFiles) Close() error {
+	var firstErr error
+	for i, file := range f.files {
+		if err := f.writers[i].Flush(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if err := file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// ReadMerkleNode reads the node at the position of the depth from the node files of a Merkle tree in dir, without
+// reading the rest of the tree.
+func ReadMerkleNode(dir string, depth int, position int) (Hash, error) {
+	file, err := os.Open(merkleNodeFilePath(dir, depth))
+	if err != nil {
+		return nil, fmt.Errorf("error opening merkle node file: %w", err)
+	}
+	defer file.Close()
+	node := make(Hash, ModBytes)
+	if _, err := file.ReadAt(node, int64(position*ModBytes)); err != nil {
+		return nil, fmt.Errorf("error reading node %d at depth %d: %w", position, depth, err)
+	}
+	return node, nil
+}
+
+// ReadMerklePathFromNodeFiles reads the Merkle path of the leaf at position from the node files of a Merkle tree of
+// depth treeDepth in dir. It returns the same path as ComputeMerklePath, reading only the siblings on the path.
+func ReadMerklePathFromNodeFiles(dir string, treeDepth int, position int) ([]Hash, error) {
+	if position < 0 || position >= PowOfTwo(treeDepth) {
+		return nil, fmt.Errorf("position %d is out of bounds of a tree of depth %d", position, treeDepth)
+	}
+	path := make([]Hash, 0, treeDepth)
+	for depth := treeDepth; depth > 0; depth-- {
+		// the sibling is right after an even position, and right before an odd one
+		sibling, err := ReadMerkleNode(dir, depth, position^1)
+		if err != nil {
+			return nil, err
+		}
+		path = append(path, sibling)
+		position /= 2
+	}
+	return path, nil
+}
+
+// ReadMerkleNodeFiles reads every node of the node files of a Merkle tree of depth treeDepth in dir, in the layout
+// of GoComputeMerkleTreeNodesFromAccounts.
+func ReadMerkleNodeFiles(dir string, treeDepth int) ([][]Hash, error) {
+	nodes := make([][]Hash, treeDepth+1)
+	for depth := 0; depth <= treeDepth; depth++ {
+		data, err := os.ReadFile(merkleNodeFilePath(dir, depth))
+		if err != nil {
+			return nil, fmt.Errorf("error reading merkle node file: %w", err)
+		}
+		if len(data) != PowOfTwo(depth)*ModBytes {
+			return nil, fmt.Errorf("merkle node file of depth %d has %d bytes, expected %d", depth, len(data), PowOfTwo(depth)*ModBytes)
+		}
+		nodes[depth] = make([]Hash, PowOfTwo(depth))
+		for i := range nodes[depth] {
+			nodes[depth][i] = data[i*ModBytes : (i+1)*ModBytes]
+		}
+	}
+	return nodes, nil
+}
@@ -11,8 +11,10 @@ type Balance []frontend.Variable
 
 // Account is an input to the circuit and is only used in this package. GoAccount is preferred elsewhere.
 type Account struct {
-	WalletId  frontend.Variable
-	Balance Balance
+	WalletId frontend.Variable
+	Balance  Balance
+	// Salt blinds the hash of the account (zero for unsalted accounts), see GoAccount.
+	Salt frontend.Variable
 }
 
 // Circuit is the input to the proof. A complete Circuit generates a proof, and the public elements of
@@ -22,10 +24,31 @@ type Circuit struct {
 	AssetSum                   Balance           `gnark:""`
 	MerkleRoot                 frontend.Variable `gnark:",public"`
 	MerkleRootWithAssetSumHash frontend.Variable `gnark:",public"`
+	// AccountCount is the number of accounts (the leaves that are not padding), so the number of leaves a proof covers
+	// can be checked from its public inputs.
+	AccountCount frontend.Variable `gnark:",public"`
+	// Epoch identifies the snapshot the proof attests to (zero for proofs bound to no snapshot). It is hashed into
+	// MerkleRootWithAssetSumHash, so every proof of a round, and each proof's leaf in the next level, carries it.
+	Epoch frontend.Variable `gnark:",public"`
+	// CircuitVersion is CIRCUIT_VERSION, which the circuit asserts, so proofs record the version of the circuit they
+	// were generated with in their public inputs, and a verifier can reject proofs of a version it does not support
+	// before their pairing check fails.
+	CircuitVersion frontend.Variable `gnark:",public"`
+
+	// Config is the configuration the circuit is compiled with (the configuration set through SetConfig if it is
+	// zero). It is not part of the witness.
+	Config Config `gnark:"-"`
 }
 
 type Hash = []byte
 
+// MerklePathElement is a sibling of a merkle path with the side it is hashed on, so the path can be folded without the
+// position of the leaf. IsLeft is set when the sibling is the left node, i.e. it is hashed before the current node.
+type MerklePathElement struct {
+	Sibling Hash
+	IsLeft  bool
+}
+
 // GoBalance represents the balance of an account. It can be converted to Balance for use in the circuit
 // through ConvertGoBalanceToBalance.
 type GoBalance []*big.Int
@@ -33,13 +56,23 @@ type GoBalance []*big.Int
 // GoAccount represents an account. It can be converted to Account for use in the circuit
 // through ConvertGoAccountToAccount.
 type GoAccount struct {
-	WalletId  []byte
-	Balance GoBalance
+	WalletId []byte
+	Balance  GoBalance
+	// Salt is a random field element hashed with the account (see NewSalt), so its balances can't be brute-forced
+	// from its leaf by someone who knows its WalletId. It is secret: it is only given to the account's user in their
+	// user verification package. Accounts without a salt (or with a zero salt) are unsalted.
+	Salt []byte
 }
 
 // RawGoAccount represents an account read from file (with a string WalletId). It can be converted to
 // GoAccount (to manipulate here) through ConvertRawGoAccountToAccount.
 type RawGoAccount struct {
-	WalletId  string
-	Balance GoBalance
+	WalletId string
+	Balance  GoBalance
+	// Salt is hex encoded (empty for unsalted accounts).
+	Salt string `json:",omitempty"`
+	// HashedWalletId is set when WalletId is not the account's own WalletId, but the field element (in base36) that it
+	// was hashed to (e.g. a base36 WalletId longer than MAX_BASE36_WALLET_ID_LENGTH characters, see WalletIdEncoding),
+	// so it is not hashed again. It is read the same way whatever the WalletId encoding.
+	HashedWalletId bool `json:",omitempty"`
 }
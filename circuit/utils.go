@@ -1,90 +1,534 @@
 package circuit
 
 import (
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
+	"math"
 	"math/big"
 	"math/rand"
+	"slices"
 	"strconv"
 	"strings"
 
-	"github.com/consensys/gnark-crypto/ecc/bn254/fr/mimc"
+	"github.com/consensys/gnark-crypto/ecc"
 	"github.com/consensys/gnark-crypto/hash"
 )
 
-// Getter function to interact with AssetSymbols array
-// (created in case we retrieve AssetSymbols from different source in future)
-func GetNumberOfAssets() int {
-	return len(AssetSymbols)
+// SetDeploymentDomain sets the domain of the deployment (e.g. "testnet" or "production") of the configuration (see
+// Config.DeploymentDomain). It must be set before any proofs are generated or verified. The empty domain (the default)
+// leaves hashes unchanged.
+func SetDeploymentDomain(domain string) {
+	updateConfig(func(c *Config) { c.DeploymentDomain = domain })
 }
 
-func GetAssetSymbols() []string {
-	return AssetSymbols
+func GetDeploymentDomain() string {
+	return GetConfig().DeploymentDomain
+}
+
+// GetDeploymentDomainElement returns the field element the configured deployment domain is hashed as, or nil for the
+// empty domain.
+func GetDeploymentDomainElement() Hash {
+	return GetConfig().GetDeploymentDomainElement()
+}
+
+// GetDeploymentDomainElement returns the field element the deployment domain of the configuration is hashed as, or nil
+// for the empty domain.
+func (c Config) GetDeploymentDomainElement() Hash {
+	if c.DeploymentDomain == "" {
+		return nil
+	}
+	if element, ok := deploymentDomainElements.Load(c.DeploymentDomain); ok {
+		return element.(Hash)
+	}
+	// the domain is hashed as SHA-256(domain) reduced modulo the BN254 scalar field
+	digest := sha256.Sum256([]byte(c.DeploymentDomain))
+	element := new(big.Int).SetBytes(digest[:])
+	domainElement := Hash(padToModBytes(element.Mod(element, ecc.BN254.ScalarField())))
+	deploymentDomainElements.Store(c.DeploymentDomain, domainElement)
+	return domainElement
+}
+
+// goWriteDeploymentDomain writes the deployment domain of the configuration (if any) to the hasher. It must be called
+// right after resetting the hasher, to be consistent with writeDeploymentDomain in the circuit.
+func (c Config) goWriteDeploymentDomain(hasher hash.StateStorer) error {
+	domain := c.GetDeploymentDomainElement()
+	if domain == nil {
+		return nil
+	}
+	_, err := hasher.Write(domain)
+	return err
+}
+
+// goWriteHashTag writes the tag (LEAF_HASH_TAG, NODE_HASH_TAG, or ROOT_HASH_TAG) to the hasher if the hash version of
+// the configuration tags hashes. It must be called right after goWriteDeploymentDomain, to be consistent with
+// writeHashTag in the circuit.
+func (c Config) goWriteHashTag(hasher hash.StateStorer, tag int64) error {
+	if !c.GetHashVersion().IsTagged() {
+		return nil
+	}
+	_, err := hasher.Write(padToModBytes(big.NewInt(tag)))
+	return err
+}
+
+// ConvertBaseUnitsToDisplayAmount converts an amount in base units (e.g. satoshis) to a decimal string in display
+// units (e.g. BTC) given the number of decimals of the asset. Trailing zeros of the fractional part are removed.
+func ConvertBaseUnitsToDisplayAmount(amount *big.Int, decimals int) string {
+	if amount.Sign() < 0 {
+		return "-" + ConvertBaseUnitsToDisplayAmount(new(big.Int).Neg(amount), decimals)
+	}
+	digits := amount.String()
+	if decimals <= 0 {
+		return digits
+	}
+
+	// left pad with zeros so there is at least one digit before the decimal point
+	if len(digits) <= decimals {
+		digits = strings.Repeat("0", decimals-len(digits)+1) + digits
+	}
+	integerPart, fractionalPart := digits[:len(digits)-decimals], strings.TrimRight(digits[len(digits)-decimals:], "0")
+	if fractionalPart == "" {
+		return integerPart
+	}
+	return integerPart + "." + fractionalPart
+}
+
+// ConvertDisplayAmountToBaseUnits converts a decimal string in display units (e.g. "1.5" BTC) to an amount in
+// base units (e.g. 150000000 satoshis) given the number of decimals of the asset. Returns an error if the string is
+// not an unsigned decimal number (digits, with an optional decimal point) or has more fractional digits than the asset
+// supports. Signs are rejected, as balances can't be negative.
+func ConvertDisplayAmountToBaseUnits(amount string, decimals int) (*big.Int, error) {
+	integerPart, fractionalPart, hasPoint := strings.Cut(strings.TrimSpace(amount), ".")
+	if integerPart == "" && (!hasPoint || fractionalPart == "") {
+		return nil, fmt.Errorf("invalid display amount: %q", amount)
+	}
+	if !isDecimalDigits(integerPart) || !isDecimalDigits(fractionalPart) {
+		return nil, fmt.Errorf("invalid display amount: %q", amount)
+	}
+	if len(fractionalPart) > decimals {
+		return nil, fmt.Errorf("display amount %q has more than %d decimal places", amount, decimals)
+	}
+
+	// the amount in base units is the display amount with the decimal point shifted right by decimals places
+	digits := integerPart + fractionalPart + strings.Repeat("0", decimals-len(fractionalPart))
+	baseUnits, ok := new(big.Int).SetString(digits, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid display amount: %q", amount)
+	}
+	return baseUnits, nil
+}
+
+// isDecimalDigits reports whether s only has the digits 0 to 9 (an empty string does).
+func isDecimalDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
 }
 
 // padToModBytes returns the bytes of the input value padded to ModBytes length
 func padToModBytes(num *big.Int) (paddedValue []byte) {
+	paddedValue, err := PadToModBytesWithError(num)
+	if err != nil {
+		panic(err.Error())
+	}
+	return paddedValue
+}
+
+// PadToModBytesWithError returns the bytes of the input value padded to ModBytes length, or an error if the value is
+// negative or longer than ModBytes.
+func PadToModBytesWithError(num *big.Int) (paddedValue []byte, err error) {
 	// If the value is negative, it will fail the circuit range check (since the sign extended version
-	// will be greater than 128 bytes, which is an overflow). So we simply panic here.
+	// will be greater than 128 bytes, which is an overflow). So we simply fail here.
 	if num.Sign() < 0 {
-		panic("negative value cannot be used in the circuit")
+		return nil, errors.New("negative value cannot be used in the circuit")
 	}
 
 	value := num.Bytes()
-	return append(make([]byte, ModBytes-len(value)), value...)
+	if len(value) > ModBytes {
+		return nil, errors.New("value exceeds " + strconv.Itoa(ModBytes) + " bytes")
+	}
+	return append(make([]byte, ModBytes-len(value)), value...), nil
 }
 
 // goConvertBalanceToBytes converts a GoBalance to bytes.
 func goConvertBalanceToBytes(balance GoBalance) (value []byte) {
-	if len(balance) != GetNumberOfAssets() {
-		panic(INVALID_BALANCE_LENGTH_MESSAGE)
+	value, err := GetConfig().goConvertBalanceToBytesWithError(balance)
+	if err != nil {
+		panic(err.Error())
 	}
+	return value
+}
 
-	value = make([]byte, 0)
+func (c Config) goConvertBalanceToBytesWithError(balance GoBalance) (value []byte, err error) {
+	if len(balance) != c.GetNumberOfAssets() {
+		return nil, errors.New(INVALID_BALANCE_LENGTH_MESSAGE)
+	}
+
+	value = make([]byte, 0, len(balance)*ModBytes)
 	for _, asset := range balance {
-		value = append(value, padToModBytes(asset)...)
+		paddedAsset, err := PadToModBytesWithError(asset)
+		if err != nil {
+			return nil, err
+		}
+		value = append(value, paddedAsset...)
+	}
+	return value, nil
+}
+
+// CanonicalAccountBytes returns the canonical byte layout of an account in the given version, which is what is hashed
+// to compute its leaf in the Merkle tree. In ACCOUNT_BYTES_VERSION_1, every value is a big-endian field element
+// left-padded with zeros to ModBytes (32) bytes:
+//
+//	WalletId || Balance[0] || Balance[1] || ... || Balance[GetNumberOfAssets()-1]
+//
+// with balances in the order of the asset registry. The leaf is H(WalletId, H(balances)), where H is the configured hash
+// function (MiMC by default, see SetHashFunction), WalletId is the first 32 bytes and balances are the remaining bytes
+// (a deployment domain, if any, is hashed before WalletId, followed by LEAF_HASH_TAG with tagged hash versions). The
+// salt of a salted account is not part of the canonical bytes, and its leaf is H(WalletId, Salt, H(balances)).
+// With LEAF_HASH_SHA256, the leaf is SHA-256 of the same values, each as 32 bytes, with the salt (if any) right after
+// WalletId, reduced modulo the scalar field. Accounts are hashed from the configured version (see
+// SetAccountBytesVersion). See GoComputeMiMCHashForAccount.
+func CanonicalAccountBytes(version AccountBytesVersion, account GoAccount) []byte {
+	accountBytes, err := CanonicalAccountBytesWithError(version, account)
+	if err != nil {
+		panic(err.Error())
+	}
+	return accountBytes
+}
+
+// CanonicalAccountBytesWithError returns the canonical byte layout of an account in the given version (see
+// CanonicalAccountBytes), or an error if the version is unknown or its WalletId or balances don't fit in it.
+func CanonicalAccountBytesWithError(version AccountBytesVersion, account GoAccount) ([]byte, error) {
+	config := GetConfig()
+	config.AccountBytesVersion = version
+	return config.canonicalAccountBytes(account)
+}
+
+// canonicalAccountBytes returns the canonical byte layout of an account in the account bytes version of the
+// configuration (see CanonicalAccountBytes), or an error if the version is unknown or its WalletId or balances don't
+// fit in it.
+func (c Config) canonicalAccountBytes(account GoAccount) ([]byte, error) {
+	if _, err := ParseAccountBytesVersion(int(c.AccountBytesVersion)); err != nil {
+		return nil, err
+	}
+	if len(account.WalletId) > ModBytes {
+		return nil, errors.New("WalletId exceeds " + strconv.Itoa(ModBytes) + " bytes")
+	}
+	balanceBytes, err := c.goConvertBalanceToBytesWithError(account.Balance)
+	if err != nil {
+		return nil, err
+	}
+	walletId := append(make([]byte, ModBytes-len(account.WalletId)), account.WalletId...)
+	return append(walletId, balanceBytes...), nil
+}
+
+// IsSalted returns whether the account has a non-zero salt.
+func (account GoAccount) IsSalted() bool {
+	return new(big.Int).SetBytes(account.Salt).Sign() != 0
+}
+
+// NewSalt returns a random salt for an account, i.e. a uniformly random non-zero field element.
+func NewSalt() []byte {
+	for {
+		salt, err := crand.Int(crand.Reader, ecc.BN254.ScalarField())
+		if err != nil {
+			panic("Error generating salt: " + err.Error())
+		}
+		if salt.Sign() != 0 {
+			return salt.FillBytes(make([]byte, ModBytes))
+		}
+	}
+}
+
+// SaltAccounts gives every unsalted account a new salt (see NewSalt). Salted accounts keep their salt.
+func SaltAccounts(accounts []GoAccount) {
+	for i := range accounts {
+		if !accounts[i].IsSalted() {
+			accounts[i].Salt = NewSalt()
+		}
+	}
+}
+
+// NewDummyAccount returns an account with a random WalletId and salt (see NewSalt) and a zero balance of every asset,
+// to pad a batch with so that its number of leaves does not reveal its number of accounts.
+func NewDummyAccount() GoAccount {
+	return GoAccount{
+		WalletId: new(big.Int).SetBytes(NewSalt()).Bytes(),
+		Balance:  ConstructGoBalance(),
+		Salt:     NewSalt(),
 	}
-	return value
 }
 
-// GoComputeMiMCHashForAccount computes the MiMC hash of the account's balance and user ID
-// and returns a consistent result with hashAccount in the circuit.
+// GoComputeMiMCHashForAccount computes the hash of the account's balance, user ID, and salt (if any) with the
+// configured hash function (MiMC by default, despite the name) and returns a consistent result with hashAccount in
+// the circuit.
 func GoComputeMiMCHashForAccount(account GoAccount) Hash {
-	hasher := mimc.NewMiMC()
+	return GetConfig().GoComputeMiMCHashForAccount(account)
+}
+
+// GoComputeMiMCHashForAccount computes the hash of the account like GoComputeMiMCHashForAccount, hashed as the
+// configuration hashes accounts.
+func (c Config) GoComputeMiMCHashForAccount(account GoAccount) Hash {
+	return c.goComputeHashForAccount(NewGoHasher(c.GetHashFunction()), account)
+}
+
+// GoComputeMiMCHashForAccountWithError computes the hash of the account (see GoComputeMiMCHashForAccount), or returns
+// an error if the account can't be hashed, e.g. because a balance is negative.
+func GoComputeMiMCHashForAccountWithError(account GoAccount) (Hash, error) {
+	return GetConfig().GoComputeMiMCHashForAccountWithError(account)
+}
+
+// GoComputeMiMCHashForAccountWithError computes the hash of the account like GoComputeMiMCHashForAccountWithError,
+// hashed as the configuration hashes accounts.
+func (c Config) GoComputeMiMCHashForAccountWithError(account GoAccount) (Hash, error) {
+	return c.goComputeHashForAccountWithError(NewGoHasher(c.GetHashFunction()), account)
+}
+
+// goComputeHashForAccount computes the hash of the account with the given hasher, which is reset first, so hash
+// workers can reuse their hasher for every account.
+func (c Config) goComputeHashForAccount(hasher hash.StateStorer, account GoAccount) Hash {
+	accountHash, err := c.goComputeHashForAccountWithError(hasher, account)
+	if err != nil {
+		panic(err.Error())
+	}
+	return accountHash
+}
+
+func (c Config) goComputeHashForAccountWithError(hasher hash.StateStorer, account GoAccount) (Hash, error) {
+	return c.goComputeTaggedHashForAccountWithError(hasher, account, LEAF_HASH_TAG)
+}
+
+// goComputeTaggedHashForAccountWithError computes the hash of the account like goComputeHashForAccountWithError, with
+// the given tag instead of LEAF_HASH_TAG, and returns a consistent result with hashAccountWithTag in the circuit.
+func (c Config) goComputeTaggedHashForAccountWithError(hasher hash.StateStorer, account GoAccount, tag int64) (Hash, error) {
+	if c.GetLeafHashMode() == LEAF_HASH_SHA256 {
+		return c.goComputeSHA256HashForAccountWithError(account, tag)
+	}
+	hasher.Reset()
+	accountBytes, err := c.canonicalAccountBytes(account)
+	if err != nil {
+		return nil, err
+	}
 
 	// hash balances
-	_, err := hasher.Write(goConvertBalanceToBytes(account.Balance))
+	_, err = hasher.Write(accountBytes[ModBytes:])
 	if err != nil {
-		panic("Error writing GoBalance bytes to hasher: " + err.Error())
+		return nil, errors.New("Error writing GoBalance bytes to hasher: " + err.Error())
 	}
 	balanceHash := hasher.Sum(nil)
 
-	// add walletId to hasher
+	// add deployment domain, leaf tag (if any), and walletId to hasher
 	hasher.Reset()
-	_, err = hasher.Write(account.WalletId)
+	if err := c.goWriteDeploymentDomain(hasher); err != nil {
+		return nil, errors.New("Error writing deployment domain to hasher: " + err.Error())
+	}
+	if err := c.goWriteHashTag(hasher, tag); err != nil {
+		return nil, errors.New("Error writing leaf tag to hasher: " + err.Error())
+	}
+	_, err = hasher.Write(accountBytes[:ModBytes])
 	if err != nil {
-		panic("Error writing WalletId to hasher: " + err.Error())
+		return nil, errors.New("Error writing WalletId to hasher: " + err.Error())
+	}
+
+	// add salt (if any) to hasher
+	if account.IsSalted() {
+		if len(account.Salt) > ModBytes {
+			return nil, errors.New("Salt exceeds " + strconv.Itoa(ModBytes) + " bytes")
+		}
+		_, err = hasher.Write(append(make([]byte, ModBytes-len(account.Salt)), account.Salt...))
+		if err != nil {
+			return nil, errors.New("Error writing Salt to hasher: " + err.Error())
+		}
 	}
 
 	// add balanceHash to hasher and return full hash
 	_, err = hasher.Write(balanceHash)
 	if err != nil {
-		panic("Error writing GoBalance hash to hasher: " + err.Error())
+		return nil, errors.New("Error writing GoBalance hash to hasher: " + err.Error())
+	}
+	return hasher.Sum(nil), nil
+}
+
+// goComputeSHA256HashForAccountWithError computes the leaf of the account with LEAF_HASH_SHA256, and returns a
+// consistent result with sha256HashAccount in the circuit: SHA-256 of the deployment domain element (if any), the tag
+// (with tagged hash versions), WalletId, Salt (if any), and balances, each as ModBytes big-endian bytes, reduced modulo
+// the scalar field.
+func (c Config) goComputeSHA256HashForAccountWithError(account GoAccount, tag int64) (Hash, error) {
+	accountBytes, err := c.canonicalAccountBytes(account)
+	if err != nil {
+		return nil, err
+	}
+	preimage := make([]byte, 0, len(accountBytes)+3*ModBytes)
+	if domain := c.GetDeploymentDomainElement(); domain != nil {
+		preimage = append(preimage, domain...)
+	}
+	if c.GetHashVersion().IsTagged() {
+		preimage = append(preimage, padToModBytes(big.NewInt(tag))...)
+	}
+	preimage = append(preimage, accountBytes[:ModBytes]...)
+	if account.IsSalted() {
+		if len(account.Salt) > ModBytes {
+			return nil, errors.New("Salt exceeds " + strconv.Itoa(ModBytes) + " bytes")
+		}
+		preimage = append(preimage, append(make([]byte, ModBytes-len(account.Salt)), account.Salt...)...)
+	}
+	preimage = append(preimage, accountBytes[ModBytes:]...)
+	digest := sha256.Sum256(preimage)
+	return padToModBytes(new(big.Int).Mod(new(big.Int).SetBytes(digest[:]), ecc.BN254.ScalarField())), nil
+}
+
+// GoComputeRootWithAssetSumHash computes the MerkleRootWithAssetSumHash of a proof, which binds its merkle root to its
+// asset sum and epoch, and returns a consistent result with hashRootWithAssetSum in the circuit. It is the hash of a
+// pseudo-account with the merkle root as WalletId, the asset sum as Balance, and the epoch as Salt (see EpochSalt),
+// under the deployment domain, so it is also the leaf of the proof in the tree of the next level proof (see
+// GoComputeHashForPseudoAccount). Every use of the binding must go through here.
+func GoComputeRootWithAssetSumHash(merkleRoot Hash, assetSum GoBalance, epoch uint64) Hash {
+	return GetConfig().GoComputeRootWithAssetSumHash(merkleRoot, assetSum, epoch)
+}
+
+// GoComputeRootWithAssetSumHash computes the MerkleRootWithAssetSumHash of a proof like GoComputeRootWithAssetSumHash,
+// hashed as the configuration hashes pseudo-accounts.
+func (c Config) GoComputeRootWithAssetSumHash(merkleRoot Hash, assetSum GoBalance, epoch uint64) Hash {
+	return c.GoComputeHashForPseudoAccount(GoAccount{WalletId: merkleRoot, Balance: assetSum, Salt: EpochSalt(epoch)})
+}
+
+// GoComputeHashForPseudoAccount computes the hash of the pseudo-account of a lower level proof, which is its leaf in
+// the upper level proof, and returns a consistent result with hashPseudoAccount in the circuit. It is hashed like an
+// account, but with ROOT_HASH_TAG instead of LEAF_HASH_TAG with HASH_VERSION_ROOT_TAGGED.
+func GoComputeHashForPseudoAccount(account GoAccount) Hash {
+	return GetConfig().GoComputeHashForPseudoAccount(account)
+}
+
+// GoComputeHashForPseudoAccount computes the hash of the pseudo-account like GoComputeHashForPseudoAccount, hashed as
+// the configuration hashes pseudo-accounts.
+func (c Config) GoComputeHashForPseudoAccount(account GoAccount) Hash {
+	accountHash, err := c.goComputeTaggedHashForAccountWithError(NewGoHasher(c.GetHashFunction()), account, int64(c.pseudoAccountHashTag()))
+	if err != nil {
+		panic(err.Error())
 	}
-	return hasher.Sum(nil)
+	return accountHash
 }
 
-// GoComputeMiMCHashesForAccounts computes the MiMC hash of each account in accounts and returns
-// them in a slice.
+// EpochSalt returns the salt of the pseudo-account of a proof bound to the epoch. Epoch zero gives no salt, so
+// proofs bound to no epoch hash like they did before epochs were introduced.
+func EpochSalt(epoch uint64) []byte {
+	if epoch == 0 {
+		return nil
+	}
+	return new(big.Int).SetUint64(epoch).FillBytes(make([]byte, ModBytes))
+}
+
+// GoComputeMiMCHashesForAccounts computes the hash of each account in accounts and returns
+// them in a slice. The accounts are hashed by the configured number of hash workers (see SetHashWorkers).
 func GoComputeMiMCHashesForAccounts(accounts []GoAccount) (hashes []Hash) {
-	hashes = make([]Hash, len(accounts))
-	for i, account := range accounts {
-		hashes[i] = GoComputeMiMCHashForAccount(account)
+	return GetConfig().GoComputeMiMCHashesForAccounts(accounts)
+}
+
+// GoComputeMiMCHashesForAccounts computes the hash of each account like GoComputeMiMCHashesForAccounts, hashed as the
+// configuration hashes accounts.
+func (c Config) GoComputeMiMCHashesForAccounts(accounts []GoAccount) (hashes []Hash) {
+	hashes, err := c.GoComputeMiMCHashesForAccountsWithError(accounts)
+	if err != nil {
+		panic(err.Error())
 	}
 	return hashes
 }
 
+// GoComputeMiMCHashesForAccountsWithError computes the hash of each account in accounts (see
+// GoComputeMiMCHashesForAccounts), or returns the error of the first account that can't be hashed.
+func GoComputeMiMCHashesForAccountsWithError(accounts []GoAccount) (hashes []Hash, err error) {
+	return GetConfig().GoComputeMiMCHashesForAccountsWithError(accounts)
+}
+
+// GoComputeMiMCHashesForAccountsWithError computes the hash of each account like
+// GoComputeMiMCHashesForAccountsWithError, hashed as the configuration hashes accounts.
+func (c Config) GoComputeMiMCHashesForAccountsWithError(accounts []GoAccount) (hashes []Hash, err error) {
+	return c.goComputeTaggedHashesForAccountsWithError(accounts, LEAF_HASH_TAG)
+}
+
+// goComputeTaggedHashesForAccountsWithError computes the hash of each account in accounts with the given tag (see
+// goComputeTaggedHashForAccountWithError) with the configured number of hash workers, or returns the error of the
+// first account that can't be hashed.
+func (c Config) goComputeTaggedHashesForAccountsWithError(accounts []GoAccount, tag int64) (hashes []Hash, err error) {
+	hashes = make([]Hash, len(accounts))
+	errs := make([]error, len(accounts))
+	goHashInParallel(c.GetHashFunction(), len(accounts), func(hasher hash.StateStorer, start, end int) {
+		for i := start; i < end; i++ {
+			hashes[i], errs[i] = c.goComputeTaggedHashForAccountWithError(hasher, accounts[i], tag)
+		}
+	})
+	return hashes, firstError(errs)
+}
+
+// GoComputeParentNodes computes the parent nodes of a layer of the Merkle tree, i.e. the hash of each pair of nodes,
+// with the configured number of hash workers (see SetHashWorkers).
+func GoComputeParentNodes(nodes []Hash) []Hash {
+	return GetConfig().GoComputeParentNodes(nodes)
+}
+
+// GoComputeParentNodes computes the parent nodes of a layer of the Merkle tree like GoComputeParentNodes, hashed as the
+// configuration hashes nodes.
+func (c Config) GoComputeParentNodes(nodes []Hash) []Hash {
+	parents, err := c.GoComputeParentNodesWithError(nodes)
+	if err != nil {
+		panic(err.Error())
+	}
+	return parents
+}
+
+// GoComputeParentNodesWithError computes the parent nodes of a layer of the Merkle tree (see GoComputeParentNodes),
+// or returns the error of the first pair of nodes that can't be hashed, e.g. because a node is not a field element.
+func GoComputeParentNodesWithError(nodes []Hash) ([]Hash, error) {
+	return GetConfig().GoComputeParentNodesWithError(nodes)
+}
+
+// GoComputeParentNodesWithError computes the parent nodes of a layer of the Merkle tree like
+// GoComputeParentNodesWithError, hashed as the configuration hashes nodes.
+func (c Config) GoComputeParentNodesWithError(nodes []Hash) ([]Hash, error) {
+	parents := make([]Hash, len(nodes)/2)
+	errs := make([]error, len(parents))
+	goHashInParallel(c.GetHashFunction(), len(parents), func(hasher hash.StateStorer, start, end int) {
+		for j := start; j < end; j++ {
+			parents[j], errs[j] = c.GoComputeHashOfTwoNodes(hasher, nodes[j*2], nodes[j*2+1], "node "+strconv.Itoa(j*2), "node "+strconv.Itoa(j*2+1))
+		}
+	})
+	return parents, firstError(errs)
+}
+
+// firstError returns the first non-nil error of errs, or nil if there is none.
+func firstError(errs []error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GoComputeHashOfTwoNodes computes the hash of two nodes of a Merkle tree with the hasher (of the configured hash
+// function, see NewGoHasher), which is reset first, and returns a consistent result with the nodes of
+// computeMerkleRootFromLeaves in the circuit. The labels name the nodes in errors.
 func GoComputeHashOfTwoNodes(hasher hash.StateStorer, node1, node2 Hash, label1, label2 string) (Hash, error) {
+	return GetConfig().GoComputeHashOfTwoNodes(hasher, node1, node2, label1, label2)
+}
+
+// GoComputeHashOfTwoNodes computes the hash of two nodes like GoComputeHashOfTwoNodes, hashed as the configuration
+// hashes nodes, with a hasher of its hash function.
+func (c Config) GoComputeHashOfTwoNodes(hasher hash.StateStorer, node1, node2 Hash, label1, label2 string) (Hash, error) {
 	hasher.Reset()
+	if err := c.goWriteDeploymentDomain(hasher); err != nil {
+		return nil, fmt.Errorf("error writing deployment domain to hasher: %w", err)
+	}
+	if err := c.goWriteHashTag(hasher, NODE_HASH_TAG); err != nil {
+		return nil, fmt.Errorf("error writing node tag to hasher: %w", err)
+	}
 	_, err := hasher.Write(node1)
 	if err != nil {
 		return nil, fmt.Errorf("error writing %s to hasher: %w", label1, err)
@@ -96,15 +540,23 @@ func GoComputeHashOfTwoNodes(hasher hash.StateStorer, node1, node2 Hash, label1,
 	return hasher.Sum(nil), nil
 }
 
-// goComputeMerkleRootFromHashes computes the MiMC Merkle root from a list of hashes,
+// goComputeMerkleRootFromHashes computes the Merkle root from a list of hashes,
 // given a particular treeDepth.
 func goComputeMerkleRootFromHashes(hashes []Hash, treeDepth int) (rootHash Hash) {
+	config := GetConfig()
+	config.TreeDepth = treeDepth
+	return config.GoComputeMerkleRootFromHashes(hashes)
+}
+
+func (c Config) goComputeMerkleRootFromHashesWithError(hashes []Hash) (rootHash Hash, err error) {
+	treeDepth := c.TreeDepth
+
 	// preliminary checks
 	if treeDepth < 0 {
-		panic("tree depth must be greater than 0")
+		return nil, errors.New("tree depth must be greater than 0")
 	}
 	if len(hashes) > PowOfTwo(treeDepth) {
-		panic(MERKLE_TREE_LEAF_LIMIT_EXCEEDED_MESSAGE)
+		return nil, errors.New(MERKLE_TREE_LEAF_LIMIT_EXCEEDED_MESSAGE)
 	}
 
 	// store hashes of accounts (pad with 0's to reach 2^treeDepth nodes)
@@ -118,48 +570,103 @@ func goComputeMerkleRootFromHashes(hashes []Hash, treeDepth int) (rootHash Hash)
 	}
 
 	// iteratively calculate hashes of parent nodes from bottom level to root
-	hasher := mimc.NewMiMC()
 	for i := treeDepth - 1; i >= 0; i-- {
-		for j := 0; j < PowOfTwo(i); j++ {
-			hasher.Reset()
-			_, err := hasher.Write(nodes[j*2])
-			if err != nil {
-				panic("Error writing node " + strconv.Itoa(j*2) + " to hasher: " + err.Error())
-			}
-			_, err = hasher.Write(nodes[j*2+1])
-			if err != nil {
-				panic("Error writing node " + strconv.Itoa(j*2+1) + " to hasher: " + err.Error())
-			}
-			nodes[j] = hasher.Sum(nil)
+		if nodes, err = c.GoComputeParentNodesWithError(nodes); err != nil {
+			return nil, err
 		}
 	}
-	return nodes[0]
+	return nodes[0], nil
 }
 
-// GoComputeMerkleRootFromHashes computes the MiMC Merkle root from a list of hashes,
-// assuming Merkle Tree of depth TREE_DEPTH.
+// GoComputeMerkleRootFromHashes computes the Merkle root from a list of hashes,
+// assuming Merkle Tree of the configured depth (see SetConfig).
 func GoComputeMerkleRootFromHashes(hashes []Hash) (rootHash Hash) {
-	return goComputeMerkleRootFromHashes(hashes, TREE_DEPTH)
+	return GetConfig().GoComputeMerkleRootFromHashes(hashes)
+}
+
+// GoComputeMerkleRootFromHashes computes the Merkle root from a list of hashes like GoComputeMerkleRootFromHashes,
+// assuming a Merkle Tree of the depth of the configuration.
+func (c Config) GoComputeMerkleRootFromHashes(hashes []Hash) (rootHash Hash) {
+	rootHash, err := c.goComputeMerkleRootFromHashesWithError(hashes)
+	if err != nil {
+		panic(err.Error())
+	}
+	return rootHash
+}
+
+// GoComputeMerkleRootFromHashesWithError computes the Merkle root from a list of hashes (see
+// GoComputeMerkleRootFromHashes), or returns an error if there are too many hashes or a hash is not a field element.
+func GoComputeMerkleRootFromHashesWithError(hashes []Hash) (rootHash Hash, err error) {
+	return GetConfig().GoComputeMerkleRootFromHashesWithError(hashes)
+}
+
+// GoComputeMerkleRootFromHashesWithError computes the Merkle root from a list of hashes like
+// GoComputeMerkleRootFromHashesWithError, assuming a Merkle Tree of the depth of the configuration.
+func (c Config) GoComputeMerkleRootFromHashesWithError(hashes []Hash) (rootHash Hash, err error) {
+	return c.goComputeMerkleRootFromHashesWithError(hashes)
 }
 
 // GoComputeMerkleRootFromAccounts computes the Merkle root from a list of accounts.
 // It returns a consistent result with computeMerkleRootFromAccounts in the circuit.
 func GoComputeMerkleRootFromAccounts(accounts []GoAccount) (rootHash Hash) {
-	return GoComputeMerkleRootFromHashes(GoComputeMiMCHashesForAccounts(accounts))
+	return GetConfig().GoComputeMerkleRootFromAccounts(accounts)
+}
+
+// GoComputeMerkleRootFromAccounts computes the Merkle root from a list of accounts like
+// GoComputeMerkleRootFromAccounts, with the tree depth and hashing of the configuration.
+func (c Config) GoComputeMerkleRootFromAccounts(accounts []GoAccount) (rootHash Hash) {
+	return c.GoComputeMerkleRootFromHashes(c.GoComputeMiMCHashesForAccounts(accounts))
+}
+
+// GoComputeMerkleRootFromAccountsWithError computes the Merkle root from a list of accounts (see
+// GoComputeMerkleRootFromAccounts), or returns an error if an account can't be hashed or there are too many accounts.
+func GoComputeMerkleRootFromAccountsWithError(accounts []GoAccount) (rootHash Hash, err error) {
+	return GetConfig().GoComputeMerkleRootFromAccountsWithError(accounts)
+}
+
+// GoComputeMerkleRootFromAccountsWithError computes the Merkle root from a list of accounts like
+// GoComputeMerkleRootFromAccountsWithError, with the tree depth and hashing of the configuration.
+func (c Config) GoComputeMerkleRootFromAccountsWithError(accounts []GoAccount) (rootHash Hash, err error) {
+	hashes, err := c.GoComputeMiMCHashesForAccountsWithError(accounts)
+	if err != nil {
+		return nil, err
+	}
+	return c.GoComputeMerkleRootFromHashesWithError(hashes)
+}
+
+// GoPaddingLeaf returns the leaf of the positions of a Merkle tree past its accounts (or lower level proofs).
+func GoPaddingLeaf() Hash {
+	return padToModBytes(big.NewInt(0))
 }
 
 func goComputeMerkleTreeNodesFromHashes(hashes []Hash, treeDepth int) [][]Hash {
+	config := GetConfig()
+	config.TreeDepth = treeDepth
+	return config.goComputeMerkleTreeNodesFromHashes(hashes)
+}
+
+func (c Config) goComputeMerkleTreeNodesFromHashes(hashes []Hash) [][]Hash {
+	nodes, err := c.goComputeMerkleTreeNodesFromHashesWithError(hashes)
+	if err != nil {
+		panic(err.Error())
+	}
+	return nodes
+}
+
+func (c Config) goComputeMerkleTreeNodesFromHashesWithError(hashes []Hash) (nodes [][]Hash, err error) {
+	treeDepth := c.TreeDepth
+
 	// preliminary checks
 	if treeDepth < 0 {
-		panic("tree depth must be greater than 0")
+		return nil, errors.New("tree depth must be greater than 0")
 	}
 	if len(hashes) > PowOfTwo(treeDepth) {
-		panic(MERKLE_TREE_LEAF_LIMIT_EXCEEDED_MESSAGE)
+		return nil, errors.New(MERKLE_TREE_LEAF_LIMIT_EXCEEDED_MESSAGE)
 	}
 
 	// create [][]Hash to store all internal nodes
 	// nodes[i] will represent the hashes of all the nodes at depth i
-	nodes := make([][]Hash, treeDepth+1)
+	nodes = make([][]Hash, treeDepth+1)
 
 	// at bottom layer, store hashes of accounts (pad with 0's to reach 2^treeDepth nodes)
 	nodes[treeDepth] = make([]Hash, PowOfTwo(treeDepth))
@@ -167,47 +674,92 @@ func goComputeMerkleTreeNodesFromHashes(hashes []Hash, treeDepth int) [][]Hash {
 		if i < len(hashes) {
 			nodes[treeDepth][i] = hashes[i]
 		} else {
-			nodes[treeDepth][i] = padToModBytes(big.NewInt(0))
+			nodes[treeDepth][i] = GoPaddingLeaf()
 		}
 	}
 
 	// iteratively calculate hashes of parent nodes from bottom level to root
-	hasher := mimc.NewMiMC()
 	for i := treeDepth - 1; i >= 0; i-- {
-		nodes[i] = make([]Hash, PowOfTwo(i))
-		for j := 0; j < PowOfTwo(i); j++ {
-			hasher.Reset()
-			_, err := hasher.Write(nodes[i+1][j*2])
-			if err != nil {
-				panic("Error writing node " + strconv.Itoa(j*2) + " to hasher: " + err.Error())
-			}
-			_, err = hasher.Write(nodes[i+1][j*2+1])
-			if err != nil {
-				panic("Error writing node " + strconv.Itoa(j*2+1) + " to hasher: " + err.Error())
-			}
-			nodes[i][j] = hasher.Sum(nil)
+		if nodes[i], err = c.GoComputeParentNodesWithError(nodes[i+1]); err != nil {
+			return nil, err
 		}
 	}
-	return nodes
+	return nodes, nil
 }
 
+// GoComputeMerkleTreeNodesFromAccounts computes every node of the Merkle tree of the accounts, assuming a Merkle Tree
+// of the configured depth (see SetConfig).
 func GoComputeMerkleTreeNodesFromAccounts(accounts []GoAccount) [][]Hash {
-	return goComputeMerkleTreeNodesFromHashes(GoComputeMiMCHashesForAccounts(accounts), TREE_DEPTH)
+	return GetConfig().GoComputeMerkleTreeNodesFromAccounts(accounts)
+}
+
+// GoComputeMerkleTreeNodesFromAccounts computes every node of the Merkle tree of the accounts like
+// GoComputeMerkleTreeNodesFromAccounts, with the tree depth and hashing of the configuration.
+func (c Config) GoComputeMerkleTreeNodesFromAccounts(accounts []GoAccount) [][]Hash {
+	return c.goComputeMerkleTreeNodesFromHashes(c.GoComputeMiMCHashesForAccounts(accounts))
+}
+
+// GoComputeMerkleTreeNodesFromAccountsWithError computes every node of the Merkle tree of the accounts (see
+// GoComputeMerkleTreeNodesFromAccounts), or returns an error if an account can't be hashed or there are too many
+// accounts.
+func GoComputeMerkleTreeNodesFromAccountsWithError(accounts []GoAccount) ([][]Hash, error) {
+	return GetConfig().GoComputeMerkleTreeNodesFromAccountsWithError(accounts)
+}
+
+// GoComputeMerkleTreeNodesFromAccountsWithError computes every node of the Merkle tree of the accounts like
+// GoComputeMerkleTreeNodesFromAccountsWithError, with the tree depth and hashing of the configuration.
+func (c Config) GoComputeMerkleTreeNodesFromAccountsWithError(accounts []GoAccount) ([][]Hash, error) {
+	hashes, err := c.GoComputeMiMCHashesForAccountsWithError(accounts)
+	if err != nil {
+		return nil, err
+	}
+	return c.goComputeMerkleTreeNodesFromHashesWithError(hashes)
+}
+
+// GoComputeMerkleTreeNodesFromPseudoAccounts computes every node of the Merkle tree of the pseudo-accounts of lower
+// level proofs (see GoComputeHashForPseudoAccount), i.e. of an upper level proof, assuming a Merkle Tree of the
+// configured depth (see SetConfig). It returns a consistent result with computeMerkleRootFromAccounts in the circuit of
+// the upper level proof (see Config.UpperLevel).
+func GoComputeMerkleTreeNodesFromPseudoAccounts(accounts []GoAccount) [][]Hash {
+	return GetConfig().GoComputeMerkleTreeNodesFromPseudoAccounts(accounts)
+}
+
+// GoComputeMerkleTreeNodesFromPseudoAccounts computes every node of the Merkle tree of the pseudo-accounts like
+// GoComputeMerkleTreeNodesFromPseudoAccounts, with the tree depth and hashing of the configuration.
+func (c Config) GoComputeMerkleTreeNodesFromPseudoAccounts(accounts []GoAccount) [][]Hash {
+	hashes, err := c.goComputeTaggedHashesForAccountsWithError(accounts, int64(c.pseudoAccountHashTag()))
+	if err != nil {
+		panic(err.Error())
+	}
+	return c.goComputeMerkleTreeNodesFromHashes(hashes)
 }
 
 // ComputeMerklePath computes the MerklePath of a hash at a particular bottom level position in a group
 // of merkle nodes for a merkle tree.
 func ComputeMerklePath(position int, nodes [][]Hash) []Hash {
+	path, err := ComputeMerklePathWithError(position, nodes)
+	if err != nil {
+		panic(err.Error())
+	}
+	return path
+}
+
+// ComputeMerklePathWithError computes the MerklePath of a hash (see ComputeMerklePath), or returns an error if the
+// position is out of bounds or the merkle nodes are not those of a merkle tree.
+func ComputeMerklePathWithError(position int, nodes [][]Hash) ([]Hash, error) {
 	treeDepth := len(nodes) - 1
+	if treeDepth < 0 {
+		return nil, errors.New("merkle nodes provided are empty")
+	}
 	if position < 0 || position >= PowOfTwo(treeDepth) {
-		panic("position is out of bounds - should be in range 0 to " + strconv.Itoa(PowOfTwo(treeDepth)-1) + " inclusive")
+		return nil, errors.New("position is out of bounds - should be in range 0 to " + strconv.Itoa(PowOfTwo(treeDepth)-1) + " inclusive")
 	}
 
 	path := make([]Hash, 0, treeDepth)
 	currPos := position
 	for i := treeDepth; i > 0; i-- {
 		if len(nodes[i]) != PowOfTwo(i) {
-			panic("merkle nodes provided are not of correct structure - there should be " + strconv.Itoa(PowOfTwo(i)) + " nodes in layer " + strconv.Itoa(i))
+			return nil, errors.New("merkle nodes provided are not of correct structure - there should be " + strconv.Itoa(PowOfTwo(i)) + " nodes in layer " + strconv.Itoa(i))
 		}
 
 		// get the sibling of the node at index currPos in the current layer (if even, sibling right after, else right before)
@@ -221,27 +773,152 @@ func ComputeMerklePath(position int, nodes [][]Hash) []Hash {
 		currPos /= 2
 	}
 
+	return path, nil
+}
+
+// ComputeMerklePathElements computes the MerklePath of a hash at a particular bottom level position (see
+// ComputeMerklePath), with the side of each sibling, so the path describes the position itself.
+func ComputeMerklePathElements(position int, nodes [][]Hash) []MerklePathElement {
+	path, err := ComputeMerklePathElementsWithError(position, nodes)
+	if err != nil {
+		panic(err.Error())
+	}
+	return path
+}
+
+// ComputeMerklePathElementsWithError computes the MerklePath of a hash with the side of each sibling (see
+// ComputeMerklePathElements), or returns an error if the position is out of bounds or the merkle nodes are not those
+// of a merkle tree.
+func ComputeMerklePathElementsWithError(position int, nodes [][]Hash) ([]MerklePathElement, error) {
+	siblings, err := ComputeMerklePathWithError(position, nodes)
+	if err != nil {
+		return nil, err
+	}
+	path := make([]MerklePathElement, len(siblings))
+	currPos := position
+	for i, sibling := range siblings {
+		// the sibling of an odd node is on its left
+		path[i] = MerklePathElement{Sibling: sibling, IsLeft: currPos%2 == 1}
+		currPos /= 2
+	}
+	return path, nil
+}
+
+// GetMerklePathElementsPosition returns the bottom level position of the leaf of a merkle path with the side of each
+// sibling, which the sides encode bit by bit.
+func GetMerklePathElementsPosition(path []MerklePathElement) int {
+	position := 0
+	for i := len(path) - 1; i >= 0; i-- {
+		position *= 2
+		if path[i].IsLeft {
+			position++
+		}
+	}
+	return position
+}
+
+// ComputeMerkleMultiPath computes the merkle multi-path of the hashes at several bottom level positions in a group of
+// merkle nodes for a merkle tree: the siblings needed to compute the root from all of those hashes at once. Siblings
+// shared between the paths, or computable from the hashes themselves, are only included once (or not at all), so it is
+// much smaller than the paths of every position. Siblings are ordered from the bottom layer up and by increasing
+// position within a layer, which is the order in which VerifyMerkleMultiPath (in core) consumes them.
+func ComputeMerkleMultiPath(positions []int, nodes [][]Hash) []Hash {
+	path, err := ComputeMerkleMultiPathWithError(positions, nodes)
+	if err != nil {
+		panic(err.Error())
+	}
 	return path
 }
 
+// ComputeMerkleMultiPathWithError computes the merkle multi-path of several hashes (see ComputeMerkleMultiPath), or
+// returns an error if there are no positions, a position is out of bounds or repeated, or the merkle nodes are not
+// those of a merkle tree.
+func ComputeMerkleMultiPathWithError(positions []int, nodes [][]Hash) ([]Hash, error) {
+	treeDepth := len(nodes) - 1
+	if treeDepth < 0 {
+		return nil, errors.New("merkle nodes provided are empty")
+	}
+	currPositions, err := SortMerkleMultiPathPositions(positions, treeDepth)
+	if err != nil {
+		return nil, err
+	}
+
+	path := make([]Hash, 0)
+	for i := treeDepth; i > 0; i-- {
+		if len(nodes[i]) != PowOfTwo(i) {
+			return nil, errors.New("merkle nodes provided are not of correct structure - there should be " + strconv.Itoa(PowOfTwo(i)) + " nodes in layer " + strconv.Itoa(i))
+		}
+
+		parentPositions := make([]int, 0, len(currPositions))
+		for j := 0; j < len(currPositions); j++ {
+			currPos := currPositions[j]
+			if currPos%2 == 0 && j+1 < len(currPositions) && currPositions[j+1] == currPos+1 {
+				// the sibling is also known, so it is not part of the path
+				j++
+			} else {
+				// get the sibling of the node (if even, sibling right after, else right before)
+				path = append(path, nodes[i][currPos^1])
+			}
+			parentPositions = append(parentPositions, currPos/2)
+		}
+		currPositions = parentPositions
+	}
+
+	return path, nil
+}
+
+// SortMerkleMultiPathPositions returns the positions of a merkle multi-path in increasing order, or returns an error if
+// there are none, or one is out of bounds for a merkle tree of the given depth or repeated.
+func SortMerkleMultiPathPositions(positions []int, treeDepth int) ([]int, error) {
+	if len(positions) == 0 {
+		return nil, errors.New("no positions provided")
+	}
+	sorted := slices.Clone(positions)
+	slices.Sort(sorted)
+	for i, position := range sorted {
+		if position < 0 || position >= PowOfTwo(treeDepth) {
+			return nil, errors.New("position is out of bounds - should be in range 0 to " + strconv.Itoa(PowOfTwo(treeDepth)-1) + " inclusive")
+		}
+		if i > 0 && sorted[i-1] == position {
+			return nil, errors.New("position " + strconv.Itoa(position) + " is repeated")
+		}
+	}
+	return sorted, nil
+}
+
 // ConvertGoBalanceToBalance converts a GoBalance to a Balance immediately before inclusion in the circuit.
 func ConvertGoBalanceToBalance(goBalance GoBalance) Balance {
+	balance, err := ConvertGoBalanceToBalanceWithError(goBalance)
+	if err != nil {
+		panic(err.Error())
+	}
+	return balance
+}
+
+// ConvertGoBalanceToBalanceWithError converts a GoBalance to a Balance (see ConvertGoBalanceToBalance), or returns an
+// error if it does not have a balance for every asset or a balance is negative.
+func ConvertGoBalanceToBalanceWithError(goBalance GoBalance) (Balance, error) {
 	if len(goBalance) != GetNumberOfAssets() {
-		panic(INVALID_BALANCE_LENGTH_MESSAGE)
+		return nil, errors.New(INVALID_BALANCE_LENGTH_MESSAGE)
 	}
 
 	balance := make(Balance, GetNumberOfAssets())
 	for i, asset := range goBalance {
-		balance[i] = padToModBytes(asset)
+		paddedAsset, err := PadToModBytesWithError(asset)
+		if err != nil {
+			return nil, err
+		}
+		balance[i] = paddedAsset
 	}
-	return balance
+	return balance, nil
 }
 
 // ConvertGoAccountToAccount converts a GoAccount to an Account immediately before inclusion in the circuit.
 func convertGoAccountToAccount(goAccount GoAccount) Account {
 	return Account{
-		WalletId:  new(big.Int).SetBytes(goAccount.WalletId),
-		Balance: ConvertGoBalanceToBalance(goAccount.Balance),
+		WalletId: new(big.Int).SetBytes(goAccount.WalletId),
+		Balance:  ConvertGoBalanceToBalance(goAccount.Balance),
+		Salt:     new(big.Int).SetBytes(goAccount.Salt),
 	}
 }
 
@@ -253,58 +930,129 @@ func ConvertGoAccountsToAccounts(goAccounts []GoAccount) (accounts []Account) {
 	return accounts
 }
 
-// Convert raw WalletId string to a []byte by removing any hyphens, interpreting it as
-// a base36 number, and then converting that number to a []byte. If this is used to
-// get the GoAccount.WalletId, the walletId should not exceed BN254 curve limit as long
-// as the string is less than 49 characters in length.
-func convertRawWalletIdToBytes(walletId string) []byte {
-	// remove any hyphens from user id
-	cleanedWalletId := strings.ReplaceAll(walletId, "-", "")
+// convertRawSaltToBytes decodes a hex encoded salt (nil for the empty salt of unsalted accounts).
+func convertRawSaltToBytes(salt string) []byte {
+	if salt == "" {
+		return nil
+	}
+	decoded, err := hex.DecodeString(salt)
+	if err != nil {
+		panic("failed to decode salt from hex: " + err.Error())
+	}
+	return decoded
+}
 
-	// convert to bytes (interpreted as a base36 string)
-	n := new(big.Int)
-	_, ok := n.SetString(cleanedWalletId, 36)
-	if !ok {
-		panic("failed to convert walletId to big.Int from base36: " + cleanedWalletId)
+// UnmarshalJSON decodes a RawGoAccount, whose balances can either be integers in base units (as they are written) or
+// strings in display units, optionally followed by the symbol of the asset (e.g. "1.25 BTC", see ParseAssetAmount),
+// which are normalized to base units with the decimals of the asset registry.
+func (a *RawGoAccount) UnmarshalJSON(data []byte) error {
+	type rawGoAccount RawGoAccount
+	var decoded struct {
+		rawGoAccount
+		Balance []json.RawMessage
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*a = RawGoAccount(decoded.rawGoAccount)
+	a.Balance = nil
+	if decoded.Balance == nil {
+		return nil
+	}
+	a.Balance = make(GoBalance, len(decoded.Balance))
+	for i, value := range decoded.Balance {
+		var amount string
+		if err := json.Unmarshal(value, &amount); err != nil {
+			// not a string, so an amount in base units
+			a.Balance[i] = new(big.Int)
+			if err := json.Unmarshal(value, a.Balance[i]); err != nil {
+				return fmt.Errorf("invalid balance %d of account %s: %w", i, a.WalletId, err)
+			}
+			continue
+		}
+		baseUnits, err := ParseAssetAmount(amount, i)
+		if err != nil {
+			return fmt.Errorf("invalid balance %d of account %s: %w", i, a.WalletId, err)
+		}
+		a.Balance[i] = baseUnits
 	}
-	return n.Bytes()
+	return nil
 }
 
-// Converts a RawGoAccount (read from json file) to a GoAccount
+// Converts a RawGoAccount (read from json file) to a GoAccount, with the configured WalletId encoding (see
+// SetWalletIdEncoding).
 func ConvertRawGoAccountToGoAccount(rawAccount RawGoAccount) GoAccount {
+	return ConvertRawGoAccountToGoAccountWithEncoding(rawAccount, GetWalletIdEncoding())
+}
+
+// ConvertRawGoAccountToGoAccountWithEncoding converts a RawGoAccount to a GoAccount, with the WalletId encoding of
+// the file it was read from.
+func ConvertRawGoAccountToGoAccountWithEncoding(rawAccount RawGoAccount, encoding WalletIdEncoding) GoAccount {
+	var walletId []byte
+	if rawAccount.HashedWalletId {
+		walletId = convertHashedWalletIdToBytes(rawAccount.WalletId)
+	} else {
+		walletId = encoding.convertRawWalletIdToBytes(rawAccount.WalletId)
+	}
 	return GoAccount{
-		WalletId:  convertRawWalletIdToBytes(rawAccount.WalletId),
-		Balance: rawAccount.Balance,
+		WalletId: walletId,
+		Balance:  rawAccount.Balance,
+		Salt:     convertRawSaltToBytes(rawAccount.Salt),
 	}
 }
 
-// Converts a GoAccount to a RawGoAccount properly (for writing to json file)
+// Converts a GoAccount to a RawGoAccount properly (for writing to json file), with the configured WalletId encoding.
 func ConvertGoAccountToRawGoAccount(goAccount GoAccount) RawGoAccount {
-	return RawGoAccount{
-		WalletId:  new(big.Int).SetBytes(goAccount.WalletId).Text(36),
-		Balance: goAccount.Balance,
+	return ConvertGoAccountToRawGoAccountWithEncoding(goAccount, GetWalletIdEncoding())
+}
+
+// ConvertGoAccountToRawGoAccountWithEncoding converts a GoAccount to a RawGoAccount, with the WalletId encoding of the
+// file it is written to.
+func ConvertGoAccountToRawGoAccountWithEncoding(goAccount GoAccount, encoding WalletIdEncoding) RawGoAccount {
+	walletId, hashed := encoding.convertBytesToRawWalletId(goAccount.WalletId)
+	rawAccount := RawGoAccount{
+		WalletId:       walletId,
+		Balance:        goAccount.Balance,
+		HashedWalletId: hashed,
+	}
+	if goAccount.IsSalted() {
+		rawAccount.Salt = hex.EncodeToString(goAccount.Salt)
 	}
+	return rawAccount
 }
 
 func ConvertRawGoAccountsToGoAccounts(rawAccounts []RawGoAccount) []GoAccount {
+	return ConvertRawGoAccountsToGoAccountsWithEncoding(rawAccounts, GetWalletIdEncoding())
+}
+
+func ConvertRawGoAccountsToGoAccountsWithEncoding(rawAccounts []RawGoAccount, encoding WalletIdEncoding) []GoAccount {
 	accounts := make([]GoAccount, len(rawAccounts))
 	for i, rawAccount := range rawAccounts {
-		accounts[i] = ConvertRawGoAccountToGoAccount(rawAccount)
+		accounts[i] = ConvertRawGoAccountToGoAccountWithEncoding(rawAccount, encoding)
 	}
 	return accounts
 }
 
 func ConvertGoAccountsToRawGoAccounts(accounts []GoAccount) []RawGoAccount {
+	return ConvertGoAccountsToRawGoAccountsWithEncoding(accounts, GetWalletIdEncoding())
+}
+
+func ConvertGoAccountsToRawGoAccountsWithEncoding(accounts []GoAccount, encoding WalletIdEncoding) []RawGoAccount {
 	rawAccounts := make([]RawGoAccount, len(accounts))
 	for i, account := range accounts {
-		rawAccounts[i] = ConvertGoAccountToRawGoAccount(account)
+		rawAccounts[i] = ConvertGoAccountToRawGoAccountWithEncoding(account, encoding)
 	}
 	return rawAccounts
 }
 
 // Util to construct GoBalance.
 func ConstructGoBalance(initialBalances ...*big.Int) GoBalance {
-	balances := make(GoBalance, GetNumberOfAssets())
+	return GetConfig().ConstructGoBalance(initialBalances...)
+}
+
+// ConstructGoBalance constructs a GoBalance like ConstructGoBalance, with the number of assets of the configuration.
+func (c Config) ConstructGoBalance(initialBalances ...*big.Int) GoBalance {
+	balances := make(GoBalance, c.GetNumberOfAssets())
 	for i := range balances {
 		if i < len(initialBalances) {
 			balances[i] = initialBalances[i]
@@ -315,27 +1063,166 @@ func ConstructGoBalance(initialBalances ...*big.Int) GoBalance {
 	return balances
 }
 
+// ConstructGoBalanceFromMap constructs a GoBalance from amounts keyed by asset symbol, placing each amount at the index
+// of its asset (see GetAssetSymbols). Assets without an amount have a zero balance. It panics on a symbol that is not
+// an asset of the balances.
+func ConstructGoBalanceFromMap(amounts map[string]*big.Int) GoBalance {
+	balance, err := ConstructGoBalanceFromMapWithError(amounts)
+	if err != nil {
+		panic(err.Error())
+	}
+	return balance
+}
+
+// ConstructGoBalanceFromMapWithError is ConstructGoBalanceFromMap, but returns an error on a symbol that is not an
+// asset of the balances, or on a nil amount.
+func ConstructGoBalanceFromMapWithError(amounts map[string]*big.Int) (GoBalance, error) {
+	indices := make(map[string]int)
+	for i, symbol := range GetAssetSymbols() {
+		indices[symbol] = i
+	}
+	balance := ConstructGoBalance()
+	for _, symbol := range slices.Sorted(maps.Keys(amounts)) {
+		index, ok := indices[symbol]
+		if !ok {
+			return nil, fmt.Errorf("%q is not an asset of the balances", symbol)
+		}
+		if amounts[symbol] == nil {
+			return nil, fmt.Errorf("amount of %s is nil", symbol)
+		}
+		balance[index] = amounts[symbol]
+	}
+	return balance, nil
+}
+
 // SumGoAccountBalances sums the balances of a list of GoAccounts and panics on negative functions.
 // This panic is because any circuit that is passed negative balances will violate constraints.
 func SumGoAccountBalances(accounts []GoAccount) GoBalance {
-	assetSum := ConstructGoBalance()
+	assetSum, err := SumGoAccountBalancesWithError(accounts)
+	if err != nil {
+		panic(err.Error())
+	}
+	return assetSum
+}
+
+// SumGoAccountBalancesWithError sums the balances of a list of GoAccounts (see SumGoAccountBalances), or returns an
+// error if an account does not have a balance for every asset or has a negative balance.
+func SumGoAccountBalancesWithError(accounts []GoAccount) (GoBalance, error) {
+	return GetConfig().SumGoAccountBalancesWithError(accounts)
+}
+
+// SumGoAccountBalancesWithError sums the balances of a list of GoAccounts like SumGoAccountBalancesWithError, with the
+// number of assets of the configuration.
+func (c Config) SumGoAccountBalancesWithError(accounts []GoAccount) (GoBalance, error) {
+	assetSum := c.ConstructGoBalance()
 	for _, account := range accounts {
-		if len(account.Balance) != GetNumberOfAssets() {
-			panic(INVALID_BALANCE_LENGTH_MESSAGE)
+		if len(account.Balance) != c.GetNumberOfAssets() {
+			return nil, errors.New(INVALID_BALANCE_LENGTH_MESSAGE)
 		}
 		for i, asset := range account.Balance {
 			if asset.Sign() == -1 {
-				panic("negative asset balance found")
+				return nil, errors.New("negative asset balance found")
 			}
 			assetSum[i].Add(assetSum[i], asset)
 		}
 	}
-	return assetSum
+	return assetSum, nil
+}
+
+// AggregateAccountsByUserId merges the accounts of each user (the accounts with the same WalletId, e.g. the
+// sub-wallets of the user) into one account whose balance is the sum of theirs, so every user is a single leaf.
+// Accounts are returned in the order of the first account of each user. A merged account keeps the salt of the first
+// salted account of its user (or is unsalted if none is).
+func AggregateAccountsByUserId(accounts []GoAccount) []GoAccount {
+	aggregated, err := AggregateAccountsByUserIdWithError(accounts)
+	if err != nil {
+		panic(err.Error())
+	}
+	return aggregated
+}
+
+// AggregateAccountsByUserIdWithError merges the accounts of each user into one account (see
+// AggregateAccountsByUserId), or returns an error if an account does not have a balance for every asset.
+func AggregateAccountsByUserIdWithError(accounts []GoAccount) ([]GoAccount, error) {
+	return GetConfig().AggregateAccountsByUserIdWithError(accounts)
+}
+
+// AggregateAccountsByUserIdWithError merges the accounts of each user into one account like
+// AggregateAccountsByUserIdWithError, with the number of assets of the configuration.
+func (c Config) AggregateAccountsByUserIdWithError(accounts []GoAccount) ([]GoAccount, error) {
+	positions := make(map[string]int, len(accounts))
+	aggregated := make([]GoAccount, 0, len(accounts))
+	for _, account := range accounts {
+		if len(account.Balance) != c.GetNumberOfAssets() {
+			return nil, errors.New(INVALID_BALANCE_LENGTH_MESSAGE)
+		}
+		i, ok := positions[string(account.WalletId)]
+		if !ok {
+			positions[string(account.WalletId)] = len(aggregated)
+			aggregated = append(aggregated, GoAccount{WalletId: account.WalletId, Balance: account.Balance, Salt: account.Salt})
+			continue
+		}
+		balance, err := c.AddGoBalances(aggregated[i].Balance, account.Balance)
+		if err != nil {
+			return nil, err
+		}
+		aggregated[i].Balance = balance
+		if !aggregated[i].IsSalted() {
+			aggregated[i].Salt = account.Salt
+		}
+	}
+	return aggregated, nil
+}
+
+// TestDataProfile describes the distribution of the accounts generated by GenerateTestDataWithProfile.
+type TestDataProfile struct {
+	// MaxBalance bounds the balances (exclusive).
+	MaxBalance int64
+	// PowerLawExponent makes balances follow a power law (a Pareto distribution with this shape, capped below
+	// MaxBalance) when positive, so most balances are small and a few are very large. Balances are uniform otherwise.
+	PowerLawExponent float64
+	// ZeroBalancePercent is the percentage of accounts with a zero balance for every asset.
+	ZeroBalancePercent int
+	// AssetCoveragePercent is the percentage of assets each account has a balance for (the others are zero).
+	AssetCoveragePercent int
+	// UserIdLength pads user IDs to this many characters when positive. IDs longer than
+	// MAX_BASE36_WALLET_ID_LENGTH are hashed into a field element (see convertRawWalletIdToBytes).
+	UserIdLength int
+}
+
+var (
+	// DEFAULT_TEST_DATA_PROFILE is the profile of GenerateTestData: uniform balances below 10,500 for every asset.
+	DEFAULT_TEST_DATA_PROFILE = TestDataProfile{MaxBalance: 10500, AssetCoveragePercent: 100}
+	// PRODUCTION_TEST_DATA_PROFILE is shaped like production data: power-law balances up to 10^15, a third of the
+	// accounts empty, each account holding a fifth of the assets, and user IDs longer than can be encoded directly.
+	PRODUCTION_TEST_DATA_PROFILE = TestDataProfile{MaxBalance: 1_000_000_000_000_000, PowerLawExponent: 0.3, ZeroBalancePercent: 33, AssetCoveragePercent: 20, UserIdLength: 64}
+)
+
+// GetTestDataProfile returns the test data profile with the given name: "uniform" (DEFAULT_TEST_DATA_PROFILE) or
+// "production" (PRODUCTION_TEST_DATA_PROFILE).
+func GetTestDataProfile(name string) (TestDataProfile, error) {
+	switch name {
+	case "", "uniform":
+		return DEFAULT_TEST_DATA_PROFILE, nil
+	case "production":
+		return PRODUCTION_TEST_DATA_PROFILE, nil
+	}
+	return TestDataProfile{}, fmt.Errorf("unknown test data profile %q (expected uniform or production)", name)
 }
 
 // GenerateTestData generates test data for a given number of accounts with a seed based on the account index.
-// Each account gets a random user ID.
+// Each account gets a random user ID. Balances follow DEFAULT_TEST_DATA_PROFILE.
 func GenerateTestData(count int, seed int) (accounts []GoAccount, assetSum GoBalance, merkleRoot Hash, merkleRootWithAssetSumHash Hash) {
+	return GenerateTestDataWithProfile(count, seed, DEFAULT_TEST_DATA_PROFILE)
+}
+
+// GenerateTestDataWithProfile generates test data like GenerateTestData, with accounts distributed according to the
+// profile. It panics if the profile is invalid.
+func GenerateTestDataWithProfile(count int, seed int, profile TestDataProfile) (accounts []GoAccount, assetSum GoBalance, merkleRoot Hash, merkleRootWithAssetSumHash Hash) {
+	if profile.MaxBalance <= 0 || profile.PowerLawExponent < 0 || profile.ZeroBalancePercent < 0 ||
+		profile.ZeroBalancePercent > 100 || profile.AssetCoveragePercent < 0 || profile.AssetCoveragePercent > 100 {
+		panic(fmt.Sprintf("invalid test data profile %+v", profile))
+	}
 
 	// initialize random number generator with seed
 	source := rand.NewSource(int64(seed))
@@ -343,12 +1230,21 @@ func GenerateTestData(count int, seed int) (accounts []GoAccount, assetSum GoBal
 
 	for i := 0; i < count; i++ {
 		// generate random user ID
-		walletId := convertRawWalletIdToBytes(fmt.Sprintf("user%d", rng.Int31()))
+		rawWalletId := fmt.Sprintf("user%d", rng.Int31())
+		if len(rawWalletId) < profile.UserIdLength {
+			rawWalletId += strings.Repeat("0", profile.UserIdLength-len(rawWalletId))
+		}
+		walletId := convertRawWalletIdToBytes(rawWalletId)
 
-		// generate random balances between 0 and 10,500
+		// generate random balances below MaxBalance (and 0 outside of the account's assets)
 		balances := make(GoBalance, GetNumberOfAssets())
+		empty := profile.ZeroBalancePercent > 0 && rng.Intn(100) < profile.ZeroBalancePercent
 		for i := range balances {
-			balances[i] = big.NewInt(rng.Int63n(10500))
+			balances[i] = big.NewInt(0)
+			if empty || (profile.AssetCoveragePercent < 100 && rng.Intn(100) >= profile.AssetCoveragePercent) {
+				continue
+			}
+			balances[i].SetInt64(generateTestBalance(rng, profile))
 		}
 
 		accounts = append(accounts, GoAccount{WalletId: walletId, Balance: balances})
@@ -356,18 +1252,97 @@ func GenerateTestData(count int, seed int) (accounts []GoAccount, assetSum GoBal
 
 	goAccountBalanceSum := SumGoAccountBalances(accounts)
 	merkleRoot = GoComputeMerkleRootFromAccounts(accounts)
-	merkleRootWithAssetSumHash = GoComputeMiMCHashForAccount(GoAccount{WalletId: merkleRoot, Balance: goAccountBalanceSum})
+	merkleRootWithAssetSumHash = GoComputeRootWithAssetSumHash(merkleRoot, goAccountBalanceSum, 0)
 	return accounts, goAccountBalanceSum, merkleRoot, merkleRootWithAssetSumHash
 }
 
+// generateTestBalance returns a random balance below profile.MaxBalance, distributed according to the profile.
+func generateTestBalance(rng *rand.Rand, profile TestDataProfile) int64 {
+	if profile.PowerLawExponent == 0 {
+		return rng.Int63n(profile.MaxBalance)
+	}
+	// inverse transform sampling of a Pareto distribution starting at 1, shifted to start at 0
+	balance := math.Pow(1-rng.Float64(), -1/profile.PowerLawExponent) - 1
+	if balance >= float64(profile.MaxBalance-1) {
+		return profile.MaxBalance - 1
+	}
+	return int64(balance)
+}
+
 // Check if GoBalance equal to other.
 func (GoBalance *GoBalance) Equals(other GoBalance) bool {
-	if len(*GoBalance) != len(other) || len(*GoBalance) != GetNumberOfAssets() {
+	return GetConfig().EqualGoBalances(*GoBalance, other)
+}
+
+// EqualGoBalances checks if the balances are equal like GoBalance.Equals, with the number of assets of the
+// configuration.
+func (c Config) EqualGoBalances(b GoBalance, other GoBalance) bool {
+	if len(b) != len(other) || len(b) != c.GetNumberOfAssets() {
 		panic(INVALID_BALANCE_LENGTH_MESSAGE)
 	}
 
-	for i := range *GoBalance {
-		if (*GoBalance)[i].Cmp(other[i]) != 0 {
+	for i := range b {
+		if b[i].Cmp(other[i]) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Add returns the sum of the balances, asset by asset. Returns an error if they don't have a balance for every asset.
+// The sum can exceed the range of the circuit, see IsWithinBits.
+func (b GoBalance) Add(other GoBalance) (GoBalance, error) {
+	return GetConfig().AddGoBalances(b, other)
+}
+
+// AddGoBalances returns the sum of the balances like GoBalance.Add, with the number of assets of the configuration.
+func (c Config) AddGoBalances(b GoBalance, other GoBalance) (GoBalance, error) {
+	if len(b) != c.GetNumberOfAssets() || len(other) != c.GetNumberOfAssets() {
+		return nil, errors.New(INVALID_BALANCE_LENGTH_MESSAGE)
+	}
+	sum := make(GoBalance, len(b))
+	for i := range b {
+		sum[i] = new(big.Int).Add(b[i], other[i])
+	}
+	return sum, nil
+}
+
+// Sub returns the difference of the balances, asset by asset. Returns an error if they don't have a balance for every
+// asset, or if the difference of an asset is negative (i.e. other has more of the asset), as negative balances can't
+// be proven.
+func (b GoBalance) Sub(other GoBalance) (GoBalance, error) {
+	if len(b) != GetNumberOfAssets() || len(other) != GetNumberOfAssets() {
+		return nil, errors.New(INVALID_BALANCE_LENGTH_MESSAGE)
+	}
+	difference := make(GoBalance, len(b))
+	for i := range b {
+		difference[i] = new(big.Int).Sub(b[i], other[i])
+		if difference[i].Sign() < 0 {
+			return nil, fmt.Errorf("balance of %s underflows: %s - %s is negative", GetAssetSymbols()[i], b[i], other[i])
+		}
+	}
+	return difference, nil
+}
+
+// Cmp compares the balances asset by asset, and returns the result of big.Int.Cmp for each asset: -1 if b has less of
+// the asset than other, 0 if they have the same, and +1 if b has more. It panics if they don't have a balance for
+// every asset, like Equals.
+func (b GoBalance) Cmp(other GoBalance) []int {
+	if len(b) != GetNumberOfAssets() || len(other) != GetNumberOfAssets() {
+		panic(INVALID_BALANCE_LENGTH_MESSAGE)
+	}
+	comparisons := make([]int, len(b))
+	for i := range b {
+		comparisons[i] = b[i].Cmp(other[i])
+	}
+	return comparisons
+}
+
+// IsWithinBits returns whether every balance is in [0, 2^bits), e.g. whether the balance passes the range check of
+// the circuit with BALANCE_BITS.
+func (b GoBalance) IsWithinBits(bits int) bool {
+	for _, asset := range b {
+		if asset.Sign() < 0 || asset.BitLen() > bits {
 			return false
 		}
 	}
@@ -2,9 +2,20 @@ package circuit
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 
+	"github.com/consensys/gnark-crypto/ecc"
 	"github.com/consensys/gnark-crypto/ecc/bn254/fr/mimc"
 	"github.com/consensys/gnark-crypto/hash"
 	"github.com/consensys/gnark/test"
@@ -68,8 +79,8 @@ func TestGoComputeMiMCHashesForAccounts(t *testing.T) {
 	}
 
 	expectedHashes := []Hash{
-		{0x1, 0x8a, 0x24, 0xf9, 0x77, 0x3a, 0xaf, 0x74, 0x41, 0x1d, 0x2d, 0x6b, 0x4f, 0xc0, 0xe8, 0xc1, 0x3, 0x7, 0xd3, 0x84, 0x34, 0xf8, 0xf, 0x77, 0xa0, 0x55, 0x7, 0xf8, 0xee, 0xc4, 0xa, 0xb1},
-		{0x25, 0x4d, 0x52, 0xf9, 0x5d, 0x98, 0x4c, 0x35, 0x43, 0xd0, 0xab, 0xff, 0x7d, 0xb1, 0xf, 0x19, 0x3b, 0xa6, 0x53, 0xab, 0x22, 0xe7, 0x1, 0xe4, 0x44, 0x52, 0x11, 0x45, 0xfc, 0x53, 0xbc, 0xcd},
+		{0x24, 0x4e, 0x4c, 0x4b, 0xb6, 0x18, 0x8c, 0x3c, 0x24, 0x8b, 0x14, 0x9d, 0xe3, 0x78, 0x93, 0x35, 0xcc, 0xb7, 0x40, 0x83, 0xd3, 0xcc, 0x69, 0xdf, 0xc4, 0x4d, 0x5f, 0x25, 0x38, 0xac, 0xed, 0xc6},
+		{0x2a, 0x2f, 0x2c, 0xa3, 0x6, 0x54, 0x8e, 0x8c, 0xa5, 0x42, 0x39, 0xa3, 0x4e, 0x6, 0x6f, 0x4b, 0x2, 0xdb, 0x6d, 0x57, 0x72, 0xc7, 0xfd, 0x2, 0x4c, 0x91, 0x7b, 0x9f, 0xc8, 0x8b, 0xdb, 0x8b},
 	}
 
 	actualHashes := GoComputeMiMCHashesForAccounts(accounts)
@@ -198,8 +209,8 @@ func TestPublicComputeMerkleRootMaxAccountsConstraint(t *testing.T) {
 	accounts := make([]GoAccount, ACCOUNTS_PER_BATCH+1)
 	for i := range accounts {
 		accounts[i] = GoAccount{
-			WalletId:  []byte{byte(i % 256)},
-			Balance: ConstructGoBalance(big.NewInt(1), big.NewInt(1)),
+			WalletId: []byte{byte(i % 256)},
+			Balance:  ConstructGoBalance(big.NewInt(1), big.NewInt(1)),
 		}
 	}
 
@@ -423,6 +434,63 @@ func TestComputeMerklePath(t *testing.T) {
 	}
 }
 
+func TestComputeMerklePathElements(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// tree of depth 3 (with leaf hashes 0 to 7)
+	hashes := make([]Hash, 8)
+	for i := range hashes {
+		hashes[i] = padToModBytes(big.NewInt(int64(i)))
+	}
+	nodes := goComputeMerkleTreeNodesFromHashes(hashes, 3)
+
+	// the siblings are those of the merkle path, and their sides encode the position
+	for position := range hashes {
+		path := ComputeMerklePathElements(position, nodes)
+		siblings := ComputeMerklePath(position, nodes)
+		for i, element := range path {
+			assert.Equal(siblings[i], element.Sibling)
+		}
+		assert.Equal(position, GetMerklePathElementsPosition(path))
+	}
+	assert.Equal([]MerklePathElement{{nodes[3][4], true}, {nodes[2][3], false}, {nodes[1][0], true}}, ComputeMerklePathElements(5, nodes))
+
+	// invalid positions
+	_, err := ComputeMerklePathElementsWithError(8, nodes)
+	assert.Error(err)
+	assert.Panics(func() { ComputeMerklePathElements(-1, nodes) })
+}
+
+func TestComputeMerkleMultiPath(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// tree of depth 3 (with leaf hashes 0 to 7)
+	hashes := make([]Hash, 8)
+	for i := range hashes {
+		hashes[i] = padToModBytes(big.NewInt(int64(i)))
+	}
+	nodes := goComputeMerkleTreeNodesFromHashes(hashes, 3)
+
+	// a single position has the merkle path of that position
+	assert.Equal(ComputeMerklePath(5, nodes), ComputeMerkleMultiPath([]int{5}, nodes))
+
+	// siblings and shared ancestors are not repeated, and positions may be in any order
+	assert.Equal([]Hash{nodes[3][3], nodes[1][1]}, ComputeMerkleMultiPath([]int{2, 0, 1}, nodes))
+	assert.Equal([]Hash{nodes[3][1], nodes[3][6], nodes[2][1], nodes[2][2]}, ComputeMerkleMultiPath([]int{7, 0}, nodes))
+
+	// every position needs no siblings
+	assert.Empty(ComputeMerkleMultiPath([]int{0, 1, 2, 3, 4, 5, 6, 7}, nodes))
+
+	// invalid positions or nodes
+	for _, positions := range [][]int{{}, {8}, {-1}, {1, 1}} {
+		_, err := ComputeMerkleMultiPathWithError(positions, nodes)
+		assert.Error(err, "positions %v", positions)
+	}
+	_, err := ComputeMerkleMultiPathWithError([]int{0}, [][]Hash{nodes[0], nodes[1], nodes[1]})
+	assert.ErrorContains(err, "merkle nodes provided are not of correct structure")
+	assert.Panics(func() { ComputeMerkleMultiPath([]int{0, 0}, nodes) })
+}
+
 func TestSumGoAccountBalances(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -434,12 +502,12 @@ func TestSumGoAccountBalances(t *testing.T) {
 			name: "All positive balances",
 			accounts: []GoAccount{
 				{
-					WalletId:  []byte("user1"),
-					Balance: ConstructGoBalance(big.NewInt(100), big.NewInt(200)),
+					WalletId: []byte("user1"),
+					Balance:  ConstructGoBalance(big.NewInt(100), big.NewInt(200)),
 				},
 				{
-					WalletId:  []byte("user2"),
-					Balance: ConstructGoBalance(big.NewInt(150), big.NewInt(250)),
+					WalletId: []byte("user2"),
+					Balance:  ConstructGoBalance(big.NewInt(150), big.NewInt(250)),
 				},
 			},
 			expected:    ConstructGoBalance(big.NewInt(250), big.NewInt(450)),
@@ -449,8 +517,8 @@ func TestSumGoAccountBalances(t *testing.T) {
 			name: "With negative balance for first asset",
 			accounts: []GoAccount{
 				{
-					WalletId:  []byte("user1"),
-					Balance: ConstructGoBalance(big.NewInt(-250), big.NewInt(450)),
+					WalletId: []byte("user1"),
+					Balance:  ConstructGoBalance(big.NewInt(-250), big.NewInt(450)),
 				},
 			},
 			expected:    GoBalance{}, // doesn't matter, should panic
@@ -460,8 +528,8 @@ func TestSumGoAccountBalances(t *testing.T) {
 			name: "With negative balance for second asset",
 			accounts: []GoAccount{
 				{
-					WalletId:  []byte("user1"),
-					Balance: ConstructGoBalance(big.NewInt(250), big.NewInt(-450)),
+					WalletId: []byte("user1"),
+					Balance:  ConstructGoBalance(big.NewInt(250), big.NewInt(-450)),
 				},
 			},
 			expected:    GoBalance{}, // doesn't matter, should panic
@@ -471,8 +539,8 @@ func TestSumGoAccountBalances(t *testing.T) {
 			name: "Zero balances",
 			accounts: []GoAccount{
 				{
-					WalletId:  []byte("user1"),
-					Balance: ConstructGoBalance(),
+					WalletId: []byte("user1"),
+					Balance:  ConstructGoBalance(),
 				},
 			},
 			expected:    ConstructGoBalance(),
@@ -573,6 +641,44 @@ func TestGoBalanceEquals(t *testing.T) {
 	}
 }
 
+func TestGoBalanceArithmetic(t *testing.T) {
+	assert := test.NewAssert(t)
+	a := ConstructGoBalance(big.NewInt(250), big.NewInt(450))
+	b := ConstructGoBalance(big.NewInt(200), big.NewInt(500))
+
+	sum, err := a.Add(b)
+	assert.NoError(err)
+	assert.Equal(ConstructGoBalance(big.NewInt(450), big.NewInt(950)), sum)
+	assert.Equal(ConstructGoBalance(big.NewInt(250), big.NewInt(450)), a, "Add must not modify its operands")
+
+	difference, err := sum.Sub(b)
+	assert.NoError(err)
+	assert.Equal(a, difference)
+	_, err = a.Sub(b)
+	assert.ErrorContains(err, "balance of ARBETH underflows")
+
+	comparisons := a.Cmp(b)
+	assert.Equal([]int{1, -1}, comparisons[:2])
+	for _, comparison := range comparisons[2:] {
+		assert.Equal(0, comparison)
+	}
+
+	_, err = a.Add(GoBalance{big.NewInt(1)})
+	assert.ErrorContains(err, INVALID_BALANCE_LENGTH_MESSAGE)
+	_, err = a.Sub(GoBalance{big.NewInt(1)})
+	assert.ErrorContains(err, INVALID_BALANCE_LENGTH_MESSAGE)
+	assert.Panics(func() { a.Cmp(GoBalance{big.NewInt(1)}) })
+
+	// balances at the limit of the circuit's range check
+	maxBalance := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), BALANCE_BITS), big.NewInt(1))
+	atLimit := ConstructGoBalance(maxBalance)
+	assert.True(atLimit.IsWithinBits(BALANCE_BITS))
+	overLimit, err := atLimit.Add(ConstructGoBalance(big.NewInt(1)))
+	assert.NoError(err)
+	assert.False(overLimit.IsWithinBits(BALANCE_BITS))
+	assert.False(ConstructGoBalance(big.NewInt(-1)).IsWithinBits(BALANCE_BITS))
+	assert.True(ConstructGoBalance().IsWithinBits(0))
+}
 func TestConvertRawWalletIdToBytes(t *testing.T) {
 	t.Run("basic alphanumeric conversion", func(t *testing.T) {
 		walletId := "user123"
@@ -610,13 +716,41 @@ func TestConvertRawWalletIdToBytes(t *testing.T) {
 		}()
 		convertRawWalletIdToBytes(walletId)
 	})
+
+	t.Run("walletId longer than 48 characters is hashed", func(t *testing.T) {
+		walletId := strings.Repeat("z", MAX_BASE36_WALLET_ID_LENGTH)
+		longWalletId := walletId + "z"
+
+		// the longest base36 walletId still fits in the field
+		n := new(big.Int).SetBytes(convertRawWalletIdToBytes(walletId))
+		if n.Text(36) != walletId {
+			t.Errorf("Expected %s, got %s", walletId, n.Text(36))
+		}
+
+		// a longer one is hashed to a field element, case-insensitively and ignoring hyphens
+		digest := sha256.Sum256([]byte(longWalletId))
+		expected := new(big.Int).Mod(new(big.Int).SetBytes(digest[:]), ecc.BN254.ScalarField()).Bytes()
+		if !bytes.Equal(expected, convertRawWalletIdToBytes(longWalletId)) {
+			t.Errorf("Expected long walletId to be hashed to SHA-256 mod r")
+		}
+		if !bytes.Equal(expected, convertRawWalletIdToBytes(strings.ToUpper(longWalletId[:10])+"-"+longWalletId[10:])) {
+			t.Errorf("Expected hashing to ignore case and hyphens")
+		}
+
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("Expected panic with invalid characters")
+			}
+		}()
+		convertRawWalletIdToBytes(longWalletId + "@")
+	})
 }
 
 func TestConvertRawGoAccountToGoAccount(t *testing.T) {
 	t.Run("standard conversion with alphanumeric and hyphenated walletId", func(t *testing.T) {
 		rawAccount := RawGoAccount{
-			WalletId:  "user-123-abc",
-			Balance: ConstructGoBalance(big.NewInt(1000), big.NewInt(2000)),
+			WalletId: "user-123-abc",
+			Balance:  ConstructGoBalance(big.NewInt(1000), big.NewInt(2000)),
 		}
 
 		result := ConvertRawGoAccountToGoAccount(rawAccount)
@@ -639,8 +773,8 @@ func TestConvertGoAccountToRawGoAccount(t *testing.T) {
 		// Create a GoAccount
 		walletId := convertRawWalletIdToBytes("user123")
 		goAccount := GoAccount{
-			WalletId:  walletId,
-			Balance: ConstructGoBalance(big.NewInt(1000), big.NewInt(2000)),
+			WalletId: walletId,
+			Balance:  ConstructGoBalance(big.NewInt(1000), big.NewInt(2000)),
 		}
 
 		// Convert to RawGoAccount
@@ -661,8 +795,8 @@ func TestConvertGoAccountToRawGoAccount(t *testing.T) {
 		// Start with a GoAccount
 		originalWalletId := convertRawWalletIdToBytes("test456abc")
 		originalAccount := GoAccount{
-			WalletId:  originalWalletId,
-			Balance: ConstructGoBalance(big.NewInt(500), big.NewInt(600)),
+			WalletId: originalWalletId,
+			Balance:  ConstructGoBalance(big.NewInt(500), big.NewInt(600)),
 		}
 
 		// Convert to RawGoAccount and back
@@ -677,6 +811,29 @@ func TestConvertGoAccountToRawGoAccount(t *testing.T) {
 			t.Errorf("Balance should be preserved in round trip")
 		}
 	})
+
+	t.Run("round trip conversion of a hashed walletId", func(t *testing.T) {
+		// the field element of a long walletId is written with HashedWalletId, so it is not hashed again when read
+		originalAccount := ConvertRawGoAccountToGoAccount(RawGoAccount{
+			WalletId: strings.Repeat("user", 20),
+			Balance:  ConstructGoBalance(big.NewInt(500), big.NewInt(600)),
+		})
+		rawAccount := ConvertGoAccountToRawGoAccount(originalAccount)
+		if !rawAccount.HashedWalletId {
+			t.Errorf("Expected the walletId to be flagged as hashed, got %s", rawAccount.WalletId)
+		}
+		if !bytes.Equal(originalAccount.WalletId, ConvertRawGoAccountToGoAccount(rawAccount).WalletId) {
+			t.Errorf("WalletId should be preserved in round trip")
+		}
+
+		// a hashed walletId must be a field element
+		defer func() {
+			if r := recover(); r == nil {
+				t.Errorf("Expected panic with a hashed walletId outside the field")
+			}
+		}()
+		ConvertRawGoAccountToGoAccount(RawGoAccount{WalletId: strings.Repeat("z", 50), HashedWalletId: true})
+	})
 }
 
 func TestBatchConversionFunctions(t *testing.T) {
@@ -684,12 +841,12 @@ func TestBatchConversionFunctions(t *testing.T) {
 		// Create a batch of raw accounts
 		rawAccounts := []RawGoAccount{
 			{
-				WalletId:  "user1",
-				Balance: ConstructGoBalance(big.NewInt(100), big.NewInt(200)),
+				WalletId: "user1",
+				Balance:  ConstructGoBalance(big.NewInt(100), big.NewInt(200)),
 			},
 			{
-				WalletId:  "user-2",
-				Balance: ConstructGoBalance(big.NewInt(300), big.NewInt(400)),
+				WalletId: "user-2",
+				Balance:  ConstructGoBalance(big.NewInt(300), big.NewInt(400)),
 			},
 		}
 
@@ -724,12 +881,12 @@ func TestBatchConversionFunctions(t *testing.T) {
 		// Create a batch of go accounts
 		accounts := []GoAccount{
 			{
-				WalletId:  convertRawWalletIdToBytes("user1"),
-				Balance: ConstructGoBalance(big.NewInt(100), big.NewInt(200)),
+				WalletId: convertRawWalletIdToBytes("user1"),
+				Balance:  ConstructGoBalance(big.NewInt(100), big.NewInt(200)),
 			},
 			{
-				WalletId:  convertRawWalletIdToBytes("user2"),
-				Balance: ConstructGoBalance(big.NewInt(300), big.NewInt(400)),
+				WalletId: convertRawWalletIdToBytes("user2"),
+				Balance:  ConstructGoBalance(big.NewInt(300), big.NewInt(400)),
 			},
 		}
 
@@ -838,6 +995,140 @@ func TestConstructGoBalance(t *testing.T) {
 	}
 }
 
+func TestConstructGoBalanceFromMap(t *testing.T) {
+	assert := test.NewAssert(t)
+	defer SetAssetRegistry(DefaultAssetRegistry())
+
+	// amounts are placed at the index of their asset, and missing assets are zero
+	balance := ConstructGoBalanceFromMap(map[string]*big.Int{"ETH": big.NewInt(2), "BTC": big.NewInt(1)})
+	expected := ConstructGoBalance()
+	expected[3] = big.NewInt(1)
+	expected[12] = big.NewInt(2)
+	assert.True(balance.Equals(expected))
+
+	// with an asset subset, amounts are placed at the index of their asset in the subset
+	assert.NoError(SetAssetSubset([]int{3, 12}))
+	assert.Equal(GoBalance{big.NewInt(1), big.NewInt(2)}, ConstructGoBalanceFromMap(map[string]*big.Int{"ETH": big.NewInt(2), "BTC": big.NewInt(1)}))
+	_, err := ConstructGoBalanceFromMapWithError(map[string]*big.Int{"SOL": big.NewInt(1)})
+	assert.Error(err)
+	assert.NoError(SetAssetSubset(nil))
+
+	// unknown symbols and nil amounts are rejected
+	_, err = ConstructGoBalanceFromMapWithError(map[string]*big.Int{"XYZ": big.NewInt(1)})
+	assert.Error(err)
+	_, err = ConstructGoBalanceFromMapWithError(map[string]*big.Int{"BTC": nil})
+	assert.Error(err)
+	assert.Panics(func() { ConstructGoBalanceFromMap(map[string]*big.Int{"btc": big.NewInt(1)}) })
+}
+
+func TestAggregateAccountsByUserId(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// the accounts of each user are merged in the order of the user's first account, keeping the first salt
+	balance := func(amounts ...int64) GoBalance {
+		values := make([]*big.Int, len(amounts))
+		for i, amount := range amounts {
+			values[i] = big.NewInt(amount)
+		}
+		return ConstructGoBalance(values...)
+	}
+	salt := []byte{1}
+	accounts := []GoAccount{
+		{WalletId: []byte("bob"), Balance: balance(1, 2)},
+		{WalletId: []byte("alice"), Balance: balance(5)},
+		{WalletId: []byte("bob"), Balance: balance(10, 0, 3), Salt: salt},
+		{WalletId: []byte("bob"), Balance: balance(100), Salt: []byte{2}},
+	}
+	aggregated := AggregateAccountsByUserId(accounts)
+	assert.Equal([]GoAccount{
+		{WalletId: []byte("bob"), Balance: balance(111, 2, 3), Salt: salt},
+		{WalletId: []byte("alice"), Balance: balance(5)},
+	}, aggregated)
+	assetSum := SumGoAccountBalances(accounts)
+	assert.True(assetSum.Equals(SumGoAccountBalances(aggregated)))
+
+	// the input is not modified, and accounts of distinct users are unchanged
+	assert.True(accounts[0].Balance.Equals(balance(1, 2)))
+	assert.Equal(accounts[1:2], AggregateAccountsByUserId(accounts[1:2]))
+
+	// balances must have every asset
+	_, err := AggregateAccountsByUserIdWithError([]GoAccount{{WalletId: []byte("bob"), Balance: GoBalance{big.NewInt(1)}}})
+	assert.Error(err)
+	assert.Panics(func() { AggregateAccountsByUserId([]GoAccount{{WalletId: []byte("bob"), Balance: GoBalance{}}}) })
+}
+
+func TestCanonicalAccountBytes(t *testing.T) {
+	assert := test.NewAssert(t)
+	account := GoAccount{WalletId: []byte{0x01, 0x02}, Balance: ConstructGoBalance(big.NewInt(0x0304), big.NewInt(0), big.NewInt(5))}
+	accountBytes := CanonicalAccountBytes(ACCOUNT_BYTES_VERSION_1, account)
+
+	// WalletId followed by each balance, each left-padded to ModBytes
+	assert.Equal((1+GetNumberOfAssets())*ModBytes, len(accountBytes))
+	assert.Equal(append(make([]byte, ModBytes-2), 0x01, 0x02), accountBytes[:ModBytes])
+	assert.Equal(append(make([]byte, ModBytes-2), 0x03, 0x04), accountBytes[ModBytes:2*ModBytes])
+	assert.Equal(make([]byte, ModBytes), accountBytes[2*ModBytes:3*ModBytes])
+	assert.Equal(append(make([]byte, ModBytes-1), 0x05), accountBytes[3*ModBytes:4*ModBytes])
+
+	// the leaf is MiMC(LEAF_HASH_TAG, WalletId, MiMC(balances)), and MiMC(WalletId, MiMC(balances)) when untagged
+	defer SetHashVersion(HASH_VERSION_ROOT_TAGGED)
+	hasher := mimc.NewMiMC()
+	hasher.Write(accountBytes[ModBytes:])
+	balanceHash := hasher.Sum(nil)
+	hasher.Reset()
+	hasher.Write(padToModBytes(big.NewInt(LEAF_HASH_TAG)))
+	hasher.Write(accountBytes[:ModBytes])
+	hasher.Write(balanceHash)
+	assert.Equal(hasher.Sum(nil), GoComputeMiMCHashForAccount(account))
+	hasher.Reset()
+	hasher.Write(accountBytes[:ModBytes])
+	hasher.Write(balanceHash)
+	assert.NoError(SetHashVersion(HASH_VERSION_UNTAGGED))
+	assert.Equal(hasher.Sum(nil), GoComputeMiMCHashForAccount(account))
+
+	assert.Panics(func() {
+		CanonicalAccountBytes(ACCOUNT_BYTES_VERSION_1, GoAccount{WalletId: make([]byte, ModBytes+1), Balance: ConstructGoBalance()})
+	})
+
+	// proofs that do not record a version use version 1, and unknown versions are rejected
+	assert.Equal(accountBytes, CanonicalAccountBytes(0, account))
+	_, err := CanonicalAccountBytesWithError(2, account)
+	assert.Error(err)
+	assert.Error(SetAccountBytesVersion(2))
+	assert.Equal(ACCOUNT_BYTES_VERSION_1, GetAccountBytesVersion())
+}
+
+func TestGoComputeRootWithAssetSumHash(t *testing.T) {
+	assert := test.NewAssert(t)
+	defer SetDeploymentDomain("")
+	defer SetHashVersion(HASH_VERSION_ROOT_TAGGED)
+	merkleRoot := Hash{0x01, 0x02}
+	assetSum := ConstructGoBalance(big.NewInt(3), big.NewInt(4))
+	hash := GoComputeRootWithAssetSumHash(merkleRoot, assetSum, 0)
+
+	// the hash is the leaf of the proof in the next level proof, where the proof is a pseudo-account, which is tagged
+	// apart from the account of the same values
+	pseudoAccount := GoAccount{WalletId: merkleRoot, Balance: assetSum}
+	assert.Equal(GoComputeHashForPseudoAccount(pseudoAccount), hash)
+	assert.Equal(GoComputeMerkleTreeNodesFromPseudoAccounts([]GoAccount{pseudoAccount})[GetTreeDepth()][0], hash)
+	assert.NotEqual(GoComputeMiMCHashForAccount(pseudoAccount), hash)
+	assert.NotEqual(hash, GoComputeRootWithAssetSumHash(merkleRoot, ConstructGoBalance(big.NewInt(3), big.NewInt(5)), 0))
+
+	// the epoch salts the pseudo-account, so the same root and sum give a different hash in every epoch
+	epochHash := GoComputeRootWithAssetSumHash(merkleRoot, assetSum, 7)
+	assert.Equal(GoComputeHashForPseudoAccount(GoAccount{WalletId: merkleRoot, Balance: assetSum, Salt: EpochSalt(7)}), epochHash)
+	assert.NotEqual(hash, epochHash)
+	assert.NotEqual(epochHash, GoComputeRootWithAssetSumHash(merkleRoot, assetSum, 8))
+
+	// proofs of earlier hash versions hashed the pseudo-account like an account
+	assert.NoError(SetHashVersion(HASH_VERSION_TAGGED))
+	assert.Equal(GoComputeMiMCHashForAccount(pseudoAccount), GoComputeRootWithAssetSumHash(merkleRoot, assetSum, 0))
+	assert.NoError(SetHashVersion(HASH_VERSION_ROOT_TAGGED))
+
+	// the deployment domain separates the hashes of different deployments
+	SetDeploymentDomain("testnet")
+	assert.NotEqual(hash, GoComputeRootWithAssetSumHash(merkleRoot, assetSum, 0))
+}
+
 func TestGoConvertBalanceToBytes(t *testing.T) {
 	tests := []struct {
 		name         string
@@ -910,3 +1201,627 @@ func TestGoConvertBalanceToBytes(t *testing.T) {
 		})
 	}
 }
+
+func TestConvertBaseUnitsToDisplayAmount(t *testing.T) {
+	tests := []struct {
+		amount   *big.Int
+		decimals int
+		expected string
+	}{
+		{big.NewInt(150000000), 8, "1.5"},
+		{big.NewInt(1), 8, "0.00000001"},
+		{big.NewInt(0), 8, "0"},
+		{big.NewInt(1200), 2, "12"},
+		{big.NewInt(1234), 0, "1234"},
+		{big.NewInt(-150), 2, "-1.5"},
+	}
+	for _, tc := range tests {
+		result := ConvertBaseUnitsToDisplayAmount(tc.amount, tc.decimals)
+		if result != tc.expected {
+			t.Errorf("ConvertBaseUnitsToDisplayAmount(%v, %d) = %s; expected %s", tc.amount, tc.decimals, result, tc.expected)
+		}
+	}
+}
+
+func TestConvertDisplayAmountToBaseUnits(t *testing.T) {
+	tests := []struct {
+		amount      string
+		decimals    int
+		expected    *big.Int
+		shouldError bool
+	}{
+		{"1.5", 8, big.NewInt(150000000), false},
+		{"0.00000001", 8, big.NewInt(1), false},
+		{"12", 2, big.NewInt(1200), false},
+		{".5", 1, big.NewInt(5), false},
+		{"3.", 1, big.NewInt(30), false},
+		{"0.000000001", 8, nil, true},
+		{"1.2.3", 8, nil, true},
+		{"abc", 8, nil, true},
+		{"1.-5", 8, nil, true},
+		{"-0.5", 8, nil, true},
+		{"-.5", 8, nil, true},
+		{"-1", 8, nil, true},
+		{"+1", 8, nil, true},
+		{"1.+5", 8, nil, true},
+		{"1_000", 8, nil, true},
+		{"0x10", 8, nil, true},
+		{".", 8, nil, true},
+		{"", 8, nil, true},
+	}
+	for _, tc := range tests {
+		result, err := ConvertDisplayAmountToBaseUnits(tc.amount, tc.decimals)
+		if tc.shouldError {
+			if err == nil {
+				t.Errorf("ConvertDisplayAmountToBaseUnits(%q, %d) expected error, got %v", tc.amount, tc.decimals, result)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ConvertDisplayAmountToBaseUnits(%q, %d) returned error: %v", tc.amount, tc.decimals, err)
+		} else if result.Cmp(tc.expected) != 0 {
+			t.Errorf("ConvertDisplayAmountToBaseUnits(%q, %d) = %v; expected %v", tc.amount, tc.decimals, result, tc.expected)
+		}
+	}
+
+	// converting to display units and back should be lossless for every asset
+	for i, decimals := range GetAssetDecimals() {
+		amount := big.NewInt(123456789)
+		result, err := ConvertDisplayAmountToBaseUnits(ConvertBaseUnitsToDisplayAmount(amount, decimals), decimals)
+		if err != nil || result.Cmp(amount) != 0 {
+			t.Errorf("round trip of %v for asset %s failed: got %v, error %v", amount, GetAssetSymbols()[i], result, err)
+		}
+	}
+	if len(GetAssetDecimals()) != GetNumberOfAssets() {
+		t.Errorf("expected %d asset decimals, found %d", GetNumberOfAssets(), len(GetAssetDecimals()))
+	}
+}
+
+func TestAssetRegistry(t *testing.T) {
+	assert := test.NewAssert(t)
+	defer SetAssetRegistry(DefaultAssetRegistry())
+
+	// the default registry is the one balances used before the registry was configurable
+	assert.Equal(36, GetNumberOfAssets())
+	assert.Equal("BTC", GetAssetSymbols()[3])
+	assert.Equal(8, GetAssetDecimals()[3])
+
+	// the hash depends on the symbols, decimals, and order of the assets
+	registry := AssetRegistry{Assets: []Asset{{Symbol: "BTC", Decimals: 8}, {Symbol: "ETH", Decimals: 18}}}
+	assert.Equal(registry.Hash(), AssetRegistry{Assets: []Asset{{Symbol: "BTC", Decimals: 8}, {Symbol: "ETH", Decimals: 18}}}.Hash())
+	assert.NotEqual(registry.Hash(), AssetRegistry{Assets: []Asset{{Symbol: "ETH", Decimals: 18}, {Symbol: "BTC", Decimals: 8}}}.Hash())
+	assert.NotEqual(registry.Hash(), AssetRegistry{Assets: []Asset{{Symbol: "BTC", Decimals: 8}, {Symbol: "ETH", Decimals: 9}}}.Hash())
+	assert.NotEqual(registry.Hash(), DefaultAssetRegistry().Hash())
+
+	// invalid registries are rejected and leave the registry unchanged
+	assert.Error(SetAssetRegistry(AssetRegistry{}))
+	assert.Error(SetAssetRegistry(AssetRegistry{Assets: []Asset{{Symbol: "BTC"}, {Symbol: "BTC"}}}))
+	assert.Error(SetAssetRegistry(AssetRegistry{Assets: []Asset{{Symbol: ""}}}))
+	assert.Error(SetAssetRegistry(AssetRegistry{Assets: []Asset{{Symbol: "BTC", Decimals: -1}}}))
+	assert.Equal(DefaultAssetRegistry().Hash(), GetAssetRegistry().Hash())
+
+	assert.NoError(SetAssetRegistry(registry))
+	assert.Equal(2, GetNumberOfAssets())
+	assert.Equal([]string{"BTC", "ETH"}, GetAssetSymbols())
+	assert.Equal([]int{8, 18}, GetAssetDecimals())
+}
+
+func TestAssetSubset(t *testing.T) {
+	assert := test.NewAssert(t)
+	defer SetAssetRegistry(DefaultAssetRegistry())
+
+	// every balance has the assets of the subset, in the order of the registry
+	assert.NoError(SetAssetSubset([]int{3, 12}))
+	assert.Equal([]int{3, 12}, GetAssetSubset())
+	assert.Equal(2, GetNumberOfAssets())
+	assert.Equal([]string{"BTC", "ETH"}, GetAssetSymbols())
+	assert.Equal([]int{8, 18}, GetAssetDecimals())
+	assert.Equal(DefaultAssetRegistry().Hash(), GetAssetRegistry().Hash())
+
+	// balances of every asset of the registry are restricted to the subset
+	balance := make(GoBalance, len(DefaultAssetRegistry().Assets))
+	for i := range balance {
+		balance[i] = big.NewInt(int64(i))
+	}
+	selected, err := SelectAssetSubset(balance)
+	assert.NoError(err)
+	assert.Equal(GoBalance{big.NewInt(3), big.NewInt(12)}, selected)
+	_, err = SelectAssetSubset(selected)
+	assert.Error(err)
+
+	// invalid subsets are rejected and leave the subset unchanged
+	assert.Error(SetAssetSubset([]int{}))
+	assert.Error(SetAssetSubset([]int{12, 3}))
+	assert.Error(SetAssetSubset([]int{3, 3}))
+	assert.Error(SetAssetSubset([]int{-1}))
+	assert.Error(SetAssetSubset([]int{36}))
+	assert.Equal([]int{3, 12}, GetAssetSubset())
+
+	// without a subset, balances have every asset
+	assert.NoError(SetAssetSubset(nil))
+	assert.Nil(GetAssetSubset())
+	assert.Equal(36, GetNumberOfAssets())
+	selected, err = SelectAssetSubset(balance)
+	assert.NoError(err)
+	assert.Equal(balance, selected)
+
+	// setting the registry clears the subset, whose indices are of the previous registry
+	assert.NoError(SetAssetSubset([]int{3, 12}))
+	assert.NoError(SetAssetRegistry(DefaultAssetRegistry()))
+	assert.Nil(GetAssetSubset())
+}
+
+func TestReadAssetRegistry(t *testing.T) {
+	assert := test.NewAssert(t)
+	dir := t.TempDir()
+	expected := AssetRegistry{Assets: []Asset{{Symbol: "BTC", Decimals: 8}, {Symbol: "ETH", Decimals: 18}}}
+
+	files := map[string]string{
+		"assets.json": `{"assets": [{"symbol": "BTC", "decimals": 8}, {"symbol": "ETH", "decimals": 18}]}`,
+		"assets.yaml": "assets:\n  - symbol: BTC\n    decimals: 8\n  - symbol: ETH\n    decimals: 18\n",
+	}
+	for name, content := range files {
+		assert.NoError(os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+		registry, err := ReadAssetRegistry(filepath.Join(dir, name))
+		assert.NoError(err, name)
+		assert.Equal(expected, registry, name)
+	}
+
+	// unknown extensions and invalid registries are rejected
+	assert.NoError(os.WriteFile(filepath.Join(dir, "assets.txt"), []byte(files["assets.json"]), 0o644))
+	_, err := ReadAssetRegistry(filepath.Join(dir, "assets.txt"))
+	assert.Error(err)
+	assert.NoError(os.WriteFile(filepath.Join(dir, "empty.json"), []byte(`{"assets": []}`), 0o644))
+	_, err = ReadAssetRegistry(filepath.Join(dir, "empty.json"))
+	assert.Error(err)
+}
+
+func TestHashWorkers(t *testing.T) {
+	assert := test.NewAssert(t)
+	defer SetHashWorkers(GetHashWorkers())
+
+	// hashes are the same whatever the number of hash workers
+	accounts, _, _, _ := GenerateTestData(1000, 3)
+	assert.NoError(SetHashWorkers(1))
+	hashes := GoComputeMiMCHashesForAccounts(accounts)
+	nodes := GoComputeMerkleTreeNodesFromAccounts(accounts)
+	root := GoComputeMerkleRootFromAccounts(accounts)
+	for _, workers := range []int{2, 3, 8} {
+		assert.NoError(SetHashWorkers(workers))
+		assert.Equal(hashes, GoComputeMiMCHashesForAccounts(accounts))
+		assert.Equal(nodes, GoComputeMerkleTreeNodesFromAccounts(accounts))
+		assert.Equal(root, GoComputeMerkleRootFromAccounts(accounts))
+	}
+	for i := range accounts {
+		assert.Equal(GoComputeMiMCHashForAccount(accounts[i]), hashes[i])
+	}
+
+	// a panic of a hash worker is raised in the caller
+	invalidAccounts := append([]GoAccount{}, accounts...)
+	invalidAccounts[500].Salt = make([]byte, ModBytes+1)
+	invalidAccounts[500].Salt[0] = 1
+	assert.Panics(func() { GoComputeMiMCHashesForAccounts(invalidAccounts) })
+
+	assert.Error(SetHashWorkers(0))
+	assert.Equal(8, GetHashWorkers())
+}
+
+func TestGenerateTestDataWithProfile(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// the default profile generates the same data as GenerateTestData
+	accounts, assetSum, merkleRoot, _ := GenerateTestData(100, 7)
+	profileAccounts, profileAssetSum, profileMerkleRoot, _ := GenerateTestDataWithProfile(100, 7, DEFAULT_TEST_DATA_PROFILE)
+	assert.Equal(accounts, profileAccounts)
+	assert.Equal(assetSum, profileAssetSum)
+	assert.Equal(merkleRoot, profileMerkleRoot)
+
+	// production-shaped data has empty accounts, sparse assets, large balances and hashed user IDs
+	profile := PRODUCTION_TEST_DATA_PROFILE
+	accounts, assetSum, merkleRoot, _ = GenerateTestDataWithProfile(1000, 7, profile)
+	assert.Equal(merkleRoot, GoComputeMerkleRootFromAccounts(accounts))
+	assert.Equal(assetSum, SumGoAccountBalances(accounts))
+	emptyAccounts, zeroBalances, maxBalance := 0, 0, big.NewInt(0)
+	for _, account := range accounts {
+		assert.True(len(account.WalletId) > 20, "expected a hashed user ID, got %x", account.WalletId)
+		empty := true
+		for _, balance := range account.Balance {
+			assert.True(balance.Sign() >= 0 && balance.Cmp(big.NewInt(profile.MaxBalance)) < 0)
+			if balance.Sign() == 0 {
+				zeroBalances++
+			} else {
+				empty = false
+			}
+			if balance.Cmp(maxBalance) > 0 {
+				maxBalance = balance
+			}
+		}
+		if empty {
+			emptyAccounts++
+		}
+	}
+	assert.True(emptyAccounts > 250 && emptyAccounts < 450, "expected about a third of empty accounts, got %d", emptyAccounts)
+	assert.True(zeroBalances > len(accounts)*GetNumberOfAssets()*3/4, "expected sparse assets, got %d zero balances", zeroBalances)
+	assert.True(maxBalance.Cmp(big.NewInt(1_000_000)) > 0, "expected a heavy tail, got a maximum balance of %s", maxBalance)
+
+	// balances are capped below the maximum balance
+	cappedProfile := TestDataProfile{MaxBalance: 10, PowerLawExponent: 0.1, AssetCoveragePercent: 100}
+	accounts, _, _, _ = GenerateTestDataWithProfile(100, 7, cappedProfile)
+	for _, account := range accounts {
+		for _, balance := range account.Balance {
+			assert.True(balance.Cmp(big.NewInt(10)) < 0)
+		}
+	}
+
+	// profiles are found by name, and invalid profiles are rejected
+	for name, expected := range map[string]TestDataProfile{"": DEFAULT_TEST_DATA_PROFILE, "uniform": DEFAULT_TEST_DATA_PROFILE, "production": PRODUCTION_TEST_DATA_PROFILE} {
+		profile, err := GetTestDataProfile(name)
+		assert.NoError(err)
+		assert.Equal(expected, profile)
+	}
+	_, err := GetTestDataProfile("realistic")
+	assert.Error(err)
+	assert.Panics(func() { GenerateTestDataWithProfile(1, 0, TestDataProfile{}) })
+	assert.Panics(func() { GenerateTestDataWithProfile(1, 0, TestDataProfile{MaxBalance: 1, ZeroBalancePercent: 101}) })
+}
+
+func TestValidateWitnessInputs(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	accounts, assetSum, _, _ := GenerateTestData(8, 9)
+	assert.NoError(ValidateWitnessInputs(accounts, assetSum))
+
+	// every offending account is identified, with the offending asset
+	invalidAccounts := make([]GoAccount, len(accounts))
+	for i, account := range accounts {
+		invalidAccounts[i] = GoAccount{WalletId: account.WalletId, Balance: slices.Clone(account.Balance), Salt: account.Salt}
+	}
+	invalidAccounts[1].Balance[0] = big.NewInt(-1)
+	invalidAccounts[3].WalletId = invalidAccounts[2].WalletId
+	invalidAccounts[4].Balance[1] = new(big.Int).Lsh(big.NewInt(1), BALANCE_BITS)
+	invalidAccounts[6].Salt = ecc.BN254.ScalarField().Bytes()
+	err := ValidateWitnessInputs(invalidAccounts, assetSum)
+	var errs WitnessInputErrors
+	assert.True(errors.As(err, &errs))
+	assert.Equal([]WitnessInputError{
+		{AccountIndex: 1, AssetIndex: 0, Reason: "balance -1 is negative"},
+		{AccountIndex: 3, AssetIndex: -1, Reason: "WalletId is the WalletId of account 2"},
+		{AccountIndex: 4, AssetIndex: 1, Reason: fmt.Sprintf("balance %s exceeds the %d-bit range of the circuit", invalidAccounts[4].Balance[1], BALANCE_BITS)},
+		{AccountIndex: 6, AssetIndex: -1, Reason: "salt is not a field element"},
+	}, []WitnessInputError(errs))
+	assert.ErrorContains(err, "4 invalid witness inputs: account 1 ("+GetAssetSymbols()[0]+"): balance -1 is negative")
+
+	// problems with the batch and its asset sum
+	wrongAssetSum := slices.Clone(assetSum)
+	wrongAssetSum[2] = new(big.Int).Add(assetSum[2], big.NewInt(1))
+	err = ValidateWitnessInputs(accounts, wrongAssetSum)
+	assert.True(errors.As(err, &errs))
+	assert.Equal([]WitnessInputError{{AccountIndex: -1, AssetIndex: 2, Reason: fmt.Sprintf("asset sum %s is not the sum of the balances (%s)", wrongAssetSum[2], assetSum[2])}}, []WitnessInputError(errs))
+	assert.Error(ValidateWitnessInputs(nil, ConstructGoBalance()))
+	assert.Error(ValidateWitnessInputs(accounts, assetSum[:1]))
+	assert.Error(Config{TreeDepth: 2}.ValidateWitnessInputs(accounts, assetSum))
+	assert.Error(Config{TreeDepth: 3, BalanceBits: 8}.ValidateWitnessInputs(accounts, assetSum))
+	assert.NoError(Config{TreeDepth: 3, BalanceBits: 14, AssetSumBits: 32}.ValidateWitnessInputs(accounts, assetSum))
+}
+
+func TestCountComponentConstraints(t *testing.T) {
+	assert := test.NewAssert(t)
+	defer SetAssetRegistry(DefaultAssetRegistry())
+
+	// more accounts, depth, or balance bits need more constraints
+	config := Config{TreeDepth: 3}
+	counts, err := CountComponentConstraints(4, config)
+	assert.NoError(err)
+	assert.True(counts.RangeChecks > 0 && counts.Merkle > 0 && counts.Sum > 0)
+	moreAccounts, err := CountComponentConstraints(8, config)
+	assert.NoError(err)
+	assert.True(moreAccounts.RangeChecks > counts.RangeChecks)
+	assert.True(moreAccounts.Merkle > counts.Merkle)
+	deeper, err := CountComponentConstraints(4, Config{TreeDepth: 4})
+	assert.NoError(err)
+	assert.True(deeper.Merkle > counts.Merkle)
+	assert.Equal(counts.RangeChecks, deeper.RangeChecks)
+	wider, err := CountComponentConstraints(4, Config{TreeDepth: 3, BalanceBits: 200})
+	assert.NoError(err)
+	assert.True(wider.RangeChecks > counts.RangeChecks)
+
+	// so do more assets
+	registry := DefaultAssetRegistry()
+	registry.Assets = append(registry.Assets, Asset{Symbol: "NEW", Decimals: 8})
+	assert.NoError(SetAssetRegistry(registry))
+	moreAssets, err := CountComponentConstraints(4, config)
+	assert.NoError(err)
+	assert.True(moreAssets.RangeChecks > counts.RangeChecks)
+	assert.True(moreAssets.Sum > counts.Sum)
+
+	_, err = CountComponentConstraints(9, config)
+	assert.Error(err)
+	_, err = CountComponentConstraints(4, Config{})
+	assert.Error(err)
+}
+
+// sliceAccountIterator is a GoAccountIterator over a slice of accounts.
+type sliceAccountIterator struct {
+	accounts []GoAccount
+}
+
+func (it *sliceAccountIterator) Next(ctx context.Context) (GoAccount, error) {
+	if len(it.accounts) == 0 {
+		return GoAccount{}, io.EOF
+	}
+	account := it.accounts[0]
+	it.accounts = it.accounts[1:]
+	return account, nil
+}
+
+func TestBuildMerkleTree(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// the streamed tree is the same as the tree computed in memory
+	accounts, _, merkleRoot, _ := GenerateTestData(1000, 4)
+	dir := t.TempDir()
+	nodeFiles, err := CreateMerkleNodeFiles(dir, GetTreeDepth())
+	assert.NoError(err)
+	root, accountCount, err := BuildMerkleTree(context.Background(), &sliceAccountIterator{accounts}, GetTreeDepth(), nodeFiles)
+	assert.NoError(err)
+	assert.NoError(nodeFiles.Close())
+	assert.Equal(1000, accountCount)
+	assert.Equal(merkleRoot, root)
+
+	nodes := GoComputeMerkleTreeNodesFromAccounts(accounts)
+	readNodes, err := ReadMerkleNodeFiles(dir, GetTreeDepth())
+	assert.NoError(err)
+	assert.Equal(nodes, readNodes)
+	for _, position := range []int{0, 1, 999, PowOfTwo(GetTreeDepth()) - 1} {
+		path, err := ReadMerklePathFromNodeFiles(dir, GetTreeDepth(), position)
+		assert.NoError(err)
+		assert.Equal(ComputeMerklePath(position, nodes), path)
+	}
+	_, err = ReadMerklePathFromNodeFiles(dir, GetTreeDepth(), PowOfTwo(GetTreeDepth()))
+	assert.Error(err)
+
+	// an empty stream gives the tree of no accounts
+	emptyFiles, err := CreateMerkleNodeFiles(t.TempDir(), 2)
+	assert.NoError(err)
+	root, accountCount, err = BuildMerkleTree(context.Background(), &sliceAccountIterator{}, 2, emptyFiles)
+	assert.NoError(err)
+	assert.NoError(emptyFiles.Close())
+	assert.Equal(0, accountCount)
+	assert.Equal(goComputeMerkleRootFromHashes(nil, 2), root)
+
+	// too many accounts for the tree depth
+	tooManyFiles, err := CreateMerkleNodeFiles(t.TempDir(), 2)
+	assert.NoError(err)
+	defer tooManyFiles.Close()
+	_, _, err = BuildMerkleTree(context.Background(), &sliceAccountIterator{accounts[:5]}, 2, tooManyFiles)
+	assert.ErrorContains(err, MERKLE_TREE_LEAF_LIMIT_EXCEEDED_MESSAGE)
+}
+
+func TestSparseMerkleTree(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	accounts, _, _, _ := GenerateTestData(20, 5)
+	tree := NewSparseMerkleTree()
+	emptyRoot, err := tree.Root()
+	assert.NoError(err)
+	assert.NoError(tree.AddAccounts(accounts[:10]))
+	root, err := tree.Root()
+	assert.NoError(err)
+	assert.NotEqual(emptyRoot, root)
+
+	// the root does not depend on the order the users are added in
+	reversed := NewSparseMerkleTree()
+	for i := 9; i >= 0; i-- {
+		assert.NoError(reversed.AddAccount(accounts[i]))
+	}
+	reversedRoot, err := reversed.Root()
+	assert.NoError(err)
+	assert.Equal(root, reversedRoot)
+
+	// users that were not added have a path from the zero leaf at their key to the root
+	zeroLeaf := padToModBytes(big.NewInt(0))
+	for _, account := range accounts[10:] {
+		path, err := tree.NonInclusionPath(account.WalletId)
+		assert.NoError(err)
+		key, err := ComputeSparseTreeKey(account.WalletId)
+		assert.NoError(err)
+		computedRoot, err := GoComputeSparseMerkleRoot(key, zeroLeaf, path)
+		assert.NoError(err)
+		assert.Equal(root, computedRoot)
+	}
+
+	// users that were added have none, and the siblings of their path lead from their account hash to the root
+	_, err = tree.NonInclusionPath(accounts[0].WalletId)
+	assert.Error(err)
+	path, err := tree.NonInclusionPath(accounts[10].WalletId)
+	assert.NoError(err)
+	assert.NoError(tree.AddAccount(accounts[10]))
+	newRoot, err := tree.Root()
+	assert.NoError(err)
+	key, err := ComputeSparseTreeKey(accounts[10].WalletId)
+	assert.NoError(err)
+	computedRoot, err := GoComputeSparseMerkleRoot(key, GoComputeMiMCHashForAccount(accounts[10]), path)
+	assert.NoError(err)
+	assert.Equal(newRoot, computedRoot)
+
+	// a path of the wrong depth is rejected
+	_, err = GoComputeSparseMerkleRoot(key, zeroLeaf, path[1:])
+	assert.Error(err)
+}
+
+func TestWalletIdEncodings(t *testing.T) {
+	assert := test.NewAssert(t)
+	defer SetWalletIdEncoding(GetWalletIdEncoding())
+
+	for _, name := range []string{"", "base36", "utf8", "hex", "hashed"} {
+		_, err := ParseWalletIdEncoding(name)
+		assert.NoError(err, name)
+	}
+	_, err := ParseWalletIdEncoding("base64")
+	assert.Error(err)
+	assert.Error(SetWalletIdEncoding("base64"))
+	assert.Equal(WALLET_ID_BASE36, GetWalletIdEncoding())
+
+	longEmail := strings.Repeat("a", MAX_UTF8_WALLET_ID_BYTES) + "@example.com"
+	tests := []struct {
+		name     string
+		encoding WalletIdEncoding
+		walletId string
+		expected []byte
+		// written is the raw WalletId written back from the account (walletId if empty)
+		written string
+		hashed  bool
+	}{
+		{"base36", WALLET_ID_BASE36, "user-123", convertRawWalletIdToBytes("user123"), "user123", false},
+		{"utf8", WALLET_ID_UTF8, "Alice_Smith@example.com", []byte("Alice_Smith@example.com"), "", false},
+		{"long utf8", WALLET_ID_UTF8, longEmail, hashBytesToField([]byte(longEmail)), "", true},
+		{"hex", WALLET_ID_HEX, "0x00AbCd", []byte{0xab, 0xcd}, "abcd", false},
+		{"hashed", WALLET_ID_HASHED, "Alice_Smith", hashBytesToField([]byte("Alice_Smith")), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := test.NewAssert(t)
+			assert.NoError(SetWalletIdEncoding(tt.encoding))
+			account := ConvertRawGoAccountToGoAccount(RawGoAccount{WalletId: tt.walletId, Balance: ConstructGoBalance()})
+			assert.Equal(tt.expected, account.WalletId)
+
+			// accounts are written back so that they are read as the same account
+			rawAccount := ConvertGoAccountToRawGoAccount(account)
+			assert.Equal(tt.hashed, rawAccount.HashedWalletId)
+			if tt.hashed {
+				assert.Equal(new(big.Int).SetBytes(tt.expected).Text(36), rawAccount.WalletId)
+			} else if tt.written != "" {
+				assert.Equal(tt.written, rawAccount.WalletId)
+			} else {
+				assert.Equal(tt.walletId, rawAccount.WalletId)
+			}
+			assert.Equal(account.WalletId, ConvertRawGoAccountToGoAccount(rawAccount).WalletId)
+
+			// and read the same way whatever the configured encoding, given the encoding they were written with
+			assert.NoError(SetWalletIdEncoding(WALLET_ID_BASE36))
+			assert.Equal(account.WalletId, ConvertRawGoAccountToGoAccountWithEncoding(rawAccount, tt.encoding).WalletId)
+		})
+	}
+
+	// WalletIds the encoding can't represent
+	assert.Panics(func() { WALLET_ID_UTF8.convertRawWalletIdToBytes("") })
+	assert.Panics(func() { WALLET_ID_UTF8.convertRawWalletIdToBytes("\xff") })
+	assert.Panics(func() { WALLET_ID_HEX.convertRawWalletIdToBytes("user") })
+	assert.Panics(func() { WALLET_ID_HEX.convertRawWalletIdToBytes("-ab") })
+	assert.Panics(func() { WALLET_ID_HEX.convertRawWalletIdToBytes(ecc.BN254.ScalarField().Text(16)) })
+	assert.Panics(func() { WALLET_ID_HASHED.convertRawWalletIdToBytes("") })
+}
+
+func TestParseAssetAmount(t *testing.T) {
+	assert := test.NewAssert(t)
+	btc := 3 // index of BTC (8 decimals) in the default registry
+
+	for _, amount := range []string{"1.25 BTC", "1.25", " 1.25  BTC "} {
+		baseUnits, err := ParseAssetAmount(amount, btc)
+		assert.NoError(err, amount)
+		assert.Equal(big.NewInt(125000000), baseUnits, amount)
+	}
+	for _, amount := range []string{"1.25 ETH", "1.25 BTC extra", "", "1.123456789 BTC", "abc BTC"} {
+		_, err := ParseAssetAmount(amount, btc)
+		assert.Error(err, amount)
+	}
+	_, err := ParseAssetAmount("1", GetNumberOfAssets())
+	assert.Error(err)
+
+	assert.Equal("1.25 BTC", FormatAssetAmount(big.NewInt(125000000), btc))
+	baseUnits, err := ParseAssetAmount(FormatAssetAmount(big.NewInt(1), btc), btc)
+	assert.NoError(err)
+	assert.Equal(big.NewInt(1), baseUnits)
+}
+
+func TestRawGoAccountUnmarshalJSON(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// balances can mix base units and display amounts
+	var account RawGoAccount
+	assert.NoError(json.Unmarshal([]byte(`{"WalletId": "user1", "Balance": [1000000, "2.5", "0", "1.25 BTC"], "Salt": "01"}`), &account))
+	assert.Equal(RawGoAccount{
+		WalletId: "user1",
+		Balance:  GoBalance{big.NewInt(1000000), big.NewInt(2500000000000000000), big.NewInt(0), big.NewInt(125000000)},
+		Salt:     "01",
+	}, account)
+
+	// accounts are written in base units, and read back unchanged
+	encoded, err := json.Marshal(account)
+	assert.NoError(err)
+	var decoded RawGoAccount
+	assert.NoError(json.Unmarshal(encoded, &decoded))
+	assert.Equal(account, decoded)
+
+	for _, invalid := range []string{
+		`{"WalletId": "user1", "Balance": ["1.25 ETH"]}`,
+		`{"WalletId": "user1", "Balance": ["1.0000001"]}`,
+		`{"WalletId": "user1", "Balance": [1.5]}`,
+	} {
+		assert.Error(json.Unmarshal([]byte(invalid), &decoded), invalid)
+	}
+}
+
+func TestWithErrorVariants(t *testing.T) {
+	assert := test.NewAssert(t)
+	accounts, assetSum, merkleRoot, _ := GenerateTestData(10, 5)
+
+	// the error-returning variants give the same results as the panicking ones
+	hashes, err := GoComputeMiMCHashesForAccountsWithError(accounts)
+	assert.NoError(err)
+	assert.Equal(GoComputeMiMCHashesForAccounts(accounts), hashes)
+	accountHash, err := GoComputeMiMCHashForAccountWithError(accounts[0])
+	assert.NoError(err)
+	assert.Equal(hashes[0], accountHash)
+	root, err := GoComputeMerkleRootFromAccountsWithError(accounts)
+	assert.NoError(err)
+	assert.Equal(merkleRoot, root)
+	nodes, err := GoComputeMerkleTreeNodesFromAccountsWithError(accounts)
+	assert.NoError(err)
+	assert.Equal(GoComputeMerkleTreeNodesFromAccounts(accounts), nodes)
+	path, err := ComputeMerklePathWithError(3, nodes)
+	assert.NoError(err)
+	assert.Equal(ComputeMerklePath(3, nodes), path)
+	sum, err := SumGoAccountBalancesWithError(accounts)
+	assert.NoError(err)
+	assert.Equal(assetSum, sum)
+	balance, err := ConvertGoBalanceToBalanceWithError(assetSum)
+	assert.NoError(err)
+	assert.Equal(ConvertGoBalanceToBalance(assetSum), balance)
+	padded, err := PadToModBytesWithError(big.NewInt(1))
+	assert.NoError(err)
+	assert.Equal(padToModBytes(big.NewInt(1)), padded)
+
+	// and return the errors the panicking ones panic with
+	negativeAccounts := append([]GoAccount{}, accounts...)
+	negativeAccounts[7] = GoAccount{WalletId: accounts[7].WalletId, Balance: ConstructGoBalance(big.NewInt(-1))}
+	shortAccounts := append([]GoAccount{}, accounts...)
+	shortAccounts[2] = GoAccount{WalletId: accounts[2].WalletId, Balance: GoBalance{big.NewInt(1)}}
+	tooManyHashes := make([]Hash, GetAccountsPerBatch()+1)
+	invalidNodes := [][]Hash{{}, {padToModBytes(big.NewInt(0))}}
+
+	tests := []struct {
+		name         string
+		withError    func() error
+		panicking    func()
+		errorMessage string
+	}{
+		{"negative value", func() error { _, err := PadToModBytesWithError(big.NewInt(-1)); return err }, func() { padToModBytes(big.NewInt(-1)) }, "negative value cannot be used in the circuit"},
+		{"value too long", func() error { _, err := PadToModBytesWithError(new(big.Int).Lsh(big.NewInt(1), 256)); return err }, func() { padToModBytes(new(big.Int).Lsh(big.NewInt(1), 256)) }, "value exceeds 32 bytes"},
+		{"negative balance", func() error { _, err := GoComputeMiMCHashesForAccountsWithError(negativeAccounts); return err }, func() { GoComputeMiMCHashesForAccounts(negativeAccounts) }, "negative value cannot be used in the circuit"},
+		{"negative balance sum", func() error { _, err := SumGoAccountBalancesWithError(negativeAccounts); return err }, func() { SumGoAccountBalances(negativeAccounts) }, "negative asset balance found"},
+		{"short balance", func() error { _, err := GoComputeMerkleRootFromAccountsWithError(shortAccounts); return err }, func() { GoComputeMerkleRootFromAccounts(shortAccounts) }, INVALID_BALANCE_LENGTH_MESSAGE},
+		{"short balance conversion", func() error { _, err := ConvertGoBalanceToBalanceWithError(GoBalance{}); return err }, func() { ConvertGoBalanceToBalance(GoBalance{}) }, INVALID_BALANCE_LENGTH_MESSAGE},
+		{"too many hashes", func() error { _, err := GoComputeMerkleRootFromHashesWithError(tooManyHashes); return err }, func() { GoComputeMerkleRootFromHashes(tooManyHashes) }, MERKLE_TREE_LEAF_LIMIT_EXCEEDED_MESSAGE},
+		{"position out of bounds", func() error { _, err := ComputeMerklePathWithError(len(nodes[len(nodes)-1]), nodes); return err }, func() { ComputeMerklePath(len(nodes[len(nodes)-1]), nodes) }, "position is out of bounds"},
+		{"invalid nodes", func() error { _, err := ComputeMerklePathWithError(0, invalidNodes); return err }, func() { ComputeMerklePath(0, invalidNodes) }, "merkle nodes provided are not of correct structure"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := test.NewAssert(t)
+			err := tt.withError()
+			assert.ErrorContains(err, tt.errorMessage)
+			assert.PanicsWithValue(err.Error(), tt.panicking)
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package circuit
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/consensys/gnark-crypto/ecc"
+)
+
+// WalletIdEncoding identifies how the WalletId strings of files are converted to the field element accounts are
+// hashed with. It is recorded in batch files, user verification packages, and the round metadata, so WalletIds are
+// read back with the encoding they were written with.
+type WalletIdEncoding string
+
+const (
+	// WALLET_ID_BASE36 interprets WalletIds as base36 numbers, ignoring hyphens and case (the default, and the
+	// encoding of files that do not record one). WalletIds longer than MAX_BASE36_WALLET_ID_LENGTH characters are
+	// hashed (see hashWalletIdToField).
+	WALLET_ID_BASE36 WalletIdEncoding = "base36"
+	// WALLET_ID_UTF8 interprets the UTF-8 bytes of WalletIds as big-endian numbers, so WalletIds can have any
+	// characters (e.g. emails). WalletIds longer than MAX_UTF8_WALLET_ID_BYTES bytes are hashed with SHA-256.
+	WALLET_ID_UTF8 WalletIdEncoding = "utf8"
+	// WALLET_ID_HEX interprets WalletIds as hex numbers (with an optional 0x prefix), which must be field elements.
+	WALLET_ID_HEX WalletIdEncoding = "hex"
+	// WALLET_ID_HASHED hashes every WalletId with SHA-256 (case-sensitive), so WalletIds can have any characters and
+	// any length, but files written from accounts only have the hashes of their WalletIds.
+	WALLET_ID_HASHED WalletIdEncoding = "hashed"
+)
+
+// MAX_UTF8_WALLET_ID_BYTES is the longest UTF-8 WalletId (in bytes) whose value always fits in the BN254 scalar field.
+const MAX_UTF8_WALLET_ID_BYTES = 31
+
+// ParseWalletIdEncoding returns the WalletId encoding with the given name. The empty name is base36, the encoding of
+// files written before the encoding was recorded.
+func ParseWalletIdEncoding(name string) (WalletIdEncoding, error) {
+	switch WalletIdEncoding(name) {
+	case "", WALLET_ID_BASE36:
+		return WALLET_ID_BASE36, nil
+	case WALLET_ID_UTF8, WALLET_ID_HEX, WALLET_ID_HASHED:
+		return WalletIdEncoding(name), nil
+	}
+	return "", fmt.Errorf("unknown WalletId encoding %q (expected %s, %s, %s, or %s)", name, WALLET_ID_BASE36, WALLET_ID_UTF8, WALLET_ID_HEX, WALLET_ID_HASHED)
+}
+
+// SetWalletIdEncoding sets the encoding of the WalletIds of the account files read by the prover (and of the files
+// it writes). Files that record their encoding are always read with it.
+func SetWalletIdEncoding(encoding WalletIdEncoding) error {
+	parsed, err := ParseWalletIdEncoding(string(encoding))
+	if err != nil {
+		return err
+	}
+	return updateSettings(func(s *settings) error {
+		s.walletIdEncoding = parsed
+		return nil
+	})
+}
+
+func GetWalletIdEncoding() WalletIdEncoding {
+	return getSettings().walletIdEncoding
+}
+
+// convertRawWalletIdToBytes converts a raw WalletId to the bytes of its field element with the encoding. The encoding
+// must be valid (see ParseWalletIdEncoding).
+func (e WalletIdEncoding) convertRawWalletIdToBytes(walletId string) []byte {
+	switch e {
+	case WALLET_ID_UTF8:
+		if walletId == "" || !utf8.ValidString(walletId) {
+			panic(fmt.Sprintf("walletId is not a non-empty UTF-8 string: %q", walletId))
+		}
+		if len(walletId) > MAX_UTF8_WALLET_ID_BYTES {
+			return hashBytesToField([]byte(walletId))
+		}
+		return new(big.Int).SetBytes([]byte(walletId)).Bytes()
+	case WALLET_ID_HEX:
+		n, ok := new(big.Int).SetString(strings.TrimPrefix(strings.TrimPrefix(walletId, "0x"), "0X"), 16)
+		if !ok || n.Sign() < 0 || n.Cmp(ecc.BN254.ScalarField()) >= 0 {
+			panic("walletId is not a hex field element: " + walletId)
+		}
+		return n.Bytes()
+	case WALLET_ID_HASHED:
+		if walletId == "" {
+			panic("walletId is empty")
+		}
+		return hashBytesToField([]byte(walletId))
+	}
+	return convertRawWalletIdToBytes(walletId)
+}
+
+// convertBytesToRawWalletId converts the field element of a WalletId back to a raw WalletId of the encoding. If the
+// WalletId was hashed (or is otherwise not one the encoding gives), it returns the field element in base36 and true
+// instead, see RawGoAccount.HashedWalletId.
+func (e WalletIdEncoding) convertBytesToRawWalletId(walletId []byte) (rawWalletId string, hashed bool) {
+	n := new(big.Int).SetBytes(walletId)
+	switch e {
+	case WALLET_ID_UTF8:
+		// only values of valid UTF-8 strings without leading zero bytes are read back as the same value
+		value := n.Bytes()
+		if len(value) > 0 && len(value) <= MAX_UTF8_WALLET_ID_BYTES && utf8.Valid(value) {
+			return string(value), false
+		}
+	case WALLET_ID_HEX:
+		return n.Text(16), false
+	case WALLET_ID_HASHED:
+	default:
+		// only the field element of a hashed WalletId can be longer, and it must not be hashed again when read back
+		if rawWalletId = n.Text(36); len(rawWalletId) <= MAX_BASE36_WALLET_ID_LENGTH {
+			return rawWalletId, false
+		}
+	}
+	return n.Text(36), true
+}
+
+// Convert raw WalletId string to a []byte by removing any hyphens, interpreting it as
+// a base36 number, and then converting that number to a []byte. If this is used to
+// get the GoAccount.WalletId, the walletId should not exceed BN254 curve limit as long
+// as the string is less than 49 characters in length. Longer WalletIds (after removing
+// hyphens) are hashed to a field element instead (see hashWalletIdToField).
+func convertRawWalletIdToBytes(walletId string) []byte {
+	// remove any hyphens from user id
+	cleanedWalletId := strings.ReplaceAll(walletId, "-", "")
+	if len(cleanedWalletId) > MAX_BASE36_WALLET_ID_LENGTH {
+		return hashWalletIdToField(cleanedWalletId)
+	}
+
+	// convert to bytes (interpreted as a base36 string)
+	n := new(big.Int)
+	_, ok := n.SetString(cleanedWalletId, 36)
+	if !ok {
+		panic("failed to convert walletId to big.Int from base36: " + cleanedWalletId)
+	}
+	return n.Bytes()
+}
+
+// hashWalletIdToField hashes a (cleaned) WalletId that is too long to be interpreted as a base36 field element, i.e.
+// SHA-256(lowercase WalletId) reduced modulo the BN254 scalar field. Base36 is case-insensitive, so the WalletId is
+// lowercased first to identify the same accounts as base36 WalletIds do. It must contain only base36 characters.
+func hashWalletIdToField(cleanedWalletId string) []byte {
+	for _, c := range cleanedWalletId {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			panic("failed to hash walletId, it is not a base36 string: " + cleanedWalletId)
+		}
+	}
+	return hashBytesToField([]byte(strings.ToLower(cleanedWalletId)))
+}
+
+// hashBytesToField returns SHA-256(value) reduced modulo the BN254 scalar field.
+func hashBytesToField(value []byte) []byte {
+	digest := sha256.Sum256(value)
+	return new(big.Int).Mod(new(big.Int).SetBytes(digest[:]), ecc.BN254.ScalarField()).Bytes()
+}
+
+// convertHashedWalletIdToBytes converts the base36 field element of a hashed WalletId (see RawGoAccount.HashedWalletId)
+// to a []byte.
+func convertHashedWalletIdToBytes(hashedWalletId string) []byte {
+	n, ok := new(big.Int).SetString(hashedWalletId, 36)
+	if !ok || n.Cmp(ecc.BN254.ScalarField()) >= 0 {
+		panic("hashed walletId is not a base36 field element: " + hashedWalletId)
+	}
+	return n.Bytes()
+}
@@ -0,0 +1,131 @@
+package circuit
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/consensys/gnark-crypto/ecc"
+)
+
+// WitnessInputError is a problem with the inputs of a witness found by ValidateWitnessInputs.
+type WitnessInputError struct {
+	// AccountIndex is the index of the offending account, or -1 if the problem is with the batch or its asset sum.
+	AccountIndex int
+	// AssetIndex is the index of the offending balance in the asset registry, or -1 if the problem is not with a
+	// balance.
+	AssetIndex int
+	// Reason describes the problem.
+	Reason string
+}
+
+func (e WitnessInputError) Error() string {
+	location := "batch"
+	if e.AccountIndex >= 0 {
+		location = fmt.Sprintf("account %d", e.AccountIndex)
+	}
+	if e.AssetIndex >= 0 {
+		location += fmt.Sprintf(" (%s)", GetAssetSymbols()[e.AssetIndex])
+	}
+	return location + ": " + e.Reason
+}
+
+// WitnessInputErrors are all the problems found by ValidateWitnessInputs, in the order of the accounts (problems with
+// the batch or its asset sum come last).
+type WitnessInputErrors []WitnessInputError
+
+func (errs WitnessInputErrors) Error() string {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("%d invalid witness inputs: %s", len(errs), strings.Join(messages, "; "))
+}
+
+// ValidateWitnessInputs checks that the accounts and asset sum of a batch can be proven with the configured circuit
+// (see Config.ValidateWitnessInputs), before the expensive witness creation and proving.
+func ValidateWitnessInputs(accounts []GoAccount, assetSum GoBalance) error {
+	return GetConfig().ValidateWitnessInputs(accounts, assetSum)
+}
+
+// ValidateWitnessInputs checks that the accounts and asset sum of a batch can be proven with a circuit of this
+// configuration: the batch fits in the tree, every account has a balance for every asset in the range of the circuit,
+// its WalletId and salt are field elements, no two accounts have the same WalletId, and the asset sum is the sum of the
+// balances, in the range of the circuit. Returns nil if they can be proven, or the WitnessInputErrors identifying every
+// offending account otherwise.
+func (c Config) ValidateWitnessInputs(accounts []GoAccount, assetSum GoBalance) error {
+	var errs WitnessInputErrors
+	report := func(accountIndex int, assetIndex int, format string, args ...any) {
+		errs = append(errs, WitnessInputError{AccountIndex: accountIndex, AssetIndex: assetIndex, Reason: fmt.Sprintf(format, args...)})
+	}
+	modulus := ecc.BN254.ScalarField()
+	balanceBits := GetAssetBalanceBits(c.GetBalanceBits())
+
+	// the asset sum is only compared with the sum of the balances of an asset if all of them are valid
+	positions := make(map[string]int, len(accounts))
+	sum := make([]*big.Int, GetNumberOfAssets())
+	summed := make([]bool, GetNumberOfAssets())
+	for i := range sum {
+		sum[i] = new(big.Int)
+		summed[i] = true
+	}
+	for i, account := range accounts {
+		if new(big.Int).SetBytes(account.WalletId).Cmp(modulus) >= 0 {
+			report(i, -1, "WalletId is not a field element")
+		}
+		if new(big.Int).SetBytes(account.Salt).Cmp(modulus) >= 0 {
+			report(i, -1, "salt is not a field element")
+		}
+		if j, ok := positions[string(account.WalletId)]; ok {
+			report(i, -1, "WalletId is the WalletId of account %d", j)
+		} else {
+			positions[string(account.WalletId)] = i
+		}
+		if len(account.Balance) != GetNumberOfAssets() {
+			report(i, -1, "has %d balances, but there are %d assets", len(account.Balance), GetNumberOfAssets())
+			clear(summed)
+			continue
+		}
+		for j, balance := range account.Balance {
+			switch {
+			case balance == nil:
+				report(i, j, "balance is missing")
+				summed[j] = false
+			case balance.Sign() < 0:
+				report(i, j, "balance %s is negative", balance)
+				summed[j] = false
+			case balance.BitLen() > balanceBits[j]:
+				report(i, j, "balance %s exceeds the %d-bit range of the circuit", balance, balanceBits[j])
+				summed[j] = false
+			default:
+				sum[j].Add(sum[j], balance)
+			}
+		}
+	}
+
+	if len(accounts) == 0 {
+		report(-1, -1, "there are no accounts")
+	}
+	if len(accounts) > c.AccountsPerBatch() {
+		report(-1, -1, "%d accounts exceed the %d accounts of a tree of depth %d", len(accounts), c.AccountsPerBatch(), c.TreeDepth)
+	}
+	if len(assetSum) != GetNumberOfAssets() {
+		report(-1, -1, "asset sum has %d balances, but there are %d assets", len(assetSum), GetNumberOfAssets())
+	} else {
+		for j, bits := range GetAssetBalanceBits(c.GetAssetSumBits()) {
+			switch {
+			case assetSum[j] == nil:
+				report(-1, j, "asset sum is missing")
+			case summed[j] && assetSum[j].Cmp(sum[j]) != 0:
+				report(-1, j, "asset sum %s is not the sum of the balances (%s)", assetSum[j], sum[j])
+			case assetSum[j].BitLen() > bits:
+				report(-1, j, "asset sum %s exceeds the %d-bit range of the circuit", assetSum[j], bits)
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package circuit
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/consensys/gnark-crypto/hash"
+)
+
+// MIN_HASHES_PER_WORKER is the least number of hashes a hash worker is started for, as smaller chunks are faster to
+// hash than to hand to a goroutine.
+const MIN_HASHES_PER_WORKER = 64
+
+// SetHashWorkers sets the number of goroutines account and node hashes are computed with in Go (the number of CPUs by
+// default). It does not change any hash, only how fast they are computed.
+func SetHashWorkers(workers int) error {
+	if workers < 1 {
+		return fmt.Errorf("number of hash workers must be at least 1, got %d", workers)
+	}
+	return updateSettings(func(s *settings) error {
+		s.hashWorkers = workers
+		return nil
+	})
+}
+
+func GetHashWorkers() int {
+	return getSettings().hashWorkers
+}
+
+// goHashInParallel calls hashRange for consecutive chunks of [0, count) on up to hashWorkers goroutines, each with its
+// own hasher of the hash function (see NewGoHasher), and returns once every chunk is hashed. A panic in a chunk is raised again in the
+// caller once the other chunks are done.
+func goHashInParallel(hashFunction HashFunction, count int, hashRange func(hasher hash.StateStorer, start, end int)) {
+	workers := min(GetHashWorkers(), (count+MIN_HASHES_PER_WORKER-1)/MIN_HASHES_PER_WORKER)
+	if workers <= 1 {
+		hashRange(NewGoHasher(hashFunction), 0, count)
+		return
+	}
+
+	var wg sync.WaitGroup
+	var panicOnce sync.Once
+	var panicValue any
+	chunkSize := (count + workers - 1) / workers
+	for start := 0; start < count; start += chunkSize {
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					panicOnce.Do(func() { panicValue = r })
+				}
+			}()
+			hashRange(NewGoHasher(hashFunction), start, end)
+		}(start, min(start+chunkSize, count))
+	}
+	wg.Wait()
+	if panicValue != nil {
+		panic(panicValue)
+	}
+}
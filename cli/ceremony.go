@@ -0,0 +1,79 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"bitgo.com/proof_of_reserves/core"
+	"github.com/spf13/cobra"
+)
+
+var ceremonyUpperLevel bool
+
+var ceremonyCmd = &cobra.Command{
+	Use:   "ceremony",
+	Short: "Runs an MPC setup ceremony for the Groth16 keys of a circuit, so its setup isn't single-party",
+}
+
+var ceremonyInitCmd = &cobra.Command{
+	Use:   "init [AccountCount] [Phase1File] [CeremonyDir]",
+	Short: "Starts a ceremony for the circuit for AccountCount accounts from the phase 1 parameters of a public ceremony",
+	Long: "Compiles the circuit for AccountCount accounts with the current backend, hash, circuit, and asset registry\n" +
+		"flags (which must include --plain-range-checks), and writes the phase 2 parameters derived from it and from the\n" +
+		"powers of tau in Phase1File (in gnark's mpcsetup format) to CeremonyDir, with the ceremony transcript. With\n" +
+		"'--upper-level', the ceremony is for the circuit of an upper level proof of AccountCount lower level proofs.",
+	Args: cobra.ExactArgs(3),
+	Run: func(cmd *cobra.Command, args []string) {
+		accountCount, err := strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Println("Error parsing account count:", err)
+			os.Exit(1)
+		}
+		if _, err := core.InitCeremony(accountCount, ceremonyUpperLevel, args[1], args[2]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Println("Ceremony started in", args[2])
+	},
+}
+
+var ceremonyContributeCmd = &cobra.Command{
+	Use:   "contribute [CeremonyDir]",
+	Short: "Adds a contribution of fresh randomness to the ceremony",
+	Long: "Adds a contribution of fresh randomness to the last phase 2 parameters of the ceremony in CeremonyDir, and\n" +
+		"prints its hash, which the participant can find in the setup manifest of the imported keys.",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		hash, err := core.ContributeToCeremony(args[0])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Println("Contribution hash:", hash)
+	},
+}
+
+var ceremonyImportCmd = &cobra.Command{
+	Use:   "import [CeremonyDir]",
+	Short: "Validates the ceremony against the compiled circuit, and writes its keys to the key directory",
+	Long: "Validates every contribution of the ceremony in CeremonyDir against the circuit compiled with the current\n" +
+		"flags, and writes the proving and verification keys it produced to the key directory, which prove, prove-batch,\n" +
+		"and aggregate then generate proofs with (see --key-dir).",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		setupCircuit, err := core.ImportCeremony(args[0], keyDir)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("Imported the keys of %d contributions, verification key %s\n", len(setupCircuit.Ceremony.ContributionHashes), setupCircuit.VerificationKeyFingerprint)
+	},
+}
+
+func init() {
+	ceremonyInitCmd.Flags().BoolVar(&ceremonyUpperLevel, "upper-level", false, "start the ceremony for the circuit of an upper level proof")
+	ceremonyImportCmd.Flags().StringVar(&keyDir, "key-dir", "keys", "directory to write the keys and setup manifest to")
+	ceremonyCmd.AddCommand(ceremonyInitCmd, ceremonyContributeCmd, ceremonyImportCmd)
+	rootCmd.AddCommand(ceremonyCmd)
+}
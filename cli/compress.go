@@ -0,0 +1,38 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"bitgo.com/proof_of_reserves/core"
+	"github.com/spf13/cobra"
+)
+
+var compressionFormat string
+
+var compressCmd = &cobra.Command{
+	Use:   "compress [Directory]",
+	Short: "Compresses the JSON files of a directory, e.g. 'out/public' before it is hosted for download",
+	Long: "Compresses every JSON file in the given directory and its subdirectories with gzip or zstd, replacing\n" +
+		"'name.json' with 'name.json.gz' or 'name.json.zst'. The commands of this tool read the compressed files in\n" +
+		"place of the originals, and verify their digests and signatures as the files they were published as.",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		compression, err := core.ParseCompression(compressionFormat)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		count, err := core.CompressFiles(args[0], compression)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("Compressed %d files with %s\n", count, compression)
+	},
+}
+
+func init() {
+	compressCmd.Flags().StringVar(&compressionFormat, "format", string(core.COMPRESSION_GZIP), "compression of the files: gzip, or zstd (smaller, but needs a zstd decoder)")
+	rootCmd.AddCommand(compressCmd)
+}
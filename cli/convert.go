@@ -0,0 +1,25 @@
+package cli
+
+import (
+	"fmt"
+
+	"bitgo.com/proof_of_reserves/core"
+	"github.com/spf13/cobra"
+)
+
+var convertProofCmd = &cobra.Command{
+	Use:   "convert-proof [InputFile] [OutputFile]",
+	Short: "Converts a proof between the JSON and binary formats",
+	Long: "Reads a proof and writes it to another file, in the binary format if the file name ends with '.bin' and in\n" +
+		"JSON otherwise. The binary format stores the proof, verification key, and hashes as raw bytes, so proofs with\n" +
+		"verification keys or merkle nodes are much smaller, while published proofs stay in JSON.",
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		core.WriteDataToFile(args[1], core.ReadDataFromFile[core.CompletedProof](args[0]))
+		fmt.Println("Proof written to", args[1])
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(convertProofCmd)
+}
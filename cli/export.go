@@ -0,0 +1,55 @@
+package cli
+
+import (
+	"fmt"
+	"strconv"
+
+	"bitgo.com/proof_of_reserves/core"
+	"github.com/spf13/cobra"
+)
+
+var (
+	exportOptions core.ExportOptions
+	notifyWebhook string
+)
+
+var exportUsersCmd = &cobra.Command{
+	Use:   "exportusers [BatchCount] [UserOutDir]",
+	Short: "Generates the verification package of every user using the data in 'out/'",
+	Long: "Generates the verification package of every user using the proofs in 'out/public/' and the user data in 'out/secret/'.\n" +
+		"Packages are written to shard directories in UserOutDir, and a done marker is written next to each shard directory\n" +
+		"once all its packages are written. Completed shards are skipped, so an interrupted export can be resumed by running\n" +
+		"the command again. Once every shard is complete, a manifest of all the shards is written to UserOutDir.\n" +
+		"With --reference-keys, packages reference the verification keys by fingerprint instead of including them, and are\n" +
+		"verified with the key bundle of the round (see 'keys bundle' and 'userverify --key-bundle').\n" +
+		"This function takes 2 arguments: the number of batches and the directory to write the packages to.",
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		batchCount, err := strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Println("Error parsing batchCount:", err)
+			return
+		}
+		if err := exportOptions.Validate(); err != nil {
+			fmt.Println("Error parsing options:", err)
+			return
+		}
+		if notifyWebhook != "" {
+			exportOptions.Notifier = core.WebhookNotifier{URL: notifyWebhook}
+		}
+		manifest := core.ExportUserPackages(batchCount, outDir(), args[1], exportOptions)
+		fmt.Printf("Exported %d user packages in %d shards.\n", manifest.UserCount, len(manifest.Shards))
+	},
+}
+
+func init() {
+	exportUsersCmd.Flags().IntVar(&exportOptions.ShardSize, "shard-size", 1, "number of batches per shard")
+	exportUsersCmd.Flags().IntVar(&exportOptions.Workers, "workers", 1, "number of shards exported in parallel")
+	exportUsersCmd.Flags().Float64Var(&exportOptions.MaxPackagesPerSecond, "rate", 0, "maximum packages written per second (0 for unlimited, at most 1e9)")
+	exportUsersCmd.Flags().StringVar(&notifyWebhook, "notify-webhook", "", "URL to post a completion event for every shard to")
+	exportUsersCmd.Flags().StringVar(&exportOptions.StorageURIPrefix, "storage-uri", "", "URI the packages in UserOutDir are stored under, used in completion events")
+	exportUsersCmd.Flags().BoolVar(&exportOptions.ReferenceVerificationKeys, "reference-keys", false, "reference verification keys by fingerprint instead of including them in packages")
+	exportUsersCmd.Flags().BoolVar(&exportOptions.MerklePathElements, "path-elements", false, "add the merkle path of each user with the side of each sibling to packages")
+	addRoundEpochFlag(exportUsersCmd)
+	rootCmd.AddCommand(exportUsersCmd)
+}
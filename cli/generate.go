@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strconv"
 
+	"bitgo.com/proof_of_reserves/circuit"
 	"bitgo.com/proof_of_reserves/core"
 	"github.com/spf13/cobra"
 )
@@ -24,10 +25,22 @@ var generateCmd = &cobra.Command{
 			fmt.Println("Error parsing accountsPerBatch:", err)
 			return
 		}
-		core.GenerateData(batchCount, accountsPerBatch, core.OUT_DIR)
+		profile, err := circuit.GetTestDataProfile(testDataProfile)
+		if err != nil {
+			fmt.Println("Error parsing profile:", err)
+			return
+		}
+		options.TestDataProfile = profile
+		core.SetOptions(options)
+		core.GenerateData(batchCount, accountsPerBatch, outDir())
 	},
 }
 
+var testDataProfile string
+
 func init() {
+	generateCmd.Flags().BoolVar(&options.AggregateUserAccounts, "aggregate-users", false, "give some users several accounts, which are merged into one account per user when proving with --aggregate-users")
+	generateCmd.Flags().StringVar(&testDataProfile, "profile", "uniform", "distribution of the generated accounts: uniform (uniform balances for every asset) or production (power-law balances, empty accounts, sparse assets and long user IDs)")
+	addRoundEpochFlag(generateCmd)
 	rootCmd.AddCommand(generateCmd)
 }
@@ -0,0 +1,81 @@
+package cli
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"bitgo.com/proof_of_reserves/core"
+	"github.com/spf13/cobra"
+)
+
+var keyBundleValidity time.Duration
+
+var keysCmd = &cobra.Command{
+	Use:   "keys",
+	Short: "Manages the signed bundles of verification keys distributed to verifiers",
+}
+
+var keysGenerateCmd = &cobra.Command{
+	Use:   "generate [SigningKeyPath]",
+	Short: "Generates a key for signing key bundles",
+	Long: "Generates an ed25519 key for signing key bundles and writes it to SigningKeyPath (which must not exist).\n" +
+		"Prints the public key, which verifiers need to trust the bundles.",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("Public key:", core.GenerateKeyBundleSigningKey(args[0]))
+	},
+}
+
+var keysBundleCmd = &cobra.Command{
+	Use:   "bundle [BatchCount] [SigningKeyPath]",
+	Short: "Writes a signed bundle of the verification keys in 'out/public/' to 'out/public/key_bundle.json'",
+	Long: "Writes a bundle of the distinct verification keys of each layer of the proofs in 'out/public/', with their\n" +
+		"fingerprints, the circuit version and parameters, and a validity window starting now (see --valid-for), signed\n" +
+		"with the key in SigningKeyPath, to 'out/public/key_bundle.json'.\n" +
+		"This function takes 2 arguments: the number of batches and the path of the signing key.",
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		batchCount, err := strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Println("Error parsing batchCount:", err)
+			return
+		}
+		now := time.Now()
+		bundle := core.BuildKeyBundle(batchCount, roundDir(), now, now.Add(keyBundleValidity))
+		core.WriteKeyBundle(roundDir()+core.KEY_BUNDLE_FILE, core.SignKeyBundle(bundle, core.ReadKeyBundleSigningKey(args[1])))
+		fmt.Printf("Bundled %d verification keys, valid until %s.\n", len(bundle.Keys), bundle.NotAfter.Format(time.RFC3339))
+	},
+}
+
+var keysVerifyCmd = &cobra.Command{
+	Use:   "verify [path/to/key_bundle.json] [PublicKey]",
+	Short: "Verifies a key bundle is signed by the given public key and currently valid",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		publicKey, err := hex.DecodeString(args[1])
+		if err != nil {
+			fmt.Println("Error parsing public key:", err)
+			os.Exit(1)
+		}
+		bundle, err := core.VerifyKeyBundle(core.ReadKeyBundle(args[0]), []ed25519.PublicKey{publicKey}, time.Now())
+		if err != nil {
+			fmt.Println("Key bundle verification failed:", err)
+			os.Exit(1)
+		}
+		for _, entry := range bundle.Keys {
+			fmt.Printf("  %s level: %s (%d proofs)\n", entry.Layer, entry.Fingerprint, entry.ProofCount)
+		}
+		println("Key bundle verification succeeded!")
+	},
+}
+
+func init() {
+	keysBundleCmd.Flags().DurationVar(&keyBundleValidity, "valid-for", 90*24*time.Hour, "how long the bundle is valid for")
+	addRoundEpochFlag(keysBundleCmd)
+	keysCmd.AddCommand(keysGenerateCmd, keysBundleCmd, keysVerifyCmd)
+	rootCmd.AddCommand(keysCmd)
+}
@@ -0,0 +1,40 @@
+package cli
+
+import (
+	"fmt"
+	"strconv"
+
+	"bitgo.com/proof_of_reserves/core"
+	"github.com/spf13/cobra"
+)
+
+var migrateDepthCmd = &cobra.Command{
+	Use:   "migratedepth [PreviousTreeDepth] [PreviousBatchCount] [PreviousOutDir]",
+	Short: "Migrates the batches of a round with a different tree depth to 'out/secret/'",
+	Long: "Migrates the batches of a round generated with a different tree depth (in PreviousOutDir/secret/) to batches\n" +
+		"for the current tree depth in 'out/secret/'. Accounts keep their order and are re-chunked into full batches.\n" +
+		"A user index mapping each account's previous batch and position to its new ones is written to\n" +
+		"'out/secret/user_index.json', and a migration note, which the next prove records in the round metadata, is written\n" +
+		"to 'out/secret/tree_depth_migration.json'. Prints the number of batches to prove.\n" +
+		"This function takes 3 arguments: the previous tree depth, the previous number of batches, and the previous output directory.",
+	Args: cobra.ExactArgs(3),
+	Run: func(cmd *cobra.Command, args []string) {
+		previousTreeDepth, err := strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Println("Error parsing previousTreeDepth:", err)
+			return
+		}
+		previousBatchCount, err := strconv.Atoi(args[1])
+		if err != nil {
+			fmt.Println("Error parsing previousBatchCount:", err)
+			return
+		}
+		migration := core.MigrateTreeDepth(previousTreeDepth, previousBatchCount, args[2], outDir())
+		fmt.Println(migration.Note)
+		fmt.Printf("Migrated %d batches into %d batches.\n", migration.PreviousBatchCount, migration.BatchCount)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(migrateDepthCmd)
+}
@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"fmt"
+	"strconv"
+
+	"bitgo.com/proof_of_reserves/core"
+	"github.com/spf13/cobra"
+)
+
+var nonInclusionProofPath string
+
+var nonInclusionCmd = &cobra.Command{
+	Use:   "noninclusion [BatchCount] [WalletId]",
+	Short: "Generates the proof that a user is not in the round in 'out/'",
+	Long: "Generates the proof that the user with the given WalletId is not in the round in 'out/', e.g. for a departed\n" +
+		"customer, using the sparse Merkle tree of the round. The round must have been proven with --sparse-tree.\n" +
+		"The proof is written to the given path (--output), to be verified with verifynoninclusion.",
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		batchCount, err := strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Println("Error parsing batchCount:", err)
+			return
+		}
+		proof, err := core.ProveNonInclusion(batchCount, roundDir(), args[1])
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		core.WriteNonInclusionProof(nonInclusionProofPath, proof)
+		fmt.Println("Proof of non-inclusion written to", nonInclusionProofPath)
+	},
+}
+
+var verifyNonInclusionCmd = &cobra.Command{
+	Use:   "verifynoninclusion [path/to/noninclusion.json] [path/to/round_metadata.json]",
+	Short: "Verify the user of the given proof of non-inclusion is not in the round of the given metadata",
+	Long: "Verifies the user of the given proof of non-inclusion is not in the round of the given round metadata, i.e.\n" +
+		"that the path of the proof leads from the empty leaf at the user's position in the sparse Merkle tree of the\n" +
+		"round to the root recorded in the round metadata.",
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		proof := core.ReadNonInclusionProof(args[0])
+		metadata := core.ReadPublishedRoundMetadata(args[1])
+		if proof.RoundId != metadata.RoundId {
+			fmt.Printf("Proof of non-inclusion is of round %q, but the metadata is of round %q\n", proof.RoundId, metadata.RoundId)
+			return
+		}
+		if err := core.VerifyNonInclusion(proof, metadata.SparseTreeRoot); err != nil {
+			fmt.Println("Non-inclusion verification failed:", err)
+			return
+		}
+		fmt.Println("Non-inclusion verification succeeded!")
+	},
+}
+
+func init() {
+	nonInclusionCmd.Flags().StringVar(&nonInclusionProofPath, "output", "noninclusion.json", "path to write the proof of non-inclusion to")
+	addRoundEpochFlag(nonInclusionCmd)
+	rootCmd.AddCommand(nonInclusionCmd)
+	rootCmd.AddCommand(verifyNonInclusionCmd)
+}
@@ -1,28 +1,153 @@
 package cli
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
+	"os/signal"
 	"strconv"
+	"time"
 
+	"bitgo.com/proof_of_reserves/circuit"
 	"bitgo.com/proof_of_reserves/core"
 	"github.com/spf13/cobra"
 )
 
+var publicationPolicyPath string
+var assetSubset []int
+var proofWorkers int
+var maxProofMemory uint64
+var circuitCacheCapacity int
+var prewarmCircuits bool
+var showProgress bool
+var streamBatches bool
+var dryRun bool
+var signingKeyPath string
+var keyDir string
+
 var proveCmd = &cobra.Command{
 	Use:   "prove [BatchCount]",
 	Short: "Generates proofs using the secret data in 'out/secret/'",
-	Long:  "Generates proofs using the secret data in 'out/secret/'. This function takes 1 argument: the number of batches.",
-	Args:  cobra.ExactArgs(1),
+	Long: "Generates proofs using the secret data in 'out/secret/'. This function takes 1 argument: the number of batches.\n" +
+		"An interrupt (Ctrl-C) stops proving once the batches being proven are done, keeping every bottom level proof\n" +
+		"generated so far, and running prove again with the same number of batches resumes the round.",
+	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		batchCount, err := strconv.Atoi(args[0])
 		if err != nil {
 			fmt.Println("Error parsing batchCount:", err)
 			return
 		}
-		core.Prove(batchCount, core.OUT_DIR)
+		if err := setupProver(); err != nil {
+			fmt.Println(err)
+			return
+		}
+		if err := core.SetProofWorkers(proofWorkers); err != nil {
+			fmt.Println(err)
+			return
+		}
+		core.SetMaxProofMemory(maxProofMemory << 20)
+		cache, err := core.NewCircuitCache(circuitCacheCapacity)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		core.SetCircuitCache(cache)
+		if prewarmCircuits {
+			if err := core.PrewarmCircuits(circuit.GetAccountsPerBatch()); err != nil {
+				fmt.Println(err)
+				return
+			}
+		}
+		// an interrupt stops proving after the batches being proven, and running prove again resumes the round
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		var opts []core.ProverOption
+		if showProgress {
+			opts = append(opts, core.WithProgress(printProgress))
+		}
+		if dryRun {
+			if err := core.ProveWithError(batchCount, outDir(), append(opts, core.DryRun)...); err != nil {
+				fmt.Println(err)
+				return
+			}
+			fmt.Println("Dry run found no problems in the batches.")
+			return
+		}
+		if streamBatches {
+			core.ProveStreaming(batchCount, outDir(), opts...)
+			fmt.Println(core.GetProverMetrics())
+			return
+		}
+		progress, err := core.ProveContext(ctx, batchCount, outDir(), opts...)
+		if errors.Is(err, context.Canceled) {
+			fmt.Printf("Proving interrupted with %d of %d bottom level proofs generated, run prove again to resume\n", len(progress.ProvenBatches), batchCount)
+			return
+		}
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println(core.GetProverMetrics())
 	},
 }
 
+// setupProver applies the asset subset, publication policy, signing key, and key directory flags shared by the commands
+// that generate proofs.
+func setupProver() error {
+	if len(assetSubset) > 0 {
+		if err := circuit.SetAssetSubset(assetSubset); err != nil {
+			return fmt.Errorf("Error setting asset subset: %w", err)
+		}
+	}
+	if publicationPolicyPath != "" {
+		options.ProofPublicationPolicy = core.ReadPublicationPolicy(publicationPolicyPath)
+	}
+	if signingKeyPath != "" {
+		options.ArtifactSigner = core.ReadKeyBundleSigningKey(signingKeyPath)
+	}
+	core.SetOptions(options)
+	if err := core.SetSetupKeyDir(keyDir); err != nil {
+		return fmt.Errorf("Error setting key directory: %w", err)
+	}
+	return nil
+}
+
+// addBatchPreparationFlags adds the flags governing how batches are prepared to be proven, which every command
+// proving or aggregating the batches of a round must be given alike.
+func addBatchPreparationFlags(cmd *cobra.Command) {
+	cmd.Flags().Uint64Var(&options.ProofEpoch, "epoch", 0, "epoch the proofs are bound to, e.g. the snapshot date as YYYYMMDD (0 for none)")
+	cmd.Flags().IntSliceVar(&assetSubset, "asset-subset", nil, "indices of the assets of the asset registry to prove the liabilities in, e.g. 3,12 (every asset if unset)")
+	cmd.Flags().BoolVar(&options.AggregateUserAccounts, "aggregate-users", false, "merge the accounts with the same WalletId in each batch (e.g. the sub-wallets of a user) into one account per user")
+	cmd.Flags().IntVar(&options.DummyAccountPadding, "dummy-padding", 0, "number of accounts to pad every batch to with dummy accounts of zero balance, e.g. the accounts of a full batch to hide how full batches are (0 for none)")
+}
+
+// printProgress prints a progress event on one line.
+func printProgress(event core.ProgressEvent) {
+	line := fmt.Sprintf("[%d/%d] %s level proof %d %s (elapsed %s", event.Finished, event.Total, event.Layer, event.Batch, event.Phase, event.Elapsed.Round(time.Second))
+	if event.ETA > 0 {
+		line += fmt.Sprintf(", ETA %s", event.ETA.Round(time.Second))
+	}
+	fmt.Println(line + ")")
+}
+
 func init() {
+	proveCmd.Flags().StringVar(&options.ProvenanceBuilderId, "builder-id", options.ProvenanceBuilderId, "builder identity recorded in the provenance statement")
+	addBatchPreparationFlags(proveCmd)
+	proveCmd.Flags().BoolVar(&options.BuildSparseTree, "sparse-tree", false, "build a sparse Merkle tree of the proven accounts, so users not in the round can be given proofs of non-inclusion")
+	proveCmd.Flags().IntVar(&options.HierarchyLevels, "levels", 0, "number of levels of proofs from the bottom level to the top level (0 to derive it from the number of batches: 3, or more if the batches do not fit in 3 levels)")
+	proveCmd.Flags().IntVar(&proofWorkers, "workers", 1, "number of bottom level proofs generated concurrently (each needs the memory of proving a batch)")
+	proveCmd.Flags().Uint64Var(&maxProofMemory, "max-memory", 0, "memory in MiB the concurrent proofs (see --workers) may use beyond the cached circuits, estimated from the size of each circuit (0 for no limit)")
+	proveCmd.Flags().StringVar(&keyDir, "key-dir", "", "directory of the keys written by setup to prove with, instead of setting up each circuit when it is compiled")
+	proveCmd.Flags().IntVar(&circuitCacheCapacity, "circuit-cache", core.DEFAULT_CIRCUIT_CACHE_CAPACITY, "number of compiled circuits (with their proving keys) kept in memory, evicting the least recently used (0 for no limit)")
+	proveCmd.Flags().BoolVar(&prewarmCircuits, "prewarm", false, "compile and set up the circuit of a full batch before proving starts")
+	proveCmd.Flags().BoolVar(&options.PublishVerificationKeys, "publish-vk", false, "publish the verification key of each circuit once to 'out/public/vk.json', which the published proofs reference by fingerprint")
+	proveCmd.Flags().BoolVar(&showProgress, "progress", false, "print when the proof of each batch starts, is hashed, is proven, and finishes, with an estimate of the time left")
+	proveCmd.Flags().BoolVar(&options.WriteProofMetrics, "metrics", false, "write how each proof was generated (circuit size, compile, setup, witness, and prove times, peak memory) next to its secret copy, e.g. 'out/secret/bottom_level_proof_0.metrics.json'")
+	proveCmd.Flags().BoolVar(&dryRun, "dry-run", false, "only validate the batches (balances, WalletIds, asset sums, and merkle roots) without proving them, and write the problems found to 'out/secret/validation_report.json'")
+	proveCmd.Flags().BoolVar(&streamBatches, "streaming", false, "read, prove, and release one batch at a time (per worker) so memory does not grow with the number of batches (a streamed round can't be resumed after an interrupt)")
+	proveCmd.Flags().StringVar(&publicationPolicyPath, "publication-policy", "", "path of a JSON publication policy governing which proof fields are written to 'out/public/' and 'out/secret/'")
+	proveCmd.Flags().StringVar(&signingKeyPath, "signing-key", "", "path of a key (see 'keys generate') to sign every published file of the round with, in 'out/public/signatures.json'")
 	rootCmd.AddCommand(proveCmd)
 }
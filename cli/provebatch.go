@@ -0,0 +1,78 @@
+package cli
+
+import (
+	"fmt"
+	"strconv"
+
+	"bitgo.com/proof_of_reserves/core"
+	"github.com/spf13/cobra"
+)
+
+var proveBatchCmd = &cobra.Command{
+	Use:   "prove-batch [BatchIndex]",
+	Short: "Generates the bottom level proof of one batch of the secret data in 'out/secret/'",
+	Long: "Generates the bottom level proof of the batch with the given index in 'out/secret/', so the batches of a round\n" +
+		"can be proven on different machines. Once the bottom level proofs (and the dummy accounts of padded batches) of\n" +
+		"every batch are gathered in 'out/', aggregate generates the rest of the round. The batches must be proven and\n" +
+		"aggregated with the same settings (e.g. --epoch and --dummy-padding).",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		index, err := strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Println("Error parsing batch index:", err)
+			return
+		}
+		if err := setupProver(); err != nil {
+			fmt.Println(err)
+			return
+		}
+		var opts []core.ProverOption
+		if showProgress {
+			opts = append(opts, core.WithProgress(printProgress))
+		}
+		core.ProveBatch(index, outDir(), opts...)
+		fmt.Println(core.GetProverMetrics())
+	},
+}
+
+var aggregateCmd = &cobra.Command{
+	Use:   "aggregate [BatchCount]",
+	Short: "Generates the upper level proofs of a round from the bottom level proofs generated by prove-batch",
+	Long: "Generates the mid and top level proofs (and the files describing the round) of the round in 'out/' from the\n" +
+		"bottom level proofs of its batches generated by prove-batch. This function takes 1 argument: the number of batches.",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		batchCount, err := strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Println("Error parsing batchCount:", err)
+			return
+		}
+		if err := setupProver(); err != nil {
+			fmt.Println(err)
+			return
+		}
+		var opts []core.ProverOption
+		if showProgress {
+			opts = append(opts, core.WithProgress(printProgress))
+		}
+		core.AggregateBatches(batchCount, outDir(), opts...)
+		fmt.Println(core.GetProverMetrics())
+	},
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{proveBatchCmd, aggregateCmd} {
+		addBatchPreparationFlags(cmd)
+		cmd.Flags().BoolVar(&showProgress, "progress", false, "print when each proof starts, is hashed, is proven, and finishes")
+		cmd.Flags().StringVar(&publicationPolicyPath, "publication-policy", "", "path of a JSON publication policy governing which proof fields are written to 'out/public/' and 'out/secret/'")
+		cmd.Flags().StringVar(&keyDir, "key-dir", "", "directory of the keys written by setup to prove with, instead of setting up each circuit when it is compiled")
+		cmd.Flags().BoolVar(&options.WriteProofMetrics, "metrics", false, "write how each proof was generated (circuit size, compile, setup, witness, and prove times, peak memory) next to its secret copy")
+	}
+	aggregateCmd.Flags().StringVar(&options.ProvenanceBuilderId, "builder-id", options.ProvenanceBuilderId, "builder identity recorded in the provenance statement")
+	aggregateCmd.Flags().BoolVar(&options.BuildSparseTree, "sparse-tree", false, "build a sparse Merkle tree of the proven accounts, so users not in the round can be given proofs of non-inclusion")
+	aggregateCmd.Flags().IntVar(&options.HierarchyLevels, "levels", 0, "number of levels of proofs from the bottom level to the top level (0 to derive it from the number of batches: 3, or more if the batches do not fit in 3 levels)")
+	aggregateCmd.Flags().StringVar(&signingKeyPath, "signing-key", "", "path of a key (see 'keys generate') to sign every published file of the round with, in 'out/public/signatures.json'")
+	aggregateCmd.Flags().BoolVar(&options.PublishVerificationKeys, "publish-vk", false, "publish the verification key of each circuit once to 'out/public/vk.json', which the published proofs reference by fingerprint")
+	rootCmd.AddCommand(proveBatchCmd)
+	rootCmd.AddCommand(aggregateCmd)
+}
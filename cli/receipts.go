@@ -0,0 +1,65 @@
+package cli
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"bitgo.com/proof_of_reserves/core"
+	"github.com/spf13/cobra"
+)
+
+var receiptsCmd = &cobra.Command{
+	Use:   "receipts",
+	Short: "Manages the signed receipts returned to the teams exporting the batches",
+}
+
+var receiptsIssueCmd = &cobra.Command{
+	Use:   "issue [BatchCount] [SigningKeyPath]",
+	Short: "Writes a signed receipt for each batch in 'out/secret/' to 'out/secret/batch_receipt_<n>.json'",
+	Long: "Writes a receipt for each batch in 'out/secret/', with the digest and account count of the batch file and the\n" +
+		"merkle root and asset subtotal hash of its bottom level proof, signed with the key in SigningKeyPath (see 'keys generate').\n" +
+		"This function takes 2 arguments: the number of batches and the path of the signing key.",
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		batchCount, err := strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Println("Error parsing batchCount:", err)
+			return
+		}
+		receipts := core.IssueBatchReceipts(batchCount, roundDir(), core.ReadKeyBundleSigningKey(args[1]), time.Now())
+		for i, receipt := range receipts {
+			core.WriteBatchReceipt(roundDir()+core.BATCH_RECEIPT_PREFIX+strconv.Itoa(i)+".json", receipt)
+		}
+		fmt.Printf("Issued %d batch receipts.\n", len(receipts))
+	},
+}
+
+var receiptsVerifyCmd = &cobra.Command{
+	Use:   "verify [path/to/batch_receipt.json] [path/to/batch.json] [PublicKey]",
+	Short: "Verifies a batch receipt is signed by the given public key and matches the exported batch file",
+	Args:  cobra.ExactArgs(3),
+	Run: func(cmd *cobra.Command, args []string) {
+		publicKey, err := hex.DecodeString(args[2])
+		if err != nil {
+			fmt.Println("Error parsing public key:", err)
+			os.Exit(1)
+		}
+		receipt, err := core.VerifyBatchReceipt(core.ReadBatchReceipt(args[0]), []ed25519.PublicKey{publicKey}, args[1])
+		if err != nil {
+			fmt.Println("Batch receipt verification failed:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("  batch %d: %d accounts (%d excluded), merkle root %x\n", receipt.Batch, receipt.AccountCount, receipt.ExcludedAccountCount, receipt.MerkleRoot)
+		println("Batch receipt verification succeeded!")
+	},
+}
+
+func init() {
+	addRoundEpochFlag(receiptsIssueCmd)
+	receiptsCmd.AddCommand(receiptsIssueCmd, receiptsVerifyCmd)
+	rootCmd.AddCommand(receiptsCmd)
+}
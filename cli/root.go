@@ -1,13 +1,136 @@
 package cli
 
 import (
-	"github.com/spf13/cobra"
+	"fmt"
+	"log/slog"
 	"os"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"bitgo.com/proof_of_reserves/core"
+	"github.com/spf13/cobra"
+)
+
+var (
+	assetRegistryPath string
+	assetSumBits      int
+	balanceBits       int
+	deploymentDomain  string
+	gpuProving        bool
+	hashFunction      string
+	hashVersion       int
+	hashWorkers       int
+	layoutPath        string
+	leafHashMode      string
+	outDirectory      string
+	paddedBatches     bool
+	plainRangeChecks  bool
+	proofBackend      string
+	plonkSRSPath      string
+	secretCopyKeyPath string
+	setupSeed         string
+	treeDepth         int
+	verbose           bool
+	walletIdEncoding  string
 )
 
+// options are the options of the prover and the verifiers, which the flags of the commands are bound to. They are set
+// before every command runs, and again by the commands that derive options from their flags (e.g. setupProver).
+var options = core.DefaultOptions()
+
 var rootCmd = &cobra.Command{
 	Use:   "bgproof",
 	Short: "Validate BitGo's proof of reserves",
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		// the configuration is set first, as it resets how accounts and nodes are hashed, which is set below
+		if err := circuit.SetConfig(circuit.Config{TreeDepth: treeDepth, BalanceBits: balanceBits, AssetSumBits: assetSumBits, PlainRangeChecks: plainRangeChecks, PaddedBatches: paddedBatches, DeploymentDomain: deploymentDomain}); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if err := circuit.SetHashFunction(circuit.HashFunction(hashFunction)); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if err := circuit.SetHashVersion(circuit.HashVersion(hashVersion)); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if err := circuit.SetLeafHashMode(circuit.LeafHashMode(leafHashMode)); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if err := circuit.SetWalletIdEncoding(circuit.WalletIdEncoding(walletIdEncoding)); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if err := circuit.SetHashWorkers(hashWorkers); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if assetRegistryPath != "" {
+			registry, err := circuit.ReadAssetRegistry(assetRegistryPath)
+			if err == nil {
+				err = circuit.SetAssetRegistry(registry)
+			}
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}
+		if err := core.SetProofBackend(core.ProofBackend(proofBackend)); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if plonkSRSPath != "" {
+			srs, err := core.ReadPlonkSRS(plonkSRSPath)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			core.SetPlonkSRS(srs)
+		}
+		if layoutPath != "" {
+			layout, err := core.ReadLayout(layoutPath)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			options.OutputLayout = layout
+		}
+		if secretCopyKeyPath != "" {
+			key, err := core.ReadSecretCopyKey(secretCopyKeyPath)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			options.SecretCopyKey = key
+		}
+		core.SetOptions(options)
+		core.SetGPUProving(gpuProving)
+		if setupSeed != "" {
+			slog.Warn("setting circuits up deterministically from a seed, for tests only: anyone who knows the seed can forge the proofs")
+			core.SetDeterministicSetup([]byte(setupSeed))
+		}
+		if verbose {
+			slog.SetLogLoggerLevel(slog.LevelDebug)
+		}
+	},
+}
+
+// outDir returns the output directory of rounds (see --out), with a trailing separator.
+func outDir() string {
+	if strings.HasSuffix(outDirectory, "/") {
+		return outDirectory
+	}
+	return outDirectory + "/"
+}
+
+// roundDir returns the directory of the round of the epoch in the output directory (see --layout), for commands
+// reading or writing the files of a single round.
+func roundDir() string {
+	return core.GetOptions().OutputLayout.RoundDir(outDir())
 }
 
 func Execute() {
@@ -17,6 +140,71 @@ func Execute() {
 	}
 }
 
+// envOrDefault returns the value of the environment variable, or the default value if it is not set or empty.
+func envOrDefault(name string, defaultValue string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// envTreeDepth returns the tree depth set in $BGPROOF_TREE_DEPTH, or circuit.TREE_DEPTH if it is not set. An invalid
+// value is reported when the flags are applied.
+func envTreeDepth() int {
+	value, ok := os.LookupEnv("BGPROOF_TREE_DEPTH")
+	if !ok {
+		return circuit.TREE_DEPTH
+	}
+	depth, err := strconv.Atoi(value)
+	if err != nil {
+		return -1
+	}
+	return depth
+}
+
 func init() {
 	rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	rootCmd.PersistentFlags().StringVar(&deploymentDomain, "domain", os.Getenv("BGPROOF_DOMAIN"),
+		"deployment domain mixed into all hashes (e.g. testnet), defaults to $BGPROOF_DOMAIN")
+	rootCmd.PersistentFlags().StringVar(&assetRegistryPath, "assets", os.Getenv("BGPROOF_ASSETS"),
+		"JSON or YAML asset registry listing the symbol and decimals of each balance, defaults to $BGPROOF_ASSETS or the built-in assets")
+	rootCmd.PersistentFlags().StringVar(&hashFunction, "hash", os.Getenv("BGPROOF_HASH"),
+		"hash function of new proofs (mimc or poseidon2), defaults to $BGPROOF_HASH or mimc (proofs are always verified with their own)")
+	rootCmd.PersistentFlags().IntVar(&hashVersion, "hash-version", int(circuit.HASH_VERSION_ROOT_TAGGED),
+		"hash version of new proofs (1 hashes accounts and nodes alike, 2 tags them apart, 3 tags lower level proofs apart from accounts too), defaults to 3 (proofs are always verified with their own)")
+	rootCmd.PersistentFlags().StringVar(&leafHashMode, "leaf-hash", string(circuit.LEAF_HASH_NATIVE),
+		"leaf hash mode of new proofs (native hashes accounts with the hash function, sha256 with SHA-256), defaults to native (proofs are always verified with their own)")
+	rootCmd.PersistentFlags().StringVar(&walletIdEncoding, "wallet-id-encoding", os.Getenv("BGPROOF_WALLET_ID_ENCODING"),
+		"encoding of the WalletIds of account files (base36, utf8, hex, or hashed), defaults to $BGPROOF_WALLET_ID_ENCODING or base36 (files that record theirs are always read with it)")
+	rootCmd.PersistentFlags().IntVar(&hashWorkers, "hash-workers", runtime.NumCPU(),
+		"number of goroutines account and node hashes are computed with outside the circuit, defaults to the number of CPUs")
+	rootCmd.PersistentFlags().IntVar(&treeDepth, "tree-depth", envTreeDepth(),
+		"depth of the Merkle trees of new proofs (each batch holds up to 2^depth accounts), defaults to $BGPROOF_TREE_DEPTH or "+
+			strconv.Itoa(circuit.TREE_DEPTH)+" (proofs are always verified with their own)")
+	rootCmd.PersistentFlags().IntVar(&balanceBits, "balance-bits", circuit.BALANCE_BITS,
+		"number of bits account balances of new proofs are range checked to (assets of the asset registry can set their own with balanceBits), defaults to "+
+			strconv.Itoa(circuit.BALANCE_BITS))
+	rootCmd.PersistentFlags().IntVar(&assetSumBits, "asset-sum-bits", 0,
+		"number of bits asset sums of new proofs, and the balances of upper level proofs, are range checked to, defaults to the balance bits")
+	rootCmd.PersistentFlags().BoolVar(&plainRangeChecks, "plain-range-checks", false,
+		"range check balances of new proofs by bit decomposition instead of with a lookup argument (more constraints, but no commitment, as the circuits of an MPC setup ceremony need)")
+	rootCmd.PersistentFlags().BoolVar(&paddedBatches, "padded-batches", false,
+		"prove partial batches of new proofs (e.g. the last batch) with the circuit of a full batch padded with zero accounts, so they need no setup of their own")
+	rootCmd.PersistentFlags().StringVar(&proofBackend, "backend", os.Getenv("BGPROOF_BACKEND"),
+		"proof system of new proofs (groth16, plonk, or groth16-recursive), defaults to $BGPROOF_BACKEND or groth16 (proofs are always verified with their own)")
+	rootCmd.PersistentFlags().StringVar(&plonkSRSPath, "plonk-srs", os.Getenv("BGPROOF_PLONK_SRS"),
+		"universal KZG SRS of PLONK proofs from a public ceremony, defaults to $BGPROOF_PLONK_SRS (without one, an SRS is generated locally)")
+	rootCmd.PersistentFlags().BoolVar(&gpuProving, "gpu", false,
+		"generate Groth16 proofs on the GPU with ICICLE (needs a binary built with -tags icicle and a CUDA device), falling back to the CPU otherwise")
+	rootCmd.PersistentFlags().StringVar(&setupSeed, "insecure-setup-seed", "",
+		"seed circuits are set up (and Groth16 proofs generated) from instead of random toxic waste, for reproducible test proofs only: never use it for production keys, as anyone who knows the seed can forge proofs")
+	rootCmd.PersistentFlags().StringVar(&outDirectory, "out", envOrDefault("BGPROOF_OUT", core.OUT_DIR),
+		"output directory of rounds ('out/' in the descriptions of the commands), a local directory or a storage URI (s3://bucket/prefix or gs://bucket/prefix, configured with the usual AWS and Google Cloud environment variables), defaults to $BGPROOF_OUT or 'out/'")
+	rootCmd.PersistentFlags().StringVar(&layoutPath, "layout", os.Getenv("BGPROOF_LAYOUT"),
+		"JSON layout of the files of rounds in 'out/' (e.g. {\"EpochDirectory\": \"epoch_{epoch}/\"} to keep the round of each --epoch apart), defaults to $BGPROOF_LAYOUT or the files directly in 'out/'")
+	rootCmd.PersistentFlags().StringVar(&secretCopyKeyPath, "secret-copy-key", os.Getenv("BGPROOF_SECRET_COPY_KEY"),
+		"path of a hex encoded AES-256 key the copies of the proofs in 'out/secret/' (see --publication-policy) are encrypted with when written, and decrypted with when read, defaults to $BGPROOF_SECRET_COPY_KEY")
+	rootCmd.PersistentFlags().BoolVar(&options.SyncFileWrites, "fsync", false,
+		"flush every written file (e.g. the secret batch data and proofs) to disk before going on, so the files survive a power loss")
+	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "log circuit compilation and proof generation events")
 }
@@ -0,0 +1,53 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"bitgo.com/proof_of_reserves/core"
+	"github.com/spf13/cobra"
+)
+
+var (
+	roundConfigPath string
+	restartRound    bool
+)
+
+var roundCmd = &cobra.Command{
+	Use:   "round",
+	Short: "Runs the lifecycle of a round",
+}
+
+var roundRunCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Runs preflight, prove, verify, sign, and publish for the round configured in --config",
+	Long: "Runs the lifecycle of the round configured in --config (YAML), one stage at a time:\n" +
+		" 1) preflight: validates the config, batch files, exclusion list, publication policy, and signing key.\n" +
+		" 2) prove: generates the proofs of the round.\n" +
+		" 3) verify: fully verifies the round, and reconciles it against the ledger totals in the config.\n" +
+		" 4) sign: writes the signed key bundle and batch receipts.\n" +
+		" 5) publish: copies the public directory of the round to the publish directory.\n" +
+		"A stage only runs once the stages before it succeeded. The outcome of every stage is recorded in the audit log\n" +
+		"'secret/round_audit_log.jsonl', and running the command again resumes from the first stage that has not\n" +
+		"succeeded, unless --restart is set.",
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		config := core.ReadRoundConfig(roundConfigPath)
+		if !cmd.Flags().Changed("domain") && os.Getenv("BGPROOF_DOMAIN") == "" {
+			circuit.SetDeploymentDomain(config.DeploymentDomain)
+		}
+		if err := core.RunRound(config, restartRound, os.Stdout); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		println("Round completed!")
+	},
+}
+
+func init() {
+	roundRunCmd.Flags().StringVar(&roundConfigPath, "config", "round.yaml", "path of the round config")
+	roundRunCmd.Flags().BoolVar(&restartRound, "restart", false, "run every stage again, instead of resuming from the last successful stage")
+	roundCmd.AddCommand(roundRunCmd)
+	rootCmd.AddCommand(roundCmd)
+}
@@ -0,0 +1,50 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"bitgo.com/proof_of_reserves/core"
+	"github.com/spf13/cobra"
+)
+
+var keepSelfTestDir bool
+
+var selfTestCmd = &cobra.Command{
+	Use:   "selftest",
+	Short: "Generates, proves, and verifies a tiny synthetic round in a temporary directory",
+	Long: "Runs the whole pipeline on a tiny synthetic round in a temporary directory: generates data, proves it, fully\n" +
+		"verifies it, exports the user verification packages, and verifies one of them. Intended as a quick check of\n" +
+		"the installed binary and environment before a production run. The directory is removed afterwards, whether the\n" +
+		"self test succeeds or fails, unless --keep is set.",
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		dir, err := os.MkdirTemp("", "bgproof_selftest")
+		if err != nil {
+			fmt.Println("Error creating temporary directory:", err)
+			os.Exit(1)
+		}
+		if keepSelfTestDir {
+			fmt.Println("Self test directory:", dir)
+		} else {
+			defer os.RemoveAll(dir)
+		}
+
+		if err := core.RunSelfTest(dir, os.Stdout); err != nil {
+			fmt.Println(err)
+			// os.Exit skips the deferred removal, so the directory is removed here, unless it is kept to inspect it
+			if keepSelfTestDir {
+				fmt.Println("Self test directory kept:", dir)
+			} else {
+				os.RemoveAll(dir)
+			}
+			os.Exit(1)
+		}
+		println("Self test succeeded!")
+	},
+}
+
+func init() {
+	selfTestCmd.Flags().BoolVar(&keepSelfTestDir, "keep", false, "keep the self test directory")
+	rootCmd.AddCommand(selfTestCmd)
+}
@@ -0,0 +1,74 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"bitgo.com/proof_of_reserves/core"
+	"github.com/spf13/cobra"
+)
+
+var setupBatchCount int
+
+var setupCmd = &cobra.Command{
+	Use:   "setup [AccountCount...]",
+	Short: "Sets up the circuits of a round ahead of proving, writing their keys to a key directory",
+	Long: "Compiles and sets up the circuits for the given account counts (a full batch if none are given) with the\n" +
+		"current backend, hash, circuit, and asset registry flags, and writes their proving and verification keys to\n" +
+		"the key directory, with 'setup_manifest.json' listing the keys and their digests. Proving with '--key-dir'\n" +
+		"then reads the keys instead of setting each circuit up, so every run uses the keys generated and audited here.\n" +
+		"With '--batches', the circuits of the upper level proofs of a round of as many full batches are set up too\n" +
+		"(upper level proofs have circuits of their own, as their leaves are tagged apart from account leaves).",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(assetSubset) > 0 {
+			if err := circuit.SetAssetSubset(assetSubset); err != nil {
+				fmt.Println("Error setting asset subset:", err)
+				os.Exit(1)
+			}
+		}
+		var accountCounts, upperLevelAccountCounts []int
+		for _, arg := range args {
+			accountCount, err := strconv.Atoi(arg)
+			if err != nil {
+				fmt.Println("Error parsing account count:", err)
+				os.Exit(1)
+			}
+			accountCounts = append(accountCounts, accountCount)
+		}
+		if setupBatchCount > 0 {
+			plan := core.NewLayerPlan(setupBatchCount)
+			if err := plan.Validate(); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			roundAccountCounts, roundUpperLevelAccountCounts := core.RoundAccountCounts(plan)
+			accountCounts = append(accountCounts, roundAccountCounts...)
+			upperLevelAccountCounts = roundUpperLevelAccountCounts
+		} else if len(accountCounts) == 0 {
+			accountCounts = []int{circuit.GetAccountsPerBatch()}
+		}
+		manifest, err := core.Setup(accountCounts, upperLevelAccountCounts, keyDir)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		for _, setupCircuit := range manifest.Circuits {
+			level := "accounts"
+			if setupCircuit.UpperLevel {
+				level = "lower level proofs"
+			}
+			fmt.Printf("%d %s: %d constraints, verification key %s\n", setupCircuit.AccountCount, level, setupCircuit.Constraints, setupCircuit.VerificationKeyFingerprint)
+		}
+		fmt.Println("Keys written to", keyDir)
+	},
+}
+
+func init() {
+	setupCmd.Flags().StringVar(&keyDir, "key-dir", "keys", "directory to write the keys and setup manifest to")
+	setupCmd.Flags().IntVar(&setupBatchCount, "batches", 0, "number of full batches of the round whose upper level circuits to set up too (0 for none)")
+	setupCmd.Flags().IntSliceVar(&assetSubset, "asset-subset", nil, "indices of the assets of the asset registry the circuits prove the liabilities in, e.g. 3,12 (every asset if unset)")
+	setupCmd.Flags().IntVar(&options.HierarchyLevels, "levels", 0, "number of levels of proofs of the round of '--batches' (0 to derive it from the number of batches)")
+	rootCmd.AddCommand(setupCmd)
+}
@@ -0,0 +1,31 @@
+package cli
+
+import (
+	"fmt"
+
+	"bitgo.com/proof_of_reserves/core"
+	"github.com/spf13/cobra"
+)
+
+var splitCmd = &cobra.Command{
+	Use:   "split [AccountsFile]",
+	Short: "Splits a file of accounts into the batches of 'out/secret/'",
+	Long: "Reads a JSON array of accounts, sorts them by WalletId, checks them, and writes them to 'out/secret/' as\n" +
+		"batches of up to 2^tree-depth accounts that prove can read. Accounts without a salt are given one. Prints the\n" +
+		"number of batches, which prove needs. With --aggregate-users, the accounts of a user are kept in the same batch.",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		batchCount, err := core.SplitAccountsIntoBatches(args[0], outDir())
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println("Accounts split into", batchCount, "batches")
+	},
+}
+
+func init() {
+	splitCmd.Flags().BoolVar(&options.AggregateUserAccounts, "aggregate-users", false, "allow users with several accounts, which are kept in the same batch to be merged when proving with --aggregate-users")
+	addRoundEpochFlag(splitCmd)
+	rootCmd.AddCommand(splitCmd)
+}
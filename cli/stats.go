@@ -0,0 +1,99 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"bitgo.com/proof_of_reserves/core"
+	"github.com/spf13/cobra"
+)
+
+var statsCmd = &cobra.Command{
+	Use:   "stats [AccountCount]",
+	Short: "Prints the constraint counts of the circuit",
+	Long: "Compiles the bottom level circuit for the given number of accounts (the accounts of a full batch by default)\n" +
+		"with the configured backend, hash, tree depth, balance bits, and asset registry, and prints its number of\n" +
+		"constraints and wires, and the constraints of its range checks, hashes, and sum. Intended to evaluate the cost\n" +
+		"of adding assets or depth before a production run.",
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		accountCount := circuit.GetAccountsPerBatch()
+		if len(args) == 1 {
+			var err error
+			accountCount, err = strconv.Atoi(args[0])
+			if err != nil {
+				fmt.Println("Error parsing AccountCount:", err)
+				os.Exit(1)
+			}
+		}
+		constraints, wires, err := core.CircuitStats(accountCount)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		components, err := core.CircuitComponentStats(accountCount)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("Circuit for %d accounts: %d constraints, %d wires\n", accountCount, constraints, wires)
+		fmt.Printf("  range checks: %d constraints\n", components.RangeChecks)
+		fmt.Printf("  merkle:       %d constraints\n", components.Merkle)
+		fmt.Printf("  sum:          %d constraints\n", components.Sum)
+	},
+}
+
+var estimateWorkers int
+var estimateMaxMemory uint64
+
+var estimateCmd = &cobra.Command{
+	Use:   "estimate [BatchCount] [AccountsPerBatch]",
+	Short: "Estimates the time, memory, and output size of proving a round",
+	Long: "Generates a bottom level proof of the given number of generated accounts (the accounts of a full batch by\n" +
+		"default) and an upper level proof of copies of it with the configured backend, hash, tree depth, and asset\n" +
+		"registry, and predicts from them the wall-clock time, peak memory, and output size of proving a round of the\n" +
+		"given number of full batches with --workers concurrent proofs. Intended to plan capacity before a production run.",
+	Args: cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		batchCount, err := strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Println("Error parsing BatchCount:", err)
+			os.Exit(1)
+		}
+		accountsPerBatch := circuit.GetAccountsPerBatch()
+		if len(args) == 2 {
+			accountsPerBatch, err = strconv.Atoi(args[1])
+			if err != nil {
+				fmt.Println("Error parsing AccountsPerBatch:", err)
+				os.Exit(1)
+			}
+		}
+		core.SetMaxProofMemory(estimateMaxMemory << 20)
+		estimate, err := core.EstimateRun(batchCount, accountsPerBatch, estimateWorkers)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("Round of %d batches of %d accounts (%d upper level proofs) with %d workers:\n", batchCount, accountsPerBatch, estimate.Plan.UpperLevelProofCount(), estimate.Workers)
+		fmt.Printf("  time:        %s (setup %s)\n", estimate.Duration.Round(time.Second), estimate.SetupDuration.Round(time.Second))
+		fmt.Printf("  peak memory: %d MiB\n", estimate.PeakMemory>>20)
+		fmt.Printf("  output size: %d MiB\n", estimate.OutputSize>>20)
+		for _, benchmark := range []struct {
+			level string
+			core.ProofBenchmark
+		}{{"bottom", estimate.BottomLevel}, {"upper", estimate.UpperLevel}} {
+			fmt.Printf("  %s level proof of %d accounts: %d constraints, setup %s, proof %s, %d KiB written\n", benchmark.level, benchmark.Accounts,
+				benchmark.Constraints, benchmark.SetupDuration.Round(time.Millisecond), benchmark.ProofDuration.Round(time.Millisecond), benchmark.OutputSize>>10)
+		}
+	},
+}
+
+func init() {
+	estimateCmd.Flags().IntVar(&estimateWorkers, "workers", 1, "number of bottom level proofs generated concurrently in the run (see 'prove --workers')")
+	estimateCmd.Flags().Uint64Var(&estimateMaxMemory, "max-memory", 0, "memory in MiB the concurrent proofs of the run may use (see 'prove --max-memory', 0 for no limit)")
+	rootCmd.AddCommand(statsCmd)
+	rootCmd.AddCommand(estimateCmd)
+}
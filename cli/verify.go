@@ -1,8 +1,15 @@
 package cli
 
 import (
+	"crypto/ed25519"
+	"encoding/hex"
 	"fmt"
+	"os"
+	"runtime"
 	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"bitgo.com/proof_of_reserves/core"
 	"github.com/spf13/cobra"
@@ -18,6 +25,14 @@ var verifyCmd = &cobra.Command{
 		" 3) Each proof has merkle nodes that accurately represent the tree of the merkle root.\n" +
 		" 4) Each account was included in at least one bottom level proof.\n" +
 		" 5) The AssetSum published in the top level proof is indeed the sum hashed in MerkleRootWithAssetSumHash.\n" +
+		"Proofs are verified with the trusted verification keys of their layer given with --pinned-vk or --pinned-vk-hash,\n" +
+		"never with their own, and fail if no key is pinned for their layer.\n" +
+		"With --workers N, N batches are verified concurrently, and the first batch that fails is reported, as with one worker.\n" +
+		"With --report, every check is run, and every failure is printed and written to the report as JSON.\n" +
+		"With --streaming, the batches are read and verified one at a time, with their bottom level proofs, so the memory\n" +
+		"needed doesn't grow with the number of accounts.\n" +
+		"With --duplicate-users set or bloom, no user may be in more than one batch (users are checked within each batch\n" +
+		"regardless), with the set of the users of every batch, or a bloom filter of them for rounds with many users.\n" +
 		"The command takes 1 argument: the number of batches.",
 	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
@@ -26,11 +41,119 @@ var verifyCmd = &cobra.Command{
 			fmt.Println("Error parsing batchCount:", err)
 			return
 		}
-		core.VerifyFull(batchCount, core.OUT_DIR)
+		if err := core.SetVerificationWorkers(verificationWorkers); err != nil {
+			fmt.Println(err)
+			return
+		}
+		if options.CrossBatchDuplicateUsers, err = core.ParseDuplicateUserCheck(duplicateUserCheck); err != nil {
+			fmt.Println(err)
+			return
+		}
+		core.SetOptions(options)
+		if err := pinVerificationKeys(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if verifyStreaming {
+			if verificationReportPath != "" {
+				fmt.Println("--streaming can't be combined with --report")
+				return
+			}
+			core.VerifyFullStreaming(batchCount, outDir())
+			println("Verification succeeded!")
+			return
+		}
+		if verificationReportPath == "" {
+			core.VerifyFull(batchCount, outDir())
+			println("Verification succeeded!")
+			return
+		}
+
+		report, err := core.VerifyFullReport(batchCount, outDir())
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		core.WriteVerificationReport(verificationReportPath, *report)
+		for _, failure := range report.Failures {
+			fmt.Println(failure.Error)
+		}
+		if !report.Passed {
+			fmt.Printf("Verification failed with %d failures!\n", len(report.Failures))
+			os.Exit(1)
+		}
 		println("Verification succeeded!")
 	},
 }
 
+// verificationWorkers is the number of batches verify verifies concurrently (see core.SetVerificationWorkers).
+var verificationWorkers int
+
+// verificationReportPath is the path verify writes the report of every failed check to (see core.VerifyFullReport),
+// empty to stop at the first failure.
+var verificationReportPath string
+
+// verifyStreaming makes verify read and verify the batches one at a time (see core.VerifyFullStreaming).
+var verifyStreaming bool
+
+// duplicateUserCheck is the name of the check verify makes that no user is in more than one batch (see
+// core.ParseDuplicateUserCheck).
+var duplicateUserCheck string
+
+// pinnedVerificationKeysPath is the path of the published verification keys (see core.Options.PublishVerificationKeys) that
+// proofs must be verified with, obtained out of band.
+var pinnedVerificationKeysPath string
+
+// pinnedVerificationKeyFingerprints are the fingerprints of the verification keys proofs must come with, obtained out
+// of band, as layer:fingerprint (or layer:fingerprint:backend for keys of another backend than groth16), in addition
+// to the keys at pinnedVerificationKeysPath.
+var pinnedVerificationKeyFingerprints []string
+
+// pinVerificationKeys pins the verification keys at pinnedVerificationKeysPath, if set, and the verification keys of
+// pinnedVerificationKeyFingerprints, each for its layer.
+func pinVerificationKeys() error {
+	if pinnedVerificationKeysPath != "" {
+		if err := core.PinPublishedVerificationKeys(core.ReadPublishedVerificationKeys(pinnedVerificationKeysPath)); err != nil {
+			return err
+		}
+	}
+	for _, pin := range pinnedVerificationKeyFingerprints {
+		fields := strings.Split(pin, ":")
+		if len(fields) < 2 || len(fields) > 3 {
+			return fmt.Errorf("pinned verification key %q is not layer:fingerprint or layer:fingerprint:backend", pin)
+		}
+		backend := core.BACKEND_GROTH16
+		if len(fields) == 3 {
+			backend = core.ProofBackend(fields[2])
+		}
+		if err := core.PinVerificationKeyFingerprints(fields[0], backend, fields[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// requirePinnedVerificationKeys exits with an error if no trusted verification keys are given, for the commands that
+// verify a round from its top level proof alone, whose key fixes those of every other proof.
+func requirePinnedVerificationKeys(command string) {
+	if pinnedVerificationKeysPath == "" && len(pinnedVerificationKeyFingerprints) == 0 {
+		fmt.Println(command + " needs the trusted verification key of the top level proof (--pinned-vk or --pinned-vk-hash)")
+		os.Exit(1)
+	}
+}
+
+// addRoundEpochFlag adds the flag selecting the epoch of the round a command reads or writes, whose directory of 'out/'
+// is given by the layout (see --layout). Commands proving a round bind it to their proofs instead (see
+// addBatchPreparationFlags).
+func addRoundEpochFlag(cmd *cobra.Command) {
+	cmd.Flags().Uint64Var(&options.ProofEpoch, "epoch", 0, "epoch of the round, which selects its directory of 'out/' in the --layout")
+}
+
+var (
+	keyBundlePath      string
+	keyBundlePublicKey string
+)
+
 var userVerifyCmd = &cobra.Command{
 	Use:   "userverify [path/to/userinfo.json]",
 	Short: "Verify the provided user account was included in the provided proofs and proofs are valid.",
@@ -45,16 +168,334 @@ var userVerifyCmd = &cobra.Command{
 		"---> Your account was included in the asset sum for the low level proof.\n" +
 		"---> The low level proof was included in the asset sum for the mid level proof.\n" +
 		"---> The mid level proof was included in the asset sum for the high level proof.\n" +
-		"---> There were no accounts with overflowing balances or negative balances included in any of the asset sums.",
+		"---> There were no accounts with overflowing balances or negative balances included in any of the asset sums.\n" +
+		"Packages that reference verification keys by fingerprint need the key bundle of the round (--key-bundle), signed\n" +
+		"by the given public key (--bundle-key). With a key bundle, every key must be in it, including those a package comes\n" +
+		"with, and the keys of the bundle are pinned. Proofs are verified with the trusted verification keys of their layer\n" +
+		"given with --pinned-vk or --pinned-vk-hash (or the key bundle), never with their own.\n" +
+		"The package may be read from an HTTPS URL (e.g. https://example.com/round/user_123.json) or the URI of a bucket\n" +
+		"(s3://bucket/user_123.json). With --round, the directory or URL the round was published in, the package's top level\n" +
+		"proof must also be the published one, whose checksum must match the round's manifest. The manifest must be signed by\n" +
+		"one of the --round-key public keys, or have the SHA-256 digest given with --manifest-digest, obtained out of band.",
 	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		userVerificationElements := core.ReadDataFromFile[core.UserVerificationElements](args[0])
+		if keyBundlePath != "" {
+			publicKey, err := hex.DecodeString(keyBundlePublicKey)
+			if err != nil {
+				fmt.Println("Error parsing public key:", err)
+				os.Exit(1)
+			}
+			bundle, err := core.VerifyKeyBundle(core.ReadKeyBundle(keyBundlePath), []ed25519.PublicKey{publicKey}, time.Now())
+			if err != nil {
+				fmt.Println("Key bundle verification failed:", err)
+				os.Exit(1)
+			}
+			userVerificationElements, err = core.ResolveBundledVerificationKeys(userVerificationElements, bundle.VerificationKeys())
+			if err != nil {
+				fmt.Println("User verification failed:", err)
+				os.Exit(1)
+			}
+			if err := core.PinKeyBundle(bundle); err != nil {
+				fmt.Println("Key bundle verification failed:", err)
+				os.Exit(1)
+			}
+		}
+		if err := pinVerificationKeys(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 		core.VerifyUser(userVerificationElements)
+		if userVerificationRoundDir != "" {
+			trustedKeys := make([]ed25519.PublicKey, len(userVerificationRoundKeys))
+			for i, key := range userVerificationRoundKeys {
+				var err error
+				trustedKeys[i], err = hex.DecodeString(key)
+				if err != nil {
+					fmt.Println("Error parsing round key:", err)
+					os.Exit(1)
+				}
+			}
+			if err := core.VerifyUserAgainstManifest(userVerificationElements, userVerificationRoundDir, trustedKeys, userVerificationManifestDigest); err != nil {
+				fmt.Println("User verification failed:", err)
+				os.Exit(1)
+			}
+		}
 		println("User verification succeeded!")
+
+		// show the verified balances in display units (only non-zero balances)
+		fmt.Println("Verified account balances:")
+		for _, balance := range core.ConvertGoBalanceToRawUVBalances(userVerificationElements.AccountInfo.Balance) {
+			if balance.Amount != "0" {
+				fmt.Printf("  %s: %s\n", balance.Asset, balance.DisplayAmount)
+			}
+		}
+	},
+}
+
+// userVerificationRoundDir is the directory or URL of the published round userverify checks packages against.
+var userVerificationRoundDir string
+
+// userVerificationRoundKeys are the hex encoded public keys trusted to sign the manifest of the round userverify checks
+// packages against, and userVerificationManifestDigest is its pinned digest.
+var (
+	userVerificationRoundKeys      []string
+	userVerificationManifestDigest string
+)
+
+// userVerificationWorkers is the number of packages batch-userverify verifies concurrently.
+var userVerificationWorkers int
+
+var batchUserVerifyCmd = &cobra.Command{
+	Use:   "batch-userverify [path/to/userinfo.json]...",
+	Short: "Verifies many user verification packages concurrently, and prints whether each passed",
+	Long: "Verifies each of the given user verification packages like userverify, --workers packages at once, and prints\n" +
+		"a summary of the packages that passed and the error of those that failed. Each distinct proof is verified only\n" +
+		"once, however many packages include it. Proofs are verified with the trusted verification keys given with\n" +
+		"--pinned-vk or --pinned-vk-hash, never with their own. Exits with an error if any package fails.",
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := core.SetVerificationWorkers(userVerificationWorkers); err != nil {
+			fmt.Println(err)
+			return
+		}
+		if err := pinVerificationKeys(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		results := core.VerifyUsers(args)
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "package\tresult")
+		failed := 0
+		for _, result := range results {
+			status := "pass"
+			if !result.Passed {
+				status = result.Error
+				failed++
+			}
+			fmt.Fprintf(writer, "%s\t%s\n", result.Path, status)
+		}
+		writer.Flush()
+		if failed > 0 {
+			fmt.Printf("User verification failed for %d of %d packages!\n", failed, len(results))
+			os.Exit(1)
+		}
+		println("User verification succeeded!")
+	},
+}
+
+// provenanceRoundKeys are the hex encoded public keys trusted to sign the manifest listing the provenance statement
+// verifyprovenance checks.
+var provenanceRoundKeys []string
+
+var verifyProvenanceCmd = &cobra.Command{
+	Use:   "verifyprovenance [BatchCount]",
+	Short: "Verifies the provenance statement in 'out/public/' matches the published proofs",
+	Long: "Verifies the provenance statement in 'out/public/provenance.json' is the one recorded in the round metadata,\n" +
+		"and that the digests of the published proofs match the digests recorded in the statement. Rounds without a\n" +
+		"statement fail. With --round-key, the statement must also be listed in the manifest signed by one of the given\n" +
+		"public keys. Only the public data is needed. The command takes 1 argument: the number of batches.",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		batchCount, err := strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Println("Error parsing batchCount:", err)
+			os.Exit(1)
+		}
+		trustedKeys := make([]ed25519.PublicKey, len(provenanceRoundKeys))
+		for i, key := range provenanceRoundKeys {
+			trustedKeys[i], err = hex.DecodeString(key)
+			if err != nil {
+				fmt.Println("Error parsing round key:", err)
+				os.Exit(1)
+			}
+		}
+		core.VerifyProvenance(batchCount, roundDir(), trustedKeys)
+		println("Provenance verification succeeded!")
+	},
+}
+
+var verifyAuditLogCmd = &cobra.Command{
+	Use:   "verifyauditlog",
+	Short: "Verifies the prover audit log in 'out/audit.log' is an unbroken hash chain",
+	Long: "Verifies the prover audit log in 'out/audit.log', which records the hash of every file read and written by\n" +
+		"each run of the prover, is an unbroken hash chain, and prints the number of runs and files recorded.",
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		entries, err := core.VerifyProverAuditLog(roundDir())
+		if err != nil {
+			fmt.Println("Audit log verification failed:", err)
+			os.Exit(1)
+		}
+		runs, files := 0, 0
+		for _, entry := range entries {
+			switch entry.Action {
+			case core.PROVER_AUDIT_STARTED:
+				runs++
+			case core.PROVER_AUDIT_READ, core.PROVER_AUDIT_WRITE:
+				files++
+			}
+		}
+		fmt.Printf("%d runs, %d files read or written\n", runs, files)
+		println("Audit log verification succeeded!")
+	},
+}
+
+var verifyTopCmd = &cobra.Command{
+	Use:   "verifytop [path/to/top_level_proof.json]",
+	Short: "Verifies a round from its top level proof alone",
+	Long: "Verifies a round from its top level proof alone, which is possible for proofs generated with the\n" +
+		"groth16-recursive backend: the top level proof verifies the mid level proofs in its circuit, which verify the\n" +
+		"bottom level proofs in theirs. Also verifies the published AssetSum is the sum hashed in\n" +
+		"MerkleRootWithAssetSumHash. The verification key of the top level proof must be pinned for the top layer with the\n" +
+		"groth16-recursive backend with --pinned-vk or --pinned-vk-hash, obtained out of band. The command takes 1\n" +
+		"argument: the path of the top level proof.",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		requirePinnedVerificationKeys("verifytop")
+		if err := pinVerificationKeys(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if err := core.VerifyTopLevelProof(core.ReadDataFromFile[core.CompletedProof](args[0])); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		println("Top level proof verification succeeded!")
+	},
+}
+
+var verifySuccinctCmd = &cobra.Command{
+	Use:   "verifysuccinct [path/to/succinct_round_proof.json]",
+	Short: "Verifies a round from its succinct proof alone",
+	Long: "Verifies a round from its succinct proof ('out/public/succinct_round_proof.json'), which is published with the\n" +
+		"rounds proven with the groth16-recursive backend: its top level proof attests every lower level proof verified,\n" +
+		"and must be that of the round metadata and number of batches it comes with. The verification key of the top level\n" +
+		"proof must be pinned for the top layer with the groth16-recursive backend with --pinned-vk or --pinned-vk-hash\n" +
+		"(e.g. top:<fingerprint>:groth16-recursive), obtained out of band: nothing else needs to be\n" +
+		"downloaded, whatever the number of batches. The command takes 1 argument: the path of the succinct round proof.",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		requirePinnedVerificationKeys("verifysuccinct")
+		if err := pinVerificationKeys(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		proof := core.ReadSuccinctRoundProof(args[0])
+		if err := core.VerifySuccinctRoundProof(proof); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("  round %s: %d batches\n", proof.Metadata.RoundId, proof.BatchCount)
+		println("Succinct round proof verification succeeded!")
+	},
+}
+
+var verifySignatureCmd = &cobra.Command{
+	Use:   "verify-signature [PublicKey]",
+	Short: "Verifies the published files in 'out/public/' are signed by the given public key",
+	Long: "Verifies every published file of the round in 'out/public/' listed in its manifest, and the manifest itself,\n" +
+		"is signed in 'out/public/signatures.json' by the given hex encoded public key, and that each file matches the\n" +
+		"checksum recorded in the manifest. Only the public data is needed. The command takes 1 argument: the public key.",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		publicKey, err := hex.DecodeString(args[0])
+		if err != nil {
+			fmt.Println("Error parsing public key:", err)
+			os.Exit(1)
+		}
+		manifest, err := core.VerifySignatures(roundDir(), []ed25519.PublicKey{publicKey})
+		if err != nil {
+			fmt.Println("Signature verification failed:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("  round %s: %d batches, %d signed files\n", manifest.RoundId, manifest.BatchCount, len(manifest.Files)+1)
+		println("Signature verification succeeded!")
+	},
+}
+
+var compatibilityReportPath string
+
+var verifyHistoryCmd = &cobra.Command{
+	Use:   "verifyhistory [ArchiveDir]",
+	Short: "Verifies the public data of every round in an archive of previous rounds",
+	Long: "Verifies the public data of every round in ArchiveDir with this binary, and prints a compatibility matrix of\n" +
+		"the checks passed by each round. Each subdirectory of ArchiveDir is a round with the layout of 'out/' (only its\n" +
+		"'public/' directory is needed), and rounds are verified in the order of their names. Exits with an error if\n" +
+		"any round fails. The command takes 1 argument: the archive directory.",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := pinVerificationKeys(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		report := core.VerifyHistoricalRounds(args[0])
+		if compatibilityReportPath != "" {
+			core.WriteCompatibilityReport(compatibilityReportPath, report)
+		}
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprint(writer, "round\tversion\tdepth\tdomain\tbatches")
+		for _, check := range report.Checks {
+			fmt.Fprint(writer, "\t"+check)
+		}
+		fmt.Fprintln(writer)
+		for _, round := range report.Rounds {
+			fmt.Fprintf(writer, "%s\t%d\t%d\t%q\t%d", round.Round, round.CircuitVersion, round.TreeDepth, round.DeploymentDomain, round.BatchCount)
+			for _, check := range report.Checks {
+				result := round.Checks[check]
+				if result != core.ROUND_CHECK_PASSED && result != core.ROUND_CHECK_SKIPPED {
+					result = "FAIL"
+				}
+				fmt.Fprint(writer, "\t"+result)
+			}
+			fmt.Fprintln(writer)
+		}
+		writer.Flush()
+
+		for _, round := range report.Rounds {
+			for _, check := range report.Checks {
+				if result := round.Checks[check]; result != core.ROUND_CHECK_PASSED && result != core.ROUND_CHECK_SKIPPED {
+					fmt.Printf("%s: %s: %s\n", round.Round, check, result)
+				}
+			}
+		}
+		if !report.Compatible {
+			fmt.Println("Historical verification failed!")
+			os.Exit(1)
+		}
+		println("Historical verification succeeded!")
 	},
 }
 
 func init() {
+	userVerifyCmd.Flags().StringVar(&keyBundlePath, "key-bundle", "", "path of the key bundle to resolve referenced verification keys from")
+	userVerifyCmd.Flags().StringVar(&keyBundlePublicKey, "bundle-key", "", "hex encoded public key trusted to sign the key bundle")
+	userVerifyCmd.Flags().StringVar(&userVerificationRoundDir, "round", "", "directory or URL of the published round (e.g. https://example.com/round/) whose manifest and top level proof the package must match")
+	verifyProvenanceCmd.Flags().StringSliceVar(&provenanceRoundKeys, "round-key", nil, "hex encoded public key trusted to sign the manifest of the round (repeatable)")
+	userVerifyCmd.Flags().StringSliceVar(&userVerificationRoundKeys, "round-key", nil, "hex encoded public key trusted to sign the manifest of the round given with --round (repeatable)")
+	userVerifyCmd.Flags().StringVar(&userVerificationManifestDigest, "manifest-digest", "", "hex encoded SHA-256 digest, obtained out of band, of the manifest of the round given with --round")
+	for _, cmd := range []*cobra.Command{verifyCmd, userVerifyCmd, batchUserVerifyCmd, verifyTopCmd, verifySuccinctCmd, verifyHistoryCmd} {
+		cmd.Flags().StringVar(&pinnedVerificationKeysPath, "pinned-vk", "", "path of the trusted verification keys (a copy of 'out/public/vk.json' obtained out of band) every proof must be verified with")
+		cmd.Flags().StringSliceVar(&pinnedVerificationKeyFingerprints, "pinned-vk-hash", nil, "trusted verification keys, obtained out of band, as layer:fingerprint or layer:fingerprint:backend (e.g. top:<hex SHA-256>:groth16-recursive), every proof of the layer must come with a key of")
+	}
+	for _, cmd := range []*cobra.Command{verifyCmd, verifyProvenanceCmd, verifySignatureCmd} {
+		addRoundEpochFlag(cmd)
+	}
+	verifyCmd.Flags().IntVar(&verificationWorkers, "workers", 1, "number of batches verified concurrently")
+	batchUserVerifyCmd.Flags().IntVar(&userVerificationWorkers, "workers", runtime.NumCPU(), "number of user verification packages verified concurrently")
+	verifyCmd.Flags().StringVar(&duplicateUserCheck, "duplicate-users", "none", "check no user is in more than one batch with the set of the users of every batch (set) or a bloom filter of them (bloom), or not (none)")
+	verifyCmd.Flags().BoolVar(&verifyStreaming, "streaming", false, "read and verify the batches one at a time, for rounds too large to verify in memory")
+	verifyCmd.Flags().StringVar(&verificationReportPath, "report", "", "path to write the report of every failed check to as JSON, instead of stopping at the first failure")
+	verifyHistoryCmd.Flags().StringVar(&compatibilityReportPath, "report", "", "path to write the compatibility report to as JSON")
 	rootCmd.AddCommand(verifyCmd)
 	rootCmd.AddCommand(userVerifyCmd)
+	rootCmd.AddCommand(batchUserVerifyCmd)
+	rootCmd.AddCommand(verifyProvenanceCmd)
+	rootCmd.AddCommand(verifyAuditLogCmd)
+	rootCmd.AddCommand(verifyHistoryCmd)
+	rootCmd.AddCommand(verifyTopCmd)
+	rootCmd.AddCommand(verifySuccinctCmd)
+	rootCmd.AddCommand(verifySignatureCmd)
 }
@@ -0,0 +1,123 @@
+package cli
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"bitgo.com/proof_of_reserves/core"
+	"github.com/spf13/cobra"
+)
+
+var (
+	releaseManifestPath string
+	releaseManifestOut  string
+	releaseKeys         []string
+	releaseBinaryDir    string
+	releaseRevision     string
+	showDependencies    bool
+)
+
+var versionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "Prints how this binary was built, and optionally verifies it against a signed release manifest",
+	Long: "Prints the build info embedded in this binary: the Go version, module version, VCS revision, build settings,\n" +
+		"and (with --deps) the versions of every dependency. With --verify, the digest of this binary is recomputed and\n" +
+		"compared against the binary of the current platform in the given release manifest, which must be signed by one\n" +
+		"of the --release-key public keys.",
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		info, err := core.GetBuildInfo()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Println("Go version:", info.GoVersion)
+		fmt.Println("Module:", info.ModulePath, info.ModuleVersion)
+		keys := make([]string, 0, len(info.Settings))
+		for key := range info.Settings {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Printf("  %s=%s\n", key, info.Settings[key])
+		}
+		if showDependencies {
+			fmt.Println("Dependencies:")
+			for _, dependency := range info.Dependencies {
+				fmt.Printf("  %s %s %s\n", dependency.Path, dependency.Version, dependency.Sum)
+			}
+		}
+		for _, warning := range info.ReproducibilityWarnings() {
+			fmt.Println("Warning:", warning)
+		}
+
+		if releaseManifestPath == "" {
+			return
+		}
+		trustedKeys := make([]ed25519.PublicKey, len(releaseKeys))
+		for i, key := range releaseKeys {
+			trustedKeys[i], err = hex.DecodeString(key)
+			if err != nil {
+				fmt.Println("Error parsing release key:", err)
+				os.Exit(1)
+			}
+		}
+		executable, err := os.Executable()
+		if err != nil {
+			fmt.Println("Error locating this binary:", err)
+			os.Exit(1)
+		}
+		manifest, artifact, err := core.VerifyBinaryAgainstReleaseManifest(core.ReadReleaseManifest(releaseManifestPath), trustedKeys, executable)
+		if err != nil {
+			fmt.Println("Binary verification failed:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("This binary is %s of release %s (revision %s), with digest %s.\n", artifact.Name, manifest.Version, manifest.VCSRevision, artifact.Digest)
+		println("Binary verification succeeded!")
+	},
+}
+
+var versionManifestCmd = &cobra.Command{
+	Use:   "manifest [Version] [SigningKeyPath] [GOOS/GOARCH=BinaryName]...",
+	Short: "Writes a signed manifest of the binaries of a release",
+	Long: "Writes a manifest of the binaries of a release, with the digest of each binary in --dir, signed with the key in\n" +
+		"SigningKeyPath (see 'keys generate'), to the path given by --out. Each\n" +
+		"binary is given with its platform, e.g. linux/amd64=bgproof-linux-amd64.",
+	Args: cobra.MinimumNArgs(3),
+	Run: func(cmd *cobra.Command, args []string) {
+		artifacts := make([]core.ReleaseArtifact, 0, len(args)-2)
+		for _, arg := range args[2:] {
+			platform, name, foundName := strings.Cut(arg, "=")
+			goos, goarch, foundArch := strings.Cut(platform, "/")
+			if !foundName || !foundArch {
+				fmt.Println("Error parsing binary (expected GOOS/GOARCH=BinaryName):", arg)
+				os.Exit(1)
+			}
+			artifacts = append(artifacts, core.ReleaseArtifact{Name: name, GOOS: goos, GOARCH: goarch})
+		}
+		if releaseRevision == "" {
+			if info, err := core.GetBuildInfo(); err == nil {
+				releaseRevision = info.Settings["vcs.revision"]
+			}
+		}
+		manifest := core.BuildReleaseManifest(args[0], releaseRevision, artifacts, releaseBinaryDir, time.Now())
+		core.WriteReleaseManifest(releaseManifestOut, core.SignReleaseManifest(manifest, core.ReadKeyBundleSigningKey(args[1])))
+		fmt.Printf("Wrote the manifest of %d binaries of release %s to %s.\n", len(manifest.Artifacts), manifest.Version, releaseManifestOut)
+	},
+}
+
+func init() {
+	versionCmd.Flags().StringVar(&releaseManifestPath, "verify", "", "path of the signed release manifest")
+	versionCmd.Flags().StringSliceVar(&releaseKeys, "release-key", nil, "hex encoded public key trusted to sign release manifests (repeatable)")
+	versionCmd.Flags().BoolVar(&showDependencies, "deps", false, "print the versions of every dependency")
+	versionManifestCmd.Flags().StringVar(&releaseManifestOut, "out", "release_manifest.json", "path to write the signed release manifest to")
+	versionManifestCmd.Flags().StringVar(&releaseBinaryDir, "dir", ".", "directory of the release binaries")
+	versionManifestCmd.Flags().StringVar(&releaseRevision, "revision", "", "VCS revision of the release (defaults to the revision of this binary)")
+	versionCmd.AddCommand(versionManifestCmd)
+	rootCmd.AddCommand(versionCmd)
+}
@@ -0,0 +1,417 @@
+// Package client is a small library for verifying liability proofs from other services. It downloads the public
+// artifacts of a round, checks their verification keys are pinned, and verifies user verification packages against
+// them, returning typed results instead of panicking.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"bitgo.com/proof_of_reserves/verification"
+)
+
+// proofLevel labels the proof of the given level of a round with levelCount levels in errors.
+func proofLevel(level int, levelCount int) string {
+	return verification.LayerName(level, levelCount) + " level"
+}
+
+// RoundMetadata is the metadata published with a round (see core.RoundMetadata), as far as the client reads it.
+type RoundMetadata struct {
+	// DeploymentDomain is the domain mixed into the hashes of the round (see circuit.SetDeploymentDomain).
+	DeploymentDomain string
+	LayerPlan        LayerPlan
+	// CircuitVersion is the version of the circuit the round was generated with (0 for rounds generated before it
+	// was recorded, which used version 1).
+	CircuitVersion int
+	// RoundId is the random identifier every artifact of the round is tagged with.
+	RoundId string
+	// WalletIdEncoding is the encoding of the WalletIds of the round's accounts (empty for rounds generated before it
+	// was recorded, which used base36).
+	WalletIdEncoding circuit.WalletIdEncoding `json:",omitempty"`
+	// AssetSubset are the indices of the assets of the asset registry the round proves the liabilities in (nil for
+	// every asset).
+	AssetSubset []int `json:",omitempty"`
+}
+
+// LayerPlan is the number of proofs of each level of a round (see core.LayerPlan).
+type LayerPlan struct {
+	BottomLevelProofCount int
+	MidLevelProofCount    int
+	TopLevelProofCount    int
+	// LevelCount is the number of levels of proofs (0 for rounds generated before it was recorded, which have
+	// verification.MIN_HIERARCHY_LEVELS).
+	LevelCount int `json:",omitempty"`
+}
+
+// Levels returns the number of levels of proofs of the round, from the bottom level to the top level.
+func (plan LayerPlan) Levels() int {
+	if plan.LevelCount == 0 {
+		return verification.MIN_HIERARCHY_LEVELS
+	}
+	return plan.LevelCount
+}
+
+// Round contains the public artifacts of a round that user packages are verified against.
+type Round struct {
+	TopProof verification.CompletedProof
+	Metadata RoundMetadata
+	// VerificationKeyFingerprints are the fingerprints of the verification keys of each level, from the bottom level
+	// to the top level.
+	VerificationKeyFingerprints []string
+}
+
+// UserResult is the result of successfully verifying a user verification package.
+type UserResult struct {
+	WalletId      string
+	Balances      []verification.RawUVBalance
+	TopMerkleRoot []byte
+	LiabilitySum  []verification.RawUVBalance
+}
+
+// Client verifies user verification packages against the public artifacts of a round published under a base URL
+// (the equivalent of the output directory of the prover, e.g. "https://example.com/round/" for "out/"). It is safe
+// for concurrent use.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	// deploymentDomain is the deployment domain of the round (see SetDeploymentDomain), independent of the one
+	// configured in the circuit package, so clients of several deployments can be used in one process.
+	deploymentDomain string
+	// mutex guards the fields below, which are written when keys are pinned or the round is loaded, and read when
+	// packages are verified.
+	mutex sync.RWMutex
+	// pinnedVerificationKeys are the trusted verification keys of each layer (see verification.LayerName), by
+	// fingerprint. Keys
+	// pinned by fingerprint alone have no key until it is known.
+	pinnedVerificationKeys verification.VerificationKeyPins
+	// verificationKeys are the known verification keys by fingerprint, used to resolve the keys referenced by packages.
+	verificationKeys map[string]string
+	round            *Round
+}
+
+// New creates a client for the round published under baseURL. If httpClient is nil, http.DefaultClient is used.
+func New(baseURL string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+	return &Client{baseURL: baseURL, httpClient: httpClient, pinnedVerificationKeys: make(verification.VerificationKeyPins), verificationKeys: make(map[string]string)}
+}
+
+// SetDeploymentDomain sets the deployment domain (e.g. "testnet" or "production") the round must have been generated
+// for, whose hashes mix it in (see circuit.Config.DeploymentDomain). The empty domain is the default. It must be set
+// before LoadRound and TrustKeyBundle.
+func (c *Client) SetDeploymentDomain(domain string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.deploymentDomain = domain
+}
+
+// PinVerificationKeys adds the fingerprints (see verification.ComputeVerificationKeyFingerprint) of verification keys,
+// obtained out of band, to the trusted keys of the layer ("bottom", "mid", "top", or "mid<level>" for intermediate
+// levels, as in key bundles), whose proofs are verified with the given backend (the empty backend is
+// verification.BACKEND_GROTH16). The proofs of a layer must have one of its pinned keys, so a key trusted for one layer
+// can't be used for another. Keys must be pinned (or trusted with TrustKeyBundle) before LoadRound.
+func (c *Client) PinVerificationKeys(layer string, backend verification.ProofBackend, fingerprints ...string) error {
+	backend, err := verification.ParseProofBackend(string(backend))
+	if err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, fingerprint := range fingerprints {
+		c.pinVerificationKey(layer, fingerprint, verification.PinnedVerificationKey{Backend: backend})
+	}
+	return nil
+}
+
+// pinVerificationKey pins the verification key of the given fingerprint for the layer, unless it is already pinned
+// with its key.
+func (c *Client) pinVerificationKey(layer string, fingerprint string, key verification.PinnedVerificationKey) {
+	if c.pinnedVerificationKeys[layer] == nil {
+		c.pinnedVerificationKeys[layer] = make(map[string]verification.PinnedVerificationKey)
+	}
+	if pinned, ok := c.pinnedVerificationKeys[layer][fingerprint]; ok && pinned.VerificationKey != "" && key.VerificationKey == "" {
+		return
+	}
+	c.pinnedVerificationKeys[layer][fingerprint] = key
+}
+
+// TrustKeyBundle verifies a signed key bundle (see verification.VerifyKeyBundleForDomain) of the deployment domain of the
+// client against the trusted public keys and pins each of its verification keys for its layer. Its keys are also used
+// to resolve the keys referenced by packages.
+func (c *Client) TrustKeyBundle(signed verification.SignedKeyBundle, trustedPublicKeys ...ed25519.PublicKey) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	bundle, err := verification.VerifyKeyBundleForDomain(signed, trustedPublicKeys, time.Now(), c.deploymentDomain)
+	if err != nil {
+		return err
+	}
+	for _, entry := range bundle.Keys {
+		backend, err := verification.ParseProofBackend(string(entry.Backend))
+		if err != nil {
+			return err
+		}
+		c.pinVerificationKey(entry.Layer, entry.Fingerprint, verification.PinnedVerificationKey{VerificationKey: entry.VerificationKey, Backend: backend})
+		c.verificationKeys[entry.Fingerprint] = entry.VerificationKey
+	}
+	return nil
+}
+
+// recoverError converts a panic from the verification package into an error, wrapping the panic value if it is an
+// error, so callers can inspect it with errors.Is and errors.As. Runtime errors are bugs rather than failed
+// verifications, so they panic again.
+func recoverError(err *error, message string) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	if _, ok := r.(runtime.Error); ok {
+		panic(r)
+	}
+	if recovered, ok := r.(error); ok {
+		*err = fmt.Errorf("%s: %w", message, recovered)
+		return
+	}
+	*err = fmt.Errorf("%s: %v", message, r)
+}
+
+// fetchJson downloads the file at the given path relative to the base URL and decodes it into data.
+func (c *Client) fetchJson(ctx context.Context, path string, data interface{}) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("error downloading %s: %w", path, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("error downloading %s: unexpected status %s", path, response.Status)
+	}
+	if err := json.NewDecoder(response.Body).Decode(data); err != nil {
+		return fmt.Errorf("error decoding %s: %w", path, err)
+	}
+	return nil
+}
+
+// fetchProof downloads and decodes the completed proof at the given path relative to the base URL.
+func (c *Client) fetchProof(ctx context.Context, path string) (proof verification.CompletedProof, err error) {
+	var rawProof verification.RawCompletedProof
+	if err := c.fetchJson(ctx, path, &rawProof); err != nil {
+		return verification.CompletedProof{}, err
+	}
+	defer recoverError(&err, "error decoding "+path)
+	return verification.ConvertRawCompletedProofToCompletedProof(rawProof), nil
+}
+
+// checkPinned returns an error if the verification key of the proof of the given level of a round with levelCount
+// levels is not pinned for its layer.
+func (c *Client) checkPinned(proof verification.CompletedProof, level int, levelCount int) (string, error) {
+	label := proofLevel(level, levelCount)
+	fingerprint, err := verification.ComputeVerificationKeyFingerprint(proof.VerificationKey)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", label, err)
+	}
+	if _, ok := c.pinnedVerificationKeys[verification.LayerName(level, levelCount)][fingerprint]; !ok {
+		return "", fmt.Errorf("%s verification key %s is not pinned for the %s layer", label, fingerprint, verification.LayerName(level, levelCount))
+	}
+	return fingerprint, nil
+}
+
+// resolvePublishedVerificationKeys sets the verification keys of the proofs that reference their key by fingerprint
+// (see core.Options.PublishVerificationKeys) from the keys published with the round, which are only downloaded if a proof
+// references its key. The keys must still be pinned to be trusted.
+func (c *Client) resolvePublishedVerificationKeys(ctx context.Context, proofs []verification.CompletedProof, roundId string) error {
+	var verificationKeys map[string]string
+	for i := range proofs {
+		if proofs[i].VerificationKey != "" {
+			continue
+		}
+		if verificationKeys == nil {
+			var published verification.PublishedVerificationKeys
+			if err := c.fetchJson(ctx, verification.VERIFICATION_KEYS_FILE, &published); err != nil {
+				return err
+			}
+			if published.RoundId != roundId {
+				return fmt.Errorf("published verification keys belong to round %q, but the round metadata is of round %q", published.RoundId, roundId)
+			}
+			if err := published.Validate(); err != nil {
+				return err
+			}
+			verificationKeys = published.VerificationKeys()
+		}
+		verificationKey, ok := verificationKeys[proofs[i].VerificationKeyFingerprint]
+		if !ok {
+			return fmt.Errorf("published %s proof references verification key %s, which is not published", proofLevel(i, len(proofs)), proofs[i].VerificationKeyFingerprint)
+		}
+		proofs[i].VerificationKey = verificationKey
+	}
+	return nil
+}
+
+// LoadRound downloads the top level proof, round metadata, and the first proof of every other level of the round,
+// checks the round was generated for the deployment domain of the client (see SetDeploymentDomain), and that the
+// verification key of each proof is pinned for its layer (see PinVerificationKeys and TrustKeyBundle). Keys are never
+// trusted because the round publishes them, so a round can't be loaded without pinned keys.
+func (c *Client) LoadRound(ctx context.Context) (*Round, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.loadRound(ctx)
+}
+
+// loadRoundOnce loads the round (see LoadRound) unless it is already loaded.
+func (c *Client) loadRoundOnce(ctx context.Context) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.round != nil {
+		return nil
+	}
+	_, err := c.loadRound(ctx)
+	return err
+}
+
+// loadRound loads the round like LoadRound. The caller must hold the write lock.
+func (c *Client) loadRound(ctx context.Context) (*Round, error) {
+	if len(c.pinnedVerificationKeys) == 0 {
+		return nil, fmt.Errorf("no verification keys are pinned, pin the trusted keys with PinVerificationKeys or TrustKeyBundle")
+	}
+	bottomProof, err := c.fetchProof(ctx, verification.BOTTOM_PROOF_PREFIX+"0.json")
+	if err != nil {
+		return nil, err
+	}
+	midProof, err := c.fetchProof(ctx, verification.MIDDLE_PROOF_PREFIX+"0.json")
+	if err != nil {
+		return nil, err
+	}
+	topProof, err := c.fetchProof(ctx, verification.TOP_PROOF_PREFIX+"0.json")
+	if err != nil {
+		return nil, err
+	}
+	if topProof.AssetSum == nil {
+		return nil, fmt.Errorf("published top level proof has no AssetSum")
+	}
+	var metadata RoundMetadata
+	if err := c.fetchJson(ctx, verification.ROUND_METADATA_FILE, &metadata); err != nil {
+		return nil, err
+	}
+	if metadata.DeploymentDomain != c.deploymentDomain {
+		return nil, fmt.Errorf("round was generated for deployment domain %q, expected %q", metadata.DeploymentDomain, c.deploymentDomain)
+	}
+
+	// rounds with more than verification.MIN_HIERARCHY_LEVELS levels have intermediate levels between the mid and top levels
+	proofs := []verification.CompletedProof{bottomProof, midProof}
+	for level := 2; level < metadata.LayerPlan.Levels()-1; level++ {
+		proof, err := c.fetchProof(ctx, verification.IntermediateLevelProofPrefix(level)+"0.json")
+		if err != nil {
+			return nil, err
+		}
+		proofs = append(proofs, proof)
+	}
+	proofs = append(proofs, topProof)
+	for i, proof := range proofs {
+		if proof.RoundId != metadata.RoundId {
+			return nil, fmt.Errorf("published %s proof belongs to round %q, but the round metadata is of round %q", proofLevel(i, len(proofs)), proof.RoundId, metadata.RoundId)
+		}
+	}
+
+	if err := c.resolvePublishedVerificationKeys(ctx, proofs, metadata.RoundId); err != nil {
+		return nil, err
+	}
+
+	round := &Round{TopProof: proofs[len(proofs)-1], Metadata: metadata}
+	for i, proof := range proofs {
+		fingerprint, err := c.checkPinned(proof, i, len(proofs))
+		if err != nil {
+			return nil, err
+		}
+		round.VerificationKeyFingerprints = append(round.VerificationKeyFingerprints, fingerprint)
+		c.verificationKeys[fingerprint] = proof.VerificationKey
+	}
+	c.round = round
+	return round, nil
+}
+
+// VerifyUser verifies a user verification package against the round, loading the round first if necessary.
+// Verification keys referenced by the package are resolved from the trusted key bundles and the loaded round. It
+// checks every proof in the package uses a pinned verification key, that the package's top level proof is the
+// published one, and that the package passes core.VerifyUser in the deployment domain of the client. Packages can be
+// verified concurrently.
+func (c *Client) VerifyUser(ctx context.Context, elements verification.UserVerificationElements) (result *UserResult, err error) {
+	defer recoverError(&err, "user verification failed")
+	if err := c.loadRoundOnce(ctx); err != nil {
+		return nil, err
+	}
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	elements, err = verification.ResolveVerificationKeys(elements, c.verificationKeys)
+	if err != nil {
+		return nil, err
+	}
+	proofs := elements.ProofInfo.ProofChain()
+	for i, proof := range proofs {
+		if _, err := c.checkPinned(proof, i, len(proofs)); err != nil {
+			return nil, err
+		}
+	}
+
+	// the package must be verified against the published top level proof
+	topProof := elements.ProofInfo.TopProof
+	if topProof.RoundId != c.round.Metadata.RoundId {
+		return nil, fmt.Errorf("package belongs to round %q, but the published round is %q", topProof.RoundId, c.round.Metadata.RoundId)
+	}
+	if !bytes.Equal(topProof.MerkleRoot, c.round.TopProof.MerkleRoot) ||
+		!bytes.Equal(topProof.MerkleRootWithAssetSumHash, c.round.TopProof.MerkleRootWithAssetSumHash) ||
+		topProof.AssetSum == nil || !topProof.AssetSum.Equals(*c.round.TopProof.AssetSum) {
+		return nil, fmt.Errorf("top level proof in package does not match the published top level proof")
+	}
+
+	if err := verification.VerifyUserWithPinnedKeysForDomain(elements, c.pinnedVerificationKeys, c.deploymentDomain); err != nil {
+		return nil, err
+	}
+
+	raw := verification.ConvertUserVerificationElementsToRawUserVerificationElements(elements)
+	// the WalletId is given in the encoding of the round rather than the configured one
+	walletIdEncoding, err := circuit.ParseWalletIdEncoding(string(c.round.Metadata.WalletIdEncoding))
+	if err != nil {
+		return nil, err
+	}
+	return &UserResult{
+		WalletId:      circuit.ConvertGoAccountToRawGoAccountWithEncoding(elements.AccountInfo, walletIdEncoding).WalletId,
+		Balances:      raw.AccountInfo.Balance,
+		TopMerkleRoot: topProof.MerkleRoot,
+		LiabilitySum:  *raw.ProofInfo.TopProof.AssetSum,
+	}, nil
+}
+
+// VerifyUserPackage decodes a user verification package (e.g. accountproof.json) and verifies it with VerifyUser.
+func (c *Client) VerifyUserPackage(ctx context.Context, r io.Reader) (result *UserResult, err error) {
+	var rawElements verification.RawUserVerificationElements
+	if err := json.NewDecoder(r).Decode(&rawElements); err != nil {
+		return nil, fmt.Errorf("error decoding user verification package: %w", err)
+	}
+
+	var elements verification.UserVerificationElements
+	func() {
+		defer recoverError(&err, "error converting user verification package")
+		elements = verification.ConvertRawUserVerificationElementsToUserVerificationElements(rawElements)
+	}()
+	if err != nil {
+		return nil, err
+	}
+	return c.VerifyUser(ctx, elements)
+}
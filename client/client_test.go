@@ -0,0 +1,244 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"bitgo.com/proof_of_reserves/core"
+	"bitgo.com/proof_of_reserves/verification"
+	"github.com/consensys/gnark/test"
+)
+
+var server *httptest.Server
+var roundDir string
+var userElements core.UserVerificationElements
+
+// TestMain generates a small round once and serves its output directory for all tests to use.
+func TestMain(m *testing.M) {
+	outDir, err := os.MkdirTemp("", "client_test")
+	if err != nil {
+		panic(err)
+	}
+	outDir += "/"
+	roundDir = outDir
+	for _, dir := range []string{"secret", "public"} {
+		if err := os.MkdirAll(filepath.Join(outDir, dir), 0o755); err != nil {
+			panic(err)
+		}
+	}
+	core.GenerateData(1, 4, outDir)
+	core.Prove(1, outDir)
+
+	accounts := core.ReadDataFromFile[core.ProofElements](outDir + core.SECRET_DATA_PREFIX + "0.json").Accounts
+	userElements = core.BuildUserVerificationElements(
+		accounts[1],
+		1,
+		core.ReadDataFromFile[core.CompletedProof](outDir+core.BOTTOM_PROOF_PREFIX+"0.json"),
+		core.ReadDataFromFile[core.CompletedProof](outDir+core.MIDDLE_PROOF_PREFIX+"0.json"),
+		core.ReadDataFromFile[core.CompletedProof](outDir+core.TOP_PROOF_PREFIX+"0.json"),
+	)
+
+	server = httptest.NewServer(http.FileServer(http.Dir(outDir)))
+	exitCode := m.Run()
+	server.Close()
+	os.RemoveAll(outDir)
+	os.Exit(exitCode)
+}
+
+// newPinnedClient returns a client of the test round with the verification key of each layer pinned, as if obtained
+// out of band.
+func newPinnedClient(t *testing.T) *Client {
+	return newPinnedClientWithBackend(t, verification.BACKEND_GROTH16)
+}
+
+// newPinnedClientWithBackend returns a client of the test round like newPinnedClient, whose keys are pinned for the
+// given backend.
+func newPinnedClientWithBackend(t *testing.T, backend verification.ProofBackend) *Client {
+	c := New(server.URL, nil)
+	proofs := userElements.ProofInfo.ProofChain()
+	for i, proof := range proofs {
+		fingerprint, err := verification.ComputeVerificationKeyFingerprint(proof.VerificationKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.PinVerificationKeys(verification.LayerName(i, len(proofs)), backend, fingerprint); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return c
+}
+
+func TestVerifyUser(t *testing.T) {
+	assert := test.NewAssert(t)
+	c := newPinnedClient(t)
+
+	round, err := c.LoadRound(context.Background())
+	assert.NoError(err)
+	assert.Equal(3, len(round.VerificationKeyFingerprints))
+
+	result, err := c.VerifyUser(context.Background(), userElements)
+	assert.NoError(err)
+	raw := core.ConvertUserVerificationElementsToRawUserVerificationElements(userElements)
+	assert.Equal(raw.AccountInfo.WalletId, result.WalletId)
+	assert.Equal(raw.AccountInfo.Balance, result.Balances)
+	assert.Equal(*raw.ProofInfo.TopProof.AssetSum, result.LiabilitySum)
+
+	// verifying a package file should load the round automatically
+	packageBytes, err := json.Marshal(raw)
+	assert.NoError(err)
+	result, err = newPinnedClient(t).VerifyUserPackage(context.Background(), bytes.NewReader(packageBytes))
+	assert.NoError(err)
+	assert.Equal(raw.AccountInfo.WalletId, result.WalletId)
+}
+
+func TestVerifyUserFailures(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// tampered balance should fail verification without panicking
+	tamperedElements := userElements
+	tamperedElements.AccountInfo.Balance = append([]*big.Int{big.NewInt(1)}, userElements.AccountInfo.Balance[1:]...)
+	_, err := newPinnedClient(t).VerifyUser(context.Background(), tamperedElements)
+	assert.Error(err)
+
+	// a top level proof other than the published one should fail
+	otherTopProofElements := userElements
+	otherTopProofElements.ProofInfo.TopProof.MerkleRoot = []byte{0x12}
+	_, err = newPinnedClient(t).VerifyUser(context.Background(), otherTopProofElements)
+	assert.Error(err)
+
+	// a package of another round should fail
+	otherRoundElements := userElements
+	otherRoundElements.ProofInfo.TopProof.RoundId = "other"
+	_, err = newPinnedClient(t).VerifyUser(context.Background(), otherRoundElements)
+	assert.Error(err)
+
+	// unpinned verification keys should fail, and so should a round loaded without any pinned key
+	c := New(server.URL, nil)
+	assert.NoError(c.PinVerificationKeys("top", verification.BACKEND_GROTH16, "0000"))
+	_, err = c.LoadRound(context.Background())
+	assert.Error(err)
+	_, err = New(server.URL, nil).LoadRound(context.Background())
+	assert.Error(err)
+	_, err = New(server.URL, nil).VerifyUser(context.Background(), userElements)
+	assert.Error(err)
+
+	// keys are pinned for their layer only, so the key of a layer does not verify the proofs of another
+	c = New(server.URL, nil)
+	proofs := userElements.ProofInfo.ProofChain()
+	for i, proof := range proofs {
+		fingerprint, err := verification.ComputeVerificationKeyFingerprint(proof.VerificationKey)
+		assert.NoError(err)
+		assert.NoError(c.PinVerificationKeys(verification.LayerName((i+1)%len(proofs), len(proofs)), verification.BACKEND_GROTH16, fingerprint))
+	}
+	_, err = c.LoadRound(context.Background())
+	assert.Error(err)
+
+	// keys are pinned for a backend, which the proofs of the package are verified with, and unknown backends are
+	// rejected
+	_, err = newPinnedClientWithBackend(t, verification.BACKEND_PLONK).VerifyUser(context.Background(), userElements)
+	assert.Error(err)
+	assert.Error(New(server.URL, nil).PinVerificationKeys("top", "stark", "0000"))
+
+	// a round of another deployment domain should fail
+	c = newPinnedClient(t)
+	c.SetDeploymentDomain("testnet")
+	_, err = c.LoadRound(context.Background())
+	assert.Error(err)
+
+	// missing round should fail
+	c = newPinnedClient(t)
+	c.baseURL += "missing/"
+	_, err = c.LoadRound(context.Background())
+	assert.Error(err)
+
+	// invalid package should fail
+	_, err = newPinnedClient(t).VerifyUserPackage(context.Background(), bytes.NewReader([]byte("{}")))
+	assert.Error(err)
+}
+
+func TestVerifyUserConcurrently(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// the round is loaded once by the first package, while the others wait for it
+	c := newPinnedClient(t)
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = c.VerifyUser(context.Background(), userElements)
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		assert.NoError(err)
+	}
+}
+
+func TestClientDeploymentDomain(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// the deployment domain of a client does not depend on the one configured in the circuit package
+	defer circuit.SetDeploymentDomain("")
+	circuit.SetDeploymentDomain("testnet")
+	c := newPinnedClient(t)
+	_, err := c.LoadRound(context.Background())
+	assert.NoError(err)
+	_, err = c.VerifyUser(context.Background(), userElements)
+	assert.NoError(err)
+}
+
+func TestTrustKeyBundle(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	keyPath := filepath.Join(t.TempDir(), "signing_key")
+	publicKey, err := hex.DecodeString(core.GenerateKeyBundleSigningKey(keyPath))
+	assert.NoError(err)
+	now := time.Now()
+	signed := core.SignKeyBundle(core.BuildKeyBundle(1, roundDir, now, now.Add(time.Hour)), core.ReadKeyBundleSigningKey(keyPath))
+
+	// the keys of a trusted bundle are pinned, so the round can be loaded
+	c := New(server.URL, nil)
+	assert.NoError(c.TrustKeyBundle(signed, publicKey))
+	_, err = c.LoadRound(context.Background())
+	assert.NoError(err)
+
+	// bundles signed by other keys are rejected
+	otherPublicKey, _, err := ed25519.GenerateKey(nil)
+	assert.NoError(err)
+	assert.Error(New(server.URL, nil).TrustKeyBundle(signed, otherPublicKey))
+
+	// and so are bundles of another deployment domain than the client's
+	c = New(server.URL, nil)
+	c.SetDeploymentDomain("testnet")
+	assert.Error(c.TrustKeyBundle(signed, publicKey))
+}
+
+func TestVerifyUserReferencingVerificationKeys(t *testing.T) {
+	assert := test.NewAssert(t)
+	referencingElements := core.ReferenceVerificationKeys(userElements)
+
+	// the referenced keys are resolved from the loaded round
+	result, err := newPinnedClient(t).VerifyUser(context.Background(), referencingElements)
+	assert.NoError(err)
+	assert.Equal(core.ConvertUserVerificationElementsToRawUserVerificationElements(userElements).AccountInfo.WalletId, result.WalletId)
+
+	// keys that are not known fail
+	unknownElements := referencingElements
+	unknownElements.ProofInfo.BottomProof.VerificationKeyFingerprint = "0000"
+	_, err = newPinnedClient(t).VerifyUser(context.Background(), unknownElements)
+	assert.Error(err)
+}
@@ -0,0 +1,258 @@
+package core
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// actions of the entries of the prover audit log
+const (
+	PROVER_AUDIT_STARTED   = "started"
+	PROVER_AUDIT_READ      = "read"
+	PROVER_AUDIT_WRITE     = "write"
+	PROVER_AUDIT_SUCCEEDED = "succeeded"
+	PROVER_AUDIT_FAILED    = "failed"
+)
+
+// ProverAuditEntry is an entry of the prover audit log of a round directory (PROVER_AUDIT_LOG_FILE), recording that a
+// run of the prover started or ended, or read or wrote a file of the round directory. Each entry is chained to the one
+// before it by its hash, so entries can't be removed, reordered, or changed without breaking the chain of the entries
+// after them (see VerifyProverAuditLog).
+type ProverAuditEntry struct {
+	Time    time.Time
+	RoundId string
+	Action  string
+	// File is the path of the file read or written, relative to the round directory, and Digest the hex encoded SHA-256
+	// of its contents as written (even if it was compressed since, see openFile).
+	File   string `json:",omitempty"`
+	Digest string `json:",omitempty"`
+	// Error is the error the run failed with.
+	Error string `json:",omitempty"`
+	// PreviousHash is the hash of the entry before it (empty for the first entry of the log), and Hash the hex encoded
+	// SHA-256 of the JSON encoding of the entry without its hash.
+	PreviousHash string `json:",omitempty"`
+	Hash         string `json:",omitempty"`
+}
+
+// proverAuditLog appends the entries of a run of the prover to the audit log of its round directory. A nil log records
+// nothing.
+type proverAuditLog struct {
+	mutex    sync.Mutex
+	roundDir string
+	roundId  string
+	lastHash string
+}
+
+var (
+	proverAuditLogsMutex sync.Mutex
+	// proverAuditLogs are the audit logs of the runs in progress, by round directory.
+	proverAuditLogs = make(map[string]*proverAuditLog)
+)
+
+// beginProverAudit starts recording the files of the round directory read and written by the run of the round with
+// the given id in the audit log of the round directory, so the data the published proofs were produced from can be
+// reconstructed. The log is only ever appended to, by every run, failed or not. Returns nil if the round directory is
+// in a storage (see storageOf), where files can't be appended to, or already has a run in progress.
+func beginProverAudit(roundDir string, roundId string) *proverAuditLog {
+	if isStorageURI(roundDir) {
+		return nil
+	}
+	proverAuditLogsMutex.Lock()
+	defer proverAuditLogsMutex.Unlock()
+	if _, ok := proverAuditLogs[roundDir]; ok {
+		return nil
+	}
+	entries, err := readProverAuditLog(roundDir)
+	panicOnError(err, "error reading prover audit log")
+	log := &proverAuditLog{roundDir: roundDir, roundId: roundId}
+	if len(entries) > 0 {
+		log.lastHash = entries[len(entries)-1].Hash
+	}
+	panicOnError(log.append(ProverAuditEntry{Action: PROVER_AUDIT_STARTED}), "error appending to prover audit log")
+	proverAuditLogs[roundDir] = log
+	return log
+}
+
+// proverAuditLogOf returns the audit log of the run in progress in the round directory of the file, if any.
+func proverAuditLogOf(filePath string) *proverAuditLog {
+	proverAuditLogsMutex.Lock()
+	defer proverAuditLogsMutex.Unlock()
+	for roundDir, log := range proverAuditLogs {
+		if strings.HasPrefix(filePath, roundDir) {
+			return log
+		}
+	}
+	return nil
+}
+
+// end ends the run, and must be deferred by the function running it, before the end of its run journal (see beginRun)
+// so it runs once the files of a failed run are rolled back: the outcome of the run, which failed if it panicked or
+// returned an error in runErr, is appended to the log, and the panic the run failed with, if any, is resumed.
+func (log *proverAuditLog) end(runErr *error) {
+	if log == nil {
+		return
+	}
+	proverAuditLogsMutex.Lock()
+	delete(proverAuditLogs, log.roundDir)
+	proverAuditLogsMutex.Unlock()
+
+	if r := recover(); r != nil {
+		if err := log.append(ProverAuditEntry{Action: PROVER_AUDIT_FAILED, Error: fmt.Sprint(r)}); err != nil {
+			panic(fmt.Sprintf("%v (error appending to prover audit log: %v)", r, err))
+		}
+		panic(r)
+	}
+	if *runErr != nil {
+		if err := log.append(ProverAuditEntry{Action: PROVER_AUDIT_FAILED, Error: (*runErr).Error()}); err != nil {
+			*runErr = fmt.Errorf("%w (error appending to prover audit log: %v)", *runErr, err)
+		}
+		return
+	}
+	panicOnError(log.append(ProverAuditEntry{Action: PROVER_AUDIT_SUCCEEDED}), "error appending to prover audit log")
+}
+
+// recordFile appends an entry recording that the file at filePath, with the given SHA-256 digest, was read or written.
+func (log *proverAuditLog) recordFile(action string, filePath string, digest []byte) error {
+	if log == nil {
+		return nil
+	}
+	return log.append(ProverAuditEntry{
+		Action: action,
+		File:   strings.TrimPrefix(filePath, log.roundDir),
+		Digest: hex.EncodeToString(digest),
+	})
+}
+
+// append chains the entry to the last entry of the log, and appends it to the audit log file.
+func (log *proverAuditLog) append(entry ProverAuditEntry) error {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+	entry.Time = time.Now().UTC()
+	entry.RoundId = log.roundId
+	entry.PreviousHash = log.lastHash
+	hash, err := hashProverAuditEntry(entry)
+	if err != nil {
+		return err
+	}
+	entry.Hash = hash
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(log.roundDir+PROVER_AUDIT_LOG_FILE, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(encoded, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	if GetOptions().SyncFileWrites {
+		if err := file.Sync(); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	log.lastHash = hash
+	return nil
+}
+
+// auditRead returns the file, which records that filePath was read, with the digest of its contents, once it is
+// closed. The rest of the file is read first if it was not read to its end. A nil log returns the file as is.
+func (log *proverAuditLog) auditRead(filePath string, file io.ReadCloser) io.ReadCloser {
+	if log == nil {
+		return file
+	}
+	digest := sha256.New()
+	return auditedFile{Reader: io.TeeReader(file, digest), close: func(rest io.Reader) error {
+		_, err := io.Copy(io.Discard, rest)
+		if err == nil {
+			err = log.recordFile(PROVER_AUDIT_READ, filePath, digest.Sum(nil))
+		}
+		return errors.Join(err, file.Close())
+	}}
+}
+
+// auditedFile is a file read through the digest of its contents (see auditRead).
+type auditedFile struct {
+	io.Reader
+	close func(rest io.Reader) error
+}
+
+func (f auditedFile) Close() error {
+	return f.close(f.Reader)
+}
+
+// hashProverAuditEntry returns the hash of the entry (see ProverAuditEntry).
+func hashProverAuditEntry(entry ProverAuditEntry) (string, error) {
+	entry.Hash = ""
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(encoded)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// readProverAuditLog reads the entries of the prover audit log of the round directory. Returns nil if there is none.
+func readProverAuditLog(roundDir string) ([]ProverAuditEntry, error) {
+	file, err := os.Open(roundDir + PROVER_AUDIT_LOG_FILE)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	entries := make([]ProverAuditEntry, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry ProverAuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("error decoding prover audit log: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// VerifyProverAuditLog verifies the prover audit log of the round in outDir (PROVER_AUDIT_LOG_FILE) is an unbroken
+// chain: each entry has the hash of its contents, and the hash of the entry before it. Returns its entries, or an
+// error if verification fails or the round has no audit log.
+func VerifyProverAuditLog(outDir string) ([]ProverAuditEntry, error) {
+	outDir = GetOptions().OutputLayout.RoundDir(outDir)
+	entries, err := readProverAuditLog(outDir)
+	if err != nil {
+		return nil, err
+	}
+	if entries == nil {
+		return nil, fmt.Errorf("round in %s has no prover audit log", outDir)
+	}
+	previousHash := ""
+	for i, entry := range entries {
+		if entry.PreviousHash != previousHash {
+			return nil, fmt.Errorf("entry %d of the prover audit log is not chained to the entry before it", i)
+		}
+		hash, err := hashProverAuditEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+		if entry.Hash != hash {
+			return nil, fmt.Errorf("entry %d of the prover audit log does not match its hash", i)
+		}
+		previousHash = entry.Hash
+	}
+	return entries, nil
+}
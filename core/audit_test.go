@@ -0,0 +1,81 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"strings"
+	"testing"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"github.com/consensys/gnark/test"
+)
+
+func TestProverAuditLog(t *testing.T) {
+	if testing.Short() {
+		t.Skip("proves rounds")
+	}
+	assert := test.NewAssert(t)
+	config := circuit.GetConfig()
+	assert.NoError(circuit.SetConfig(circuit.Config{TreeDepth: 2}))
+	defer func() { assert.NoError(circuit.SetConfig(config)) }()
+
+	// a run records the digest of every batch it read and every file it wrote, between its start and its outcome
+	dir := t.TempDir() + "/"
+	GenerateData(2, 4, dir)
+	Prove(2, dir)
+	entries, err := VerifyProverAuditLog(dir)
+	assert.NoError(err)
+	assert.Equal(PROVER_AUDIT_STARTED, entries[0].Action)
+	assert.Equal(PROVER_AUDIT_SUCCEEDED, entries[len(entries)-1].Action)
+	roundId := readRoundMetadata(dir).RoundId
+	digests := map[string]map[string]string{PROVER_AUDIT_READ: {}, PROVER_AUDIT_WRITE: {}}
+	for _, entry := range entries {
+		assert.Equal(roundId, entry.RoundId)
+		if entry.File != "" {
+			digests[entry.Action][entry.File] = entry.Digest
+		}
+	}
+	for _, file := range []string{GetOptions().OutputLayout.secretDataFile(0), GetOptions().OutputLayout.secretDataFile(1)} {
+		assert.Equal(fileDigest(t, dir+file), digests[PROVER_AUDIT_READ][file], file)
+	}
+	for _, file := range []string{GetOptions().OutputLayout.topProofPrefix() + "0.json", ROUND_METADATA_FILE, ROUND_MANIFEST_FILE} {
+		assert.Equal(fileDigest(t, dir+file), digests[PROVER_AUDIT_WRITE][file], file)
+	}
+
+	// the next runs are chained to it, failed or not
+	assert.Panics(func() { Prove(3, dir) })
+	failedEntries, err := VerifyProverAuditLog(dir)
+	assert.NoError(err)
+	assert.Equal(entries, failedEntries[:len(entries)])
+	assert.Equal(PROVER_AUDIT_STARTED, failedEntries[len(entries)].Action)
+	assert.Equal(entries[len(entries)-1].Hash, failedEntries[len(entries)].PreviousHash)
+	failure := failedEntries[len(failedEntries)-1]
+	assert.Equal(PROVER_AUDIT_FAILED, failure.Action)
+	assert.NotEmpty(failure.Error)
+	assert.NotEqual(roundId, failure.RoundId)
+
+	// changing or removing an entry breaks the chain
+	log, err := os.ReadFile(dir + PROVER_AUDIT_LOG_FILE)
+	assert.NoError(err)
+	lines := strings.SplitAfter(string(log), "\n")
+	changed := strings.Replace(string(log), entries[1].Digest, strings.Repeat("0", 64), 1)
+	assert.NoError(os.WriteFile(dir+PROVER_AUDIT_LOG_FILE, []byte(changed), 0o600))
+	_, err = VerifyProverAuditLog(dir)
+	assert.Error(err)
+	assert.NoError(os.WriteFile(dir+PROVER_AUDIT_LOG_FILE, []byte(strings.Join(append(lines[:1], lines[2:]...), "")), 0o600))
+	_, err = VerifyProverAuditLog(dir)
+	assert.Error(err)
+	_, err = VerifyProverAuditLog(t.TempDir())
+	assert.Error(err)
+}
+
+// fileDigest returns the hex encoded SHA-256 of the contents of the file at path.
+func fileDigest(t *testing.T, path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(data)
+	return hex.EncodeToString(digest[:])
+}
@@ -0,0 +1,282 @@
+package core
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/kzg"
+	gnarkbackend "github.com/consensys/gnark/backend"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/backend/plonk"
+	"github.com/consensys/gnark/backend/witness"
+	"github.com/consensys/gnark/constraint"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/r1cs"
+	"github.com/consensys/gnark/frontend/cs/scs"
+	stdgroth16 "github.com/consensys/gnark/std/recursion/groth16"
+)
+
+const (
+	// PROVER_CPU is the prover of proofs generated on the CPU.
+	PROVER_CPU = "cpu"
+	// PROVER_GPU is the prover of proofs generated on the GPU with ICICLE.
+	PROVER_GPU = "gpu"
+)
+
+// SetProofBackend sets the backend new proofs are generated with. Proofs are always verified with their own backend.
+func SetProofBackend(name ProofBackend) error {
+	parsed, err := ParseProofBackend(string(name))
+	if err != nil {
+		return err
+	}
+	return updateSettings(func(s *settings) error {
+		s.proofBackend = parsed
+		return nil
+	})
+}
+
+func GetProofBackend() ProofBackend {
+	return getSettings().proofBackend
+}
+
+// validateProofBackendConfig returns an error if proofs can't be generated with the configured backend and circuit
+// configuration: the aggregation circuits of BACKEND_GROTH16_RECURSIVE are only practical with small tree depths (see
+// circuit.Config.ValidateAggregation).
+func validateProofBackendConfig() error {
+	if GetProofBackend() == BACKEND_GROTH16_RECURSIVE {
+		return circuit.GetConfig().ValidateAggregation()
+	}
+	return nil
+}
+
+// SetPlonkSRS sets the universal SRS PLONK circuits are set up with, which should come from a public ceremony. It must
+// be large enough for the largest circuit. Without one, each PLONK circuit is set up with an SRS generated locally
+// from a random secret that is discarded right away, which is no more trustworthy than a Groth16 setup.
+func SetPlonkSRS(srs *kzg.SRS) {
+	updateSettings(func(s *settings) error {
+		s.plonkSRS = srs
+		return nil
+	})
+}
+
+// SetDeterministicSetup makes setups derive their randomness (the Groth16 toxic waste, and the PLONK SRS secret if no
+// SRS is set) from the seed instead of crypto/rand, so the same circuit always gets the same proving and verification
+// keys. Groth16 proofs are then derived from the seed and their public inputs too, so the same elements always give
+// the same CompletedProof, which makes golden files of proofs possible, and a changed verification key reveals an
+// accidental change of the circuit. A nil seed restores random setups. Circuits are set up again when the seed
+// changes.
+//
+// It is for tests only and must never be used for production keys or published proofs: anyone who knows the seed can
+// forge proofs. gnark samples this randomness from crypto/rand.Reader, which is replaced for the whole process while a
+// circuit is set up or proven, so anything else reading crypto/rand meanwhile (e.g. salts or encryption nonces) reads
+// from the seed too, and setups and proofs are run one at a time.
+func SetDeterministicSetup(seed []byte) {
+	updateSettings(func(s *settings) error {
+		s.setupSeed = seed
+		return nil
+	})
+}
+
+func GetDeterministicSetup() []byte {
+	return getSettings().setupSeed
+}
+
+// seededRandMutex serializes the setups and proofs that replace crypto/rand.Reader with a seeded reader.
+var seededRandMutex sync.Mutex
+
+// withSeededRand runs f with crypto/rand.Reader replaced by a reader derived from the seed and the label, if the seed
+// is set (see SetDeterministicSetup).
+func withSeededRand(seed []byte, label []byte, f func() error) error {
+	if seed == nil {
+		return f()
+	}
+	seededRandMutex.Lock()
+	defer seededRandMutex.Unlock()
+	reader := rand.Reader
+	defer func() { rand.Reader = reader }()
+	rand.Reader = newSeededReader(seed, label)
+	return f()
+}
+
+// seededReader is a deterministic stream of SHA-256(seed || label || counter) blocks.
+type seededReader struct {
+	mutex   sync.Mutex
+	prefix  []byte
+	counter uint64
+	block   []byte
+}
+
+func newSeededReader(seed []byte, label []byte) *seededReader {
+	prefix := binary.BigEndian.AppendUint64(nil, uint64(len(seed)))
+	prefix = append(append(prefix, seed...), label...)
+	return &seededReader{prefix: prefix}
+}
+
+func (r *seededReader) Read(p []byte) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for n := 0; n < len(p); {
+		if len(r.block) == 0 {
+			block := sha256.Sum256(binary.BigEndian.AppendUint64(append([]byte{}, r.prefix...), r.counter))
+			r.counter++
+			r.block = block[:]
+		}
+		copied := copy(p[n:], r.block)
+		r.block = r.block[copied:]
+		n += copied
+	}
+	return len(p), nil
+}
+
+// SetGPUProving sets whether Groth16 proofs are generated on the GPU with gnark's ICICLE prover, which needs a binary
+// built with the icicle build tag and a CUDA device. Proofs fall back to the CPU if the binary is built without it, or if
+// the GPU prover fails. PLONK proofs are always generated on the CPU. GPU proofs are ordinary proofs of the backend, so
+// they are verified like any other.
+func SetGPUProving(enabled bool) {
+	if enabled && !icicleAvailable {
+		slog.Warn("GPU proving requested, but the binary is built without the icicle build tag, proving on the CPU")
+	}
+	updateSettings(func(s *settings) error {
+		s.gpuProving = enabled
+		return nil
+	})
+}
+
+func GetGPUProving() bool {
+	return getSettings().gpuProving
+}
+
+// ReadPlonkSRS reads a BN254 KZG SRS (in the gnark-crypto binary format) from a file, for SetPlonkSRS.
+func ReadPlonkSRS(path string) (*kzg.SRS, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening SRS: %w", err)
+	}
+	defer file.Close()
+	srs := new(kzg.SRS)
+	if _, err := srs.ReadFrom(file); err != nil {
+		return nil, fmt.Errorf("error reading SRS: %w", err)
+	}
+	return srs, nil
+}
+
+// compileCircuit compiles the circuit into the constraint system of the backend (R1CS for Groth16, SCS for PLONK).
+func compileCircuit(backend ProofBackend, c frontend.Circuit) (constraint.ConstraintSystem, error) {
+	if backend == BACKEND_PLONK {
+		return frontend.Compile(ecc.BN254.ScalarField(), scs.NewBuilder, c)
+	}
+	return frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, c)
+}
+
+// setupCircuit runs the setup of the backend for the compiled circuit, completing the partial proof.
+func setupCircuit(partialProof *PartialProof) error {
+	return withSeededRand(GetDeterministicSetup(), []byte("setup"), func() (err error) {
+		if partialProof.backend != BACKEND_PLONK {
+			partialProof.pk, partialProof.vk, err = groth16.Setup(partialProof.cs)
+			return err
+		}
+		canonical, lagrange, err := plonkSRSForCircuit(partialProof.cs)
+		if err != nil {
+			return err
+		}
+		partialProof.plonkPk, partialProof.plonkVk, err = plonk.Setup(partialProof.cs, canonical, lagrange)
+		return err
+	})
+}
+
+// plonkSRSForCircuit returns the canonical and Lagrange SRS of the size the circuit needs, taken from plonkSRS or
+// generated locally if it is not set.
+func plonkSRSForCircuit(cs constraint.ConstraintSystem) (canonical *kzg.SRS, lagrange *kzg.SRS, err error) {
+	sizeLagrange := ecc.NextPowerOfTwo(uint64(cs.GetNbConstraints() + cs.GetNbPublicVariables()))
+	sizeCanonical := sizeLagrange + 3
+
+	plonkSRS := getSettings().plonkSRS
+	if plonkSRS == nil {
+		slog.Warn("no PLONK SRS set, generating one locally (use an SRS from a public ceremony in production)", "size", sizeCanonical)
+		secret, err := rand.Int(rand.Reader, ecc.BN254.ScalarField())
+		if err != nil {
+			return nil, nil, fmt.Errorf("error generating SRS secret: %w", err)
+		}
+		canonical, err = kzg.NewSRS(sizeCanonical, secret)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error generating SRS: %w", err)
+		}
+	} else {
+		if uint64(len(plonkSRS.Pk.G1)) < sizeCanonical {
+			return nil, nil, fmt.Errorf("PLONK SRS has %d points, but the circuit needs %d", len(plonkSRS.Pk.G1), sizeCanonical)
+		}
+		canonical = &kzg.SRS{Pk: kzg.ProvingKey{G1: plonkSRS.Pk.G1[:sizeCanonical]}, Vk: plonkSRS.Vk}
+	}
+
+	lagrangeG1, err := kzg.ToLagrangeG1(canonical.Pk.G1[:sizeLagrange])
+	if err != nil {
+		return nil, nil, fmt.Errorf("error computing Lagrange SRS: %w", err)
+	}
+	return canonical, &kzg.SRS{Pk: kzg.ProvingKey{G1: lagrangeG1}, Vk: canonical.Vk}, nil
+}
+
+// proveCircuit proves the witness satisfies the circuit with the backend of the partial proof, and returns the proof
+// and verification key to serialize, and the prover the proof was generated with (PROVER_CPU or PROVER_GPU). With a
+// deterministic setup, Groth16 proofs are derived from the seed and the public inputs (PLONK blinds its proofs
+// concurrently, so its proofs stay random).
+func proveCircuit(partialProof PartialProof, fullWitness witness.Witness) (proof io.WriterTo, vk io.WriterTo, prover string, err error) {
+	if partialProof.backend == BACKEND_PLONK {
+		proof, err = plonk.Prove(partialProof.cs, partialProof.plonkPk, fullWitness)
+		return proof, partialProof.plonkVk, PROVER_CPU, err
+	}
+	var opts []gnarkbackend.ProverOption
+	if partialProof.backend == BACKEND_GROTH16_RECURSIVE {
+		opts = append(opts, stdgroth16.GetNativeProverOptions(ecc.BN254.ScalarField(), ecc.BN254.ScalarField()))
+	}
+	seed := GetDeterministicSetup()
+	label := []byte("prove")
+	if seed != nil {
+		publicWitness, err := fullWitness.Public()
+		if err != nil {
+			return nil, nil, "", err
+		}
+		publicInputs, err := publicWitness.MarshalBinary()
+		if err != nil {
+			return nil, nil, "", err
+		}
+		label = append(label, publicInputs...)
+	}
+	if GetGPUProving() && icicleAvailable {
+		err = withSeededRand(seed, label, func() (err error) {
+			proof, err = proveOnGPU(partialProof, fullWitness, opts)
+			return err
+		})
+		if err == nil {
+			return proof, partialProof.vk, PROVER_GPU, nil
+		}
+		slog.Warn("GPU prover failed, falling back to the CPU", "error", err)
+		recordGPUFallback()
+	}
+	err = withSeededRand(seed, label, func() (err error) {
+		proof, err = groth16.Prove(partialProof.cs, icicleProvingKey(partialProof.pk), fullWitness, opts...)
+		return err
+	})
+	return proof, partialProof.vk, PROVER_CPU, err
+}
+
+// proveOnGPU proves the witness satisfies the Groth16 circuit with gnark's ICICLE prover, with the proving key converted
+// to its type if needed (see icicleProvingKey). ICICLE panics on some device failures (e.g. no CUDA device), which are
+// returned as errors, so the proof can fall back to the CPU.
+func proveOnGPU(partialProof PartialProof, fullWitness witness.Witness, opts []gnarkbackend.ProverOption) (proof groth16.Proof, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("GPU prover panicked: %v", r)
+		}
+	}()
+	opts = append(slices.Clone(opts), gnarkbackend.WithIcicleAcceleration())
+	return groth16.Prove(partialProof.cs, icicleProvingKey(partialProof.pk), fullWitness, opts...)
+}
@@ -0,0 +1,83 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"time"
+
+	"bitgo.com/proof_of_reserves/circuit"
+)
+
+// ProveBatch generates the bottom level proof of the batch with the given index in outDir, prepared the same way as by
+// Prove, and writes it, so the batches of a round can be proven on different machines (e.g. each proving a range of
+// batches into a copy of outDir). Once the bottom level proof of every batch is gathered in outDir, AggregateBatches
+// generates the rest of the round. The proof belongs to no round until it is aggregated. It is configured with the
+// given options like Prove.
+func ProveBatch(index int, outDir string, opts ...ProverOption) {
+	options := GetOptions()
+	if index < 0 {
+		panic(fmt.Sprintf("batch index must not be negative, found %d", index))
+	}
+	panicOnError(NewLayerPlan(index+1).Validate(), "invalid batch index")
+	panicOnError(options.ProofPublicationPolicy.Validate(), "invalid publication policy")
+	panicOnError(validateProofBackendConfig(), "invalid configuration for the proof backend")
+	progress := newProgressReporter(newProverSettings(opts).progress, 1)
+	outDir = options.OutputLayout.RoundDir(outDir)
+	panicOnError(createRoundDirectories(outDir), "error creating round directories")
+
+	proofElements := newBatchLoader(outDir).load(index, false)
+	panicOnError(verifyDistinctWalletIds(proofElements.Accounts), "duplicate accounts in batch "+strconv.Itoa(index))
+	writeProof(generateProof(proofElements, progress.forBatch(bottomLevelLayer.name, index)), outDir, bottomLevelLayer, index)
+}
+
+// AggregateBatches generates the upper level proofs of the round in outDir from the bottom level proofs of its batches
+// generated by ProveBatch, and writes them with the files describing the round like Prove. Every batch is read again to
+// check its proof is of the batch as it is now, to report its excluded accounts, and to add it to the sparse Merkle
+// tree (see Options.BuildSparseTree), so the settings of the round must be those the batches were proven with. It is configured
+// with the given options like Prove.
+func AggregateBatches(batchCount int, outDir string, opts ...ProverOption) {
+	options := GetOptions()
+	startedOn := time.Now()
+	outDir = options.OutputLayout.RoundDir(outDir)
+	panicOnError(createRoundDirectories(outDir), "error creating round directories")
+	roundId := newRoundId()
+	plan := NewLayerPlan(batchCount)
+	panicOnError(plan.Validate(), "invalid number of batches")
+	panicOnError(options.ProofPublicationPolicy.Validate(), "invalid publication policy")
+	panicOnError(validateProofBackendConfig(), "invalid configuration for the proof backend")
+	migration, err := readRoundMigration(outDir, plan)
+	panicOnError(err, "invalid tree depth migration")
+	progress := newProgressReporter(newProverSettings(opts).progress, plan.UpperLevelProofCount())
+
+	loader := newBatchLoader(outDir)
+	sparseTree := newSparseTree()
+	bottomLevelProofs := make([]CompletedProof, batchCount)
+	for i := range bottomLevelProofs {
+		proofElements := loader.load(i, true)
+		bottomLevelProofs[i] = readBatchProof(outDir, i, proofElements)
+		bottomLevelProofs[i].RoundId = roundId
+		addToSparseTree(sparseTree, []ProofElements{proofElements}, i)
+	}
+
+	upperLevelProofs := generateUpperLevelProofs(bottomLevelProofs, plan, progress)
+	writeProofsToFiles(bottomLevelProofs, outDir, bottomLevelLayer)
+	writeRound(outDir, roundId, "aggregate", plan, migration, upperLevelProofs, loader.exclusions, loader.excludedAccountReport(batchCount), sparseTreeRoot(sparseTree), startedOn)
+}
+
+// readBatchProof reads the bottom level proof of the batch with the given index written by ProveBatch, and checks it
+// is a proof of the given accounts of the batch. The asset sum (and the merkle nodes, if the publication policy kept
+// them out of outDir) of the proof are those of the accounts, which the upper level proofs need.
+func readBatchProof(outDir string, index int, proofElements ProofElements) CompletedProof {
+	proof := readBottomLevelProof(outDir, index)
+	merkleNodes := circuit.GoComputeMerkleTreeNodesFromAccounts(proofElements.Accounts)
+	if !bytes.Equal(proof.MerkleRoot, merkleNodes[0][0]) {
+		panic(fmt.Sprintf("bottom level proof %d is not a proof of batch %d (was the batch changed after it was proven?)", index, index))
+	}
+	if !hasAllMerkleNodes(proof, circuit.GetConfig()) {
+		proof.MerkleNodes = merkleNodes
+	}
+	assetSum := circuit.SumGoAccountBalances(proofElements.Accounts)
+	proof.AssetSum = &assetSum
+	return proof
+}
@@ -0,0 +1,40 @@
+package core
+
+import (
+	"os"
+	"testing"
+
+	"github.com/consensys/gnark/test"
+)
+
+func TestProveBatchAndAggregate(t *testing.T) {
+	assert := test.NewAssert(t)
+	outDir := t.TempDir() + "/"
+	assert.NoError(os.MkdirAll(outDir+"secret", 0o755))
+	assert.NoError(os.MkdirAll(outDir+"public", 0o755))
+	GenerateData(2, 4, outDir)
+
+	// the second batch is proven in a copy of outDir, as on another machine, and its proof gathered in outDir
+	copyDir := t.TempDir() + "/"
+	assert.NoError(os.CopyFS(copyDir, os.DirFS(outDir)))
+	ProveBatch(0, outDir)
+	ProveBatch(1, copyDir)
+	proof, err := os.ReadFile(copyDir + BOTTOM_PROOF_PREFIX + "1.json")
+	assert.NoError(err)
+	assert.NoError(os.WriteFile(outDir+BOTTOM_PROOF_PREFIX+"1.json", proof, 0o644))
+	assert.Panics(func() { ProveBatch(-1, outDir) })
+
+	// the aggregated round verifies like a round proven by Prove
+	AggregateBatches(2, outDir)
+	assert.NotPanics(func() { VerifyFull(2, outDir) })
+	for i := 0; i < 2; i++ {
+		assert.Equal(readRoundMetadata(outDir).RoundId, readBottomLevelProof(outDir, i).RoundId)
+	}
+
+	// a batch changed after it was proven is not aggregated
+	batch := ReadDataFromFile[ProofElements](outDir + SECRET_DATA_PREFIX + "0.json")
+	batch.Accounts = batch.Accounts[1:]
+	batch.AssetSum = nil
+	WriteDataToFile(outDir+SECRET_DATA_PREFIX+"0.json", batch)
+	assert.Panics(func() { AggregateBatches(2, outDir) })
+}
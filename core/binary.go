@@ -0,0 +1,340 @@
+package core
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+
+	"bitgo.com/proof_of_reserves/circuit"
+)
+
+// The binary format of a CompletedProof, written by WriteDataToFile and read by ReadDataFromFile for files with the
+// PROOF_BINARY_EXTENSION, is PROOF_BINARY_MAGIC, the format version as a uvarint, and the fields of the proof in
+// declaration order. The base64 encoded proof and verification key are stored decoded, in three quarters of their JSON
+// size, and hashes (e.g. merkle nodes) take their raw bytes instead of a base64 string:
+//   - byte slices and strings are their length plus one as a uvarint (0 for a nil slice), followed by their bytes;
+//   - slices of hashes and integers are their length plus one as a uvarint (0 for a nil slice), followed by their
+//     elements;
+//   - integers are varints, and pointers are a presence byte followed by the value if present;
+//   - the asset sum is a pointer to a slice of big integers, each a byte slice of its gob encoding.
+//
+// The JSON format stays the format of published proofs, which must be readable by anyone.
+
+// isBinaryProofFile reports whether filePath is a proof in the binary format, from its extension (before the
+// extension of its compression, if any, e.g. 'bottom_level_proof_0.bin.zst').
+func isBinaryProofFile(filePath string) bool {
+	return strings.HasSuffix(trimCompressionExtension(filePath), PROOF_BINARY_EXTENSION)
+}
+
+// writeCompletedProofBinary writes a CompletedProof in the binary format, through a buffer so memory stays bounded for
+// proofs of deep trees, like writeCompletedProofStreaming.
+func writeCompletedProofBinary(filePath string, proof CompletedProof) error {
+	return writeFile(filePath, func(w io.Writer) error {
+		writer := bufio.NewWriterSize(w, STREAMING_WRITE_BUFFER_SIZE)
+		if err := encodeCompletedProofBinary(writer, proof); err != nil {
+			return err
+		}
+		return writer.Flush()
+	})
+}
+
+// readCompletedProofBinary reads a CompletedProof in the binary format.
+func readCompletedProofBinary(filePath string) (CompletedProof, error) {
+	file, err := openFile(filePath)
+	if err != nil {
+		return CompletedProof{}, err
+	}
+	defer func(file io.ReadCloser) {
+		err := file.Close()
+		if err != nil {
+			panic("Error closing file: " + err.Error())
+		}
+	}(file)
+	return decodeCompletedProofBinary(bufio.NewReader(file))
+}
+
+// encodeCompletedProofBinary encodes a CompletedProof in the binary format to the writer.
+func encodeCompletedProofBinary(w io.Writer, proof CompletedProof) error {
+	proofBytes, err := base64.StdEncoding.Strict().DecodeString(proof.Proof)
+	if err != nil {
+		return fmt.Errorf("proof is not base64 encoded: %w", err)
+	}
+	vkBytes, err := base64.StdEncoding.Strict().DecodeString(proof.VerificationKey)
+	if err != nil {
+		return fmt.Errorf("verification key is not base64 encoded: %w", err)
+	}
+
+	e := binaryEncoder{w: w}
+	e.raw([]byte(PROOF_BINARY_MAGIC))
+	e.uvarint(PROOF_BINARY_VERSION)
+	e.bytes(proofBytes, proof.Proof == "")
+	e.bytes(vkBytes, proof.VerificationKey == "")
+	e.bytes(proof.MerkleRoot, proof.MerkleRoot == nil)
+	e.bytes(proof.MerkleRootWithAssetSumHash, proof.MerkleRootWithAssetSumHash == nil)
+	e.hashes(proof.MerklePath)
+	e.varint(int64(proof.MerklePosition))
+	e.length(len(proof.MerkleNodes), proof.MerkleNodes == nil)
+	for _, level := range proof.MerkleNodes {
+		e.hashes(level)
+	}
+	e.present(proof.AssetSum != nil)
+	if proof.AssetSum != nil {
+		e.length(len(*proof.AssetSum), *proof.AssetSum == nil)
+		for _, balance := range *proof.AssetSum {
+			encodedBalance, err := balance.GobEncode()
+			if err != nil {
+				return fmt.Errorf("error encoding asset sum: %w", err)
+			}
+			e.bytes(encodedBalance, false)
+		}
+	}
+	e.string(proof.RoundId)
+	e.string(string(proof.HashFunction))
+	e.varint(int64(proof.HashVersion))
+	e.varint(int64(proof.AccountBytesVersion))
+	e.string(string(proof.LeafHashMode))
+	e.string(string(proof.Backend))
+	e.varint(int64(proof.TreeDepth))
+	e.varint(int64(proof.BalanceBits))
+	e.varint(int64(proof.AssetSumBits))
+	e.string(proof.AssetRegistryHash)
+	e.length(len(proof.AssetSubset), proof.AssetSubset == nil)
+	for _, asset := range proof.AssetSubset {
+		e.varint(int64(asset))
+	}
+	e.present(proof.AccountCount != nil)
+	if proof.AccountCount != nil {
+		e.varint(int64(*proof.AccountCount))
+	}
+	e.present(proof.Epoch != nil)
+	if proof.Epoch != nil {
+		e.uvarint(*proof.Epoch)
+	}
+	e.varint(int64(proof.CircuitVersion))
+	e.string(proof.VerificationKeyFingerprint)
+	return e.err
+}
+
+// decodeCompletedProofBinary decodes a CompletedProof in the binary format from the reader.
+func decodeCompletedProofBinary(r io.ByteReader) (CompletedProof, error) {
+	d := binaryDecoder{r: r}
+	if magic := d.raw(len(PROOF_BINARY_MAGIC)); d.err == nil && string(magic) != PROOF_BINARY_MAGIC {
+		return CompletedProof{}, errors.New("not a binary proof file")
+	}
+	if version := d.uvarint(); d.err == nil && version != PROOF_BINARY_VERSION {
+		return CompletedProof{}, fmt.Errorf("unsupported binary proof format version %d, expected %d", version, PROOF_BINARY_VERSION)
+	}
+
+	var proof CompletedProof
+	if proofBytes := d.bytes(); proofBytes != nil {
+		proof.Proof = base64.StdEncoding.EncodeToString(proofBytes)
+	}
+	if vkBytes := d.bytes(); vkBytes != nil {
+		proof.VerificationKey = base64.StdEncoding.EncodeToString(vkBytes)
+	}
+	proof.MerkleRoot = d.bytes()
+	proof.MerkleRootWithAssetSumHash = d.bytes()
+	proof.MerklePath = d.hashes()
+	proof.MerklePosition = int(d.varint())
+	if levels, ok := d.length(); ok {
+		proof.MerkleNodes = make([][]Hash, 0, min(levels, BINARY_DECODE_PREALLOCATION))
+		for ; levels > 0 && d.err == nil; levels-- {
+			proof.MerkleNodes = append(proof.MerkleNodes, d.hashes())
+		}
+	}
+	if d.present() {
+		var assetSum circuit.GoBalance
+		if assets, ok := d.length(); ok {
+			assetSum = make(circuit.GoBalance, 0, min(assets, BINARY_DECODE_PREALLOCATION))
+			for ; assets > 0 && d.err == nil; assets-- {
+				balance := new(big.Int)
+				if err := balance.GobDecode(d.bytes()); err != nil && d.err == nil {
+					d.err = fmt.Errorf("error decoding asset sum: %w", err)
+				}
+				assetSum = append(assetSum, balance)
+			}
+		}
+		proof.AssetSum = &assetSum
+	}
+	proof.RoundId = d.string()
+	proof.HashFunction = circuit.HashFunction(d.string())
+	proof.HashVersion = circuit.HashVersion(d.varint())
+	proof.AccountBytesVersion = circuit.AccountBytesVersion(d.varint())
+	proof.LeafHashMode = circuit.LeafHashMode(d.string())
+	proof.Backend = ProofBackend(d.string())
+	proof.TreeDepth = int(d.varint())
+	proof.BalanceBits = int(d.varint())
+	proof.AssetSumBits = int(d.varint())
+	proof.AssetRegistryHash = d.string()
+	if assets, ok := d.length(); ok {
+		proof.AssetSubset = make([]int, 0, min(assets, BINARY_DECODE_PREALLOCATION))
+		for ; assets > 0 && d.err == nil; assets-- {
+			proof.AssetSubset = append(proof.AssetSubset, int(d.varint()))
+		}
+	}
+	if d.present() {
+		accountCount := int(d.varint())
+		proof.AccountCount = &accountCount
+	}
+	if d.present() {
+		epoch := d.uvarint()
+		proof.Epoch = &epoch
+	}
+	proof.CircuitVersion = int(d.varint())
+	proof.VerificationKeyFingerprint = d.string()
+	if d.err != nil {
+		return CompletedProof{}, fmt.Errorf("error decoding binary proof: %w", d.err)
+	}
+	return proof, nil
+}
+
+// binaryEncoder writes the primitives of the binary format, keeping the first write error.
+type binaryEncoder struct {
+	w   io.Writer
+	err error
+	buf [binary.MaxVarintLen64]byte
+}
+
+func (e *binaryEncoder) raw(data []byte) {
+	if e.err == nil {
+		_, e.err = e.w.Write(data)
+	}
+}
+
+func (e *binaryEncoder) uvarint(value uint64) {
+	e.raw(binary.AppendUvarint(e.buf[:0], value))
+}
+
+func (e *binaryEncoder) varint(value int64) {
+	e.raw(binary.AppendVarint(e.buf[:0], value))
+}
+
+// length writes the length of a slice plus one, or 0 for a nil slice.
+func (e *binaryEncoder) length(length int, isNil bool) {
+	if isNil {
+		e.uvarint(0)
+		return
+	}
+	e.uvarint(uint64(length) + 1)
+}
+
+func (e *binaryEncoder) bytes(data []byte, isNil bool) {
+	e.length(len(data), isNil)
+	e.raw(data)
+}
+
+func (e *binaryEncoder) string(value string) {
+	e.bytes([]byte(value), false)
+}
+
+func (e *binaryEncoder) hashes(hashes []Hash) {
+	e.length(len(hashes), hashes == nil)
+	for _, hash := range hashes {
+		e.bytes(hash, hash == nil)
+	}
+}
+
+func (e *binaryEncoder) present(present bool) {
+	if present {
+		e.raw([]byte{1})
+	} else {
+		e.raw([]byte{0})
+	}
+}
+
+// binaryDecoder reads the primitives of the binary format, keeping the first read error, after which it returns
+// zero values. Slices grow as their elements are read, so a corrupted length fails at the end of the file instead of
+// allocating the memory it claims.
+type binaryDecoder struct {
+	r   io.ByteReader
+	err error
+}
+
+func (d *binaryDecoder) raw(length int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	data := make([]byte, 0, min(length, BINARY_DECODE_PREALLOCATION))
+	for range length {
+		b, err := d.r.ReadByte()
+		if err != nil {
+			d.err = unexpectedEOF(err)
+			return nil
+		}
+		data = append(data, b)
+	}
+	return data
+}
+
+func (d *binaryDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	value, err := binary.ReadUvarint(d.r)
+	d.err = unexpectedEOF(err)
+	return value
+}
+
+func (d *binaryDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	value, err := binary.ReadVarint(d.r)
+	d.err = unexpectedEOF(err)
+	return value
+}
+
+// length reads the length of a slice, which is not ok for a nil slice.
+func (d *binaryDecoder) length() (int, bool) {
+	length := d.uvarint()
+	if length == 0 || d.err != nil {
+		return 0, false
+	}
+	return int(length - 1), true
+}
+
+func (d *binaryDecoder) bytes() []byte {
+	length, ok := d.length()
+	if !ok {
+		return nil
+	}
+	data := d.raw(length)
+	if data == nil && d.err == nil {
+		data = []byte{}
+	}
+	return data
+}
+
+func (d *binaryDecoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *binaryDecoder) hashes() []Hash {
+	length, ok := d.length()
+	if !ok {
+		return nil
+	}
+	hashes := make([]Hash, 0, min(length, BINARY_DECODE_PREALLOCATION))
+	for ; length > 0 && d.err == nil; length-- {
+		hashes = append(hashes, d.bytes())
+	}
+	return hashes
+}
+
+func (d *binaryDecoder) present() bool {
+	data := d.raw(1)
+	return len(data) == 1 && data[0] != 0
+}
+
+// unexpectedEOF reports the end of the file in the middle of a proof as io.ErrUnexpectedEOF.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,46 @@
+package core
+
+import (
+	"os"
+	"testing"
+
+	"github.com/consensys/gnark/test"
+)
+
+func TestCompletedProofBinaryFormat(t *testing.T) {
+	assert := test.NewAssert(t)
+	dir := t.TempDir() + "/"
+
+	// proofs read back from the binary format are the proofs written, with their optional fields
+	assert.True(len(proofLower0.MerkleNodes) > 0)
+	for name, proof := range map[string]CompletedProof{"bottom": proofLower0, "mid": proofMid, "top": proofTop, "empty": {}} {
+		WriteDataToFile(dir+name+PROOF_BINARY_EXTENSION, proof)
+		assert.Equal(proof, ReadDataFromFile[CompletedProof](dir+name+PROOF_BINARY_EXTENSION), name)
+	}
+	assert.NoError(verifyProof(ReadDataFromFile[CompletedProof](dir+"top"+PROOF_BINARY_EXTENSION), topLevelLayer.name))
+
+	// the binary format is smaller than the JSON one
+	WriteDataToFile(dir+"bottom.json", proofLower0)
+	jsonInfo, err := os.Stat(dir + "bottom.json")
+	assert.NoError(err)
+	binaryInfo, err := os.Stat(dir + "bottom" + PROOF_BINARY_EXTENSION)
+	assert.NoError(err)
+	assert.True(binaryInfo.Size() < jsonInfo.Size()*3/4, "expected the binary proof to be smaller")
+
+	// truncated files, other files, and other versions of the format are rejected
+	encoded, err := os.ReadFile(dir + "bottom" + PROOF_BINARY_EXTENSION)
+	assert.NoError(err)
+	assert.NoError(os.WriteFile(dir+"truncated"+PROOF_BINARY_EXTENSION, encoded[:len(encoded)/2], 0o644))
+	_, err = readCompletedProofBinary(dir + "truncated" + PROOF_BINARY_EXTENSION)
+	assert.Error(err)
+	_, err = readCompletedProofBinary(dir + "bottom.json")
+	assert.Error(err)
+	encoded[len(PROOF_BINARY_MAGIC)] = PROOF_BINARY_VERSION + 1
+	assert.NoError(os.WriteFile(dir+"future"+PROOF_BINARY_EXTENSION, encoded, 0o644))
+	_, err = readCompletedProofBinary(dir + "future" + PROOF_BINARY_EXTENSION)
+	assert.Error(err)
+
+	// only proofs have a binary format
+	assert.Panics(func() { WriteDataToFile(dir+"elements"+PROOF_BINARY_EXTENSION, testData0) })
+	assert.Panics(func() { ReadDataFromFile[ProofElements](dir + "bottom" + PROOF_BINARY_EXTENSION) })
+}
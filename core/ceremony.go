@@ -0,0 +1,237 @@
+package core
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	"github.com/consensys/gnark-crypto/ecc"
+	curve "github.com/consensys/gnark-crypto/ecc/bn254"
+	"github.com/consensys/gnark/backend/groth16/bn254/mpcsetup"
+	cs "github.com/consensys/gnark/constraint/bn254"
+)
+
+// InitCeremony starts an MPC setup ceremony for the keys of the circuit for accountCount accounts (or, if upperLevel is
+// set, of the upper level circuit for accountCount lower level proofs, see circuit.Config.ForUpperLevel), with the
+// configured hash function, hash version, leaf hash mode, circuit configuration, and asset registry, so the toxic
+// waste of its Groth16 setup is only known if every participant colludes. The phase 1 parameters (the powers of tau of a public
+// ceremony, in the binary format of gnark's mpcsetup.Phase1) are read from phase1Path and truncated to the size of the
+// circuit, and the phase 2 parameters derived from them and from the circuit are written to ceremonyDir with its
+// transcript. Each participant then runs ContributeToCeremony in turn on the directory, and ImportCeremony validates
+// the contributions and writes the keys to a key directory. Only the Groth16 backend has MPC setups, and the circuit
+// must have plain range checks (see circuit.Config.PlainRangeChecks), as the keys of a ceremony can't commit.
+func InitCeremony(accountCount int, upperLevel bool, phase1Path string, ceremonyDir string) (CeremonyTranscript, error) {
+	key := newCircuitKey(accountCount)
+	if upperLevel {
+		key = newUpperLevelCircuitKey(accountCount)
+	}
+	r1cs, err := compileCeremonyCircuit(key)
+	if err != nil {
+		return CeremonyTranscript{}, err
+	}
+	phase1, err := readCeremonyPhase1(phase1Path, r1cs)
+	if err != nil {
+		return CeremonyTranscript{}, err
+	}
+	phase2, _ := mpcsetup.InitPhase2(r1cs, &phase1)
+
+	if err := os.MkdirAll(ceremonyDir, 0o755); err != nil {
+		return CeremonyTranscript{}, err
+	}
+	transcript := CeremonyTranscript{
+		CircuitId:    setupCircuitId(key),
+		AccountCount: accountCount,
+		UpperLevel:   upperLevel,
+		Phase1:       CEREMONY_PHASE1_FILE,
+		Phase2:       []string{ceremonyPhase2File(0)},
+	}
+	if err := writeCeremonyParameters(filepath.Join(ceremonyDir, transcript.Phase1), &phase1); err != nil {
+		return CeremonyTranscript{}, err
+	}
+	if err := writeCeremonyParameters(filepath.Join(ceremonyDir, transcript.Phase2[0]), &phase2); err != nil {
+		return CeremonyTranscript{}, err
+	}
+	if err := writeJson(filepath.Join(ceremonyDir, CEREMONY_TRANSCRIPT_FILE), transcript); err != nil {
+		return CeremonyTranscript{}, err
+	}
+	return transcript, nil
+}
+
+// ContributeToCeremony adds a contribution of fresh randomness to the last phase 2 parameters of the ceremony in
+// ceremonyDir, writing them to a new file of the transcript, and returns the hex encoded hash of the contribution,
+// which the participant can check the imported keys include (see SetupCeremony). The randomness is discarded once the
+// contribution is written.
+func ContributeToCeremony(ceremonyDir string) (string, error) {
+	transcript, err := readCeremonyTranscript(ceremonyDir)
+	if err != nil {
+		return "", err
+	}
+	var phase2 mpcsetup.Phase2
+	if err := readCeremonyParameters(filepath.Join(ceremonyDir, transcript.Phase2[len(transcript.Phase2)-1]), &phase2); err != nil {
+		return "", err
+	}
+	phase2.Contribute()
+
+	file := ceremonyPhase2File(len(transcript.Phase2))
+	if err := writeCeremonyParameters(filepath.Join(ceremonyDir, file), &phase2); err != nil {
+		return "", err
+	}
+	transcript.Phase2 = append(transcript.Phase2, file)
+	if err := writeJson(filepath.Join(ceremonyDir, CEREMONY_TRANSCRIPT_FILE), transcript); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(phase2.Hash), nil
+}
+
+// ImportCeremony validates the ceremony in ceremonyDir against the circuit compiled with the current configuration,
+// and writes the keys it produced to keyDir, like Setup, so proofs are generated with them (see SetSetupKeyDir). The
+// initial phase 2 parameters must be those derived from the phase 1 parameters and the compiled circuit, and each
+// contribution must be a valid update of the parameters before it, so a transcript of another circuit, or with a
+// tampered contribution, is rejected. The ceremony must have at least one contribution. The phase 1 parameters are
+// trusted as they are: they must be the outcome of a public powers of tau ceremony.
+func ImportCeremony(ceremonyDir string, keyDir string) (SetupCircuit, error) {
+	transcript, err := readCeremonyTranscript(ceremonyDir)
+	if err != nil {
+		return SetupCircuit{}, err
+	}
+	if len(transcript.Phase2) < 2 {
+		return SetupCircuit{}, errors.New("the ceremony has no contributions")
+	}
+	key := newCircuitKey(transcript.AccountCount)
+	if transcript.UpperLevel {
+		key = newUpperLevelCircuitKey(transcript.AccountCount)
+	}
+	if transcript.CircuitId != setupCircuitId(key) {
+		return SetupCircuit{}, fmt.Errorf("the ceremony is for another circuit of %d accounts than the one of the current configuration", transcript.AccountCount)
+	}
+	manifest, err := openSetupManifest(keyDir, key.backend)
+	if err != nil {
+		return SetupCircuit{}, err
+	}
+
+	r1cs, err := compileCeremonyCircuit(key)
+	if err != nil {
+		return SetupCircuit{}, err
+	}
+	phase1, err := readCeremonyPhase1(filepath.Join(ceremonyDir, transcript.Phase1), r1cs)
+	if err != nil {
+		return SetupCircuit{}, err
+	}
+	derived, evaluations := mpcsetup.InitPhase2(r1cs, &phase1)
+	phase2 := make([]*mpcsetup.Phase2, len(transcript.Phase2))
+	for i, file := range transcript.Phase2 {
+		phase2[i] = new(mpcsetup.Phase2)
+		if err := readCeremonyParameters(filepath.Join(ceremonyDir, file), phase2[i]); err != nil {
+			return SetupCircuit{}, err
+		}
+	}
+	// the initial parameters have a random public key, which the first contribution is bound to, so they are compared
+	// with the parameters derived again rather than replaced by them
+	if !sameCeremonyParameters(phase2[0], &derived) {
+		return SetupCircuit{}, errors.New("the initial phase 2 parameters of the ceremony are not those of the circuit")
+	}
+	contributions := phase2[1:]
+	ceremony := SetupCeremony{Phase1Hash: hex.EncodeToString(phase1.Hash)}
+	for _, contribution := range contributions {
+		ceremony.ContributionHashes = append(ceremony.ContributionHashes, hex.EncodeToString(contribution.Hash))
+	}
+	if err := mpcsetup.VerifyPhase2(phase2[0], contributions[0], contributions[1:]...); err != nil {
+		return SetupCircuit{}, fmt.Errorf("invalid ceremony contribution: %w", err)
+	}
+
+	pk, vk := mpcsetup.ExtractKeys(&phase1, contributions[len(contributions)-1], &evaluations, r1cs.GetNbConstraints())
+	setupCircuit, err := writeSetupKeys(key, r1cs, &pk, &vk, keyDir)
+	if err != nil {
+		return SetupCircuit{}, err
+	}
+	setupCircuit.Ceremony = &ceremony
+	manifest.addCircuit(setupCircuit)
+	if err := writeJson(filepath.Join(keyDir, SETUP_MANIFEST_FILE), manifest); err != nil {
+		return SetupCircuit{}, err
+	}
+	return setupCircuit, nil
+}
+
+// compileCeremonyCircuit compiles the circuit of the key into the R1CS of an MPC setup ceremony.
+func compileCeremonyCircuit(key circuitKey) (*cs.R1CS, error) {
+	if key.backend != BACKEND_GROTH16 {
+		return nil, fmt.Errorf("MPC setup ceremonies are only supported with the %s backend, not %s", BACKEND_GROTH16, key.backend)
+	}
+	baseCircuit := newBaseCircuit(key.accountCount, key.config)
+	compiled, err := compileCircuit(key.backend, &baseCircuit)
+	if err != nil {
+		return nil, err
+	}
+	r1cs := compiled.(*cs.R1CS)
+	if len(r1cs.CommitmentInfo.CommitmentIndexes()) > 0 {
+		return nil, errors.New("the circuit commits to its range checks, which the keys of a ceremony can't do (use plain range checks)")
+	}
+	return r1cs, nil
+}
+
+// sameCeremonyParameters returns whether the phase 2 parameters a and b are the same, whatever their public keys.
+func sameCeremonyParameters(a, b *mpcsetup.Phase2) bool {
+	equal := func(p, q curve.G1Affine) bool { return p.Equal(&q) }
+	return a.Parameters.G1.Delta.Equal(&b.Parameters.G1.Delta) && a.Parameters.G2.Delta.Equal(&b.Parameters.G2.Delta) &&
+		slices.EqualFunc(a.Parameters.G1.L, b.Parameters.G1.L, equal) && slices.EqualFunc(a.Parameters.G1.Z, b.Parameters.G1.Z, equal)
+}
+
+// readCeremonyPhase1 reads the phase 1 parameters at filePath, truncated to the size of the domain of the R1CS.
+func readCeremonyPhase1(filePath string, r1cs *cs.R1CS) (mpcsetup.Phase1, error) {
+	var phase1 mpcsetup.Phase1
+	if err := readCeremonyParameters(filePath, &phase1); err != nil {
+		return mpcsetup.Phase1{}, err
+	}
+	size := int(ecc.NextPowerOfTwo(uint64(r1cs.GetNbConstraints())))
+	parameters := &phase1.Parameters
+	if len(parameters.G2.Tau) < size || len(parameters.G1.AlphaTau) < size || len(parameters.G1.BetaTau) < size || len(parameters.G1.Tau) < 2*size-1 {
+		return mpcsetup.Phase1{}, fmt.Errorf("the phase 1 parameters have %d powers of tau, but the circuit needs %d", len(parameters.G2.Tau), size)
+	}
+	parameters.G1.Tau = parameters.G1.Tau[:2*size-1]
+	parameters.G1.AlphaTau = parameters.G1.AlphaTau[:size]
+	parameters.G1.BetaTau = parameters.G1.BetaTau[:size]
+	parameters.G2.Tau = parameters.G2.Tau[:size]
+	return phase1, nil
+}
+
+// readCeremonyTranscript reads the transcript of the ceremony in ceremonyDir.
+func readCeremonyTranscript(ceremonyDir string) (CeremonyTranscript, error) {
+	var transcript CeremonyTranscript
+	if err := readJson(filepath.Join(ceremonyDir, CEREMONY_TRANSCRIPT_FILE), &transcript); err != nil {
+		return CeremonyTranscript{}, fmt.Errorf("error reading ceremony transcript: %w", err)
+	}
+	if len(transcript.Phase2) == 0 {
+		return CeremonyTranscript{}, errors.New("the ceremony transcript has no phase 2 parameters")
+	}
+	return transcript, nil
+}
+
+// ceremonyPhase2File returns the name of the file of the phase 2 parameters after the given number of contributions.
+func ceremonyPhase2File(contributions int) string {
+	return CEREMONY_PHASE2_PREFIX + strconv.Itoa(contributions) + ".bin"
+}
+
+func writeCeremonyParameters(filePath string, parameters io.WriterTo) error {
+	return writeFile(filePath, func(w io.Writer) error {
+		_, err := parameters.WriteTo(w)
+		return err
+	})
+}
+
+func readCeremonyParameters(filePath string, parameters io.ReaderFrom) error {
+	// the parameters are read from memory, as they end with a hash read with a single Read
+	data, err := readFile(filePath)
+	if err != nil {
+		return err
+	}
+	if _, err := parameters.ReadFrom(bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("error reading %s: %w", filepath.Base(filePath), err)
+	}
+	return nil
+}
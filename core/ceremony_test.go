@@ -0,0 +1,90 @@
+package core
+
+import (
+	"math/bits"
+	"path/filepath"
+	"testing"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/groth16/bn254/mpcsetup"
+	"github.com/consensys/gnark/test"
+)
+
+func TestImportCeremony(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs an MPC setup ceremony")
+	}
+	assert := test.NewAssert(t)
+	previousConfig := circuit.GetConfig()
+	defer circuit.SetConfig(previousConfig)
+	defer circuit.SetAssetSubset(nil)
+	defaultCache := GetCircuitCache()
+	defer SetCircuitCache(defaultCache)
+	// the smallest circuit: of a tree depth of 1, and of a single asset
+	assert.NoError(circuit.SetConfig(circuit.Config{TreeDepth: 1, PlainRangeChecks: true}))
+	assert.NoError(circuit.SetAssetSubset([]int{0}))
+	dir := t.TempDir()
+	ceremonyDir, keyDir := filepath.Join(dir, "ceremony"), filepath.Join(dir, "keys")
+
+	// phase 1 parameters with a contribution, of the size of the circuit
+	r1cs, err := compileCeremonyCircuit(newCircuitKey(2))
+	assert.NoError(err)
+	phase1 := mpcsetup.InitPhase1(bits.Len64(ecc.NextPowerOfTwo(uint64(r1cs.GetNbConstraints()))) - 1)
+	phase1.Contribute()
+	phase1Path := filepath.Join(dir, "powers_of_tau.bin")
+	assert.NoError(writeCeremonyParameters(phase1Path, &phase1))
+
+	// the keys of a ceremony are only imported once it has contributions, and record them
+	_, err = InitCeremony(2, false, phase1Path, ceremonyDir)
+	assert.NoError(err)
+	_, err = ImportCeremony(ceremonyDir, keyDir)
+	assert.Error(err)
+	var hashes []string
+	for i := 0; i < 2; i++ {
+		hash, err := ContributeToCeremony(ceremonyDir)
+		assert.NoError(err)
+		hashes = append(hashes, hash)
+	}
+	setupCircuit, err := ImportCeremony(ceremonyDir, keyDir)
+	assert.NoError(err)
+	assert.Equal(hashes, setupCircuit.Ceremony.ContributionHashes)
+
+	// proofs are generated with the keys of the ceremony
+	cache, err := NewCircuitCache(0)
+	assert.NoError(err)
+	SetCircuitCache(cache)
+	assert.NoError(SetSetupKeyDir(keyDir))
+	defer SetSetupKeyDir("")
+	batches := []ProofElements{{Accounts: testData0.Accounts[:2]}}
+	applyAssetSubset(batches, circuit.GetAssetSubset())
+	elements := batches[0]
+	assetSum := circuit.SumGoAccountBalances(elements.Accounts)
+	elements.AssetSum = &assetSum
+	proof := generateProof(elements, nil)
+	assert.NoError(verifyGeneratedProof(proof, bottomLevelLayer.name))
+	fingerprint, err := ComputeVerificationKeyFingerprint(proof.VerificationKey)
+	assert.NoError(err)
+	assert.Equal(setupCircuit.VerificationKeyFingerprint, fingerprint)
+
+	// and with GPU proving, which converts the keys of the ceremony to the type of the ICICLE prover if it is available
+	SetGPUProving(true)
+	defer SetGPUProving(false)
+	assert.NoError(verifyGeneratedProof(generateProof(elements, nil), bottomLevelLayer.name))
+
+	// a transcript missing a contribution, or of another circuit, is rejected
+	transcript, err := readCeremonyTranscript(ceremonyDir)
+	assert.NoError(err)
+	transcript.Phase2 = []string{transcript.Phase2[0], transcript.Phase2[2]}
+	assert.NoError(writeJson(filepath.Join(ceremonyDir, CEREMONY_TRANSCRIPT_FILE), transcript))
+	_, err = ImportCeremony(ceremonyDir, keyDir)
+	assert.Error(err)
+	assert.NoError(circuit.SetConfig(circuit.Config{TreeDepth: 2, PlainRangeChecks: true}))
+	_, err = ImportCeremony(ceremonyDir, keyDir)
+	assert.Error(err)
+
+	// circuits committing to their range checks have no ceremonies
+	assert.NoError(circuit.SetConfig(circuit.Config{TreeDepth: 1}))
+	_, err = InitCeremony(2, false, phase1Path, t.TempDir())
+	assert.Error(err)
+}
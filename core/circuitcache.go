@@ -0,0 +1,133 @@
+package core
+
+import (
+	"container/list"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"bitgo.com/proof_of_reserves/circuit"
+)
+
+// CircuitCache caches the compiled and set up circuits (constraint systems and proving and verifying keys) of the
+// shapes proven, so that we do not need to recompile the same circuit repeatedly. It is safe for concurrent use (see
+// SetProofWorkers). Once it holds its capacity, the least recently used circuit is evicted to make room for a new
+// one. An evicted circuit is compiled and set up again when it is next needed, which, without a deterministic setup
+// (see SetDeterministicSetup), gives it new keys.
+type CircuitCache struct {
+	capacity int
+
+	mutex sync.Mutex
+	// order lists the cached circuits from the most to the least recently used, and entries indexes them by key
+	order   *list.List
+	entries map[circuitKey]*list.Element
+}
+
+// circuitCacheEntry is a cached circuit in CircuitCache.order.
+type circuitCacheEntry struct {
+	key   circuitKey
+	proof PartialProof
+}
+
+// NewCircuitCache returns an empty cache of at most capacity circuits (0 for no limit).
+func NewCircuitCache(capacity int) (*CircuitCache, error) {
+	if capacity < 0 {
+		return nil, fmt.Errorf("circuit cache capacity must not be negative, got %d", capacity)
+	}
+	return &CircuitCache{capacity: capacity, order: list.New(), entries: make(map[circuitKey]*list.Element)}, nil
+}
+
+// circuitCompileMutex makes circuits be compiled one at a time, so concurrent proofs of the same shape compile it once.
+var circuitCompileMutex sync.Mutex
+
+// SetCircuitCache sets the cache of the circuits used by the prover (a cache of DEFAULT_CIRCUIT_CACHE_CAPACITY circuits
+// by default), e.g. a cache of another capacity, or a cache shared with another prover run in the same process. It
+// must not be called while proofs are generated.
+func SetCircuitCache(cache *CircuitCache) {
+	updateSettings(func(s *settings) error {
+		s.circuitCache = cache
+		return nil
+	})
+}
+
+func GetCircuitCache() *CircuitCache {
+	return getSettings().circuitCache
+}
+
+// Capacity returns the number of circuits the cache holds at most (0 for no limit).
+func (cache *CircuitCache) Capacity() int {
+	return cache.capacity
+}
+
+// Len returns the number of circuits in the cache.
+func (cache *CircuitCache) Len() int {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	return cache.order.Len()
+}
+
+// Clear evicts every circuit from the cache.
+func (cache *CircuitCache) Clear() {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.order.Init()
+	clear(cache.entries)
+}
+
+// get returns the cached circuit of the key, marking it as the most recently used.
+func (cache *CircuitCache) get(key circuitKey) (PartialProof, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	element, ok := cache.entries[key]
+	if !ok {
+		return PartialProof{}, false
+	}
+	cache.order.MoveToFront(element)
+	return element.Value.(*circuitCacheEntry).proof, true
+}
+
+// add caches the circuit of the key as the most recently used, evicting the least recently used circuits beyond the
+// capacity of the cache.
+func (cache *CircuitCache) add(key circuitKey, proof PartialProof) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if element, ok := cache.entries[key]; ok {
+		element.Value.(*circuitCacheEntry).proof = proof
+		cache.order.MoveToFront(element)
+		return
+	}
+	cache.entries[key] = cache.order.PushFront(&circuitCacheEntry{key: key, proof: proof})
+	for cache.capacity > 0 && cache.order.Len() > cache.capacity {
+		evicted := cache.order.Remove(cache.order.Back()).(*circuitCacheEntry)
+		delete(cache.entries, evicted.key)
+		recordCircuitEvicted(evicted.key.accountCount)
+	}
+}
+
+// remove evicts the circuit of the key from the cache, if it is cached.
+func (cache *CircuitCache) remove(key circuitKey) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if element, ok := cache.entries[key]; ok {
+		cache.order.Remove(element)
+		delete(cache.entries, key)
+	}
+}
+
+// PrewarmCircuits compiles and sets up the bottom level circuits for each of the given account counts (e.g. the
+// accounts of a full batch and of the last batch) into the circuit cache, with the configured backend, hash function,
+// hash version, leaf hash mode, circuit configuration, asset registry, and setup seed, so their cost is paid before
+// proving starts and does not delay the first proofs. Circuits already cached are not compiled again. Upper level
+// proofs have circuits of their own (see circuit.Config.UpperLevel), which are compiled when first needed.
+func PrewarmCircuits(accountCounts ...int) error {
+	for _, accountCount := range accountCounts {
+		if accountCount < 1 || accountCount > circuit.GetAccountsPerBatch() {
+			return fmt.Errorf("account count must be between 1 and %d, got %d", circuit.GetAccountsPerBatch(), accountCount)
+		}
+	}
+	for _, accountCount := range accountCounts {
+		getCachedProof(newCircuitKey(accountCount), lowerLevelInputs{}, &ProofMetrics{})
+		slog.Debug("prewarmed circuit", "accounts", accountCount)
+	}
+	return nil
+}
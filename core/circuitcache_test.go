@@ -0,0 +1,94 @@
+package core
+
+import (
+	"testing"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"github.com/consensys/gnark/test"
+)
+
+func TestCircuitCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	assert := test.NewAssert(t)
+	_, err := NewCircuitCache(-1)
+	assert.Error(err)
+
+	// once full, the cache evicts the circuit used least recently
+	cache, err := NewCircuitCache(2)
+	assert.NoError(err)
+	ResetProverMetrics()
+	cache.add(newCircuitKey(1), PartialProof{})
+	cache.add(newCircuitKey(2), PartialProof{})
+	_, ok := cache.get(newCircuitKey(1))
+	assert.True(ok)
+	cache.add(newCircuitKey(3), PartialProof{})
+	assert.Equal(2, cache.Len())
+	_, ok = cache.get(newCircuitKey(2))
+	assert.False(ok, "the least recently used circuit should be evicted")
+	_, ok = cache.get(newCircuitKey(1))
+	assert.True(ok)
+	assert.Equal(1, GetProverMetrics().CacheEvictions)
+
+	// a cache without capacity keeps every circuit
+	unlimited, err := NewCircuitCache(0)
+	assert.NoError(err)
+	for i := 1; i <= 4; i++ {
+		unlimited.add(newCircuitKey(i), PartialProof{})
+	}
+	assert.Equal(4, unlimited.Len())
+	unlimited.Clear()
+	assert.Equal(0, unlimited.Len())
+}
+
+func TestSetCircuitCache(t *testing.T) {
+	assert := test.NewAssert(t)
+	defaultCache := GetCircuitCache()
+	defer SetCircuitCache(defaultCache)
+	assert.Equal(DEFAULT_CIRCUIT_CACHE_CAPACITY, defaultCache.Capacity())
+	defaultLen := defaultCache.Len()
+
+	// the prover compiles into the configured cache, and a prewarmed circuit is reused by the first proof
+	cache, err := NewCircuitCache(1)
+	assert.NoError(err)
+	SetCircuitCache(cache)
+	assert.NoError(PrewarmCircuits(2))
+	assert.Equal(1, cache.Len())
+	elements := ProofElements{Accounts: testData0.Accounts[:2]}
+	assetSum := circuit.SumGoAccountBalances(elements.Accounts)
+	elements.AssetSum = &assetSum
+	ResetProverMetrics()
+	assert.NoError(verifyGeneratedProof(generateProof(elements, nil), bottomLevelLayer.name))
+	assert.Equal(1, GetProverMetrics().CacheHits)
+	assert.Equal(0, GetProverMetrics().CacheMisses)
+
+	// a proof of another shape evicts it from a full cache
+	elements = ProofElements{Accounts: testData0.Accounts[:1]}
+	assetSum = circuit.SumGoAccountBalances(elements.Accounts)
+	elements.AssetSum = &assetSum
+	assert.NoError(verifyGeneratedProof(generateProof(elements, nil), bottomLevelLayer.name))
+	assert.Equal(1, GetProverMetrics().CacheEvictions)
+	_, ok := cache.get(newCircuitKey(2))
+	assert.False(ok)
+
+	assert.Equal(defaultLen, defaultCache.Len(), "the default cache should not be used")
+	assert.Error(PrewarmCircuits(0))
+	assert.Error(PrewarmCircuits(circuit.GetAccountsPerBatch() + 1))
+}
+
+func TestCircuitCacheKeysDeploymentDomain(t *testing.T) {
+	assert := test.NewAssert(t)
+	defer circuit.SetDeploymentDomain("")
+
+	// circuits of different deployment domains hash differently, so they are cached and set up apart
+	cache, err := NewCircuitCache(0)
+	assert.NoError(err)
+	circuit.SetDeploymentDomain("testnet")
+	testnetKey := newCircuitKey(2)
+	cache.add(testnetKey, PartialProof{})
+	circuit.SetDeploymentDomain("production")
+	_, ok := cache.get(newCircuitKey(2))
+	assert.False(ok, "a circuit of another deployment domain should not be reused")
+	assert.NotEqual(setupCircuitId(testnetKey), setupCircuitId(newCircuitKey(2)))
+	circuit.SetDeploymentDomain("testnet")
+	_, ok = cache.get(newCircuitKey(2))
+	assert.True(ok)
+}
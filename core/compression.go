@@ -0,0 +1,213 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression identifies how a file written by the prover is compressed. Files are compressed by the extension of
+// their path (see writeFile), and decompressed whatever their extension (see openFile).
+type Compression string
+
+const (
+	// COMPRESSION_NONE writes files as they are.
+	COMPRESSION_NONE Compression = "none"
+	// COMPRESSION_GZIP writes gzip files, with the COMPRESSION_GZIP_EXTENSION.
+	COMPRESSION_GZIP Compression = "gzip"
+	// COMPRESSION_ZSTD writes Zstandard files, with the COMPRESSION_ZSTD_EXTENSION, which are smaller than gzip files
+	// and faster to decompress, but need a Zstandard decoder.
+	COMPRESSION_ZSTD Compression = "zstd"
+)
+
+// ParseCompression returns the compression with the given name. The empty name is COMPRESSION_NONE.
+func ParseCompression(name string) (Compression, error) {
+	switch Compression(name) {
+	case "", COMPRESSION_NONE:
+		return COMPRESSION_NONE, nil
+	case COMPRESSION_GZIP, COMPRESSION_ZSTD:
+		return Compression(name), nil
+	}
+	return "", fmt.Errorf("unknown compression %q (expected %s, %s, or %s)", name, COMPRESSION_NONE, COMPRESSION_GZIP, COMPRESSION_ZSTD)
+}
+
+// Extension returns the extension appended to the path of the files compressed with the compression.
+func (compression Compression) Extension() string {
+	switch compression {
+	case COMPRESSION_GZIP:
+		return COMPRESSION_GZIP_EXTENSION
+	case COMPRESSION_ZSTD:
+		return COMPRESSION_ZSTD_EXTENSION
+	}
+	return ""
+}
+
+// compressionOfPath returns the compression of the files written to filePath, from its extension.
+func compressionOfPath(filePath string) Compression {
+	switch {
+	case strings.HasSuffix(filePath, COMPRESSION_GZIP_EXTENSION):
+		return COMPRESSION_GZIP
+	case strings.HasSuffix(filePath, COMPRESSION_ZSTD_EXTENSION):
+		return COMPRESSION_ZSTD
+	}
+	return COMPRESSION_NONE
+}
+
+// trimCompressionExtension returns filePath without the extension of its compression, e.g. to find the format of the
+// compressed file.
+func trimCompressionExtension(filePath string) string {
+	return strings.TrimSuffix(filePath, compressionOfPath(filePath).Extension())
+}
+
+// nopWriteCloser is a writer whose Close does nothing.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// newCompressingWriter returns a writer compressing what is written to w with the compression of filePath, from its
+// extension (e.g. 'bottom_level_proof_0.json.gz'), or writing it as it is. It must be closed to flush the compressed
+// data, which does not close w.
+func newCompressingWriter(w io.Writer, filePath string) (io.WriteCloser, error) {
+	switch compressionOfPath(filePath) {
+	case COMPRESSION_GZIP:
+		return gzip.NewWriter(w), nil
+	case COMPRESSION_ZSTD:
+		return zstd.NewWriter(w)
+	}
+	return nopWriteCloser{w}, nil
+}
+
+// decompressedFile closes the decompressing reader and the file it reads from.
+type decompressedFile struct {
+	io.Reader
+	close func() error
+}
+
+func (f decompressedFile) Close() error {
+	return f.close()
+}
+
+// openFile opens the file at filePath (in its storage if it is a URI, see storageOf), decompressing it if it is compressed, whatever its extension. If there is no
+// such file, the file compressed with the extension of a compression (e.g. 'bottom_level_proof_0.json.gz' for
+// 'bottom_level_proof_0.json') is opened instead, so rounds whose files were compressed after they were written (see
+// CompressFiles) are read like the others. Files of a round directory read during a run of the prover are recorded in
+// its audit log once closed (see beginProverAudit).
+func openFile(filePath string) (io.ReadCloser, error) {
+	file, err := openDecompressedFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return proverAuditLogOf(filePath).auditRead(filePath, file), nil
+}
+
+// openDecompressedFile opens the file at filePath, or the file compressed with the extension of a compression,
+// decompressing it (see openFile).
+func openDecompressedFile(filePath string) (io.ReadCloser, error) {
+	file, err := openStoredFile(filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		for _, compression := range []Compression{COMPRESSION_GZIP, COMPRESSION_ZSTD} {
+			if compressedFile, compressedErr := openStoredFile(filePath + compression.Extension()); compressedErr == nil {
+				file, err = compressedFile, nil
+				break
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(file)
+	magic, _ := reader.Peek(len(ZSTD_MAGIC))
+	switch {
+	case bytes.HasPrefix(magic, []byte(GZIP_MAGIC)):
+		gzipReader, err := gzip.NewReader(reader)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return decompressedFile{gzipReader, func() error { return errors.Join(gzipReader.Close(), file.Close()) }}, nil
+	case bytes.HasPrefix(magic, []byte(ZSTD_MAGIC)):
+		zstdReader, err := zstd.NewReader(reader)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return decompressedFile{zstdReader, func() error { zstdReader.Close(); return file.Close() }}, nil
+	}
+	return decompressedFile{reader, file.Close}, nil
+}
+
+// readFile returns the decompressed contents of the file at filePath (see openFile).
+func readFile(filePath string) ([]byte, error) {
+	file, err := openFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(file)
+	return data, errors.Join(err, file.Close())
+}
+
+// fileExists reports whether the file at filePath exists, or compressed with the extension of a compression (see
+// openFile).
+func fileExists(filePath string) bool {
+	for _, compression := range []Compression{COMPRESSION_NONE, COMPRESSION_GZIP, COMPRESSION_ZSTD} {
+		if storedFileExists(filePath + compression.Extension()) {
+			return true
+		}
+	}
+	return false
+}
+
+// CompressFiles compresses every JSON file in directory and its subdirectories (e.g. 'out/public', to shrink the
+// files hosted for download), replacing each with a file with the extension of the compression. The files are still
+// read, and their digests and signatures still verified, as the uncompressed files they were written as. Returns the
+// number of files compressed.
+func CompressFiles(directory string, compression Compression) (int, error) {
+	if compression == COMPRESSION_NONE {
+		return 0, errors.New("a compression must be given")
+	}
+	var paths []string
+	err := filepath.WalkDir(directory, func(path string, entry fs.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() && strings.HasSuffix(path, ".json") {
+			paths = append(paths, path)
+		}
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, path := range paths {
+		if err := compressFile(path, path+compression.Extension()); err != nil {
+			return 0, fmt.Errorf("error compressing %s: %w", path, err)
+		}
+		if err := os.Remove(path); err != nil {
+			return 0, err
+		}
+	}
+	return len(paths), nil
+}
+
+// compressFile writes the contents of the file at path to compressedPath, compressed by its extension.
+func compressFile(path string, compressedPath string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return writeFile(compressedPath, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
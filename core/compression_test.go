@@ -0,0 +1,60 @@
+package core
+
+import (
+	"os"
+	"testing"
+
+	"github.com/consensys/gnark/test"
+)
+
+func TestCompressedFiles(t *testing.T) {
+	assert := test.NewAssert(t)
+	dir := t.TempDir() + "/"
+
+	// files are compressed by their extension, and read back whatever their extension
+	for _, name := range []string{"proof.json", "proof.json.gz", "proof.json.zst", "proof.bin.zst"} {
+		WriteDataToFile(dir+name, proofLower0)
+		assert.Equal(proofLower0, ReadDataFromFile[CompletedProof](dir+name), name)
+	}
+	WriteDataToFile(dir+"elements.json.gz", testData0)
+	assert.Equal(testData0, ReadDataFromFile[ProofElements](dir+"elements.json.gz"))
+	assert.NoError(os.Rename(dir+"proof.json.zst", dir+"renamed.json"))
+	assert.Equal(proofLower0, ReadDataFromFile[CompletedProof](dir+"renamed.json"))
+	jsonInfo, err := os.Stat(dir + "proof.json")
+	assert.NoError(err)
+	compressedInfo, err := os.Stat(dir + "proof.json.gz")
+	assert.NoError(err)
+	assert.True(compressedInfo.Size() < jsonInfo.Size()/2, "expected the compressed proof to be smaller")
+
+	// a missing file is read from its compressed copy
+	assert.NoError(os.Rename(dir+"proof.json.gz", dir+"moved.json.gz"))
+	assert.Equal(proofLower0, ReadDataFromFile[CompletedProof](dir+"moved.json"))
+	assert.True(fileExists(dir + "moved.json"))
+	assert.False(fileExists(dir + "missing.json"))
+
+	_, err = ParseCompression("brotli")
+	assert.Error(err)
+}
+
+func TestCompressFiles(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// a round whose public files are compressed after it is published verifies like the original, signatures included
+	for _, compression := range []Compression{COMPRESSION_GZIP, COMPRESSION_ZSTD} {
+		archiveDir := t.TempDir()
+		roundDir := copyPublicRound(t, testOutDir, archiveDir, string(compression))
+		count, err := CompressFiles(roundDir+"public", compression)
+		assert.NoError(err)
+		assert.True(count > 0)
+		_, err = os.Stat(roundDir + ROUND_METADATA_FILE)
+		assert.True(os.IsNotExist(err))
+		assert.True(fileExists(roundDir + ROUND_METADATA_FILE))
+
+		report := VerifyHistoricalRounds(archiveDir)
+		assert.True(report.Compatible, "expected the compressed round to verify: %+v", report.Rounds)
+		assert.Equal(batchCount, report.Rounds[0].BatchCount)
+	}
+
+	_, err := CompressFiles(t.TempDir(), COMPRESSION_NONE)
+	assert.Error(err)
+}
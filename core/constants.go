@@ -1,9 +1,128 @@
 package core
 
+import "bitgo.com/proof_of_reserves/verification"
+
 const (
 	OUT_DIR             = "out/"
 	SECRET_DATA_PREFIX  = "secret/batch_"
-	BOTTOM_PROOF_PREFIX = "public/bottom_level_proof_"
-	MIDDLE_PROOF_PREFIX = "public/mid_level_proof_"
-	TOP_PROOF_PREFIX    = "public/top_level_proof_"
+	BOTTOM_PROOF_PREFIX = verification.BOTTOM_PROOF_PREFIX
+	MIDDLE_PROOF_PREFIX = verification.MIDDLE_PROOF_PREFIX
+	TOP_PROOF_PREFIX    = verification.TOP_PROOF_PREFIX
+	EXCLUSION_LIST_FILE = "secret/exclusion_list.json"
+	ROUND_REPORT_FILE   = "secret/round_report.json"
+	ROUND_METADATA_FILE = verification.ROUND_METADATA_FILE
+	PROVENANCE_FILE     = "public/provenance.json"
+	MIGRATION_FILE      = "secret/tree_depth_migration.json"
+	USER_INDEX_FILE     = "secret/user_index.json"
+	PROVE_PROGRESS_FILE = "secret/prove_progress.json"
+	KEY_BUNDLE_FILE     = "public/key_bundle.json"
+	ROUND_MANIFEST_FILE = "public/manifest.json"
+	SIGNATURES_FILE     = "public/signatures.json"
+	VALIDATION_REPORT   = "secret/validation_report.json"
+	// SUCCINCT_ROUND_PROOF_FILE is the succinct proof of a round generated with BACKEND_GROTH16_RECURSIVE, see
+	// SuccinctRoundProof
+	SUCCINCT_ROUND_PROOF_FILE = "public/succinct_round_proof.json"
+
+	// STREAMING_WRITE_BUFFER_SIZE is the size of the buffer used when streaming large proofs to files.
+	STREAMING_WRITE_BUFFER_SIZE = 64 * 1024
+
+	// rough memory a proof needs per wire and per constraint of its circuit (see estimateProofMemory)
+	PROOF_MEMORY_BYTES_PER_WIRE       = 128
+	PROOF_MEMORY_BYTES_PER_CONSTRAINT = 384
+
+	// number of compiled circuits the default circuit cache holds (see SetCircuitCache): enough for the full and partial
+	// batches of every layer of a round, with room for the rounds of other configurations
+	DEFAULT_CIRCUIT_CACHE_CAPACITY = 32
+
+	// binary format of proofs (see writeCompletedProofBinary), used for files with PROOF_BINARY_EXTENSION instead of
+	// JSON; PROOF_BINARY_VERSION is incremented whenever the format changes
+	PROOF_BINARY_EXTENSION = ".bin"
+	PROOF_BINARY_MAGIC     = "PORP"
+	PROOF_BINARY_VERSION   = 1
+	// number of elements preallocated for a slice read from a binary proof, whatever length it claims
+	BINARY_DECODE_PREALLOCATION = 4096
+
+	// files of the key directory written by Setup
+	SETUP_MANIFEST_FILE           = "setup_manifest.json"
+	SETUP_PROVING_KEY_EXTENSION   = ".pk"
+	SETUP_VERIFYING_KEY_EXTENSION = ".vk"
+
+	// files of the directory of an MPC setup ceremony, see InitCeremony
+	CEREMONY_TRANSCRIPT_FILE = "ceremony.json"
+	CEREMONY_PHASE1_FILE     = "phase1.bin"
+	CEREMONY_PHASE2_PREFIX   = "phase2_"
+
+	// files are written to temporary files named after them with this pattern (see os.CreateTemp), which are renamed to
+	// them once complete, with the mode of files created with os.Create under the usual umask (see writeFile)
+	TEMP_FILE_PATTERN = ".tmp*"
+	WRITTEN_FILE_MODE = 0o644
+
+	// extensions and leading bytes of compressed files (see writeFile and openFile)
+	COMPRESSION_GZIP_EXTENSION = ".gz"
+	COMPRESSION_ZSTD_EXTENSION = ".zst"
+	GZIP_MAGIC                 = "\x1f\x8b"
+	ZSTD_MAGIC                 = "\x28\xb5\x2f\xfd"
+
+	// files written by ExportUserPackages
+	SHARD_DONE_MARKER_SUFFIX = ".done.json"
+	USER_EXPORT_MANIFEST     = "manifest.json"
+
+	// copies of the proofs kept in the secret directory (see PublicationPolicy)
+	SECRET_BOTTOM_PROOF_PREFIX = "secret/bottom_level_proof_"
+	SECRET_MIDDLE_PROOF_PREFIX = "secret/mid_level_proof_"
+	SECRET_TOP_PROOF_PREFIX    = "secret/top_level_proof_"
+
+	// metrics of the generated proofs, after the secret prefix and index of each proof (see Options.WriteProofMetrics)
+	PROOF_METRICS_SUFFIX = ".metrics.json"
+
+	// asset sum summaries of the proofs, after the public or secret prefix and index of each proof (see
+	// AssetSumDisclosure)
+	ASSET_SUM_SUMMARY_SUFFIX = ".asset_sums.json"
+
+	// proofs of the levels between the mid and top levels of rounds with more than MIN_HIERARCHY_LEVELS levels, which
+	// are followed by the level and "_level_proof_" (see IntermediateLevelProofPrefix)
+	INTERMEDIATE_PROOF_PREFIX        = verification.INTERMEDIATE_PROOF_PREFIX
+	SECRET_INTERMEDIATE_PROOF_PREFIX = "secret/mid"
+
+	// MIN_HIERARCHY_LEVELS is the number of levels of proofs of a round (bottom, mid, and top) unless more are needed
+	// or configured (see Options.HierarchyLevels).
+	MIN_HIERARCHY_LEVELS = verification.MIN_HIERARCHY_LEVELS
+
+	// verification keys the published proofs reference by fingerprint (see Options.PublishVerificationKeys)
+	VERIFICATION_KEYS_FILE = verification.VERIFICATION_KEYS_FILE
+
+	// dummy accounts the batches are padded with (see Options.DummyAccountPadding)
+	DUMMY_ACCOUNTS_PREFIX = "secret/dummy_accounts_"
+
+	// receipts issued to the teams exporting the batches
+	BATCH_RECEIPT_PREFIX = "secret/batch_receipt_"
+
+	// audit log of the stages of a round run by RunRound
+	ROUND_AUDIT_LOG_FILE = "secret/round_audit_log.jsonl"
+
+	// hash-chained audit log of the files read and written by the runs of the prover (see beginProverAudit)
+	PROVER_AUDIT_LOG_FILE = "audit.log"
+
+	// directory of a run of the prover in the secret directory, followed by the round id, where the previous versions of
+	// the files the run overwrites are kept until it ends, and the files written by the run are moved if it fails
+	RUN_DIRECTORY_PREFIX   = "secret/run_"
+	RUN_PREVIOUS_DIRECTORY = "previous/"
+	RUN_FAILED_DIRECTORY   = "failed/"
+
+	// URI schemes of the storages registered by default (see RegisterStorageScheme), and the defaults of their configuration
+	STORAGE_SCHEME_S3    = "s3"
+	STORAGE_SCHEME_GCS   = "gs"
+	STORAGE_SCHEME_HTTPS = "https"
+	S3_DEFAULT_REGION    = "us-east-1"
+	GCS_DEFAULT_ENDPOINT = "https://storage.googleapis.com"
+	GCS_SCOPE            = "https://www.googleapis.com/auth/devstorage.read_write"
+
+	// placeholder of the paths of a Layout replaced by the epoch of the round
+	LAYOUT_EPOCH_PLACEHOLDER = "{epoch}"
+
+	// provenance statements written by Prove
+	IN_TOTO_STATEMENT_TYPE      = "https://in-toto.io/Statement/v1"
+	SLSA_PROVENANCE_PREDICATE   = "https://slsa.dev/provenance/v1"
+	PROVENANCE_BUILD_TYPE       = "bitgo.com/proof_of_reserves/prove@v1"
+	PROVENANCE_DIGEST_ALGORITHM = "sha256"
 )
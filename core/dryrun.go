@@ -0,0 +1,209 @@
+package core
+
+import (
+	"fmt"
+	"math/big"
+
+	"bitgo.com/proof_of_reserves/circuit"
+)
+
+// DryRun makes a run of the prover validate the batches of the round instead of proving them (see ValidateRound): the
+// ValidationReport is written to the secret directory, and the run fails if a problem is found, in minutes rather than
+// after hours of proving. No proof is generated or written.
+func DryRun(settings *proverSettings) {
+	settings.dryRun = true
+}
+
+// proveDryRun validates the batches of the round in outDir (see ValidateRound), and writes the ValidationReport to
+// the secret directory. Panics if a problem is found.
+func proveDryRun(batchCount int, outDir string) {
+	report := ValidateRound(batchCount, outDir)
+	panicOnError(writeJson(outDir+VALIDATION_REPORT, report), "error writing validation report")
+	if !report.Valid {
+		panic(fmt.Sprintf("dry run failed: problems found in the batches, see %s", outDir+VALIDATION_REPORT))
+	}
+}
+
+// ValidateRound checks that the batchCount batches in outDir can be proven, without proving them: each batch is read
+// and prepared like it is by Prove (without dummy accounts, see Options.DummyAccountPadding), and its balance lengths, negative
+// and out of range balances, WalletIds (which must be encodable as field elements, and unique in the round), and asset
+// sum are checked, and its merkle root recomputed (and compared with the one it records, if any). The sum of the asset
+// sums of the batches must be in the range of the top level proof. Every problem is listed in the returned report,
+// rather than stopping at the first one.
+func ValidateRound(batchCount int, outDir string) ValidationReport {
+	report := ValidationReport{BatchCount: batchCount, Batches: make([]BatchValidation, batchCount)}
+	if err := NewLayerPlan(batchCount).Validate(); err != nil {
+		report.Problems = append(report.Problems, "invalid number of batches: "+err.Error())
+	}
+	if err := GetOptions().ProofPublicationPolicy.Validate(); err != nil {
+		report.Problems = append(report.Problems, "invalid publication policy: "+err.Error())
+	}
+	if err := validateProofBackendConfig(); err != nil {
+		report.Problems = append(report.Problems, "invalid configuration for the proof backend: "+err.Error())
+	}
+	exclusions, err := validateExclusionList(outDir)
+	if err != nil {
+		report.Problems = append(report.Problems, "invalid exclusion list: "+err.Error())
+	}
+
+	// the batches of the round are validated with the accounts they are proven with, and the WalletIds of every batch
+	// are kept to find those in several batches
+	batchOf := make(map[string]int)
+	roundSum := make(circuit.GoBalance, circuit.GetNumberOfAssets())
+	for i := range roundSum {
+		roundSum[i] = new(big.Int)
+	}
+	for i := range report.Batches {
+		elements, validation := validateBatch(i, outDir, exclusions)
+		report.Batches[i] = validation
+		report.AccountCount += len(elements.Accounts)
+		for j, account := range elements.Accounts {
+			if k, ok := batchOf[string(account.WalletId)]; ok && k != i {
+				report.Problems = append(report.Problems, fmt.Sprintf("account %d of batch %d has the WalletId of an account of batch %d", j, i, k))
+			}
+			batchOf[string(account.WalletId)] = i
+		}
+		if len(validation.Problems) == 0 {
+			for j, balance := range *elements.AssetSum {
+				roundSum[j].Add(roundSum[j], balance)
+			}
+		}
+	}
+
+	// the top level proof proves the sum of every batch, in the range of the asset sums of upper level proofs
+	for i, bits := range circuit.GetAssetBalanceBits(circuit.GetConfig().ForUpperLevel().GetAssetSumBits()) {
+		if roundSum[i].BitLen() > bits {
+			report.Problems = append(report.Problems, fmt.Sprintf("asset sum %s of %s exceeds the %d-bit range of the circuit", roundSum[i], circuit.GetAssetSymbols()[i], bits))
+		}
+	}
+	report.AssetSum = ConvertGoBalanceToRawUVBalances(roundSum)
+
+	report.Valid = len(report.Problems) == 0
+	for _, batch := range report.Batches {
+		report.Valid = report.Valid && len(batch.Problems) == 0
+	}
+	return report
+}
+
+// validateExclusionList reads the exclusion list of the round in outDir, or returns an error if it can't be read or a
+// WalletId in it can't be encoded.
+func validateExclusionList(outDir string) (exclusions []ExcludedAccount, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	exclusions = readExclusionList(outDir)
+	applyExclusionList(nil, 0, exclusions)
+	return exclusions, nil
+}
+
+// validateBatch reads the batch with the given index in outDir, prepares it to be proven like prepareBatches (without
+// dummy accounts), and validates it. Returns the prepared batch, with no accounts if it can't be read or prepared, and
+// its validation.
+func validateBatch(index int, outDir string, exclusions []ExcludedAccount) (ProofElements, BatchValidation) {
+	validation := BatchValidation{Batch: index}
+	report := func(format string, args ...any) {
+		validation.Problems = append(validation.Problems, fmt.Sprintf(format, args...))
+	}
+
+	elements, err := readBatchForValidation(outDir+GetOptions().OutputLayout.secretDataFile(index), report)
+	if err != nil {
+		report("%s", err.Error())
+		return ProofElements{}, validation
+	}
+	if err := prepareBatchForValidation(&elements, index, exclusions); err != nil {
+		report("%s", err.Error())
+		return ProofElements{}, validation
+	}
+	validation.AccountCount = len(elements.Accounts)
+
+	if elements.AssetSum == nil {
+		report("asset sum is missing")
+		assetSum := make(circuit.GoBalance, 0)
+		elements.AssetSum = &assetSum
+	}
+	if err := circuit.ValidateWitnessInputs(elements.Accounts, *elements.AssetSum); err != nil {
+		if errs, ok := err.(circuit.WitnessInputErrors); ok {
+			for _, inputErr := range errs {
+				report("%s", inputErr.Error())
+			}
+		} else {
+			report("%s", err.Error())
+		}
+		return elements, validation
+	}
+
+	merkleRoot, err := circuit.GoComputeMerkleRootFromAccountsWithError(elements.Accounts)
+	if err != nil {
+		report("error computing merkle root: %s", err.Error())
+		return elements, validation
+	}
+	validation.MerkleRoot = merkleRoot
+	if err := verifyProofElementsRoots(elements, merkleRoot); err != nil {
+		report("%s", err.Error())
+	}
+	return elements, validation
+}
+
+// readBatchForValidation reads the batch in the given file, reporting each account whose WalletId can't be encoded
+// with the WalletId encoding of the batch rather than failing on the first one. Returns an error if the file can't be
+// read.
+func readBatchForValidation(filePath string, report func(format string, args ...any)) (ProofElements, error) {
+	var rawElements RawProofElements
+	if err := readJson(filePath, &rawElements); err != nil {
+		return ProofElements{}, fmt.Errorf("error reading batch: %w", err)
+	}
+	encoding, err := circuit.ParseWalletIdEncoding(string(rawElements.WalletIdEncoding))
+	if rawElements.WalletIdEncoding == "" {
+		encoding, err = circuit.GetWalletIdEncoding(), nil
+	}
+	if err != nil {
+		return ProofElements{}, fmt.Errorf("invalid WalletId encoding: %w", err)
+	}
+
+	elements := ProofElements{
+		Accounts:                   make([]circuit.GoAccount, 0, len(rawElements.Accounts)),
+		AssetSum:                   rawElements.AssetSum,
+		MerkleRoot:                 rawElements.MerkleRoot,
+		MerkleRootWithAssetSumHash: rawElements.MerkleRootWithAssetSumHash,
+	}
+	for i, rawAccount := range rawElements.Accounts {
+		account, err := convertRawAccountForValidation(rawAccount, encoding)
+		if err != nil {
+			report("account %d: WalletId %q can't be encoded: %s", i, rawAccount.WalletId, err.Error())
+			continue
+		}
+		elements.Accounts = append(elements.Accounts, account)
+	}
+	return elements, nil
+}
+
+// convertRawAccountForValidation converts a raw account with the given WalletId encoding, or returns an error if its
+// WalletId or salt can't be encoded.
+func convertRawAccountForValidation(rawAccount circuit.RawGoAccount, encoding circuit.WalletIdEncoding) (account circuit.GoAccount, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	return circuit.ConvertRawGoAccountToGoAccountWithEncoding(rawAccount, encoding), nil
+}
+
+// prepareBatchForValidation prepares a batch to be proven like prepareBatches, without dummy accounts, or returns an
+// error if it can't be.
+func prepareBatchForValidation(elements *ProofElements, index int, exclusions []ExcludedAccount) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	proofElements := []ProofElements{*elements}
+	applyAssetSubset(proofElements, circuit.GetAssetSubset())
+	if GetOptions().AggregateUserAccounts {
+		applyAccountAggregation(proofElements, circuit.GetConfig())
+	}
+	applyExclusionList(proofElements, index, exclusions)
+	*elements = proofElements[0]
+	return nil
+}
@@ -0,0 +1,63 @@
+package core
+
+import (
+	"context"
+	"math/big"
+	"os"
+	"strings"
+	"testing"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"github.com/consensys/gnark/test"
+)
+
+func TestProveDryRun(t *testing.T) {
+	assert := test.NewAssert(t)
+	config := circuit.GetConfig()
+	assert.NoError(circuit.SetConfig(circuit.Config{TreeDepth: 2}))
+	defer func() { assert.NoError(circuit.SetConfig(config)) }()
+
+	// a dry run of valid batches writes a valid report, and no proof
+	dir := t.TempDir() + "/"
+	GenerateData(2, 4, dir)
+	assert.NotPanics(func() { Prove(2, dir, DryRun) })
+	var report ValidationReport
+	assert.NoError(readJson(dir+VALIDATION_REPORT, &report))
+	assert.True(report.Valid)
+	assert.Equal(8, report.AccountCount)
+	for i, batch := range report.Batches {
+		elements := ReadDataFromFile[ProofElements](dir + GetOptions().OutputLayout.secretDataFile(i))
+		assert.Equal(circuit.GoComputeMerkleRootFromAccounts(elements.Accounts), Hash(batch.MerkleRoot))
+		assert.Empty(batch.Problems)
+	}
+	_, err := os.Stat(dir + GetOptions().OutputLayout.bottomProofPrefix() + "0.json")
+	assert.True(os.IsNotExist(err), "expected no proof to be written by a dry run")
+
+	// every problem of every batch is reported: a wrong merkle root in the first batch, and a WalletId of the first
+	// batch, a negative balance, and a WalletId that can't be encoded in the second
+	var first, second RawProofElements
+	assert.NoError(readJson(dir+GetOptions().OutputLayout.secretDataFile(0), &first))
+	assert.NoError(readJson(dir+GetOptions().OutputLayout.secretDataFile(1), &second))
+	first.MerkleRoot = []byte{1}
+	second.Accounts[0].WalletId = first.Accounts[0].WalletId
+	second.Accounts[1].Balance[0] = big.NewInt(-1)
+	second.Accounts[2].WalletId = "not base36!"
+	assert.NoError(writeJson(dir+GetOptions().OutputLayout.secretDataFile(0), first))
+	assert.NoError(writeJson(dir+GetOptions().OutputLayout.secretDataFile(1), second))
+
+	report = ValidateRound(2, dir)
+	assert.False(report.Valid)
+	assert.Equal(1, len(report.Batches[0].Problems))
+	assert.True(strings.Contains(report.Batches[0].Problems[0], "merkle root"))
+	problems := strings.Join(report.Batches[1].Problems, "\n")
+	assert.True(strings.Contains(problems, "account 2: WalletId \"not base36!\" can't be encoded"), problems)
+	assert.True(strings.Contains(problems, "balance -1 is negative"), problems)
+	assert.Equal([]string{"account 0 of batch 1 has the WalletId of an account of batch 0"}, report.Problems)
+
+	// the run fails, and the report is written
+	assert.Error(ProveWithError(2, dir, DryRun))
+	_, err = ProveContext(context.Background(), 2, dir, DryRun)
+	assert.Error(err)
+	assert.NoError(readJson(dir+VALIDATION_REPORT, &report))
+	assert.False(report.Valid)
+}
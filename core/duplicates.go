@@ -0,0 +1,159 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"bitgo.com/proof_of_reserves/circuit"
+)
+
+// DuplicateUserCheck identifies how full verification checks that no user (identified by the WalletId of their
+// account) is in more than one batch of a round, which would count their liabilities twice, and could make up for
+// omitted users. Users are only checked within each batch by default.
+type DuplicateUserCheck string
+
+const (
+	// DUPLICATE_USER_CHECK_NONE only checks the users within each batch.
+	DUPLICATE_USER_CHECK_NONE DuplicateUserCheck = "none"
+	// DUPLICATE_USER_CHECK_SET keeps the set of the users of every batch, which is exact but grows with the number of
+	// users.
+	DUPLICATE_USER_CHECK_SET DuplicateUserCheck = "set"
+	// DUPLICATE_USER_CHECK_BLOOM keeps a bloom filter of the users of every batch, of about DUPLICATE_USER_BLOOM_BITS
+	// bits per account the batches can hold, and only keeps the users the filter may have seen before. Their batches
+	// are found by going through the batches again, so users that are in only one batch never fail the check.
+	DUPLICATE_USER_CHECK_BLOOM DuplicateUserCheck = "bloom"
+
+	// number of bits per account, and of hashes per user, of the bloom filters of DUPLICATE_USER_CHECK_BLOOM (about 1%
+	// of the users are false positives, which are checked again)
+	DUPLICATE_USER_BLOOM_BITS   = 10
+	DUPLICATE_USER_BLOOM_HASHES = 7
+)
+
+// ParseDuplicateUserCheck returns the duplicate user check with the given name. The empty name is
+// DUPLICATE_USER_CHECK_NONE.
+func ParseDuplicateUserCheck(name string) (DuplicateUserCheck, error) {
+	switch DuplicateUserCheck(name) {
+	case "", DUPLICATE_USER_CHECK_NONE:
+		return DUPLICATE_USER_CHECK_NONE, nil
+	case DUPLICATE_USER_CHECK_SET, DUPLICATE_USER_CHECK_BLOOM:
+		return DuplicateUserCheck(name), nil
+	}
+	return "", fmt.Errorf("unknown duplicate user check %q (expected %s, %s, or %s)", name, DUPLICATE_USER_CHECK_NONE, DUPLICATE_USER_CHECK_SET, DUPLICATE_USER_CHECK_BLOOM)
+}
+
+// accountPosition is the position of an account in the batches of a round.
+type accountPosition struct {
+	batch   int
+	account int
+}
+
+// duplicateUsers finds the users in more than one batch of a round, given the batches in order (see add). With a
+// bloom filter, the batches must then be given again (see confirm) if the filter found any candidates.
+type duplicateUsers struct {
+	check DuplicateUserCheck
+	// positions are the first positions of the users (of the candidates with a bloom filter)
+	positions map[string]accountPosition
+	filter    []uint64
+	// candidates are the users the bloom filter may have seen in an earlier batch
+	candidates map[string]bool
+}
+
+// newDuplicateUsers returns the duplicate users of a round of the given number of batches with the check, or nil if
+// users are not checked across batches.
+func newDuplicateUsers(check DuplicateUserCheck, batchCount int) *duplicateUsers {
+	switch check {
+	case DUPLICATE_USER_CHECK_SET:
+		return &duplicateUsers{check: check, positions: make(map[string]accountPosition)}
+	case DUPLICATE_USER_CHECK_BLOOM:
+		bits := max(batchCount*circuit.GetAccountsPerBatch()*DUPLICATE_USER_BLOOM_BITS, 64)
+		return &duplicateUsers{
+			check:      check,
+			positions:  make(map[string]accountPosition),
+			filter:     make([]uint64, (bits+63)/64),
+			candidates: make(map[string]bool),
+		}
+	}
+	return nil
+}
+
+// add adds the users of the batch with the given index, and checks they are in no earlier batch, unless they are only
+// candidates of the bloom filter.
+func (d *duplicateUsers) add(checks *verificationChecks, batch int, accounts []circuit.GoAccount) {
+	for j, account := range accounts {
+		if d.check == DUPLICATE_USER_CHECK_SET {
+			d.checkUser(checks, accountPosition{batch, j}, account)
+			continue
+		}
+		if d.addToFilter(account.WalletId) {
+			d.candidates[string(account.WalletId)] = true
+		}
+	}
+}
+
+// needsConfirmation reports whether the batches must be given again to confirm the candidates of the bloom filter.
+func (d *duplicateUsers) needsConfirmation() bool {
+	return len(d.candidates) > 0
+}
+
+// confirm checks the candidates of the bloom filter among the users of the batch with the given index are in no
+// earlier batch.
+func (d *duplicateUsers) confirm(checks *verificationChecks, batch int, accounts []circuit.GoAccount) {
+	for j, account := range accounts {
+		if d.candidates[string(account.WalletId)] {
+			d.checkUser(checks, accountPosition{batch, j}, account)
+		}
+	}
+}
+
+// checkUser checks the user of the account at the given position is in no earlier batch, and records its position if
+// it is the first.
+func (d *duplicateUsers) checkUser(checks *verificationChecks, position accountPosition, account circuit.GoAccount) {
+	first, ok := d.positions[string(account.WalletId)]
+	if !ok {
+		d.positions[string(account.WalletId)] = position
+		return
+	}
+	// accounts of the same user in one batch are reported by the check of the batch
+	if first.batch == position.batch {
+		return
+	}
+	failure := batchFailure(VERIFY_CHECK_CROSS_BATCH_DUPLICATES, position.batch)
+	failure.Account = &position.account
+	checks.fail(
+		fmt.Sprintf("duplicate users across batches: account %d of batch %d has the same WalletId as account %d of batch %d", position.account, position.batch, first.account, first.batch),
+		failure,
+	)
+}
+
+// addToFilter adds the WalletId to the bloom filter, and reports whether the filter may have contained it.
+func (d *duplicateUsers) addToFilter(walletId []byte) bool {
+	hash := sha256.Sum256(walletId)
+	h1, h2 := binary.BigEndian.Uint64(hash[:8]), binary.BigEndian.Uint64(hash[8:16])
+	bits := uint64(len(d.filter)) * 64
+	contained := true
+	for i := uint64(0); i < DUPLICATE_USER_BLOOM_HASHES; i++ {
+		bit := (h1 + i*h2) % bits
+		if d.filter[bit/64]&(1<<(bit%64)) == 0 {
+			contained = false
+			d.filter[bit/64] |= 1 << (bit % 64)
+		}
+	}
+	return contained
+}
+
+// checkCrossBatchDuplicateUsers checks no user is in more than one of the batches with the check.
+func checkCrossBatchDuplicateUsers(checks *verificationChecks, check DuplicateUserCheck, accountBatches [][]circuit.GoAccount) {
+	duplicates := newDuplicateUsers(check, len(accountBatches))
+	if duplicates == nil {
+		return
+	}
+	for i, batch := range accountBatches {
+		duplicates.add(checks, i, batch)
+	}
+	if duplicates.needsConfirmation() {
+		for i, batch := range accountBatches {
+			duplicates.confirm(checks, i, batch)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package core
+
+import (
+	"slices"
+	"testing"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"github.com/consensys/gnark/test"
+)
+
+func TestCheckCrossBatchDuplicateUsers(t *testing.T) {
+	assert := test.NewAssert(t)
+	batches := [][]circuit.GoAccount{testData0.Accounts, testData1.Accounts}
+
+	// a user in two batches is found by both checks, but accounts of the same user in one batch are left to the check
+	// of the batch
+	duplicateBatches := [][]circuit.GoAccount{slices.Clone(testData0.Accounts), slices.Clone(testData1.Accounts)}
+	duplicateBatches[0][1].WalletId = duplicateBatches[0][0].WalletId
+	duplicateBatches[1][3].WalletId = duplicateBatches[0][5].WalletId
+	for _, check := range []DuplicateUserCheck{DUPLICATE_USER_CHECK_SET, DUPLICATE_USER_CHECK_BLOOM} {
+		checks := &verificationChecks{collect: true}
+		checkCrossBatchDuplicateUsers(checks, check, batches)
+		assert.Equal(0, len(checks.failures), check)
+
+		checkCrossBatchDuplicateUsers(checks, check, duplicateBatches)
+		assert.Equal(1, len(checks.failures), check)
+		assert.Equal(VERIFY_CHECK_CROSS_BATCH_DUPLICATES, checks.failures[0].Check)
+		assert.Equal(1, *checks.failures[0].Batch)
+		assert.Equal(3, *checks.failures[0].Account)
+		assert.Contains(checks.failures[0].Error, "account 3 of batch 1 has the same WalletId as account 5 of batch 0")
+	}
+
+	// users are not checked across batches by default
+	checks := &verificationChecks{collect: true}
+	checkCrossBatchDuplicateUsers(checks, GetOptions().CrossBatchDuplicateUsers, duplicateBatches)
+	assert.Equal(0, len(checks.failures))
+
+	// a bloom filter that has seen every user only reports the users in more than one batch
+	duplicates := newDuplicateUsers(DUPLICATE_USER_CHECK_BLOOM, 1)
+	duplicates.filter = []uint64{^uint64(0)}
+	duplicates.add(checks, 0, batches[0])
+	duplicates.add(checks, 1, batches[1])
+	assert.True(duplicates.needsConfirmation())
+	duplicates.confirm(checks, 0, batches[0])
+	duplicates.confirm(checks, 1, batches[1])
+	assert.Equal(0, len(checks.failures))
+
+	check, err := ParseDuplicateUserCheck("")
+	assert.NoError(err)
+	assert.Equal(DUPLICATE_USER_CHECK_NONE, check)
+	check, err = ParseDuplicateUserCheck("bloom")
+	assert.NoError(err)
+	assert.Equal(DUPLICATE_USER_CHECK_BLOOM, check)
+	_, err = ParseDuplicateUserCheck("list")
+	assert.Error(err)
+}
@@ -0,0 +1,102 @@
+package core
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	// SECRET_COPY_KEY_SIZE is the size of the AES-256 key secret copies are encrypted with (see Options.SecretCopyKey).
+	SECRET_COPY_KEY_SIZE = 32
+	// ENCRYPTED_FILE_EXTENSION is appended to the name of encrypted files, e.g. 'bottom_level_proof_0.json.enc'.
+	ENCRYPTED_FILE_EXTENSION = ".enc"
+)
+
+// ReadSecretCopyKey reads a hex encoded AES-256 key to encrypt secret copies with (see Options.SecretCopyKey) from the given
+// path, e.g. one generated with 'openssl rand -hex 32'.
+func ReadSecretCopyKey(filePath string) ([]byte, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading secret copy key: %w", err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != SECRET_COPY_KEY_SIZE {
+		return nil, fmt.Errorf("secret copy key must be a hex encoded %d byte key", SECRET_COPY_KEY_SIZE)
+	}
+	return key, nil
+}
+
+// secretCopyCipher returns the AES-GCM cipher of Options.SecretCopyKey.
+func secretCopyCipher() (cipher.AEAD, error) {
+	options := GetOptions()
+	if options.SecretCopyKey == nil {
+		return nil, errors.New("no secret copy key is set")
+	}
+	block, err := aes.NewCipher(options.SecretCopyKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid secret copy key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// writeEncryptedJson writes the data as JSON to filePath with the ENCRYPTED_FILE_EXTENSION, encrypted with
+// Options.SecretCopyKey: the random nonce, followed by the sealed JSON, authenticated with the name of the file.
+func writeEncryptedJson(filePath string, data interface{}) error {
+	aead, err := secretCopyCipher()
+	if err != nil {
+		return err
+	}
+	plaintext, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("error generating nonce: %w", err)
+	}
+	sealed := aead.Seal(nonce, nonce, plaintext, []byte(path.Base(filePath)))
+	return writeFile(filePath+ENCRYPTED_FILE_EXTENSION, func(w io.Writer) error {
+		_, err := w.Write(sealed)
+		return err
+	})
+}
+
+// readEncryptedJson reads the JSON data of filePath written by writeEncryptedJson (i.e. from the file with the
+// ENCRYPTED_FILE_EXTENSION), decrypting it with Options.SecretCopyKey.
+func readEncryptedJson(filePath string, data interface{}) error {
+	sealed, err := readFile(filePath + ENCRYPTED_FILE_EXTENSION)
+	if err != nil {
+		return err
+	}
+	aead, err := secretCopyCipher()
+	if err != nil {
+		return fmt.Errorf("%s is encrypted: %w", filePath, err)
+	}
+	if len(sealed) < aead.NonceSize() {
+		return fmt.Errorf("%s is not an encrypted file", filePath)
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(path.Base(filePath)))
+	if err != nil {
+		return fmt.Errorf("error decrypting %s: %w", filePath, err)
+	}
+	return json.NewDecoder(bytes.NewReader(plaintext)).Decode(data)
+}
+
+// writeSecretCopy writes data as JSON to filePath in the secret directory, encrypted if Options.SecretCopyKey is set (see
+// writeEncryptedJson).
+func writeSecretCopy(filePath string, data interface{}) error {
+	if GetOptions().SecretCopyKey != nil {
+		return writeEncryptedJson(filePath, data)
+	}
+	return writeJson(filePath, data)
+}
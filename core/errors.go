@@ -0,0 +1,144 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"bitgo.com/proof_of_reserves/verification"
+)
+
+// ProveError is returned instead of panicking when proving a round fails (see ProveWithError).
+type ProveError struct {
+	// Stage is the step that failed, e.g. "invalid number of batches" (empty if it is not known).
+	Stage string
+	Err   error
+}
+
+func (e *ProveError) Error() string {
+	return "proving failed: " + joinStage(e.Stage, e.Err)
+}
+
+func (e *ProveError) Unwrap() error {
+	return e.Err
+}
+
+// joinStage returns the message of the error prefixed with the stage it happened in, if known.
+func joinStage(stage string, err error) string {
+	if stage == "" {
+		return err.Error()
+	}
+	return stage + ": " + err.Error()
+}
+
+// stageError is the error panicOnError panics with: the error of the step that failed, with the step (e.g. "invalid
+// number of batches"), so the functions that return errors instead of panicking (see ProveWithError) return the
+// original error, which errors.Is and errors.As see through.
+type stageError struct {
+	stage string
+	err   error
+}
+
+func (e *stageError) Error() string {
+	return joinStage(e.stage, e.err)
+}
+
+func (e *stageError) Unwrap() error {
+	return e.err
+}
+
+// recoveredError returns the step and the error of the value of a recovered panic. Runtime errors (e.g. a nil pointer
+// dereference) are bugs rather than failures, so they panic again. Panics raised with a message rather than an error
+// have no step.
+func recoveredError(r any) (stage string, err error) {
+	switch value := r.(type) {
+	case runtime.Error:
+		panic(value)
+	case *stageError:
+		return value.stage, value.err
+	case *verification.StageError:
+		return value.Stage, value.Err
+	case error:
+		return "", value
+	case string:
+		return "", errors.New(value)
+	default:
+		return "", fmt.Errorf("%v", value)
+	}
+}
+
+// recoverProveError sets err to a *ProveError if proving failed with a panic in a step of its own. Runtime errors are
+// bugs rather than failures to prove, so they keep panicking.
+func recoverProveError(err *error) {
+	if r := recover(); r != nil {
+		stage, cause := recoveredError(r)
+		*err = &ProveError{Stage: stage, Err: cause}
+	}
+}
+
+// recoverVerificationError sets err to a *VerificationError if verification failed with a panic in a step of its own.
+// Runtime errors are bugs rather than failures to verify, so they keep panicking.
+func recoverVerificationError(err *error) {
+	if r := recover(); r != nil {
+		stage, cause := recoveredError(r)
+		*err = &VerificationError{Stage: stage, Err: cause}
+	}
+}
+
+// recoverError sets err to the error of a panic in a step of its own, prefixed with message. The error keeps wrapping
+// the error the step failed with, and runtime errors keep panicking, as in recoverProveError.
+func recoverError(err *error, message string) {
+	if r := recover(); r != nil {
+		stage, cause := recoveredError(r)
+		*err = fmt.Errorf("%s: %w", message, &stageError{stage: stage, err: cause})
+	}
+}
+
+// panicOnStageError panics with err, if it is not nil, as panicOnError does with the stage of a *ProveError or a
+// *VerificationError, so the panicking functions fail with the messages they always did.
+func panicOnStageError(err error) {
+	var proveError *ProveError
+	var verificationError *VerificationError
+	switch {
+	case err == nil:
+		return
+	case errors.As(err, &proveError):
+		panic(&stageError{stage: proveError.Stage, err: proveError.Err})
+	case errors.As(err, &verificationError):
+		panic(&stageError{stage: verificationError.Stage, err: verificationError.Err})
+	default:
+		panic(err)
+	}
+}
+
+// ProveWithError generates the proofs of a round (see Prove), and returns a *ProveError if proving fails, for use in
+// services.
+func ProveWithError(batchCount int, outDir string, opts ...ProverOption) (err error) {
+	defer recoverProveError(&err)
+	return prove(batchCount, outDir, opts...)
+}
+
+// VerifyFullWithError verifies a round (see VerifyFull), and returns a *VerificationError if the round fails
+// verification or can't be read, for use in services.
+func VerifyFullWithError(batchCount int, outDir string) (err error) {
+	return checkRound(&verificationChecks{}, batchCount, outDir)
+}
+
+// VerifyUserWithError verifies a user verification package (see VerifyUser), and returns a *VerificationError if the
+// package fails verification, for use in services.
+func VerifyUserWithError(userVerifElements UserVerificationElements) (err error) {
+	defer recoverVerificationError(&err)
+	proofs, config, err := verification.UserProofChain(userVerifElements)
+	if err != nil {
+		return err
+	}
+	return verification.VerifyUserProofChain(userVerifElements, proofs, config, verifyProof)
+}
+
+// VerifyUserWithPinnedKeys verifies a user verification package like VerifyUserWithError, with the given pinned
+// verification keys instead of those of GetPinnedVerificationKeys, for services that verify the packages of rounds
+// with other keys concurrently.
+func VerifyUserWithPinnedKeys(userVerifElements UserVerificationElements, pins VerificationKeyPins) (err error) {
+	return VerifyUserWithPinnedKeysForDomain(userVerifElements, pins, circuit.GetDeploymentDomain())
+}
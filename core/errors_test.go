@@ -0,0 +1,103 @@
+package core
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"github.com/consensys/gnark/test"
+)
+
+func TestProveWithError(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	err := ProveWithError(0, t.TempDir()+"/")
+	var proveError *ProveError
+	assert.True(errors.As(err, &proveError))
+	assert.Equal("invalid number of batches", proveError.Stage)
+	assert.Error(proveError.Unwrap())
+}
+
+func TestVerifyWithError(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// the round of the test proofs has 2 batches
+	var verificationError *VerificationError
+	err := VerifyFullWithError(3, testOutDir)
+	assert.True(errors.As(err, &verificationError))
+	assert.Equal("layer plan verification failed", verificationError.Stage)
+
+	// VerifyFull panics with the message of the error
+	assert.PanicsWithError(verificationError.Stage+": "+verificationError.Err.Error(), func() { VerifyFull(3, testOutDir) })
+
+	// the error a step fails with is kept, e.g. that the files of a round that doesn't exist are missing
+	err = VerifyFullWithError(2, t.TempDir())
+	assert.True(errors.As(err, &verificationError))
+	assert.True(errors.Is(err, fs.ErrNotExist))
+
+	// a user package verifies, unless its account was not included
+	account := testData0.Accounts[1]
+	elements := UserVerificationElements{
+		AccountInfo: account,
+		ProofInfo: UserProofInfo{
+			UserMerklePath:     circuit.ComputeMerklePath(1, proofLower0.MerkleNodes),
+			UserMerklePosition: 1,
+			BottomProof:        proofLower0,
+			MiddleProof:        proofMid,
+			TopProof:           proofTop,
+		},
+	}
+	assert.NoError(VerifyUserWithError(elements))
+	elements.AccountInfo = testData0.Accounts[2]
+	err = VerifyUserWithError(elements)
+	assert.True(errors.As(err, &verificationError))
+	assert.NotEqual("", verificationError.Stage)
+}
+
+func TestRuntimeErrorsAreNotVerificationErrors(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// a failure to verify is returned as an error, but a runtime error is a bug, which keeps panicking
+	verify := func(fail func()) (err error) {
+		defer recoverVerificationError(&err)
+		fail()
+		return nil
+	}
+	var verificationError *VerificationError
+	assert.True(errors.As(verify(func() { panicOnError(errors.New("mismatch"), "check failed") }), &verificationError))
+	assert.Equal("check failed", verificationError.Stage)
+	assert.Panics(func() {
+		var proofs []CompletedProof
+		_ = verify(func() { _ = proofs[len(proofs)] })
+	})
+
+	// and so it does when it happens in a batch verified in parallel
+	defer SetVerificationWorkers(GetVerificationWorkers())
+	assert.NoError(SetVerificationWorkers(2))
+	assert.Panics(func() {
+		_ = verifyInParallel(2, func(i int) error {
+			var proofs []CompletedProof
+			_ = proofs[i]
+			return nil
+		})
+	})
+}
+
+func TestRecoverError(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// the error a step panics with is wrapped with the message, but a runtime error keeps panicking
+	run := func(fail func()) (err error) {
+		defer recoverError(&err, "proving non-inclusion failed")
+		fail()
+		return nil
+	}
+	err := run(func() { panicOnError(fs.ErrNotExist, "error reading round") })
+	assert.EqualError(err, "proving non-inclusion failed: error reading round: "+fs.ErrNotExist.Error())
+	assert.True(errors.Is(err, fs.ErrNotExist))
+	assert.Panics(func() {
+		var proofs []CompletedProof
+		_ = run(func() { _ = proofs[len(proofs)] })
+	})
+}
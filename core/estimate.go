@@ -0,0 +1,166 @@
+package core
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"bitgo.com/proof_of_reserves/circuit"
+)
+
+// RunEstimate is the predicted cost of proving a round (see EstimateRun).
+type RunEstimate struct {
+	Plan    LayerPlan
+	Workers int
+	// Duration is the predicted wall-clock time of the run, SetupDuration the part of it spent compiling and setting up
+	// circuits.
+	Duration      time.Duration
+	SetupDuration time.Duration
+	// PeakMemory is the predicted peak resident memory of the prover process, in bytes, or 0 where the platform does
+	// not report it.
+	PeakMemory uint64
+	// OutputSize is the predicted size, in bytes, of the batches and proofs of the round in its round directory (the
+	// files written once per round, e.g. its metadata, are left out).
+	OutputSize uint64
+	// BottomLevel and UpperLevel are the benchmarks the estimate is based on.
+	BottomLevel ProofBenchmark
+	UpperLevel  ProofBenchmark
+}
+
+// ProofBenchmark records the cost of a proof generated to estimate a run (see EstimateRun).
+type ProofBenchmark struct {
+	// Accounts is the number of accounts (or lower level proofs) of the proof, and Constraints the size of its circuit.
+	Accounts    int
+	Constraints int
+	// SetupDuration is the time spent compiling and setting up the circuit (0 if it was already cached), and
+	// ProofDuration the time spent hashing, creating the witness, and proving.
+	SetupDuration time.Duration
+	ProofDuration time.Duration
+	// ProofMemory is the estimated memory of the proof beyond its circuit (see estimateProofMemory), and PeakMemory the
+	// peak resident memory of the process once it was proven.
+	ProofMemory uint64
+	PeakMemory  uint64
+	// OutputSize is the size of the files written for the proof: its public and secret copies, its metrics (see
+	// Options.WriteProofMetrics), and for bottom level proofs, its batch.
+	OutputSize uint64
+}
+
+// EstimateRun predicts the wall-clock time, peak memory, and output size of proving a round of batchCount batches of
+// accountsPerBatch accounts with the given number of proof workers (see SetProofWorkers), with the configured backend,
+// circuit configuration, and publication policy, by generating a bottom level proof of accountsPerBatch generated
+// accounts and an upper level proof of as many copies of it as the first mid level proof includes, so capacity can be
+// planned without a trial run. Every batch is assumed to be full, and every upper level proof to cost as much as the
+// one generated. The circuits compiled are cached like those of a run, which doesn't set them up again.
+func EstimateRun(batchCount, accountsPerBatch, workers int) (estimate RunEstimate, err error) {
+	options := GetOptions()
+	if accountsPerBatch < 1 || accountsPerBatch > circuit.GetAccountsPerBatch() {
+		return RunEstimate{}, fmt.Errorf("accounts per batch must be between 1 and %d, got %d", circuit.GetAccountsPerBatch(), accountsPerBatch)
+	}
+	if workers < 1 {
+		return RunEstimate{}, fmt.Errorf("number of proof workers must be at least 1, got %d", workers)
+	}
+	plan := NewLayerPlan(batchCount)
+	if err := plan.Validate(); err != nil {
+		return RunEstimate{}, err
+	}
+	outDir, err := os.MkdirTemp("", "estimate")
+	if err != nil {
+		return RunEstimate{}, fmt.Errorf("error creating benchmark directory: %w", err)
+	}
+	defer os.RemoveAll(outDir)
+	outDir += "/"
+	defer recoverProveError(&err)
+	panicOnError(createRoundDirectories(outDir), "error creating benchmark directory")
+
+	// prove a batch of generated accounts, and an upper level proof of copies of its proof, like a run would
+	elements := []ProofElements{{}}
+	elements[0].Accounts, _, _, _ = circuit.GenerateTestDataWithProfile(accountsPerBatch, 0, options.TestDataProfile)
+	circuit.SaltAccounts(elements[0].Accounts)
+	assetSum := circuit.SumGoAccountBalances(elements[0].Accounts)
+	elements[0].AssetSum = &assetSum
+	applyAssetSubset(elements, circuit.GetAssetSubset())
+	WriteDataToFile(outDir+options.OutputLayout.secretDataFile(0), elements[0])
+	var bottomLevelProof CompletedProof
+	estimate = RunEstimate{Plan: plan, Workers: workers}
+	estimate.BottomLevel = benchmarkProof(outDir, bottomLevelLayer, func() CompletedProof {
+		bottomLevelProof = generateProof(elements[0], nil)
+		return bottomLevelProof
+	})
+	batchSize, err := filesSize(outDir, options.OutputLayout.secretDataPrefix())
+	panicOnError(err, "error measuring the size of the benchmark batch")
+	estimate.BottomLevel.OutputSize += batchSize
+	lowerProofs := make([]CompletedProof, plan.LevelProofSize(1, 0))
+	for i := range lowerProofs {
+		lowerProofs[i] = bottomLevelProof
+	}
+	estimate.UpperLevel = benchmarkProof(outDir, midLevelLayer, func() CompletedProof {
+		return generateNextLevelProofs(lowerProofs, nil)
+	})
+
+	// bottom level proofs are generated concurrently by as many workers as the proof memory budget allows, and upper
+	// level proofs one after the other once they are
+	concurrentProofs := min(workers, batchCount)
+	if limit := GetMaxProofMemory(); limit != 0 {
+		concurrentProofs = max(1, min(concurrentProofs, int(limit/max(estimate.BottomLevel.ProofMemory, 1))))
+	}
+	upperLevelProofCount := plan.UpperLevelProofCount()
+	estimate.SetupDuration = estimate.BottomLevel.SetupDuration + estimate.UpperLevel.SetupDuration
+	estimate.Duration = estimate.SetupDuration +
+		time.Duration(ceilDiv(batchCount, concurrentProofs))*estimate.BottomLevel.ProofDuration +
+		time.Duration(upperLevelProofCount)*estimate.UpperLevel.ProofDuration
+	estimate.PeakMemory = max(
+		estimate.BottomLevel.PeakMemory+uint64(concurrentProofs-1)*estimate.BottomLevel.ProofMemory,
+		estimate.UpperLevel.PeakMemory,
+	)
+	estimate.OutputSize = uint64(batchCount)*estimate.BottomLevel.OutputSize + uint64(upperLevelProofCount)*estimate.UpperLevel.OutputSize
+	return estimate, nil
+}
+
+// benchmarkProof generates a proof with generate and writes it to outDir as the first proof of the layer, and returns
+// its benchmark, whose OutputSize is the size of the files written for the proof.
+func benchmarkProof(outDir string, layer proofLayer, generate func() CompletedProof) ProofBenchmark {
+	start := time.Now()
+	proof := generate()
+	duration := time.Since(start)
+	value, _ := generatedProofMetrics.Load(proof.Proof)
+	metrics := value.(*ProofMetrics)
+	writeProof(proof, outDir, layer, 0)
+	outputSize, err := filesSize(outDir, layer.publicPrefix(), layer.secretPrefix)
+	panicOnError(err, "error measuring the size of the benchmark proof")
+
+	setupDuration := metrics.CompileDuration + metrics.SetupDuration
+	return ProofBenchmark{
+		Accounts:      metrics.Accounts,
+		Constraints:   metrics.Constraints,
+		SetupDuration: setupDuration,
+		ProofDuration: duration - setupDuration,
+		ProofMemory:   proofMemoryOf(metrics.Wires, metrics.Constraints),
+		PeakMemory:    metrics.PeakMemory,
+		OutputSize:    outputSize,
+	}
+}
+
+// filesSize returns the total size of the files in dir whose path relative to dir has one of the given prefixes.
+func filesSize(dir string, prefixes ...string) (uint64, error) {
+	var size uint64
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		relativePath := filepath.ToSlash(strings.TrimPrefix(path, dir))
+		if !slices.ContainsFunc(prefixes, func(prefix string) bool { return strings.HasPrefix(relativePath, prefix) }) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		size += uint64(info.Size())
+		return nil
+	})
+	return size, err
+}
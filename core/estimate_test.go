@@ -0,0 +1,50 @@
+package core
+
+import (
+	"testing"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"github.com/consensys/gnark/test"
+)
+
+func TestEstimateRun(t *testing.T) {
+	assert := test.NewAssert(t)
+	config := circuit.GetConfig()
+	assert.NoError(circuit.SetConfig(circuit.Config{TreeDepth: 2}))
+	defer func() { assert.NoError(circuit.SetConfig(config)) }()
+
+	// a round of 8 batches of 4 accounts has 2 mid level proofs of 4 bottom level proofs each, and a top level proof
+	estimate, err := EstimateRun(8, 4, 1)
+	assert.NoError(err)
+	assert.Equal(3, estimate.Plan.UpperLevelProofCount())
+	assert.Equal(4, estimate.BottomLevel.Accounts)
+	assert.Equal(4, estimate.UpperLevel.Accounts)
+	assert.True(estimate.BottomLevel.Constraints > 0 && estimate.BottomLevel.ProofDuration > 0)
+	assert.True(estimate.BottomLevel.OutputSize > estimate.UpperLevel.OutputSize, "bottom level proofs also write their batch")
+	assert.Equal(8*estimate.BottomLevel.OutputSize+3*estimate.UpperLevel.OutputSize, estimate.OutputSize)
+	assert.Equal(estimate.SetupDuration+8*estimate.BottomLevel.ProofDuration+3*estimate.UpperLevel.ProofDuration, estimate.Duration)
+
+	// circuits compiled for an estimate are cached, and more workers prove the bottom level proofs concurrently with
+	// more memory
+	concurrent, err := EstimateRun(8, 4, 4)
+	assert.NoError(err)
+	assert.Equal(int64(0), int64(concurrent.SetupDuration))
+	assert.Equal(2*concurrent.BottomLevel.ProofDuration+3*concurrent.UpperLevel.ProofDuration, concurrent.Duration)
+	if concurrent.BottomLevel.PeakMemory != 0 {
+		assert.Equal(concurrent.BottomLevel.PeakMemory+3*concurrent.BottomLevel.ProofMemory, concurrent.PeakMemory)
+	}
+
+	// the proof memory budget limits the concurrent proofs
+	SetMaxProofMemory(concurrent.BottomLevel.ProofMemory * 2)
+	defer SetMaxProofMemory(0)
+	limited, err := EstimateRun(8, 4, 4)
+	assert.NoError(err)
+	assert.Equal(4*limited.BottomLevel.ProofDuration+3*limited.UpperLevel.ProofDuration, limited.Duration)
+
+	_, err = EstimateRun(8, 5, 1)
+	assert.Error(err)
+	_, err = EstimateRun(8, 4, 0)
+	assert.Error(err)
+	_, err = EstimateRun(0, 4, 1)
+	assert.Error(err)
+}
@@ -0,0 +1,149 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"bitgo.com/proof_of_reserves/circuit"
+)
+
+// readExclusionList reads the exclusion list from outDir. Returns nil if there is no exclusion list.
+func readExclusionList(outDir string) []ExcludedAccount {
+	var exclusions []ExcludedAccount
+	err := readJson(outDir+EXCLUSION_LIST_FILE, &exclusions)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	panicOnError(err, "error reading exclusion list")
+	return exclusions
+}
+
+// computeExclusionListHash returns the hex encoded SHA-256 hash of the JSON encoding of the exclusion list,
+// or an empty string if there are no exclusions.
+func computeExclusionListHash(exclusions []ExcludedAccount) string {
+	if len(exclusions) == 0 {
+		return ""
+	}
+	encoded, err := json.Marshal(exclusions)
+	panicOnError(err, "error encoding exclusion list")
+	hash := sha256.Sum256(encoded)
+	return hex.EncodeToString(hash[:])
+}
+
+// readRoundMetadata reads the round metadata from outDir. Returns empty metadata if there is none
+// (e.g. for proofs generated before round metadata was introduced).
+func readRoundMetadata(outDir string) RoundMetadata {
+	var metadata RoundMetadata
+	err := readJson(outDir+ROUND_METADATA_FILE, &metadata)
+	if errors.Is(err, fs.ErrNotExist) {
+		return RoundMetadata{}
+	}
+	panicOnError(err, "error reading round metadata")
+	return metadata
+}
+
+// applyExclusionList removes the excluded accounts from the given batches (whose index is given by firstBatch
+// onwards) and returns a report entry for each removed account. The AssetSum of each affected batch is recomputed,
+// and its MerkleRoot and MerkleRootWithAssetSumHash are cleared so they are recomputed by the prover.
+func applyExclusionList(proofElements []ProofElements, firstBatch int, exclusions []ExcludedAccount) []ExcludedAccountReport {
+	if len(exclusions) == 0 {
+		return nil
+	}
+
+	// index exclusions by the WalletId bytes that accounts are identified with
+	reasons := make(map[string]ExcludedAccount, len(exclusions))
+	for _, exclusion := range exclusions {
+		walletId := circuit.ConvertRawGoAccountToGoAccount(circuit.RawGoAccount{WalletId: exclusion.WalletId}).WalletId
+		reasons[string(walletId)] = exclusion
+	}
+
+	report := make([]ExcludedAccountReport, 0)
+	for i := range proofElements {
+		accounts := make([]circuit.GoAccount, 0, len(proofElements[i].Accounts))
+		for _, account := range proofElements[i].Accounts {
+			if exclusion, ok := reasons[string(account.WalletId)]; ok {
+				report = append(report, ExcludedAccountReport{WalletId: exclusion.WalletId, Reason: exclusion.Reason, Batch: firstBatch + i})
+				continue
+			}
+			accounts = append(accounts, account)
+		}
+		if len(accounts) == len(proofElements[i].Accounts) {
+			continue
+		}
+
+		assetSum := circuit.SumGoAccountBalances(accounts)
+		proofElements[i] = ProofElements{Accounts: accounts, AssetSum: &assetSum}
+	}
+	return report
+}
+
+// verifyExclusionListMatchesMetadata verifies the exclusion list is the one whose hash was recorded in the round metadata.
+func verifyExclusionListMatchesMetadata(exclusions []ExcludedAccount, metadata RoundMetadata) error {
+	if computeExclusionListHash(exclusions) != metadata.ExclusionListHash {
+		return fmt.Errorf("hash of exclusion list does not match the hash recorded in the round metadata")
+	}
+	return nil
+}
+
+// verifyExcludedAccountCount verifies the number of accounts of the batches the exclusion list removed is the one
+// recorded in the round metadata.
+func verifyExcludedAccountCount(excludedAccountCount int, metadata RoundMetadata) error {
+	if excludedAccountCount != metadata.ExcludedAccountCount {
+		return fmt.Errorf("expected %d excluded accounts, but the exclusion list removes %d accounts from the batches", metadata.ExcludedAccountCount, excludedAccountCount)
+	}
+	return nil
+}
+
+// verifyExcludedAccountsNotIncluded verifies that no account with an excluded WalletId in the given (unfiltered)
+// account batches appears among the leaves of the bottom level proofs, hashed as the configuration hashes them.
+func verifyExcludedAccountsNotIncluded(bottomLevelProofs []CompletedProof, accountBatches [][]circuit.GoAccount, exclusions []ExcludedAccount, config circuit.Config) error {
+	if len(exclusions) == 0 {
+		return nil
+	}
+	excludedHashes := make(map[string]bool)
+	for _, batch := range accountBatches {
+		addExcludedAccountHashes(excludedHashes, batch, exclusions, config)
+	}
+	for i, bottomProof := range bottomLevelProofs {
+		if err := verifyExcludedHashesNotIncluded(i, bottomProof, excludedHashes, config); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addExcludedAccountHashes adds the leaf hashes of the accounts of the (unfiltered) batch with an excluded WalletId to
+// excludedHashes, hashed as the configuration hashes them, and returns the number of those accounts.
+func addExcludedAccountHashes(excludedHashes map[string]bool, batch []circuit.GoAccount, exclusions []ExcludedAccount, config circuit.Config) int {
+	excludedWalletIds := make(map[string]bool, len(exclusions))
+	for _, exclusion := range exclusions {
+		walletId := circuit.ConvertRawGoAccountToGoAccount(circuit.RawGoAccount{WalletId: exclusion.WalletId}).WalletId
+		excludedWalletIds[string(walletId)] = true
+	}
+	excludedAccountCount := 0
+	for _, account := range batch {
+		if excludedWalletIds[string(account.WalletId)] {
+			excludedHashes[string(config.GoComputeMiMCHashForAccount(account))] = true
+			excludedAccountCount++
+		}
+	}
+	return excludedAccountCount
+}
+
+// verifyExcludedHashesNotIncluded verifies that none of the leaves of the bottom level proof with the given index is
+// one of the excluded hashes (see addExcludedAccountHashes), in a tree of the depth of the configuration.
+func verifyExcludedHashesNotIncluded(i int, bottomProof CompletedProof, excludedHashes map[string]bool, config circuit.Config) error {
+	if len(bottomProof.MerkleNodes) != config.TreeDepth+1 {
+		return fmt.Errorf("bottom level proof %d has no leaves to check exclusions against", i)
+	}
+	for j, leaf := range bottomProof.MerkleNodes[config.TreeDepth] {
+		if excludedHashes[string(leaf)] {
+			return fmt.Errorf("excluded account found at position %d of bottom level proof %d", j, i)
+		}
+	}
+	return nil
+}
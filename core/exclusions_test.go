@@ -0,0 +1,90 @@
+package core
+
+import (
+	"testing"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"github.com/consensys/gnark/test"
+)
+
+func TestApplyExclusionList(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// copy the test batches so the shared test data is not modified
+	proofElements := []ProofElements{testData0, testData1}
+	for i := range proofElements {
+		proofElements[i].Accounts = append([]circuit.GoAccount{}, proofElements[i].Accounts...)
+	}
+	excludedWalletId := circuit.ConvertGoAccountToRawGoAccount(testData1.Accounts[2]).WalletId
+	exclusions := []ExcludedAccount{{WalletId: excludedWalletId, Reason: "frozen"}, {WalletId: "notincluded", Reason: "frozen"}}
+
+	report := applyExclusionList(proofElements, 4, exclusions)
+	assert.Equal([]ExcludedAccountReport{{WalletId: excludedWalletId, Reason: "frozen", Batch: 5}}, report)
+
+	// the unaffected batch should be unchanged
+	assert.Equal(testData0, proofElements[0])
+
+	// the affected batch should have the account removed and its asset sum recomputed
+	assert.Equal(countPerBatch-1, len(proofElements[1].Accounts))
+	expectedAccounts := append(append([]circuit.GoAccount{}, testData1.Accounts[:2]...), testData1.Accounts[3:]...)
+	assert.Equal(expectedAccounts, proofElements[1].Accounts)
+	expectedAssetSum := circuit.SumGoAccountBalances(expectedAccounts)
+	assert.True(proofElements[1].AssetSum.Equals(expectedAssetSum))
+	assert.Nil(proofElements[1].MerkleRoot)
+	assert.Nil(proofElements[1].MerkleRootWithAssetSumHash)
+
+	// no exclusions should not change anything
+	assert.Nil(applyExclusionList(proofElements, 0, nil))
+}
+
+func TestVerifyExclusionListMatchesMetadata(t *testing.T) {
+	exclusions := []ExcludedAccount{{WalletId: "abc", Reason: "frozen"}}
+	metadata := RoundMetadata{ExclusionListHash: computeExclusionListHash(exclusions), ExcludedAccountCount: 1}
+
+	if err := verifyExclusionListMatchesMetadata(exclusions, metadata); err != nil {
+		t.Errorf("expected exclusion list to match metadata, got error: %v", err)
+	}
+	if err := verifyExclusionListMatchesMetadata(nil, RoundMetadata{}); err != nil {
+		t.Errorf("expected empty exclusion list to match empty metadata, got error: %v", err)
+	}
+	if err := verifyExclusionListMatchesMetadata(nil, metadata); err == nil {
+		t.Error("expected missing exclusion list to fail")
+	}
+	if err := verifyExclusionListMatchesMetadata([]ExcludedAccount{{WalletId: "abc", Reason: "other"}}, metadata); err == nil {
+		t.Error("expected modified exclusion list to fail")
+	}
+
+	// the metadata counts the accounts the exclusion list removed, not its entries
+	if err := verifyExcludedAccountCount(1, metadata); err != nil {
+		t.Errorf("expected excluded account count to match metadata, got error: %v", err)
+	}
+	if err := verifyExcludedAccountCount(0, metadata); err == nil {
+		t.Error("expected excluded account count other than the one of the metadata to fail")
+	}
+
+	// the prover should have recorded an empty exclusion list for the test proofs
+	if metadata := readRoundMetadata(testOutDir); metadata.ExclusionListHash != "" || metadata.ExcludedAccountCount != 0 {
+		t.Errorf("expected empty exclusion list in round metadata, got %+v", metadata)
+	}
+}
+
+func TestVerifyExcludedAccountsNotIncluded(t *testing.T) {
+	bottomProofs := []CompletedProof{proofLower0, proofLower1}
+	accountBatches := [][]circuit.GoAccount{testData0.Accounts, testData1.Accounts, altTestData0.Accounts}
+
+	includedAccount := []ExcludedAccount{{WalletId: circuit.ConvertGoAccountToRawGoAccount(testData1.Accounts[0]).WalletId}}
+	if err := verifyExcludedAccountsNotIncluded(bottomProofs, accountBatches, includedAccount, circuit.GetConfig()); err == nil {
+		t.Error("expected included excluded account to fail verification")
+	}
+
+	notIncludedAccount := []ExcludedAccount{{WalletId: circuit.ConvertGoAccountToRawGoAccount(altTestData0.Accounts[0]).WalletId}}
+	if err := verifyExcludedAccountsNotIncluded(bottomProofs, accountBatches, notIncludedAccount, circuit.GetConfig()); err != nil {
+		t.Errorf("expected account not included to pass verification, got error: %v", err)
+	}
+
+	bottomProofWithoutNodes := proofLower0
+	bottomProofWithoutNodes.MerkleNodes = nil
+	if err := verifyExcludedAccountsNotIncluded([]CompletedProof{bottomProofWithoutNodes}, accountBatches, notIncludedAccount, circuit.GetConfig()); err == nil {
+		t.Error("expected bottom proof without merkle nodes to fail verification")
+	}
+}
@@ -0,0 +1,394 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"bitgo.com/proof_of_reserves/verification"
+)
+
+// ExportOptions controls how ExportUserPackages generates user verification packages.
+type ExportOptions struct {
+	// ShardSize is the number of bottom level batches whose users are written to the same shard directory.
+	ShardSize int
+	// Workers is the number of shards exported in parallel.
+	Workers int
+	// MaxPackagesPerSecond limits the rate at which packages are written across all workers (0 means unlimited).
+	MaxPackagesPerSecond float64
+	// Notifier is notified of every shard once it is complete (optional). Shards completed by previous runs whose
+	// notification failed are notified when an export is resumed.
+	Notifier ShardNotifier
+	// StorageURIPrefix is the URI the packages in the user output directory are stored under, e.g. once uploaded,
+	// used in notifications (defaults to the user output directory).
+	StorageURIPrefix string
+	// ReferenceVerificationKeys writes packages that reference the verification keys by fingerprint instead of
+	// including them (see ReferenceVerificationKeys), so they must be verified with the round's key bundle.
+	ReferenceVerificationKeys bool
+	// MerklePathElements adds the user's merkle path with the side of each sibling to packages (see
+	// UserProofInfo.UserMerklePathElements), for verifiers that do not derive the sides from the position.
+	MerklePathElements bool
+}
+
+// Validate checks the options can be used to export the packages of a round.
+func (opts ExportOptions) Validate() error {
+	if opts.ShardSize <= 0 {
+		return fmt.Errorf("shard size must be greater than 0, got %d", opts.ShardSize)
+	}
+	if opts.Workers < 0 {
+		return fmt.Errorf("number of workers must not be negative, got %d", opts.Workers)
+	}
+	// the packages are written on the ticks of a ticker, which needs a positive interval
+	if opts.MaxPackagesPerSecond < 0 || math.IsNaN(opts.MaxPackagesPerSecond) || math.IsInf(opts.MaxPackagesPerSecond, 0) ||
+		(opts.MaxPackagesPerSecond > 0 && opts.throttleInterval() <= 0) {
+		return fmt.Errorf("maximum packages written per second must be 0 (unlimited) or a positive rate of at most %d, got %v", time.Second, opts.MaxPackagesPerSecond)
+	}
+	return nil
+}
+
+// throttleInterval returns the interval between the packages written at the maximum rate.
+func (opts ExportOptions) throttleInterval() time.Duration {
+	return time.Duration(float64(time.Second) / opts.MaxPackagesPerSecond)
+}
+
+// UserExportShard describes a completed shard of user verification packages. It is written as the shard's
+// done marker next to the shard directory, so downstream pipelines can consume shards as they finish.
+type UserExportShard struct {
+	// RoundId is the id of the round the packages belong to (see RoundMetadata.RoundId), and ShardSize the number of
+	// batches of every shard of the export, so a marker left by an export of another round or with another shard size
+	// is not mistaken for the shard.
+	RoundId    string `json:",omitempty"`
+	ShardSize  int
+	Index      int
+	FirstBatch int
+	LastBatch  int
+	UserCount  int
+	Directory  string
+	// Checksum is the hex encoded SHA-256 hash of the names and SHA-256 hashes of the packages of the shard.
+	Checksum string
+	// Packages are the packages of the shard, in the order they are written.
+	Packages []UserPackageEntry
+	// Notified is whether the notifier of an export was notified of the shard (see ExportOptions.Notifier).
+	Notified bool `json:",omitempty"`
+}
+
+// UserPackageEntry maps the WalletId of a user to the file name of their verification package.
+type UserPackageEntry struct {
+	WalletId string
+	File     string
+}
+
+// UserPackageFileName returns the file name of the verification package of the account: the hex encoded SHA-256 hash
+// of its WalletId. WalletIds can be any string (see circuit.WalletIdEncoding), so they are never used as file names,
+// which could escape the export directory or collide on case-insensitive file systems.
+func UserPackageFileName(account circuit.GoAccount) string {
+	hash := sha256.Sum256(account.WalletId)
+	return hex.EncodeToString(hash[:]) + ".json"
+}
+
+// UserExportManifest is written once all shards of an export are complete.
+type UserExportManifest struct {
+	BatchCount int
+	ShardSize  int
+	UserCount  int
+	Shards     []UserExportShard
+}
+
+// BuildUserVerificationElements assembles the verification package of the account at the given position of the
+// bottom level proof. The bottom proof must contain its MerkleNodes, and the top proof must contain its AssetSum.
+func BuildUserVerificationElements(account circuit.GoAccount, position int, bottomProof, midProof, topProof CompletedProof) UserVerificationElements {
+	return BuildUserVerificationElementsFromChain(account, position, []CompletedProof{bottomProof, midProof, topProof})
+}
+
+// BuildUserVerificationElementsFromChain assembles the verification package of the account at the given position of
+// the bottom level proof of a round with any number of levels, given the proof of each level that includes the
+// account, from the bottom level proof up to the top level proof (see UserProofInfo.ProofChain). The bottom proof must
+// contain its MerkleNodes, and the top proof must contain its AssetSum.
+func BuildUserVerificationElementsFromChain(account circuit.GoAccount, position int, proofChain []CompletedProof) UserVerificationElements {
+	if len(proofChain) < MIN_HIERARCHY_LEVELS {
+		panic(fmt.Sprintf("proof chain must have at least %d proofs, found %d", MIN_HIERARCHY_LEVELS, len(proofChain)))
+	}
+	bottomProof := proofChain[0]
+	if bottomProof.MerkleNodes == nil {
+		panic("bottom proof has no MerkleNodes, cannot compute user merkle path")
+	}
+	userMerklePath := circuit.ComputeMerklePath(position, bottomProof.MerkleNodes)
+
+	// strip data that should not be shared with the user
+	proofs := make([]CompletedProof, len(proofChain))
+	for i, proof := range proofChain {
+		proof.MerkleNodes = nil
+		if i < len(proofChain)-1 {
+			proof.AssetSum = nil
+		}
+		proofs[i] = proof
+	}
+	var intermediateProofs []CompletedProof
+	if len(proofs) > MIN_HIERARCHY_LEVELS {
+		intermediateProofs = proofs[2 : len(proofs)-1]
+	}
+
+	return UserVerificationElements{
+		AccountInfo: account,
+		ProofInfo: UserProofInfo{
+			UserMerklePath:     userMerklePath,
+			UserMerklePosition: position,
+			BottomProof:        proofs[0],
+			MiddleProof:        proofs[1],
+			IntermediateProofs: intermediateProofs,
+			TopProof:           proofs[len(proofs)-1],
+		},
+	}
+}
+
+// userExport holds what is needed to write the user verification packages of the batches of a round, which is read
+// once and shared by every batch (see newUserExport).
+type userExport struct {
+	outDir     string
+	plan       LayerPlan
+	metadata   RoundMetadata
+	exclusions []ExcludedAccount
+	// config is the configuration the proofs were generated with (see verification.ProofsConfig), and assetSubset their
+	// asset subset
+	config      circuit.Config
+	assetSubset []int
+	// upperLevelProofs are the proofs of the levels above the bottom level, from the mid level up to the top level. If
+	// opts.ReferenceVerificationKeys is set, they reference their verification keys.
+	upperLevelProofs [][]CompletedProof
+	opts             ExportOptions
+	// throttle limits the rate packages are written at, if set
+	throttle <-chan time.Time
+}
+
+// newUserExport reads the round metadata, exclusion list, and upper level proofs of the round of batchCount batches in
+// the round directory of outDir (see Options.OutputLayout), checking they belong to the round, to write the packages of its users with the given options. The balances
+// of the packages are restricted to the asset subset of the proofs, if any.
+func newUserExport(batchCount int, outDir string, opts ExportOptions) userExport {
+	outDir = GetOptions().OutputLayout.RoundDir(outDir)
+	metadata := readRoundMetadata(outDir)
+	topLevelProof := readPublishedProof(outDir, topLevelLayer, 0)
+	config, err := verification.ProofsConfig([]CompletedProof{topLevelProof})
+	panicOnError(err, "proof parameters verification failed")
+	plan := roundLayerPlan(batchCount, metadata, config)
+	panicOnError(plan.Validate(), "invalid number of batches")
+	panicOnError(verifyLayerPlanMatchesMetadata(plan, metadata), "layer plan verification failed")
+
+	// the proofs of the levels above the bottom level are shared by all users, so only read them once
+	upperLevelProofs := append(readMidLevelProofs(plan, outDir), []CompletedProof{topLevelProof})
+	for i, proofs := range upperLevelProofs {
+		panicOnError(verification.VerifyProofsBelongToRound(metadata.RoundId, levelLayer(i+1, plan.Levels()).name, proofs), "round id verification failed")
+	}
+	assetSubset, err := verification.ProofsAssetSubset([]CompletedProof{topLevelProof})
+	panicOnError(err, "asset subset verification failed")
+	if opts.ReferenceVerificationKeys {
+		for _, proofs := range upperLevelProofs {
+			for i := range proofs {
+				proofs[i] = referenceVerificationKey(proofs[i])
+			}
+		}
+	}
+	return userExport{
+		outDir:           outDir,
+		plan:             plan,
+		metadata:         metadata,
+		exclusions:       readExclusionList(outDir),
+		config:           config,
+		assetSubset:      assetSubset,
+		upperLevelProofs: upperLevelProofs,
+		opts:             opts,
+	}
+}
+
+// writeBatchPackages writes the user verification package of every account of the given batch to directory (see
+// UserPackageFileName). Returns the packages written.
+func (e userExport) writeBatchPackages(batch int, directory string) []UserPackageEntry {
+	// excluded accounts are not in the proofs, so remove them (and the assets not in the proofs) and merge the
+	// accounts of each user the same way the prover did
+	proofElements := []ProofElements{ReadDataFromFile[ProofElements](e.outDir + GetOptions().OutputLayout.secretDataFile(batch))}
+	applyAssetSubset(proofElements, e.assetSubset)
+	if e.metadata.AggregatedUserAccounts {
+		applyAccountAggregation(proofElements, e.config)
+	}
+	applyExclusionList(proofElements, batch, e.exclusions)
+	accounts := proofElements[0].Accounts
+	topLevelProof := e.upperLevelProofs[len(e.upperLevelProofs)-1][0]
+	bottomProof := readBottomLevelProof(e.outDir, batch)
+	panicOnError(verification.VerifyProofsBelongToRound(topLevelProof.RoundId, "bottom", []CompletedProof{bottomProof}), "round id verification failed")
+	if e.opts.ReferenceVerificationKeys {
+		bottomProof = referenceVerificationKey(bottomProof)
+	}
+	// the proof of each level that includes the batch, from the bottom level proof up
+	proofChain := []CompletedProof{bottomProof}
+	index := batch
+	for _, proofs := range e.upperLevelProofs {
+		index = e.plan.UpperLevelProofIndex(index)
+		proofChain = append(proofChain, proofs[index])
+	}
+
+	packages := make([]UserPackageEntry, len(accounts))
+	for position, account := range accounts {
+		if e.throttle != nil {
+			<-e.throttle
+		}
+		elements := BuildUserVerificationElementsFromChain(account, position, proofChain)
+		if e.opts.MerklePathElements {
+			elements.ProofInfo.UserMerklePathElements = circuit.ComputeMerklePathElements(position, bottomProof.MerkleNodes)
+		}
+		packages[position] = UserPackageEntry{WalletId: circuit.ConvertGoAccountToRawGoAccount(account).WalletId, File: UserPackageFileName(account)}
+		WriteDataToFile(filepath.Join(directory, packages[position].File), elements)
+	}
+	return packages
+}
+
+// exportShard writes the user verification packages of every account in the batches of the given shard, followed
+// by the shard's done marker. Returns an error instead of panicking so failures can be collected from workers.
+func exportShard(shard UserExportShard, export userExport) (completedShard UserExportShard, err error) {
+	defer recoverError(&err, fmt.Sprintf("exporting shard %d failed", shard.Index))
+
+	// packages left by an interrupted or mismatched export would be counted in the checksum of the shard
+	if err := os.Remove(shard.Directory + SHARD_DONE_MARKER_SUFFIX); !errors.Is(err, fs.ErrNotExist) {
+		panicOnError(err, "failed to remove shard done marker")
+	}
+	panicOnError(os.RemoveAll(shard.Directory), "failed to remove shard directory")
+	panicOnError(os.MkdirAll(shard.Directory, 0o755), "failed to create shard directory")
+	for batch := shard.FirstBatch; batch <= shard.LastBatch; batch++ {
+		shard.Packages = append(shard.Packages, export.writeBatchPackages(batch, shard.Directory)...)
+	}
+
+	shard.UserCount = len(shard.Packages)
+
+	// the done marker is written last, so its presence means every package of the shard was written
+	shard.Checksum, err = computeShardChecksum(shard.Directory)
+	panicOnError(err, "failed to compute shard checksum")
+	panicOnError(writeJson(shard.Directory+SHARD_DONE_MARKER_SUFFIX, shard), "failed to write shard done marker")
+	return shard, nil
+}
+
+// GenerateUserPackages writes the verification package of every account in the batches read from outDir to
+// userOutDir (see UserPackageFileName), so each can be handed to its user as is. Unlike
+// ExportUserPackages, the packages are written to userOutDir itself, and every package is self-contained: it includes
+// the verification keys of its proofs and the user's merkle path with the side of each sibling. Returns the number of
+// packages written.
+func GenerateUserPackages(batchCount int, outDir string, userOutDir string) int {
+	export := newUserExport(batchCount, outDir, ExportOptions{MerklePathElements: true})
+	panicOnError(os.MkdirAll(userOutDir, 0o755), "failed to create user output directory")
+	userCount := 0
+	for batch := 0; batch < batchCount; batch++ {
+		userCount += len(export.writeBatchPackages(batch, userOutDir))
+	}
+	return userCount
+}
+
+// ExportUserPackages generates the user verification package of every account in the batches read from outDir,
+// writing them to shard directories under userOutDir. Shards that already have a done marker of the same round, shard
+// size, and batches are skipped, so an interrupted export can be resumed by running it again, while the shards of
+// other markers are exported again. Once every shard is complete, a manifest listing all the shards is written to
+// userOutDir. If a notifier is set, it is notified of every complete shard, and failing to notify fails the export
+// (rerunning it notifies the shards that were not notified).
+func ExportUserPackages(batchCount int, outDir string, userOutDir string, opts ExportOptions) UserExportManifest {
+	panicOnError(opts.Validate(), "invalid export options")
+	if opts.Workers == 0 {
+		opts.Workers = 1
+	}
+
+	export := newUserExport(batchCount, outDir, opts)
+	roundId := export.metadata.RoundId
+	if roundId == "" {
+		// rounds generated before round ids were recorded are identified by their top level proof
+		topLevelProof := export.upperLevelProofs[len(export.upperLevelProofs)-1][0]
+		roundId = hex.EncodeToString(topLevelProof.MerkleRootWithAssetSumHash)
+	}
+
+	if opts.MaxPackagesPerSecond > 0 {
+		ticker := time.NewTicker(opts.throttleInterval())
+		defer ticker.Stop()
+		export.throttle = ticker.C
+	}
+
+	// plan shards, reusing the done markers of shards completed by previous runs
+	shardCount := (batchCount + opts.ShardSize - 1) / opts.ShardSize
+	shards := make([]UserExportShard, shardCount)
+	pending := make(chan int, shardCount)
+	completed := make([]int, 0)
+	for i := range shards {
+		shards[i] = UserExportShard{
+			RoundId:    export.metadata.RoundId,
+			ShardSize:  opts.ShardSize,
+			Index:      i,
+			FirstBatch: i * opts.ShardSize,
+			LastBatch:  min((i+1)*opts.ShardSize, batchCount) - 1,
+			Directory:  filepath.Join(userOutDir, "shard_"+strconv.Itoa(i)),
+		}
+		markerPath := shards[i].Directory + SHARD_DONE_MARKER_SUFFIX
+		if _, err := os.Stat(markerPath); err == nil {
+			var completedShard UserExportShard
+			panicOnError(readJson(markerPath, &completedShard), "failed to read shard done marker")
+			if completedShard.isShard(shards[i]) {
+				shards[i] = completedShard
+				completed = append(completed, i)
+				continue
+			}
+		}
+		pending <- i
+	}
+	close(pending)
+
+	// export pending shards in parallel
+	var wg sync.WaitGroup
+	errs := make([]error, shardCount)
+	// the shards notified are marked as such in their done markers, so resuming an export only notifies the shards
+	// whose notification failed
+	notify := func(i int) {
+		if opts.Notifier == nil || errs[i] != nil || shards[i].Notified {
+			return
+		}
+		if err := opts.Notifier.Notify(newShardCompletionEvent(roundId, shards[i], opts.StorageURIPrefix)); err != nil {
+			errs[i] = fmt.Errorf("notifying completion of shard %d failed: %w", i, err)
+			return
+		}
+		shards[i].Notified = true
+		if err := writeJson(shards[i].Directory+SHARD_DONE_MARKER_SUFFIX, shards[i]); err != nil {
+			errs[i] = fmt.Errorf("marking shard %d as notified failed: %w", i, err)
+		}
+	}
+	for _, i := range completed {
+		notify(i)
+	}
+	for w := 0; w < opts.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range pending {
+				shards[i], errs[i] = exportShard(shards[i], export)
+				notify(i)
+			}
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		panicOnError(err, "error exporting user packages")
+	}
+
+	manifest := UserExportManifest{BatchCount: batchCount, ShardSize: opts.ShardSize, Shards: shards}
+	for _, shard := range shards {
+		manifest.UserCount += shard.UserCount
+	}
+	panicOnError(writeJson(filepath.Join(userOutDir, USER_EXPORT_MANIFEST), manifest), "failed to write user export manifest")
+	return manifest
+}
+
+// isShard returns whether the shard of a done marker is the planned shard: of the same round and shard size, with the
+// same batches.
+func (shard UserExportShard) isShard(planned UserExportShard) bool {
+	return shard.RoundId == planned.RoundId && shard.ShardSize == planned.ShardSize && shard.Index == planned.Index &&
+		shard.FirstBatch == planned.FirstBatch && shard.LastBatch == planned.LastBatch
+}
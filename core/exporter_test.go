@@ -0,0 +1,270 @@
+package core
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"github.com/consensys/gnark/test"
+)
+
+func TestExportUserPackages(t *testing.T) {
+	assert := test.NewAssert(t)
+	userOutDir := "testutildata/users"
+	defer os.RemoveAll(userOutDir)
+
+	manifest := ExportUserPackages(batchCount, testOutDir, userOutDir, ExportOptions{ShardSize: 1, Workers: 2})
+	assert.Equal(batchCount*countPerBatch, manifest.UserCount)
+	assert.Equal(batchCount, len(manifest.Shards))
+	for i, shard := range manifest.Shards {
+		assert.Equal(countPerBatch, shard.UserCount)
+		assert.Equal(i, shard.FirstBatch)
+		_, err := os.Stat(shard.Directory + SHARD_DONE_MARKER_SUFFIX)
+		assert.NoError(err, "expected done marker for shard %d", i)
+	}
+
+	// every exported package should pass user verification
+	account := testData1.Accounts[3]
+	packagePath := filepath.Join(manifest.Shards[1].Directory, UserPackageFileName(account))
+	userVerificationElements := ReadDataFromFile[UserVerificationElements](packagePath)
+	assert.NotPanics(func() { VerifyUser(userVerificationElements) })
+	assert.Equal(3, userVerificationElements.ProofInfo.UserMerklePosition)
+	assert.Equal(UserPackageEntry{WalletId: circuit.ConvertGoAccountToRawGoAccount(account).WalletId, File: UserPackageFileName(account)}, manifest.Shards[1].Packages[3])
+
+	// completed shards are skipped when resuming, incomplete shards are exported again
+	assert.NoError(os.Remove(packagePath))
+	ExportUserPackages(batchCount, testOutDir, userOutDir, ExportOptions{ShardSize: 1})
+	_, err := os.Stat(packagePath)
+	assert.True(os.IsNotExist(err), "expected completed shard to be skipped")
+
+	assert.NoError(os.Remove(manifest.Shards[1].Directory + SHARD_DONE_MARKER_SUFFIX))
+	resumedManifest := ExportUserPackages(batchCount, testOutDir, userOutDir, ExportOptions{ShardSize: 1, MaxPackagesPerSecond: 1000})
+	_, err = os.Stat(packagePath)
+	assert.NoError(err, "expected incomplete shard to be exported again")
+	assert.Equal(manifest, resumedManifest)
+
+	// the marker of a shard of another round is not trusted, and the shard is exported again
+	markerPath := manifest.Shards[1].Directory + SHARD_DONE_MARKER_SUFFIX
+	var marker UserExportShard
+	assert.NoError(readJson(markerPath, &marker))
+	assert.Equal(proofTop.RoundId, marker.RoundId)
+	marker.RoundId = "another round"
+	assert.NoError(writeJson(markerPath, marker))
+	assert.NoError(os.Remove(packagePath))
+	assert.Equal(manifest, ExportUserPackages(batchCount, testOutDir, userOutDir, ExportOptions{ShardSize: 1}))
+	_, err = os.Stat(packagePath)
+	assert.NoError(err, "expected shard of another round to be exported again")
+
+	// and so are the shards of markers of another shard size
+	resizedManifest := ExportUserPackages(batchCount, testOutDir, userOutDir, ExportOptions{ShardSize: batchCount})
+	assert.Equal(1, len(resizedManifest.Shards))
+	assert.Equal(batchCount*countPerBatch, resizedManifest.Shards[0].UserCount)
+	assert.Equal(batchCount-1, resizedManifest.Shards[0].LastBatch)
+}
+
+func TestExportOptionsValidate(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	assert.NoError(ExportOptions{ShardSize: 1}.Validate())
+	assert.NoError(ExportOptions{ShardSize: 1, Workers: 4, MaxPackagesPerSecond: 0.5}.Validate())
+	assert.Error(ExportOptions{}.Validate())
+	assert.Error(ExportOptions{ShardSize: 1, Workers: -1}.Validate())
+
+	// a rate whose interval between packages is not positive can't be throttled to
+	for _, rate := range []float64{-1, 2e9, math.Inf(1), math.NaN()} {
+		assert.Error(ExportOptions{ShardSize: 1, MaxPackagesPerSecond: rate}.Validate(), "rate %v", rate)
+	}
+	assert.Panics(func() {
+		ExportUserPackages(batchCount, testOutDir, t.TempDir(), ExportOptions{ShardSize: 1, MaxPackagesPerSecond: -1})
+	})
+}
+
+func TestGenerateUserPackages(t *testing.T) {
+	assert := test.NewAssert(t)
+	userOutDir := t.TempDir()
+
+	assert.Equal(batchCount*countPerBatch, GenerateUserPackages(batchCount, testOutDir, userOutDir))
+
+	// the packages are written to the output directory itself, include their keys and path elements, and verify
+	account := testData1.Accounts[3]
+	packagePath := filepath.Join(userOutDir, UserPackageFileName(account))
+	userVerificationElements := ReadDataFromFile[UserVerificationElements](packagePath)
+	assert.NotEmpty(userVerificationElements.ProofInfo.BottomProof.VerificationKey)
+	assert.NotEmpty(userVerificationElements.ProofInfo.UserMerklePathElements)
+	assert.NotPanics(func() { VerifyUser(userVerificationElements) })
+	_, err := os.Stat(filepath.Join(userOutDir, USER_EXPORT_MANIFEST))
+	assert.True(os.IsNotExist(err), "expected no export manifest")
+}
+
+func TestBuildUserVerificationElements(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	elements := BuildUserVerificationElements(testData0.Accounts[2], 2, proofLower0, proofMid, proofTop)
+	assert.Nil(elements.ProofInfo.BottomProof.MerkleNodes)
+	assert.Nil(elements.ProofInfo.BottomProof.AssetSum)
+	assert.NotNil(elements.ProofInfo.TopProof.AssetSum)
+	assert.NotPanics(func() { VerifyUser(elements) })
+
+	// bottom proofs without merkle nodes cannot be used
+	bottomProofWithoutNodes := proofLower0
+	bottomProofWithoutNodes.MerkleNodes = nil
+	assert.Panics(func() {
+		BuildUserVerificationElements(testData0.Accounts[2], 2, bottomProofWithoutNodes, proofMid, proofTop)
+	})
+}
+
+func TestExportUserPackagesWithMerklePathElements(t *testing.T) {
+	assert := test.NewAssert(t)
+	userOutDir := t.TempDir()
+
+	// packages have the merkle path with the side of each sibling, which is verified instead of the merkle path
+	manifest := ExportUserPackages(batchCount, testOutDir, userOutDir, ExportOptions{ShardSize: 1, MerklePathElements: true})
+	account := testData0.Accounts[5]
+	packagePath := filepath.Join(manifest.Shards[0].Directory, UserPackageFileName(account))
+	elements := ReadDataFromFile[UserVerificationElements](packagePath)
+	assert.Equal(circuit.ComputeMerklePathElements(5, proofLower0.MerkleNodes), elements.ProofInfo.UserMerklePathElements)
+	assert.NotPanics(func() { VerifyUser(elements) })
+
+	// the sides must be those of the user's position
+	elements.ProofInfo.UserMerklePathElements = circuit.ComputeMerklePathElements(4, proofLower0.MerkleNodes)
+	assert.Panics(func() { VerifyUser(elements) })
+}
+
+func TestExportUserPackagesNotifiesShards(t *testing.T) {
+	assert := test.NewAssert(t)
+	userOutDir := t.TempDir()
+
+	var mutex sync.Mutex
+	events := make(map[string]ShardCompletionEvent)
+	notifications := 0
+	failing := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		notifications++
+		if failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var event ShardCompletionEvent
+		assert.NoError(json.NewDecoder(r.Body).Decode(&event))
+		assert.Equal(event.IdempotencyKey, r.Header.Get("Idempotency-Key"))
+		events[event.IdempotencyKey] = event
+	}))
+	defer server.Close()
+	opts := ExportOptions{ShardSize: 1, Workers: 2, Notifier: WebhookNotifier{URL: server.URL}, StorageURIPrefix: "s3://packages/round"}
+
+	// failing to notify fails the export, but the shards stay complete
+	assert.Panics(func() { ExportUserPackages(batchCount, testOutDir, userOutDir, opts) })
+
+	// rerunning the export notifies the completed shards
+	mutex.Lock()
+	failing = false
+	notifications = 0
+	mutex.Unlock()
+	manifest := ExportUserPackages(batchCount, testOutDir, userOutDir, opts)
+	assert.Equal(batchCount, len(events))
+	assert.Equal(batchCount, notifications)
+	for _, shard := range manifest.Shards {
+		checksum, err := computeShardChecksum(shard.Directory)
+		assert.NoError(err)
+		assert.Equal(checksum, shard.Checksum)
+
+		// the event of a shard is identified by the round id and the index of the shard
+		event := events[proofTop.RoundId+"/shard_"+strconv.Itoa(shard.Index)]
+		assert.Equal(proofTop.RoundId, event.RoundId)
+		assert.Equal(shard.UserCount, event.UserCount)
+		assert.Equal(shard.Checksum, event.Checksum)
+		assert.Equal("s3://packages/round/shard_"+strconv.Itoa(shard.Index), event.StorageURI)
+	}
+	assert.NotEqual(manifest.Shards[0].Checksum, manifest.Shards[1].Checksum)
+
+	// the shards notified are not notified again when the export is rerun
+	assert.True(manifest.Shards[0].Notified)
+	assert.Equal(manifest, ExportUserPackages(batchCount, testOutDir, userOutDir, opts))
+	assert.Equal(batchCount, notifications)
+}
+
+func TestWebhookNotifierTimesOut(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// a webhook that stops responding fails the notification instead of hanging the export
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+	assert.Equal(WEBHOOK_TIMEOUT, webhookHTTPClient.Timeout)
+	notifier := WebhookNotifier{URL: server.URL, Client: &http.Client{Timeout: 50 * time.Millisecond}}
+	assert.Error(notifier.Notify(ShardCompletionEvent{IdempotencyKey: "round/shard_0"}))
+}
+
+func TestExportUserPackagesReferencingVerificationKeys(t *testing.T) {
+	assert := test.NewAssert(t)
+	userOutDir := t.TempDir()
+
+	manifest := ExportUserPackages(batchCount, testOutDir, userOutDir, ExportOptions{ShardSize: 1, ReferenceVerificationKeys: true})
+	account := testData1.Accounts[3]
+	packagePath := filepath.Join(manifest.Shards[1].Directory, UserPackageFileName(account))
+	elements := ReadDataFromFile[UserVerificationElements](packagePath)
+	assert.Equal("", elements.ProofInfo.BottomProof.VerificationKey)
+	assert.Equal("", elements.ProofInfo.TopProof.VerificationKey)
+
+	// the package is smaller than one including the verification keys
+	fullPackagePath := filepath.Join(t.TempDir(), "full.json")
+	WriteDataToFile(fullPackagePath, BuildUserVerificationElements(account, 3, proofLower1, proofMid, proofTop))
+	referencingInfo, err := os.Stat(packagePath)
+	assert.NoError(err)
+	fullInfo, err := os.Stat(fullPackagePath)
+	assert.NoError(err)
+	assert.True(referencingInfo.Size() < fullInfo.Size(), "expected referencing package to be smaller")
+
+	// unresolved keys fail verification, resolved keys pass
+	assert.Panics(func() { VerifyUser(elements) })
+	now := time.Now()
+	verificationKeys := BuildKeyBundle(batchCount, testOutDir, now, now.Add(time.Hour)).VerificationKeys()
+	resolved, err := ResolveVerificationKeys(elements, verificationKeys)
+	assert.NoError(err)
+	assert.NotPanics(func() { VerifyUser(resolved) })
+
+	// packages including their keys are resolved unchanged
+	full := ReadDataFromFile[UserVerificationElements](fullPackagePath)
+	resolvedFull, err := ResolveVerificationKeys(full, nil)
+	assert.NoError(err)
+	assert.Equal(full, resolvedFull)
+
+	// with a key bundle, the keys packages come with must be in it too
+	bundled, err := ResolveBundledVerificationKeys(full, verificationKeys)
+	assert.NoError(err)
+	assert.Equal(full, bundled)
+
+	// keys missing from the bundle fail resolution
+	delete(verificationKeys, elements.ProofInfo.MiddleProof.VerificationKeyFingerprint)
+	_, err = ResolveVerificationKeys(elements, verificationKeys)
+	assert.Error(err)
+	_, err = ResolveBundledVerificationKeys(full, verificationKeys)
+	assert.Error(err)
+}
+
+func TestUserPackageFileName(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// packages are named after the hash of the WalletId, so WalletIds that are paths, or that only differ in case or
+	// leading zero bytes, get distinct names inside the export directory
+	names := make(map[string]bool)
+	for _, walletId := range [][]byte{[]byte("../../x"), []byte("user"), []byte("USER"), []byte("\x00user")} {
+		name := UserPackageFileName(circuit.GoAccount{WalletId: walletId})
+		assert.Equal(name, filepath.Base(name))
+		assert.False(names[name], "duplicate package name for %q", walletId)
+		names[name] = true
+	}
+}
@@ -0,0 +1,103 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// writeFile writes a file at filePath with the write function, compressed if filePath has the extension of a
+// compression (see newCompressingWriter). The file is written to a temporary file in the same directory, which is
+// renamed to filePath once write returns without error, so a crash or a failed write never leaves a partial file at
+// filePath: readers find either the previous file or the complete new one. The temporary file is removed if the
+// write fails. If filePath is the URI of a storage (see storageOf), the file is written in memory and put in the
+// storage once write returns without error instead. Files of a round directory written during a run of the prover are
+// recorded in its audit log (see beginProverAudit).
+func writeFile(filePath string, write func(w io.Writer) error) error {
+	storage, key, err := storageOf(filePath)
+	if err != nil {
+		return err
+	}
+	audit := proverAuditLogOf(filePath)
+	digest := sha256.New()
+	compressedWrite := func(w io.Writer) error {
+		writer, err := newCompressingWriter(w, filePath)
+		if err != nil {
+			return err
+		}
+		if audit != nil {
+			return errors.Join(write(io.MultiWriter(writer, digest)), writer.Close())
+		}
+		return errors.Join(write(writer), writer.Close())
+	}
+	if storage != nil {
+		var buffer bytes.Buffer
+		if err := compressedWrite(&buffer); err != nil {
+			return err
+		}
+		return storage.Put(key, buffer.Bytes())
+	}
+	if err := writeLocalFile(filePath, compressedWrite); err != nil {
+		return err
+	}
+	return audit.recordFile(PROVER_AUDIT_WRITE, filePath, digest.Sum(nil))
+}
+
+// writeLocalFile writes a local file at filePath with the write function, atomically (see writeFile).
+func writeLocalFile(filePath string, write func(w io.Writer) error) (err error) {
+	options := GetOptions()
+	directory, name := filepath.Split(filePath)
+	file, err := os.CreateTemp(directory, "."+name+TEMP_FILE_PATTERN)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// the temporary file is removed unless it was renamed, including when write panics
+		if file != nil {
+			file.Close()
+			os.Remove(file.Name())
+		}
+	}()
+
+	if err := write(file); err != nil {
+		return err
+	}
+	if options.SyncFileWrites {
+		if err := file.Sync(); err != nil {
+			return err
+		}
+	}
+	// os.CreateTemp creates files readable by their owner only, unlike os.Create
+	if err := file.Chmod(WRITTEN_FILE_MODE); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := runJournalOf(filePath).recordWrite(filePath); err != nil {
+		return err
+	}
+	if err := os.Rename(file.Name(), filePath); err != nil {
+		return err
+	}
+	file = nil
+	if options.SyncFileWrites {
+		return syncDirectory(directory)
+	}
+	return nil
+}
+
+// syncDirectory flushes the entries of the directory to disk, e.g. a file renamed into it.
+func syncDirectory(directory string) error {
+	if directory == "" {
+		directory = "."
+	}
+	dir, err := os.Open(directory)
+	if err != nil {
+		return err
+	}
+	return errors.Join(dir.Sync(), dir.Close())
+}
@@ -0,0 +1,48 @@
+package core
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/consensys/gnark/test"
+)
+
+func TestWriteFileIsAtomic(t *testing.T) {
+	assert := test.NewAssert(t)
+	dir := t.TempDir() + "/"
+
+	// a complete file replaces the previous one
+	WriteDataToFile(dir+"proof.json", proofLower0)
+	WriteDataToFile(dir+"proof.json", proofLower1)
+	assert.Equal(proofLower1, ReadDataFromFile[CompletedProof](dir+"proof.json"))
+	info, err := os.Stat(dir + "proof.json")
+	assert.NoError(err)
+	assert.Equal(os.FileMode(WRITTEN_FILE_MODE), info.Mode().Perm()&WRITTEN_FILE_MODE)
+
+	// a failed or interrupted write leaves the previous file, and no temporary file
+	assert.Error(writeFile(dir+"proof.json", func(w io.Writer) error {
+		w.Write([]byte("{"))
+		return errors.New("disk full")
+	}))
+	assert.Panics(func() {
+		writeFile(dir+"proof.json", func(w io.Writer) error {
+			w.Write([]byte("{"))
+			panic("interrupted")
+		})
+	})
+	assert.Error(writeJson(dir+"unencodable.json", func() {}))
+	assert.Equal(proofLower1, ReadDataFromFile[CompletedProof](dir+"proof.json"))
+	_, err = os.Stat(dir + "unencodable.json")
+	assert.True(os.IsNotExist(err))
+	entries, err := filepath.Glob(dir + "*" + TEMP_FILE_PATTERN)
+	assert.NoError(err)
+	assert.Equal(0, len(entries), "expected no temporary files, found %v", entries)
+
+	// synced writes are written the same way
+	setOptions(t, func(options *Options) { options.SyncFileWrites = true })
+	WriteDataToFile(dir+"synced.json.gz", proofLower0)
+	assert.Equal(proofLower0, ReadDataFromFile[CompletedProof](dir+"synced.json.gz"))
+}
@@ -1,13 +1,15 @@
 package core
 
 import (
-	"strconv"
-
 	"bitgo.com/proof_of_reserves/circuit"
 )
 
-// GenerateData generates test data and writes it to files for development/testing purposes.
+// GenerateData generates test data and writes it to files for development/testing purposes, with accounts
+// distributed according to Options.TestDataProfile. Every account gets a random salt. With Options.AggregateUserAccounts, every third account is a second account of the user of the previous one,
+// and the merkle roots of each batch are those of its accounts merged by user. The batches are written to the round
+// directory of Options.ProofEpoch in outDir (see Options.OutputLayout).
 func GenerateData(batchCount int, countPerBatch int, outDir string) {
+	options := GetOptions()
 	// create base seed for generating accounts with outDir
 	baseSeed := 0
 	for i := range outDir {
@@ -15,13 +17,28 @@ func GenerateData(batchCount int, countPerBatch int, outDir string) {
 	}
 
 	// for each batch, generate a file with test data
+	outDir = options.OutputLayout.RoundDir(outDir)
+	panicOnError(createRoundDirectories(outDir), "error creating round directories")
 	for i := 0; i < batchCount; i++ {
-		filePath := outDir + SECRET_DATA_PREFIX + strconv.Itoa(i) + ".json"
+		filePath := outDir + options.OutputLayout.secretDataFile(i)
 
+		// accounts are salted like real accounts, so the merkle roots are computed after salting
 		var secretData ProofElements
-		var assetSum circuit.GoBalance
-		secretData.Accounts, assetSum, secretData.MerkleRoot, secretData.MerkleRootWithAssetSumHash = circuit.GenerateTestData(countPerBatch, baseSeed+i)
+		secretData.Accounts, _, _, _ = circuit.GenerateTestDataWithProfile(countPerBatch, baseSeed+i, options.TestDataProfile)
+		if options.AggregateUserAccounts {
+			for j := 2; j < len(secretData.Accounts); j += 3 {
+				secretData.Accounts[j].WalletId = secretData.Accounts[j-1].WalletId
+			}
+		}
+		circuit.SaltAccounts(secretData.Accounts)
+		assetSum := circuit.SumGoAccountBalances(secretData.Accounts)
 		secretData.AssetSum = &assetSum
+		leafAccounts := secretData.Accounts
+		if options.AggregateUserAccounts {
+			leafAccounts = circuit.AggregateAccountsByUserId(secretData.Accounts)
+		}
+		secretData.MerkleRoot = circuit.GoComputeMerkleRootFromAccounts(leafAccounts)
+		secretData.MerkleRootWithAssetSumHash = circuit.GoComputeRootWithAssetSumHash(secretData.MerkleRoot, assetSum, 0)
 
 		// write to file
 		WriteDataToFile(filePath, secretData)
@@ -0,0 +1,325 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"bitgo.com/proof_of_reserves/verification"
+)
+
+// names of the checks run on every round by VerifyHistoricalRounds, in order
+const (
+	ROUND_CHECK_FILES        = "files"
+	ROUND_CHECK_METADATA     = "metadata"
+	ROUND_CHECK_PROOFS       = "proofs"
+	ROUND_CHECK_MERKLE_PATHS = "merkle paths"
+	ROUND_CHECK_ASSET_SUM    = "asset sum"
+	ROUND_CHECK_PROVENANCE   = "provenance"
+	ROUND_CHECK_TRANSITION   = "transition"
+
+	ROUND_CHECK_PASSED  = "pass"
+	ROUND_CHECK_SKIPPED = "skipped"
+)
+
+var roundChecks = []string{
+	ROUND_CHECK_FILES, ROUND_CHECK_METADATA, ROUND_CHECK_PROOFS, ROUND_CHECK_MERKLE_PATHS,
+	ROUND_CHECK_ASSET_SUM, ROUND_CHECK_PROVENANCE, ROUND_CHECK_TRANSITION,
+}
+
+// RoundCompatibility is the row of a CompatibilityReport for one round.
+type RoundCompatibility struct {
+	// Round is the name of the round's directory in the archive.
+	Round            string
+	CircuitVersion   int
+	TreeDepth        int
+	DeploymentDomain string
+	BatchCount       int
+	// Checks maps the name of each check to ROUND_CHECK_PASSED, ROUND_CHECK_SKIPPED, or the error it failed with.
+	Checks     map[string]string
+	Compatible bool
+}
+
+// CompatibilityReport is the result of verifying an archive of historical rounds with the current verifier.
+type CompatibilityReport struct {
+	VerifierCircuitVersion int
+	VerifierTreeDepth      int
+	// Checks are the names of the checks run on every round, in order.
+	Checks     []string
+	Rounds     []RoundCompatibility
+	Compatible bool
+}
+
+// publicRound holds the public artifacts of a round. Its midLevelProofs are the proofs of the levels between the bottom
+// and top levels, by level from the mid level up, and config is the configuration its proofs were generated with (see
+// verification.ProofsConfig).
+type publicRound struct {
+	outDir            string
+	metadata          RoundMetadata
+	plan              LayerPlan
+	bottomLevelProofs []CompletedProof
+	midLevelProofs    [][]CompletedProof
+	topLevelProof     CompletedProof
+	config            circuit.Config
+}
+
+// publicRoundVerifiers returns the checks of a round by the version of the circuit it was generated with.
+// Verifiers of old versions must be kept, so published rounds can still be verified. Rounds that did not
+// record their circuit version were generated with version 1.
+var publicRoundVerifiers = map[int]func(round publicRound) map[string]func() error{
+	1: publicRoundChecksV1,
+	// version 2 salts account leaves, which are hashed in the bottom level proofs and never in the public checks, and
+	// version 3 makes the account count public, which the checks verify whenever proofs record it, and version 4 binds
+	// the proofs to an epoch, which is checked when the round is read, and version 5 tags account and node hashes with
+	// the hash version its proofs record, which is read with the round, and version 6 range checks asset sums,
+	// which only constrains the proofs, and version 7 makes the circuit version public, which proofs record
+	2: publicRoundChecksV1,
+	3: publicRoundChecksV1,
+	4: publicRoundChecksV1,
+	5: publicRoundChecksV1,
+	6: publicRoundChecksV1,
+	7: publicRoundChecksV1,
+}
+
+// publicRoundChecksV1 returns the checks of a round generated with version 1 of the circuit, which only need the
+// public artifacts of the round.
+func publicRoundChecksV1(round publicRound) map[string]func() error {
+	checks := map[string]func() error{
+		ROUND_CHECK_METADATA: func() error {
+			if err := verifyLayerPlanMatchesMetadata(round.plan, round.metadata); err != nil {
+				return err
+			}
+			return verifyTreeDepth(round.metadata, round.config)
+		},
+		ROUND_CHECK_PROOFS: func() error {
+			for i, proof := range round.bottomLevelProofs {
+				if err := verifyProof(proof, bottomLevelLayer.name); err != nil {
+					return fmt.Errorf("bottom level proof %d: %w", i, err)
+				}
+			}
+			for level, proofs := range round.midLevelProofs {
+				for i, proof := range proofs {
+					if err := verifyProof(proof, levelLayer(level+1, round.plan.Levels()).name); err != nil {
+						return fmt.Errorf("%s level proof %d: %w", levelLayer(level+1, round.plan.Levels()).name, i, err)
+					}
+				}
+			}
+			if err := verifyProof(round.topLevelProof, topLevelLayer.name); err != nil {
+				return fmt.Errorf("top level proof: %w", err)
+			}
+
+			// the upper level proofs must cover every lower level proof
+			for level, proofs := range round.midLevelProofs {
+				for i, proof := range proofs {
+					if err := verifyAccountCount(proof, round.plan.LevelProofSize(level+1, i)); err != nil {
+						return fmt.Errorf("%s level proof %d: %w", levelLayer(level+1, round.plan.Levels()).name, i, err)
+					}
+				}
+			}
+			if err := verifyAccountCount(round.topLevelProof, len(round.midLevelProofs[len(round.midLevelProofs)-1])); err != nil {
+				return fmt.Errorf("top level proof: %w", err)
+			}
+			return nil
+		},
+		ROUND_CHECK_MERKLE_PATHS: func() error {
+			for i, proof := range round.bottomLevelProofs {
+				if hasAllMerkleNodes(proof, round.config) {
+					if err := verifyBuild(proof.MerkleNodes, proof.MerkleRoot, round.config); err != nil {
+						return fmt.Errorf("merkle nodes of bottom level proof %d: %w", i, err)
+					}
+				}
+				midLevelProof := round.midLevelProofs[0][round.plan.MidLevelProofIndex(i)]
+				if err := verification.VerifyMerklePath(proof.MerkleRootWithAssetSumHash, proof.MerklePosition, proof.MerklePath, midLevelProof.MerkleRoot, round.config); err != nil {
+					return fmt.Errorf("bottom level proof %d: %w", i, err)
+				}
+			}
+			for level, proofs := range round.midLevelProofs {
+				for i, proof := range proofs {
+					upperProof := round.topLevelProof
+					if level+1 < len(round.midLevelProofs) {
+						upperProof = round.midLevelProofs[level+1][round.plan.UpperLevelProofIndex(i)]
+					}
+					if err := verification.VerifyMerklePath(proof.MerkleRootWithAssetSumHash, proof.MerklePosition, proof.MerklePath, upperProof.MerkleRoot, round.config); err != nil {
+						return fmt.Errorf("%s level proof %d: %w", levelLayer(level+1, round.plan.Levels()).name, i, err)
+					}
+				}
+			}
+			return nil
+		},
+		ROUND_CHECK_ASSET_SUM: func() error {
+			return verification.VerifyTopLayerProofMatchesAssetSum(round.topLevelProof, round.config)
+		},
+	}
+
+	// rounds generated before provenance statements were introduced have none, so the check is skipped for them
+	// (and reported as such) rather than passed
+	if round.metadata.ProvenanceHash != "" || fileExists(round.outDir+PROVENANCE_FILE) {
+		checks[ROUND_CHECK_PROVENANCE] = func() error {
+			return verifyProvenance(round.plan.BottomLevelProofCount, round.outDir, round.metadata, false, round.config)
+		}
+	}
+	return checks
+}
+
+// runRoundCheck runs a check, converting a panic into an error.
+func runRoundCheck(check func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	return check()
+}
+
+// countBottomLevelProofs returns the number of bottom level proofs in outDir, for rounds that did not record their
+// layer plan.
+func countBottomLevelProofs(outDir string) int {
+	count := 0
+	for {
+		if !fileExists(outDir + bottomLevelLayer.publicPrefix() + strconv.Itoa(count) + ".json") {
+			return count
+		}
+		count++
+	}
+}
+
+// readPublicRound reads the public artifacts of the round in outDir.
+func readPublicRound(outDir string) publicRound {
+	round := publicRound{outDir: outDir, metadata: readRoundMetadata(outDir)}
+
+	// the layer plan depends on the tree depth the round was generated with, which the top level proof records
+	round.topLevelProof = readPublishedProof(outDir, topLevelLayer, 0)
+	treeDepth, err := verification.ProofsTreeDepth([]CompletedProof{round.topLevelProof})
+	panicOnError(err, "tree depth verification failed")
+	round.config = circuit.GetConfig()
+	round.config.TreeDepth = treeDepth
+
+	round.plan = round.metadata.LayerPlan.withConfig(round.config)
+	if round.metadata.LayerPlan == (LayerPlan{}) {
+		round.plan = LayerPlan{}.withConfig(round.config).withLevels(countBottomLevelProofs(outDir), MIN_HIERARCHY_LEVELS)
+	}
+	panicOnError(round.plan.Validate(), "invalid number of bottom level proofs")
+	round.bottomLevelProofs = readPublishedProofs(round.plan.BottomLevelProofCount, outDir, bottomLevelLayer)
+	round.midLevelProofs = readMidLevelProofs(round.plan, outDir)
+	if round.topLevelProof.AssetSum == nil {
+		panic("top level proof has no AssetSum")
+	}
+	panicOnError(verification.VerifyProofsBelongToRound(round.metadata.RoundId, "bottom", round.bottomLevelProofs), "round id verification failed")
+	allProofs := append([]CompletedProof{}, round.bottomLevelProofs...)
+	for level, proofs := range round.midLevelProofs {
+		panicOnError(verification.VerifyProofsBelongToRound(round.metadata.RoundId, levelLayer(level+1, round.plan.Levels()).name, proofs), "round id verification failed")
+		allProofs = append(allProofs, proofs...)
+	}
+	panicOnError(verification.VerifyProofsBelongToRound(round.metadata.RoundId, "top", []CompletedProof{round.topLevelProof}), "round id verification failed")
+	allProofs = append(allProofs, round.topLevelProof)
+	round.config, err = verification.ProofsConfig(allProofs)
+	panicOnError(err, "proof parameters verification failed")
+	panicOnError(verification.VerifyAssetRegistry(allProofs), "asset registry verification failed")
+	assetSubset, err := verification.ProofsAssetSubset(allProofs)
+	panicOnError(err, "asset subset verification failed")
+	panicOnError(verifyAssetSubsetMatchesMetadata(assetSubset, round.metadata), "asset subset verification failed")
+	_, err = verification.ProofsEpoch(allProofs)
+	panicOnError(err, "epoch verification failed")
+	return round
+}
+
+// verifyPublicRound runs every check on the public artifacts of the round in outDir, dispatching on the circuit
+// version the round was generated with. The transition from the previous round is checked if previous is not nil.
+// The deployment domain is set to the round's for the duration of the checks, which hash accounts and nodes with the
+// configuration the round was generated with (see verification.ProofsConfig).
+func verifyPublicRound(name string, outDir string, previous *RoundMetadata) (RoundCompatibility, *RoundMetadata) {
+	result := RoundCompatibility{Round: name, Checks: make(map[string]string), Compatible: true}
+	record := func(check string, err error) {
+		if err != nil {
+			result.Checks[check] = err.Error()
+			result.Compatible = false
+		} else {
+			result.Checks[check] = ROUND_CHECK_PASSED
+		}
+	}
+	skip := func(checks []string, reason string) {
+		for _, check := range checks {
+			if _, ok := result.Checks[check]; !ok {
+				result.Checks[check] = reason
+			}
+		}
+	}
+
+	var round publicRound
+	err := runRoundCheck(func() error {
+		round = readPublicRound(outDir)
+		return nil
+	})
+	record(ROUND_CHECK_FILES, err)
+	if err != nil {
+		skip(roundChecks, ROUND_CHECK_SKIPPED)
+		return result, nil
+	}
+	result.CircuitVersion = max(round.metadata.CircuitVersion, 1)
+	result.TreeDepth = round.metadata.TreeDepth
+	result.DeploymentDomain = round.metadata.DeploymentDomain
+	result.BatchCount = round.plan.BottomLevelProofCount
+
+	verifier, ok := publicRoundVerifiers[result.CircuitVersion]
+	if !ok {
+		result.Compatible = false
+		skip(roundChecks, fmt.Sprintf("unsupported circuit version %d", result.CircuitVersion))
+		return result, &round.metadata
+	}
+
+	defer circuit.SetDeploymentDomain(circuit.GetDeploymentDomain())
+	circuit.SetDeploymentDomain(round.metadata.DeploymentDomain)
+	checks := verifier(round)
+	for _, check := range roundChecks {
+		if run, ok := checks[check]; ok {
+			record(check, runRoundCheck(run))
+		}
+	}
+	if previous != nil {
+		record(ROUND_CHECK_TRANSITION, VerifyRoundTransition(*previous, round.metadata))
+	}
+	skip(roundChecks, ROUND_CHECK_SKIPPED)
+	return result, &round.metadata
+}
+
+// VerifyHistoricalRounds verifies the public artifacts of every round in archiveDir with the current verifier, so
+// releases can assert they still verify every published round. Each subdirectory of archiveDir is a round with the
+// layout of OUT_DIR (only its public directory is needed). Rounds are verified in the order of their names, which
+// should therefore sort chronologically (e.g. dates), and the transition between consecutive rounds is checked too.
+// As the deployment domain is changed while each round is verified, it must not be called concurrently with other
+// verification.
+func VerifyHistoricalRounds(archiveDir string) CompatibilityReport {
+	entries, err := os.ReadDir(archiveDir)
+	panicOnError(err, "error reading round archive")
+	names := make([]string, 0)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	report := CompatibilityReport{
+		VerifierCircuitVersion: circuit.CIRCUIT_VERSION,
+		VerifierTreeDepth:      circuit.GetTreeDepth(),
+		Checks:                 roundChecks,
+		Rounds:                 make([]RoundCompatibility, 0, len(names)),
+		Compatible:             true,
+	}
+	var previous *RoundMetadata
+	for _, name := range names {
+		var result RoundCompatibility
+		result, previous = verifyPublicRound(name, filepath.Join(archiveDir, name)+"/", previous)
+		report.Rounds = append(report.Rounds, result)
+		report.Compatible = report.Compatible && result.Compatible
+	}
+	return report
+}
+
+// WriteCompatibilityReport writes the report to the given path.
+func WriteCompatibilityReport(path string, report CompatibilityReport) {
+	panicOnError(writeJson(path, report), "error writing compatibility report")
+}
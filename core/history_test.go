@@ -0,0 +1,97 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/consensys/gnark/test"
+)
+
+// copyPublicRound copies the public directory of the round in outDir to the round directory in the archive.
+func copyPublicRound(t *testing.T, outDir string, archiveDir string, round string) string {
+	roundDir := filepath.Join(archiveDir, round) + "/"
+	if err := os.CopyFS(roundDir+"public", os.DirFS(outDir+"public")); err != nil {
+		t.Fatal(err)
+	}
+	return roundDir
+}
+
+func TestVerifyHistoricalRounds(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	archiveDir := t.TempDir()
+	copyPublicRound(t, testOutDir, archiveDir, "2024-01")
+	copyPublicRound(t, testAltDir, archiveDir, "2024-02")
+
+	report := VerifyHistoricalRounds(archiveDir)
+	assert.True(report.Compatible, "expected every round to verify: %+v", report.Rounds)
+	assert.Equal(2, len(report.Rounds))
+	assert.Equal("2024-01", report.Rounds[0].Round)
+	assert.Equal(batchCount, report.Rounds[0].BatchCount)
+	assert.Equal(ROUND_CHECK_SKIPPED, report.Rounds[0].Checks[ROUND_CHECK_TRANSITION])
+	for _, check := range report.Checks[:len(report.Checks)-1] {
+		assert.Equal(ROUND_CHECK_PASSED, report.Rounds[0].Checks[check], check)
+	}
+	assert.Equal(ROUND_CHECK_PASSED, report.Rounds[1].Checks[ROUND_CHECK_TRANSITION])
+
+	t.Run("Legacy round", func(t *testing.T) {
+		// rounds without round metadata, provenance, or round ids are verified from their proofs alone
+		archiveDir := t.TempDir()
+		roundDir := copyPublicRound(t, testOutDir, archiveDir, "legacy")
+		assert.NoError(os.Remove(roundDir + ROUND_METADATA_FILE))
+		assert.NoError(os.Remove(roundDir + PROVENANCE_FILE))
+		proofFiles, err := filepath.Glob(roundDir + "public/*_proof_*.json")
+		assert.NoError(err)
+		for _, proofFile := range proofFiles {
+			proof := ReadDataFromFile[CompletedProof](proofFile)
+			proof.RoundId = ""
+			WriteDataToFile(proofFile, proof)
+		}
+		report := VerifyHistoricalRounds(archiveDir)
+		assert.True(report.Compatible, "expected legacy round to verify: %+v", report.Rounds)
+		assert.Equal(batchCount, report.Rounds[0].BatchCount)
+		assert.Equal(1, report.Rounds[0].CircuitVersion)
+	})
+
+	t.Run("Tampered round", func(t *testing.T) {
+		archiveDir := t.TempDir()
+		roundDir := copyPublicRound(t, testOutDir, archiveDir, "tampered")
+		proof := ReadDataFromFile[CompletedProof](roundDir + BOTTOM_PROOF_PREFIX + "0.json")
+		proof.MerklePosition = 1
+		WriteDataToFile(roundDir+BOTTOM_PROOF_PREFIX+"0.json", proof)
+		report := VerifyHistoricalRounds(archiveDir)
+		assert.False(report.Compatible)
+		assert.NotEqual(ROUND_CHECK_PASSED, report.Rounds[0].Checks[ROUND_CHECK_MERKLE_PATHS])
+		assert.NotEqual(ROUND_CHECK_PASSED, report.Rounds[0].Checks[ROUND_CHECK_PROVENANCE])
+		assert.Equal(ROUND_CHECK_PASSED, report.Rounds[0].Checks[ROUND_CHECK_PROOFS])
+	})
+
+	t.Run("Mixed rounds", func(t *testing.T) {
+		archiveDir := t.TempDir()
+		roundDir := copyPublicRound(t, testOutDir, archiveDir, "mixed")
+		WriteDataToFile(roundDir+TOP_PROOF_PREFIX+"0.json", altProofTop)
+		report := VerifyHistoricalRounds(archiveDir)
+		assert.False(report.Compatible)
+		assert.Contains(report.Rounds[0].Checks[ROUND_CHECK_FILES], "artifacts of different rounds are mixed")
+	})
+
+	t.Run("Unsupported circuit version", func(t *testing.T) {
+		archiveDir := t.TempDir()
+		roundDir := copyPublicRound(t, testOutDir, archiveDir, "future")
+		metadata := readRoundMetadata(roundDir)
+		metadata.CircuitVersion = 1000
+		assert.NoError(writeJson(roundDir+ROUND_METADATA_FILE, metadata))
+		report := VerifyHistoricalRounds(archiveDir)
+		assert.False(report.Compatible)
+		assert.Equal("unsupported circuit version 1000", report.Rounds[0].Checks[ROUND_CHECK_PROOFS])
+	})
+
+	t.Run("Missing files", func(t *testing.T) {
+		archiveDir := t.TempDir()
+		assert.NoError(os.MkdirAll(filepath.Join(archiveDir, "empty"), 0o755))
+		report := VerifyHistoricalRounds(archiveDir)
+		assert.False(report.Compatible)
+		assert.NotEqual(ROUND_CHECK_PASSED, report.Rounds[0].Checks[ROUND_CHECK_FILES])
+	})
+}
@@ -0,0 +1,31 @@
+//go:build icicle
+
+package core
+
+import (
+	"sync"
+
+	"github.com/consensys/gnark/backend/groth16"
+	groth16bn254 "github.com/consensys/gnark/backend/groth16/bn254"
+	iciclegroth16 "github.com/consensys/gnark/backend/groth16/bn254/icicle"
+)
+
+// icicleAvailable reports whether the binary is built with the icicle build tag, which links gnark's ICICLE GPU prover
+// (see SetGPUProving).
+const icicleAvailable = true
+
+// icicleProvingKeys are the ICICLE proving keys of the plain BN254 proving keys converted by icicleProvingKey, so the
+// key of a circuit is only copied to the device once.
+var icicleProvingKeys sync.Map
+
+// icicleProvingKey returns the proving key as gnark's prover expects it in binaries built with the icicle build tag,
+// where it proves with the ICICLE prover whether or not the GPU is used. Keys set up or read by gnark already are, but
+// those extracted from an MPC setup ceremony (see mpcsetup.ExtractKeys) are plain BN254 keys, which are converted.
+func icicleProvingKey(pk groth16.ProvingKey) groth16.ProvingKey {
+	plain, ok := pk.(*groth16bn254.ProvingKey)
+	if !ok {
+		return pk
+	}
+	converted, _ := icicleProvingKeys.LoadOrStore(plain, &iciclegroth16.ProvingKey{ProvingKey: *plain})
+	return converted.(*iciclegroth16.ProvingKey)
+}
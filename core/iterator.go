@@ -0,0 +1,171 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"bitgo.com/proof_of_reserves/circuit"
+)
+
+// AccountIterator yields the accounts of a snapshot one at a time, e.g. from a database cursor.
+type AccountIterator interface {
+	// Next returns the next account, or io.EOF once every account has been returned.
+	Next(ctx context.Context) (circuit.GoAccount, error)
+}
+
+// ProveOptions controls how ProveFromIterator generates proofs.
+type ProveOptions struct {
+	// OutDir is the directory the batches and proofs are written to (defaults to OUT_DIR).
+	OutDir string
+	// BatchSize is the number of accounts in each bottom level batch (defaults to, and must not exceed,
+	// ACCOUNTS_PER_BATCH).
+	BatchSize int
+	// Lookahead is the number of batches read from the iterator ahead of the batch being proven (defaults to 1).
+	Lookahead int
+	// Progress is called with the progress of the proofs, if set (see WithProgress). The number of bottom level proofs
+	// is only known once the iterator is exhausted, so the Total of the events grows with each batch read.
+	Progress func(ProgressEvent)
+}
+
+// iteratorBatch is a batch of accounts read from an AccountIterator, or the error that stopped reading.
+type iteratorBatch struct {
+	accounts []circuit.GoAccount
+	err      error
+}
+
+// readBatches reads accounts from the iterator in a separate goroutine and sends them in batches of batchSize
+// accounts on the returned channel, which buffers up to lookahead batches. The channel is closed after the last
+// batch, after an error (which is sent as the last batch), or once ctx is done.
+func readBatches(ctx context.Context, iterator AccountIterator, batchSize int, lookahead int) <-chan iteratorBatch {
+	batches := make(chan iteratorBatch, lookahead)
+	go func() {
+		defer close(batches)
+		send := func(batch iteratorBatch) bool {
+			select {
+			case batches <- batch:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		accounts := make([]circuit.GoAccount, 0, batchSize)
+		for {
+			account, err := iterator.Next(ctx)
+			if errors.Is(err, io.EOF) {
+				if len(accounts) > 0 {
+					send(iteratorBatch{accounts: accounts})
+				}
+				return
+			}
+			if err != nil {
+				send(iteratorBatch{err: fmt.Errorf("error reading account from iterator: %w", err)})
+				return
+			}
+			accounts = append(accounts, account)
+			if len(accounts) == batchSize {
+				if !send(iteratorBatch{accounts: accounts}) {
+					return
+				}
+				accounts = make([]circuit.GoAccount, 0, batchSize)
+			}
+		}
+	}()
+	return batches
+}
+
+// ProveFromIterator generates the proofs of a round from accounts pulled lazily from the iterator, for snapshots
+// that cannot be rewound or fully materialized. Accounts are formed into batches on the fly, and each batch is
+// written to the secret directory and proven as soon as it is read, so only the roots and asset sums of the bottom
+// level proofs are kept in memory. Accounts without a salt get a random one (see circuit.SaltAccounts). The
+// exclusion list in OutDir (if any) is applied as in Prove. Returns the number of batches, which is needed to verify
+// the round, or an error instead of panicking.
+func ProveFromIterator(ctx context.Context, iterator AccountIterator, opts ProveOptions) (batchCount int, err error) {
+	defer recoverError(&err, "proving from iterator failed")
+
+	options := GetOptions()
+	startedOn := time.Now()
+	roundId := newRoundId()
+	if opts.OutDir == "" {
+		opts.OutDir = OUT_DIR
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = circuit.GetAccountsPerBatch()
+	}
+	if opts.BatchSize > circuit.GetAccountsPerBatch() {
+		return 0, fmt.Errorf("batch size %d exceeds the maximum of %d accounts per batch", opts.BatchSize, circuit.GetAccountsPerBatch())
+	}
+	if opts.Lookahead <= 0 {
+		opts.Lookahead = 1
+	}
+	if err := options.ProofPublicationPolicy.Validate(); err != nil {
+		return 0, err
+	}
+	opts.OutDir = options.OutputLayout.RoundDir(opts.OutDir)
+	if err := createRoundDirectories(opts.OutDir); err != nil {
+		return 0, err
+	}
+
+	// stop reading from the iterator if proving fails
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	exclusions := readExclusionList(opts.OutDir)
+	var excludedAccounts []ExcludedAccountReport
+	sparseTree := newSparseTree()
+	progress := newProgressReporter(opts.Progress, 0)
+	bottomLevelProofs := make([]CompletedProof, 0)
+	for batch := range readBatches(ctx, iterator, opts.BatchSize, opts.Lookahead) {
+		if batch.err != nil {
+			return 0, batch.err
+		}
+		index := len(bottomLevelProofs)
+		if err := NewLayerPlan(index + 1).Validate(); err != nil {
+			return 0, err
+		}
+
+		// unsalted accounts are salted, and the batch is written (with the salts users need to verify their leaves)
+		// before excluding accounts, the same way batches are read by Prove
+		circuit.SaltAccounts(batch.accounts)
+		assetSum := circuit.SumGoAccountBalances(batch.accounts)
+		proofElements := []ProofElements{{Accounts: batch.accounts, AssetSum: &assetSum}}
+		WriteDataToFile(opts.OutDir+options.OutputLayout.secretDataFile(index), proofElements[0])
+		if options.AggregateUserAccounts {
+			applyAccountAggregation(proofElements, circuit.GetConfig())
+		}
+		excludedAccounts = append(excludedAccounts, applyExclusionList(proofElements, index, exclusions)...)
+		applyDummyAccountPadding(proofElements, index, options.DummyAccountPadding, opts.OutDir)
+		addToSparseTree(sparseTree, proofElements, index)
+
+		if err := verifyDistinctWalletIds(proofElements[0].Accounts); err != nil {
+			return 0, fmt.Errorf("duplicate accounts in batch %d: %w", index, err)
+		}
+
+		// write the proof with its merkle nodes right away, and only keep what the upper levels need
+		progress.plan(1)
+		proof := generateProof(proofElements[0], progress.forBatch(bottomLevelLayer.name, index))
+		proof.RoundId = roundId
+		writeProof(proof, opts.OutDir, bottomLevelLayer, index)
+		bottomLevelProofs = append(bottomLevelProofs, retainForUpperLevels(proof))
+	}
+	// the iterator stops without an error when ctx is done
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	plan := NewLayerPlan(len(bottomLevelProofs))
+	if err := plan.Validate(); err != nil {
+		return 0, err
+	}
+	progress.plan(plan.UpperLevelProofCount())
+	upperLevelProofs := generateUpperLevelProofs(bottomLevelProofs, plan, progress)
+
+	writeBottomLevelMerklePaths(bottomLevelProofs, opts.OutDir)
+
+	// the batches are those read from the iterator, so a tree depth migration in the output directory is not theirs
+	writeRound(opts.OutDir, roundId, "prove-from-iterator", plan, nil, upperLevelProofs, exclusions, excludedAccounts, sparseTreeRoot(sparseTree), startedOn)
+	return plan.BottomLevelProofCount, nil
+}
@@ -0,0 +1,76 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"github.com/consensys/gnark/test"
+)
+
+// sliceAccountIterator iterates over a slice of accounts, optionally failing after a number of accounts.
+type sliceAccountIterator struct {
+	accounts  []circuit.GoAccount
+	next      int
+	failAfter int
+}
+
+func (it *sliceAccountIterator) Next(ctx context.Context) (circuit.GoAccount, error) {
+	if err := ctx.Err(); err != nil {
+		return circuit.GoAccount{}, err
+	}
+	if it.failAfter > 0 && it.next == it.failAfter {
+		return circuit.GoAccount{}, errors.New("cursor closed")
+	}
+	if it.next == len(it.accounts) {
+		return circuit.GoAccount{}, io.EOF
+	}
+	it.next++
+	return it.accounts[it.next-1], nil
+}
+
+func TestProveFromIterator(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// 20 accounts in batches of 16 give a full batch and a partial batch
+	accounts := append(append([]circuit.GoAccount{}, testData0.Accounts...), testData1.Accounts[:4]...)
+	outDir := t.TempDir() + "/"
+	batchCount, err := ProveFromIterator(context.Background(), &sliceAccountIterator{accounts: accounts}, ProveOptions{OutDir: outDir, BatchSize: countPerBatch, Lookahead: 2})
+	assert.NoError(err)
+	assert.Equal(2, batchCount)
+
+	// the round should verify like a round generated by Prove
+	assert.NotPanics(func() { VerifyFull(batchCount, outDir) })
+	assert.Equal(NewLayerPlan(2), readRoundMetadata(outDir).LayerPlan)
+
+	// the first batch has the same accounts as the first batch of the test round
+	bottomProof := ReadDataFromFile[CompletedProof](outDir + BOTTOM_PROOF_PREFIX + "0.json")
+	assert.Equal(proofLower0.MerkleRoot, bottomProof.MerkleRoot)
+	assert.Equal(proofLower0.MerkleNodes, bottomProof.MerkleNodes)
+	assert.Equal(0, bottomProof.MerklePosition)
+	assert.Equal(1, ReadDataFromFile[CompletedProof](outDir+BOTTOM_PROOF_PREFIX+"1.json").MerklePosition)
+}
+
+func TestProveFromIteratorFailures(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// iterator errors are returned
+	_, err := ProveFromIterator(context.Background(), &sliceAccountIterator{accounts: testData0.Accounts, failAfter: 1}, ProveOptions{OutDir: t.TempDir() + "/"})
+	assert.Error(err)
+
+	// an empty snapshot cannot be proven
+	_, err = ProveFromIterator(context.Background(), &sliceAccountIterator{}, ProveOptions{OutDir: t.TempDir() + "/"})
+	assert.Error(err)
+
+	// batches cannot exceed the maximum size
+	_, err = ProveFromIterator(context.Background(), &sliceAccountIterator{}, ProveOptions{OutDir: t.TempDir() + "/", BatchSize: circuit.ACCOUNTS_PER_BATCH + 1})
+	assert.Error(err)
+
+	// cancelled contexts stop proving
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = ProveFromIterator(ctx, &sliceAccountIterator{accounts: testData0.Accounts}, ProveOptions{OutDir: t.TempDir() + "/"})
+	assert.ErrorIs(err, context.Canceled)
+}
@@ -0,0 +1,168 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"bitgo.com/proof_of_reserves/circuit"
+)
+
+// GenerateKeyBundleSigningKey generates an ed25519 key for signing key bundles (or batch receipts) and writes its hex encoded seed to
+// the given path, which must not exist yet. Returns the hex encoded public key to distribute to verifiers.
+func GenerateKeyBundleSigningKey(path string) string {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	panicOnError(err, "error generating signing key")
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	panicOnError(err, "error creating signing key file")
+	defer func() {
+		panicOnError(file.Close(), "error closing signing key file")
+	}()
+	_, err = file.WriteString(hex.EncodeToString(privateKey.Seed()) + "\n")
+	panicOnError(err, "error writing signing key")
+	return hex.EncodeToString(publicKey)
+}
+
+// ReadKeyBundleSigningKey reads a signing key written by GenerateKeyBundleSigningKey.
+func ReadKeyBundleSigningKey(path string) ed25519.PrivateKey {
+	data, err := os.ReadFile(path)
+	panicOnError(err, "error reading signing key")
+	seed, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	panicOnError(err, "error decoding signing key")
+	if len(seed) != ed25519.SeedSize {
+		panic("signing key must be a hex encoded ed25519 seed")
+	}
+	return ed25519.NewKeyFromSeed(seed)
+}
+
+// BuildKeyBundle lists the distinct verification keys of each layer of the round in outDir, valid between
+// notBefore and notAfter.
+func BuildKeyBundle(batchCount int, outDir string, notBefore time.Time, notAfter time.Time) KeyBundle {
+	plan := roundLayerPlan(batchCount, readRoundMetadata(outDir), circuit.GetConfig())
+	panicOnError(plan.Validate(), "invalid number of batches")
+	if !notAfter.After(notBefore) {
+		panic("key bundle validity window must end after it starts")
+	}
+
+	bundle := KeyBundle{
+		CircuitVersion:   circuit.CIRCUIT_VERSION,
+		TreeDepth:        circuit.GetTreeDepth(),
+		AssetCount:       circuit.GetNumberOfAssets(),
+		DeploymentDomain: circuit.GetDeploymentDomain(),
+		NotBefore:        notBefore.UTC(),
+		NotAfter:         notAfter.UTC(),
+		Keys:             make([]KeyBundleEntry, 0),
+	}
+	for level := 0; level < plan.Levels(); level++ {
+		layer := levelLayer(level, plan.Levels())
+		entries := make(map[string]int)
+		for i := 0; i < plan.LevelProofCount(level); i++ {
+			// the proofs are read as raw proofs, as only the verification key is needed
+			var proof RawCompletedProof
+			panicOnError(readJson(outDir+layer.publicPrefix()+strconv.Itoa(i)+".json", &proof), "error reading proof")
+			if proof.VerificationKey == "" {
+				// the key of a proof that references it is published once (see Options.PublishVerificationKeys)
+				resolved := []CompletedProof{{RoundId: proof.RoundId, VerificationKeyFingerprint: proof.VerificationKeyFingerprint}}
+				resolvePublishedVerificationKeys(resolved, layer.name, outDir)
+				proof.VerificationKey = resolved[0].VerificationKey
+			}
+			fingerprint, err := ComputeVerificationKeyFingerprint(proof.VerificationKey)
+			panicOnError(err, "error computing verification key fingerprint")
+			if entry, ok := entries[fingerprint]; ok {
+				bundle.Keys[entry].ProofCount++
+				continue
+			}
+			entries[fingerprint] = len(bundle.Keys)
+			bundle.Keys = append(bundle.Keys, KeyBundleEntry{
+				Layer:           layer.name,
+				Fingerprint:     fingerprint,
+				VerificationKey: proof.VerificationKey,
+				ProofCount:      1,
+				Backend:         proof.Backend,
+			})
+		}
+	}
+	return bundle
+}
+
+// SignKeyBundle signs the key bundle with the given key.
+func SignKeyBundle(bundle KeyBundle, privateKey ed25519.PrivateKey) SignedKeyBundle {
+	payload, publicKey, signature := signJson(SIGNING_CONTEXT_KEY_BUNDLE, bundle, privateKey)
+	return SignedKeyBundle{Payload: payload, PublicKey: publicKey, Signature: signature}
+}
+
+// VerifyKeyBundle verifies that the bundle is signed by one of the trusted public keys, that it is valid at the
+// given time, and that it was generated for the circuit version, tree depth, assets, and deployment domain of this
+// build. Returns the verified bundle, whose fingerprints can then be pinned.
+func VerifyKeyBundle(signed SignedKeyBundle, trustedPublicKeys []ed25519.PublicKey, now time.Time) (KeyBundle, error) {
+	return VerifyKeyBundleForDomain(signed, trustedPublicKeys, now, circuit.GetDeploymentDomain())
+}
+
+// referenceVerificationKey replaces the verification key of the proof with its fingerprint.
+func referenceVerificationKey(proof CompletedProof) CompletedProof {
+	if proof.VerificationKey == "" {
+		return proof
+	}
+	fingerprint, err := ComputeVerificationKeyFingerprint(proof.VerificationKey)
+	panicOnError(err, "error computing verification key fingerprint")
+	proof.VerificationKey = ""
+	proof.VerificationKeyFingerprint = fingerprint
+	return proof
+}
+
+// ReferenceVerificationKeys replaces the verification keys of the proofs in a user verification package with their
+// fingerprints. The verification keys are shared by every user of a round and make up most of a package, so packages
+// that reference them are much smaller. They are resolved from a key bundle with ResolveVerificationKeys before
+// verification.
+func ReferenceVerificationKeys(elements UserVerificationElements) UserVerificationElements {
+	elements.ProofInfo.BottomProof = referenceVerificationKey(elements.ProofInfo.BottomProof)
+	elements.ProofInfo.MiddleProof = referenceVerificationKey(elements.ProofInfo.MiddleProof)
+	intermediateProofs := make([]CompletedProof, len(elements.ProofInfo.IntermediateProofs))
+	for i, proof := range elements.ProofInfo.IntermediateProofs {
+		intermediateProofs[i] = referenceVerificationKey(proof)
+	}
+	if len(intermediateProofs) > 0 {
+		elements.ProofInfo.IntermediateProofs = intermediateProofs
+	}
+	elements.ProofInfo.TopProof = referenceVerificationKey(elements.ProofInfo.TopProof)
+	return elements
+}
+
+// ResolveBundledVerificationKeys resolves the verification keys of a user verification package like
+// ResolveVerificationKeys from the keys of a verified key bundle by fingerprint, and checks every proof's key is in
+// the bundle, including those the package comes with, so a package can't bypass the bundle by embedding its own keys.
+func ResolveBundledVerificationKeys(elements UserVerificationElements, verificationKeys map[string]string) (UserVerificationElements, error) {
+	elements, err := ResolveVerificationKeys(elements, verificationKeys)
+	if err != nil {
+		return UserVerificationElements{}, err
+	}
+	proofs := elements.ProofInfo.ProofChain()
+	for i, proof := range proofs {
+		layer := levelLayer(i, len(proofs)).name
+		fingerprint, err := ComputeVerificationKeyFingerprint(proof.VerificationKey)
+		if err != nil {
+			return UserVerificationElements{}, fmt.Errorf("invalid %s level verification key: %w", layer, err)
+		}
+		if _, ok := verificationKeys[fingerprint]; !ok {
+			return UserVerificationElements{}, fmt.Errorf("%s level verification key %s is not in the key bundle", layer, fingerprint)
+		}
+	}
+	return elements, nil
+}
+
+// ReadKeyBundle reads a signed key bundle from the given path.
+func ReadKeyBundle(path string) SignedKeyBundle {
+	var signed SignedKeyBundle
+	panicOnError(readJson(path, &signed), "error reading key bundle")
+	return signed
+}
+
+// WriteKeyBundle writes a signed key bundle to the given path.
+func WriteKeyBundle(path string, signed SignedKeyBundle) {
+	panicOnError(writeJson(path, signed), "error writing key bundle")
+}
@@ -0,0 +1,81 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"github.com/consensys/gnark/test"
+)
+
+func TestKeyBundle(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	keyPath := filepath.Join(t.TempDir(), "signing_key")
+	publicKeyHex := GenerateKeyBundleSigningKey(keyPath)
+	publicKey, err := hex.DecodeString(publicKeyHex)
+	assert.NoError(err)
+	trusted := []ed25519.PublicKey{publicKey}
+	assert.Panics(func() { GenerateKeyBundleSigningKey(keyPath) }, "existing signing keys should not be overwritten")
+
+	now := time.Now()
+	bundle := BuildKeyBundle(batchCount, testOutDir, now, now.Add(time.Hour))
+
+	// both bottom level proofs have the same shape, so they share a key
+	assert.Equal(3, len(bundle.Keys))
+	assert.Equal(KeyBundleEntry{Layer: "bottom", Fingerprint: bundle.Keys[0].Fingerprint, VerificationKey: proofLower0.VerificationKey, ProofCount: 2, Backend: proofLower0.Backend}, bundle.Keys[0])
+	assert.Equal("mid", bundle.Keys[1].Layer)
+	assert.Equal("top", bundle.Keys[2].Layer)
+	assert.Equal(circuit.CIRCUIT_VERSION, bundle.CircuitVersion)
+
+	// the bundle survives being written and read
+	bundlePath := filepath.Join(t.TempDir(), "key_bundle.json")
+	WriteKeyBundle(bundlePath, SignKeyBundle(bundle, ReadKeyBundleSigningKey(keyPath)))
+	signed := ReadKeyBundle(bundlePath)
+	verified, err := VerifyKeyBundle(signed, trusted, now.Add(time.Minute))
+	assert.NoError(err)
+	assert.Equal(bundle.Fingerprints(), verified.Fingerprints())
+
+	t.Run("Untrusted key", func(t *testing.T) {
+		otherPublicKey, _, err := ed25519.GenerateKey(nil)
+		assert.NoError(err)
+		_, err = VerifyKeyBundle(signed, []ed25519.PublicKey{otherPublicKey}, now.Add(time.Minute))
+		assert.Error(err)
+	})
+
+	t.Run("Outside validity window", func(t *testing.T) {
+		_, err := VerifyKeyBundle(signed, trusted, now.Add(-time.Minute))
+		assert.Error(err)
+		_, err = VerifyKeyBundle(signed, trusted, now.Add(time.Hour))
+		assert.Error(err)
+	})
+
+	t.Run("Tampered payload", func(t *testing.T) {
+		tamperedBundle := bundle
+		tamperedBundle.NotAfter = now.Add(24 * time.Hour)
+		tampered := SignKeyBundle(tamperedBundle, ReadKeyBundleSigningKey(keyPath))
+		tampered.Signature = signed.Signature
+		_, err := VerifyKeyBundle(tampered, trusted, now.Add(time.Minute))
+		assert.Error(err)
+	})
+
+	t.Run("Other deployment domain", func(t *testing.T) {
+		defer circuit.SetDeploymentDomain("")
+		circuit.SetDeploymentDomain("testnet")
+		_, err := VerifyKeyBundle(signed, trusted, now.Add(time.Minute))
+		assert.Error(err)
+	})
+
+	t.Run("Mismatched fingerprint", func(t *testing.T) {
+		mismatchedBundle := bundle
+		mismatchedBundle.Keys = append([]KeyBundleEntry{}, bundle.Keys...)
+		mismatchedBundle.Keys[0].Fingerprint = bundle.Keys[1].Fingerprint
+		_, err := VerifyKeyBundle(SignKeyBundle(mismatchedBundle, ReadKeyBundleSigningKey(keyPath)), trusted, now.Add(time.Minute))
+		assert.Error(err)
+	})
+
+	assert.Panics(func() { BuildKeyBundle(batchCount, testOutDir, now, now) })
+}
@@ -0,0 +1,173 @@
+package core
+
+import (
+	"fmt"
+
+	"bitgo.com/proof_of_reserves/circuit"
+)
+
+// NewLayerPlan computes the number of proofs in each layer of a round with the given number of batches, with
+// Options.HierarchyLevels levels. Each proof of a level above the bottom level includes up to circuit.GetAccountsPerBatch()
+// proofs of the level below, and the single top level proof includes every proof of the level below it. The plan
+// should be checked with Validate before it is used.
+func NewLayerPlan(batchCount int) LayerPlan {
+	return NewLayerPlanWithLevels(batchCount, GetOptions().HierarchyLevels)
+}
+
+// NewLayerPlanWithLevels computes the layer plan of a round with the given number of batches and levels, which is
+// derived from the number of batches if it is 0 (see Options.HierarchyLevels).
+func NewLayerPlanWithLevels(batchCount int, levelCount int) LayerPlan {
+	return LayerPlan{}.withLevels(batchCount, levelCount)
+}
+
+// withConfig returns the plan for proofs of the tree depth of the configuration, rather than the configured one.
+func (plan LayerPlan) withConfig(config circuit.Config) LayerPlan {
+	plan.accountsPerBatch = config.AccountsPerBatch()
+	return plan
+}
+
+// withLevels returns the layer plan of a round with the given number of batches and levels (see
+// NewLayerPlanWithLevels), for proofs of the tree depth of the plan (see withConfig).
+func (plan LayerPlan) withLevels(batchCount int, levelCount int) LayerPlan {
+	accountsPerBatch := plan.getAccountsPerBatch()
+	if levelCount == 0 {
+		levelCount = MIN_HIERARCHY_LEVELS
+		for proofCount := ceilDiv(batchCount, accountsPerBatch); proofCount > accountsPerBatch; proofCount = ceilDiv(proofCount, accountsPerBatch) {
+			levelCount++
+		}
+	}
+	return LayerPlan{
+		BottomLevelProofCount: batchCount,
+		MidLevelProofCount:    ceilDiv(batchCount, accountsPerBatch),
+		TopLevelProofCount:    1,
+		LevelCount:            levelCount,
+		accountsPerBatch:      plan.accountsPerBatch,
+	}
+}
+
+// getAccountsPerBatch returns the number of proofs of the level below each proof above the bottom level includes.
+func (plan LayerPlan) getAccountsPerBatch() int {
+	if plan.accountsPerBatch == 0 {
+		return circuit.GetAccountsPerBatch()
+	}
+	return plan.accountsPerBatch
+}
+
+// ceilDiv returns a / b rounded up, for a >= 0 and b > 0.
+func ceilDiv(a, b int) int {
+	return (a + b - 1) / b
+}
+
+// Validate returns an error if the plan cannot be proven, e.g. because there are no batches or because there are
+// more proofs in the level below the top level than fit in the top level proof.
+func (plan LayerPlan) Validate() error {
+	if plan.BottomLevelProofCount <= 0 {
+		return fmt.Errorf("batch count must be greater than 0, found %d", plan.BottomLevelProofCount)
+	}
+	if plan.Levels() < MIN_HIERARCHY_LEVELS {
+		return fmt.Errorf("a round must have at least %d levels of proofs, found %d", MIN_HIERARCHY_LEVELS, plan.Levels())
+	}
+	if plan.normalized() != plan.withLevels(plan.BottomLevelProofCount, plan.Levels()).normalized() {
+		return fmt.Errorf("layer plan %+v is inconsistent with %d batches", plan, plan.BottomLevelProofCount)
+	}
+	belowTop := plan.Levels() - 2
+	if accountsPerBatch := plan.getAccountsPerBatch(); plan.LevelProofCount(belowTop) > accountsPerBatch {
+		return fmt.Errorf("%d batches need %d %s level proofs, but the top level proof can include at most %d (the maximum with %d levels is %d batches)",
+			plan.BottomLevelProofCount, plan.LevelProofCount(belowTop), levelLayer(belowTop, plan.Levels()).name, accountsPerBatch,
+			plan.Levels(), plan.maxBatchCount())
+	}
+	return nil
+}
+
+// Levels returns the number of levels of proofs of the plan, from the bottom level to the top level. Plans recorded
+// before the number of levels was recorded have MIN_HIERARCHY_LEVELS levels.
+func (plan LayerPlan) Levels() int {
+	if plan.LevelCount == 0 {
+		return MIN_HIERARCHY_LEVELS
+	}
+	return plan.LevelCount
+}
+
+// normalized returns the plan with its number of levels recorded, so plans recorded before it was can be compared, and
+// without the accounts per batch, which is not recorded.
+func (plan LayerPlan) normalized() LayerPlan {
+	plan.LevelCount = plan.Levels()
+	plan.accountsPerBatch = 0
+	return plan
+}
+
+// maxBatchCount returns the maximum number of batches of a round with the number of levels of the plan.
+func (plan LayerPlan) maxBatchCount() int {
+	maxBatchCount := 1
+	for level := 1; level < plan.Levels(); level++ {
+		maxBatchCount *= plan.getAccountsPerBatch()
+	}
+	return maxBatchCount
+}
+
+// LevelProofCount returns the number of proofs of the given level, from the bottom level (0) to the top level
+// (Levels() - 1).
+func (plan LayerPlan) LevelProofCount(level int) int {
+	switch {
+	case level == 0:
+		return plan.BottomLevelProofCount
+	case level == plan.Levels()-1:
+		return plan.TopLevelProofCount
+	case level == 1:
+		return plan.MidLevelProofCount
+	default:
+		return ceilDiv(plan.LevelProofCount(level-1), plan.getAccountsPerBatch())
+	}
+}
+
+// UpperLevelProofCount returns the number of proofs of the levels above the bottom level.
+func (plan LayerPlan) UpperLevelProofCount() int {
+	count := 0
+	for level := 1; level < plan.Levels(); level++ {
+		count += plan.LevelProofCount(level)
+	}
+	return count
+}
+
+// MidLevelProofIndex returns the index of the mid level proof that includes the given bottom level proof.
+func (plan LayerPlan) MidLevelProofIndex(batch int) int {
+	return plan.UpperLevelProofIndex(batch)
+}
+
+// UpperLevelProofIndex returns the index of the proof of the level above that includes the proof with the given index.
+func (plan LayerPlan) UpperLevelProofIndex(index int) int {
+	return index / plan.getAccountsPerBatch()
+}
+
+// MidLevelProofSize returns the number of bottom level proofs the given mid level proof includes.
+func (plan LayerPlan) MidLevelProofSize(midLevelProof int) int {
+	return plan.LevelProofSize(1, midLevelProof)
+}
+
+// LevelProofSize returns the number of proofs of the level below that the proof with the given index of the given
+// level (above the bottom level) includes.
+func (plan LayerPlan) LevelProofSize(level int, index int) int {
+	accountsPerBatch := plan.getAccountsPerBatch()
+	return min((index+1)*accountsPerBatch, plan.LevelProofCount(level-1)) - index*accountsPerBatch
+}
+
+// verifyLayerPlanMatchesMetadata verifies that the layer plan recorded in the round metadata (if any) is the given
+// plan, i.e. that the round is read with the number of batches (and levels) it was generated with.
+func verifyLayerPlanMatchesMetadata(plan LayerPlan, metadata RoundMetadata) error {
+	if metadata.LayerPlan == (LayerPlan{}) {
+		// rounds generated before layer plans were recorded
+		return nil
+	}
+	if metadata.LayerPlan.normalized() != plan.normalized() {
+		return fmt.Errorf("round was generated with %d batches (%d mid level proofs, %d levels), but %d batches (%d levels) were given",
+			metadata.LayerPlan.BottomLevelProofCount, metadata.LayerPlan.MidLevelProofCount, metadata.LayerPlan.Levels(),
+			plan.BottomLevelProofCount, plan.Levels())
+	}
+	return nil
+}
+
+// roundLayerPlan returns the layer plan of a round with the given number of batches and the number of levels recorded
+// in its metadata, with which the round is read, for proofs of the tree depth of the configuration.
+func roundLayerPlan(batchCount int, metadata RoundMetadata, config circuit.Config) LayerPlan {
+	return LayerPlan{}.withConfig(config).withLevels(batchCount, metadata.LayerPlan.Levels())
+}
@@ -0,0 +1,146 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"github.com/consensys/gnark/test"
+)
+
+func TestNewLayerPlan(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	tests := []struct {
+		batchCount    int
+		expectedMid   int
+		expectedValid bool
+	}{
+		{0, 0, false},
+		{-1, 0, false},
+		{1, 1, true},
+		{circuit.ACCOUNTS_PER_BATCH, 1, true},
+		{circuit.ACCOUNTS_PER_BATCH + 1, 2, true},
+		{circuit.ACCOUNTS_PER_BATCH * circuit.ACCOUNTS_PER_BATCH, circuit.ACCOUNTS_PER_BATCH, true},
+		// more batches than fit in 3 levels are proven with more levels
+		{circuit.ACCOUNTS_PER_BATCH*circuit.ACCOUNTS_PER_BATCH + 1, circuit.ACCOUNTS_PER_BATCH + 1, true},
+	}
+	for _, tt := range tests {
+		plan := NewLayerPlan(tt.batchCount)
+		assert.Equal(tt.batchCount, plan.BottomLevelProofCount)
+		assert.Equal(tt.expectedMid, plan.MidLevelProofCount, "batch count %d", tt.batchCount)
+		assert.Equal(1, plan.TopLevelProofCount)
+		if tt.expectedValid {
+			assert.NoError(plan.Validate(), "batch count %d", tt.batchCount)
+		} else {
+			assert.Error(plan.Validate(), "batch count %d", tt.batchCount)
+		}
+	}
+
+	// inconsistent plans are invalid
+	plan := NewLayerPlan(2)
+	plan.MidLevelProofCount = 2
+	assert.Error(plan.Validate())
+
+	// plans recorded before the number of levels was recorded have 3 levels
+	plan = NewLayerPlan(2)
+	assert.Equal(3, plan.LevelCount)
+	plan.LevelCount = 0
+	assert.Equal(3, plan.Levels())
+	assert.NoError(plan.Validate())
+
+	// bottom level proofs are included in mid level proofs in order
+	plan = NewLayerPlan(3 * circuit.ACCOUNTS_PER_BATCH)
+	assert.Equal(0, plan.MidLevelProofIndex(circuit.ACCOUNTS_PER_BATCH-1))
+	assert.Equal(1, plan.MidLevelProofIndex(circuit.ACCOUNTS_PER_BATCH))
+	assert.Equal(2, plan.MidLevelProofIndex(3*circuit.ACCOUNTS_PER_BATCH-1))
+
+	// every mid level proof is full except the last one
+	plan = NewLayerPlan(2*circuit.ACCOUNTS_PER_BATCH + 5)
+	assert.Equal(circuit.ACCOUNTS_PER_BATCH, plan.MidLevelProofSize(0))
+	assert.Equal(circuit.ACCOUNTS_PER_BATCH, plan.MidLevelProofSize(1))
+	assert.Equal(5, plan.MidLevelProofSize(2))
+}
+
+func TestNewLayerPlanWithLevels(t *testing.T) {
+	assert := test.NewAssert(t)
+	config := circuit.GetConfig()
+	assert.NoError(circuit.SetConfig(circuit.Config{TreeDepth: 1}))
+	defer func() { assert.NoError(circuit.SetConfig(config)) }()
+
+	// with 2 proofs per proof, 4 batches fit in 3 levels, and 5 need 4 levels
+	assert.Equal(3, NewLayerPlan(4).Levels())
+	plan := NewLayerPlan(5)
+	assert.Equal(4, plan.Levels())
+	assert.Equal([]int{5, 3, 2, 1}, []int{plan.LevelProofCount(0), plan.LevelProofCount(1), plan.LevelProofCount(2), plan.LevelProofCount(3)})
+	assert.Equal(6, plan.UpperLevelProofCount())
+	assert.Equal(1, plan.LevelProofSize(2, 1))
+	assert.Equal(1, plan.UpperLevelProofIndex(2))
+	assert.NoError(plan.Validate())
+
+	// the number of levels can be configured, but must fit the batches
+	setOptions(t, func(options *Options) { options.HierarchyLevels = 3 })
+	assert.Error(NewLayerPlan(5).Validate())
+	setOptions(t, func(options *Options) { options.HierarchyLevels = 5 })
+	assert.Equal(5, NewLayerPlan(5).Levels())
+	assert.NoError(NewLayerPlan(5).Validate())
+	assert.Error(NewLayerPlanWithLevels(5, 2).Validate())
+
+	// intermediate levels are written after the mid level
+	assert.Equal("mid2", levelLayer(2, 5).name)
+	assert.Equal(IntermediateLevelProofPrefix(2), levelLayer(2, 5).publicPrefix())
+	assert.Equal(topLevelLayer.name, levelLayer(4, 5).name)
+}
+
+func TestProveWithMoreLevels(t *testing.T) {
+	assert := test.NewAssert(t)
+	outDir := t.TempDir() + "/"
+	assert.NoError(os.MkdirAll(outDir+"secret", 0o755))
+	assert.NoError(os.MkdirAll(outDir+"public", 0o755))
+	GenerateData(2, 4, outDir)
+
+	// a round with an intermediate level between the mid and top levels
+	setOptions(t, func(options *Options) { options.HierarchyLevels = 4 })
+	Prove(2, outDir)
+	setOptions(t, func(options *Options) { options.HierarchyLevels = 0 })
+	assert.Equal(4, readRoundMetadata(outDir).LayerPlan.LevelCount)
+	_, err := os.Stat(outDir + IntermediateLevelProofPrefix(2) + "0.json")
+	assert.NoError(err)
+
+	// the verifiers read the number of levels from the round metadata
+	assert.NotPanics(func() { VerifyFull(2, outDir) })
+	userOutDir := t.TempDir()
+	manifest := ExportUserPackages(2, outDir, userOutDir, ExportOptions{ShardSize: 1})
+	account := ReadDataFromFile[ProofElements](outDir + SECRET_DATA_PREFIX + "1.json").Accounts[3]
+	packagePath := filepath.Join(manifest.Shards[1].Directory, UserPackageFileName(account))
+	elements := ReadDataFromFile[UserVerificationElements](packagePath)
+	assert.Equal(4, len(elements.ProofInfo.ProofChain()))
+	assert.NotPanics(func() { VerifyUser(elements) })
+
+	// the package converts to and from its raw format with its intermediate proof
+	raw := ConvertUserVerificationElementsToRawUserVerificationElements(elements)
+	assert.Equal(1, len(raw.ProofInfo.IntermediateProofs))
+	assert.NotPanics(func() { VerifyUser(ConvertRawUserVerificationElementsToUserVerificationElements(raw)) })
+
+	// skipping the intermediate proof breaks the chain
+	elements.ProofInfo.IntermediateProofs = nil
+	assert.Panics(func() { VerifyUser(elements) })
+}
+
+func TestVerifyLayerPlanMatchesMetadata(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// the prover records the layer plan of the round
+	metadata := readRoundMetadata(testOutDir)
+	assert.Equal(NewLayerPlan(batchCount), metadata.LayerPlan)
+	assert.NoError(verifyLayerPlanMatchesMetadata(NewLayerPlan(batchCount), metadata))
+	assert.Error(verifyLayerPlanMatchesMetadata(NewLayerPlan(batchCount+1), metadata))
+
+	// rounds without a recorded layer plan are accepted
+	assert.NoError(verifyLayerPlanMatchesMetadata(NewLayerPlan(batchCount+1), RoundMetadata{}))
+
+	// reading the round with the wrong number of batches fails before any proof is read
+	assert.Panics(func() { VerifyFull(batchCount+1, testOutDir) })
+	assert.Panics(func() { VerifyFull(0, testOutDir) })
+}
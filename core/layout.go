@@ -0,0 +1,146 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Layout is where the files of a round are written in the output directory, so several rounds can share it. Every
+// path of the layout is relative to the output directory, and may contain LAYOUT_EPOCH_PLACEHOLDER, which is replaced
+// by Options.ProofEpoch.
+type Layout struct {
+	// EpochDirectory is the directory in the output directory the round of each epoch is written to, e.g. "epoch_{epoch}/"
+	// (empty to write rounds to the output directory itself). Every file of the round is in it.
+	EpochDirectory string `json:",omitempty"`
+	// SecretDataPrefix is the prefix of the batch files in the round directory, which is followed by the index of each
+	// batch and ".json". It must be in the secret directory.
+	SecretDataPrefix string
+	// BottomProofPrefix, MidProofPrefix, and TopProofPrefix are the prefixes of the published proofs of the bottom, mid,
+	// and top levels in the round directory, which are followed by the index of each proof and ".json". They must be in
+	// the public directory.
+	BottomProofPrefix string
+	MidProofPrefix    string
+	TopProofPrefix    string
+}
+
+// DefaultLayout returns the layout that writes rounds to the output directory itself, with the file names of the
+// constants.
+func DefaultLayout() Layout {
+	return Layout{
+		SecretDataPrefix:  SECRET_DATA_PREFIX,
+		BottomProofPrefix: BOTTOM_PROOF_PREFIX,
+		MidProofPrefix:    MIDDLE_PROOF_PREFIX,
+		TopProofPrefix:    TOP_PROOF_PREFIX,
+	}
+}
+
+// Validate checks the layout only has relative paths, in the directories they must be in, without "..", and that its
+// prefixes are different.
+// Returns nil if the layout is valid, error otherwise
+func (l Layout) Validate() error {
+	if l.EpochDirectory != "" && !strings.HasSuffix(l.EpochDirectory, "/") {
+		return fmt.Errorf("epoch directory %q must end with /", l.EpochDirectory)
+	}
+	prefixes := []struct {
+		name      string
+		prefix    string
+		directory string
+	}{
+		{"secret data", l.SecretDataPrefix, "secret/"},
+		{"bottom proof", l.BottomProofPrefix, "public/"},
+		{"mid proof", l.MidProofPrefix, "public/"},
+		{"top proof", l.TopProofPrefix, "public/"},
+	}
+	if err := validateLayoutPath("epoch directory", l.EpochDirectory); err != nil {
+		return err
+	}
+	seen := make(map[string]string, len(prefixes))
+	for _, p := range prefixes {
+		if err := validateLayoutPath(p.name+" prefix", p.prefix); err != nil {
+			return err
+		}
+		if !strings.HasPrefix(p.prefix, p.directory) || p.prefix == p.directory {
+			return fmt.Errorf("%s prefix %q must be in the %s directory", p.name, p.prefix, strings.TrimSuffix(p.directory, "/"))
+		}
+		if other, ok := seen[p.prefix]; ok {
+			return fmt.Errorf("%s prefix %q is the %s prefix", p.name, p.prefix, other)
+		}
+		seen[p.prefix] = p.name
+	}
+	return nil
+}
+
+// validateLayoutPath checks a path of a layout is relative, and has no ".." element.
+func validateLayoutPath(name string, layoutPath string) error {
+	if path.IsAbs(layoutPath) {
+		return fmt.Errorf("%s %q must be relative", name, layoutPath)
+	}
+	for _, element := range strings.Split(layoutPath, "/") {
+		if element == ".." {
+			return fmt.Errorf("%s %q must not contain ..", name, layoutPath)
+		}
+	}
+	return nil
+}
+
+// RoundDir returns the directory of the round of Options.ProofEpoch in outDir.
+func (l Layout) RoundDir(outDir string) string {
+	return outDir + l.expand(l.EpochDirectory)
+}
+
+// expand replaces LAYOUT_EPOCH_PLACEHOLDER with Options.ProofEpoch in a path of the layout.
+func (l Layout) expand(layoutPath string) string {
+	return strings.ReplaceAll(layoutPath, LAYOUT_EPOCH_PLACEHOLDER, strconv.FormatUint(GetOptions().ProofEpoch, 10))
+}
+
+func (l Layout) secretDataPrefix() string {
+	return l.expand(l.SecretDataPrefix)
+}
+
+func (l Layout) bottomProofPrefix() string {
+	return l.expand(l.BottomProofPrefix)
+}
+
+func (l Layout) midProofPrefix() string {
+	return l.expand(l.MidProofPrefix)
+}
+
+func (l Layout) topProofPrefix() string {
+	return l.expand(l.TopProofPrefix)
+}
+
+// secretDataFile returns the path of the batch with the given index in the round directory.
+func (l Layout) secretDataFile(index int) string {
+	return l.secretDataPrefix() + strconv.Itoa(index) + ".json"
+}
+
+// ReadLayout reads a layout from the JSON file at the given path and validates it. Prefixes it does not set are those
+// of DefaultLayout().
+func ReadLayout(layoutPath string) (Layout, error) {
+	layout := DefaultLayout()
+	if err := readJson(layoutPath, &layout); err != nil {
+		return Layout{}, fmt.Errorf("error reading layout: %w", err)
+	}
+	if err := layout.Validate(); err != nil {
+		return Layout{}, fmt.Errorf("invalid layout: %w", err)
+	}
+	return layout, nil
+}
+
+// createRoundDirectories creates the secret and public directories of the round directory, and the directories of the
+// prefixes of Options.OutputLayout in it. Storages have no directories (see storageOf), so nothing is created in them.
+func createRoundDirectories(roundDir string) error {
+	if isStorageURI(roundDir) {
+		return nil
+	}
+	options := GetOptions()
+	for _, prefix := range []string{"secret/", "public/", options.OutputLayout.secretDataPrefix(), options.OutputLayout.bottomProofPrefix(), options.OutputLayout.midProofPrefix(), options.OutputLayout.topProofPrefix()} {
+		if err := os.MkdirAll(roundDir+path.Dir(prefix), 0o755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
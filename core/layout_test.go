@@ -0,0 +1,71 @@
+package core
+
+import (
+	"os"
+	"strconv"
+	"testing"
+
+	"bitgo.com/proof_of_reserves/verification"
+	"github.com/consensys/gnark/test"
+)
+
+func TestLayoutValidate(t *testing.T) {
+	assert := test.NewAssert(t)
+	assert.NoError(DefaultLayout().Validate())
+
+	layout := DefaultLayout()
+	layout.EpochDirectory = "epoch_{epoch}/"
+	layout.BottomProofPrefix = "public/{epoch}/bottom_"
+	assert.NoError(layout.Validate())
+
+	for _, invalid := range []func(l *Layout){
+		func(l *Layout) { l.EpochDirectory = "epoch_{epoch}" },
+		func(l *Layout) { l.EpochDirectory = "/epochs/{epoch}/" },
+		func(l *Layout) { l.EpochDirectory = "../{epoch}/" },
+		func(l *Layout) { l.SecretDataPrefix = "public/batch_" },
+		func(l *Layout) { l.TopProofPrefix = "secret/top_" },
+		func(l *Layout) { l.MidProofPrefix = "public/" },
+		func(l *Layout) { l.MidProofPrefix = l.BottomProofPrefix },
+		func(l *Layout) { l.BottomProofPrefix = "public/../secret/bottom_" },
+	} {
+		layout := DefaultLayout()
+		invalid(&layout)
+		assert.Error(layout.Validate(), "expected %+v to be invalid", layout)
+	}
+}
+
+func TestProveWithEpochLayout(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// the rounds of two epochs are proven and verified side by side in the same output directory
+	outDir := t.TempDir() + "/"
+	setOptions(t, func(options *Options) {
+		options.OutputLayout = Layout{
+			EpochDirectory:    "epoch_{epoch}/",
+			SecretDataPrefix:  "secret/batches/batch_",
+			BottomProofPrefix: "public/bottom_",
+			MidProofPrefix:    "public/mid_",
+			TopProofPrefix:    "public/top_",
+		}
+	})
+	assert.NoError(GetOptions().OutputLayout.Validate())
+	for _, epoch := range []uint64{20240101, 20240201} {
+		setOptions(t, func(options *Options) { options.ProofEpoch = epoch })
+		GenerateData(2, countPerBatch, outDir)
+		Prove(2, outDir)
+	}
+	for _, epoch := range []uint64{20240101, 20240201} {
+		setOptions(t, func(options *Options) { options.ProofEpoch = epoch })
+		assert.NotPanics(func() { VerifyFull(2, outDir) })
+		topLevelProof := ReadDataFromFile[CompletedProof](outDir + "epoch_" + strconv.FormatUint(epoch, 10) + "/public/top_0.json")
+		assert.Equal(epoch, verification.EpochOf(topLevelProof))
+	}
+	_, err := os.Stat(outDir + "epoch_20240101/secret/batches/batch_1.json")
+	assert.NoError(err)
+	_, err = os.Stat(outDir + "public")
+	assert.True(os.IsNotExist(err), "expected no files outside of the epoch directories")
+
+	// a round can't be read from the directory of another epoch
+	setOptions(t, func(options *Options) { options.ProofEpoch = 20240301 })
+	assert.Panics(func() { VerifyFull(2, outDir) })
+}
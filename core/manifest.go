@@ -0,0 +1,165 @@
+package core
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strings"
+	"time"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"bitgo.com/proof_of_reserves/verification"
+)
+
+// manifestFileNames returns the paths of the published files of a round with the given layer plan that its manifest
+// lists: its proofs, its published verification keys (if any, see Options.PublishVerificationKeys), the asset sum summary of its
+// top level proof (if any, see AssetSumDisclosure), its succinct proof (if any, see SuccinctRoundProof), its provenance statement, and its round metadata.
+func manifestFileNames(plan LayerPlan, outDir string) []string {
+	names := provenanceSubjectNames(plan)
+	if fileExists(outDir + VERIFICATION_KEYS_FILE) {
+		names = append(names, VERIFICATION_KEYS_FILE)
+	}
+	if fileExists(outDir + topAssetSumSummaryFile()) {
+		names = append(names, topAssetSumSummaryFile())
+	}
+	if fileExists(outDir + SUCCINCT_ROUND_PROOF_FILE) {
+		names = append(names, SUCCINCT_ROUND_PROOF_FILE)
+	}
+	return append(names, PROVENANCE_FILE, ROUND_METADATA_FILE)
+}
+
+// writeManifest writes the manifest of the round in outDir with the given layer plan, generated with the configured
+// epoch, tree depth, and asset registry. Every other published file of the round must already be written.
+func writeManifest(plan LayerPlan, outDir string, roundId string, startedOn time.Time) {
+	files, err := describeFiles(outDir, manifestFileNames(plan, outDir))
+	panicOnError(err, "error computing digests of published files")
+	panicOnError(
+		writeJson(outDir+ROUND_MANIFEST_FILE, RoundManifest{
+			RoundId:           roundId,
+			Epoch:             GetOptions().ProofEpoch,
+			BatchCount:        plan.BottomLevelProofCount,
+			TreeDepth:         circuit.GetTreeDepth(),
+			AssetRegistryHash: circuit.GetAssetRegistry().Hash(),
+			CircuitVersion:    circuit.CIRCUIT_VERSION,
+			Files:             files,
+			StartedOn:         startedOn.UTC(),
+			FinishedOn:        time.Now().UTC(),
+		}),
+		"error writing round manifest",
+	)
+}
+
+// verifyManifest verifies the manifest of the round in outDir against its round metadata, its top level proof, the
+// configured asset registry, and the checksums of its published files (the proofs being of the tree depth of the
+// configuration). Rounds generated before manifests were introduced have no manifest and pass.
+func verifyManifest(batchCount int, outDir string, metadata RoundMetadata, topLevelProof CompletedProof, config circuit.Config) error {
+	var manifest RoundManifest
+	if err := readJson(outDir+ROUND_MANIFEST_FILE, &manifest); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error reading round manifest: %w", err)
+	}
+
+	switch {
+	case manifest.RoundId != metadata.RoundId:
+		return fmt.Errorf("manifest is of round %s, but the round metadata is of round %s", manifest.RoundId, metadata.RoundId)
+	case manifest.BatchCount != batchCount:
+		return fmt.Errorf("expected batch count %d in manifest, found %d", batchCount, manifest.BatchCount)
+	case manifest.TreeDepth != metadata.TreeDepth:
+		return fmt.Errorf("manifest has tree depth %d, but the round metadata has %d", manifest.TreeDepth, metadata.TreeDepth)
+	case manifest.CircuitVersion != metadata.CircuitVersion:
+		return fmt.Errorf("manifest has circuit version %d, but the round metadata has %d", manifest.CircuitVersion, metadata.CircuitVersion)
+	case manifest.Epoch != verification.EpochOf(topLevelProof):
+		return fmt.Errorf("manifest has epoch %d, but the top level proof is bound to epoch %d", manifest.Epoch, verification.EpochOf(topLevelProof))
+	case manifest.AssetRegistryHash != circuit.GetAssetRegistry().Hash():
+		return fmt.Errorf("manifest has asset registry %s, but the configured asset registry is %s", manifest.AssetRegistryHash, circuit.GetAssetRegistry().Hash())
+	}
+
+	files, err := describeFiles(outDir, manifestFileNames(roundLayerPlan(batchCount, metadata, config), outDir))
+	if err != nil {
+		return fmt.Errorf("error computing digests of published files: %w", err)
+	}
+	if len(files) != len(manifest.Files) {
+		return fmt.Errorf("expected %d files in manifest, found %d", len(files), len(manifest.Files))
+	}
+	for i := range files {
+		if files[i].Name != manifest.Files[i].Name {
+			return fmt.Errorf("expected file %d of manifest to be %s, found %s", i, files[i].Name, manifest.Files[i].Name)
+		}
+		if files[i].Digest[PROVENANCE_DIGEST_ALGORITHM] != manifest.Files[i].Digest[PROVENANCE_DIGEST_ALGORITHM] {
+			return fmt.Errorf("checksum of %s does not match the checksum recorded in the manifest", files[i].Name)
+		}
+	}
+	return nil
+}
+
+// readPinnedManifest reads the manifest of the round in outDir once, and checks the bytes read have the given hex
+// encoded SHA-256 digest, obtained out of band (e.g. from the exchange's announcement of the round). Returns the
+// manifest decoded from those bytes.
+func readPinnedManifest(outDir string, digest string) (RoundManifest, error) {
+	data, err := readFile(outDir + ROUND_MANIFEST_FILE)
+	if err != nil {
+		return RoundManifest{}, fmt.Errorf("error reading round manifest: %w", err)
+	}
+	if describeData(ROUND_MANIFEST_FILE, data).Digest[PROVENANCE_DIGEST_ALGORITHM] != strings.ToLower(digest) {
+		return RoundManifest{}, fmt.Errorf("round manifest does not match the pinned digest %s", digest)
+	}
+	var manifest RoundManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return RoundManifest{}, fmt.Errorf("error reading round manifest: %w", err)
+	}
+	return manifest, nil
+}
+
+// VerifyUserAgainstManifest verifies the user verification package was issued for the round published in roundDir
+// (e.g. 'https://example.com/round/', the equivalent of the output directory of the prover), whose manifest must list
+// the published top level proof with its checksum: the package's top level proof must be the published one, and the
+// published top level proof must match its checksum in the manifest. This is checked in addition to VerifyUser, so a
+// package downloaded from anywhere is tied to the round attested by the manifest.
+// The manifest is only trusted if it is signed by one of the trusted public keys (see SignRound), or has the given
+// hex encoded SHA-256 digest (manifestDigest, if not empty), obtained out of band; at least one is required, since the
+// manifest is served by the same origin as the files it vouches for. The top level proof is read once, and the proof
+// compared with the package is decoded from the bytes whose checksum was checked.
+func VerifyUserAgainstManifest(elements UserVerificationElements, roundDir string, trustedPublicKeys []ed25519.PublicKey, manifestDigest string) (err error) {
+	defer recoverVerificationError(&err)
+	var manifest RoundManifest
+	switch {
+	case manifestDigest != "":
+		manifest, err = readPinnedManifest(roundDir, manifestDigest)
+		panicOnError(err, "round manifest verification failed")
+	case len(trustedPublicKeys) > 0:
+		manifest, err = VerifyManifestSignature(roundDir, trustedPublicKeys)
+		panicOnError(err, "round manifest verification failed")
+	default:
+		panic("the round manifest must be verified with a trusted public key or a pinned digest")
+	}
+	topProof := elements.ProofInfo.TopProof
+	if manifest.RoundId != topProof.RoundId {
+		panic(fmt.Sprintf("package belongs to round %s, but the manifest is of round %s", topProof.RoundId, manifest.RoundId))
+	}
+
+	name := topLevelLayer.publicPrefix() + "0.json"
+	index := slices.IndexFunc(manifest.Files, func(file ResourceDescriptor) bool { return file.Name == name })
+	if index < 0 {
+		panic("manifest does not list the top level proof " + name)
+	}
+	data, err := readFile(roundDir + name)
+	panicOnError(err, "error reading published top level proof")
+	if describeData(name, data).Digest[PROVENANCE_DIGEST_ALGORITHM] != manifest.Files[index].Digest[PROVENANCE_DIGEST_ALGORITHM] {
+		panic(fmt.Sprintf("checksum of %s does not match the checksum recorded in the manifest", name))
+	}
+
+	var rawPublishedProof RawCompletedProof
+	panicOnError(json.Unmarshal(data, &rawPublishedProof), "error decoding published top level proof")
+	publishedProof := ConvertRawCompletedProofToCompletedProof(rawPublishedProof)
+	if !bytes.Equal(topProof.MerkleRoot, publishedProof.MerkleRoot) ||
+		!bytes.Equal(topProof.MerkleRootWithAssetSumHash, publishedProof.MerkleRootWithAssetSumHash) ||
+		topProof.AssetSum == nil || publishedProof.AssetSum == nil || !topProof.AssetSum.Equals(*publishedProof.AssetSum) {
+		panic("top level proof in package does not match the published top level proof")
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"testing"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"github.com/consensys/gnark/test"
+)
+
+func TestVerifyManifest(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// the rounds generated in TestMain have manifests
+	metadata := readRoundMetadata(testOutDir)
+	assert.NoError(verifyManifest(batchCount, testOutDir, metadata, proofTop, circuit.GetConfig()))
+	assert.NoError(verifyManifest(1, testAltDir, readRoundMetadata(testAltDir), altProofTop, circuit.GetConfig()))
+
+	t.Run("Manifest contents", func(t *testing.T) {
+		var manifest RoundManifest
+		assert.NoError(readJson(testOutDir+ROUND_MANIFEST_FILE, &manifest))
+		assert.Equal(metadata.RoundId, manifest.RoundId)
+		assert.Equal(batchCount, manifest.BatchCount)
+		assert.Equal(circuit.GetTreeDepth(), manifest.TreeDepth)
+		assert.Equal(circuit.CIRCUIT_VERSION, manifest.CircuitVersion)
+		assert.Equal(circuit.GetAssetRegistry().Hash(), manifest.AssetRegistryHash)
+		assert.Equal(batchCount+4, len(manifest.Files))
+		assert.Equal(ROUND_METADATA_FILE, manifest.Files[len(manifest.Files)-1].Name)
+		assert.False(manifest.FinishedOn.Before(manifest.StartedOn))
+	})
+
+	t.Run("Wrong round", func(t *testing.T) {
+		assert.Error(verifyManifest(1, testOutDir, metadata, proofTop, circuit.GetConfig()))
+		assert.Error(verifyManifest(batchCount, testOutDir, readRoundMetadata(testAltDir), proofTop, circuit.GetConfig()))
+		epoch := uint64(20240101)
+		proof := proofTop
+		proof.Epoch = &epoch
+		assert.Error(verifyManifest(batchCount, testOutDir, metadata, proof, circuit.GetConfig()))
+	})
+
+	t.Run("Modified files", func(t *testing.T) {
+		roundDir := copyPublicRound(t, testOutDir, t.TempDir(), "modified")
+		assert.NoError(verifyManifest(batchCount, roundDir, metadata, proofTop, circuit.GetConfig()))
+
+		// a modified proof is detected, and so is a round without it
+		assert.NoError(os.WriteFile(roundDir+MIDDLE_PROOF_PREFIX+"0.json", []byte("modified"), 0o644))
+		assert.Error(verifyManifest(batchCount, roundDir, metadata, proofTop, circuit.GetConfig()))
+		assert.NoError(os.Remove(roundDir + MIDDLE_PROOF_PREFIX + "0.json"))
+		assert.Error(verifyManifest(batchCount, roundDir, metadata, proofTop, circuit.GetConfig()))
+	})
+
+	t.Run("Round without manifest", func(t *testing.T) {
+		roundDir := copyPublicRound(t, testOutDir, t.TempDir(), "legacy")
+		assert.NoError(os.Remove(roundDir + ROUND_MANIFEST_FILE))
+		assert.NoError(verifyManifest(batchCount, roundDir, metadata, proofTop, circuit.GetConfig()))
+	})
+}
+
+func TestVerifyUserAgainstSignedManifest(t *testing.T) {
+	assert := test.NewAssert(t)
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(err)
+	otherPublicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(err)
+	roundDir := copyPublicRound(t, testOutDir, t.TempDir(), "signed")
+	elements := BuildUserVerificationElements(testData0.Accounts[0], 0, proofLower0, proofMid, proofTop)
+
+	// the manifest is verified with the signature, and only with a trusted key
+	assert.Error(VerifyUserAgainstManifest(elements, roundDir, []ed25519.PublicKey{publicKey}, ""))
+	SignRound(batchCount, roundDir, privateKey)
+	assert.NoError(VerifyUserAgainstManifest(elements, roundDir, []ed25519.PublicKey{publicKey}, ""))
+	assert.Error(VerifyUserAgainstManifest(elements, roundDir, []ed25519.PublicKey{otherPublicKey}, ""))
+
+	// a manifest that isn't the signed one fails
+	manifest, err := os.ReadFile(testAltDir + ROUND_MANIFEST_FILE)
+	assert.NoError(err)
+	assert.NoError(os.WriteFile(roundDir+ROUND_MANIFEST_FILE, manifest, 0o644))
+	assert.Error(VerifyUserAgainstManifest(elements, roundDir, []ed25519.PublicKey{publicKey}, ""))
+}
@@ -0,0 +1,73 @@
+package core
+
+import (
+	"sync"
+
+	"github.com/consensys/gnark/constraint"
+)
+
+// SetMaxProofMemory sets the memory, in bytes, the proofs generated concurrently (see SetProofWorkers) may use beyond
+// their cached circuits and proving keys (0, the default, for no limit). A proof waits to be proven until its
+// estimated memory fits in what the proofs being proven leave, so the number of concurrent proofs adapts to the size
+// of their circuits. A proof whose estimate exceeds the whole budget is proven alone.
+func SetMaxProofMemory(bytes uint64) {
+	updateSettings(func(s *settings) error {
+		s.proofMemory = newMemoryBudget(bytes)
+		return nil
+	})
+}
+
+// GetMaxProofMemory returns the memory budget of the proofs generated concurrently (0 for no limit).
+func GetMaxProofMemory() uint64 {
+	return getSettings().proofMemory.limit
+}
+
+// estimateProofMemory returns an estimate of the memory, in bytes, proving a circuit with the constraint system needs
+// beyond the constraint system and proving key themselves (the solution of every wire, the evaluations of the
+// constraints over the FFT domain and its coset, and the scalars of the multi-scalar multiplications).
+func estimateProofMemory(cs constraint.ConstraintSystem) uint64 {
+	wires := cs.GetNbPublicVariables() + cs.GetNbSecretVariables() + cs.GetNbInternalVariables()
+	return proofMemoryOf(wires, cs.GetNbConstraints())
+}
+
+// proofMemoryOf returns the estimate of estimateProofMemory for a circuit with the given numbers of wires and
+// constraints.
+func proofMemoryOf(wires int, constraints int) uint64 {
+	return uint64(wires)*PROOF_MEMORY_BYTES_PER_WIRE + uint64(constraints)*PROOF_MEMORY_BYTES_PER_CONSTRAINT
+}
+
+// memoryBudget hands out reservations of a limited amount of memory to concurrent proofs.
+type memoryBudget struct {
+	limit uint64
+
+	mutex    sync.Mutex
+	released *sync.Cond
+	reserved uint64
+}
+
+// newMemoryBudget returns a budget of limit bytes (0 for no limit).
+func newMemoryBudget(limit uint64) *memoryBudget {
+	budget := &memoryBudget{limit: limit}
+	budget.released = sync.NewCond(&budget.mutex)
+	return budget
+}
+
+// reserve waits until the given number of bytes fits in the budget (or nothing else is reserved) and reserves them.
+// Returns a function releasing them.
+func (budget *memoryBudget) reserve(bytes uint64) func() {
+	if budget.limit == 0 {
+		return func() {}
+	}
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+	for budget.reserved > 0 && budget.reserved+bytes > budget.limit {
+		budget.released.Wait()
+	}
+	budget.reserved += bytes
+	return func() {
+		budget.mutex.Lock()
+		defer budget.mutex.Unlock()
+		budget.reserved -= bytes
+		budget.released.Broadcast()
+	}
+}
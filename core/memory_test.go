@@ -0,0 +1,65 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"github.com/consensys/gnark/test"
+)
+
+func TestMemoryBudget(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// reservations wait until they fit in the budget
+	budget := newMemoryBudget(100)
+	release := budget.reserve(60)
+	reserved := make(chan func())
+	go func() { reserved <- budget.reserve(60) }()
+	select {
+	case <-reserved:
+		t.Fatal("reservation should wait until the budget is released")
+	case <-time.After(50 * time.Millisecond):
+	}
+	release()
+	releaseSecond := <-reserved
+	assert.Equal(uint64(60), budget.reserved)
+
+	// a reservation larger than the budget is made alone
+	go func() { reserved <- budget.reserve(200) }()
+	releaseSecond()
+	(<-reserved)()
+	assert.Equal(uint64(0), budget.reserved)
+
+	// an unlimited budget never waits
+	unlimited := newMemoryBudget(0)
+	unlimited.reserve(1 << 40)
+	unlimited.reserve(1 << 40)()
+}
+
+func TestSetMaxProofMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("compiles and proves circuits")
+	}
+	assert := test.NewAssert(t)
+	defer SetMaxProofMemory(0)
+
+	// proofs are generated within the budget, even if a single proof exceeds it
+	SetMaxProofMemory(1 << 10)
+	assert.Equal(uint64(1<<10), GetMaxProofMemory())
+	assert.NoError(SetProofWorkers(2))
+	defer func() { assert.NoError(SetProofWorkers(1)) }()
+	proofs := generateProofs([]ProofElements{testData0, testData1})
+	assert.NoError(verifyGeneratedProof(proofs[0], bottomLevelLayer.name))
+	assert.NoError(verifyGeneratedProof(proofs[1], bottomLevelLayer.name))
+
+	// the estimate grows with the circuit
+	estimates := make([]uint64, 2)
+	for i := range estimates {
+		baseCircuit := newBaseCircuit(i+1, circuit.GetConfig())
+		cs, err := compileCircuit(GetProofBackend(), &baseCircuit)
+		assert.NoError(err)
+		estimates[i] = estimateProofMemory(cs)
+	}
+	assert.True(estimates[1] > estimates[0], "expected a larger circuit to need more memory")
+}
@@ -0,0 +1,194 @@
+package core
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"github.com/consensys/gnark/constraint"
+)
+
+// ProverMetrics records how compiled circuits in the circuit cache (see SetCircuitCache) were reused, and where the time of proof generation
+// was spent, since the metrics were last reset.
+type ProverMetrics struct {
+	// CacheHits is the number of proofs generated with an already compiled circuit.
+	CacheHits int
+	// CacheMisses is the number of proofs that needed a circuit to be compiled and set up first.
+	CacheMisses int
+	// CacheEvictions is the number of circuits evicted from the circuit cache to make room for another one.
+	CacheEvictions int
+	// CompiledShapes lists the number of accounts of each compiled circuit, in compilation order.
+	CompiledShapes []int
+	// CompileDuration is the time spent in frontend.Compile.
+	CompileDuration time.Duration
+	// SetupDuration is the time spent in the setup of the proof backend (see setupCircuit).
+	SetupDuration time.Duration
+	// ProveDuration is the time spent proving with the proof backend (see proveCircuit).
+	ProveDuration time.Duration
+	// GPUProofs is the number of proofs generated on the GPU (see SetGPUProving).
+	GPUProofs int
+	// GPUFallbacks is the number of proofs that fell back to the CPU because the GPU prover failed.
+	GPUFallbacks int
+	// Batches lists how each proof was generated, in generation order.
+	Batches []BatchMetrics
+}
+
+// BatchMetrics records how a proof was generated.
+type BatchMetrics struct {
+	// Accounts is the number of accounts of the proof.
+	Accounts int
+	// Prover is PROVER_CPU or PROVER_GPU.
+	Prover string
+	// ProveDuration is the time spent proving.
+	ProveDuration time.Duration
+}
+
+// ProofMetrics records how a proof was generated.
+type ProofMetrics struct {
+	// Layer is the layer of the proof ("bottom", "mid", or "top"), and Batch its index in the layer.
+	Layer string
+	Batch int
+	// Accounts is the number of accounts of the proof, and Constraints and Wires the size of its circuit.
+	Accounts    int
+	Constraints int
+	Wires       int
+	// CircuitCached is set when the circuit was already compiled and set up, in which case CompileDuration and
+	// SetupDuration are 0.
+	CircuitCached   bool
+	CompileDuration time.Duration
+	SetupDuration   time.Duration
+	// WitnessDuration is the time spent creating the witness, and ProveDuration the time spent proving with Prover
+	// (PROVER_CPU or PROVER_GPU).
+	WitnessDuration time.Duration
+	ProveDuration   time.Duration
+	Prover          string
+	// PeakMemory is the peak resident memory of the prover process, in bytes, when the proof was generated (including
+	// the proofs generated concurrently, see SetProofWorkers), or 0 where the platform does not report it.
+	PeakMemory uint64
+}
+
+// generatedProofMetrics records how the proofs generated in this process were generated, by proof, until they are
+// written (see writeProofMetrics).
+var generatedProofMetrics sync.Map
+
+// writeProofMetrics writes the metrics of the proof with the given index of the layer to the secret directory of
+// outDir, if the proof was generated in this process and Options.WriteProofMetrics is set.
+func writeProofMetrics(proof CompletedProof, outDir string, layer proofLayer, index int) {
+	value, generated := generatedProofMetrics.LoadAndDelete(proof.Proof)
+	if !generated || !GetOptions().WriteProofMetrics {
+		return
+	}
+	metrics := *value.(*ProofMetrics)
+	metrics.Layer, metrics.Batch = layer.name, index
+	panicOnError(writeJson(outDir+layer.secretPrefix+strconv.Itoa(index)+PROOF_METRICS_SUFFIX, metrics), "error writing proof metrics")
+}
+
+var (
+	proverMetrics      ProverMetrics
+	proverMetricsMutex sync.Mutex
+)
+
+// GetProverMetrics returns a copy of the prover metrics.
+func GetProverMetrics() ProverMetrics {
+	proverMetricsMutex.Lock()
+	defer proverMetricsMutex.Unlock()
+	metrics := proverMetrics
+	metrics.CompiledShapes = slices.Clone(proverMetrics.CompiledShapes)
+	metrics.Batches = slices.Clone(proverMetrics.Batches)
+	return metrics
+}
+
+// ResetProverMetrics resets the prover metrics, e.g. before a run. The compiled circuits stay cached.
+func ResetProverMetrics() {
+	proverMetricsMutex.Lock()
+	defer proverMetricsMutex.Unlock()
+	proverMetrics = ProverMetrics{}
+}
+
+// String summarizes the metrics on one line.
+func (metrics ProverMetrics) String() string {
+	return fmt.Sprintf("circuit cache hits: %d, misses: %d, evictions: %d, compiled shapes: %v, compile: %s, setup: %s, prove: %s, gpu proofs: %d, gpu fallbacks: %d",
+		metrics.CacheHits, metrics.CacheMisses, metrics.CacheEvictions, metrics.CompiledShapes,
+		metrics.CompileDuration.Round(time.Millisecond), metrics.SetupDuration.Round(time.Millisecond), metrics.ProveDuration.Round(time.Millisecond),
+		metrics.GPUProofs, metrics.GPUFallbacks)
+}
+
+// recordCircuitCompiled records that a circuit for proofLen accounts was compiled and set up, and logs it.
+func recordCircuitCompiled(proofLen int, compileDuration time.Duration, setupDuration time.Duration) {
+	proverMetricsMutex.Lock()
+	defer proverMetricsMutex.Unlock()
+	proverMetrics.CacheMisses++
+	proverMetrics.CompiledShapes = append(proverMetrics.CompiledShapes, proofLen)
+	proverMetrics.CompileDuration += compileDuration
+	proverMetrics.SetupDuration += setupDuration
+	slog.Debug("compiled circuit", "accounts", proofLen, "compile", compileDuration, "setup", setupDuration)
+}
+
+// recordCircuitReused records that the cached circuit for proofLen accounts was reused, and logs it.
+func recordCircuitReused(proofLen int) {
+	proverMetricsMutex.Lock()
+	defer proverMetricsMutex.Unlock()
+	proverMetrics.CacheHits++
+	slog.Debug("reused compiled circuit", "accounts", proofLen)
+}
+
+// recordCircuitEvicted records that the cached circuit for proofLen accounts was evicted from the circuit cache, and
+// logs it.
+func recordCircuitEvicted(proofLen int) {
+	proverMetricsMutex.Lock()
+	defer proverMetricsMutex.Unlock()
+	proverMetrics.CacheEvictions++
+	slog.Debug("evicted compiled circuit", "accounts", proofLen)
+}
+
+// recordProofGenerated records the time spent proving a batch of proofLen accounts with the prover, and logs it.
+func recordProofGenerated(proofLen int, prover string, proveDuration time.Duration) {
+	proverMetricsMutex.Lock()
+	defer proverMetricsMutex.Unlock()
+	proverMetrics.ProveDuration += proveDuration
+	if prover == PROVER_GPU {
+		proverMetrics.GPUProofs++
+	}
+	proverMetrics.Batches = append(proverMetrics.Batches, BatchMetrics{Accounts: proofLen, Prover: prover, ProveDuration: proveDuration})
+	slog.Debug("generated proof", "accounts", proofLen, "prover", prover, "prove", proveDuration)
+}
+
+// recordGPUFallback records that a proof fell back to the CPU because the GPU prover failed.
+func recordGPUFallback() {
+	proverMetricsMutex.Lock()
+	defer proverMetricsMutex.Unlock()
+	proverMetrics.GPUFallbacks++
+}
+
+// CircuitStats returns the number of constraints and wires of the bottom level circuit for accountCount accounts, with
+// the configured backend, hash function, hash version, leaf hash mode, circuit configuration, and asset registry, so the
+// cost of adding assets or depth can be evaluated before a production run. The circuit is compiled, unless it was
+// already compiled for a proof. See CircuitComponentStats for the constraints of each component.
+func CircuitStats(accountCount int) (constraints, wires int, err error) {
+	if accountCount < 1 || accountCount > circuit.GetAccountsPerBatch() {
+		return 0, 0, fmt.Errorf("account count must be between 1 and %d, got %d", circuit.GetAccountsPerBatch(), accountCount)
+	}
+	var cs constraint.ConstraintSystem
+	key := newCircuitKey(accountCount)
+	if cachedProof, ok := GetCircuitCache().get(key); ok {
+		cs = cachedProof.cs
+	} else {
+		baseCircuit := newBaseCircuit(accountCount, key.config)
+		if cs, err = compileCircuit(key.backend, &baseCircuit); err != nil {
+			return 0, 0, fmt.Errorf("error compiling circuit: %w", err)
+		}
+	}
+	wires = cs.GetNbPublicVariables() + cs.GetNbSecretVariables() + cs.GetNbInternalVariables()
+	return cs.GetNbConstraints(), wires, nil
+}
+
+// CircuitComponentStats returns the number of R1CS constraints of the range checks, hashes, and sum of the bottom level
+// circuit for accountCount accounts, with the configured circuit configuration (see
+// circuit.CountComponentConstraints).
+func CircuitComponentStats(accountCount int) (circuit.ComponentConstraints, error) {
+	return circuit.CountComponentConstraints(accountCount, circuit.GetConfig())
+}
@@ -0,0 +1,173 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"bitgo.com/proof_of_reserves/circuit"
+)
+
+// MigrateTreeDepth migrates the batches of a round generated with a previous tree depth (read from previousOutDir)
+// to batches for the configured tree depth (written to outDir). Accounts keep their order and are re-chunked
+// into batches of circuit.GetAccountsPerBatch() accounts. A user index mapping each account's previous batch and
+// position to its new ones, and a TreeDepthMigration (recorded in the round metadata by the next Prove) are written
+// to the secret directory of outDir. Returns the migration, whose BatchCount should be used to prove the round.
+func MigrateTreeDepth(previousTreeDepth int, previousBatchCount int, previousOutDir string, outDir string) TreeDepthMigration {
+	options := GetOptions()
+	if previousTreeDepth <= 0 {
+		panic("previous tree depth must be greater than 0")
+	}
+	if previousBatchCount <= 0 {
+		panic("previous batch count must be greater than 0")
+	}
+
+	// read the previous batches, keeping track of where each account was
+	previousBatches := ReadDataFromFiles[ProofElements](previousBatchCount, previousOutDir+options.OutputLayout.secretDataPrefix())
+	accounts := make([]circuit.GoAccount, 0)
+	index := make([]UserIndexEntry, 0)
+	for i, batch := range previousBatches {
+		if len(batch.Accounts) > circuit.PowOfTwo(previousTreeDepth) {
+			panic(fmt.Sprintf("previous batch %d has %d accounts, more than fit in a tree of depth %d", i, len(batch.Accounts), previousTreeDepth))
+		}
+		for j, account := range batch.Accounts {
+			accounts = append(accounts, account)
+			index = append(index, UserIndexEntry{
+				WalletId:         circuit.ConvertGoAccountToRawGoAccount(account).WalletId,
+				PreviousBatch:    i,
+				PreviousPosition: j,
+			})
+		}
+	}
+	if len(accounts) == 0 {
+		panic("previous batches have no accounts")
+	}
+
+	// re-chunk the accounts into batches for the current tree depth
+	accountsPerBatch := circuit.GetAccountsPerBatch()
+	batchCount := (len(accounts) + accountsPerBatch - 1) / accountsPerBatch
+	panicOnError(NewLayerPlan(batchCount).Validate(), "migrated batches cannot be proven")
+	panicOnError(os.MkdirAll(outDir+"secret", 0o755), "failed to create secret directory")
+	for i := 0; i < batchCount; i++ {
+		batchAccounts := accounts[i*accountsPerBatch : min((i+1)*accountsPerBatch, len(accounts))]
+		assetSum := circuit.SumGoAccountBalances(batchAccounts)
+		WriteDataToFile(outDir+options.OutputLayout.secretDataFile(i), ProofElements{Accounts: batchAccounts, AssetSum: &assetSum})
+	}
+	for i := range index {
+		index[i].Batch = i / accountsPerBatch
+		index[i].Position = i % accountsPerBatch
+	}
+
+	batchesDigest, err := migratedBatchesDigest(batchCount, outDir)
+	panicOnError(err, "error computing digest of migrated batches")
+	migration := TreeDepthMigration{
+		PreviousTreeDepth:  previousTreeDepth,
+		PreviousBatchCount: previousBatchCount,
+		TreeDepth:          circuit.GetTreeDepth(),
+		BatchCount:         batchCount,
+		Note: fmt.Sprintf(
+			"tree depth changed from %d to %d: batches hold up to %d accounts instead of %d, the %d previous batches were "+
+				"re-chunked in order into %d batches, and user batches and positions changed accordingly",
+			previousTreeDepth, circuit.GetTreeDepth(), accountsPerBatch, circuit.PowOfTwo(previousTreeDepth), previousBatchCount, batchCount,
+		),
+		BatchesDigest: batchesDigest,
+	}
+	panicOnError(writeJson(outDir+USER_INDEX_FILE, index), "error writing user index")
+	panicOnError(writeJson(outDir+MIGRATION_FILE, migration), "error writing tree depth migration")
+	return migration
+}
+
+// readTreeDepthMigration reads the tree depth migration from outDir. Returns nil if the batches were not migrated.
+func readTreeDepthMigration(outDir string) *TreeDepthMigration {
+	var migration TreeDepthMigration
+	err := readJson(outDir+MIGRATION_FILE, &migration)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	panicOnError(err, "error reading tree depth migration")
+	return &migration
+}
+
+// migratedBatchesDigest returns the hex encoded SHA-256 digest of the digests of the batch files of outDir, in order.
+func migratedBatchesDigest(batchCount int, outDir string) (string, error) {
+	hash := sha256.New()
+	for i := 0; i < batchCount; i++ {
+		descriptor, err := describeFile(outDir, GetOptions().OutputLayout.secretDataFile(i))
+		if err != nil {
+			return "", err
+		}
+		hash.Write([]byte(descriptor.Digest[PROVENANCE_DIGEST_ALGORITHM]))
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// readRoundMigration reads the tree depth migration of the round with the given plan in outDir before it is proven,
+// and verifies it matches the round (see verifyMigrationMatchesPlan). A migration left from an earlier run, whose
+// batches were replaced since, is stale and ignored. Returns nil if the batches were not migrated.
+func readRoundMigration(outDir string, plan LayerPlan) (*TreeDepthMigration, error) {
+	migration := readTreeDepthMigration(outDir)
+	if migration == nil {
+		return nil, nil
+	}
+	if migration.BatchesDigest != "" {
+		digest, err := migratedBatchesDigest(plan.BottomLevelProofCount, outDir)
+		if err != nil || digest != migration.BatchesDigest {
+			return nil, nil
+		}
+	}
+	if err := verifyMigrationMatchesPlan(migration, plan); err != nil {
+		return nil, fmt.Errorf("tree depth migration does not match the round: %w", err)
+	}
+	return migration, nil
+}
+
+// verifyMigrationMatchesPlan verifies that the tree depth migration (if any) produced the batches of the given plan
+// with the current tree depth, i.e. that it belongs to the round being proven.
+func verifyMigrationMatchesPlan(migration *TreeDepthMigration, plan LayerPlan) error {
+	if migration == nil {
+		return nil
+	}
+	if migration.TreeDepth != circuit.GetTreeDepth() {
+		return fmt.Errorf("batches were migrated to tree depth %d, but the configured tree depth is %d", migration.TreeDepth, circuit.GetTreeDepth())
+	}
+	if migration.BatchCount != plan.BottomLevelProofCount {
+		return fmt.Errorf("batches were migrated into %d batches, but %d batches are being proven", migration.BatchCount, plan.BottomLevelProofCount)
+	}
+	return nil
+}
+
+// verifyTreeDepth verifies that the round was generated with the tree depth of the configuration (rounds generated
+// before the tree depth was recorded pass).
+func verifyTreeDepth(metadata RoundMetadata, config circuit.Config) error {
+	if metadata.TreeDepth != 0 && metadata.TreeDepth != config.TreeDepth {
+		return fmt.Errorf("round was generated with tree depth %d, but the configured tree depth is %d", metadata.TreeDepth, config.TreeDepth)
+	}
+	return nil
+}
+
+// VerifyRoundTransition verifies that a round can be compared with the previous round. If the tree depth changed
+// between them, the round must record a TreeDepthMigration from the previous round, as user batches and positions
+// are otherwise not comparable. Tree depths that were not recorded are assumed not to have changed.
+func VerifyRoundTransition(previous RoundMetadata, current RoundMetadata) error {
+	migration := current.TreeDepthMigration
+	if migration == nil {
+		if previous.TreeDepth != 0 && current.TreeDepth != 0 && previous.TreeDepth != current.TreeDepth {
+			return fmt.Errorf("tree depth changed from %d to %d without a tree depth migration", previous.TreeDepth, current.TreeDepth)
+		}
+		return nil
+	}
+
+	if previous.TreeDepth != 0 && migration.PreviousTreeDepth != previous.TreeDepth {
+		return fmt.Errorf("round was migrated from tree depth %d, but the previous round has tree depth %d", migration.PreviousTreeDepth, previous.TreeDepth)
+	}
+	if previous.LayerPlan != (LayerPlan{}) && migration.PreviousBatchCount != previous.LayerPlan.BottomLevelProofCount {
+		return fmt.Errorf("round was migrated from %d batches, but the previous round has %d batches", migration.PreviousBatchCount, previous.LayerPlan.BottomLevelProofCount)
+	}
+	if current.TreeDepth != 0 && migration.TreeDepth != current.TreeDepth {
+		return fmt.Errorf("round was migrated to tree depth %d, but has tree depth %d", migration.TreeDepth, current.TreeDepth)
+	}
+	return nil
+}
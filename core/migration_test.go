@@ -0,0 +1,99 @@
+package core
+
+import (
+	"testing"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"github.com/consensys/gnark/test"
+)
+
+func TestMigrateTreeDepth(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// the test round has 2 batches of 16 accounts, i.e. the layout of a round with tree depth 4
+	outDir := t.TempDir() + "/"
+	migration := MigrateTreeDepth(4, batchCount, testOutDir, outDir)
+	assert.Equal(TreeDepthMigration{
+		PreviousTreeDepth:  4,
+		PreviousBatchCount: batchCount,
+		TreeDepth:          circuit.TREE_DEPTH,
+		BatchCount:         1,
+		Note:               migration.Note,
+		BatchesDigest:      migration.BatchesDigest,
+	}, migration)
+	assert.Equal(&migration, readTreeDepthMigration(outDir))
+
+	// accounts keep their order in the migrated batch
+	migrated := ReadDataFromFile[ProofElements](outDir + SECRET_DATA_PREFIX + "0.json")
+	assert.Equal(append(append([]circuit.GoAccount{}, testData0.Accounts...), testData1.Accounts...), migrated.Accounts)
+	assetSum := circuit.SumGoAccountBalances(migrated.Accounts)
+	assert.True(assetSum.Equals(*migrated.AssetSum))
+
+	// the user index maps previous positions to new ones
+	var index []UserIndexEntry
+	assert.NoError(readJson(outDir+USER_INDEX_FILE, &index))
+	assert.Equal(2*countPerBatch, len(index))
+	assert.Equal(UserIndexEntry{
+		WalletId:         circuit.ConvertGoAccountToRawGoAccount(testData1.Accounts[3]).WalletId,
+		Batch:            0,
+		Position:         countPerBatch + 3,
+		PreviousBatch:    1,
+		PreviousPosition: 3,
+	}, index[countPerBatch+3])
+
+	// the migration must match the round being proven
+	assert.NoError(verifyMigrationMatchesPlan(&migration, NewLayerPlan(1)))
+	assert.Error(verifyMigrationMatchesPlan(&migration, NewLayerPlan(2)))
+	assert.NoError(verifyMigrationMatchesPlan(nil, NewLayerPlan(2)))
+	roundMigration, err := readRoundMigration(outDir, NewLayerPlan(1))
+	assert.NoError(err)
+	assert.Equal(&migration, roundMigration)
+	_, err = readRoundMigration(t.TempDir()+"/", NewLayerPlan(1))
+	assert.NoError(err)
+
+	// a migration left from an earlier run is ignored once its batches are replaced
+	WriteDataToFile(outDir+SECRET_DATA_PREFIX+"0.json", testData0)
+	roundMigration, err = readRoundMigration(outDir, NewLayerPlan(1))
+	assert.NoError(err)
+	assert.Nil(roundMigration)
+	WriteDataToFile(outDir+SECRET_DATA_PREFIX+"0.json", migrated)
+	roundMigration, err = readRoundMigration(outDir, NewLayerPlan(1))
+	assert.NoError(err)
+	assert.Equal(&migration, roundMigration)
+
+	// a migration of the batches being proven that doesn't match the round fails before proving
+	legacy := migration
+	legacy.BatchesDigest = ""
+	assert.NoError(writeJson(outDir+MIGRATION_FILE, legacy))
+	_, err = readRoundMigration(outDir, NewLayerPlan(2))
+	assert.Error(err)
+
+	// batches with more accounts than the previous tree depth allows cannot be migrated
+	assert.Panics(func() { MigrateTreeDepth(3, batchCount, testOutDir, t.TempDir()+"/") })
+}
+
+func TestVerifyRoundTransition(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	previous := RoundMetadata{TreeDepth: 4, LayerPlan: NewLayerPlan(2)}
+	current := RoundMetadata{TreeDepth: circuit.TREE_DEPTH, LayerPlan: NewLayerPlan(1)}
+	migration := &TreeDepthMigration{PreviousTreeDepth: 4, PreviousBatchCount: 2, TreeDepth: circuit.TREE_DEPTH, BatchCount: 1}
+
+	// same tree depth, or tree depths not recorded
+	assert.NoError(VerifyRoundTransition(current, current))
+	assert.NoError(VerifyRoundTransition(RoundMetadata{}, current))
+
+	// a tree depth change needs a matching migration
+	assert.Error(VerifyRoundTransition(previous, current))
+	current.TreeDepthMigration = migration
+	assert.NoError(VerifyRoundTransition(previous, current))
+	previous.LayerPlan = NewLayerPlan(3)
+	assert.Error(VerifyRoundTransition(previous, current))
+	previous = RoundMetadata{TreeDepth: 8}
+	assert.Error(VerifyRoundTransition(previous, current))
+
+	// the test round records the tree depth of the circuit
+	assert.Equal(circuit.TREE_DEPTH, readRoundMetadata(testOutDir).TreeDepth)
+	assert.NoError(verifyTreeDepth(readRoundMetadata(testOutDir), circuit.GetConfig()))
+	assert.Error(verifyTreeDepth(RoundMetadata{TreeDepth: circuit.TREE_DEPTH + 4}, circuit.GetConfig()))
+}
@@ -0,0 +1,15 @@
+//go:build !icicle
+
+package core
+
+import "github.com/consensys/gnark/backend/groth16"
+
+// icicleAvailable reports whether the binary is built with the icicle build tag, which links gnark's ICICLE GPU prover
+// (see SetGPUProving).
+const icicleAvailable = false
+
+// icicleProvingKey returns the proving key as is, as gnark proves with the plain BN254 prover without the icicle build
+// tag.
+func icicleProvingKey(pk groth16.ProvingKey) groth16.ProvingKey {
+	return pk
+}
@@ -0,0 +1,171 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"bitgo.com/proof_of_reserves/verification"
+)
+
+// newSparseTree returns an empty sparse Merkle tree if Options.BuildSparseTree is set, or nil otherwise.
+func newSparseTree() *circuit.SparseMerkleTree {
+	if !GetOptions().BuildSparseTree {
+		return nil
+	}
+	return circuit.NewSparseMerkleTree()
+}
+
+// addToSparseTree adds the accounts of the given batches (whose index is given by firstBatch onwards) to the sparse
+// Merkle tree, if there is one.
+func addToSparseTree(tree *circuit.SparseMerkleTree, proofElements []ProofElements, firstBatch int) {
+	if tree == nil {
+		return
+	}
+	for i, proofElement := range proofElements {
+		panicOnError(tree.AddAccounts(proofElement.Accounts), fmt.Sprintf("error adding batch %d to sparse merkle tree", firstBatch+i))
+	}
+}
+
+// sparseTreeRoot returns the root of the sparse Merkle tree, or nil if there is none.
+func sparseTreeRoot(tree *circuit.SparseMerkleTree) Hash {
+	if tree == nil {
+		return nil
+	}
+	root, err := tree.Root()
+	panicOnError(err, "error computing sparse merkle tree root")
+	return root
+}
+
+// verifySparseTreeRoot verifies that the root is the root of the sparse Merkle tree of the accounts of the batches,
+// hashed as the configuration hashes them. Rounds without a sparse Merkle tree (a nil root) pass.
+func verifySparseTreeRoot(accountBatches [][]circuit.GoAccount, root Hash, config circuit.Config) error {
+	if root == nil {
+		return nil
+	}
+	tree, err := circuit.NewSparseMerkleTreeWithConfig(config)
+	if err != nil {
+		return err
+	}
+	for i, batch := range accountBatches {
+		if err := tree.AddAccounts(batch); err != nil {
+			return fmt.Errorf("batch %d: %w", i, err)
+		}
+	}
+	return verifySparseTreeHasRoot(tree, root)
+}
+
+// verifySparseTreeHasRoot verifies the root of the sparse Merkle tree of the accounts of a round is the given root.
+func verifySparseTreeHasRoot(tree *circuit.SparseMerkleTree, root Hash) error {
+	computedRoot, err := tree.Root()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(computedRoot, root) {
+		return errors.New("sparse merkle tree root of the round is not the root of the tree of its accounts")
+	}
+	return nil
+}
+
+// ProveNonInclusion builds the proof of non-inclusion of the user with the given raw WalletId in the round in outDir,
+// which must have been proven with Options.BuildSparseTree. The sparse Merkle tree is rebuilt from the batches in outDir the
+// same way the prover built it, and must have the root recorded in the round metadata. Returns an error if the user is
+// in the round, instead of panicking.
+func ProveNonInclusion(batchCount int, outDir string, rawWalletId string) (proof NonInclusionProof, err error) {
+	defer recoverError(&err, "proving non-inclusion failed")
+
+	metadata := readRoundMetadata(outDir)
+	if metadata.SparseTreeRoot == nil {
+		return NonInclusionProof{}, errors.New("round has no sparse merkle tree (it was not proven with BuildSparseTree)")
+	}
+	encoding := verification.RecordedWalletIdEncoding(metadata.WalletIdEncoding)
+	walletId := circuit.ConvertRawGoAccountToGoAccountWithEncoding(circuit.RawGoAccount{WalletId: rawWalletId}, encoding).WalletId
+
+	// hash accounts and nodes with the configuration the round was proven with, and restrict the accounts to its asset
+	// subset
+	topLevelProof := ReadDataFromFile[CompletedProof](outDir + topLevelLayer.publicPrefix() + "0.json")
+	topLevelProofs := []CompletedProof{topLevelProof}
+	config, err := verification.ProofsConfig(topLevelProofs)
+	panicOnError(err, "proof parameters verification failed")
+	assetSubset, err := verification.ProofsAssetSubset(topLevelProofs)
+	panicOnError(err, "asset subset verification failed")
+
+	// rebuild the tree from the accounts the prover proved
+	proofElements := ReadDataFromFiles[ProofElements](batchCount, outDir+GetOptions().OutputLayout.secretDataPrefix())
+	applyAssetSubset(proofElements, assetSubset)
+	if metadata.AggregatedUserAccounts {
+		applyAccountAggregation(proofElements, config)
+	}
+	applyExclusionList(proofElements, 0, readExclusionList(outDir))
+	readDummyAccountPadding(proofElements, 0, metadata.DummyAccountPadding, outDir)
+	tree, err := circuit.NewSparseMerkleTreeWithConfig(config)
+	panicOnError(err, "error creating sparse merkle tree")
+	addToSparseTree(tree, proofElements, 0)
+	if !bytes.Equal(sparseTreeRoot(tree), metadata.SparseTreeRoot) {
+		return NonInclusionProof{}, errors.New("sparse merkle tree of the batches does not have the root of the round")
+	}
+
+	path, err := tree.NonInclusionPath(walletId)
+	if err != nil {
+		return NonInclusionProof{}, err
+	}
+	return NonInclusionProof{
+		WalletId:         rawWalletId,
+		WalletIdEncoding: metadata.WalletIdEncoding,
+		RoundId:          metadata.RoundId,
+		HashFunction:     topLevelProof.HashFunction,
+		HashVersion:      topLevelProof.HashVersion,
+		SparseTreePath:   path,
+	}, nil
+}
+
+// VerifyNonInclusion verifies that the path of the proof leads from the zero leaf at the user's key to the root of the
+// sparse Merkle tree of the round (SparseTreeRoot in its metadata), i.e. that the user is not in the round. The nodes
+// are hashed with the configured deployment domain.
+func VerifyNonInclusion(proof NonInclusionProof, root Hash) (err error) {
+	defer recoverError(&err, "non-inclusion verification failed")
+
+	if root == nil {
+		return errors.New("round has no sparse merkle tree root")
+	}
+	config, err := verification.ProofsConfig([]CompletedProof{{HashFunction: proof.HashFunction, HashVersion: proof.HashVersion}})
+	if err != nil {
+		return err
+	}
+
+	encoding := verification.RecordedWalletIdEncoding(proof.WalletIdEncoding)
+	walletId := circuit.ConvertRawGoAccountToGoAccountWithEncoding(circuit.RawGoAccount{WalletId: proof.WalletId}, encoding).WalletId
+	key, err := circuit.ComputeSparseTreeKey(walletId)
+	if err != nil {
+		return err
+	}
+	// the leaf of a user that is not in the tree is zero
+	computedRoot, err := config.GoComputeSparseMerkleRoot(key, make(Hash, circuit.ModBytes), proof.SparseTreePath)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(computedRoot, root) {
+		return fmt.Errorf("sparse merkle path of user %s does not lead from an empty leaf to the root of the round", proof.WalletId)
+	}
+	return nil
+}
+
+// ReadNonInclusionProof reads a proof of non-inclusion from the given path.
+func ReadNonInclusionProof(path string) NonInclusionProof {
+	var proof NonInclusionProof
+	panicOnError(readJson(path, &proof), "error reading proof of non-inclusion")
+	return proof
+}
+
+// WriteNonInclusionProof writes a proof of non-inclusion to the given path.
+func WriteNonInclusionProof(path string, proof NonInclusionProof) {
+	panicOnError(writeJson(path, proof), "error writing proof of non-inclusion")
+}
+
+// ReadPublishedRoundMetadata reads the round metadata published at the given path (ROUND_METADATA_FILE of a round).
+func ReadPublishedRoundMetadata(path string) RoundMetadata {
+	var metadata RoundMetadata
+	panicOnError(readJson(path, &metadata), "error reading round metadata")
+	return metadata
+}
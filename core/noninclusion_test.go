@@ -0,0 +1,66 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"github.com/consensys/gnark/test"
+)
+
+func TestNonInclusion(t *testing.T) {
+	assert := test.NewAssert(t)
+	outDir := t.TempDir() + "/"
+	assert.NoError(os.MkdirAll(outDir+"secret", 0o755))
+	assert.NoError(os.MkdirAll(outDir+"public", 0o755))
+
+	// rounds proven without the sparse tree have no root, and no proofs of non-inclusion
+	GenerateData(1, 6, outDir)
+	Prove(1, outDir)
+	assert.Nil(readRoundMetadata(outDir).SparseTreeRoot)
+	_, err := ProveNonInclusion(1, outDir, "departeduser")
+	assert.Error(err)
+
+	setOptions(t, func(options *Options) { options.BuildSparseTree = true })
+	Prove(1, outDir)
+	metadata := readRoundMetadata(outDir)
+	assert.NotNil(metadata.SparseTreeRoot)
+	assert.NotPanics(func() { VerifyFull(1, outDir) })
+	assert.NotPanics(func() { VerifyFullStreaming(1, outDir) })
+
+	// a user that is not in the round gets a proof of non-inclusion, which survives a round trip through JSON
+	proof, err := ProveNonInclusion(1, outDir, "departeduser")
+	assert.NoError(err)
+	assert.Equal(metadata.RoundId, proof.RoundId)
+	data, err := json.Marshal(proof)
+	assert.NoError(err)
+	var readProof NonInclusionProof
+	assert.NoError(json.Unmarshal(data, &readProof))
+	assert.NoError(VerifyNonInclusion(readProof, metadata.SparseTreeRoot))
+
+	// the proof is of that user, and of that root
+	otherUserProof := proof
+	otherUserProof.WalletId = "otheruser"
+	assert.Error(VerifyNonInclusion(otherUserProof, metadata.SparseTreeRoot))
+	assert.Error(VerifyNonInclusion(proof, proofLower0.MerkleRoot))
+	assert.Error(VerifyNonInclusion(proof, nil))
+
+	// a user in the round gets none
+	accounts := ReadDataFromFile[ProofElements](outDir + SECRET_DATA_PREFIX + "0.json").Accounts
+	_, err = ProveNonInclusion(1, outDir, circuit.ConvertGoAccountToRawGoAccount(accounts[0]).WalletId)
+	assert.Error(err)
+
+	// proving from an iterator builds the same tree
+	iteratorOutDir := t.TempDir() + "/"
+	_, err = ProveFromIterator(context.Background(), &sliceAccountIterator{accounts: accounts}, ProveOptions{OutDir: iteratorOutDir})
+	assert.NoError(err)
+	assert.Equal(metadata.SparseTreeRoot, readRoundMetadata(iteratorOutDir).SparseTreeRoot)
+
+	// a root that is not of the round's accounts fails full verification
+	metadata.SparseTreeRoot = proofLower0.MerkleRoot
+	assert.NoError(writeJson(outDir+ROUND_METADATA_FILE, metadata))
+	assert.Panics(func() { VerifyFull(1, outDir) })
+	assert.Panics(func() { VerifyFullStreaming(1, outDir) })
+}
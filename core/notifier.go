@@ -0,0 +1,126 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ShardCompletionEvent announces that the user verification packages of a shard are ready.
+type ShardCompletionEvent struct {
+	// IdempotencyKey identifies the shard of the round, so consumers can ignore repeated events. Events are
+	// repeated whenever notifying fails and is retried.
+	IdempotencyKey string
+	// RoundId is the id of the round (see RoundMetadata.RoundId), or the hex encoded merkle root with asset sum hash of
+	// the top level proof of rounds generated before round ids were recorded.
+	RoundId    string
+	ShardIndex int
+	UserCount  int
+	// StorageURI is where the packages of the shard are stored.
+	StorageURI string
+	// Checksum is the checksum of the packages of the shard (see UserExportShard).
+	Checksum string
+}
+
+// ShardNotifier notifies downstream systems (e.g. a webhook or a queue) that a shard of user verification packages
+// is ready.
+type ShardNotifier interface {
+	// Notify delivers the event, returning an error if it may not have been delivered.
+	Notify(event ShardCompletionEvent) error
+}
+
+// WEBHOOK_TIMEOUT is how long posting an event to a webhook may take before it fails, so a webhook that stops
+// responding fails the export (which notifies the shard again when resumed) instead of hanging it.
+const WEBHOOK_TIMEOUT = 30 * time.Second
+
+// webhookHTTPClient is the HTTP client of the webhook notifiers that are not given one.
+var webhookHTTPClient = &http.Client{Timeout: WEBHOOK_TIMEOUT}
+
+// WebhookNotifier posts each event as JSON to a URL, with the idempotency key in the Idempotency-Key header.
+type WebhookNotifier struct {
+	URL string
+	// Client is the HTTP client used to post events (defaults to a client that times out after WEBHOOK_TIMEOUT).
+	Client *http.Client
+}
+
+// Notify posts the event to the webhook. Responses with a status other than 2xx are errors.
+func (notifier WebhookNotifier) Notify(event ShardCompletionEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest(http.MethodPost, notifier.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Idempotency-Key", event.IdempotencyKey)
+
+	client := notifier.Client
+	if client == nil {
+		client = webhookHTTPClient
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(io.Discard, response.Body)
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %s", response.Status)
+	}
+	return nil
+}
+
+// computeShardChecksum returns the hex encoded SHA-256 hash of the names and SHA-256 hashes of the packages in the
+// shard directory, in name order.
+func computeShardChecksum(directory string) (string, error) {
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return "", err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	hasher := sha256.New()
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(directory, name))
+		if err != nil {
+			return "", err
+		}
+		fileHash := sha256.Sum256(data)
+		fmt.Fprintf(hasher, "%s %s\n", name, hex.EncodeToString(fileHash[:]))
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// newShardCompletionEvent returns the completion event of the shard of the given round. storageURIPrefix replaces
+// the user output directory in the storage URI (the shard directory is used as is if it is empty).
+func newShardCompletionEvent(roundId string, shard UserExportShard, storageURIPrefix string) ShardCompletionEvent {
+	storageURI := shard.Directory
+	if storageURIPrefix != "" {
+		storageURI = storageURIPrefix + "/" + filepath.Base(shard.Directory)
+	}
+	return ShardCompletionEvent{
+		IdempotencyKey: roundId + "/shard_" + strconv.Itoa(shard.Index),
+		RoundId:        roundId,
+		ShardIndex:     shard.Index,
+		UserCount:      shard.UserCount,
+		StorageURI:     storageURI,
+		Checksum:       shard.Checksum,
+	}
+}
@@ -0,0 +1,175 @@
+package core
+
+import (
+	"container/list"
+	"crypto"
+	"sync"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"github.com/consensys/gnark-crypto/ecc/bn254/kzg"
+)
+
+// Options are the options of the prover and the verifiers that can be chosen at runtime (see SetOptions). Unlike the
+// configuration of the circuit, they are not recorded in the proofs, but some are recorded in the round metadata.
+type Options struct {
+	// ProofEpoch is the epoch the generated proofs are bound to, e.g. the date of the snapshot as YYYYMMDD or its Unix
+	// timestamp. It is a public input of every proof and is hashed into MerkleRootWithAssetSumHash, so the proofs of
+	// one layer can't be combined with the proofs of another snapshot. Zero binds the proofs to no snapshot.
+	ProofEpoch uint64
+	// HierarchyLevels is the number of levels of proofs of the rounds that are proven, from the bottom level to the
+	// top level. If it is 0, the number of levels is derived from the number of batches: MIN_HIERARCHY_LEVELS, or as
+	// many more as are needed for the proofs of the level below the top level to fit in the top level proof. The number
+	// of levels is recorded in the layer plan of the round, which verifiers read it from.
+	HierarchyLevels int
+	// AggregateUserAccounts makes the prover merge the accounts of each user (the accounts with the same WalletId) in
+	// each batch into one account before proving (see circuit.AggregateAccountsByUserId), and GenerateData generate
+	// users with several accounts. It is recorded in the round metadata, so the accounts are merged the same way when
+	// users are exported and the round is verified. The accounts of a user must be in the same batch.
+	AggregateUserAccounts bool
+	// DummyAccountPadding is the number of accounts the prover pads every batch to with dummy accounts (see
+	// circuit.NewDummyAccount), or 0 for no padding. Without padding, the zero leaves of a batch reveal exactly how
+	// many accounts it holds (and so does the AccountCount of its proof); padding to circuit.GetAccountsPerBatch()
+	// hides the fill level of every batch. The dummy accounts, with their salts, are only written to the secret
+	// directory (DUMMY_ACCOUNTS_PREFIX), and the padding is recorded in the round metadata, so they are added back the
+	// same way when the round is verified. No user verification package is exported for them.
+	DummyAccountPadding int
+	// BuildSparseTree makes the prover build a sparse Merkle tree of the proven accounts (see
+	// circuit.SparseMerkleTree) alongside the trees of the batches, and record its root in the round metadata, so
+	// users that are not in the round (e.g. departed customers) can be given a proof of non-inclusion (see
+	// ProveNonInclusion). The root is not a public input of the circuit: VerifyFull checks it is the root of the tree
+	// of the proven accounts.
+	BuildSparseTree bool
+	// PublishVerificationKeys makes the prover publish the verification key of each circuit once, in
+	// VERIFICATION_KEYS_FILE, instead of in every published proof, which references its key by fingerprint
+	// (VerificationKeyFingerprint) instead. A proof is only as trustworthy as the key it is verified with, and a proof
+	// that carries its own key could pair a bogus key with a bogus proof, so verifiers should pin the published keys
+	// (see PinPublishedVerificationKeys) once they have checked them out of band, e.g. against a key bundle or a
+	// previous round. The secret copies of the proofs (see PublicationPolicy) keep their keys.
+	PublishVerificationKeys bool
+	// WriteProofMetrics makes the prover write how each proof was generated (see ProofMetrics) to the secret
+	// directory, next to the secret copy of the proof, in PROOF_METRICS_SUFFIX files (e.g.
+	// 'secret/bottom_level_proof_0.metrics.json'), so regressions can be tracked across releases and data sizes.
+	WriteProofMetrics bool
+	// SyncFileWrites makes every file written by the prover (see writeFile) be flushed to disk, with the directory
+	// entry it is renamed to, before the write returns, so the files of a round, e.g. its secret batch data, survive a
+	// power loss or a kernel crash, and not only a crash of the process. It makes writes slower.
+	SyncFileWrites bool
+	// CrossBatchDuplicateUsers is how VerifyFull and VerifyFullStreaming check that no user is in more than one batch.
+	CrossBatchDuplicateUsers DuplicateUserCheck
+	// OutputLayout is the layout the files of rounds are written and read with. Prove (and the other ways of proving a
+	// round), VerifyFull, GenerateData, SplitAccountsIntoBatches, and the exports of user packages read and write the
+	// round of ProofEpoch in its round directory (see Layout.RoundDir); functions that take the directory of a round
+	// (e.g. SignRound) expect that directory.
+	OutputLayout Layout
+	// ProofPublicationPolicy is the policy the prover writes proofs with.
+	ProofPublicationPolicy PublicationPolicy
+	// SecretCopyKey is the AES-256 key the copies of the proofs kept in the secret directory (see PublicationPolicy),
+	// and their asset sum summaries, are encrypted with, so the asset sums and merkle nodes they keep can only be read
+	// with the key, not by whoever can read the secret directory (e.g. its backups). Each copy is encrypted with
+	// AES-GCM under a random nonce, and bound to its file name, so copies can't be swapped. Copies are written in plain
+	// text if it is nil.
+	SecretCopyKey []byte
+	// ArtifactSigner signs the published files of the rounds the prover generates (see SignRound), so consumers can
+	// confirm they were published by the exchange and were not swapped in transit. It must sign with an ed25519 key:
+	// an ed25519.PrivateKey (e.g. read with ReadKeyBundleSigningKey), or a crypto.Signer backed by a KMS that keeps the
+	// private key. Rounds are not signed if it is nil.
+	ArtifactSigner crypto.Signer
+	// ProvenanceBuilderId identifies the builder in the provenance statements of generated rounds. Deployments should
+	// set it to a URI identifying the environment the prover runs in.
+	ProvenanceBuilderId string
+	// TestDataProfile is the distribution of the accounts generated by GenerateData (see circuit.TestDataProfile).
+	TestDataProfile circuit.TestDataProfile
+}
+
+// DefaultOptions returns the default options, which prove rounds of the default layout and publication policy,
+// without padding, aggregation, or signatures.
+func DefaultOptions() Options {
+	return Options{
+		CrossBatchDuplicateUsers: DUPLICATE_USER_CHECK_NONE,
+		OutputLayout:             DefaultLayout(),
+		ProofPublicationPolicy:   DefaultPublicationPolicy(),
+		ProvenanceBuilderId:      "bitgo.com/proof_of_reserves",
+		TestDataProfile:          circuit.DEFAULT_TEST_DATA_PROFILE,
+	}
+}
+
+// settings are the process-wide settings of the package: its options (see SetOptions), the backend of new proofs with
+// its SRS (see SetProofBackend, SetPlonkSRS, SetDeterministicSetup, and SetGPUProving), the circuit cache and setup keys of the prover (see SetCircuitCache and
+// SetSetupKeyDir), the workers and memory of proofs and verifications (see SetProofWorkers, SetMaxProofMemory, and
+// SetVerificationWorkers), and the storages of URI schemes (see RegisterStorageScheme). Like the settings of the
+// circuit package, they are only read and replaced as a whole, with settingsMutex held, so they are consistent even if
+// they are set while rounds are proven or verified. Their slices and maps are never modified in place, so copies of the
+// settings share them.
+type settings struct {
+	options             Options
+	proofBackend        ProofBackend
+	plonkSRS            *kzg.SRS
+	setupSeed           []byte
+	gpuProving          bool
+	circuitCache        *CircuitCache
+	setupKeyDir         string
+	proofMemory         *memoryBudget
+	proofWorkers        int
+	verificationWorkers int
+	storageSchemes      map[string]func(bucket string) (Storage, error)
+}
+
+// currentSettings are the settings of the package, guarded by settingsMutex.
+var currentSettings = settings{
+	options:             DefaultOptions(),
+	proofBackend:        BACKEND_GROTH16,
+	circuitCache:        &CircuitCache{capacity: DEFAULT_CIRCUIT_CACHE_CAPACITY, order: list.New(), entries: make(map[circuitKey]*list.Element)},
+	proofMemory:         newMemoryBudget(0),
+	proofWorkers:        1,
+	verificationWorkers: 1,
+	storageSchemes: map[string]func(bucket string) (Storage, error){
+		STORAGE_SCHEME_S3:    NewS3StorageFromEnv,
+		STORAGE_SCHEME_GCS:   NewGCSStorageFromEnv,
+		STORAGE_SCHEME_HTTPS: NewHTTPSStorage,
+	},
+}
+
+var settingsMutex sync.RWMutex
+
+// getSettings returns the settings of the package.
+func getSettings() settings {
+	settingsMutex.RLock()
+	defer settingsMutex.RUnlock()
+	return currentSettings
+}
+
+// updateSettings updates the settings of the package with update, which is given a copy of the settings, unless it
+// returns an error.
+func updateSettings(update func(s *settings) error) error {
+	settingsMutex.Lock()
+	defer settingsMutex.Unlock()
+	updated := currentSettings
+	if err := update(&updated); err != nil {
+		return err
+	}
+	currentSettings = updated
+	return nil
+}
+
+// SetOptions sets the options of the prover and the verifiers (DefaultOptions() by default). Options that are not
+// valid (e.g. a layout with absolute paths, or a padding larger than a batch) are reported when a round is proven or
+// verified with them. The options must not be modified after they are set, e.g. the bytes of SecretCopyKey.
+func SetOptions(options Options) {
+	updateSettings(func(s *settings) error {
+		s.options = options
+		return nil
+	})
+}
+
+// GetOptions returns the options of the prover and the verifiers.
+func GetOptions() Options {
+	return getSettings().options
+}
+
+// updateOptions updates the options of the prover and the verifiers with update, which is given a copy of them.
+func updateOptions(update func(options *Options)) {
+	updateSettings(func(s *settings) error {
+		update(&s.options)
+		return nil
+	})
+}
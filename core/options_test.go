@@ -0,0 +1,62 @@
+package core
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/consensys/gnark/test"
+)
+
+// setOptions updates the options with update for the test, and restores them once it is done.
+func setOptions(t *testing.T, update func(options *Options)) {
+	options := GetOptions()
+	t.Cleanup(func() { SetOptions(options) })
+	updateOptions(update)
+}
+
+func TestSetOptions(t *testing.T) {
+	assert := test.NewAssert(t)
+	defer SetOptions(GetOptions())
+
+	// the options are set as a whole, and default to DefaultOptions()
+	assert.Equal(DefaultOptions(), GetOptions())
+	options := DefaultOptions()
+	options.ProofEpoch = 20240101
+	options.OutputLayout.EpochDirectory = "epoch_{epoch}/"
+	SetOptions(options)
+	assert.Equal(options, GetOptions())
+	assert.Equal("out/epoch_20240101/", GetOptions().OutputLayout.RoundDir("out/"))
+}
+
+func TestSetOptionsConcurrently(t *testing.T) {
+	assert := test.NewAssert(t)
+	defer SetOptions(GetOptions())
+	defer SetProofWorkers(GetProofWorkers())
+	defer RegisterStorageScheme(STORAGE_SCHEME_HTTPS, NewHTTPSStorage)
+
+	// the options and settings can be changed while they are read, which see either the old or the new ones
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			options := DefaultOptions()
+			options.ProofEpoch = uint64(i % 2)
+			options.HierarchyLevels = i % 2
+			SetOptions(options)
+			assert.NoError(SetProofWorkers(1 + i%4))
+			RegisterStorageScheme(STORAGE_SCHEME_HTTPS, NewHTTPSStorage)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			options := GetOptions()
+			assert.Equal(int(options.ProofEpoch), options.HierarchyLevels)
+			assert.Contains([]int{1, 2, 3, 4}, GetProofWorkers())
+			_, _, err := storageOf("https://example.com/round/")
+			assert.NoError(err)
+		}
+	}()
+	wg.Wait()
+}
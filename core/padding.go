@@ -0,0 +1,67 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+
+	"bitgo.com/proof_of_reserves/circuit"
+)
+
+// validateDummyAccountPadding returns an error if the padding is not 0 or between 1 and the number of accounts of a
+// batch.
+func validateDummyAccountPadding(padding int) error {
+	if padding < 0 || padding > circuit.GetAccountsPerBatch() {
+		return fmt.Errorf("dummy account padding must be between 0 and %d accounts, got %d", circuit.GetAccountsPerBatch(), padding)
+	}
+	return nil
+}
+
+// dummyAccountsFile returns the path of the dummy accounts of a batch.
+func dummyAccountsFile(outDir string, batch int) string {
+	return outDir + DUMMY_ACCOUNTS_PREFIX + strconv.Itoa(batch) + ".json"
+}
+
+// applyDummyAccountPadding pads the given batches (whose index is given by firstBatch onwards) to padding accounts
+// with new dummy accounts, which are written to outDir. Their MerkleRoot and MerkleRootWithAssetSumHash are cleared so
+// they are recomputed by the prover (their AssetSum is unchanged, as dummy accounts have zero balances). The batches are
+// unchanged if padding is 0.
+func applyDummyAccountPadding(proofElements []ProofElements, firstBatch int, padding int, outDir string) {
+	if padding == 0 {
+		return
+	}
+	panicOnError(validateDummyAccountPadding(padding), "invalid dummy account padding")
+	for i := range proofElements {
+		accountCount := len(proofElements[i].Accounts)
+		if accountCount > padding {
+			panic(fmt.Sprintf("batch %d has %d accounts, more than the %d accounts it is padded to", firstBatch+i, accountCount, padding))
+		}
+		dummyAccounts := make([]circuit.GoAccount, padding-accountCount)
+		for j := range dummyAccounts {
+			dummyAccounts[j] = circuit.NewDummyAccount()
+		}
+		WriteDataToFile(dummyAccountsFile(outDir, firstBatch+i), ProofElements{Accounts: dummyAccounts})
+		proofElements[i] = appendDummyAccounts(proofElements[i], dummyAccounts)
+	}
+}
+
+// readDummyAccountPadding pads the given batches (whose index is given by firstBatch onwards) with the dummy accounts
+// the prover wrote to outDir, if the round was padded (a padding other than 0, see Options.DummyAccountPadding).
+func readDummyAccountPadding(proofElements []ProofElements, firstBatch int, padding int, outDir string) {
+	if padding == 0 {
+		return
+	}
+	for i := range proofElements {
+		dummyAccounts := ReadDataFromFile[ProofElements](dummyAccountsFile(outDir, firstBatch+i)).Accounts
+		proofElements[i] = appendDummyAccounts(proofElements[i], dummyAccounts)
+	}
+}
+
+// appendDummyAccounts returns the batch with the dummy accounts after its accounts, so the positions of its accounts
+// are unchanged.
+func appendDummyAccounts(proofElement ProofElements, dummyAccounts []circuit.GoAccount) ProofElements {
+	if len(dummyAccounts) == 0 {
+		return proofElement
+	}
+	accounts := append(append(make([]circuit.GoAccount, 0, len(proofElement.Accounts)+len(dummyAccounts)), proofElement.Accounts...), dummyAccounts...)
+	return ProofElements{Accounts: accounts, AssetSum: proofElement.AssetSum}
+}
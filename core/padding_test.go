@@ -0,0 +1,49 @@
+package core
+
+import (
+	"os"
+	"testing"
+
+	"github.com/consensys/gnark/test"
+)
+
+func TestDummyAccountPadding(t *testing.T) {
+	if testing.Short() {
+		t.Skip("proves rounds")
+	}
+	assert := test.NewAssert(t)
+	outDir := t.TempDir() + "/"
+	assert.NoError(os.MkdirAll(outDir+"secret", 0o755))
+	assert.NoError(os.MkdirAll(outDir+"public", 0o755))
+
+	// the batch of 6 accounts is proven as a batch of 8, padded with salted dummy accounts of zero balance
+	GenerateData(1, 6, outDir)
+	setOptions(t, func(options *Options) { options.DummyAccountPadding = 8 })
+	Prove(1, outDir)
+	assert.Equal(8, *readBottomLevelProof(outDir, 0).AccountCount)
+	assert.Equal(8, readRoundMetadata(outDir).DummyAccountPadding)
+	dummyAccounts := ReadDataFromFile[ProofElements](dummyAccountsFile(outDir, 0)).Accounts
+	assert.Equal(2, len(dummyAccounts))
+	for _, account := range dummyAccounts {
+		assert.True(account.IsSalted())
+		for _, balance := range account.Balance {
+			assert.Equal(0, balance.Sign())
+		}
+	}
+	assert.Equal(6, len(ReadDataFromFile[ProofElements](outDir+SECRET_DATA_PREFIX+"0.json").Accounts))
+
+	// the dummy accounts are added back when verifying, and get no user verification package
+	setOptions(t, func(options *Options) { options.DummyAccountPadding = 0 })
+	assert.NotPanics(func() { VerifyFull(1, outDir) })
+	assert.NotPanics(func() { VerifyFullStreaming(1, outDir) })
+	manifest := ExportUserPackages(1, outDir, t.TempDir(), ExportOptions{ShardSize: 1})
+	assert.Equal(6, manifest.UserCount)
+
+	// without them, the round does not verify
+	assert.NoError(os.Remove(dummyAccountsFile(outDir, 0)))
+	assert.Panics(func() { VerifyFull(1, outDir) })
+
+	// batches can't be padded to fewer accounts than they hold
+	setOptions(t, func(options *Options) { options.DummyAccountPadding = 4 })
+	assert.Panics(func() { Prove(1, outDir) })
+}
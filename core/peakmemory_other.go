@@ -0,0 +1,8 @@
+//go:build !unix
+
+package core
+
+// peakMemory returns 0, as the peak resident memory of the process is not read on this platform (see ProofMetrics).
+func peakMemory() uint64 {
+	return 0
+}
@@ -0,0 +1,21 @@
+//go:build unix
+
+package core
+
+import (
+	"runtime"
+	"syscall"
+)
+
+// peakMemory returns the peak resident memory of the process in bytes (see ProofMetrics), or 0 if it can't be read.
+func peakMemory() uint64 {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return 0
+	}
+	// macOS reports the peak resident memory in bytes, other systems in KiB
+	if runtime.GOOS == "darwin" || runtime.GOOS == "ios" {
+		return uint64(usage.Maxrss)
+	}
+	return uint64(usage.Maxrss) << 10
+}
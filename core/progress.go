@@ -0,0 +1,135 @@
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+// ProgressPhase is the phase of the proof of a batch a ProgressEvent reports.
+type ProgressPhase string
+
+const (
+	// PROGRESS_BATCH_STARTED is reported when the proof of a batch is started.
+	PROGRESS_BATCH_STARTED ProgressPhase = "started"
+	// PROGRESS_HASHING is reported when the merkle tree of a batch is hashed.
+	PROGRESS_HASHING ProgressPhase = "hashing"
+	// PROGRESS_PROVING is reported when the circuit of a batch is compiled (unless it is cached) and proven.
+	PROGRESS_PROVING ProgressPhase = "proving"
+	// PROGRESS_BATCH_FINISHED is reported when the proof of a batch is generated.
+	PROGRESS_BATCH_FINISHED ProgressPhase = "finished"
+)
+
+// ProgressEvent reports the progress of the proofs of a round (see WithProgress).
+type ProgressEvent struct {
+	// Layer is the layer of the proof ("bottom", "mid", or "top"), and Batch its index in the layer.
+	Layer string
+	Batch int
+	Phase ProgressPhase
+	// Finished is the number of proofs generated so far, out of the Total proofs of the round to generate (batches
+	// that are already proven, e.g. by a previous run of ProveContext, or that are copies of another batch, are not
+	// counted).
+	Finished int
+	Total    int
+	// Elapsed is the time since proving started.
+	Elapsed time.Duration
+	// ETA is the estimated time until every proof is generated, from the average time of the proofs generated so far
+	// (0 until the first proof is generated). Upper level proofs usually take longer than bottom level proofs.
+	ETA time.Duration
+}
+
+// ProverOption configures a run of Prove or ProveContext, e.g. WithProgress or DryRun.
+type ProverOption func(*proverSettings)
+
+// proverSettings are the settings of a run of the prover, set with ProverOption.
+type proverSettings struct {
+	progress func(ProgressEvent)
+	// dryRun is set to validate the batches instead of proving them (see DryRun).
+	dryRun bool
+}
+
+// WithProgress reports the progress of the run to the given callback: when the proof of each batch starts and
+// finishes, and whether it is being hashed or proven, along with an estimate of the time left. The callback is called
+// synchronously, one event at a time (even when batches are proven concurrently, see SetProofWorkers), so it should
+// return quickly.
+func WithProgress(report func(ProgressEvent)) ProverOption {
+	return func(settings *proverSettings) {
+		settings.progress = report
+	}
+}
+
+// newProverSettings applies the options to the default settings.
+func newProverSettings(opts []ProverOption) proverSettings {
+	var settings proverSettings
+	for _, opt := range opts {
+		opt(&settings)
+	}
+	return settings
+}
+
+// progressReporter reports ProgressEvents to a callback. A nil reporter reports nothing.
+type progressReporter struct {
+	mutex     sync.Mutex
+	report    func(ProgressEvent)
+	startedOn time.Time
+	finished  int
+	total     int
+}
+
+// newProgressReporter returns a reporter of the given number of proofs to the callback, or nil if there is no
+// callback.
+func newProgressReporter(report func(ProgressEvent), total int) *progressReporter {
+	if report == nil {
+		return nil
+	}
+	return &progressReporter{report: report, startedOn: time.Now(), total: total}
+}
+
+// plan adds the given number of proofs to the proofs to generate.
+func (reporter *progressReporter) plan(count int) {
+	if reporter == nil {
+		return
+	}
+	reporter.mutex.Lock()
+	defer reporter.mutex.Unlock()
+	reporter.total += count
+}
+
+// forBatch returns the reporter of the phases of the proof of a batch of the given layer.
+func (reporter *progressReporter) forBatch(layer string, batch int) *batchProgress {
+	if reporter == nil {
+		return nil
+	}
+	return &batchProgress{reporter: reporter, layer: layer, batch: batch}
+}
+
+// batchProgress reports the phases of the proof of a batch. A nil batchProgress reports nothing.
+type batchProgress struct {
+	reporter *progressReporter
+	layer    string
+	batch    int
+}
+
+// report reports that the proof of the batch entered the given phase.
+func (progress *batchProgress) report(phase ProgressPhase) {
+	if progress == nil {
+		return
+	}
+	reporter := progress.reporter
+	reporter.mutex.Lock()
+	defer reporter.mutex.Unlock()
+	if phase == PROGRESS_BATCH_FINISHED {
+		reporter.finished++
+	}
+	event := ProgressEvent{
+		Layer:    progress.layer,
+		Batch:    progress.batch,
+		Phase:    phase,
+		Finished: reporter.finished,
+		Total:    reporter.total,
+		Elapsed:  time.Since(reporter.startedOn),
+	}
+	if event.Finished > 0 && event.Total > event.Finished {
+		event.ETA = event.Elapsed / time.Duration(event.Finished) * time.Duration(event.Total-event.Finished)
+	}
+	reporter.report(event)
+}
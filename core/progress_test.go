@@ -0,0 +1,43 @@
+package core
+
+import (
+	"os"
+	"testing"
+
+	"github.com/consensys/gnark/test"
+)
+
+func TestProveWithProgress(t *testing.T) {
+	assert := test.NewAssert(t)
+	outDir := t.TempDir() + "/"
+	assert.NoError(os.MkdirAll(outDir+"secret", 0o755))
+	assert.NoError(os.MkdirAll(outDir+"public", 0o755))
+	GenerateData(2, 4, outDir)
+
+	events := make([]ProgressEvent, 0)
+	Prove(2, outDir, WithProgress(func(event ProgressEvent) { events = append(events, event) }))
+
+	// every proof reports each phase in order, bottom level proofs first
+	phases := []ProgressPhase{PROGRESS_BATCH_STARTED, PROGRESS_HASHING, PROGRESS_PROVING, PROGRESS_BATCH_FINISHED}
+	proofs := []struct {
+		layer string
+		batch int
+	}{{"bottom", 0}, {"bottom", 1}, {"mid", 0}, {"top", 0}}
+	assert.Equal(len(proofs)*len(phases), len(events))
+	for i, event := range events {
+		assert.Equal(proofs[i/len(phases)].layer, event.Layer)
+		assert.Equal(proofs[i/len(phases)].batch, event.Batch)
+		assert.Equal(phases[i%len(phases)], event.Phase)
+		assert.Equal(len(proofs), event.Total)
+		finished := i / len(phases)
+		if event.Phase == PROGRESS_BATCH_FINISHED {
+			finished++
+		}
+		assert.Equal(finished, event.Finished)
+	}
+
+	// the time left is estimated once a proof is generated, until the last one is
+	assert.Equal(int64(0), int64(events[0].ETA))
+	assert.True(events[len(phases)-1].ETA > 0)
+	assert.Equal(int64(0), int64(events[len(events)-1].ETA))
+}
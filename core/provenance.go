@@ -0,0 +1,214 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"bitgo.com/proof_of_reserves/circuit"
+)
+
+// describeFile returns the resource descriptor of the file at the given path relative to outDir. The digest is the
+// digest of the file as it was written, even if it was compressed since (see openFile).
+func describeFile(outDir string, name string) (ResourceDescriptor, error) {
+	data, err := readFile(outDir + name)
+	if err != nil {
+		return ResourceDescriptor{}, err
+	}
+	return describeData(name, data), nil
+}
+
+// describeData returns the resource descriptor of the file with the given path relative to outDir and contents.
+func describeData(name string, data []byte) ResourceDescriptor {
+	hash := sha256.Sum256(data)
+	return ResourceDescriptor{Name: name, Digest: map[string]string{PROVENANCE_DIGEST_ALGORITHM: hex.EncodeToString(hash[:])}}
+}
+
+// describeFiles returns the resource descriptors of the files at the given paths relative to outDir.
+func describeFiles(outDir string, names []string) ([]ResourceDescriptor, error) {
+	descriptors := make([]ResourceDescriptor, len(names))
+	for i, name := range names {
+		descriptor, err := describeFile(outDir, name)
+		if err != nil {
+			return nil, err
+		}
+		descriptors[i] = descriptor
+	}
+	return descriptors, nil
+}
+
+// provenanceSubjectNames returns the paths of the published proofs of a round with the given layer plan.
+func provenanceSubjectNames(plan LayerPlan) []string {
+	names := make([]string, 0)
+	for level := 0; level < plan.Levels(); level++ {
+		layer := levelLayer(level, plan.Levels())
+		for i := 0; i < plan.LevelProofCount(level); i++ {
+			names = append(names, layer.publicPrefix()+strconv.Itoa(i)+".json")
+		}
+	}
+	return names
+}
+
+// provenanceInputNames returns the paths of the secret inputs the proofs of a round were generated from.
+func provenanceInputNames(batchCount int, outDir string) []string {
+	names := make([]string, 0)
+	for i := 0; i < batchCount; i++ {
+		names = append(names, GetOptions().OutputLayout.secretDataFile(i))
+	}
+	for _, name := range []string{EXCLUSION_LIST_FILE, MIGRATION_FILE} {
+		if fileExists(outDir + name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// getProvenanceBuilderVersion returns the versions of the prover binary and its toolchain, if available.
+func getProvenanceBuilderVersion() map[string]string {
+	info, err := GetBuildInfo()
+	if err != nil {
+		return nil
+	}
+	version := map[string]string{"go": info.GoVersion, "module": info.ModuleVersion}
+	if revision, ok := info.Settings["vcs.revision"]; ok {
+		version["vcs.revision"] = revision
+	}
+	return version
+}
+
+// generateProvenance generates the provenance statement of the round in outDir with the given layer plan, using the
+// round id as its invocation id. The proofs must already be written.
+func generateProvenance(plan LayerPlan, outDir string, command string, roundId string, startedOn time.Time) ProvenanceStatement {
+	batchCount := plan.BottomLevelProofCount
+	subjects, err := describeFiles(outDir, provenanceSubjectNames(plan))
+	panicOnError(err, "error computing digests of proofs")
+	dependencies, err := describeFiles(outDir, provenanceInputNames(batchCount, outDir))
+	panicOnError(err, "error computing digests of inputs")
+
+	return ProvenanceStatement{
+		Type:          IN_TOTO_STATEMENT_TYPE,
+		Subject:       subjects,
+		PredicateType: SLSA_PROVENANCE_PREDICATE,
+		Predicate: ProvenancePredicate{
+			BuildDefinition: ProvenanceBuildDefinition{
+				BuildType:          PROVENANCE_BUILD_TYPE,
+				ExternalParameters: ProvenanceExternalParameters{Command: command, BatchCount: batchCount},
+				InternalParameters: ProvenanceInternalParameters{
+					TreeDepth:        circuit.GetTreeDepth(),
+					AccountsPerBatch: circuit.GetAccountsPerBatch(),
+					AssetCount:       circuit.GetNumberOfAssets(),
+					DeploymentDomain: circuit.GetDeploymentDomain(),
+				},
+				ResolvedDependencies: dependencies,
+			},
+			RunDetails: ProvenanceRunDetails{
+				Builder: ProvenanceBuilder{Id: GetOptions().ProvenanceBuilderId, Version: getProvenanceBuilderVersion()},
+				Metadata: ProvenanceMetadata{
+					InvocationId: roundId,
+					StartedOn:    startedOn.UTC().Format(time.RFC3339),
+					FinishedOn:   time.Now().UTC().Format(time.RFC3339),
+				},
+			},
+		},
+	}
+}
+
+// writeProvenance writes the provenance statement of the round in outDir with the given layer plan, generated by the given command, and returns the hex encoded SHA-256 hash
+// of the written file, to be recorded in the round metadata.
+func writeProvenance(plan LayerPlan, outDir string, command string, roundId string, startedOn time.Time) string {
+	statement := generateProvenance(plan, outDir, command, roundId, startedOn)
+	panicOnError(writeJson(outDir+PROVENANCE_FILE, statement), "error writing provenance statement")
+	descriptor, err := describeFile(outDir, PROVENANCE_FILE)
+	panicOnError(err, "error computing digest of provenance statement")
+	return descriptor.Digest[PROVENANCE_DIGEST_ALGORITHM]
+}
+
+// compareResourceDescriptors returns an error if the descriptors recorded in a provenance statement do not match
+// the descriptors computed from the files.
+func compareResourceDescriptors(label string, expected []ResourceDescriptor, actual []ResourceDescriptor) error {
+	if len(expected) != len(actual) {
+		return fmt.Errorf("expected %d %s in provenance statement, found %d", len(expected), label, len(actual))
+	}
+	for i := range expected {
+		if expected[i].Name != actual[i].Name {
+			return fmt.Errorf("expected %s %d to be %s, found %s", label, i, expected[i].Name, actual[i].Name)
+		}
+		if expected[i].Digest[PROVENANCE_DIGEST_ALGORITHM] != actual[i].Digest[PROVENANCE_DIGEST_ALGORITHM] {
+			return fmt.Errorf("digest of %s does not match the digest recorded in the provenance statement", expected[i].Name)
+		}
+	}
+	return nil
+}
+
+// verifyProvenance verifies the provenance statement of the round in outDir against its round metadata and the
+// published proofs, of the tree depth of the configuration. If checkInputs is set, the secret inputs are verified too.
+// The statement is read once, and decoded from the bytes whose hash was checked. Rounds without a statement fail: the
+// statement is listed in the manifest, so it is covered by the signatures of the round (see VerifyProvenance), and a
+// missing one is not evidence of anything.
+func verifyProvenance(batchCount int, outDir string, metadata RoundMetadata, checkInputs bool, config circuit.Config) error {
+	if metadata.ProvenanceHash == "" {
+		if !fileExists(outDir + PROVENANCE_FILE) {
+			return fmt.Errorf("round has no provenance statement")
+		}
+		return fmt.Errorf("round metadata has no provenance hash, but a provenance statement exists")
+	}
+
+	data, err := readFile(outDir + PROVENANCE_FILE)
+	if err != nil {
+		return fmt.Errorf("error reading provenance statement: %w", err)
+	}
+	if describeData(PROVENANCE_FILE, data).Digest[PROVENANCE_DIGEST_ALGORITHM] != metadata.ProvenanceHash {
+		return fmt.Errorf("hash of provenance statement does not match the hash recorded in the round metadata")
+	}
+	var statement ProvenanceStatement
+	if err := json.Unmarshal(data, &statement); err != nil {
+		return fmt.Errorf("error decoding provenance statement: %w", err)
+	}
+	if statement.Type != IN_TOTO_STATEMENT_TYPE || statement.PredicateType != SLSA_PROVENANCE_PREDICATE {
+		return fmt.Errorf("unexpected provenance statement type %s with predicate %s", statement.Type, statement.PredicateType)
+	}
+	if statement.Predicate.BuildDefinition.ExternalParameters.BatchCount != batchCount {
+		return fmt.Errorf("expected batch count %d in provenance statement, found %d", batchCount, statement.Predicate.BuildDefinition.ExternalParameters.BatchCount)
+	}
+
+	subjects, err := describeFiles(outDir, provenanceSubjectNames(roundLayerPlan(batchCount, metadata, config)))
+	if err != nil {
+		return fmt.Errorf("error computing digests of proofs: %w", err)
+	}
+	if err := compareResourceDescriptors("subject", subjects, statement.Subject); err != nil {
+		return err
+	}
+	if !checkInputs {
+		return nil
+	}
+	dependencies, err := describeFiles(outDir, provenanceInputNames(batchCount, outDir))
+	if err != nil {
+		return fmt.Errorf("error computing digests of inputs: %w", err)
+	}
+	return compareResourceDescriptors("input", dependencies, statement.Predicate.BuildDefinition.ResolvedDependencies)
+}
+
+// VerifyProvenance verifies the provenance statement of the round in outDir against its round metadata and the
+// published proofs. It only needs the public data, so it can be run by anyone with access to the published round. If
+// trusted public keys are given, the statement must also be the one listed in the manifest of the round, signed by one
+// of them (see SignRound), so it is known to come from the exchange.
+func VerifyProvenance(batchCount int, outDir string, trustedPublicKeys []ed25519.PublicKey) {
+	metadata := readRoundMetadata(outDir)
+	if len(trustedPublicKeys) > 0 {
+		manifest, err := VerifyManifestSignature(outDir, trustedPublicKeys)
+		panicOnError(err, "provenance verification failed")
+		index := slices.IndexFunc(manifest.Files, func(file ResourceDescriptor) bool { return file.Name == PROVENANCE_FILE })
+		if index < 0 {
+			panic("provenance verification failed: the signed manifest does not list the provenance statement")
+		}
+		if manifest.Files[index].Digest[PROVENANCE_DIGEST_ALGORITHM] != metadata.ProvenanceHash {
+			panic("provenance verification failed: the provenance statement is not the one listed in the signed manifest")
+		}
+	}
+	panicOnError(verifyProvenance(batchCount, outDir, metadata, false, circuit.GetConfig()), "provenance verification failed")
+}
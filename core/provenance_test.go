@@ -0,0 +1,104 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"github.com/consensys/gnark/test"
+)
+
+// writeTestRoundFiles writes placeholder files for the inputs and proofs of a round with one batch.
+func writeTestRoundFiles(t *testing.T, outDir string) {
+	for _, name := range append(provenanceInputNames(1, outDir), provenanceSubjectNames(NewLayerPlan(1))...) {
+		if err := os.MkdirAll(filepath.Dir(outDir+name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(outDir+name, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestVerifyProvenance(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// the rounds generated in TestMain have provenance statements
+	assert.NotPanics(func() { VerifyProvenance(batchCount, testOutDir, nil) })
+	assert.NotPanics(func() { VerifyProvenance(1, testAltDir, nil) })
+	assert.NotEmpty(readRoundMetadata(testOutDir).ProvenanceHash)
+
+	t.Run("Statement contents", func(t *testing.T) {
+		var statement ProvenanceStatement
+		assert.NoError(readJson(testOutDir+PROVENANCE_FILE, &statement))
+		assert.Equal(IN_TOTO_STATEMENT_TYPE, statement.Type)
+		assert.Equal(SLSA_PROVENANCE_PREDICATE, statement.PredicateType)
+		assert.Equal(provenanceSubjectNames(NewLayerPlan(batchCount))[0], statement.Subject[0].Name)
+		assert.Equal(batchCount+2, len(statement.Subject))
+		assert.Equal(batchCount, len(statement.Predicate.BuildDefinition.ResolvedDependencies))
+		assert.Equal(GetOptions().ProvenanceBuilderId, statement.Predicate.RunDetails.Builder.Id)
+	})
+
+	t.Run("Wrong batch count", func(t *testing.T) {
+		assert.Error(verifyProvenance(1, testOutDir, readRoundMetadata(testOutDir), false, circuit.GetConfig()))
+	})
+
+	t.Run("Wrong provenance hash", func(t *testing.T) {
+		metadata := readRoundMetadata(testOutDir)
+		metadata.ProvenanceHash = computeExclusionListHash([]ExcludedAccount{{WalletId: "a"}})
+		assert.Error(verifyProvenance(batchCount, testOutDir, metadata, false, circuit.GetConfig()))
+	})
+
+	t.Run("Statement without provenance hash", func(t *testing.T) {
+		assert.Error(verifyProvenance(batchCount, testOutDir, RoundMetadata{}, false, circuit.GetConfig()))
+	})
+
+	t.Run("Round without provenance", func(t *testing.T) {
+		outDir := t.TempDir() + "/"
+		writeTestRoundFiles(t, outDir)
+		assert.Error(verifyProvenance(1, outDir, RoundMetadata{}, true, circuit.GetConfig()))
+		assert.Error(verifyProvenance(1, outDir, RoundMetadata{}, false, circuit.GetConfig()))
+	})
+
+	t.Run("Signed statement", func(t *testing.T) {
+		publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		assert.NoError(err)
+		roundDir := copyPublicRound(t, testOutDir, t.TempDir(), "signed")
+		assert.Panics(func() { VerifyProvenance(batchCount, roundDir, []ed25519.PublicKey{publicKey}) }, "an unsigned round should not verify")
+		SignRound(batchCount, roundDir, privateKey)
+		assert.NotPanics(func() { VerifyProvenance(batchCount, roundDir, []ed25519.PublicKey{publicKey}) })
+
+		// a statement swapped along with the round metadata is not the one the manifest lists
+		var statement ProvenanceStatement
+		assert.NoError(readJson(roundDir+PROVENANCE_FILE, &statement))
+		statement.Predicate.RunDetails.Builder.Id = "https://example.com/other-builder"
+		assert.NoError(writeJson(roundDir+PROVENANCE_FILE, statement))
+		metadata := readRoundMetadata(roundDir)
+		descriptor, err := describeFile(roundDir, PROVENANCE_FILE)
+		assert.NoError(err)
+		metadata.ProvenanceHash = descriptor.Digest[PROVENANCE_DIGEST_ALGORITHM]
+		assert.NoError(writeJson(roundDir+ROUND_METADATA_FILE, metadata))
+		assert.NotPanics(func() { VerifyProvenance(batchCount, roundDir, nil) })
+		assert.Panics(func() { VerifyProvenance(batchCount, roundDir, []ed25519.PublicKey{publicKey}) })
+	})
+
+	t.Run("Modified files", func(t *testing.T) {
+		outDir := t.TempDir() + "/"
+		writeTestRoundFiles(t, outDir)
+		metadata := RoundMetadata{ProvenanceHash: writeProvenance(NewLayerPlan(1), outDir, "prove", newRoundId(), time.Now())}
+		assert.NoError(verifyProvenance(1, outDir, metadata, true, circuit.GetConfig()))
+
+		// modified inputs are only detected when checking inputs
+		assert.NoError(os.WriteFile(outDir+SECRET_DATA_PREFIX+"0.json", []byte("modified"), 0o644))
+		assert.NoError(verifyProvenance(1, outDir, metadata, false, circuit.GetConfig()))
+		assert.Error(verifyProvenance(1, outDir, metadata, true, circuit.GetConfig()))
+
+		// modified proofs are always detected
+		assert.NoError(os.WriteFile(outDir+TOP_PROOF_PREFIX+"0.json", []byte("modified"), 0o644))
+		assert.Error(verifyProvenance(1, outDir, metadata, false, circuit.GetConfig()))
+	})
+}
@@ -2,21 +2,117 @@ package core
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
-	"strconv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"bitgo.com/proof_of_reserves/circuit"
+	"bitgo.com/proof_of_reserves/verification"
 	"github.com/consensys/gnark-crypto/ecc"
 	"github.com/consensys/gnark/backend/groth16"
 	"github.com/consensys/gnark/frontend"
-	"github.com/consensys/gnark/frontend/cs/r1cs"
 )
 
-// cachedProofs means that we do not need to recompile the same Circuit repeatedly.
-var cachedProofs = make(map[int]PartialProof)
+// circuitKey identifies the shape of a compiled circuit.
+type circuitKey struct {
+	accountCount int
+	hashFunction circuit.HashFunction
+	hashVersion  circuit.HashVersion
+	leafHashMode circuit.LeafHashMode
+	backend      ProofBackend
+	config       circuit.Config
+	// assetRegistryHash identifies the asset registry, whose number of assets (or of the asset subset, if any) is the
+	// length of every balance
+	assetRegistryHash string
+	assetSubset       string
+	// lowerVerificationKeys are the fingerprints of the verification keys fixed in an aggregation circuit (empty for
+	// circuits that do not verify lower level proofs)
+	lowerVerificationKeys string
+	// setupSeed is the seed of deterministic setups (empty for random setups), see SetDeterministicSetup
+	setupSeed string
+}
+
+// newCircuitKey returns the key of the circuit for accountCount accounts with the configured hash function, hash
+// version, and leaf hash mode, backend, circuit configuration (including the deployment domain), and asset registry
+// and subset. With padded batches (see
+// circuit.Config.PaddedBatches), every count of accounts is proven with the circuit of a full batch.
+func newCircuitKey(accountCount int) circuitKey {
+	config := circuit.GetConfig()
+	if config.PaddedBatches {
+		accountCount = config.AccountsPerBatch()
+	}
+	return circuitKey{
+		accountCount:      accountCount,
+		hashFunction:      circuit.GetHashFunction(),
+		hashVersion:       circuit.GetHashVersion(),
+		leafHashMode:      circuit.GetLeafHashMode(),
+		backend:           GetProofBackend(),
+		config:            config,
+		assetRegistryHash: circuit.GetAssetRegistry().Hash(),
+		assetSubset:       fmt.Sprint(circuit.GetAssetSubset()),
+		setupSeed:         string(GetDeterministicSetup()),
+	}
+}
+
+// newUpperLevelCircuitKey returns the key of the circuit of an upper level proof of accountCount lower level proofs
+// (see circuit.Config.ForUpperLevel), like newCircuitKey.
+func newUpperLevelCircuitKey(accountCount int) circuitKey {
+	key := newCircuitKey(accountCount)
+	key.config = key.config.ForUpperLevel()
+	return key
+}
+
+// SetProofWorkers sets the number of bottom level proofs Prove generates concurrently (1 by default, which proves the
+// batches one at a time). It does not change any proof, nor the order they are written in, but each worker needs the
+// memory of proving a batch.
+func SetProofWorkers(workers int) error {
+	if workers < 1 {
+		return fmt.Errorf("number of proof workers must be at least 1, got %d", workers)
+	}
+	return updateSettings(func(s *settings) error {
+		s.proofWorkers = workers
+		return nil
+	})
+}
+
+func GetProofWorkers() int {
+	return getSettings().proofWorkers
+}
 
 // generateProof for single batch of accounts
-func generateProof(elements ProofElements) CompletedProof {
+func generateProof(elements ProofElements, progress *batchProgress) CompletedProof {
+	progress.report(PROGRESS_BATCH_STARTED)
+	proof := generateProofOfLowerLevelProofs(elements, nil, progress)
+	progress.report(PROGRESS_BATCH_FINISHED)
+	return proof
+}
+
+// newBaseCircuit returns a circuit for accountCount accounts with empty accounts and all-zero asset sum, to be
+// compiled with the given configuration.
+func newBaseCircuit(accountCount int, config circuit.Config) circuit.Circuit {
+	emptyAccounts := make([]circuit.Account, accountCount)
+	for i := range emptyAccounts {
+		emptyAccounts[i].Balance = circuit.ConstructBalance()
+	}
+	return circuit.Circuit{
+		Accounts: emptyAccounts,
+		AssetSum: circuit.ConstructBalance(),
+		Config:   config,
+	}
+}
+
+// generateProofOfLowerLevelProofs generates the proof of a batch of accounts. With BACKEND_GROTH16_RECURSIVE, the
+// accounts are the pseudo-accounts of the given lower level proofs, which are verified in the circuit (see
+// circuit.AggregationCircuit). Otherwise, lowerProofs is ignored. Its hashing and proving phases are reported to
+// progress.
+func generateProofOfLowerLevelProofs(elements ProofElements, lowerProofs []CompletedProof, progress *batchProgress) CompletedProof {
 	// preliminary checks
 	if elements.AssetSum == nil {
 		panic("AssetSum is nil")
@@ -25,61 +121,100 @@ func generateProof(elements ProofElements) CompletedProof {
 	if !actualBalances.Equals(*elements.AssetSum) {
 		panic("Asset sum does not match")
 	}
+	// the balances of upper level proofs are asset sums of lower level proofs, and are range checked like them
+	config := circuit.GetConfig()
+	if lowerProofs != nil {
+		config = config.ForUpperLevel()
+	}
+	// the circuit can't prove an asset sum outside of its range, so fail with a clear message rather than unsatisfied constraints
+	for i, bits := range circuit.GetAssetBalanceBits(config.GetAssetSumBits()) {
+		if !(circuit.GoBalance{actualBalances[i]}).IsWithinBits(bits) {
+			panic(fmt.Sprintf("Asset sum exceeds the %d-bit range of the circuit", bits))
+		}
+	}
+	// find every account the circuit can't prove before the expensive witness creation and proving (upper level proofs
+	// prove the pseudo-accounts of lower level proofs, whose WalletIds repeat for identical batches)
+	if lowerProofs == nil {
+		panicOnError(config.ValidateWitnessInputs(elements.Accounts, *elements.AssetSum), "invalid witness inputs")
+	}
 
-	// set merkle roots if non-existent
+	// set merkle roots if non-existent, and hash the merkle nodes (whose leaves are the hashes of the pseudo-accounts
+	// of the lower level proofs in upper level proofs)
+	progress.report(PROGRESS_HASHING)
+	var merkleNodes [][]circuit.Hash
+	if lowerProofs != nil {
+		merkleNodes = circuit.GoComputeMerkleTreeNodesFromPseudoAccounts(elements.Accounts)
+	} else {
+		merkleNodes = circuit.GoComputeMerkleTreeNodesFromAccounts(elements.Accounts)
+	}
+	panicOnError(verifyProofElementsRoots(elements, merkleNodes[0][0]), "inconsistent merkle roots")
 	if elements.MerkleRoot == nil {
-		elements.MerkleRoot = circuit.GoComputeMerkleRootFromAccounts(elements.Accounts)
+		elements.MerkleRoot = merkleNodes[0][0]
 	}
-	if elements.MerkleRootWithAssetSumHash == nil {
-		elements.MerkleRootWithAssetSumHash = circuit.GoComputeMiMCHashForAccount(circuit.GoAccount{WalletId: elements.MerkleRoot, Balance: *elements.AssetSum})
+	// batch files bind their merkle root to no epoch, so the hash is recomputed for the epoch the proof is bound to
+	epoch := GetOptions().ProofEpoch
+	if elements.MerkleRootWithAssetSumHash == nil || epoch != 0 {
+		elements.MerkleRootWithAssetSumHash = circuit.GoComputeRootWithAssetSumHash(elements.MerkleRoot, *elements.AssetSum, epoch)
 	}
 
-	// check if compiled proof cached already for this length of accounts
+	// check if compiled proof cached already for this length of accounts, hash function, version, and leaf hash mode,
+	// backend, and configuration (and the verification keys of the lower level proofs, if they are verified in the
+	// circuit)
+	progress.report(PROGRESS_PROVING)
 	proofLen := len(elements.Accounts)
-	if _, ok := cachedProofs[proofLen]; !ok {
-		var err error
-
-		// create a circuit with empty accounts and all-zero asset sum
-		emptyAccounts := make([]circuit.Account, proofLen)
-		for i := range emptyAccounts {
-			emptyAccounts[i].Balance = circuit.ConstructBalance()
+	key := newCircuitKey(proofLen)
+	key.config = config
+	var lowerLevel lowerLevelInputs
+	if key.backend == BACKEND_GROTH16_RECURSIVE && lowerProofs != nil {
+		if len(lowerProofs) != proofLen {
+			panic(fmt.Sprintf("expected a lower level proof for each of the %d accounts, got %d", proofLen, len(lowerProofs)))
 		}
-		c := &circuit.Circuit{
-			Accounts: emptyAccounts,
-			AssetSum: circuit.ConstructBalance(),
-		}
-
-		// compile, set up, and cache partial proof
-		cachedProof := PartialProof{}
-		cachedProof.cs, err = frontend.Compile(ecc.BN254.ScalarField(), r1cs.NewBuilder, c)
-		if err != nil {
-			panic("Circuit failed to compile: " + err.Error())
-		}
-		cachedProof.pk, cachedProof.vk, err = groth16.Setup(cachedProof.cs)
-		if err != nil {
-			panic("Failed to setup circuit: " + err.Error())
-		}
-		cachedProofs[proofLen] = cachedProof
+		lowerLevel = decodeLowerLevelProofs(lowerProofs)
+		key.lowerVerificationKeys = lowerLevel.fingerprints
+		// aggregation circuits verify a lower level proof for each of their accounts, so they can't be padded
+		key.accountCount, key.config.PaddedBatches = proofLen, false
 	}
+	metrics := &ProofMetrics{Accounts: proofLen}
+	cachedProof := getCachedProof(key, lowerLevel, metrics)
+	metrics.Constraints = cachedProof.cs.GetNbConstraints()
+	metrics.Wires = cachedProof.cs.GetNbPublicVariables() + cachedProof.cs.GetNbSecretVariables() + cachedProof.cs.GetNbInternalVariables()
 
 	// create witness using proof elements
 	witnessInput := circuit.Circuit{
-		Accounts:                   circuit.ConvertGoAccountsToAccounts(elements.Accounts),
+		Accounts:                   padAccounts(circuit.ConvertGoAccountsToAccounts(elements.Accounts), key.accountCount),
 		AssetSum:                   circuit.ConvertGoBalanceToBalance(*elements.AssetSum),
 		MerkleRoot:                 elements.MerkleRoot,
 		MerkleRootWithAssetSumHash: elements.MerkleRootWithAssetSumHash,
+		AccountCount:               proofLen,
+		Epoch:                      epoch,
+		CircuitVersion:             circuit.CIRCUIT_VERSION,
 	}
-	witness, err := frontend.NewWitness(&witnessInput, ecc.BN254.ScalarField())
+	var witnessCircuit frontend.Circuit = &witnessInput
+	if key.lowerVerificationKeys != "" {
+		witnessCircuit = &circuit.AggregationCircuit{
+			Circuit:            witnessInput,
+			LowerProofs:        lowerLevel.proofs,
+			LowerAccountCounts: lowerLevel.accountCounts,
+			LowerVerifyingKeys: lowerLevel.verifyingKeys,
+		}
+	}
+	witnessStart := time.Now()
+	witness, err := frontend.NewWitness(witnessCircuit, ecc.BN254.ScalarField())
 	if err != nil {
 		panic("Failed to create witness: " + err.Error())
 	}
+	metrics.WitnessDuration = time.Since(witnessStart)
 
-	// use cached partial proof to create a proof that witness satisfies constraints
-	cachedProof := cachedProofs[proofLen]
-	proof, err := groth16.Prove(cachedProof.cs, cachedProof.pk, witness)
+	// use cached partial proof to create a proof that witness satisfies constraints, once its memory fits in the budget
+	release := getSettings().proofMemory.reserve(estimateProofMemory(cachedProof.cs))
+	proveStart := time.Now()
+	proof, vk, prover, err := proveCircuit(cachedProof, witness)
+	release()
 	if err != nil {
 		panic("Failed to prove witness satisfies constraints: " + err.Error())
 	}
+	metrics.ProveDuration, metrics.Prover, metrics.PeakMemory = time.Since(proveStart), prover, peakMemory()
+	recordProofGenerated(proofLen, prover, metrics.ProveDuration)
 
 	// read proof and verification key from proof
 	proofBytes := bytes.Buffer{}
@@ -88,51 +223,243 @@ func generateProof(elements ProofElements) CompletedProof {
 		panic("Failed to read proof bytes from proof: " + err.Error())
 	}
 	vkBytes := bytes.Buffer{}
-	_, err = cachedProof.vk.WriteTo(&vkBytes)
+	_, err = vk.WriteTo(&vkBytes)
 	if err != nil {
 		panic("Failed to read verification key bytes from proof: " + err.Error())
 	}
 
 	// construct and return completed proof (do not init MerklePath or MerklePosition as we don't know the upper level proof)
-	return CompletedProof{
+	completedProof := CompletedProof{
 		Proof:                      base64.StdEncoding.EncodeToString(proofBytes.Bytes()),
 		VerificationKey:            base64.StdEncoding.EncodeToString(vkBytes.Bytes()),
 		MerkleRoot:                 elements.MerkleRoot,
 		MerkleRootWithAssetSumHash: elements.MerkleRootWithAssetSumHash,
-		MerkleNodes:                circuit.GoComputeMerkleTreeNodesFromAccounts(elements.Accounts),
+		MerkleNodes:                merkleNodes,
 		AssetSum:                   elements.AssetSum,
+		HashFunction:               key.hashFunction,
+		HashVersion:                key.hashVersion,
+		AccountBytesVersion:        circuit.GetAccountBytesVersion(),
+		LeafHashMode:               key.leafHashMode,
+		Backend:                    key.backend,
+		TreeDepth:                  key.config.TreeDepth,
+		BalanceBits:                key.config.GetBalanceBits(),
+		AssetSumBits:               key.config.GetAssetSumBits(),
+		AssetRegistryHash:          key.assetRegistryHash,
+		AssetSubset:                circuit.GetAssetSubset(),
+		AccountCount:               &proofLen,
+		Epoch:                      &epoch,
+		CircuitVersion:             circuit.CIRCUIT_VERSION,
+	}
+	generatedProofMetrics.Store(completedProof.Proof, metrics)
+	return completedProof
+}
+
+// padAccounts pads the accounts of a witness with zero accounts to the count of accounts of its circuit, which is
+// larger with padded batches (see circuit.Config.PaddedBatches).
+func padAccounts(accounts []circuit.Account, count int) []circuit.Account {
+	for len(accounts) < count {
+		accounts = append(accounts, circuit.Account{WalletId: 0, Balance: circuit.ConstructBalance(), Salt: 0})
+	}
+	return accounts
+}
+
+// getCachedProof returns the compiled and set up circuit of the key from the circuit cache, compiling and setting it up
+// and caching it first if it is not cached, in which case the time spent is recorded in metrics. Circuits are compiled
+// one at a time, so concurrent proofs of the same shape (see SetProofWorkers) compile it once.
+func getCachedProof(key circuitKey, lowerLevel lowerLevelInputs, metrics *ProofMetrics) PartialProof {
+	circuitCompileMutex.Lock()
+	defer circuitCompileMutex.Unlock()
+	circuitCache, setupKeyDir := GetCircuitCache(), GetSetupKeyDir()
+	if cachedProof, ok := circuitCache.get(key); ok {
+		recordCircuitReused(key.accountCount)
+		metrics.CircuitCached = true
+		return cachedProof
+	}
+
+	// create a circuit with empty accounts and all-zero asset sum
+	baseCircuit := newBaseCircuit(key.accountCount, key.config)
+	var c frontend.Circuit = &baseCircuit
+	if key.lowerVerificationKeys != "" {
+		c = &circuit.AggregationCircuit{
+			Circuit:            baseCircuit,
+			LowerProofs:        lowerLevel.proofs,
+			LowerAccountCounts: make([]frontend.Variable, key.accountCount),
+			LowerVerifyingKeys: lowerLevel.verifyingKeys,
+		}
+	}
+
+	// compile, set up, and cache partial proof
+	cachedProof := PartialProof{backend: key.backend}
+	var err error
+	compileStart := time.Now()
+	cachedProof.cs, err = compileCircuit(key.backend, c)
+	if err != nil {
+		panic("Circuit failed to compile: " + err.Error())
+	}
+	setupStart := time.Now()
+	if setupKeyDir != "" && key.lowerVerificationKeys == "" {
+		err = loadSetupKeys(&cachedProof, key, setupKeyDir)
+		panicOnError(err, "error loading circuit keys")
+	} else {
+		err = setupCircuit(&cachedProof)
+		if err != nil {
+			panic("Failed to setup circuit: " + err.Error())
+		}
+	}
+	metrics.CompileDuration, metrics.SetupDuration = setupStart.Sub(compileStart), time.Since(setupStart)
+	recordCircuitCompiled(key.accountCount, metrics.CompileDuration, metrics.SetupDuration)
+	circuitCache.add(key, cachedProof)
+	return cachedProof
+}
+
+// computeProofElementsKey returns a content address for proof elements (the SHA-256 hash of their raw JSON encoding),
+// so identical batches can be identified.
+func computeProofElementsKey(elements ProofElements) string {
+	encoded, err := json.Marshal(ConvertProofElementsToRawProofElements(elements))
+	panicOnError(err, "error encoding proof elements")
+	key := sha256.Sum256(encoded)
+	return string(key[:])
+}
+
+// verifyProofElementsRoots verifies the MerkleRoot and MerkleRootWithAssetSumHash of the batch, if set, are those of
+// its accounts, whose merkle root is given, and of its asset sum. The MerkleRootWithAssetSumHash of a batch file is bound
+// to no epoch, and that of the accounts of an upper level proof to the epoch of the proof (see Options.ProofEpoch).
+func verifyProofElementsRoots(elements ProofElements, merkleRoot []byte) error {
+	options := GetOptions()
+	if elements.MerkleRoot != nil && !bytes.Equal(elements.MerkleRoot, merkleRoot) {
+		return fmt.Errorf("MerkleRoot is %x, but the merkle root of the accounts is %x", elements.MerkleRoot, merkleRoot)
+	}
+	if elements.MerkleRootWithAssetSumHash == nil || elements.AssetSum == nil {
+		return nil
+	}
+	expected := circuit.GoComputeRootWithAssetSumHash(merkleRoot, *elements.AssetSum, 0)
+	if options.ProofEpoch != 0 && !bytes.Equal(elements.MerkleRootWithAssetSumHash, expected) {
+		expected = circuit.GoComputeRootWithAssetSumHash(merkleRoot, *elements.AssetSum, options.ProofEpoch)
 	}
+	if !bytes.Equal(elements.MerkleRootWithAssetSumHash, expected) {
+		return fmt.Errorf("MerkleRootWithAssetSumHash is %x, but the hash of the merkle root of the accounts with the asset sum is %x",
+			elements.MerkleRootWithAssetSumHash, expected)
+	}
+	return nil
 }
 
-// generate proofs for multiple batches
+// generate proofs for multiple batches of accounts, checking no batch includes an account twice
 func generateProofs(proofElements []ProofElements) []CompletedProof {
 	completedProofs := make([]CompletedProof, len(proofElements))
+	panicOnError(generateMissingProofs(context.Background(), proofElements, completedProofs, nil, nil), "error generating proofs")
+	return completedProofs
+}
+
+// generateMissingProofs generates the proof of every batch that has none in completedProofs (an empty Proof), checking
+// no batch includes an account twice, and calls onProven (if set) with each proof it generates, from the goroutine
+// that generated it. The progress of each proof is reported to progress. No batch is started once ctx is done.
+// Returns ctx.Err() if a batch was left without a proof.
+func generateMissingProofs(ctx context.Context, proofElements []ProofElements, completedProofs []CompletedProof, onProven func(i int, proof CompletedProof), progress *progressReporter) error {
+	// identical batches (e.g. batches of dummy accounts used for padding) have identical public inputs, so each
+	// distinct batch is only proven once and its proof is reused for the copies. Each copy still gets its own
+	// MerklePath and MerklePosition when they are set later.
+	provenBatches := make(map[string]int)
+	copiedBatches := make(map[int]int)
+	missingBatches := make([]int, 0, len(proofElements))
 	for i := 0; i < len(proofElements); i++ {
-		completedProofs[i] = generateProof(proofElements[i])
+		panicOnError(verifyDistinctWalletIds(proofElements[i].Accounts), fmt.Sprintf("duplicate accounts in batch %d", i))
+		// a batch whose merkle roots are not those of its accounts would only fail once its witness is proven, so it is
+		// rejected before any batch is (accounts that can't be hashed are reported when their batch is proven)
+		if proofElements[i].MerkleRoot != nil || proofElements[i].MerkleRootWithAssetSumHash != nil {
+			if merkleRoot, err := circuit.GoComputeMerkleRootFromAccountsWithError(proofElements[i].Accounts); err == nil {
+				panicOnError(verifyProofElementsRoots(proofElements[i], merkleRoot), fmt.Sprintf("inconsistent merkle roots in batch %d", i))
+			}
+		}
+		key := computeProofElementsKey(proofElements[i])
+		if j, ok := provenBatches[key]; ok {
+			copiedBatches[i] = j
+			continue
+		}
+		provenBatches[key] = i
+		if completedProofs[i].Proof == "" {
+			missingBatches = append(missingBatches, i)
+		}
 	}
-	return completedProofs
+
+	// the proofs are stored by batch index, so they are in batch order whatever order the workers finish in
+	progress.plan(len(missingBatches))
+	err := proveInParallel(ctx, missingBatches, func(i int) {
+		completedProofs[i] = generateProof(proofElements[i], progress.forBatch(bottomLevelLayer.name, i))
+		if onProven != nil {
+			onProven(i, completedProofs[i])
+		}
+	})
+	if err != nil {
+		return err
+	}
+	for i, j := range copiedBatches {
+		completedProofs[i] = completedProofs[j]
+	}
+	return nil
 }
 
-// writeProofsToFiles writes the proofs to files with the given prefix.
-// saveAssetSum should be set to true only for top level proofs, because
-// otherwise the asset sum may leak information about the balance composition of each batch
-// of accounts.
-func writeProofsToFiles(proofs []CompletedProof, prefix string, saveAssetSum bool, saveMerkleNodes bool) {
-	for i, proof := range proofs {
-		if !saveAssetSum {
-			proof.AssetSum = nil
+// proveInParallel calls prove for every batch index on up to proofWorkers goroutines, and returns once every batch is
+// proven, or once the batches being proven are done after ctx is done, in which case it returns ctx.Err(). A panic in
+// a batch stops the batches that aren't started yet, as with one worker, and is raised again in the caller once the
+// batches being proven are done.
+func proveInParallel(ctx context.Context, batches []int, prove func(i int)) error {
+	workers := min(GetProofWorkers(), len(batches))
+	if workers <= 1 {
+		for _, i := range batches {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			prove(i)
 		}
-		if !saveMerkleNodes {
-			proof.MerkleNodes = nil
+		return nil
+	}
+
+	pending := make(chan int, len(batches))
+	for j := range batches {
+		pending <- j
+	}
+	close(pending)
+	proven := make([]bool, len(batches))
+	var wg sync.WaitGroup
+	var panicOnce sync.Once
+	var panicValue any
+	var panicked atomic.Bool
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					panicOnce.Do(func() { panicValue = r })
+					panicked.Store(true)
+				}
+			}()
+			for j := range pending {
+				if ctx.Err() != nil || panicked.Load() {
+					return
+				}
+				prove(batches[j])
+				proven[j] = true
+			}
+		}()
+	}
+	wg.Wait()
+	if panicValue != nil {
+		panic(panicValue)
+	}
+	for _, ok := range proven {
+		if !ok {
+			return ctx.Err()
 		}
-		filePath := prefix + strconv.Itoa(i) + ".json"
-		WriteDataToFile(filePath, proof)
 	}
+	return nil
 }
 
 // generateNextLevelProofs generates the next level proofs by calling generateProof and treating the lower level
-// proofs as accounts, with MerkleRoot as WalletId and AssetSum as Balance.
-func generateNextLevelProofs(currentLevelProof []CompletedProof) CompletedProof {
+// proofs as accounts, with MerkleRoot as WalletId, AssetSum as Balance, and the epoch as Salt.
+func generateNextLevelProofs(currentLevelProof []CompletedProof, progress *batchProgress) CompletedProof {
+	options := GetOptions()
+	progress.report(PROGRESS_BATCH_STARTED)
 
 	// properly make accounts for next level proof using currentLevelProofs
 	nextLevelProofAccounts := make([]circuit.GoAccount, len(currentLevelProof))
@@ -140,62 +467,302 @@ func generateNextLevelProofs(currentLevelProof []CompletedProof) CompletedProof
 		if currentLevelProof[i].AssetSum == nil {
 			panic("AssetSum is nil")
 		}
+		if verification.EpochOf(currentLevelProof[i]) != options.ProofEpoch {
+			panic(fmt.Sprintf("lower level proof %d has epoch %d, but the proofs are generated for epoch %d", i, verification.EpochOf(currentLevelProof[i]), options.ProofEpoch))
+		}
 		// convert lower level proof to GoAccount struct
-		nextLevelProofAccounts[i] = circuit.GoAccount{WalletId: currentLevelProof[i].MerkleRoot, Balance: *currentLevelProof[i].AssetSum}
-		if !bytes.Equal(currentLevelProof[i].MerkleRootWithAssetSumHash, circuit.GoComputeMiMCHashForAccount(nextLevelProofAccounts[i])) {
+		nextLevelProofAccounts[i] = ConvertProofToGoAccount(currentLevelProof[i])
+		if !bytes.Equal(currentLevelProof[i].MerkleRootWithAssetSumHash, circuit.GoComputeRootWithAssetSumHash(currentLevelProof[i].MerkleRoot, *currentLevelProof[i].AssetSum, options.ProofEpoch)) {
 			panic("Merkle root with asset sum hash does not match")
 		}
 	}
 
 	// create next level proof
 	assetSum := circuit.SumGoAccountBalances(nextLevelProofAccounts)
-	merkleRoot := circuit.GoComputeMerkleRootFromAccounts(nextLevelProofAccounts)
-	return generateProof(ProofElements{
+	// every lower level asset sum is within range, but their sum may not be, which the circuit can't prove
+	for i, bits := range circuit.GetAssetBalanceBits(circuit.GetConfig().GetAssetSumBits()) {
+		if !(circuit.GoBalance{assetSum[i]}).IsWithinBits(bits) {
+			panic(fmt.Sprintf("sum of %s over %d lower level proofs is %s, which exceeds the maximum asset sum of the circuit (2^%d - 1)",
+				circuit.GetAssetSymbols()[i], len(currentLevelProof), assetSum[i], bits))
+		}
+	}
+	// the leaves of the lower level proofs are their merkle roots with asset sums, verified above
+	leaves := make([]circuit.Hash, len(currentLevelProof))
+	for i := range currentLevelProof {
+		leaves[i] = currentLevelProof[i].MerkleRootWithAssetSumHash
+	}
+	merkleRoot := circuit.GoComputeMerkleRootFromHashes(leaves)
+	proof := generateProofOfLowerLevelProofs(ProofElements{
 		Accounts:                   nextLevelProofAccounts,
 		MerkleRoot:                 merkleRoot,
 		AssetSum:                   &assetSum,
-		MerkleRootWithAssetSumHash: circuit.GoComputeMiMCHashForAccount(circuit.GoAccount{WalletId: merkleRoot, Balance: assetSum}),
-	})
+		MerkleRootWithAssetSumHash: circuit.GoComputeRootWithAssetSumHash(merkleRoot, assetSum, options.ProofEpoch),
+	}, currentLevelProof, progress)
+	progress.report(PROGRESS_BATCH_FINISHED)
+	return proof
+}
+
+// lowerLevelInputs are the inputs of an aggregation circuit taken from its lower level proofs.
+type lowerLevelInputs struct {
+	proofs        []circuit.LowerProof
+	verifyingKeys []circuit.LowerVerifyingKey
+	accountCounts []frontend.Variable
+	// fingerprints identifies the verification keys of the lower level proofs
+	fingerprints string
+}
+
+// decodeLowerLevelProofs decodes the proofs and verification keys of lower level proofs for an aggregation circuit.
+// The lower level proofs must have been generated with BACKEND_GROTH16_RECURSIVE.
+func decodeLowerLevelProofs(lowerProofs []CompletedProof) lowerLevelInputs {
+	inputs := lowerLevelInputs{
+		proofs:        make([]circuit.LowerProof, len(lowerProofs)),
+		verifyingKeys: make([]circuit.LowerVerifyingKey, len(lowerProofs)),
+		accountCounts: make([]frontend.Variable, len(lowerProofs)),
+	}
+	for i, lowerProof := range lowerProofs {
+		if lowerProof.Backend != BACKEND_GROTH16_RECURSIVE || lowerProof.AccountCount == nil {
+			panic(fmt.Sprintf("lower level proof %d can't be verified recursively, it must be generated with the %s backend", i, BACKEND_GROTH16_RECURSIVE))
+		}
+		inputs.accountCounts[i] = *lowerProof.AccountCount
+
+		proofBytes, err := base64.StdEncoding.DecodeString(lowerProof.Proof)
+		panicOnError(err, "error decoding lower level proof")
+		proof := groth16.NewProof(ecc.BN254)
+		_, err = proof.ReadFrom(bytes.NewReader(proofBytes))
+		panicOnError(err, "error reading lower level proof")
+		inputs.proofs[i], err = circuit.ConvertGroth16ProofToLowerProof(proof)
+		panicOnError(err, "error converting lower level proof")
+
+		vkBytes, err := base64.StdEncoding.DecodeString(lowerProof.VerificationKey)
+		panicOnError(err, "error decoding lower level verification key")
+		vk := groth16.NewVerifyingKey(ecc.BN254)
+		_, err = vk.ReadFrom(bytes.NewReader(vkBytes))
+		panicOnError(err, "error reading lower level verification key")
+		inputs.verifyingKeys[i], err = circuit.ConvertGroth16VerifyingKeyToLowerVerifyingKey(vk)
+		panicOnError(err, "error converting lower level verification key")
+
+		fingerprint, err := ComputeVerificationKeyFingerprint(lowerProof.VerificationKey)
+		panicOnError(err, "error fingerprinting lower level verification key")
+		inputs.fingerprints += fingerprint + ","
+	}
+	return inputs
 }
 
 // setLowerLevelProofsMerklePaths sets the MerklePath and MerklePosition for each lower level proof given corresponding
 // upper level proofs. Updates contents of lowerLevelProofs directly so nothing is returned.
 func setLowerLevelProofsMerklePaths(lowerLevelProofs []CompletedProof, upperLevelProofs []CompletedProof) {
+	accountsPerBatch := circuit.GetAccountsPerBatch()
 	for i := range lowerLevelProofs {
-		upperLevelProofIndex := i / circuit.ACCOUNTS_PER_BATCH
+		upperLevelProofIndex := i / accountsPerBatch
 		if upperLevelProofIndex >= len(upperLevelProofs) {
 			panic("not enough upperLevelProofs given for lowerLevelProofs")
 		}
 
 		lowerLevelProofs[i].MerklePath = circuit.ComputeMerklePath(
-			i%circuit.ACCOUNTS_PER_BATCH,
+			i%accountsPerBatch,
 			upperLevelProofs[upperLevelProofIndex].MerkleNodes,
 		)
-		lowerLevelProofs[i].MerklePosition = i % circuit.ACCOUNTS_PER_BATCH
+		lowerLevelProofs[i].MerklePosition = i % accountsPerBatch
+	}
+}
+
+// newRoundId returns a random identifier for a new round, which every artifact of the round is tagged with so
+// artifacts of different rounds cannot be mixed up.
+func newRoundId() string {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	panicOnError(err, "error generating round id")
+	return hex.EncodeToString(id)
+}
+
+// main proof generation function, configured with the given options (e.g. WithProgress, or DryRun to only validate the
+// batches), proving the round of Options.ProofEpoch in its directory of outDir (see Options.OutputLayout). If it panics, the files it
+// wrote are moved to 'secret/run_<round id>/failed/' and those it overwrote restored (see runJournal), so the directory
+// is left with the files of the previous run. Panics if proving fails (see ProveWithError).
+func Prove(batchCount int, outDir string, opts ...ProverOption) {
+	panicOnStageError(ProveWithError(batchCount, outDir, opts...))
+}
+
+// prove generates the proofs of a round, see Prove. Returns a *ProveError if a step of its own fails.
+func prove(batchCount int, outDir string, opts ...ProverOption) (err error) {
+	options := GetOptions()
+	startedOn := time.Now()
+	outDir = options.OutputLayout.RoundDir(outDir)
+	if err := createRoundDirectories(outDir); err != nil {
+		return &ProveError{Stage: "error creating round directories", Err: err}
+	}
+	settings := newProverSettings(opts)
+	if settings.dryRun {
+		proveDryRun(batchCount, outDir)
+		return nil
+	}
+	roundId := newRoundId()
+	defer beginProverAudit(outDir, roundId).end(&err)
+	defer beginRun(outDir, roundId).end(&err)
+	plan := NewLayerPlan(batchCount)
+	if err := plan.Validate(); err != nil {
+		return &ProveError{Stage: "invalid number of batches", Err: err}
+	}
+	if err := options.ProofPublicationPolicy.Validate(); err != nil {
+		return &ProveError{Stage: "invalid publication policy", Err: err}
+	}
+	if err := validateProofBackendConfig(); err != nil {
+		return &ProveError{Stage: "invalid configuration for the proof backend", Err: err}
+	}
+	migration, err := readRoundMigration(outDir, plan)
+	if err != nil {
+		return &ProveError{Stage: "invalid tree depth migration", Err: err}
+	}
+	progress := newProgressReporter(settings.progress, plan.UpperLevelProofCount())
+
+	// bottom level proofs (of the assets of the asset subset, and excluding the accounts in the exclusion list, if any)
+	proofElements, exclusions, excludedAccounts := prepareBatches(batchCount, outDir, false)
+	sparseTree := newSparseTree()
+	addToSparseTree(sparseTree, proofElements, 0)
+	bottomLevelProofs := make([]CompletedProof, batchCount)
+	if err := generateMissingProofs(context.Background(), proofElements, bottomLevelProofs, nil, progress); err != nil {
+		return &ProveError{Stage: "error generating proofs", Err: err}
+	}
+	for i := range bottomLevelProofs {
+		bottomLevelProofs[i].RoundId = roundId
+	}
+
+	upperLevelProofs := generateUpperLevelProofs(bottomLevelProofs, plan, progress)
+
+	// write all the proofs to files, followed by the files describing the round
+	writeProofsToFiles(bottomLevelProofs, outDir, bottomLevelLayer)
+	writeRound(outDir, roundId, "prove", plan, migration, upperLevelProofs, exclusions, excludedAccounts, sparseTreeRoot(sparseTree), startedOn)
+	return nil
+}
+
+// prepareBatches reads the batches in outDir, and prepares them to be proven: restricted to the assets of the asset
+// subset, with the accounts of each user merged (see Options.AggregateUserAccounts), without the accounts of the exclusion
+// list, and padded with dummy accounts (see Options.DummyAccountPadding), which are new ones unless reuseDummyAccounts is set,
+// in which case those written by a previous run are read back. Returns the batches, the exclusion list, and a report
+// entry for each excluded account.
+func prepareBatches(batchCount int, outDir string, reuseDummyAccounts bool) ([]ProofElements, []ExcludedAccount, []ExcludedAccountReport) {
+	options := GetOptions()
+	proofElements := ReadDataFromFiles[ProofElements](batchCount, outDir+options.OutputLayout.secretDataPrefix())
+	applyAssetSubset(proofElements, circuit.GetAssetSubset())
+	if options.AggregateUserAccounts {
+		applyAccountAggregation(proofElements, circuit.GetConfig())
+	}
+	exclusions := readExclusionList(outDir)
+	excludedAccounts := applyExclusionList(proofElements, 0, exclusions)
+	if reuseDummyAccounts {
+		readDummyAccountPadding(proofElements, 0, options.DummyAccountPadding, outDir)
+	} else {
+		applyDummyAccountPadding(proofElements, 0, options.DummyAccountPadding, outDir)
 	}
+	return proofElements, exclusions, excludedAccounts
 }
 
-// main proof generation function
-func Prove(batchCount int, outDir string) {
-	// bottom level proofs
-	proofElements := ReadDataFromFiles[ProofElements](batchCount, outDir+SECRET_DATA_PREFIX)
-	bottomLevelProofs := generateProofs(proofElements)
+// applyAssetSubset restricts the balances of the accounts of the given batches, which have every asset of the asset
+// registry, to the assets of the given asset subset (see circuit.SetAssetSubset). The AssetSum of each batch is
+// restricted too, and its MerkleRoot and MerkleRootWithAssetSumHash are cleared so they are recomputed by the prover.
+// The batches are unchanged if there is no asset subset.
+func applyAssetSubset(proofElements []ProofElements, assetSubset []int) {
+	if assetSubset == nil {
+		return
+	}
+	registry := circuit.GetAssetRegistry()
+	for i := range proofElements {
+		accounts := make([]circuit.GoAccount, len(proofElements[i].Accounts))
+		for j, account := range proofElements[i].Accounts {
+			balance, err := registry.SelectSubset(assetSubset, account.Balance)
+			panicOnError(err, fmt.Sprintf("error selecting the asset subset of account %d of batch %d", j, i))
+			accounts[j] = circuit.GoAccount{WalletId: account.WalletId, Balance: balance, Salt: account.Salt}
+		}
+		var assetSum *circuit.GoBalance
+		if proofElements[i].AssetSum != nil {
+			selected, err := registry.SelectSubset(assetSubset, *proofElements[i].AssetSum)
+			panicOnError(err, fmt.Sprintf("error selecting the asset subset of the asset sum of batch %d", i))
+			assetSum = &selected
+		}
+		proofElements[i] = ProofElements{Accounts: accounts, AssetSum: assetSum}
+	}
+}
+
+// applyAccountAggregation merges the accounts of each user in each of the given batches into one account (see
+// circuit.AggregateAccountsByUserId), whose balances have the number of assets of the configuration. The MerkleRoot and
+// MerkleRootWithAssetSumHash of each affected batch are cleared so they are recomputed by the prover (its AssetSum is
+// unchanged).
+func applyAccountAggregation(proofElements []ProofElements, config circuit.Config) {
+	for i := range proofElements {
+		accounts, err := config.AggregateAccountsByUserIdWithError(proofElements[i].Accounts)
+		panicOnError(err, fmt.Sprintf("error aggregating the accounts of batch %d", i))
+		if len(accounts) == len(proofElements[i].Accounts) {
+			continue
+		}
+		proofElements[i] = ProofElements{Accounts: accounts, AssetSum: proofElements[i].AssetSum}
+	}
+}
 
-	// mid level proofs
-	midLevelProofs := make([]CompletedProof, 0)
-	for _, batch := range batchProofs(bottomLevelProofs, circuit.ACCOUNTS_PER_BATCH) {
-		midLevelProofs = append(midLevelProofs, generateNextLevelProofs(batch))
+// generateUpperLevelProofs generates the proofs of the levels above the bottom level of a round with the plan for the
+// given bottom level proofs, and sets the merkle paths of the proofs of every level below the top level. Returns the
+// proofs of each level from the mid level up, the last of which is the top level proof. The progress of each proof is
+// reported to progress.
+func generateUpperLevelProofs(bottomLevelProofs []CompletedProof, plan LayerPlan, progress *progressReporter) [][]CompletedProof {
+	levels := [][]CompletedProof{bottomLevelProofs}
+	for level := 1; level < plan.Levels(); level++ {
+		layer := levelLayer(level, plan.Levels())
+		proofs := make([]CompletedProof, 0)
+		for i, batch := range batchProofs(levels[level-1], circuit.GetAccountsPerBatch()) {
+			proofs = append(proofs, generateNextLevelProofs(batch, progress.forBatch(layer.name, i)))
+		}
+		levels = append(levels, proofs)
 	}
 
-	// top level proof
-	topLevelProof := generateNextLevelProofs(midLevelProofs)
+	// set merkle paths of the proofs of every level below the top level
+	for level := 1; level < len(levels); level++ {
+		setLowerLevelProofsMerklePaths(levels[level-1], levels[level])
+	}
+	return levels[1:]
+}
 
-	// set merkle paths of bottom and midlevel proofs
-	setLowerLevelProofsMerklePaths(bottomLevelProofs, midLevelProofs)
-	setLowerLevelProofsMerklePaths(midLevelProofs, []CompletedProof{topLevelProof})
+// writeRound writes the proofs of the levels above the bottom level (see generateUpperLevelProofs), followed by the
+// provenance statement, round metadata, and succinct round proof if any (public), the round report (secret), and the
+// manifest of the published files (public), all tagged with the round id, and signs the published files if there is an
+// Options.ArtifactSigner. The bottom level proofs must already be written. The tree depth migration is that of the round (see
+// readRoundMigration), checked before it was proven, or nil. The sparse tree root is nil if no sparse Merkle tree was built (see Options.BuildSparseTree).
+func writeRound(outDir string, roundId string, command string, plan LayerPlan, migration *TreeDepthMigration, upperLevelProofs [][]CompletedProof, exclusions []ExcludedAccount, excludedAccounts []ExcludedAccountReport, sparseTreeRoot Hash, startedOn time.Time) {
+	options := GetOptions()
+	for i, proofs := range upperLevelProofs {
+		for j := range proofs {
+			proofs[j].RoundId = roundId
+		}
+		writeProofsToFiles(proofs, outDir, levelLayer(i+1, plan.Levels()))
+	}
+	topLevelProof := upperLevelProofs[len(upperLevelProofs)-1][0]
 
-	// write all the proofs to files
-	writeProofsToFiles(bottomLevelProofs, outDir+BOTTOM_PROOF_PREFIX, false, true)
-	writeProofsToFiles(midLevelProofs, outDir+MIDDLE_PROOF_PREFIX, false, false)
-	writeProofsToFiles([]CompletedProof{topLevelProof}, outDir+TOP_PROOF_PREFIX, true, false)
+	provenanceHash := writeProvenance(plan, outDir, command, roundId, startedOn)
+	panicOnError(
+		writeJson(outDir+ROUND_METADATA_FILE, RoundMetadata{
+			ExclusionListHash:      computeExclusionListHash(exclusions),
+			ExcludedAccountCount:   len(excludedAccounts),
+			ProvenanceHash:         provenanceHash,
+			DeploymentDomain:       circuit.GetDeploymentDomain(),
+			LayerPlan:              plan,
+			TreeDepth:              circuit.GetTreeDepth(),
+			TreeDepthMigration:     migration,
+			CircuitVersion:         circuit.CIRCUIT_VERSION,
+			RoundId:                roundId,
+			WalletIdEncoding:       circuit.GetWalletIdEncoding(),
+			AssetSubset:            circuit.GetAssetSubset(),
+			AggregatedUserAccounts: options.AggregateUserAccounts,
+			SparseTreeRoot:         sparseTreeRoot,
+			DummyAccountPadding:    options.DummyAccountPadding,
+		}),
+		"error writing round metadata",
+	)
+	writeSuccinctRoundProof(outDir, plan, topLevelProof)
+	report := RoundReport{RoundId: roundId, ExcludedAccounts: excludedAccounts}
+	if topLevelProof.AssetSum != nil {
+		report.LiabilitySum = ConvertGoBalanceToRawUVBalances(*topLevelProof.AssetSum)
+	}
+	panicOnError(writeJson(outDir+ROUND_REPORT_FILE, report), "error writing round report")
+	writeManifest(plan, outDir, roundId, startedOn)
+	if options.ArtifactSigner != nil {
+		panicOnError(signArtifacts(plan, outDir, roundId, options.ArtifactSigner), "error signing round")
+	}
 }
@@ -0,0 +1,546 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"bitgo.com/proof_of_reserves/verification"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark-crypto/ecc/bn254/kzg"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/test"
+)
+
+func TestGenerateProofsDeduplicatesIdenticalBatches(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	proofs := generateProofs([]ProofElements{testData0, testData1, testData0})
+	assert.Equal(3, len(proofs))
+
+	// the identical batches should reuse the same proof, the different one should not
+	assert.Equal(proofs[0].Proof, proofs[2].Proof)
+	assert.NotEqual(proofs[0].Proof, proofs[1].Proof)
+	assert.NoError(verifyGeneratedProof(proofs[2], bottomLevelLayer.name))
+
+	// each copy should still get its own merkle path and position
+	upperLevelProof := generateNextLevelProofs(proofs, nil)
+	setLowerLevelProofsMerklePaths(proofs, []CompletedProof{upperLevelProof})
+	assert.Equal(0, proofs[0].MerklePosition)
+	assert.Equal(2, proofs[2].MerklePosition)
+	assert.NotEqual(proofs[0].MerklePath[0], proofs[2].MerklePath[0])
+	for _, proof := range proofs {
+		assert.NoError(verification.VerifyMerklePath(proof.MerkleRootWithAssetSumHash, proof.MerklePosition, proof.MerklePath, upperLevelProof.MerkleRoot, circuit.GetConfig()))
+	}
+}
+
+func TestProverMetrics(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// a batch of a new shape compiles a circuit, the following batches of the same shape reuse it
+	elements := ProofElements{Accounts: testData0.Accounts[:3]}
+	assetSum := circuit.SumGoAccountBalances(elements.Accounts)
+	elements.AssetSum = &assetSum
+	GetCircuitCache().remove(newCircuitKey(len(elements.Accounts)))
+	ResetProverMetrics()
+	generateProof(elements, nil)
+	generateProof(elements, nil)
+
+	metrics := GetProverMetrics()
+	assert.Equal(1, metrics.CacheMisses)
+	assert.Equal(1, metrics.CacheHits)
+	assert.Equal([]int{3}, metrics.CompiledShapes)
+	assert.True(metrics.CompileDuration > 0)
+	assert.True(metrics.SetupDuration > 0)
+	assert.True(metrics.ProveDuration > 0)
+	assert.Equal(2, len(metrics.Batches))
+	assert.Equal(BatchMetrics{Accounts: 3, Prover: PROVER_CPU, ProveDuration: metrics.Batches[0].ProveDuration}, metrics.Batches[0])
+
+	ResetProverMetrics()
+	assert.Equal(0, GetProverMetrics().CacheHits)
+	assert.Equal(0, len(GetProverMetrics().CompiledShapes))
+}
+
+func TestWriteProofMetrics(t *testing.T) {
+	assert := test.NewAssert(t)
+	outDir := t.TempDir() + "/"
+	assert.NoError(os.MkdirAll(outDir+"secret", 0o755))
+	assert.NoError(os.MkdirAll(outDir+"public", 0o755))
+
+	// every proof of the round gets a metrics file next to its secret copy
+	setOptions(t, func(options *Options) { options.WriteProofMetrics = true })
+	GenerateData(2, 4, outDir)
+	Prove(2, outDir)
+	for _, layer := range []struct {
+		prefix string
+		name   string
+		count  int
+	}{{SECRET_BOTTOM_PROOF_PREFIX, "bottom", 2}, {SECRET_MIDDLE_PROOF_PREFIX, "mid", 1}, {SECRET_TOP_PROOF_PREFIX, "top", 1}} {
+		for i := 0; i < layer.count; i++ {
+			var metrics ProofMetrics
+			assert.NoError(readJson(outDir+layer.prefix+strconv.Itoa(i)+PROOF_METRICS_SUFFIX, &metrics))
+			assert.Equal(layer.name, metrics.Layer)
+			assert.Equal(i, metrics.Batch)
+			assert.True(metrics.Constraints > 0 && metrics.Wires > 0, "expected the size of the circuit")
+			assert.True(metrics.ProveDuration > 0)
+			assert.Equal(PROVER_CPU, metrics.Prover)
+		}
+	}
+	var bottomMetrics ProofMetrics
+	assert.NoError(readJson(outDir+SECRET_BOTTOM_PROOF_PREFIX+"0"+PROOF_METRICS_SUFFIX, &bottomMetrics))
+	assert.Equal(4, bottomMetrics.Accounts)
+
+	// nothing is written by default
+	setOptions(t, func(options *Options) { options.WriteProofMetrics = false })
+	outDir = t.TempDir() + "/"
+	assert.NoError(os.MkdirAll(outDir+"secret", 0o755))
+	assert.NoError(os.MkdirAll(outDir+"public", 0o755))
+	GenerateData(1, 4, outDir)
+	Prove(1, outDir)
+	_, err := os.Stat(outDir + SECRET_BOTTOM_PROOF_PREFIX + "0" + PROOF_METRICS_SUFFIX)
+	assert.True(os.IsNotExist(err))
+}
+
+func TestCircuitStats(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// the stats of a compiled circuit are those of its cached constraint system, and do not compile it again
+	elements := ProofElements{Accounts: testData0.Accounts[:3]}
+	assetSum := circuit.SumGoAccountBalances(elements.Accounts)
+	elements.AssetSum = &assetSum
+	generateProof(elements, nil)
+	ResetProverMetrics()
+	constraints, wires, err := CircuitStats(3)
+	assert.NoError(err)
+	cachedProof, _ := GetCircuitCache().get(newCircuitKey(3))
+	cs := cachedProof.cs
+	assert.Equal(cs.GetNbConstraints(), constraints)
+	assert.True(wires > constraints)
+	assert.Equal(0, GetProverMetrics().CacheMisses)
+
+	// circuits that were not compiled are compiled, and grow with the accounts
+	moreConstraints, _, err := CircuitStats(5)
+	assert.NoError(err)
+	assert.True(moreConstraints > constraints)
+	_, _, err = CircuitStats(0)
+	assert.Error(err)
+	_, _, err = CircuitStats(circuit.GetAccountsPerBatch() + 1)
+	assert.Error(err)
+
+	// the components account for every constraint but the checks of the account count and circuit version
+	components, err := CircuitComponentStats(3)
+	assert.NoError(err)
+	assert.Equal(constraints, components.RangeChecks+components.Merkle+components.Sum+2)
+}
+
+func TestGPUProvingFallsBackToCPU(t *testing.T) {
+	assert := test.NewAssert(t)
+	defer SetGPUProving(false)
+
+	// without the icicle build tag (or a CUDA device), proofs are generated on the CPU, and verify like any other
+	elements := ProofElements{Accounts: testData0.Accounts[:3]}
+	assetSum := circuit.SumGoAccountBalances(elements.Accounts)
+	elements.AssetSum = &assetSum
+	SetGPUProving(true)
+	ResetProverMetrics()
+	proof := generateProof(elements, nil)
+	assert.NoError(verifyGeneratedProof(proof, bottomLevelLayer.name))
+	if !icicleAvailable {
+		metrics := GetProverMetrics()
+		assert.Equal(0, metrics.GPUProofs)
+		assert.Equal(PROVER_CPU, metrics.Batches[0].Prover)
+	}
+}
+
+func TestGenerateProofWithPlonk(t *testing.T) {
+	if testing.Short() {
+		t.Skip("sets up PLONK circuits")
+	}
+	assert := test.NewAssert(t)
+	defer SetProofBackend(BACKEND_GROTH16)
+
+	elements := ProofElements{Accounts: testData0.Accounts[:3]}
+	assetSum := circuit.SumGoAccountBalances(elements.Accounts)
+	elements.AssetSum = &assetSum
+	assert.NoError(SetProofBackend(BACKEND_PLONK))
+	proof := generateProof(elements, nil)
+	plonkKey := newCircuitKey(len(elements.Accounts))
+	assert.NoError(SetProofBackend(BACKEND_GROTH16))
+
+	// the proof records its backend, and is verified with it whatever the configured backend is
+	assert.Equal(BACKEND_PLONK, proof.Backend)
+	assert.NoError(verifyGeneratedProof(proof, bottomLevelLayer.name))
+	tamperedProof := proof
+	tamperedProof.MerkleRoot = proofLower1.MerkleRoot
+	assert.Error(verifyProof(tamperedProof, bottomLevelLayer.name))
+	mislabeledProof := proof
+	mislabeledProof.Backend = BACKEND_GROTH16
+	assert.Error(verifyProof(mislabeledProof, bottomLevelLayer.name))
+
+	// an SRS that is too small for the circuit is rejected
+	smallSRS, err := kzg.NewSRS(4, big.NewInt(42))
+	assert.NoError(err)
+	SetPlonkSRS(smallSRS)
+	defer SetPlonkSRS(nil)
+	plonkProof, _ := GetCircuitCache().get(plonkKey)
+	_, _, err = plonkSRSForCircuit(plonkProof.cs)
+	assert.Error(err)
+}
+
+func TestProveRejectsRecursiveGroth16AtLargeTreeDepths(t *testing.T) {
+	assert := test.NewAssert(t)
+	defer SetProofBackend(BACKEND_GROTH16)
+
+	// the aggregation circuits of the default tree depth would be far too large to prove
+	assert.NoError(SetProofBackend(BACKEND_GROTH16_RECURSIVE))
+	err := ProveWithError(2, t.TempDir()+"/")
+	var proveError *ProveError
+	assert.True(errors.As(err, &proveError))
+	assert.Equal("invalid configuration for the proof backend", proveError.Stage)
+}
+
+func TestGenerateProofWithRecursiveGroth16(t *testing.T) {
+	assert := test.NewAssert(t)
+	defer SetProofBackend(BACKEND_GROTH16)
+	defer circuit.SetConfig(circuit.DefaultConfig())
+
+	// generate two bottom level proofs that an upper level proof can verify recursively
+	assert.NoError(SetProofBackend(BACKEND_GROTH16_RECURSIVE))
+	assert.NoError(circuit.SetConfig(circuit.Config{TreeDepth: 1}))
+	setOptions(t, func(options *Options) { options.ProofEpoch = 20240131 })
+	lowerProofs := make([]CompletedProof, 2)
+	for i, accounts := range [][]circuit.GoAccount{testData0.Accounts[:2], testData0.Accounts[2:3]} {
+		assetSum := circuit.SumGoAccountBalances(accounts)
+		lowerProofs[i] = generateProof(ProofElements{Accounts: accounts, AssetSum: &assetSum}, nil)
+	}
+
+	// the proofs record their backend, and are verified with it
+	assert.Equal(BACKEND_GROTH16_RECURSIVE, lowerProofs[0].Backend)
+	assert.NoError(verifyGeneratedProof(lowerProofs[0], bottomLevelLayer.name))
+	mislabeledProof := lowerProofs[0]
+	mislabeledProof.Backend = BACKEND_GROTH16
+	assert.Error(verifyProof(mislabeledProof, bottomLevelLayer.name))
+
+	// the proofs satisfy the aggregation circuit of their pseudo-accounts, which is what generateNextLevelProofs
+	// proves (proving it needs a setup of millions of constraints, which is too slow for a test)
+	lowerLevel := decodeLowerLevelProofs(lowerProofs)
+	pseudoAccounts := []circuit.GoAccount{ConvertProofToGoAccount(lowerProofs[0]), ConvertProofToGoAccount(lowerProofs[1])}
+	assetSum := circuit.SumGoAccountBalances(pseudoAccounts)
+	merkleRoot := circuit.GoComputeMerkleTreeNodesFromPseudoAccounts(pseudoAccounts)[0][0]
+	placeholderAccounts := make([]circuit.Account, 2)
+	for i := range placeholderAccounts {
+		placeholderAccounts[i].Balance = circuit.ConstructBalance()
+	}
+	placeholder := &circuit.AggregationCircuit{
+		Circuit:            circuit.Circuit{Accounts: placeholderAccounts, AssetSum: circuit.ConstructBalance(), Config: circuit.GetConfig().ForUpperLevel()},
+		LowerProofs:        lowerLevel.proofs,
+		LowerAccountCounts: make([]frontend.Variable, 2),
+		LowerVerifyingKeys: lowerLevel.verifyingKeys,
+	}
+	assignment := &circuit.AggregationCircuit{
+		Circuit: circuit.Circuit{
+			Accounts:                   circuit.ConvertGoAccountsToAccounts(pseudoAccounts),
+			AssetSum:                   circuit.ConvertGoBalanceToBalance(assetSum),
+			MerkleRoot:                 merkleRoot,
+			MerkleRootWithAssetSumHash: circuit.GoComputeRootWithAssetSumHash(merkleRoot, assetSum, GetOptions().ProofEpoch),
+			AccountCount:               2,
+			Epoch:                      GetOptions().ProofEpoch,
+			CircuitVersion:             circuit.CIRCUIT_VERSION,
+		},
+		LowerProofs:        lowerLevel.proofs,
+		LowerAccountCounts: lowerLevel.accountCounts,
+		LowerVerifyingKeys: lowerLevel.verifyingKeys,
+	}
+	assert.NoError(test.IsSolved(placeholder, assignment, ecc.BN254.ScalarField()))
+
+	// only proofs of the recursive backend can be verified recursively
+	assert.Panics(func() { decodeLowerLevelProofs([]CompletedProof{mislabeledProof}) })
+}
+
+func TestParseProofBackend(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// proofs that do not record a backend used Groth16
+	backend, err := ParseProofBackend("")
+	assert.NoError(err)
+	assert.Equal(BACKEND_GROTH16, backend)
+	backend, err = ParseProofBackend("plonk")
+	assert.NoError(err)
+	assert.Equal(BACKEND_PLONK, backend)
+	backend, err = ParseProofBackend("groth16-recursive")
+	assert.NoError(err)
+	assert.Equal(BACKEND_GROTH16_RECURSIVE, backend)
+	_, err = ParseProofBackend("stark")
+	assert.Error(err)
+	assert.Error(SetProofBackend("stark"))
+	assert.Equal(BACKEND_GROTH16, GetProofBackend())
+}
+
+func TestGenerateProofsRejectsOverflowingAssetSum(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// two balances within the range of the circuit whose sum is not are rejected before anything is proven
+	maxBalance := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), circuit.BALANCE_BITS), big.NewInt(1))
+	accounts := []circuit.GoAccount{
+		{WalletId: testData1.Accounts[0].WalletId, Balance: circuit.ConstructGoBalance(maxBalance)},
+		{WalletId: testData1.Accounts[1].WalletId, Balance: circuit.ConstructGoBalance(maxBalance)},
+	}
+	assetSum := circuit.SumGoAccountBalances(accounts)
+	assert.PanicsWithValue(
+		"Asset sum exceeds the 128-bit range of the circuit",
+		func() { generateProofs([]ProofElements{{Accounts: accounts, AssetSum: &assetSum}}) },
+	)
+}
+
+func TestGenerateNextLevelProofsRejectsOverflowingAssetSum(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// two lower level proofs with asset sums within the range of the circuit whose sum is not are rejected before
+	// anything is proven
+	maxBalance := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), circuit.BALANCE_BITS), big.NewInt(1))
+	assetSum := circuit.ConstructGoBalance(maxBalance)
+	epoch := GetOptions().ProofEpoch
+	lowerProofs := make([]CompletedProof, 2)
+	for i := range lowerProofs {
+		merkleRoot := testData1.Accounts[i].WalletId
+		lowerProofs[i] = CompletedProof{
+			MerkleRoot:                 merkleRoot,
+			AssetSum:                   &assetSum,
+			MerkleRootWithAssetSumHash: circuit.GoComputeRootWithAssetSumHash(merkleRoot, assetSum, epoch),
+			Epoch:                      &epoch,
+		}
+	}
+	assert.PanicsWithValue(
+		fmt.Sprintf("sum of %s over 2 lower level proofs is %s, which exceeds the maximum asset sum of the circuit (2^128 - 1)",
+			circuit.GetAssetSymbols()[0], new(big.Int).Add(maxBalance, maxBalance)),
+		func() { generateNextLevelProofs(lowerProofs, nil) },
+	)
+}
+
+func TestGenerateProofsWithBalanceBits(t *testing.T) {
+	assert := test.NewAssert(t)
+	defer circuit.SetConfig(circuit.DefaultConfig())
+
+	// the upper level proof range checks its balances to the asset sum bits of the bottom level proof
+	elements := ProofElements{Accounts: testData0.Accounts[:3]}
+	assetSum := circuit.SumGoAccountBalances(elements.Accounts)
+	elements.AssetSum = &assetSum
+	assert.NoError(circuit.SetConfig(circuit.Config{TreeDepth: circuit.TREE_DEPTH, BalanceBits: 64, AssetSumBits: 96}))
+	bottomProof := generateProof(elements, nil)
+	upperProof := generateNextLevelProofs([]CompletedProof{bottomProof}, nil)
+	assert.Equal(64, bottomProof.BalanceBits)
+	assert.Equal(96, bottomProof.AssetSumBits)
+	assert.Equal(96, upperProof.BalanceBits)
+	assert.Equal(96, upperProof.AssetSumBits)
+	assert.NoError(verifyGeneratedProof(bottomProof, bottomLevelLayer.name))
+	assert.NoError(verifyGeneratedProof(upperProof, midLevelLayer.name))
+	assert.NoError(verification.VerifyBalanceBits(bottomProof, upperProof))
+	assert.Error(verification.VerifyBalanceBits(bottomProof, bottomProof))
+
+	// an asset sum outside the asset sum bits is rejected before anything is proven
+	assert.NoError(circuit.SetConfig(circuit.Config{TreeDepth: circuit.TREE_DEPTH, BalanceBits: 8}))
+	assert.PanicsWithValue(
+		"Asset sum exceeds the 8-bit range of the circuit",
+		func() { generateProof(elements, nil) },
+	)
+}
+
+func TestGenerateProofsRejectsDuplicateAccounts(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// an account included twice in a batch is rejected before anything is proven
+	accounts := append(append([]circuit.GoAccount{}, testData1.Accounts...), testData1.Accounts[0])
+	assetSum := circuit.SumGoAccountBalances(accounts)
+	assert.PanicsWithError(
+		"duplicate accounts in batch 1: accounts 0 and "+strconv.Itoa(len(accounts)-1)+" have the same WalletId",
+		func() { generateProofs([]ProofElements{testData0, {Accounts: accounts, AssetSum: &assetSum}}) },
+	)
+}
+
+func TestGenerateProofsRejectsInconsistentMerkleRoots(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// a batch whose merkle root is not that of its accounts is rejected before anything is proven
+	wrongRoot := testData1
+	wrongRoot.MerkleRoot = testData0.MerkleRoot
+	assert.PanicsWithError(
+		fmt.Sprintf("inconsistent merkle roots in batch 1: MerkleRoot is %x, but the merkle root of the accounts is %x", testData0.MerkleRoot, testData1.MerkleRoot),
+		func() { generateProofs([]ProofElements{testData0, wrongRoot}) },
+	)
+
+	// and so is a batch whose hash of the merkle root with the asset sum is not that of its merkle root and asset sum
+	wrongHash := testData1
+	wrongHash.MerkleRootWithAssetSumHash = testData0.MerkleRootWithAssetSumHash
+	assert.PanicsWithError(
+		fmt.Sprintf("inconsistent merkle roots in batch 1: MerkleRootWithAssetSumHash is %x, but the hash of the merkle root of the accounts with the asset sum is %x",
+			testData0.MerkleRootWithAssetSumHash, testData1.MerkleRootWithAssetSumHash),
+		func() { generateProofs([]ProofElements{testData0, wrongHash}) },
+	)
+	assert.PanicsWithError(
+		fmt.Sprintf("inconsistent merkle roots: MerkleRoot is %x, but the merkle root of the accounts is %x", testData0.MerkleRoot, testData1.MerkleRoot),
+		func() { generateProof(wrongRoot, nil) },
+	)
+
+	// the hash of an upper level proof's accounts is bound to the epoch of the proof
+	setOptions(t, func(options *Options) { options.ProofEpoch = 20240101 })
+	boundHash := testData1
+	boundHash.MerkleRootWithAssetSumHash = circuit.GoComputeRootWithAssetSumHash(testData1.MerkleRoot, *testData1.AssetSum, GetOptions().ProofEpoch)
+	assert.NoError(verifyProofElementsRoots(testData1, testData1.MerkleRoot))
+	assert.NoError(verifyProofElementsRoots(boundHash, testData1.MerkleRoot))
+	assert.Error(verifyProofElementsRoots(wrongHash, testData1.MerkleRoot))
+}
+
+func TestGenerateProofRejectsInvalidWitnessInputs(t *testing.T) {
+	assert := test.NewAssert(t)
+	defer circuit.SetConfig(circuit.DefaultConfig())
+
+	// a balance outside the range of the circuit is rejected before anything is proven, even if the asset sum is not
+	accounts := []circuit.GoAccount{
+		{WalletId: testData1.Accounts[0].WalletId, Balance: circuit.ConstructGoBalance(big.NewInt(1))},
+		{WalletId: testData1.Accounts[1].WalletId, Balance: circuit.ConstructGoBalance(big.NewInt(256))},
+	}
+	assetSum := circuit.SumGoAccountBalances(accounts)
+	assert.NoError(circuit.SetConfig(circuit.Config{TreeDepth: circuit.TREE_DEPTH, BalanceBits: 8, AssetSumBits: 16}))
+	assert.PanicsWithError(
+		"invalid witness inputs: 1 invalid witness inputs: account 1 ("+circuit.GetAssetSymbols()[0]+"): balance 256 exceeds the 8-bit range of the circuit",
+		func() { generateProof(ProofElements{Accounts: accounts, AssetSum: &assetSum}, nil) },
+	)
+}
+
+func TestProveWithAggregatedUserAccounts(t *testing.T) {
+	assert := test.NewAssert(t)
+	outDir := t.TempDir() + "/"
+	assert.NoError(os.MkdirAll(outDir+"secret", 0o755))
+	assert.NoError(os.MkdirAll(outDir+"public", 0o755))
+
+	// the generated batch has a user with two accounts, which are proven as one account
+	setOptions(t, func(options *Options) { options.AggregateUserAccounts = true })
+	GenerateData(1, 4, outDir)
+	Prove(1, outDir)
+	setOptions(t, func(options *Options) { options.AggregateUserAccounts = false })
+	accounts := ReadDataFromFile[ProofElements](outDir + SECRET_DATA_PREFIX + "0.json").Accounts
+	assert.Equal(accounts[1].WalletId, accounts[2].WalletId)
+	assert.Equal(3, *readBottomLevelProof(outDir, 0).AccountCount)
+	assert.True(readRoundMetadata(outDir).AggregatedUserAccounts)
+
+	// the accounts are merged the same way when verifying and exporting, as recorded in the round metadata
+	assert.NotPanics(func() { VerifyFull(1, outDir) })
+	manifest := ExportUserPackages(1, outDir, t.TempDir(), ExportOptions{ShardSize: 1})
+	assert.Equal(3, manifest.UserCount)
+}
+
+func TestProveWithProofWorkers(t *testing.T) {
+	if testing.Short() {
+		t.Skip("proves a round")
+	}
+	assert := test.NewAssert(t)
+	outDir := t.TempDir() + "/"
+	assert.NoError(os.MkdirAll(outDir+"secret", 0o755))
+	assert.NoError(os.MkdirAll(outDir+"public", 0o755))
+	assert.Error(SetProofWorkers(0))
+	assert.NoError(SetProofWorkers(3))
+	defer SetProofWorkers(1)
+	assert.Equal(3, GetProofWorkers())
+
+	// the bottom level proofs are written in batch order, whatever order the workers finish in
+	GenerateData(4, 5, outDir)
+	Prove(4, outDir)
+	for i := 0; i < 4; i++ {
+		accounts := ReadDataFromFile[ProofElements](outDir + SECRET_DATA_PREFIX + strconv.Itoa(i) + ".json").Accounts
+		assert.Equal(circuit.GoComputeMerkleRootFromAccounts(accounts), readBottomLevelProof(outDir, i).MerkleRoot)
+	}
+	assert.NotPanics(func() { VerifyFull(4, outDir) })
+
+	// a batch that can't be proven panics as it does with one worker
+	elements := ReadDataFromFiles[ProofElements](4, outDir+SECRET_DATA_PREFIX)
+	elements[2].AssetSum = nil
+	assert.PanicsWithValue("AssetSum is nil", func() { generateProofs(elements) })
+}
+
+func TestProveInParallelStopsAfterPanic(t *testing.T) {
+	assert := test.NewAssert(t)
+	assert.NoError(SetProofWorkers(2))
+	defer SetProofWorkers(1)
+
+	// once a batch panics, no other batch is started, and the panic is raised again as it was raised
+	batches := make([]int, 100)
+	for i := range batches {
+		batches[i] = i
+	}
+	var started atomic.Int32
+	assert.PanicsWithValue("AssetSum is nil", func() {
+		_ = proveInParallel(context.Background(), batches, func(i int) {
+			started.Add(1)
+			if i == 0 {
+				panic("AssetSum is nil")
+			}
+			time.Sleep(10 * time.Millisecond)
+		})
+	})
+	assert.Less(started.Load(), int32(len(batches)/2))
+}
+
+func TestProveWithPaddedBatches(t *testing.T) {
+	assert := test.NewAssert(t)
+	config := circuit.GetConfig()
+	assert.NoError(circuit.SetConfig(circuit.Config{TreeDepth: 2, PaddedBatches: true}))
+	defer func() { assert.NoError(circuit.SetConfig(config)) }()
+
+	// the last batch has 3 of the 4 accounts of a full batch
+	outDir := t.TempDir() + "/"
+	GenerateData(2, 4, outDir)
+	lastBatchPath := outDir + SECRET_DATA_PREFIX + "1.json"
+	accounts := ReadDataFromFile[ProofElements](lastBatchPath).Accounts[:3]
+	assetSum := circuit.SumGoAccountBalances(accounts)
+	WriteDataToFile(lastBatchPath, ProofElements{Accounts: accounts, AssetSum: &assetSum})
+
+	// every proof of the round is proven with the circuit of a full batch, and records how many accounts it covers (the
+	// upper level proofs have a circuit of their own, see circuit.Config.UpperLevel)
+	ResetProverMetrics()
+	Prove(2, outDir)
+	assert.Equal([]int{4, 4}, GetProverMetrics().CompiledShapes)
+	assert.Equal(3, *readBottomLevelProof(outDir, 1).AccountCount)
+	assert.Equal(circuit.GoComputeMerkleRootFromAccounts(accounts), readBottomLevelProof(outDir, 1).MerkleRoot)
+	assert.NotPanics(func() { VerifyFull(2, outDir) })
+
+	// the padding of the proof can't hold an account left out of the batch
+	WriteDataToFile(lastBatchPath, ProofElements{Accounts: accounts[:2], AssetSum: &assetSum})
+	assert.Panics(func() { VerifyFull(2, outDir) })
+}
+
+func TestDeterministicSetup(t *testing.T) {
+	if testing.Short() {
+		t.Skip("sets up circuits three times")
+	}
+	assert := test.NewAssert(t)
+	defer SetDeterministicSetup(nil)
+
+	elements := ProofElements{Accounts: testData0.Accounts[:3]}
+	assetSum := circuit.SumGoAccountBalances(elements.Accounts)
+	elements.AssetSum = &assetSum
+
+	// setting the circuit up again with the same seed gives the same keys and proof
+	SetDeterministicSetup([]byte("golden"))
+	assert.Equal([]byte("golden"), GetDeterministicSetup())
+	proof := generateProof(elements, nil)
+	GetCircuitCache().remove(newCircuitKey(len(elements.Accounts)))
+	sameProof := generateProof(elements, nil)
+	assert.Equal(proof.VerificationKey, sameProof.VerificationKey)
+	assert.Equal(proof.Proof, sameProof.Proof)
+	assert.NoError(verifyGeneratedProof(sameProof, bottomLevelLayer.name))
+
+	// another seed, or no seed, gives other keys
+	SetDeterministicSetup([]byte("other"))
+	otherProof := generateProof(elements, nil)
+	assert.NotEqual(proof.VerificationKey, otherProof.VerificationKey)
+	assert.NoError(verifyGeneratedProof(otherProof, bottomLevelLayer.name))
+	SetDeterministicSetup(nil)
+	assert.NotEqual(proof.VerificationKey, generateProof(elements, nil).VerificationKey)
+}
@@ -0,0 +1,472 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"bitgo.com/proof_of_reserves/verification"
+)
+
+// MerkleNodesPolicy is which merkle nodes of a proof are written.
+type MerkleNodesPolicy string
+
+const (
+	// MERKLE_NODES_NONE drops the merkle nodes (the zero value does too).
+	MERKLE_NODES_NONE MerkleNodesPolicy = "none"
+	// MERKLE_NODES_LEAVES keeps the leaf layer (e.g. to index users) and drops the internal nodes.
+	MERKLE_NODES_LEAVES MerkleNodesPolicy = "leaves"
+	// MERKLE_NODES_ALL keeps every merkle node.
+	MERKLE_NODES_ALL MerkleNodesPolicy = "all"
+)
+
+// ProofFieldPolicy is which optional fields of the proofs of a layer are written.
+type ProofFieldPolicy struct {
+	AssetSum    bool
+	MerkleNodes MerkleNodesPolicy
+	// AssetSums is which asset sums are disclosed in a summary written next to each proof (no summary is written if it
+	// is nil). It is independent of AssetSum, which writes the whole asset sum in the proof.
+	AssetSums *AssetSumDisclosure `json:",omitempty"`
+}
+
+// AssetSumDisclosure is which asset sums of a proof its asset sum summary discloses (see AssetSumSummary), e.g. the
+// sums of the major assets, with the long tail of assets aggregated into a single "OTHER" line.
+type AssetSumDisclosure struct {
+	// Assets are the symbols of the assets whose sums are disclosed individually (every asset if empty).
+	Assets []string `json:",omitempty"`
+	// Other is the name of the line the sums of the other assets are aggregated into. The other assets are left out
+	// of the summary if it is empty.
+	Other string `json:",omitempty"`
+	// Prices are the prices of a display unit of each other asset, as decimal strings in the unit the aggregated sums
+	// are valued in (e.g. "64000.5" for BTC in USD).
+	Prices map[string]string `json:",omitempty"`
+}
+
+// LayerFieldPolicies are the ProofFieldPolicy of each layer of proofs.
+type LayerFieldPolicies struct {
+	BottomLevel ProofFieldPolicy
+	MidLevel    ProofFieldPolicy
+	TopLevel    ProofFieldPolicy
+}
+
+// PublicationPolicy governs exactly which fields of the proofs land in the public directory, and in the copies of
+// the proofs kept in the secret directory, which are encrypted if Options.SecretCopyKey is set (e.g. to keep the asset sums of
+// every proof internally without them being readable from the secret directory). Every proof written by the prover
+// goes through it.
+type PublicationPolicy struct {
+	Public LayerFieldPolicies
+	// Secret is the policy of the copies written to the secret directory (no copies are written if it is nil).
+	Secret *LayerFieldPolicies
+}
+
+// DefaultPublicationPolicy publishes the asset sum of the top level proof only, and every merkle node of the bottom
+// level proofs (which are needed to export user verification packages), without secret copies.
+func DefaultPublicationPolicy() PublicationPolicy {
+	return PublicationPolicy{
+		Public: LayerFieldPolicies{
+			BottomLevel: ProofFieldPolicy{MerkleNodes: MERKLE_NODES_ALL},
+			MidLevel:    ProofFieldPolicy{MerkleNodes: MERKLE_NODES_NONE},
+			TopLevel:    ProofFieldPolicy{AssetSum: true, MerkleNodes: MERKLE_NODES_NONE},
+		},
+	}
+}
+
+// Validate checks the policy can be enforced: asset sums below the top level, which may leak information about the
+// balance composition of each batch, are never published, the asset sum of the top level proof is always
+// published, and every merkle node of the bottom level proofs is written somewhere, as user verification packages
+// are built from them. Asset sum summaries, which may disclose asset sums too, are only published for the top level
+// proof, and must only name assets of the configured asset registry (and asset subset), with a price for every asset
+// they aggregate. The secret copy key, if set, must be an AES-256 key.
+func (policy PublicationPolicy) Validate() error {
+	options := GetOptions()
+	if options.SecretCopyKey != nil && len(options.SecretCopyKey) != SECRET_COPY_KEY_SIZE {
+		return fmt.Errorf("secret copy key must be %d bytes, found %d", SECRET_COPY_KEY_SIZE, len(options.SecretCopyKey))
+	}
+	layers := []LayerFieldPolicies{policy.Public}
+	if policy.Secret != nil {
+		layers = append(layers, *policy.Secret)
+	}
+	for _, layer := range layers {
+		for _, fields := range []ProofFieldPolicy{layer.BottomLevel, layer.MidLevel, layer.TopLevel} {
+			switch fields.MerkleNodes {
+			case "", MERKLE_NODES_NONE, MERKLE_NODES_LEAVES, MERKLE_NODES_ALL:
+			default:
+				return fmt.Errorf("unknown merkle nodes policy %q", fields.MerkleNodes)
+			}
+			if fields.AssetSums != nil {
+				if err := fields.AssetSums.validate(); err != nil {
+					return fmt.Errorf("invalid asset sum disclosure: %w", err)
+				}
+			}
+		}
+	}
+	if policy.Public.BottomLevel.AssetSum || policy.Public.MidLevel.AssetSum {
+		return fmt.Errorf("asset sums of bottom and mid level proofs must never be published")
+	}
+	if policy.Public.BottomLevel.AssetSums != nil || policy.Public.MidLevel.AssetSums != nil {
+		return fmt.Errorf("asset sum summaries of bottom and mid level proofs must never be published")
+	}
+	if !policy.Public.TopLevel.AssetSum {
+		return fmt.Errorf("asset sum of the top level proof must be published")
+	}
+	if policy.Public.BottomLevel.MerkleNodes != MERKLE_NODES_ALL && (policy.Secret == nil || policy.Secret.BottomLevel.MerkleNodes != MERKLE_NODES_ALL) {
+		return fmt.Errorf("every merkle node of the bottom level proofs must be written to the public or secret directory")
+	}
+	return nil
+}
+
+// apply returns the proof with only the fields allowed by the policy.
+func (fields ProofFieldPolicy) apply(proof CompletedProof) CompletedProof {
+	if !fields.AssetSum {
+		proof.AssetSum = nil
+	}
+	switch fields.MerkleNodes {
+	case MERKLE_NODES_ALL:
+	case MERKLE_NODES_LEAVES:
+		if len(proof.MerkleNodes) > 0 {
+			leaves := make([][]Hash, len(proof.MerkleNodes))
+			leaves[len(leaves)-1] = proof.MerkleNodes[len(leaves)-1]
+			proof.MerkleNodes = leaves
+		}
+	default:
+		proof.MerkleNodes = nil
+	}
+	return proof
+}
+
+// validate checks the disclosure names assets of every balance, at most once, and has a decimal price for each
+// asset it aggregates.
+func (disclosure AssetSumDisclosure) validate() error {
+	symbols := circuit.GetAssetSymbols()
+	for i, symbol := range disclosure.Assets {
+		if !slices.Contains(symbols, symbol) {
+			return fmt.Errorf("unknown asset %s", symbol)
+		}
+		if slices.Contains(disclosure.Assets[:i], symbol) {
+			return fmt.Errorf("asset %s is disclosed twice", symbol)
+		}
+	}
+	if disclosure.Other == "" {
+		return nil
+	}
+	for symbol := range disclosure.Prices {
+		if !slices.Contains(symbols, symbol) {
+			return fmt.Errorf("price of unknown asset %s", symbol)
+		}
+	}
+	_, err := aggregateAssetSums(make(circuit.GoBalance, len(symbols)), disclosure.otherAssets(), disclosure.Prices)
+	return err
+}
+
+// otherAssets returns the symbols of the assets of every balance the disclosure aggregates, in order.
+func (disclosure AssetSumDisclosure) otherAssets() []string {
+	if disclosure.Other == "" || len(disclosure.Assets) == 0 {
+		return nil
+	}
+	others := make([]string, 0)
+	for _, symbol := range circuit.GetAssetSymbols() {
+		if !slices.Contains(disclosure.Assets, symbol) {
+			others = append(others, symbol)
+		}
+	}
+	return others
+}
+
+// summarize returns the asset sum summary of the proof, which must have its asset sum.
+func (disclosure AssetSumDisclosure) summarize(proof CompletedProof) (AssetSumSummary, error) {
+	summary := AssetSumSummary{RoundId: proof.RoundId, Assets: make([]RawUVBalance, 0)}
+	for _, sum := range ConvertGoBalanceToRawUVBalances(*proof.AssetSum) {
+		if len(disclosure.Assets) == 0 || slices.Contains(disclosure.Assets, sum.Asset) {
+			summary.Assets = append(summary.Assets, sum)
+		}
+	}
+	if others := disclosure.otherAssets(); len(others) > 0 {
+		prices := make(map[string]string, len(others))
+		for _, symbol := range others {
+			prices[symbol] = disclosure.Prices[symbol]
+		}
+		value, err := aggregateAssetSums(*proof.AssetSum, others, prices)
+		if err != nil {
+			return AssetSumSummary{}, err
+		}
+		summary.Other = &AggregatedAssetSum{Name: disclosure.Other, Assets: others, Prices: prices, Value: value}
+	}
+	return summary, nil
+}
+
+// aggregateAssetSums returns the value of the sums of the given assets of the balance at the given prices of a display
+// unit of each asset, as an exact decimal string.
+func aggregateAssetSums(balance circuit.GoBalance, symbols []string, prices map[string]string) (string, error) {
+	return aggregateAssetSumsOfAssets(balance, symbols, prices, verification.ConfiguredAssets())
+}
+
+// aggregateAssetSumsOfAssets returns the value of the sums like aggregateAssetSums, the balance at index i being the
+// balance of the asset at index i of assets.
+func aggregateAssetSumsOfAssets(balance circuit.GoBalance, symbols []string, prices map[string]string, assets []circuit.Asset) (string, error) {
+	value := new(big.Rat)
+	for _, symbol := range symbols {
+		asset := slices.IndexFunc(assets, func(candidate circuit.Asset) bool { return candidate.Symbol == symbol })
+		if asset < 0 {
+			return "", fmt.Errorf("unknown asset %s", symbol)
+		}
+		// fractions are rejected, as their value may have no exact decimal representation
+		price, ok := new(big.Rat).SetString(prices[symbol])
+		if !ok || strings.Contains(prices[symbol], "/") || price.Sign() < 0 {
+			return "", fmt.Errorf("invalid price of %s: %q", symbol, prices[symbol])
+		}
+		amount := new(big.Int)
+		if balance[asset] != nil {
+			amount = balance[asset]
+		}
+		unit := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(assets[asset].Decimals)), nil)
+		value.Add(value, price.Mul(price, new(big.Rat).SetFrac(amount, unit)))
+	}
+	places := 0
+	for scaled := new(big.Rat).Set(value); !scaled.IsInt(); places++ {
+		scaled.Mul(scaled, big.NewRat(10, 1))
+	}
+	return value.FloatString(places), nil
+}
+
+// proofLayer is where the proofs of a layer are written, and which fields of the policy govern them.
+type proofLayer struct {
+	name string
+	// layoutPrefix returns the prefix of the published proofs of the layer in a layout (see publicPrefix)
+	layoutPrefix func(layout Layout) string
+	secretPrefix string
+	fields       func(policies LayerFieldPolicies) ProofFieldPolicy
+}
+
+var (
+	bottomLevelLayer = proofLayer{"bottom", Layout.bottomProofPrefix, SECRET_BOTTOM_PROOF_PREFIX, func(policies LayerFieldPolicies) ProofFieldPolicy { return policies.BottomLevel }}
+	midLevelLayer    = proofLayer{"mid", Layout.midProofPrefix, SECRET_MIDDLE_PROOF_PREFIX, func(policies LayerFieldPolicies) ProofFieldPolicy { return policies.MidLevel }}
+	topLevelLayer    = proofLayer{"top", Layout.topProofPrefix, SECRET_TOP_PROOF_PREFIX, func(policies LayerFieldPolicies) ProofFieldPolicy { return policies.TopLevel }}
+)
+
+// publicPrefix returns the prefix of the published proofs of the layer in Options.OutputLayout.
+func (layer proofLayer) publicPrefix() string {
+	return layer.layoutPrefix(GetOptions().OutputLayout)
+}
+
+// IntermediateLevelProofPrefix returns the prefix of the published proofs of the given intermediate level, between
+// the mid level (1) and the top level of a round with more than MIN_HIERARCHY_LEVELS levels.
+func IntermediateLevelProofPrefix(level int) string {
+	return verification.IntermediateLevelProofPrefix(level)
+}
+
+// levelLayer returns the layer of the proofs of the given level of a round with levelCount levels, from the bottom
+// level (0) to the top level (levelCount - 1). The proofs of intermediate levels are governed by the mid level fields
+// of the policy, and are named after their level (e.g. "mid2").
+func levelLayer(level int, levelCount int) proofLayer {
+	switch level {
+	case 0:
+		return bottomLevelLayer
+	case levelCount - 1:
+		return topLevelLayer
+	case 1:
+		return midLevelLayer
+	}
+	return proofLayer{
+		verification.LayerName(level, levelCount),
+		func(Layout) string { return IntermediateLevelProofPrefix(level) },
+		SECRET_INTERMEDIATE_PROOF_PREFIX + strconv.Itoa(level) + "_level_proof_",
+		midLevelLayer.fields,
+	}
+}
+
+// writeProof writes the proof with the given index of the layer to the public directory of outDir, and its copy
+// to the secret directory (encrypted if Options.SecretCopyKey is set), as governed by Options.ProofPublicationPolicy, followed by its metrics (see Options.WriteProofMetrics). The
+// published proof references its verification key if Options.PublishVerificationKeys is set, and the key is pinned for the
+// layer (see GetPinnedVerificationKeys).
+func writeProof(proof CompletedProof, outDir string, layer proofLayer, index int) {
+	options := GetOptions()
+	pinProvenVerificationKey(proof, layer.name)
+	publicProof := layer.fields(options.ProofPublicationPolicy.Public).apply(proof)
+	if options.PublishVerificationKeys {
+		publicProof = publishVerificationKey(publicProof, outDir, layer.name)
+	}
+	WriteDataToFile(outDir+layer.publicPrefix()+strconv.Itoa(index)+".json", publicProof)
+	writeAssetSumSummary(proof, layer.fields(options.ProofPublicationPolicy.Public), outDir+layer.publicPrefix()+strconv.Itoa(index), writeJson)
+	if options.ProofPublicationPolicy.Secret != nil {
+		fields := layer.fields(*options.ProofPublicationPolicy.Secret)
+		secretPath := outDir + layer.secretPrefix + strconv.Itoa(index) + ".json"
+		if options.SecretCopyKey != nil {
+			panicOnError(writeEncryptedJson(secretPath, ConvertCompletedProofToRawCompletedProof(fields.apply(proof))), "error writing encrypted secret copy")
+		} else {
+			WriteDataToFile(secretPath, fields.apply(proof))
+		}
+		writeAssetSumSummary(proof, fields, outDir+layer.secretPrefix+strconv.Itoa(index), writeSecretCopy)
+	}
+	writeProofMetrics(proof, outDir, layer, index)
+}
+
+// writeAssetSumSummary writes the asset sum summary of the proof after the given path prefix with write, if the fields
+// of its layer disclose its asset sums.
+func writeAssetSumSummary(proof CompletedProof, fields ProofFieldPolicy, prefix string, write func(filePath string, data interface{}) error) {
+	if fields.AssetSums == nil || proof.AssetSum == nil {
+		return
+	}
+	summary, err := fields.AssetSums.summarize(proof)
+	panicOnError(err, "error summarizing asset sums")
+	panicOnError(write(prefix+ASSET_SUM_SUMMARY_SUFFIX, summary), "error writing asset sum summary")
+}
+
+// topAssetSumSummaryFile returns the published asset sum summary of the top level proof.
+func topAssetSumSummaryFile() string {
+	return topLevelLayer.publicPrefix() + "0" + ASSET_SUM_SUMMARY_SUFFIX
+}
+
+// verifyAssetSumSummary verifies the published asset sum summary of the top level proof of the round in outDir, if
+// any, matches the asset sum of the proof, of the assets of the given asset subset: each disclosed sum is the proof's,
+// no asset is disclosed twice, and the aggregated value is that of the proof's sums of the aggregated assets at the
+// recorded prices.
+func verifyAssetSumSummary(outDir string, topLevelProof CompletedProof, assetSubset []int) error {
+	if !fileExists(outDir + topAssetSumSummaryFile()) {
+		return nil
+	}
+	var summary AssetSumSummary
+	if err := readJson(outDir+topAssetSumSummaryFile(), &summary); err != nil {
+		return fmt.Errorf("error reading asset sum summary: %w", err)
+	}
+	if topLevelProof.AssetSum == nil {
+		return errors.New("the top level proof has no asset sum to summarize")
+	}
+	if summary.RoundId != topLevelProof.RoundId {
+		return fmt.Errorf("asset sum summary is of round %s, but the top level proof is of round %s", summary.RoundId, topLevelProof.RoundId)
+	}
+	assets, err := verification.SubsetAssets(assetSubset)
+	if err != nil {
+		return err
+	}
+	sums := verification.ConvertGoBalanceToRawUVBalancesOfAssets(*topLevelProof.AssetSum, assets)
+	summarized := make([]string, 0)
+	for _, sum := range summary.Assets {
+		i := slices.IndexFunc(sums, func(s RawUVBalance) bool { return s.Asset == sum.Asset })
+		switch {
+		case i < 0:
+			return fmt.Errorf("asset sum summary discloses unknown asset %s", sum.Asset)
+		case slices.Contains(summarized, sum.Asset):
+			return fmt.Errorf("asset sum summary discloses asset %s twice", sum.Asset)
+		case sum != sums[i]:
+			return fmt.Errorf("asset sum summary discloses a sum of %s other than the top level proof's", sum.Asset)
+		}
+		summarized = append(summarized, sum.Asset)
+	}
+	if summary.Other == nil {
+		return nil
+	}
+	for _, symbol := range summary.Other.Assets {
+		if slices.Contains(summarized, symbol) {
+			return fmt.Errorf("asset sum summary discloses asset %s twice", symbol)
+		}
+		summarized = append(summarized, symbol)
+	}
+	value, err := aggregateAssetSumsOfAssets(*topLevelProof.AssetSum, summary.Other.Assets, summary.Other.Prices, assets)
+	if err != nil {
+		return fmt.Errorf("invalid aggregated asset sum: %w", err)
+	}
+	if value != summary.Other.Value {
+		return fmt.Errorf("asset sum summary values %s at %s, but the top level proof's sums are worth %s", summary.Other.Name, summary.Other.Value, value)
+	}
+	return nil
+}
+
+// writeProofsToFiles writes the proofs of the layer to files, as governed by Options.ProofPublicationPolicy.
+func writeProofsToFiles(proofs []CompletedProof, outDir string, layer proofLayer) {
+	for i, proof := range proofs {
+		writeProof(proof, outDir, layer, i)
+	}
+}
+
+// hasAllMerkleNodes returns whether every layer of merkle nodes of the proof, of the tree depth of the configuration,
+// was written.
+func hasAllMerkleNodes(proof CompletedProof, config circuit.Config) bool {
+	if len(proof.MerkleNodes) != config.TreeDepth+1 {
+		return false
+	}
+	for _, nodes := range proof.MerkleNodes {
+		if len(nodes) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// readBottomLevelProof reads the bottom level proof with the given index from the public directory of outDir. If the
+// publication policy kept some of its merkle nodes out of the public directory, they are read from the secret copy,
+// which is decrypted with Options.SecretCopyKey if it is encrypted. A referenced verification key is resolved from the published keys (see Options.PublishVerificationKeys).
+func readBottomLevelProof(outDir string, index int) CompletedProof {
+	proof := readPublishedProof(outDir, bottomLevelLayer, index)
+	// the merkle nodes are of the tree depth of the proof (an invalid one is reported when the proof is verified)
+	config := circuit.GetConfig()
+	if treeDepth, err := verification.ProofsTreeDepth([]CompletedProof{proof}); err == nil {
+		config.TreeDepth = treeDepth
+	}
+	if hasAllMerkleNodes(proof, config) {
+		return proof
+	}
+	secretPath := outDir + SECRET_BOTTOM_PROOF_PREFIX + strconv.Itoa(index) + ".json"
+	var secretProof CompletedProof
+	switch {
+	case fileExists(secretPath):
+		secretProof = ReadDataFromFile[CompletedProof](secretPath)
+	case fileExists(secretPath + ENCRYPTED_FILE_EXTENSION):
+		var rawSecretProof RawCompletedProof
+		panicOnError(readEncryptedJson(secretPath, &rawSecretProof), "error reading encrypted secret copy")
+		secretProof = ConvertRawCompletedProofToCompletedProof(rawSecretProof)
+	default:
+		return proof
+	}
+	if secretProof.Proof != proof.Proof || secretProof.RoundId != proof.RoundId {
+		panic(fmt.Sprintf("secret copy of bottom level proof %d does not match the published proof", index))
+	}
+	proof.MerkleNodes = secretProof.MerkleNodes
+	return proof
+}
+
+// readPublishedProof reads the proof with the given index of the layer from the public directory of outDir, resolving
+// its verification key from the published keys if it references it (see Options.PublishVerificationKeys).
+func readPublishedProof(outDir string, layer proofLayer, index int) CompletedProof {
+	proofs := []CompletedProof{ReadDataFromFile[CompletedProof](outDir + layer.publicPrefix() + strconv.Itoa(index) + ".json")}
+	resolvePublishedVerificationKeys(proofs, layer.name, outDir)
+	return proofs[0]
+}
+
+// readPublishedProofs reads the first count proofs of the layer from the public directory of outDir, resolving their
+// verification keys from the published keys if they reference them (see Options.PublishVerificationKeys).
+func readPublishedProofs(count int, outDir string, layer proofLayer) []CompletedProof {
+	proofs := ReadDataFromFiles[CompletedProof](count, outDir+layer.publicPrefix())
+	resolvePublishedVerificationKeys(proofs, layer.name, outDir)
+	return proofs
+}
+
+// readMidLevelProofs reads the proofs of the levels between the bottom and top levels of a round with the plan, by
+// level from the mid level up, with readPublishedProofs.
+func readMidLevelProofs(plan LayerPlan, outDir string) [][]CompletedProof {
+	levels := make([][]CompletedProof, 0, plan.Levels()-2)
+	for level := 1; level < plan.Levels()-1; level++ {
+		levels = append(levels, readPublishedProofs(plan.LevelProofCount(level), outDir, levelLayer(level, plan.Levels())))
+	}
+	return levels
+}
+
+// readBottomLevelProofs reads the bottom level proofs of a round with readBottomLevelProof.
+func readBottomLevelProofs(batchCount int, outDir string) []CompletedProof {
+	proofs := make([]CompletedProof, batchCount)
+	for i := range proofs {
+		proofs[i] = readBottomLevelProof(outDir, i)
+	}
+	return proofs
+}
+
+// ReadPublicationPolicy reads a publication policy from the given path and validates it.
+func ReadPublicationPolicy(path string) PublicationPolicy {
+	var policy PublicationPolicy
+	panicOnError(readJson(path, &policy), "error reading publication policy")
+	panicOnError(policy.Validate(), "invalid publication policy")
+	return policy
+}
@@ -0,0 +1,252 @@
+package core
+
+import (
+	"bytes"
+	"crypto/rand"
+	"math/big"
+	"os"
+	"slices"
+	"testing"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"github.com/consensys/gnark/test"
+)
+
+func TestPublicationPolicyValidate(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	assert.NoError(DefaultPublicationPolicy().Validate())
+
+	publishedMidLevelSum := DefaultPublicationPolicy()
+	publishedMidLevelSum.Public.MidLevel.AssetSum = true
+	assert.Error(publishedMidLevelSum.Validate())
+
+	unpublishedTopLevelSum := DefaultPublicationPolicy()
+	unpublishedTopLevelSum.Public.TopLevel.AssetSum = false
+	assert.Error(unpublishedTopLevelSum.Validate())
+
+	// the merkle nodes of the bottom level proofs must all be kept somewhere
+	publishedLeaves := DefaultPublicationPolicy()
+	publishedLeaves.Public.BottomLevel.MerkleNodes = MERKLE_NODES_LEAVES
+	assert.Error(publishedLeaves.Validate())
+	publishedLeaves.Secret = &LayerFieldPolicies{BottomLevel: ProofFieldPolicy{MerkleNodes: MERKLE_NODES_ALL}}
+	assert.NoError(publishedLeaves.Validate())
+
+	unknownNodes := DefaultPublicationPolicy()
+	unknownNodes.Public.MidLevel.MerkleNodes = "some"
+	assert.Error(unknownNodes.Validate())
+
+	// asset sum summaries are only published for the top level proof, and must price every aggregated asset
+	summarizedMidLevelSums := DefaultPublicationPolicy()
+	summarizedMidLevelSums.Public.MidLevel.AssetSums = &AssetSumDisclosure{Assets: []string{"BTC"}}
+	assert.Error(summarizedMidLevelSums.Validate())
+	summarizedMidLevelSums.Public.MidLevel.AssetSums = nil
+	summarizedMidLevelSums.Secret = &LayerFieldPolicies{
+		BottomLevel: ProofFieldPolicy{MerkleNodes: MERKLE_NODES_ALL},
+		MidLevel:    ProofFieldPolicy{AssetSums: &AssetSumDisclosure{Assets: []string{"BTC"}}},
+	}
+	assert.NoError(summarizedMidLevelSums.Validate())
+
+	disclosed := DefaultPublicationPolicy()
+	disclosed.Public.TopLevel.AssetSums = &AssetSumDisclosure{Assets: []string{"BTC", "ETH"}}
+	assert.NoError(disclosed.Validate())
+	disclosed.Public.TopLevel.AssetSums = &AssetSumDisclosure{Assets: []string{"BTC", "FOO"}}
+	assert.Error(disclosed.Validate())
+	disclosed.Public.TopLevel.AssetSums = &AssetSumDisclosure{Assets: []string{"BTC", "BTC"}}
+	assert.Error(disclosed.Validate())
+	disclosed.Public.TopLevel.AssetSums = &AssetSumDisclosure{Assets: []string{"BTC"}, Other: "OTHER", Prices: map[string]string{"ETH": "3000"}}
+	assert.Error(disclosed.Validate())
+	disclosed.Public.TopLevel.AssetSums.Prices = otherAssetPrices("BTC")
+	assert.NoError(disclosed.Validate())
+	disclosed.Public.TopLevel.AssetSums.Prices["ETH"] = "1/3"
+	assert.Error(disclosed.Validate())
+}
+
+// otherAssetPrices returns a price of zero for every asset but the given one.
+func otherAssetPrices(disclosed string) map[string]string {
+	prices := make(map[string]string)
+	for _, symbol := range circuit.GetAssetSymbols() {
+		if symbol != disclosed {
+			prices[symbol] = "0"
+		}
+	}
+	return prices
+}
+
+func TestAssetSumSummary(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	outDir := t.TempDir() + "/"
+	assert.NoError(os.MkdirAll(outDir+"public", 0o755))
+	assert.NoError(verifyAssetSumSummary(outDir, proofTop, circuit.GetAssetSubset()))
+
+	assetSum := make(circuit.GoBalance, circuit.GetNumberOfAssets())
+	for i := range assetSum {
+		assetSum[i] = big.NewInt(0)
+	}
+	symbols := circuit.GetAssetSymbols()
+	assetSum[slices.Index(symbols, "BTC")] = big.NewInt(150_000_000)
+	assetSum[slices.Index(symbols, "ETH")], _ = new(big.Int).SetString("2000000000000000000", 10)
+	assetSum[slices.Index(symbols, "DOGE")] = big.NewInt(500_000_000)
+	proof := proofTop
+	proof.AssetSum = &assetSum
+
+	// disclose the BTC sum, and aggregate the other sums into a single line
+	disclosure := AssetSumDisclosure{Assets: []string{"BTC"}, Other: "OTHER", Prices: otherAssetPrices("BTC")}
+	disclosure.Prices["ETH"], disclosure.Prices["DOGE"] = "3000.25", "0.15"
+	writeAssetSumSummary(proof, ProofFieldPolicy{AssetSums: &disclosure}, outDir+TOP_PROOF_PREFIX+"0", writeJson)
+	var summary AssetSumSummary
+	assert.NoError(readJson(outDir+topAssetSumSummaryFile(), &summary))
+	assert.Equal([]RawUVBalance{{Asset: "BTC", Amount: "150000000", DisplayAmount: "1.5"}}, summary.Assets)
+	assert.Equal("OTHER", summary.Other.Name)
+	assert.Equal(len(symbols)-1, len(summary.Other.Assets))
+	assert.Equal("6001.25", summary.Other.Value)
+	assert.NoError(verifyAssetSumSummary(outDir, proof, circuit.GetAssetSubset()))
+
+	// the summary must match the asset sum of the top level proof
+	otherSum := slices.Clone(assetSum)
+	otherSum[slices.Index(symbols, "DOGE")] = big.NewInt(600_000_000)
+	otherProof := proof
+	otherProof.AssetSum = &otherSum
+	assert.Error(verifyAssetSumSummary(outDir, otherProof, circuit.GetAssetSubset()))
+
+	summary.Other.Value = "6001"
+	assert.NoError(writeJson(outDir+topAssetSumSummaryFile(), summary))
+	assert.Error(verifyAssetSumSummary(outDir, proof, circuit.GetAssetSubset()))
+	summary.Other.Value = "6001.25"
+	summary.Other.Assets = append(summary.Other.Assets, "BTC")
+	assert.NoError(writeJson(outDir+topAssetSumSummaryFile(), summary))
+	assert.Error(verifyAssetSumSummary(outDir, proof, circuit.GetAssetSubset()))
+
+	// without an aggregated line, every sum is disclosed by default
+	writeAssetSumSummary(proof, ProofFieldPolicy{AssetSums: &AssetSumDisclosure{}}, outDir+TOP_PROOF_PREFIX+"0", writeJson)
+	summary = AssetSumSummary{}
+	assert.NoError(readJson(outDir+topAssetSumSummaryFile(), &summary))
+	assert.Equal(len(symbols), len(summary.Assets))
+	assert.Nil(summary.Other)
+	assert.NoError(verifyAssetSumSummary(outDir, proof, circuit.GetAssetSubset()))
+}
+
+func TestProofFieldPolicyApply(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	assetSum := circuit.SumGoAccountBalances(testData0.Accounts)
+	proof := proofLower0
+	proof.AssetSum = &assetSum
+
+	leaves := ProofFieldPolicy{MerkleNodes: MERKLE_NODES_LEAVES}.apply(proof)
+	assert.Nil(leaves.AssetSum)
+	assert.Equal(len(proof.MerkleNodes), len(leaves.MerkleNodes))
+	assert.Equal(proof.MerkleNodes[circuit.TREE_DEPTH], leaves.MerkleNodes[circuit.TREE_DEPTH])
+	assert.Nil(leaves.MerkleNodes[0])
+	assert.False(hasAllMerkleNodes(leaves, circuit.GetConfig()))
+
+	all := ProofFieldPolicy{AssetSum: true, MerkleNodes: MERKLE_NODES_ALL}.apply(proof)
+	assert.Equal(proof, all)
+	assert.Nil(ProofFieldPolicy{}.apply(proof).MerkleNodes)
+}
+
+func TestProveWithPublicationPolicy(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// publish only the leaves of the bottom level proofs, and keep every node and sum in the secret directory
+	setOptions(t, func(options *Options) {
+		options.ProofPublicationPolicy = DefaultPublicationPolicy()
+		options.ProofPublicationPolicy.Public.BottomLevel.MerkleNodes = MERKLE_NODES_LEAVES
+		options.ProofPublicationPolicy.Public.TopLevel.AssetSums = &AssetSumDisclosure{Assets: []string{"BTC", "ETH"}}
+		options.ProofPublicationPolicy.Secret = &LayerFieldPolicies{
+			BottomLevel: ProofFieldPolicy{AssetSum: true, MerkleNodes: MERKLE_NODES_ALL},
+			MidLevel:    ProofFieldPolicy{AssetSum: true, MerkleNodes: MERKLE_NODES_ALL},
+			TopLevel:    ProofFieldPolicy{AssetSum: true, MerkleNodes: MERKLE_NODES_ALL},
+		}
+	})
+
+	outDir := t.TempDir() + "/"
+	assert.NoError(os.MkdirAll(outDir+"secret", 0o755))
+	assert.NoError(os.MkdirAll(outDir+"public", 0o755))
+	GenerateData(2, 4, outDir)
+	Prove(2, outDir)
+
+	publicProof := ReadDataFromFile[CompletedProof](outDir + BOTTOM_PROOF_PREFIX + "1.json")
+	assert.False(hasAllMerkleNodes(publicProof, circuit.GetConfig()))
+	assert.Equal(circuit.ACCOUNTS_PER_BATCH, len(publicProof.MerkleNodes[circuit.TREE_DEPTH]))
+	assert.Nil(publicProof.AssetSum)
+	secretProof := ReadDataFromFile[CompletedProof](outDir + SECRET_BOTTOM_PROOF_PREFIX + "1.json")
+	assert.True(hasAllMerkleNodes(secretProof, circuit.GetConfig()))
+	assert.NotNil(secretProof.AssetSum)
+	assert.NotNil(ReadDataFromFile[CompletedProof](outDir + SECRET_MIDDLE_PROOF_PREFIX + "0.json").AssetSum)
+	assert.Nil(ReadDataFromFile[CompletedProof](outDir + MIDDLE_PROOF_PREFIX + "0.json").AssetSum)
+	assert.True(fileExists(outDir + topAssetSumSummaryFile()))
+
+	// the merkle nodes are read from the secret copies where needed
+	assert.True(hasAllMerkleNodes(readBottomLevelProof(outDir, 1), circuit.GetConfig()))
+	assert.NotPanics(func() { VerifyFull(2, outDir) })
+	manifest := ExportUserPackages(2, outDir, t.TempDir(), ExportOptions{ShardSize: 2})
+	assert.Equal(8, manifest.UserCount)
+
+	// without the secret copies, the merkle nodes are rebuilt from the accounts, which must be those of the proofs
+	assert.NoError(os.Remove(outDir + SECRET_BOTTOM_PROOF_PREFIX + "1.json"))
+	assert.NotPanics(func() { VerifyFull(2, outDir) })
+	batch := ReadDataFromFile[ProofElements](outDir + SECRET_DATA_PREFIX + "1.json")
+	rebuilt, err := RebuildMerkleNodes(publicProof, batch.Accounts)
+	assert.NoError(err)
+	assert.Equal(secretProof.MerkleNodes, rebuilt.MerkleNodes)
+	batch.Accounts[0], batch.Accounts[1] = batch.Accounts[1], batch.Accounts[0]
+	_, err = RebuildMerkleNodes(publicProof, batch.Accounts)
+	assert.Error(err)
+	_, err = RebuildMerkleNodes(publicProof, batch.Accounts[1:])
+	assert.Error(err)
+	WriteDataToFile(outDir+SECRET_DATA_PREFIX+"1.json", batch)
+	assert.Panics(func() { VerifyFull(2, outDir) })
+}
+
+func TestEncryptedSecretCopies(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// publish only the leaves of the bottom level proofs, and keep every node and the sum in an encrypted secret copy
+	setOptions(t, func(options *Options) {
+		options.ProofPublicationPolicy = DefaultPublicationPolicy()
+		options.ProofPublicationPolicy.Public.BottomLevel.MerkleNodes = MERKLE_NODES_LEAVES
+		options.ProofPublicationPolicy.Secret = &LayerFieldPolicies{BottomLevel: ProofFieldPolicy{AssetSum: true, MerkleNodes: MERKLE_NODES_ALL}}
+	})
+	key := make([]byte, SECRET_COPY_KEY_SIZE)
+	_, err := rand.Read(key)
+	assert.NoError(err)
+	setOptions(t, func(options *Options) { options.SecretCopyKey = key })
+	assert.NoError(GetOptions().ProofPublicationPolicy.Validate())
+
+	outDir := t.TempDir() + "/"
+	assert.NoError(os.MkdirAll(outDir+"secret", 0o755))
+	assert.NoError(os.MkdirAll(outDir+"public", 0o755))
+	assetSum := circuit.SumGoAccountBalances(testData0.Accounts)
+	proof := proofLower0
+	proof.AssetSum = &assetSum
+	writeProof(proof, outDir, bottomLevelLayer, 0)
+
+	// only the encrypted copy is written, and the merkle nodes are read back from it with the key
+	secretPath := outDir + SECRET_BOTTOM_PROOF_PREFIX + "0.json"
+	assert.False(fileExists(secretPath))
+	assert.True(fileExists(secretPath + ENCRYPTED_FILE_EXTENSION))
+	sealed, err := os.ReadFile(secretPath + ENCRYPTED_FILE_EXTENSION)
+	assert.NoError(err)
+	assert.False(bytes.Contains(sealed, []byte(proof.Proof[:32])), "expected the secret copy to be encrypted")
+	read := readBottomLevelProof(outDir, 0)
+	assert.True(hasAllMerkleNodes(read, circuit.GetConfig()))
+	assert.Equal(proof.MerkleNodes, read.MerkleNodes)
+	var secretProof RawCompletedProof
+	assert.NoError(readEncryptedJson(secretPath, &secretProof))
+	assert.True(assetSum.Equals(*ConvertRawCompletedProofToCompletedProof(secretProof).AssetSum))
+
+	// copies can't be read without the key, with another key, or under another name
+	setOptions(t, func(options *Options) { options.SecretCopyKey = nil })
+	assert.Panics(func() { readBottomLevelProof(outDir, 0) })
+	setOptions(t, func(options *Options) { options.SecretCopyKey = make([]byte, SECRET_COPY_KEY_SIZE) })
+	assert.Panics(func() { readBottomLevelProof(outDir, 0) })
+	setOptions(t, func(options *Options) { options.SecretCopyKey = key })
+	assert.NoError(os.WriteFile(outDir+SECRET_BOTTOM_PROOF_PREFIX+"1.json"+ENCRYPTED_FILE_EXTENSION, sealed, 0o644))
+	assert.Error(readEncryptedJson(outDir+SECRET_BOTTOM_PROOF_PREFIX+"1.json", &secretProof))
+
+	// the key must be an AES-256 key
+	setOptions(t, func(options *Options) { options.SecretCopyKey = key[:16] })
+	assert.Error(GetOptions().ProofPublicationPolicy.Validate())
+}
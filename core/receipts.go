@@ -0,0 +1,119 @@
+package core
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"fmt"
+	"strconv"
+	"time"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"bitgo.com/proof_of_reserves/verification"
+)
+
+// IssueBatchReceipts issues a receipt for each batch of the round proven in outDir, signed with the given key, to
+// return to the team that exported the batch. Each receipt records the digest and account count of the batch file
+// and the merkle root and asset subtotal hash of the bottom level proof generated from it, so the team can confirm
+// that what was proven is exactly what they exported, and disputes can be settled batch by batch.
+func IssueBatchReceipts(batchCount int, outDir string, privateKey ed25519.PrivateKey, issuedAt time.Time) []SignedBatchReceipt {
+	options := GetOptions()
+	panicOnError(NewLayerPlan(batchCount).Validate(), "invalid number of batches")
+
+	var report RoundReport
+	panicOnError(readJson(outDir+ROUND_REPORT_FILE, &report), "error reading round report")
+	excludedAccountCounts := make(map[int]int)
+	for _, excludedAccount := range report.ExcludedAccounts {
+		excludedAccountCounts[excludedAccount.Batch]++
+	}
+
+	receipts := make([]SignedBatchReceipt, batchCount)
+	for i := 0; i < batchCount; i++ {
+		input, err := describeFile(outDir, options.OutputLayout.secretDataFile(i))
+		panicOnError(err, "error hashing batch file")
+		elements := ReadDataFromFile[ProofElements](outDir + options.OutputLayout.secretDataFile(i))
+		proof := ReadDataFromFile[CompletedProof](outDir + bottomLevelLayer.publicPrefix() + strconv.Itoa(i) + ".json")
+		panicOnError(verification.VerifyProofsBelongToRound(report.RoundId, "bottom", []CompletedProof{proof}), "round id verification failed")
+
+		receipt := BatchReceipt{
+			RoundId:                    report.RoundId,
+			Batch:                      i,
+			InputDigest:                input.Digest[PROVENANCE_DIGEST_ALGORITHM],
+			AccountCount:               len(elements.Accounts),
+			ExcludedAccountCount:       excludedAccountCounts[i],
+			MerkleRoot:                 proof.MerkleRoot,
+			MerkleRootWithAssetSumHash: proof.MerkleRootWithAssetSumHash,
+			Epoch:                      verification.EpochOf(proof),
+			DeploymentDomain:           circuit.GetDeploymentDomain(),
+			IssuedAt:                   issuedAt.UTC(),
+		}
+		// never vouch for a proof that was not generated from the batch file
+		panicOnError(verifyBatchReceiptMatchesBatch(receipt, elements), fmt.Sprintf("batch %d does not match its proof", i))
+
+		payload, publicKey, signature := signJson(SIGNING_CONTEXT_BATCH_RECEIPT, receipt, privateKey)
+		receipts[i] = SignedBatchReceipt{Payload: payload, PublicKey: publicKey, Signature: signature}
+	}
+	return receipts
+}
+
+// verifyBatchReceiptMatchesBatch verifies that the receipt describes the given batch. The merkle root and asset
+// subtotal hash can only be recomputed if no accounts of the batch were excluded from the proof.
+func verifyBatchReceiptMatchesBatch(receipt BatchReceipt, elements ProofElements) error {
+	if receipt.AccountCount != len(elements.Accounts) {
+		return fmt.Errorf("receipt is for %d accounts, but the batch has %d accounts", receipt.AccountCount, len(elements.Accounts))
+	}
+	if receipt.ExcludedAccountCount != 0 {
+		return nil
+	}
+	merkleRoot := circuit.GoComputeMerkleRootFromAccounts(elements.Accounts)
+	if !bytes.Equal(receipt.MerkleRoot, merkleRoot) {
+		return fmt.Errorf("merkle root of the receipt does not match the batch")
+	}
+	assetSum := circuit.SumGoAccountBalances(elements.Accounts)
+	if !bytes.Equal(receipt.MerkleRootWithAssetSumHash, circuit.GoComputeRootWithAssetSumHash(merkleRoot, assetSum, receipt.Epoch)) {
+		return fmt.Errorf("asset subtotal hash of the receipt does not match the batch")
+	}
+	return nil
+}
+
+// VerifyBatchReceipt verifies that the receipt is signed by one of the trusted public keys and that it describes the
+// batch file at batchFilePath, i.e. that the file is byte for byte the one that was proven. Unless accounts of the
+// batch were excluded, the merkle root and asset subtotal hash are recomputed from the file and checked too.
+// Returns the verified receipt.
+func VerifyBatchReceipt(signed SignedBatchReceipt, trustedPublicKeys []ed25519.PublicKey, batchFilePath string) (receipt BatchReceipt, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("error reading batch file: %v", r)
+		}
+	}()
+
+	if err := verification.VerifySignedJson("batch receipt", SIGNING_CONTEXT_BATCH_RECEIPT, signed.Payload, signed.PublicKey, signed.Signature, trustedPublicKeys, &receipt); err != nil {
+		return BatchReceipt{}, err
+	}
+	if receipt.DeploymentDomain != circuit.GetDeploymentDomain() {
+		return BatchReceipt{}, fmt.Errorf("batch receipt is for deployment domain %q, expected %q", receipt.DeploymentDomain, circuit.GetDeploymentDomain())
+	}
+	input, err := describeFile("", batchFilePath)
+	if err != nil {
+		return BatchReceipt{}, fmt.Errorf("error hashing batch file: %w", err)
+	}
+	if input.Digest[PROVENANCE_DIGEST_ALGORITHM] != receipt.InputDigest {
+		return BatchReceipt{}, fmt.Errorf("batch file has digest %s, but batch %d was proven from a file with digest %s",
+			input.Digest[PROVENANCE_DIGEST_ALGORITHM], receipt.Batch, receipt.InputDigest)
+	}
+	if err := verifyBatchReceiptMatchesBatch(receipt, ReadDataFromFile[ProofElements](batchFilePath)); err != nil {
+		return BatchReceipt{}, err
+	}
+	return receipt, nil
+}
+
+// ReadBatchReceipt reads a signed batch receipt from the given path.
+func ReadBatchReceipt(path string) SignedBatchReceipt {
+	var signed SignedBatchReceipt
+	panicOnError(readJson(path, &signed), "error reading batch receipt")
+	return signed
+}
+
+// WriteBatchReceipt writes a signed batch receipt to the given path.
+func WriteBatchReceipt(path string, signed SignedBatchReceipt) {
+	panicOnError(writeJson(path, signed), "error writing batch receipt")
+}
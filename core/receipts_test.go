@@ -0,0 +1,70 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"github.com/consensys/gnark/test"
+)
+
+func TestBatchReceipts(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	assert.NoError(err)
+	trusted := []ed25519.PublicKey{publicKey}
+
+	receipts := IssueBatchReceipts(batchCount, testOutDir, privateKey, time.Now())
+	assert.Equal(batchCount, len(receipts))
+
+	// the receipt survives being written and read, and matches the exported batch
+	receiptPath := filepath.Join(t.TempDir(), "batch_receipt_1.json")
+	WriteBatchReceipt(receiptPath, receipts[1])
+	receipt, err := VerifyBatchReceipt(ReadBatchReceipt(receiptPath), trusted, testOutDir+SECRET_DATA_PREFIX+"1.json")
+	assert.NoError(err)
+	assert.Equal(1, receipt.Batch)
+	assert.Equal(len(testData1.Accounts), receipt.AccountCount)
+	assert.Equal(proofLower1.MerkleRoot, receipt.MerkleRoot)
+
+	t.Run("Other batch", func(t *testing.T) {
+		_, err := VerifyBatchReceipt(receipts[0], trusted, testOutDir+SECRET_DATA_PREFIX+"1.json")
+		assert.Error(err)
+	})
+
+	t.Run("Modified batch file", func(t *testing.T) {
+		data, err := os.ReadFile(testOutDir + SECRET_DATA_PREFIX + "1.json")
+		assert.NoError(err)
+		modifiedPath := filepath.Join(t.TempDir(), "batch_1.json")
+		assert.NoError(os.WriteFile(modifiedPath, append(data, '\n'), 0o644))
+		_, err = VerifyBatchReceipt(receipts[1], trusted, modifiedPath)
+		assert.Error(err)
+	})
+
+	t.Run("Untrusted key", func(t *testing.T) {
+		otherPublicKey, _, err := ed25519.GenerateKey(nil)
+		assert.NoError(err)
+		_, err = VerifyBatchReceipt(receipts[1], []ed25519.PublicKey{otherPublicKey}, testOutDir+SECRET_DATA_PREFIX+"1.json")
+		assert.Error(err)
+	})
+
+	t.Run("Other deployment domain", func(t *testing.T) {
+		defer circuit.SetDeploymentDomain("")
+		circuit.SetDeploymentDomain("testnet")
+		_, err := VerifyBatchReceipt(receipts[1], trusted, testOutDir+SECRET_DATA_PREFIX+"1.json")
+		assert.Error(err)
+	})
+
+	t.Run("Proof of another batch", func(t *testing.T) {
+		outDir := t.TempDir() + "/"
+		assert.NoError(os.MkdirAll(outDir+"secret", 0o755))
+		assert.NoError(os.MkdirAll(outDir+"public", 0o755))
+		WriteDataToFile(outDir+SECRET_DATA_PREFIX+"0.json", testData0)
+		WriteDataToFile(outDir+BOTTOM_PROOF_PREFIX+"0.json", proofLower1)
+		assert.NoError(writeJson(outDir+ROUND_REPORT_FILE, RoundReport{RoundId: proofLower1.RoundId}))
+		assert.Panics(func() { IssueBatchReceipts(1, outDir, privateKey, time.Now()) })
+	})
+}
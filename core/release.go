@@ -0,0 +1,170 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"bitgo.com/proof_of_reserves/verification"
+)
+
+// BuildDependency is a module the binary was built with.
+type BuildDependency struct {
+	Path    string
+	Version string
+	Sum     string
+}
+
+// BuildInfo describes how the running binary was built, as embedded by the Go toolchain.
+type BuildInfo struct {
+	GoVersion     string
+	ModulePath    string
+	ModuleVersion string
+	// Settings are the build settings, e.g. vcs.revision, vcs.modified, -trimpath, -ldflags, and CGO_ENABLED.
+	Settings     map[string]string
+	Dependencies []BuildDependency
+}
+
+// ReleaseArtifact is a binary of a release.
+type ReleaseArtifact struct {
+	Name   string
+	GOOS   string
+	GOARCH string
+	// Digest is the hex encoded SHA-256 hash of the binary.
+	Digest string
+}
+
+// ReleaseManifest lists the binaries of a release, so users can check a downloaded binary is one of them, and that
+// rebuilding the release from source reproduces it.
+type ReleaseManifest struct {
+	Version     string
+	VCSRevision string
+	IssuedAt    time.Time
+	Artifacts   []ReleaseArtifact
+}
+
+// SignedReleaseManifest is a ReleaseManifest signed with ed25519, the same way as a SignedKeyBundle.
+type SignedReleaseManifest struct {
+	Payload   string
+	PublicKey string
+	Signature string
+}
+
+// GetBuildInfo returns how the running binary was built. Returns an error if the binary has no build info.
+func GetBuildInfo() (BuildInfo, error) {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return BuildInfo{}, fmt.Errorf("binary has no build info")
+	}
+	buildInfo := BuildInfo{
+		GoVersion:     info.GoVersion,
+		ModulePath:    info.Main.Path,
+		ModuleVersion: info.Main.Version,
+		Settings:      make(map[string]string),
+		Dependencies:  make([]BuildDependency, 0, len(info.Deps)),
+	}
+	for _, setting := range info.Settings {
+		buildInfo.Settings[setting.Key] = setting.Value
+	}
+	for _, dependency := range info.Deps {
+		if dependency.Replace != nil {
+			dependency = dependency.Replace
+		}
+		buildInfo.Dependencies = append(buildInfo.Dependencies, BuildDependency{Path: dependency.Path, Version: dependency.Version, Sum: dependency.Sum})
+	}
+	return buildInfo, nil
+}
+
+// ReproducibilityWarnings lists why a binary built this way may not be reproducible from source.
+func (info BuildInfo) ReproducibilityWarnings() []string {
+	warnings := make([]string, 0)
+	if info.Settings["vcs.revision"] == "" {
+		warnings = append(warnings, "the VCS revision was not recorded (build the module with 'go build .' from a checkout)")
+	}
+	if info.Settings["vcs.modified"] == "true" {
+		warnings = append(warnings, "the binary was built from a checkout with uncommitted changes")
+	}
+	if info.Settings["-trimpath"] != "true" {
+		warnings = append(warnings, "the binary was built without -trimpath, so it embeds the paths of the build machine")
+	}
+	return warnings
+}
+
+// computeFileDigest returns the hex encoded SHA-256 hash of the file at the given path.
+func computeFileDigest(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// BuildReleaseManifest lists the given binaries of a release, whose Name, GOOS, and GOARCH must be set, computing
+// their digests from the binaries in binaryDir.
+func BuildReleaseManifest(version string, vcsRevision string, artifacts []ReleaseArtifact, binaryDir string, issuedAt time.Time) ReleaseManifest {
+	manifest := ReleaseManifest{Version: version, VCSRevision: vcsRevision, IssuedAt: issuedAt.UTC(), Artifacts: make([]ReleaseArtifact, len(artifacts))}
+	for i, artifact := range artifacts {
+		if artifact.Name == "" || artifact.GOOS == "" || artifact.GOARCH == "" {
+			panic("release artifacts must have a name, GOOS, and GOARCH")
+		}
+		digest, err := computeFileDigest(filepath.Join(binaryDir, artifact.Name))
+		panicOnError(err, "error hashing release binary")
+		artifact.Digest = digest
+		manifest.Artifacts[i] = artifact
+	}
+	return manifest
+}
+
+// SignReleaseManifest signs the release manifest with the given key.
+func SignReleaseManifest(manifest ReleaseManifest, privateKey ed25519.PrivateKey) SignedReleaseManifest {
+	payload, publicKey, signature := signJson(SIGNING_CONTEXT_RELEASE_MANIFEST, manifest, privateKey)
+	return SignedReleaseManifest{Payload: payload, PublicKey: publicKey, Signature: signature}
+}
+
+// VerifyBinaryAgainstReleaseManifest verifies that the release manifest is signed by one of the trusted public keys,
+// and that the binary at binaryPath (e.g. the running binary, see os.Executable) is the release's binary for the
+// current platform, by recomputing its digest. Returns the manifest and the matching artifact.
+func VerifyBinaryAgainstReleaseManifest(signed SignedReleaseManifest, trustedPublicKeys []ed25519.PublicKey, binaryPath string) (ReleaseManifest, ReleaseArtifact, error) {
+	var manifest ReleaseManifest
+	if err := verification.VerifySignedJson("release manifest", SIGNING_CONTEXT_RELEASE_MANIFEST, signed.Payload, signed.PublicKey, signed.Signature, trustedPublicKeys, &manifest); err != nil {
+		return ReleaseManifest{}, ReleaseArtifact{}, err
+	}
+	digest, err := computeFileDigest(binaryPath)
+	if err != nil {
+		return ReleaseManifest{}, ReleaseArtifact{}, fmt.Errorf("error hashing binary: %w", err)
+	}
+	for _, artifact := range manifest.Artifacts {
+		if artifact.GOOS == runtime.GOOS && artifact.GOARCH == runtime.GOARCH {
+			if artifact.Digest != digest {
+				return ReleaseManifest{}, ReleaseArtifact{}, fmt.Errorf("binary has digest %s, but the %s/%s binary of release %s has digest %s",
+					digest, runtime.GOOS, runtime.GOARCH, manifest.Version, artifact.Digest)
+			}
+			return manifest, artifact, nil
+		}
+	}
+	return ReleaseManifest{}, ReleaseArtifact{}, fmt.Errorf("release %s has no binary for %s/%s", manifest.Version, runtime.GOOS, runtime.GOARCH)
+}
+
+// ReadReleaseManifest reads a signed release manifest from the given path.
+func ReadReleaseManifest(path string) SignedReleaseManifest {
+	var signed SignedReleaseManifest
+	panicOnError(readJson(path, &signed), "error reading release manifest")
+	return signed
+}
+
+// WriteReleaseManifest writes a signed release manifest to the given path.
+func WriteReleaseManifest(path string, signed SignedReleaseManifest) {
+	panicOnError(writeJson(path, signed), "error writing release manifest")
+}
@@ -0,0 +1,75 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/consensys/gnark/test"
+)
+
+func TestReleaseManifest(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	assert.NoError(err)
+	trusted := []ed25519.PublicKey{publicKey}
+
+	binaryDir := t.TempDir()
+	assert.NoError(os.WriteFile(filepath.Join(binaryDir, "bgproof"), []byte("binary"), 0o755))
+	assert.NoError(os.WriteFile(filepath.Join(binaryDir, "bgproof.other"), []byte("other binary"), 0o755))
+	artifacts := []ReleaseArtifact{
+		{Name: "bgproof.other", GOOS: "plan9", GOARCH: "386"},
+		{Name: "bgproof", GOOS: runtime.GOOS, GOARCH: runtime.GOARCH},
+	}
+	manifestPath := filepath.Join(t.TempDir(), "release_manifest.json")
+	WriteReleaseManifest(manifestPath, SignReleaseManifest(BuildReleaseManifest("v1.0.0", "abc", artifacts, binaryDir, time.Now()), privateKey))
+	signed := ReadReleaseManifest(manifestPath)
+
+	// the binary of the current platform matches the manifest
+	manifest, artifact, err := VerifyBinaryAgainstReleaseManifest(signed, trusted, filepath.Join(binaryDir, "bgproof"))
+	assert.NoError(err)
+	assert.Equal("v1.0.0", manifest.Version)
+	assert.Equal("bgproof", artifact.Name)
+
+	t.Run("Binary of another platform", func(t *testing.T) {
+		_, _, err := VerifyBinaryAgainstReleaseManifest(signed, trusted, filepath.Join(binaryDir, "bgproof.other"))
+		assert.Error(err)
+	})
+
+	t.Run("Modified binary", func(t *testing.T) {
+		modifiedPath := filepath.Join(t.TempDir(), "bgproof")
+		assert.NoError(os.WriteFile(modifiedPath, []byte("binary\n"), 0o755))
+		_, _, err := VerifyBinaryAgainstReleaseManifest(signed, trusted, modifiedPath)
+		assert.Error(err)
+	})
+
+	t.Run("Untrusted key", func(t *testing.T) {
+		otherPublicKey, _, err := ed25519.GenerateKey(nil)
+		assert.NoError(err)
+		_, _, err = VerifyBinaryAgainstReleaseManifest(signed, []ed25519.PublicKey{otherPublicKey}, filepath.Join(binaryDir, "bgproof"))
+		assert.Error(err)
+	})
+
+	t.Run("No binary for platform", func(t *testing.T) {
+		otherSigned := SignReleaseManifest(BuildReleaseManifest("v1.0.0", "abc", artifacts[:1], binaryDir, time.Now()), privateKey)
+		_, _, err := VerifyBinaryAgainstReleaseManifest(otherSigned, trusted, filepath.Join(binaryDir, "bgproof"))
+		assert.Error(err)
+	})
+}
+
+func TestReproducibilityWarnings(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	info := BuildInfo{Settings: map[string]string{"vcs.revision": "abc", "vcs.modified": "false", "-trimpath": "true"}}
+	assert.Equal(0, len(info.ReproducibilityWarnings()))
+
+	info = BuildInfo{Settings: map[string]string{"vcs.modified": "true"}}
+	assert.Equal(3, len(info.ReproducibilityWarnings()))
+
+	_, err := GetBuildInfo()
+	assert.NoError(err)
+}
@@ -0,0 +1,132 @@
+package core
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+// ProveContext generates the proofs of the batches in outDir like Prove, but stops once ctx is done, e.g. when the
+// operator interrupts a long run. Each bottom level proof is written as soon as it is generated, followed by the
+// progress of the round (ProveProgress), so a cancelled run leaves no half-written output: calling ProveContext again
+// with the same number of batches resumes the round, only proving the batches that have no proof yet (or that changed
+// since). Cancellation is checked between batches, as gnark can't interrupt the compilation, setup, or proof of a
+// batch, so the batches being proven are finished (and kept) first. The mid and top level proofs and the files
+// describing the round are only written once every bottom level proof is generated. Returns the progress of the round,
+// and ctx.Err() if it was cancelled, or a *ProveError instead of panicking. It is configured with the given options like
+// Prove.
+func ProveContext(ctx context.Context, batchCount int, outDir string, opts ...ProverOption) (progress ProveProgress, err error) {
+	defer recoverProveError(&err)
+
+	options := GetOptions()
+	outDir = options.OutputLayout.RoundDir(outDir)
+	if err := createRoundDirectories(outDir); err != nil {
+		return ProveProgress{}, err
+	}
+	settings := newProverSettings(opts)
+	if settings.dryRun {
+		proveDryRun(batchCount, outDir)
+		return ProveProgress{}, nil
+	}
+	plan := NewLayerPlan(batchCount)
+	if err := plan.Validate(); err != nil {
+		return ProveProgress{}, err
+	}
+	if err := options.ProofPublicationPolicy.Validate(); err != nil {
+		return ProveProgress{}, err
+	}
+	if err := validateProofBackendConfig(); err != nil {
+		return ProveProgress{}, err
+	}
+	migration, err := readRoundMigration(outDir, plan)
+	if err != nil {
+		return ProveProgress{}, err
+	}
+	reporter := newProgressReporter(settings.progress, plan.UpperLevelProofCount())
+
+	// resume the round of the previous run, if it was not finished
+	progress = readProveProgress(outDir)
+	resuming := progress.RoundId != "" && progress.BatchCount == batchCount
+	if !resuming {
+		progress = ProveProgress{RoundId: newRoundId(), BatchCount: batchCount, StartedOn: time.Now(), ProvenBatches: make(map[int]string)}
+	}
+
+	// the dummy accounts of a resumed round are those its proofs were generated with
+	proofElements, exclusions, excludedAccounts := prepareBatches(batchCount, outDir, resuming)
+	sparseTree := newSparseTree()
+	addToSparseTree(sparseTree, proofElements, 0)
+
+	// reuse the bottom level proofs written by the previous run, unless their batch changed since
+	batchKeys := make([]string, batchCount)
+	for i := range proofElements {
+		batchKeys[i] = hex.EncodeToString([]byte(computeProofElementsKey(proofElements[i])))
+	}
+	bottomLevelProofs := make([]CompletedProof, batchCount)
+	for i, key := range progress.ProvenBatches {
+		if i < 0 || i >= batchCount || key != batchKeys[i] {
+			delete(progress.ProvenBatches, i)
+			continue
+		}
+		bottomLevelProofs[i] = readBottomLevelProof(outDir, i)
+		bottomLevelProofs[i].AssetSum = proofElements[i].AssetSum
+	}
+
+	// write each proof as soon as it is generated, followed by the progress
+	var progressMutex sync.Mutex
+	err = generateMissingProofs(ctx, proofElements, bottomLevelProofs, func(i int, proof CompletedProof) {
+		progressMutex.Lock()
+		defer progressMutex.Unlock()
+		proof.RoundId = progress.RoundId
+		writeProof(proof, outDir, bottomLevelLayer, i)
+		progress.ProvenBatches[i] = batchKeys[i]
+		panicOnError(writeJson(outDir+PROVE_PROGRESS_FILE, progress), "error writing prove progress")
+	}, reporter)
+	if err != nil {
+		return progress, err
+	}
+	for i := range bottomLevelProofs {
+		bottomLevelProofs[i].RoundId = progress.RoundId
+		progress.ProvenBatches[i] = batchKeys[i]
+	}
+
+	// the merkle paths of the bottom level proofs are only known now, so they are written again with them
+	upperLevelProofs := generateUpperLevelProofs(bottomLevelProofs, plan, reporter)
+	writeProofsToFiles(bottomLevelProofs, outDir, bottomLevelLayer)
+	writeRound(outDir, progress.RoundId, "prove", plan, migration, upperLevelProofs, exclusions, excludedAccounts, sparseTreeRoot(sparseTree), progress.StartedOn)
+	if err := removeProveProgress(outDir); err != nil {
+		return progress, fmt.Errorf("error removing prove progress: %w", err)
+	}
+	return progress, nil
+}
+
+// removeProveProgress removes the progress of the round proven in outDir once it is finished. Files can't be removed
+// from storages (see storageOf), so an empty progress, which no round is resumed from, is written there instead.
+func removeProveProgress(outDir string) error {
+	if isStorageURI(outDir) {
+		return writeJson(outDir+PROVE_PROGRESS_FILE, ProveProgress{})
+	}
+	if err := os.Remove(outDir + PROVE_PROGRESS_FILE); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// readProveProgress reads the progress of the round being proven in outDir, or returns an empty progress if no round
+// is being proven.
+func readProveProgress(outDir string) ProveProgress {
+	var progress ProveProgress
+	err := readJson(outDir+PROVE_PROGRESS_FILE, &progress)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ProveProgress{}
+	}
+	panicOnError(err, "error reading prove progress")
+	if progress.ProvenBatches == nil {
+		progress.ProvenBatches = make(map[int]string)
+	}
+	return progress
+}
@@ -0,0 +1,58 @@
+package core
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/consensys/gnark/test"
+)
+
+// cancelAfterContext is a context that is done once Err was called more than checks times, so the prover is cancelled
+// after proving a given number of batches.
+type cancelAfterContext struct {
+	context.Context
+	checks int
+}
+
+func (ctx *cancelAfterContext) Err() error {
+	if ctx.checks == 0 {
+		return context.Canceled
+	}
+	ctx.checks--
+	return nil
+}
+
+func TestProveContext(t *testing.T) {
+	assert := test.NewAssert(t)
+	outDir := t.TempDir() + "/"
+	assert.NoError(os.MkdirAll(outDir+"secret", 0o755))
+	assert.NoError(os.MkdirAll(outDir+"public", 0o755))
+	GenerateData(3, 4, outDir)
+
+	// cancelling after the first batch keeps its proof and the progress of the round, but writes no upper level proof
+	progress, err := ProveContext(&cancelAfterContext{Context: context.Background(), checks: 1}, 3, outDir)
+	assert.ErrorIs(err, context.Canceled)
+	assert.Equal(1, len(progress.ProvenBatches))
+	assert.Equal(progress.ProvenBatches, readProveProgress(outDir).ProvenBatches)
+	firstProof := readBottomLevelProof(outDir, 0)
+	assert.Equal(progress.RoundId, firstProof.RoundId)
+	_, err = os.Stat(outDir + TOP_PROOF_PREFIX + "0.json")
+	assert.True(os.IsNotExist(err))
+
+	// running it again resumes the round, without proving the first batch again
+	resumed, err := ProveContext(context.Background(), 3, outDir)
+	assert.NoError(err)
+	assert.Equal(progress.RoundId, resumed.RoundId)
+	assert.Equal(3, len(resumed.ProvenBatches))
+	assert.Equal(firstProof.Proof, readBottomLevelProof(outDir, 0).Proof)
+	assert.Equal(progress.RoundId, readRoundMetadata(outDir).RoundId)
+	assert.NotPanics(func() { VerifyFull(3, outDir) })
+	_, err = os.Stat(outDir + PROVE_PROGRESS_FILE)
+	assert.True(os.IsNotExist(err))
+
+	// a finished round is not resumed
+	next, err := ProveContext(context.Background(), 3, outDir)
+	assert.NoError(err)
+	assert.NotEqual(progress.RoundId, next.RoundId)
+}
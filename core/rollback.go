@@ -0,0 +1,158 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// runJournal records the files written by a run of the prover in its round directory, in the order they are first
+// written, and keeps the previous version of each file it overwrites aside, so that if the run fails halfway its files
+// can be rolled back (see end) rather than leave the round directory mixing the files of the run with those of a
+// previous run. A nil journal records nothing.
+type runJournal struct {
+	mutex    sync.Mutex
+	roundDir string
+	// runDir is the directory of the run in the secret directory, where previous versions are kept aside and the files
+	// of a failed run are quarantined.
+	runDir  string
+	written []string
+	// previous are the files written whose previous version is kept aside.
+	previous map[string]bool
+}
+
+var (
+	runJournalsMutex sync.Mutex
+	// runJournals are the journals of the runs in progress, by round directory.
+	runJournals = make(map[string]*runJournal)
+)
+
+// beginRun starts recording the files written in the round directory by the run of the round with the given id, or
+// returns nil if the round directory is in a storage (see storageOf), where files are not rolled back, or already has
+// a run in progress.
+func beginRun(roundDir string, roundId string) *runJournal {
+	if isStorageURI(roundDir) {
+		return nil
+	}
+	runJournalsMutex.Lock()
+	defer runJournalsMutex.Unlock()
+	if _, ok := runJournals[roundDir]; ok {
+		return nil
+	}
+	journal := &runJournal{roundDir: roundDir, runDir: roundDir + RUN_DIRECTORY_PREFIX + roundId + "/", previous: make(map[string]bool)}
+	runJournals[roundDir] = journal
+	return journal
+}
+
+// runJournalOf returns the journal of the run in progress in the round directory of the file, if any.
+func runJournalOf(filePath string) *runJournal {
+	runJournalsMutex.Lock()
+	defer runJournalsMutex.Unlock()
+	for roundDir, journal := range runJournals {
+		if strings.HasPrefix(filePath, roundDir) && !strings.HasPrefix(filePath, journal.runDir) {
+			return journal
+		}
+	}
+	return nil
+}
+
+// recordWrite records that the file at filePath is about to be replaced by the run, keeping its previous version aside
+// the first time, with a hard link (or a copy if it can't be linked) so the file stays in place until it is replaced.
+func (journal *runJournal) recordWrite(filePath string) error {
+	if journal == nil {
+		return nil
+	}
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	relativePath := strings.TrimPrefix(filePath, journal.roundDir)
+	if _, ok := journal.previous[relativePath]; ok {
+		return nil
+	}
+	journal.previous[relativePath] = false
+	journal.written = append(journal.written, relativePath)
+	if _, err := os.Stat(filePath); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	previousPath := journal.runDir + RUN_PREVIOUS_DIRECTORY + relativePath
+	if err := os.MkdirAll(filepath.Dir(previousPath), 0o755); err != nil {
+		return err
+	}
+	if err := os.Link(filePath, previousPath); err != nil {
+		if err := copyLocalFile(filePath, previousPath); err != nil {
+			return fmt.Errorf("error keeping the previous version of %s: %w", filePath, err)
+		}
+	}
+	journal.previous[relativePath] = true
+	return nil
+}
+
+// end ends the run, and must be deferred by the function running it: if the run panicked or returned an error in
+// runErr, the files it wrote are moved to the failed directory of the run, the previous versions of those it overwrote
+// are restored, and the panic is resumed. Otherwise, the previous versions are discarded.
+func (journal *runJournal) end(runErr *error) {
+	if journal == nil {
+		return
+	}
+	runJournalsMutex.Lock()
+	delete(runJournals, journal.roundDir)
+	runJournalsMutex.Unlock()
+
+	if r := recover(); r != nil {
+		if err := journal.rollBack(); err != nil {
+			panic(fmt.Sprintf("%v (error rolling back the files written: %v)", r, err))
+		}
+		panic(r)
+	}
+	if *runErr != nil {
+		if err := journal.rollBack(); err != nil {
+			*runErr = fmt.Errorf("%w (error rolling back the files written: %v)", *runErr, err)
+		}
+		return
+	}
+	panicOnError(os.RemoveAll(journal.runDir), "error removing the previous versions of the files of the run")
+}
+
+// rollBack moves the files written by the run to the failed directory of the run, latest first, and restores the
+// previous versions of those it overwrote.
+func (journal *runJournal) rollBack() error {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	var errs []error
+	for i := len(journal.written) - 1; i >= 0; i-- {
+		relativePath := journal.written[i]
+		filePath := journal.roundDir + relativePath
+		failedPath := journal.runDir + RUN_FAILED_DIRECTORY + relativePath
+		if err := os.MkdirAll(filepath.Dir(failedPath), 0o755); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := os.Rename(filePath, failedPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, err)
+			continue
+		}
+		if journal.previous[relativePath] {
+			errs = append(errs, os.Rename(journal.runDir+RUN_PREVIOUS_DIRECTORY+relativePath, filePath))
+		}
+	}
+	errs = append(errs, os.RemoveAll(journal.runDir+RUN_PREVIOUS_DIRECTORY))
+	return errors.Join(errs...)
+}
+
+// copyLocalFile copies the local file at source to destination.
+func copyLocalFile(source string, destination string) error {
+	file, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return writeLocalFile(destination, func(w io.Writer) error {
+		_, err := io.Copy(w, file)
+		return err
+	})
+}
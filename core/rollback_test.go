@@ -0,0 +1,86 @@
+package core
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"github.com/consensys/gnark/test"
+)
+
+// failingSigner is an ed25519 signer that fails to sign, failing a run of the prover once every proof is written.
+type failingSigner struct {
+	ed25519.PublicKey
+}
+
+func (signer failingSigner) Public() crypto.PublicKey {
+	return signer.PublicKey
+}
+
+func (failingSigner) Sign(io.Reader, []byte, crypto.SignerOpts) ([]byte, error) {
+	return nil, errors.New("signing key unavailable")
+}
+
+// readDirectoryFiles returns the contents of the files in dir and its subdirectories, by path relative to dir, except
+// those whose path starts with one of the given prefixes.
+func readDirectoryFiles(t *testing.T, dir string, except ...string) map[string]string {
+	files := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		relativePath := filepath.ToSlash(strings.TrimPrefix(path, dir))
+		if !slices.ContainsFunc(except, func(prefix string) bool { return strings.HasPrefix(relativePath, prefix) }) {
+			data, err := os.ReadFile(path)
+			files[relativePath] = string(data)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestProveRollsBackFailedRun(t *testing.T) {
+	assert := test.NewAssert(t)
+	config := circuit.GetConfig()
+	assert.NoError(circuit.SetConfig(circuit.Config{TreeDepth: 2}))
+	defer func() { assert.NoError(circuit.SetConfig(config)) }()
+
+	// a successful run leaves no run directory
+	dir := t.TempDir() + "/"
+	GenerateData(2, 4, dir)
+	Prove(2, dir)
+	entries, err := filepath.Glob(dir + RUN_DIRECTORY_PREFIX + "*")
+	assert.NoError(err)
+	assert.Empty(entries)
+	previousRun := readDirectoryFiles(t, dir, RUN_DIRECTORY_PREFIX, PROVER_AUDIT_LOG_FILE)
+
+	// a run failing once every proof is written leaves the files of the previous run, which still verify, and
+	// quarantines its own (it is only recorded in the prover audit log)
+	publicKey, _, err := ed25519.GenerateKey(nil)
+	assert.NoError(err)
+	setOptions(t, func(options *Options) { options.ArtifactSigner = failingSigner{publicKey} })
+	assert.Error(ProveWithError(2, dir))
+	assert.Equal(previousRun, readDirectoryFiles(t, dir, RUN_DIRECTORY_PREFIX, PROVER_AUDIT_LOG_FILE))
+	assert.NotPanics(func() { VerifyFull(2, dir) })
+
+	runDirs, err := filepath.Glob(dir + RUN_DIRECTORY_PREFIX + "*")
+	assert.NoError(err)
+	assert.Equal(1, len(runDirs))
+	failedRun := readDirectoryFiles(t, runDirs[0]+"/"+RUN_FAILED_DIRECTORY)
+	assert.NotEqual(previousRun[GetOptions().OutputLayout.topProofPrefix()+"0.json"], failedRun[GetOptions().OutputLayout.topProofPrefix()+"0.json"])
+	assert.NotEmpty(failedRun[ROUND_MANIFEST_FILE])
+	_, err = os.Stat(runDirs[0] + "/" + RUN_PREVIOUS_DIRECTORY)
+	assert.True(os.IsNotExist(err))
+}
@@ -0,0 +1,382 @@
+package core
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"bitgo.com/proof_of_reserves/verification"
+	"gopkg.in/yaml.v3"
+)
+
+// stages of a round run by RunRound, in the order they are run
+const (
+	ROUND_STAGE_PREFLIGHT = "preflight"
+	ROUND_STAGE_PROVE     = "prove"
+	ROUND_STAGE_VERIFY    = "verify"
+	ROUND_STAGE_SIGN      = "sign"
+	ROUND_STAGE_PUBLISH   = "publish"
+)
+
+// statuses of the entries of the round audit log
+const (
+	ROUND_AUDIT_STARTED   = "started"
+	ROUND_AUDIT_SUCCEEDED = "succeeded"
+	ROUND_AUDIT_FAILED    = "failed"
+	// ROUND_AUDIT_RESTARTED marks that the stages before it are run again.
+	ROUND_AUDIT_RESTARTED = "restarted"
+)
+
+// RoundConfig configures a round run by RunRound. It is read from YAML with ReadRoundConfig.
+type RoundConfig struct {
+	BatchCount int `yaml:"batchCount"`
+	// OutDir is the directory holding the secret and public directories of the round (defaults to OUT_DIR).
+	OutDir string `yaml:"outDir"`
+	// DeploymentDomain must be the configured deployment domain (see circuit.SetDeploymentDomain).
+	DeploymentDomain string `yaml:"deploymentDomain"`
+	// Epoch is the epoch the proofs of the round are bound to (optional, see Options.ProofEpoch).
+	Epoch uint64 `yaml:"epoch"`
+	// PublicationPolicy is the path of a JSON publication policy (optional, see PublicationPolicy).
+	PublicationPolicy string `yaml:"publicationPolicy"`
+	// SigningKey is the path of the key the key bundle and batch receipts are signed with (see GenerateKeyBundleSigningKey).
+	SigningKey        string        `yaml:"signingKey"`
+	KeyBundleValidity time.Duration `yaml:"keyBundleValidity"`
+	// PublishDir is the directory the public directory of the round is published to. It must not exist yet, or be empty.
+	PublishDir string `yaml:"publishDir"`
+	// ExpectedAccountCount and ExpectedLiabilities are the totals of the ledger the batches were exported from, which
+	// the proven round is reconciled against (optional).
+	ExpectedAccountCount int            `yaml:"expectedAccountCount"`
+	ExpectedLiabilities  []RawUVBalance `yaml:"expectedLiabilities"`
+}
+
+// RoundAuditEntry is an entry of the round audit log, recording the outcome of a stage of a round run by RunRound.
+type RoundAuditEntry struct {
+	Time    time.Time
+	Stage   string
+	Status  string
+	RoundId string `json:",omitempty"`
+	Error   string `json:",omitempty"`
+	// InputsDigest is the digest of the inputs of the round (see roundInputsDigest) the preflight and prove stages ran
+	// against.
+	InputsDigest string `json:",omitempty"`
+}
+
+// ReadRoundConfig reads a round config from the given YAML file, filling in defaults.
+func ReadRoundConfig(path string) RoundConfig {
+	file, err := os.Open(path)
+	panicOnError(err, "error opening round config")
+	defer file.Close()
+	config := RoundConfig{OutDir: OUT_DIR, KeyBundleValidity: 90 * 24 * time.Hour}
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	panicOnError(decoder.Decode(&config), "error reading round config")
+	if !strings.HasSuffix(config.OutDir, "/") {
+		config.OutDir += "/"
+	}
+	return config
+}
+
+// Validate returns an error if the round config is incomplete.
+func (config RoundConfig) Validate() error {
+	if err := NewLayerPlan(config.BatchCount).Validate(); err != nil {
+		return err
+	}
+	if config.OutDir == "" || config.SigningKey == "" || config.PublishDir == "" {
+		return fmt.Errorf("round config must set outDir, signingKey, and publishDir")
+	}
+	if config.KeyBundleValidity <= 0 {
+		return fmt.Errorf("key bundle validity must be positive")
+	}
+	return nil
+}
+
+// readRoundAuditLog reads the entries of the audit log of the round in outDir. Returns nil if there is none.
+func readRoundAuditLog(outDir string) ([]RoundAuditEntry, error) {
+	file, err := os.Open(outDir + ROUND_AUDIT_LOG_FILE)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	entries := make([]RoundAuditEntry, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry RoundAuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("error decoding round audit log: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// appendRoundAuditEntry appends an entry to the audit log of the round in outDir, tagged with the round id once the
+// round is proven.
+func appendRoundAuditEntry(outDir string, entry RoundAuditEntry) error {
+	var metadata RoundMetadata
+	if readJson(outDir+ROUND_METADATA_FILE, &metadata) == nil {
+		entry.RoundId = metadata.RoundId
+	}
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(outDir+ROUND_AUDIT_LOG_FILE, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(encoded, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// completedRoundStages returns the number of stages (in the order they are run) that succeeded since the round was
+// last restarted, which is the stage a run resumes from.
+func completedRoundStages(entries []RoundAuditEntry, stages []string) int {
+	succeeded := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.Status == ROUND_AUDIT_RESTARTED {
+			succeeded = make(map[string]bool)
+		} else if entry.Status == ROUND_AUDIT_SUCCEEDED {
+			succeeded[entry.Stage] = true
+		}
+	}
+	completed := 0
+	for completed < len(stages) && succeeded[stages[completed]] {
+		completed++
+	}
+	return completed
+}
+
+// roundInputsDigest returns the hex encoded SHA-256 digest of the inputs the round is proven from: the secret inputs
+// of the batches (see provenanceInputNames), and the settings of the config the proofs depend on.
+func roundInputsDigest(config RoundConfig) (string, error) {
+	inputs, err := describeFiles(config.OutDir, provenanceInputNames(config.BatchCount, config.OutDir))
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	for _, input := range inputs {
+		fmt.Fprintf(hash, "%s %s\n", input.Name, input.Digest[PROVENANCE_DIGEST_ALGORITHM])
+	}
+	fmt.Fprintf(hash, "batchCount %d\ndeploymentDomain %q\nepoch %d\n", config.BatchCount, config.DeploymentDomain, config.Epoch)
+	if config.PublicationPolicy != "" {
+		policy, err := readFile(config.PublicationPolicy)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(hash, "publicationPolicy %s\n", describeData(config.PublicationPolicy, policy).Digest[PROVENANCE_DIGEST_ALGORITHM])
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// recordedRoundInputsDigest returns the inputs digest last recorded in the audit log since the round was last
+// restarted, or "" if there is none.
+func recordedRoundInputsDigest(entries []RoundAuditEntry) string {
+	digest := ""
+	for _, entry := range entries {
+		if entry.Status == ROUND_AUDIT_RESTARTED {
+			digest = ""
+		} else if entry.InputsDigest != "" {
+			digest = entry.InputsDigest
+		}
+	}
+	return digest
+}
+
+// preflightRound checks everything the round needs is in place before proving.
+func preflightRound(config RoundConfig) {
+	panicOnError(config.Validate(), "invalid round config")
+	if config.DeploymentDomain != circuit.GetDeploymentDomain() {
+		panic(fmt.Sprintf("round config is for deployment domain %q, but the configured domain is %q", config.DeploymentDomain, circuit.GetDeploymentDomain()))
+	}
+	for i := 0; i < config.BatchCount; i++ {
+		elements := ReadDataFromFile[ProofElements](config.OutDir + GetOptions().OutputLayout.secretDataFile(i))
+		if len(elements.Accounts) > circuit.GetAccountsPerBatch() {
+			panic(fmt.Sprintf("batch %d has %d accounts, more than %d", i, len(elements.Accounts), circuit.GetAccountsPerBatch()))
+		}
+	}
+	readExclusionList(config.OutDir)
+	if config.PublicationPolicy != "" {
+		ReadPublicationPolicy(config.PublicationPolicy)
+	}
+	ReadKeyBundleSigningKey(config.SigningKey)
+	if len(config.ExpectedLiabilities) > 0 {
+		_, err := ConvertRawUVBalancesToGoBalance(config.ExpectedLiabilities)
+		panicOnError(err, "invalid expected liabilities")
+	}
+	panicOnError(os.MkdirAll(config.OutDir+"public", 0o755), "failed to create public directory")
+	publishedFiles, err := os.ReadDir(config.PublishDir)
+	if err == nil && len(publishedFiles) > 0 {
+		panic("publish directory " + config.PublishDir + " is not empty")
+	} else if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		panic("error reading publish directory: " + err.Error())
+	}
+}
+
+// reconcileRound checks the proven round accounts for every account of the batches, is bound to the epoch of the
+// config, and matches the totals of the ledger the batches were exported from, if they are configured.
+func reconcileRound(config RoundConfig) {
+	metadata := readRoundMetadata(config.OutDir)
+	var report RoundReport
+	panicOnError(readJson(config.OutDir+ROUND_REPORT_FILE, &report), "error reading round report")
+	if report.RoundId != metadata.RoundId || len(report.ExcludedAccounts) != metadata.ExcludedAccountCount {
+		panic(fmt.Sprintf("round report of round %q excludes %d accounts, but the round metadata of round %q excludes %d",
+			report.RoundId, len(report.ExcludedAccounts), metadata.RoundId, metadata.ExcludedAccountCount))
+	}
+
+	if config.ExpectedAccountCount > 0 {
+		accountCount := 0
+		for i := 0; i < config.BatchCount; i++ {
+			accountCount += len(ReadDataFromFile[ProofElements](config.OutDir + GetOptions().OutputLayout.secretDataFile(i)).Accounts)
+		}
+		if accountCount-metadata.ExcludedAccountCount != config.ExpectedAccountCount {
+			panic(fmt.Sprintf("round proves %d accounts (%d excluded), but the ledger has %d", accountCount-metadata.ExcludedAccountCount, metadata.ExcludedAccountCount, config.ExpectedAccountCount))
+		}
+	}
+	topLevelProof := ReadDataFromFile[CompletedProof](config.OutDir + topLevelLayer.publicPrefix() + "0.json")
+	if verification.EpochOf(topLevelProof) != config.Epoch {
+		panic(fmt.Sprintf("round is bound to epoch %d, but the round config is for epoch %d", verification.EpochOf(topLevelProof), config.Epoch))
+	}
+	if len(config.ExpectedLiabilities) > 0 {
+		expectedLiabilities, err := ConvertRawUVBalancesToGoBalance(config.ExpectedLiabilities)
+		panicOnError(err, "invalid expected liabilities")
+		if topLevelProof.AssetSum == nil || !topLevelProof.AssetSum.Equals(expectedLiabilities) {
+			panic("liability sum of the round does not match the liabilities of the ledger")
+		}
+	}
+}
+
+// signRound writes the signed key bundle of the round and the signed receipt of every batch.
+func signRound(config RoundConfig) {
+	privateKey := ReadKeyBundleSigningKey(config.SigningKey)
+	now := time.Now()
+	bundle := BuildKeyBundle(config.BatchCount, config.OutDir, now, now.Add(config.KeyBundleValidity))
+	WriteKeyBundle(config.OutDir+KEY_BUNDLE_FILE, SignKeyBundle(bundle, privateKey))
+	for i, receipt := range IssueBatchReceipts(config.BatchCount, config.OutDir, privateKey, now) {
+		WriteBatchReceipt(config.OutDir+BATCH_RECEIPT_PREFIX+strconv.Itoa(i)+".json", receipt)
+	}
+}
+
+// publishRound copies the public directory of the round to the publish directory. The files are copied to a
+// temporary directory first, which is then renamed, so the publish directory never holds a partial round.
+func publishRound(config RoundConfig) {
+	partialDir := config.PublishDir + ".partial"
+	panicOnError(os.RemoveAll(partialDir), "failed to remove partially published round")
+	panicOnError(os.CopyFS(partialDir, os.DirFS(config.OutDir+"public")), "failed to copy public directory")
+	if err := os.Remove(config.PublishDir); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		panic("failed to replace publish directory: " + err.Error())
+	}
+	panicOnError(os.Rename(partialDir, config.PublishDir), "failed to publish round")
+}
+
+// RunRound runs the lifecycle of a round: preflight validation, proving, verification and reconciliation, signing,
+// and publishing. Each stage only runs once the stages before it succeeded. The outcome of every stage is appended to
+// the audit log of the round (ROUND_AUDIT_LOG_FILE), and a run resumes from the first stage that has not succeeded
+// yet, unless restart is set, in which case every stage is run again. The preflight and prove stages record the digest
+// of the inputs of the round (see roundInputsDigest), and if the inputs changed since, a resumed run restarts from the
+// preflight, so the round is never proven from, or published for, inputs that were not validated. Progress is written to progress. Returns an
+// error naming the stage that failed.
+func RunRound(config RoundConfig, restart bool, progress io.Writer) error {
+	updateOptions(func(options *Options) { options.ProofEpoch = config.Epoch })
+	if config.PublicationPolicy != "" {
+		if err := runStep(func() {
+			policy := ReadPublicationPolicy(config.PublicationPolicy)
+			updateOptions(func(options *Options) { options.ProofPublicationPolicy = policy })
+		}); err != nil {
+			return err
+		}
+	}
+	// every stage but proving and verifying, which find it themselves, reads and writes the round directory of the
+	// epoch (see Options.OutputLayout)
+	outDir := config.OutDir
+	config.OutDir = GetOptions().OutputLayout.RoundDir(outDir)
+	if err := os.MkdirAll(config.OutDir+"secret", 0o755); err != nil {
+		return err
+	}
+
+	stages := []struct {
+		name string
+		run  func()
+	}{
+		{ROUND_STAGE_PREFLIGHT, func() { preflightRound(config) }},
+		{ROUND_STAGE_PROVE, func() { Prove(config.BatchCount, outDir) }},
+		{ROUND_STAGE_VERIFY, func() {
+			// the round may have been proven in an earlier run, whose keys this run did not pin
+			pinRoundVerificationKeys(config.BatchCount, outDir)
+			VerifyFull(config.BatchCount, outDir)
+			reconcileRound(config)
+		}},
+		{ROUND_STAGE_SIGN, func() { signRound(config) }},
+		{ROUND_STAGE_PUBLISH, func() { publishRound(config) }},
+	}
+	names := make([]string, len(stages))
+	for i, stage := range stages {
+		names[i] = stage.name
+	}
+
+	if restart {
+		if err := appendRoundAuditEntry(config.OutDir, RoundAuditEntry{Time: time.Now().UTC(), Status: ROUND_AUDIT_RESTARTED}); err != nil {
+			return fmt.Errorf("error writing round audit log: %w", err)
+		}
+	}
+	entries, err := readRoundAuditLog(config.OutDir)
+	if err != nil {
+		return fmt.Errorf("error reading round audit log: %w", err)
+	}
+	completed := completedRoundStages(entries, names)
+	if recorded := recordedRoundInputsDigest(entries); completed > 0 && recorded != "" {
+		digest, err := roundInputsDigest(config)
+		if err != nil {
+			return fmt.Errorf("error computing round inputs digest: %w", err)
+		}
+		if digest != recorded {
+			fmt.Fprintf(progress, "inputs of the round changed since it was last run, restarting from the %s stage\n", ROUND_STAGE_PREFLIGHT)
+			if err := appendRoundAuditEntry(config.OutDir, RoundAuditEntry{Time: time.Now().UTC(), Status: ROUND_AUDIT_RESTARTED}); err != nil {
+				return fmt.Errorf("error writing round audit log: %w", err)
+			}
+			completed = 0
+		}
+	}
+
+	for i, stage := range stages {
+		if i < completed {
+			fmt.Fprintf(progress, "[%d/%d] %s (already succeeded)\n", i+1, len(stages), stage.name)
+			continue
+		}
+		fmt.Fprintf(progress, "[%d/%d] %s\n", i+1, len(stages), stage.name)
+		if err := appendRoundAuditEntry(config.OutDir, RoundAuditEntry{Time: time.Now().UTC(), Stage: stage.name, Status: ROUND_AUDIT_STARTED}); err != nil {
+			return fmt.Errorf("error writing round audit log: %w", err)
+		}
+		stageErr := runStep(stage.run)
+		entry := RoundAuditEntry{Time: time.Now().UTC(), Stage: stage.name, Status: ROUND_AUDIT_SUCCEEDED}
+		if stageErr != nil {
+			entry.Status = ROUND_AUDIT_FAILED
+			entry.Error = stageErr.Error()
+		} else if stage.name == ROUND_STAGE_PREFLIGHT || stage.name == ROUND_STAGE_PROVE {
+			if entry.InputsDigest, err = roundInputsDigest(config); err != nil {
+				return fmt.Errorf("error computing round inputs digest: %w", err)
+			}
+		}
+		if err := appendRoundAuditEntry(config.OutDir, entry); err != nil {
+			return fmt.Errorf("error writing round audit log: %w", err)
+		}
+		if stageErr != nil {
+			return fmt.Errorf("round failed at stage %s: %w", stage.name, stageErr)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package core
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/consensys/gnark/test"
+)
+
+// countRoundAuditEntries counts the entries of the audit log with the given stage and status.
+func countRoundAuditEntries(entries []RoundAuditEntry, stage string, status string) int {
+	count := 0
+	for _, entry := range entries {
+		if entry.Stage == stage && entry.Status == status {
+			count++
+		}
+	}
+	return count
+}
+
+func TestRunRound(t *testing.T) {
+	assert := test.NewAssert(t)
+	dir := t.TempDir()
+	outDir := filepath.Join(dir, "out") + "/"
+	assert.NoError(os.MkdirAll(outDir+"secret", 0o755))
+	GenerateData(1, 4, outDir)
+	GenerateKeyBundleSigningKey(filepath.Join(dir, "signing_key"))
+
+	configPath := filepath.Join(dir, "round.yaml")
+	assert.NoError(os.WriteFile(configPath, []byte(
+		"batchCount: 1\n"+
+			"outDir: "+filepath.Join(dir, "out")+"\n"+
+			"signingKey: "+filepath.Join(dir, "signing_key")+"\n"+
+			"publishDir: "+filepath.Join(dir, "published")+"\n"+
+			"keyBundleValidity: 24h\n"+
+			"expectedAccountCount: 5\n"), 0o644))
+	config := ReadRoundConfig(configPath)
+	assert.Equal(outDir, config.OutDir)
+
+	// reconciliation against the wrong account count fails the verify stage, so the round is not signed
+	err := RunRound(config, false, io.Discard)
+	assert.ErrorContains(err, ROUND_STAGE_VERIFY)
+	_, err = os.Stat(outDir + KEY_BUNDLE_FILE)
+	assert.True(os.IsNotExist(err), "expected the round not to be signed")
+
+	// rerunning with the right totals resumes from the verify stage
+	config.ExpectedAccountCount = 4
+	config.ExpectedLiabilities = ConvertGoBalanceToRawUVBalances(*ReadDataFromFile[CompletedProof](outDir + TOP_PROOF_PREFIX + "0.json").AssetSum)
+	assert.NoError(RunRound(config, false, io.Discard))
+	entries, err := readRoundAuditLog(outDir)
+	assert.NoError(err)
+	assert.Equal(1, countRoundAuditEntries(entries, ROUND_STAGE_PROVE, ROUND_AUDIT_STARTED))
+	assert.Equal(1, countRoundAuditEntries(entries, ROUND_STAGE_VERIFY, ROUND_AUDIT_FAILED))
+	assert.Equal(1, countRoundAuditEntries(entries, ROUND_STAGE_PUBLISH, ROUND_AUDIT_SUCCEEDED))
+	assert.Equal(readRoundMetadata(outDir).RoundId, entries[len(entries)-1].RoundId)
+	for _, name := range []string{"round_metadata.json", "key_bundle.json", "top_level_proof_0.json"} {
+		_, err := os.Stat(filepath.Join(config.PublishDir, name))
+		assert.NoError(err, "expected %s to be published", name)
+	}
+	_, err = os.Stat(outDir + BATCH_RECEIPT_PREFIX + "0.json")
+	assert.NoError(err)
+
+	// a completed round is not run again
+	assert.NoError(RunRound(config, false, io.Discard))
+	resumedEntries, err := readRoundAuditLog(outDir)
+	assert.NoError(err)
+	assert.Equal(len(entries), len(resumedEntries))
+	assert.NotEmpty(recordedRoundInputsDigest(entries))
+
+	// replacing a batch after it was proven restarts the round from the preflight, which refuses to overwrite the
+	// published round
+	GenerateData(1, 3, outDir)
+	err = RunRound(config, false, io.Discard)
+	assert.ErrorContains(err, ROUND_STAGE_PREFLIGHT)
+	changedEntries, err := readRoundAuditLog(outDir)
+	assert.NoError(err)
+	assert.Equal(ROUND_AUDIT_RESTARTED, changedEntries[len(entries)].Status)
+	assert.Equal(1, countRoundAuditEntries(changedEntries, ROUND_STAGE_PROVE, ROUND_AUDIT_STARTED))
+
+	// restarting runs the preflight again, which refuses to overwrite the published round
+	err = RunRound(config, true, io.Discard)
+	assert.ErrorContains(err, ROUND_STAGE_PREFLIGHT)
+}
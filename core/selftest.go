@@ -0,0 +1,67 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const (
+	SELF_TEST_BATCH_COUNT        = 2
+	SELF_TEST_ACCOUNTS_PER_BATCH = 4
+)
+
+// RunSelfTest generates a tiny synthetic round in dir, proves it, fully verifies it, exports the user verification
+// packages, and verifies one of them, writing the progress of each step to progress. It checks the binary and
+// environment work end to end before a production run. Returns an error naming the step that failed.
+func RunSelfTest(dir string, progress io.Writer) error {
+	outDir := dir + "/"
+	userOutDir := filepath.Join(dir, "users")
+	var userPackagePath string
+
+	steps := []struct {
+		name string
+		run  func()
+	}{
+		{"generating synthetic data", func() {
+			panicOnError(os.MkdirAll(outDir+"secret", 0o755), "failed to create secret directory")
+			panicOnError(os.MkdirAll(outDir+"public", 0o755), "failed to create public directory")
+			GenerateData(SELF_TEST_BATCH_COUNT, SELF_TEST_ACCOUNTS_PER_BATCH, outDir)
+		}},
+		{"proving", func() { Prove(SELF_TEST_BATCH_COUNT, outDir) }},
+		{"verifying the round", func() { VerifyFull(SELF_TEST_BATCH_COUNT, outDir) }},
+		{"exporting user packages", func() {
+			manifest := ExportUserPackages(SELF_TEST_BATCH_COUNT, outDir, userOutDir, ExportOptions{ShardSize: 1})
+			if manifest.UserCount != SELF_TEST_BATCH_COUNT*SELF_TEST_ACCOUNTS_PER_BATCH {
+				panic(fmt.Sprintf("expected %d user packages, exported %d", SELF_TEST_BATCH_COUNT*SELF_TEST_ACCOUNTS_PER_BATCH, manifest.UserCount))
+			}
+			packages, err := filepath.Glob(filepath.Join(manifest.Shards[len(manifest.Shards)-1].Directory, "*.json"))
+			panicOnError(err, "error listing user packages")
+			if len(packages) == 0 {
+				panic("no user packages were exported")
+			}
+			userPackagePath = packages[0]
+		}},
+		{"verifying a user package", func() { VerifyUser(ReadDataFromFile[UserVerificationElements](userPackagePath)) }},
+	}
+
+	for i, step := range steps {
+		fmt.Fprintf(progress, "[%d/%d] %s\n", i+1, len(steps), step.name)
+		if err := runStep(step.run); err != nil {
+			return fmt.Errorf("self test failed while %s: %w", step.name, err)
+		}
+	}
+	return nil
+}
+
+// runStep runs a step of the self test or of a round, converting a panic into an error.
+func runStep(run func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	run()
+	return nil
+}
@@ -0,0 +1,23 @@
+package core
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/consensys/gnark/test"
+)
+
+func TestRunSelfTest(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	var progress bytes.Buffer
+	assert.NoError(RunSelfTest(t.TempDir(), &progress))
+	assert.Equal(5, strings.Count(progress.String(), "\n"))
+	assert.True(strings.HasPrefix(progress.String(), "[1/5] generating synthetic data"))
+
+	// failures name the failing step
+	err := RunSelfTest("/dev/null/selftest", &progress)
+	assert.Error(err)
+	assert.Contains(err.Error(), "generating synthetic data")
+}
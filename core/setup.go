@@ -0,0 +1,261 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/backend/plonk"
+	"github.com/consensys/gnark/constraint"
+)
+
+// SetSetupKeyDir makes the prover read the keys of its circuits from a key directory written by Setup instead of
+// setting each circuit up when it is compiled, so production rounds are proven with keys generated and audited ahead
+// of time, and every run with the same keys gives proofs with the same verification keys. A circuit whose keys are not
+// in the directory, or do not match their digests or the compiled circuit, makes proving fail rather than fall back
+// to a fresh setup. The empty directory (the default) sets circuits up when they are compiled.
+func SetSetupKeyDir(keyDir string) error {
+	if keyDir != "" {
+		if _, err := ReadSetupManifest(keyDir); err != nil {
+			return err
+		}
+	}
+	return updateSettings(func(s *settings) error {
+		if keyDir != s.setupKeyDir {
+			// circuits cached with other keys must not be used
+			s.circuitCache.Clear()
+		}
+		s.setupKeyDir = keyDir
+		return nil
+	})
+}
+
+func GetSetupKeyDir() string {
+	return getSettings().setupKeyDir
+}
+
+// ReadSetupManifest reads the setup manifest of a key directory written by Setup.
+func ReadSetupManifest(keyDir string) (SetupManifest, error) {
+	var manifest SetupManifest
+	if err := readJson(filepath.Join(keyDir, SETUP_MANIFEST_FILE), &manifest); err != nil {
+		return SetupManifest{}, fmt.Errorf("error reading setup manifest: %w", err)
+	}
+	return manifest, nil
+}
+
+// Setup compiles and sets up the bottom level circuits for each of the given account counts, and the upper level
+// circuits (see circuit.Config.ForUpperLevel) for each of the given upper level account counts (see
+// RoundAccountCounts), with the configured backend, hash function, hash version, leaf hash mode, circuit configuration,
+// asset registry, and setup seed, and writes their keys to keyDir, with a setup manifest listing them. Circuits
+// already in the manifest are set up again, and others are kept, so the keys of more circuits can be added later. The
+// aggregation circuits of BACKEND_GROTH16_RECURSIVE depend on the keys of the lower level circuits, so the circuits of
+// that backend can't be set up ahead of time.
+func Setup(accountCounts []int, upperLevelAccountCounts []int, keyDir string) (SetupManifest, error) {
+	backend := GetProofBackend()
+	if backend == BACKEND_GROTH16_RECURSIVE {
+		return SetupManifest{}, fmt.Errorf("circuits of the %s backend can't be set up ahead of proving", backend)
+	}
+	if len(accountCounts) == 0 && len(upperLevelAccountCounts) == 0 {
+		return SetupManifest{}, errors.New("no account counts to set up circuits for")
+	}
+	var keys []circuitKey
+	for _, accountCount := range slices.Concat(accountCounts, upperLevelAccountCounts) {
+		if accountCount < 1 || accountCount > circuit.GetAccountsPerBatch() {
+			return SetupManifest{}, fmt.Errorf("account count must be between 1 and %d, got %d", circuit.GetAccountsPerBatch(), accountCount)
+		}
+		if len(keys) < len(accountCounts) {
+			keys = append(keys, newCircuitKey(accountCount))
+		} else {
+			keys = append(keys, newUpperLevelCircuitKey(accountCount))
+		}
+	}
+	manifest, err := openSetupManifest(keyDir, backend)
+	if err != nil {
+		return SetupManifest{}, err
+	}
+	for _, key := range keys {
+		setupCircuit, err := setupCircuitKeys(key, keyDir)
+		if err != nil {
+			return SetupManifest{}, fmt.Errorf("error setting up the circuit for %d accounts: %w", key.accountCount, err)
+		}
+		manifest.addCircuit(setupCircuit)
+		slog.Debug("set up circuit", "accounts", key.accountCount, "upperLevel", key.config.UpperLevel, "circuit", setupCircuit.CircuitId)
+	}
+	if err := writeJson(filepath.Join(keyDir, SETUP_MANIFEST_FILE), manifest); err != nil {
+		return SetupManifest{}, err
+	}
+	return manifest, nil
+}
+
+// openSetupManifest returns the setup manifest of keyDir, which must hold keys of the backend and of the current
+// circuit version, or a new manifest (creating keyDir) if it has none.
+func openSetupManifest(keyDir string, backend ProofBackend) (SetupManifest, error) {
+	if err := os.MkdirAll(keyDir, 0o755); err != nil {
+		return SetupManifest{}, err
+	}
+	manifest := SetupManifest{Backend: backend, CircuitVersion: circuit.CIRCUIT_VERSION}
+	if existing, err := ReadSetupManifest(keyDir); err == nil {
+		if existing.Backend != backend || existing.CircuitVersion != circuit.CIRCUIT_VERSION {
+			return SetupManifest{}, fmt.Errorf("key directory holds keys of the %s backend and circuit version %d, not %s and %d",
+				existing.Backend, existing.CircuitVersion, backend, circuit.CIRCUIT_VERSION)
+		}
+		manifest.Circuits = existing.Circuits
+	}
+	return manifest, nil
+}
+
+// addCircuit adds the circuit to the manifest, replacing the keys of the same circuit if it has them.
+func (manifest *SetupManifest) addCircuit(setupCircuit SetupCircuit) {
+	manifest.Circuits = slices.DeleteFunc(manifest.Circuits, func(c SetupCircuit) bool { return c.CircuitId == setupCircuit.CircuitId })
+	manifest.Circuits = append(manifest.Circuits, setupCircuit)
+	// the bottom level circuit of a number of accounts comes before its upper level circuit
+	slices.SortFunc(manifest.Circuits, func(a, b SetupCircuit) int {
+		switch {
+		case a.AccountCount != b.AccountCount:
+			return a.AccountCount - b.AccountCount
+		case a.UpperLevel == b.UpperLevel:
+			return 0
+		case a.UpperLevel:
+			return 1
+		}
+		return -1
+	})
+}
+
+// RoundAccountCounts returns the account counts of the circuits of a round with the plan whose batches are full, i.e.
+// of a full batch, and of every upper level proof, for Setup.
+func RoundAccountCounts(plan LayerPlan) (accountCounts []int, upperLevelAccountCounts []int) {
+	for level := 1; level < plan.Levels(); level++ {
+		for index := 0; index < plan.LevelProofCount(level); index++ {
+			upperLevelAccountCounts = append(upperLevelAccountCounts, plan.LevelProofSize(level, index))
+		}
+	}
+	slices.Sort(upperLevelAccountCounts)
+	return []int{circuit.GetAccountsPerBatch()}, slices.Compact(upperLevelAccountCounts)
+}
+
+// setupCircuitId returns the identifier of the circuit of the key (see SetupCircuit.CircuitId).
+func setupCircuitId(key circuitKey) string {
+	id := sha256.Sum256([]byte(fmt.Sprintf("%d %+v", circuit.CIRCUIT_VERSION, key)))
+	return hex.EncodeToString(id[:])
+}
+
+// setupCircuitKeys compiles and sets up the circuit of the key, and writes its keys to keyDir.
+func setupCircuitKeys(key circuitKey, keyDir string) (SetupCircuit, error) {
+	baseCircuit := newBaseCircuit(key.accountCount, key.config)
+	partialProof := PartialProof{backend: key.backend}
+	var err error
+	if partialProof.cs, err = compileCircuit(key.backend, &baseCircuit); err != nil {
+		return SetupCircuit{}, err
+	}
+	if err := setupCircuit(&partialProof); err != nil {
+		return SetupCircuit{}, err
+	}
+
+	pk, vk := setupKeys(partialProof)
+	return writeSetupKeys(key, partialProof.cs, pk, vk, keyDir)
+}
+
+// writeSetupKeys writes the proving and verification keys of the circuit of the key, compiled into cs, to keyDir, and
+// returns their entry of the setup manifest.
+func writeSetupKeys(key circuitKey, cs constraint.ConstraintSystem, pk io.WriterTo, vk io.WriterTo, keyDir string) (SetupCircuit, error) {
+	id := setupCircuitId(key)
+	name := "circuit_" + strconv.Itoa(key.accountCount) + "_" + id[:16]
+	setupCircuit := SetupCircuit{
+		CircuitId:           id,
+		AccountCount:        key.accountCount,
+		UpperLevel:          key.config.UpperLevel,
+		Constraints:         cs.GetNbConstraints(),
+		Wires:               cs.GetNbPublicVariables() + cs.GetNbSecretVariables() + cs.GetNbInternalVariables(),
+		ProvingKeyFile:      name + SETUP_PROVING_KEY_EXTENSION,
+		VerificationKeyFile: name + SETUP_VERIFYING_KEY_EXTENSION,
+	}
+	var err error
+	if setupCircuit.ProvingKeyDigest, err = writeSetupKey(filepath.Join(keyDir, setupCircuit.ProvingKeyFile), pk); err != nil {
+		return SetupCircuit{}, err
+	}
+	if setupCircuit.VerificationKeyFingerprint, err = writeSetupKey(filepath.Join(keyDir, setupCircuit.VerificationKeyFile), vk); err != nil {
+		return SetupCircuit{}, err
+	}
+	return setupCircuit, nil
+}
+
+// setupKeys returns the proving and verification keys of the backend of the partial proof.
+func setupKeys(partialProof PartialProof) (pk io.WriterTo, vk io.WriterTo) {
+	if partialProof.backend == BACKEND_PLONK {
+		return partialProof.plonkPk, partialProof.plonkVk
+	}
+	return partialProof.pk, partialProof.vk
+}
+
+// writeSetupKey writes the key to filePath, and returns the hex encoded SHA-256 hash of its encoding.
+func writeSetupKey(filePath string, key io.WriterTo) (string, error) {
+	hash := sha256.New()
+	err := writeFile(filePath, func(w io.Writer) error {
+		_, err := key.WriteTo(io.MultiWriter(w, hash))
+		return err
+	})
+	return hex.EncodeToString(hash.Sum(nil)), err
+}
+
+// readSetupKey reads the key from filePath into key, checking the hex encoded SHA-256 hash of its encoding.
+func readSetupKey(filePath string, digest string, key io.ReaderFrom) error {
+	data, err := readFile(filePath)
+	if err != nil {
+		return err
+	}
+	if hash := sha256.Sum256(data); hex.EncodeToString(hash[:]) != digest {
+		return fmt.Errorf("%s does not match its digest in the setup manifest", filepath.Base(filePath))
+	}
+	_, err = key.ReadFrom(bytes.NewReader(data))
+	return err
+}
+
+// loadSetupKeys completes the partial proof of the key, whose circuit is compiled, with its keys from keyDir.
+func loadSetupKeys(partialProof *PartialProof, key circuitKey, keyDir string) error {
+	manifest, err := ReadSetupManifest(keyDir)
+	if err != nil {
+		return err
+	}
+	if manifest.Backend != key.backend {
+		return fmt.Errorf("the keys in %s are of the %s backend, but proofs are generated with %s", keyDir, manifest.Backend, key.backend)
+	}
+	id := setupCircuitId(key)
+	index := slices.IndexFunc(manifest.Circuits, func(c SetupCircuit) bool { return c.CircuitId == id })
+	if index < 0 {
+		return fmt.Errorf("no keys in %s for the circuit of %d accounts with the current configuration (run setup for it)", keyDir, key.accountCount)
+	}
+	setupCircuit := manifest.Circuits[index]
+	wires := partialProof.cs.GetNbPublicVariables() + partialProof.cs.GetNbSecretVariables() + partialProof.cs.GetNbInternalVariables()
+	if setupCircuit.Constraints != partialProof.cs.GetNbConstraints() || setupCircuit.Wires != wires {
+		return fmt.Errorf("the keys in %s are of a circuit of %d constraints and %d wires, but the compiled circuit has %d constraints and %d wires",
+			keyDir, setupCircuit.Constraints, setupCircuit.Wires, partialProof.cs.GetNbConstraints(), wires)
+	}
+
+	var pk, vk io.ReaderFrom
+	if key.backend == BACKEND_PLONK {
+		partialProof.plonkPk, partialProof.plonkVk = plonk.NewProvingKey(ecc.BN254), plonk.NewVerifyingKey(ecc.BN254)
+		pk, vk = partialProof.plonkPk, partialProof.plonkVk
+	} else {
+		partialProof.pk, partialProof.vk = groth16.NewProvingKey(ecc.BN254), groth16.NewVerifyingKey(ecc.BN254)
+		pk, vk = partialProof.pk, partialProof.vk
+	}
+	if err := readSetupKey(filepath.Join(keyDir, setupCircuit.ProvingKeyFile), setupCircuit.ProvingKeyDigest, pk); err != nil {
+		return fmt.Errorf("error reading proving key: %w", err)
+	}
+	if err := readSetupKey(filepath.Join(keyDir, setupCircuit.VerificationKeyFile), setupCircuit.VerificationKeyFingerprint, vk); err != nil {
+		return fmt.Errorf("error reading verification key: %w", err)
+	}
+	return nil
+}
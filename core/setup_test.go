@@ -0,0 +1,77 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"github.com/consensys/gnark/test"
+)
+
+func TestSetupKeysAreReused(t *testing.T) {
+	assert := test.NewAssert(t)
+	defaultCache := GetCircuitCache()
+	defer SetCircuitCache(defaultCache)
+	keyDir := t.TempDir()
+
+	// the keys of each circuit are written with a manifest, and setting up more circuits keeps the others
+	manifest, err := Setup([]int{2}, nil, keyDir)
+	assert.NoError(err)
+	manifest, err = Setup([]int{1}, nil, keyDir)
+	assert.NoError(err)
+	assert.Equal(2, len(manifest.Circuits))
+	assert.Equal(1, manifest.Circuits[0].AccountCount)
+	written, err := ReadSetupManifest(keyDir)
+	assert.NoError(err)
+	assert.Equal(manifest, written)
+
+	// proofs generated with the key directory have the verification key set up ahead of time, on every run
+	cache, err := NewCircuitCache(0)
+	assert.NoError(err)
+	SetCircuitCache(cache)
+	assert.NoError(SetSetupKeyDir(keyDir))
+	defer SetSetupKeyDir("")
+	elements := ProofElements{Accounts: testData0.Accounts[:2]}
+	assetSum := circuit.SumGoAccountBalances(elements.Accounts)
+	elements.AssetSum = &assetSum
+	for run := 0; run < 2; run++ {
+		cache.Clear()
+		proof := generateProof(elements, nil)
+		assert.NoError(verifyGeneratedProof(proof, bottomLevelLayer.name))
+		fingerprint, err := ComputeVerificationKeyFingerprint(proof.VerificationKey)
+		assert.NoError(err)
+		assert.Equal(manifest.Circuits[1].VerificationKeyFingerprint, fingerprint)
+	}
+
+	// circuits without keys, and tampered keys, fail instead of being set up again
+	cache.Clear()
+	elements = ProofElements{Accounts: testData0.Accounts[:3]}
+	assetSum = circuit.SumGoAccountBalances(elements.Accounts)
+	elements.AssetSum = &assetSum
+	assert.Panics(func() { generateProof(elements, nil) })
+	pkPath := filepath.Join(keyDir, manifest.Circuits[0].ProvingKeyFile)
+	pk, err := os.ReadFile(pkPath)
+	assert.NoError(err)
+	pk[len(pk)/2] ^= 1
+	assert.NoError(os.WriteFile(pkPath, pk, 0o644))
+	cache.Clear()
+	elements = ProofElements{Accounts: testData0.Accounts[:1]}
+	assetSum = circuit.SumGoAccountBalances(elements.Accounts)
+	elements.AssetSum = &assetSum
+	assert.Panics(func() { generateProof(elements, nil) })
+
+	// upper level proofs have circuits of their own, listed after the bottom level circuit of as many accounts
+	manifest, err = Setup(nil, []int{2}, keyDir)
+	assert.NoError(err)
+	assert.Equal(3, len(manifest.Circuits))
+	assert.False(manifest.Circuits[1].UpperLevel)
+	assert.True(manifest.Circuits[2].UpperLevel)
+	assert.NotEqual(manifest.Circuits[1].CircuitId, manifest.Circuits[2].CircuitId)
+
+	assert.Error(SetSetupKeyDir(t.TempDir()))
+	_, err = Setup(nil, nil, keyDir)
+	assert.Error(err)
+	_, err = Setup([]int{circuit.GetAccountsPerBatch() + 1}, nil, keyDir)
+	assert.Error(err)
+}
@@ -0,0 +1,176 @@
+package core
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"bitgo.com/proof_of_reserves/circuit"
+)
+
+// signJson encodes value as JSON and signs the encoding in the given context with the given key. Returns the base64
+// encoded payload and the hex encoded public key and signature.
+func signJson(context SigningContext, value interface{}, privateKey ed25519.PrivateKey) (payload string, publicKey string, signature string) {
+	encoded, err := json.Marshal(value)
+	panicOnError(err, "error encoding signed payload")
+	return base64.StdEncoding.EncodeToString(encoded),
+		hex.EncodeToString(privateKey.Public().(ed25519.PublicKey)),
+		hex.EncodeToString(ed25519.Sign(privateKey, context.Message(encoded)))
+}
+
+// signedArtifactNames returns the paths of the files of a round with the given layer plan that are signed: the files
+// listed in its manifest (see manifestFileNames), followed by the manifest.
+func signedArtifactNames(plan LayerPlan, outDir string) []string {
+	return append(manifestFileNames(plan, outDir), ROUND_MANIFEST_FILE)
+}
+
+// signArtifacts signs each published file of the round in outDir with the given layer plan with the signer, and writes
+// the signatures to SIGNATURES_FILE.
+func signArtifacts(plan LayerPlan, outDir string, roundId string, signer crypto.Signer) error {
+	publicKey, ok := signer.Public().(ed25519.PublicKey)
+	if !ok {
+		return fmt.Errorf("artifacts must be signed with an ed25519 key, found %T", signer.Public())
+	}
+	signatures := ArtifactSignatures{RoundId: roundId, PublicKey: hex.EncodeToString(publicKey)}
+	for _, name := range signedArtifactNames(plan, outDir) {
+		data, err := readFile(outDir + name)
+		if err != nil {
+			return err
+		}
+		// ed25519 signs the message itself rather than a digest of it, which binds the file's name too
+		signature, err := signer.Sign(rand.Reader, SIGNING_CONTEXT_ROUND_ARTIFACT.Message([]byte(name), data), crypto.Hash(0))
+		if err != nil {
+			return fmt.Errorf("error signing %s: %w", name, err)
+		}
+		signatures.Signatures = append(signatures.Signatures, ArtifactSignature{Name: name, Signature: hex.EncodeToString(signature)})
+	}
+	return writeJson(outDir+SIGNATURES_FILE, signatures)
+}
+
+// SignRound signs each published file of the round in outDir (its proofs, published verification keys, provenance
+// statement, round metadata, and manifest) with the given signer, e.g. once the round is moved to where the signing key
+// is available, and writes the signatures to SIGNATURES_FILE. See Options.ArtifactSigner.
+func SignRound(batchCount int, outDir string, signer crypto.Signer) {
+	metadata := readRoundMetadata(outDir)
+	plan := roundLayerPlan(batchCount, metadata, circuit.GetConfig())
+	panicOnError(plan.Validate(), "invalid number of batches")
+	panicOnError(verifyLayerPlanMatchesMetadata(plan, metadata), "layer plan verification failed")
+	panicOnError(signArtifacts(plan, outDir, metadata.RoundId, signer), "error signing round")
+}
+
+// readSignatures reads the signatures of the round in outDir, and checks they are made with one of the trusted public
+// keys. Returns the signatures and the decoded public key they are made with.
+func readSignatures(outDir string, trustedPublicKeys []ed25519.PublicKey) (ArtifactSignatures, ed25519.PublicKey, error) {
+	var signatures ArtifactSignatures
+	if err := readJson(outDir+SIGNATURES_FILE, &signatures); err != nil {
+		return ArtifactSignatures{}, nil, fmt.Errorf("error reading signatures: %w", err)
+	}
+	publicKey, err := hex.DecodeString(signatures.PublicKey)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return ArtifactSignatures{}, nil, fmt.Errorf("invalid signing public key")
+	}
+	trusted := false
+	for _, trustedPublicKey := range trustedPublicKeys {
+		if bytes.Equal(publicKey, trustedPublicKey) {
+			trusted = true
+		}
+	}
+	if !trusted {
+		return ArtifactSignatures{}, nil, fmt.Errorf("round is signed by untrusted key %s", signatures.PublicKey)
+	}
+	return signatures, publicKey, nil
+}
+
+// readSignedArtifact reads the signed file of the round in outDir once, and verifies its signature over the bytes read.
+// Returns those bytes, so callers check and decode exactly what was signed.
+func readSignedArtifact(outDir string, artifact ArtifactSignature, publicKey ed25519.PublicKey) ([]byte, error) {
+	data, err := readFile(outDir + artifact.Name)
+	if err != nil {
+		return nil, fmt.Errorf("error reading signed file: %w", err)
+	}
+	signature, err := hex.DecodeString(artifact.Signature)
+	if err != nil || !ed25519.Verify(publicKey, SIGNING_CONTEXT_ROUND_ARTIFACT.Message([]byte(artifact.Name), data), signature) {
+		return nil, fmt.Errorf("signature verification of %s failed", artifact.Name)
+	}
+	return data, nil
+}
+
+// decodeSignedManifest decodes the manifest from its signed bytes, and checks it is of the round of the signatures.
+func decodeSignedManifest(data []byte, signatures ArtifactSignatures) (RoundManifest, error) {
+	var manifest RoundManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return RoundManifest{}, fmt.Errorf("error reading round manifest: %w", err)
+	}
+	if manifest.RoundId != signatures.RoundId {
+		return RoundManifest{}, fmt.Errorf("signatures are of round %s, but the manifest is of round %s", signatures.RoundId, manifest.RoundId)
+	}
+	return manifest, nil
+}
+
+// VerifySignatures verifies that every published file of the round in outDir listed in its manifest, and the manifest
+// itself, is signed by one of the trusted public keys, and that each file matches the checksum the manifest records
+// for it. Each file is read once, so the checksums are computed over the bytes whose signature was verified. It only
+// needs the public data. Returns the verified manifest.
+func VerifySignatures(outDir string, trustedPublicKeys []ed25519.PublicKey) (RoundManifest, error) {
+	signatures, publicKey, err := readSignatures(outDir, trustedPublicKeys)
+	if err != nil {
+		return RoundManifest{}, err
+	}
+
+	signed := make(map[string]ResourceDescriptor)
+	var manifestData []byte
+	for _, artifact := range signatures.Signatures {
+		data, err := readSignedArtifact(outDir, artifact, publicKey)
+		if err != nil {
+			return RoundManifest{}, err
+		}
+		signed[artifact.Name] = describeData(artifact.Name, data)
+		if artifact.Name == ROUND_MANIFEST_FILE {
+			manifestData = data
+		}
+	}
+
+	// the signed manifest vouches for the rest of the round
+	if manifestData == nil {
+		return RoundManifest{}, fmt.Errorf("manifest is not signed")
+	}
+	manifest, err := decodeSignedManifest(manifestData, signatures)
+	if err != nil {
+		return RoundManifest{}, err
+	}
+	for _, file := range manifest.Files {
+		descriptor, ok := signed[file.Name]
+		if !ok {
+			return RoundManifest{}, fmt.Errorf("%s is not signed", file.Name)
+		}
+		if descriptor.Digest[PROVENANCE_DIGEST_ALGORITHM] != file.Digest[PROVENANCE_DIGEST_ALGORITHM] {
+			return RoundManifest{}, fmt.Errorf("checksum of %s does not match the checksum recorded in the manifest", file.Name)
+		}
+	}
+	return manifest, nil
+}
+
+// VerifyManifestSignature verifies that the manifest of the round in outDir is signed by one of the trusted public
+// keys, without reading the rest of the round. Returns the manifest decoded from the bytes whose signature was
+// verified, whose checksums can then be trusted for the files of the round.
+func VerifyManifestSignature(outDir string, trustedPublicKeys []ed25519.PublicKey) (RoundManifest, error) {
+	signatures, publicKey, err := readSignatures(outDir, trustedPublicKeys)
+	if err != nil {
+		return RoundManifest{}, err
+	}
+	index := slices.IndexFunc(signatures.Signatures, func(artifact ArtifactSignature) bool { return artifact.Name == ROUND_MANIFEST_FILE })
+	if index < 0 {
+		return RoundManifest{}, fmt.Errorf("manifest is not signed")
+	}
+	data, err := readSignedArtifact(outDir, signatures.Signatures[index], publicKey)
+	if err != nil {
+		return RoundManifest{}, err
+	}
+	return decodeSignedManifest(data, signatures)
+}
@@ -0,0 +1,78 @@
+package core
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"os"
+	"testing"
+
+	"bitgo.com/proof_of_reserves/verification"
+	"github.com/consensys/gnark/test"
+)
+
+func TestVerifySignatures(t *testing.T) {
+	assert := test.NewAssert(t)
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(err)
+	otherPublicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(err)
+
+	// a signed round verifies with the key it was signed with only
+	roundDir := copyPublicRound(t, testOutDir, t.TempDir(), "signed")
+	_, err = VerifySignatures(roundDir, []ed25519.PublicKey{publicKey})
+	assert.Error(err, "an unsigned round should not verify")
+	SignRound(batchCount, roundDir, privateKey)
+	manifest, err := VerifySignatures(roundDir, []ed25519.PublicKey{otherPublicKey, publicKey})
+	assert.NoError(err)
+	assert.Equal(readRoundMetadata(testOutDir).RoundId, manifest.RoundId)
+	_, err = VerifySignatures(roundDir, []ed25519.PublicKey{otherPublicKey})
+	assert.Error(err)
+
+	// rounds can only be signed with ed25519 keys
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(err)
+	assert.Panics(func() { SignRound(batchCount, roundDir, ecdsaKey) })
+
+	t.Run("Missing signature", func(t *testing.T) {
+		roundDir := copyPublicRound(t, roundDir, t.TempDir(), "missing")
+		var signatures ArtifactSignatures
+		assert.NoError(readJson(roundDir+SIGNATURES_FILE, &signatures))
+		signatures.Signatures = signatures.Signatures[1:]
+		assert.NoError(writeJson(roundDir+SIGNATURES_FILE, signatures))
+		_, err := VerifySignatures(roundDir, []ed25519.PublicKey{publicKey})
+		assert.Error(err)
+	})
+
+	t.Run("Modified file", func(t *testing.T) {
+		roundDir := copyPublicRound(t, roundDir, t.TempDir(), "modified")
+		WriteDataToFile(roundDir+TOP_PROOF_PREFIX+"0.json", altProofTop)
+		_, err := VerifySignatures(roundDir, []ed25519.PublicKey{publicKey})
+		assert.Error(err)
+	})
+
+	t.Run("Swapped manifest", func(t *testing.T) {
+		roundDir := copyPublicRound(t, roundDir, t.TempDir(), "swapped")
+		manifest, err := os.ReadFile(testAltDir + ROUND_MANIFEST_FILE)
+		assert.NoError(err)
+		assert.NoError(os.WriteFile(roundDir+ROUND_MANIFEST_FILE, manifest, 0o644))
+		_, err = VerifySignatures(roundDir, []ed25519.PublicKey{publicKey})
+		assert.Error(err)
+	})
+}
+
+func TestSigningContexts(t *testing.T) {
+	assert := test.NewAssert(t)
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(err)
+
+	// a payload signed in one context only verifies in that context, even with the same key
+	value := map[string]int{"Batch": 0}
+	payload, signingKey, signature := signJson(SIGNING_CONTEXT_BATCH_RECEIPT, value, privateKey)
+	var decoded map[string]int
+	assert.NoError(verification.VerifySignedJson("batch receipt", SIGNING_CONTEXT_BATCH_RECEIPT, payload, signingKey, signature, []ed25519.PublicKey{publicKey}, &decoded))
+	assert.Equal(value, decoded)
+	assert.Error(verification.VerifySignedJson("key bundle", SIGNING_CONTEXT_KEY_BUNDLE, payload, signingKey, signature, []ed25519.PublicKey{publicKey}, &decoded))
+	assert.Error(verification.VerifySignedJson("release manifest", SIGNING_CONTEXT_RELEASE_MANIFEST, payload, signingKey, signature, []ed25519.PublicKey{publicKey}, &decoded))
+}
@@ -0,0 +1,99 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+
+	"bitgo.com/proof_of_reserves/circuit"
+)
+
+// SplitAccountsIntoBatches reads the accounts of a snapshot from the JSON file at inputPath (an array of accounts in
+// the format of the accounts of a batch, compressed or not, see openFile) and writes them to the secret directory of
+// the round directory of outDir (see Options.OutputLayout) as batches of up to ACCOUNTS_PER_BATCH accounts, in the format Prove reads them in. The accounts are sorted
+// by WalletId, so the batches are the same whatever order the accounts were exported in, and accounts without a salt
+// get a random one (see circuit.SaltAccounts). Every account must have a balance for every asset, no negative
+// balance, and a WalletId of its own: with Options.AggregateUserAccounts, the accounts of a user are kept in the same batch
+// instead, and count as one account. Each batch is then checked like the prover checks it (see
+// circuit.ValidateWitnessInputs), and nothing is written if an account is invalid. Returns the number of batches,
+// which is needed to prove them.
+func SplitAccountsIntoBatches(inputPath string, outDir string) (batchCount int, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("splitting accounts into batches failed: %v", r)
+		}
+	}()
+
+	options := GetOptions()
+	var rawAccounts []circuit.RawGoAccount
+	if err := readJson(inputPath, &rawAccounts); err != nil {
+		return 0, fmt.Errorf("error reading accounts: %w", err)
+	}
+	if len(rawAccounts) == 0 {
+		return 0, fmt.Errorf("no accounts in %s", inputPath)
+	}
+	accounts := circuit.ConvertRawGoAccountsToGoAccounts(rawAccounts)
+	slices.SortStableFunc(accounts, func(a, b circuit.GoAccount) int {
+		return bytes.Compare(a.WalletId, b.WalletId)
+	})
+
+	users, err := groupAccountsByUser(accounts)
+	if err != nil {
+		return 0, err
+	}
+	batches := make([][]circuit.GoAccount, 0)
+	for i := 0; i < len(users); i += circuit.GetAccountsPerBatch() {
+		batch := make([]circuit.GoAccount, 0)
+		for _, userAccounts := range users[i:min(i+circuit.GetAccountsPerBatch(), len(users))] {
+			batch = append(batch, userAccounts...)
+		}
+		batches = append(batches, batch)
+	}
+	if err := NewLayerPlan(len(batches)).Validate(); err != nil {
+		return 0, fmt.Errorf("too many accounts: %w", err)
+	}
+
+	// every batch is checked the way the prover checks it before any is written
+	proofElements := make([]ProofElements, len(batches))
+	for i, batch := range batches {
+		circuit.SaltAccounts(batch)
+		assetSum := circuit.SumGoAccountBalances(batch)
+		leafAccounts := batch
+		if options.AggregateUserAccounts {
+			leafAccounts = circuit.AggregateAccountsByUserId(batch)
+		}
+		if err := circuit.ValidateWitnessInputs(leafAccounts, assetSum); err != nil {
+			return 0, fmt.Errorf("invalid accounts in batch %d: %w", i, err)
+		}
+		proofElements[i] = ProofElements{Accounts: batch, AssetSum: &assetSum}
+	}
+	outDir = options.OutputLayout.RoundDir(outDir)
+	if err := createRoundDirectories(outDir); err != nil {
+		return 0, err
+	}
+	for i := range proofElements {
+		WriteDataToFile(outDir+options.OutputLayout.secretDataFile(i), proofElements[i])
+	}
+	return len(batches), nil
+}
+
+// groupAccountsByUser checks the given accounts, sorted by WalletId, and groups the accounts of each user together.
+// Every user has a single account unless Options.AggregateUserAccounts is set.
+// Returns an error identifying the first invalid account
+func groupAccountsByUser(accounts []circuit.GoAccount) ([][]circuit.GoAccount, error) {
+	users := make([][]circuit.GoAccount, 0, len(accounts))
+	for i, account := range accounts {
+		if _, err := circuit.SumGoAccountBalancesWithError([]circuit.GoAccount{account}); err != nil {
+			return nil, fmt.Errorf("invalid account %s: %w", circuit.ConvertGoAccountToRawGoAccount(account).WalletId, err)
+		}
+		if i > 0 && bytes.Equal(account.WalletId, accounts[i-1].WalletId) {
+			if !GetOptions().AggregateUserAccounts {
+				return nil, fmt.Errorf("account %s appears more than once", circuit.ConvertGoAccountToRawGoAccount(account).WalletId)
+			}
+			users[len(users)-1] = append(users[len(users)-1], account)
+			continue
+		}
+		users = append(users, []circuit.GoAccount{account})
+	}
+	return users, nil
+}
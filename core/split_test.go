@@ -0,0 +1,62 @@
+package core
+
+import (
+	"bytes"
+	"math/big"
+	"slices"
+	"strconv"
+	"testing"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"github.com/consensys/gnark/test"
+)
+
+func TestSplitAccountsIntoBatches(t *testing.T) {
+	assert := test.NewAssert(t)
+	config := circuit.GetConfig()
+	assert.NoError(circuit.SetConfig(circuit.Config{TreeDepth: 2}))
+	defer func() { assert.NoError(circuit.SetConfig(config)) }()
+
+	// 10 accounts in batches of 4 give two full batches and a partial batch
+	dir := t.TempDir() + "/"
+	accounts := testData0.Accounts[:10]
+	assert.NoError(writeJson(dir+"accounts.json", circuit.ConvertGoAccountsToRawGoAccounts(accounts)))
+	batchCount, err := SplitAccountsIntoBatches(dir+"accounts.json", dir)
+	assert.NoError(err)
+	assert.Equal(3, batchCount)
+
+	// the batches have every account, sorted by WalletId, with the asset sum of their accounts
+	var batchedAccounts []circuit.GoAccount
+	for i := 0; i < batchCount; i++ {
+		batch := ReadDataFromFile[ProofElements](dir + SECRET_DATA_PREFIX + strconv.Itoa(i) + ".json")
+		assert.Equal([]int{4, 4, 2}[i], len(batch.Accounts))
+		assert.True(batch.AssetSum.Equals(circuit.SumGoAccountBalances(batch.Accounts)))
+		batchedAccounts = append(batchedAccounts, batch.Accounts...)
+	}
+	sortedAccounts := slices.Clone(accounts)
+	slices.SortFunc(sortedAccounts, func(a, b circuit.GoAccount) int { return bytes.Compare(a.WalletId, b.WalletId) })
+	assert.Equal(sortedAccounts, batchedAccounts)
+
+	// the accounts of a user are kept in the same batch with AggregateUserAccounts, and rejected otherwise
+	duplicated := append(slices.Clone(accounts), circuit.GoAccount{WalletId: sortedAccounts[3].WalletId, Balance: sortedAccounts[3].Balance})
+	assert.NoError(writeJson(dir+"duplicated.json", circuit.ConvertGoAccountsToRawGoAccounts(duplicated)))
+	_, err = SplitAccountsIntoBatches(dir+"duplicated.json", t.TempDir()+"/")
+	assert.Error(err)
+	setOptions(t, func(options *Options) { options.AggregateUserAccounts = true })
+	batchCount, err = SplitAccountsIntoBatches(dir+"duplicated.json", dir)
+	assert.NoError(err)
+	assert.Equal(3, batchCount)
+	assert.Equal(5, len(ReadDataFromFile[ProofElements](dir+SECRET_DATA_PREFIX+"0.json").Accounts))
+
+	// accounts with negative balances are rejected
+	negative := slices.Clone(accounts)
+	negative[0].Balance = circuit.ConstructGoBalance()
+	negative[0].Balance[0] = big.NewInt(-1)
+	assert.NoError(writeJson(dir+"negative.json", circuit.ConvertGoAccountsToRawGoAccounts(negative)))
+	_, err = SplitAccountsIntoBatches(dir+"negative.json", t.TempDir()+"/")
+	assert.Error(err)
+
+	// missing files are reported
+	_, err = SplitAccountsIntoBatches(dir+"missing.json", dir)
+	assert.Error(err)
+}
@@ -0,0 +1,467 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// Storage stores the files of rounds, e.g. in a local directory or in a bucket of an object store. Files are identified
+// by keys, which are their paths in the storage with "/" separators.
+type Storage interface {
+	// Get opens the file with the given key, or returns an error wrapping fs.ErrNotExist if there is none.
+	Get(key string) (io.ReadCloser, error)
+	// Put writes the file with the given key, replacing the previous file if there is one, so readers find either the
+	// previous file or the complete new one.
+	Put(key string, data []byte) error
+	// List returns the keys of the files whose key starts with prefix, in lexical order.
+	List(prefix string) ([]string, error)
+}
+
+// RegisterStorageScheme sets the storage of a URI scheme the files of rounds can be read from and written to, e.g. by
+// giving Prove or VerifyFull the output directory 's3://bucket/prefix/': open returns the storage of the bucket of a
+// URI of the scheme (see storageOf), which is the host of HTTPS URIs. The storages of STORAGE_SCHEME_S3,
+// STORAGE_SCHEME_GCS, and STORAGE_SCHEME_HTTPS are registered by default, and can be replaced, e.g. by storages with
+// other credentials. Paths without a scheme are local paths.
+func RegisterStorageScheme(scheme string, open func(bucket string) (Storage, error)) {
+	updateSettings(func(s *settings) error {
+		storageSchemes := make(map[string]func(bucket string) (Storage, error), len(s.storageSchemes)+1)
+		for registered, open := range s.storageSchemes {
+			storageSchemes[registered] = open
+		}
+		storageSchemes[scheme] = open
+		s.storageSchemes = storageSchemes
+		return nil
+	})
+}
+
+// OpenStorage returns the storage of a URI of a registered scheme (e.g. 's3://bucket/prefix', see
+// RegisterStorageScheme) or of a local path, and the prefix of the keys of the files under it in the storage.
+func OpenStorage(uri string) (Storage, string, error) {
+	storage, key, err := storageOf(uri)
+	if err != nil || storage != nil {
+		return storage, key, err
+	}
+	return LocalStorage{}, filepath.ToSlash(uri), nil
+}
+
+// storageOf returns the storage of the file at filePath, if it is a URI of a registered scheme (e.g.
+// 's3://bucket/prefix/public/manifest.json'), and the key of the file in it, or nil if filePath is a local path.
+func storageOf(filePath string) (Storage, string, error) {
+	scheme, location, ok := strings.Cut(filePath, "://")
+	if !ok {
+		return nil, "", nil
+	}
+	newStorage, ok := getSettings().storageSchemes[scheme]
+	if !ok {
+		return nil, "", fmt.Errorf("unknown storage scheme %q of %s", scheme, filePath)
+	}
+	bucket, key, _ := strings.Cut(location, "/")
+	if bucket == "" {
+		return nil, "", fmt.Errorf("no bucket in %s", filePath)
+	}
+	storage, err := newStorage(bucket)
+	if err != nil {
+		return nil, "", fmt.Errorf("error opening storage of %s: %w", filePath, err)
+	}
+	return storage, key, nil
+}
+
+// isStorageURI reports whether the path is a URI of a storage (see storageOf) rather than a local path.
+func isStorageURI(filePath string) bool {
+	return strings.Contains(filePath, "://")
+}
+
+// openStoredFile opens the file at filePath, in its storage if it is a URI (see storageOf), as it is stored.
+func openStoredFile(filePath string) (io.ReadCloser, error) {
+	storage, key, err := storageOf(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if storage != nil {
+		return storage.Get(key)
+	}
+	return os.Open(filePath)
+}
+
+// storedFileExists reports whether the file at filePath exists, in its storage if it is a URI (see storageOf).
+func storedFileExists(filePath string) bool {
+	storage, key, err := storageOf(filePath)
+	if err != nil {
+		return false
+	}
+	if storage == nil {
+		_, err := os.Stat(filePath)
+		return err == nil
+	}
+	keys, err := storage.List(key)
+	if err != nil {
+		return false
+	}
+	i := sort.SearchStrings(keys, key)
+	return i < len(keys) && keys[i] == key
+}
+
+// timeouts of storageHTTPClient
+const (
+	// STORAGE_RESPONSE_TIMEOUT is how long a storage has to respond to a request once it is sent.
+	STORAGE_RESPONSE_TIMEOUT = time.Minute
+	// STORAGE_REQUEST_TIMEOUT is how long a request may take as a whole, including reading the response, e.g. the
+	// download of a large batch.
+	STORAGE_REQUEST_TIMEOUT = 15 * time.Minute
+)
+
+// storageHTTPClient is the HTTP client of the storages that are not given one. A storage that stops responding fails
+// the request once it times out instead of hanging the prover or the verifier: connecting and the TLS handshake time
+// out as with http.DefaultTransport, and the response and the request as a whole after STORAGE_RESPONSE_TIMEOUT and
+// STORAGE_REQUEST_TIMEOUT.
+var storageHTTPClient = func() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = STORAGE_RESPONSE_TIMEOUT
+	return &http.Client{Transport: transport, Timeout: STORAGE_REQUEST_TIMEOUT}
+}()
+
+// storageClient returns the client requests to a storage are made with: client, or storageHTTPClient if it is nil.
+func storageClient(client *http.Client) *http.Client {
+	if client == nil {
+		return storageHTTPClient
+	}
+	return client
+}
+
+// LocalStorage stores files in the local filesystem, with keys that are paths (relative to the working directory, or
+// absolute).
+type LocalStorage struct{}
+
+func (LocalStorage) Get(key string) (io.ReadCloser, error) {
+	return os.Open(filepath.FromSlash(key))
+}
+
+// Put writes the file atomically, like writeFile.
+func (LocalStorage) Put(key string, data []byte) error {
+	return writeLocalFile(filepath.FromSlash(key), func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// List walks the directory of prefix for the files under it (a missing directory has no files).
+func (LocalStorage) List(prefix string) ([]string, error) {
+	root := filepath.Dir(filepath.FromSlash(prefix + "x"))
+	var keys []string
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		key := filepath.ToSlash(path)
+		if root == "." {
+			key = strings.TrimPrefix(key, "./")
+		}
+		if !entry.IsDir() && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	sort.Strings(keys)
+	return keys, err
+}
+
+// S3Storage stores files as the objects of a bucket of Amazon S3, or of an S3 compatible object store, through the
+// client of the AWS SDK, which signs its requests.
+type S3Storage struct {
+	Bucket string
+	// Client is the S3 client requests are made with (see NewS3StorageFromEnv).
+	Client *s3.Client
+}
+
+// NewS3StorageFromEnv returns the S3 storage of the bucket, with the default configuration of the AWS SDK: the region,
+// the endpoint, and the credentials come from the usual AWS environment variables (e.g. $AWS_REGION,
+// $AWS_ENDPOINT_URL_S3 or $AWS_ENDPOINT_URL, $AWS_ACCESS_KEY_ID, $AWS_SECRET_ACCESS_KEY, and $AWS_SESSION_TOKEN), the
+// shared configuration files, or the instance or container role, and temporary credentials are refreshed as they
+// expire. The region defaults to $AWS_DEFAULT_REGION, then to S3_DEFAULT_REGION, and buckets behind an endpoint other
+// than that of Amazon S3 (e.g. of an S3 compatible store) are addressed in the path of requests.
+func NewS3StorageFromEnv(bucket string) (Storage, error) {
+	// the SDK builds its own client (e.g. to trust $AWS_CA_BUNDLE), with the timeouts of storageHTTPClient
+	httpClient := awshttp.NewBuildableClient().WithTimeout(STORAGE_REQUEST_TIMEOUT).WithTransportOptions(func(transport *http.Transport) {
+		transport.ResponseHeaderTimeout = STORAGE_RESPONSE_TIMEOUT
+	})
+	awsConfig, err := config.LoadDefaultConfig(context.Background(), config.WithHTTPClient(httpClient))
+	if err != nil {
+		return nil, fmt.Errorf("error loading the AWS configuration: %w", err)
+	}
+	if awsConfig.Region == "" {
+		awsConfig.Region = firstEnv("AWS_DEFAULT_REGION")
+	}
+	if awsConfig.Region == "" {
+		awsConfig.Region = S3_DEFAULT_REGION
+	}
+	client := s3.NewFromConfig(awsConfig, func(options *s3.Options) {
+		options.UsePathStyle = options.BaseEndpoint != nil
+	})
+	return S3Storage{Bucket: bucket, Client: client}, nil
+}
+
+func (s S3Storage) Get(key string) (io.ReadCloser, error) {
+	output, err := s.Client.GetObject(context.Background(), &s3.GetObjectInput{Bucket: &s.Bucket, Key: &key})
+	if err != nil {
+		return nil, s.error(key, err)
+	}
+	return output.Body, nil
+}
+
+func (s S3Storage) Put(key string, data []byte) error {
+	_, err := s.Client.PutObject(context.Background(), &s3.PutObjectInput{Bucket: &s.Bucket, Key: &key, Body: bytes.NewReader(data)})
+	if err != nil {
+		return s.error(key, err)
+	}
+	return nil
+}
+
+// List lists the objects with ListObjectsV2, a page at a time.
+func (s S3Storage) List(prefix string) ([]string, error) {
+	var keys []string
+	pages := s3.NewListObjectsV2Paginator(s.Client, &s3.ListObjectsV2Input{Bucket: &s.Bucket, Prefix: &prefix})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(context.Background())
+		if err != nil {
+			return nil, s.error(prefix, err)
+		}
+		for _, object := range page.Contents {
+			keys = append(keys, aws.ToString(object.Key))
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// error returns the error of a request for the object with the given key, wrapping fs.ErrNotExist if S3 responded
+// with a 404.
+func (s S3Storage) error(key string, err error) error {
+	var response interface{ HTTPStatusCode() int }
+	if errors.As(err, &response) && response.HTTPStatusCode() == http.StatusNotFound {
+		return fmt.Errorf("s3://%s/%s: %w", s.Bucket, key, fs.ErrNotExist)
+	}
+	return fmt.Errorf("s3://%s/%s: %w", s.Bucket, key, err)
+}
+
+// GCSStorage stores files as the objects of a bucket of Google Cloud Storage, with the JSON API.
+type GCSStorage struct {
+	Bucket string
+	// Endpoint is the URL of the API (defaults to https://storage.googleapis.com), e.g. of an emulator.
+	Endpoint string
+	// TokenSource gives the OAuth 2.0 access tokens requests are authorized with, or is nil for unauthorized requests,
+	// e.g. to an emulator. It is asked for a token on every request, so it should reuse its token until it expires and
+	// then refresh it, as the token sources of application default credentials do (see NewGCSStorageFromEnv).
+	TokenSource oauth2.TokenSource
+	// Client is the HTTP client requests are made with (defaults to storageHTTPClient, which has timeouts).
+	Client *http.Client
+}
+
+// NewGCSStorageFromEnv returns the Google Cloud Storage storage of the bucket, authorized with the application default
+// credentials of Google Cloud: the credentials file at $GOOGLE_APPLICATION_CREDENTIALS, those of 'gcloud auth
+// application-default login', or the service account of the instance, whose access tokens are refreshed as they
+// expire. It uses the emulator at $STORAGE_EMULATOR_HOST if it is set, without credentials if there are none.
+func NewGCSStorageFromEnv(bucket string) (Storage, error) {
+	endpoint := os.Getenv("STORAGE_EMULATOR_HOST")
+	if endpoint != "" && !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+	// tokens are refreshed with the timeouts of the requests to the storage
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, storageHTTPClient)
+	credentials, err := google.FindDefaultCredentials(ctx, GCS_SCOPE)
+	if err != nil {
+		if endpoint != "" {
+			return GCSStorage{Bucket: bucket, Endpoint: endpoint}, nil
+		}
+		return nil, fmt.Errorf("error finding the application default credentials of Google Cloud: %w", err)
+	}
+	return GCSStorage{Bucket: bucket, Endpoint: endpoint, TokenSource: credentials.TokenSource}, nil
+}
+
+func (s GCSStorage) Get(key string) (io.ReadCloser, error) {
+	response, err := s.do(http.MethodGet, "/storage/v1/b/"+url.PathEscape(s.Bucket)+"/o/"+url.PathEscape(key), url.Values{"alt": {"media"}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := storageResponseError(response, "gs://"+s.Bucket+"/"+key); err != nil {
+		return nil, err
+	}
+	return response.Body, nil
+}
+
+// Put uploads the object with a simple upload.
+func (s GCSStorage) Put(key string, data []byte) error {
+	query := url.Values{"uploadType": {"media"}, "name": {key}}
+	response, err := s.do(http.MethodPost, "/upload/storage/v1/b/"+url.PathEscape(s.Bucket)+"/o", query, data)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	return storageResponseError(response, "gs://"+s.Bucket+"/"+key)
+}
+
+// List lists the objects a page at a time.
+func (s GCSStorage) List(prefix string) ([]string, error) {
+	var keys []string
+	pageToken := ""
+	for {
+		query := url.Values{"prefix": {prefix}, "fields": {"items(name),nextPageToken"}}
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+		response, err := s.do(http.MethodGet, "/storage/v1/b/"+url.PathEscape(s.Bucket)+"/o", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Items []struct {
+				Name string `json:"name"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		err = storageResponseError(response, "gs://"+s.Bucket+"/"+prefix)
+		if err == nil {
+			err = json.NewDecoder(response.Body).Decode(&result)
+		}
+		response.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range result.Items {
+			keys = append(keys, item.Name)
+		}
+		if result.NextPageToken == "" {
+			break
+		}
+		pageToken = result.NextPageToken
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// do makes a request to the API at the given (escaped) path, authorized with a token of the token source if it has
+// one.
+func (s GCSStorage) do(method string, escapedPath string, query url.Values, body []byte) (*http.Response, error) {
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = GCS_DEFAULT_ENDPOINT
+	}
+	request, err := http.NewRequest(method, strings.TrimSuffix(endpoint, "/")+escapedPath+"?"+query.Encode(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/octet-stream")
+	}
+	if s.TokenSource != nil {
+		token, err := s.TokenSource.Token()
+		if err != nil {
+			return nil, fmt.Errorf("error getting an access token for gs://%s: %w", s.Bucket, err)
+		}
+		token.SetAuthHeader(request)
+	}
+	return storageClient(s.Client).Do(request)
+}
+
+// HTTPSStorage reads files published on a website, e.g. the proofs of a round or user verification packages
+// downloaded by a verifier, with keys that are the paths of their URLs on the host. Files can't be written to it.
+type HTTPSStorage struct {
+	// Host is the host of the URLs of the files, with its port if it isn't the default one.
+	Host string
+	// Client is the HTTP client requests are made with (defaults to storageHTTPClient, which has timeouts).
+	Client *http.Client
+}
+
+// NewHTTPSStorage returns the storage of the files published on the host.
+func NewHTTPSStorage(host string) (Storage, error) {
+	return HTTPSStorage{Host: host}, nil
+}
+
+func (s HTTPSStorage) Get(key string) (io.ReadCloser, error) {
+	response, err := s.do(http.MethodGet, key)
+	if err != nil {
+		return nil, err
+	}
+	if err := storageResponseError(response, s.uri(key)); err != nil {
+		return nil, err
+	}
+	return response.Body, nil
+}
+
+func (s HTTPSStorage) Put(key string, data []byte) error {
+	return fmt.Errorf("%s: files can't be written to websites", s.uri(key))
+}
+
+// List can't list the files of a website, so it only finds the file whose key is prefix, if there is one.
+func (s HTTPSStorage) List(prefix string) ([]string, error) {
+	response, err := s.do(http.MethodHead, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if err := storageResponseError(response, s.uri(prefix)); errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	response.Body.Close()
+	return []string{prefix}, nil
+}
+
+// uri returns the URL of the file with the given key.
+func (s HTTPSStorage) uri(key string) string {
+	return STORAGE_SCHEME_HTTPS + "://" + s.Host + "/" + key
+}
+
+// do makes a request for the file with the given key.
+func (s HTTPSStorage) do(method string, key string) (*http.Response, error) {
+	request, err := http.NewRequest(method, s.uri(key), nil)
+	if err != nil {
+		return nil, err
+	}
+	return storageClient(s.Client).Do(request)
+}
+
+// storageResponseError returns an error if the response of a storage API for the file at uri does not have a 2xx
+// status, wrapping fs.ErrNotExist for a 404, after closing its body. Returns nil otherwise, leaving the body open.
+func storageResponseError(response *http.Response, uri string) error {
+	if response.StatusCode >= 200 && response.StatusCode <= 299 {
+		return nil
+	}
+	message, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+	response.Body.Close()
+	if response.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%s: %w", uri, fs.ErrNotExist)
+	}
+	return fmt.Errorf("%s: storage responded with status %s: %s", uri, response.Status, strings.TrimSpace(string(message)))
+}
+
+// firstEnv returns the value of the first of the environment variables that is set and not empty.
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
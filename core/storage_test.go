@@ -0,0 +1,273 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/consensys/gnark/test"
+)
+
+// fakeObjectStore is an in-memory object store serving the parts of the S3 API used by S3Storage, which checks the
+// signature of every request with the signer of the AWS SDK.
+type fakeObjectStore struct {
+	mutex       sync.Mutex
+	objects     map[string][]byte
+	bucket      string
+	region      string
+	credentials aws.Credentials
+	t           *testing.T
+}
+
+func (store *fakeObjectStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	body, _ := io.ReadAll(r.Body)
+
+	// the request must be signed with the credentials of the store, over the headers it says it signed and its body
+	date, err := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		http.Error(w, "missing date", http.StatusForbidden)
+		return
+	}
+	payloadHash := sha256.Sum256(body)
+	if r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(payloadHash[:]) {
+		store.t.Errorf("invalid payload hash of %s %s", r.Method, r.URL)
+		http.Error(w, "invalid payload hash", http.StatusForbidden)
+		return
+	}
+	_, signedHeaders, _ := strings.Cut(r.Header.Get("Authorization"), "SignedHeaders=")
+	signedHeaders, _, _ = strings.Cut(signedHeaders, ",")
+	signed := r.Clone(r.Context())
+	signed.URL.Host = r.Host
+	signed.Header = make(http.Header)
+	for _, name := range strings.Split(signedHeaders, ";") {
+		if values := r.Header.Values(name); len(values) > 0 {
+			signed.Header[http.CanonicalHeaderKey(name)] = values
+		}
+	}
+	signer := v4.NewSigner(func(options *v4.SignerOptions) {
+		// S3 signs paths as they are sent, without escaping them again
+		options.DisableURIPathEscaping = true
+	})
+	err = signer.SignHTTP(r.Context(), store.credentials, signed, hex.EncodeToString(payloadHash[:]), "s3", store.region, date)
+	if err != nil || signed.Header.Get("Authorization") != r.Header.Get("Authorization") {
+		store.t.Errorf("invalid signature of %s %s", r.Method, r.URL)
+		http.Error(w, "invalid signature", http.StatusForbidden)
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != store.bucket {
+		http.Error(w, "no such bucket", http.StatusNotFound)
+		return
+	}
+	switch {
+	case r.Method == http.MethodPut:
+		store.objects[key] = body
+	case key == "" && r.URL.Query().Get("list-type") == "2":
+		var keys []string
+		for objectKey := range store.objects {
+			if strings.HasPrefix(objectKey, r.URL.Query().Get("prefix")) {
+				keys = append(keys, objectKey)
+			}
+		}
+		sort.Strings(keys)
+		io.WriteString(w, "<ListBucketResult>")
+		for _, objectKey := range keys {
+			io.WriteString(w, "<Contents><Key>"+objectKey+"</Key></Contents>")
+		}
+		io.WriteString(w, "<IsTruncated>false</IsTruncated></ListBucketResult>")
+	case r.Method == http.MethodGet:
+		object, ok := store.objects[key]
+		if !ok {
+			http.Error(w, "no such key", http.StatusNotFound)
+			return
+		}
+		w.Write(object)
+	default:
+		http.Error(w, "unsupported request", http.StatusBadRequest)
+	}
+}
+
+func TestProveWithS3Storage(t *testing.T) {
+	assert := test.NewAssert(t)
+	config := circuit.GetConfig()
+	assert.NoError(circuit.SetConfig(circuit.Config{TreeDepth: 2}))
+	defer func() { assert.NoError(circuit.SetConfig(config)) }()
+
+	store := &fakeObjectStore{
+		objects:     make(map[string][]byte),
+		bucket:      "proofs",
+		region:      "eu-west-1",
+		credentials: aws.Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret", SessionToken: "session"},
+		t:           t,
+	}
+	server := httptest.NewServer(store)
+	defer server.Close()
+	t.Setenv("AWS_CONFIG_FILE", os.DevNull)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", os.DevNull)
+	t.Setenv("AWS_ENDPOINT_URL", server.URL)
+	t.Setenv("AWS_REGION", store.region)
+	t.Setenv("AWS_ACCESS_KEY_ID", store.credentials.AccessKeyID)
+	t.Setenv("AWS_SECRET_ACCESS_KEY", store.credentials.SecretAccessKey)
+	t.Setenv("AWS_SESSION_TOKEN", store.credentials.SessionToken)
+	storage, err := NewS3StorageFromEnv("proofs")
+	assert.NoError(err)
+
+	// a round is generated, proven, and verified in the bucket like in a local directory
+	outDir := "s3://proofs/rounds/2024 01/"
+	GenerateData(2, 4, outDir)
+	Prove(2, outDir)
+	assert.NotPanics(func() { VerifyFull(2, outDir) })
+	_, ok := store.objects["rounds/2024 01/"+ROUND_MANIFEST_FILE]
+	assert.True(ok)
+	assert.True(fileExists(outDir + GetOptions().OutputLayout.bottomProofPrefix() + "1.json"))
+	assert.False(fileExists(outDir + GetOptions().OutputLayout.bottomProofPrefix() + "2.json"))
+
+	// missing files are reported as such, and tampered proofs fail verification
+	_, err = storage.Get("rounds/2024 01/missing.json")
+	assert.ErrorIs(err, fs.ErrNotExist)
+	store.objects["rounds/2024 01/"+GetOptions().OutputLayout.topProofPrefix()+"0.json"] = store.objects["rounds/2024 01/"+GetOptions().OutputLayout.midProofPrefix()+"0.json"]
+	assert.Panics(func() { VerifyFull(2, outDir) })
+
+	// unknown schemes are rejected
+	_, _, err = OpenStorage("ftp://proofs/rounds/")
+	assert.Error(err)
+}
+
+func TestStorageClientTimeouts(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// a storage that stops responding fails the request instead of hanging
+	assert.Equal(STORAGE_REQUEST_TIMEOUT, storageClient(nil).Timeout)
+	assert.Equal(STORAGE_RESPONSE_TIMEOUT, storageClient(nil).Transport.(*http.Transport).ResponseHeaderTimeout)
+	client := &http.Client{}
+	assert.Equal(client, storageClient(client))
+}
+
+func TestGCSStorage(t *testing.T) {
+	assert := test.NewAssert(t)
+	objects := make(map[string][]byte)
+	tokens := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the refresh token of the credentials is exchanged for access tokens which expire at once, so the storage
+		// must refresh its token for every request
+		if r.URL.Path == "/token" {
+			assert.NoError(r.ParseForm())
+			assert.Equal("refresh", r.PostForm.Get("refresh_token"))
+			tokens++
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"Bearer","expires_in":1}`, tokens)
+			return
+		}
+		if r.Header.Get("Authorization") != fmt.Sprintf("Bearer token-%d", tokens) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/upload/storage/v1/b/proofs/o":
+			objects[r.URL.Query().Get("name")], _ = io.ReadAll(r.Body)
+		case r.URL.Path == "/storage/v1/b/proofs/o":
+			var names []string
+			for name := range objects {
+				if strings.HasPrefix(name, r.URL.Query().Get("prefix")) {
+					names = append(names, `{"name":"`+name+`"}`)
+				}
+			}
+			io.WriteString(w, `{"items":[`+strings.Join(names, ",")+`]}`)
+		case strings.HasPrefix(r.URL.Path, "/storage/v1/b/proofs/o/") && r.URL.Query().Get("alt") == "media":
+			object, ok := objects[strings.TrimPrefix(r.URL.Path, "/storage/v1/b/proofs/o/")]
+			if !ok {
+				http.Error(w, "not found", http.StatusNotFound)
+				return
+			}
+			w.Write(object)
+		default:
+			http.Error(w, "unsupported request", http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+	t.Setenv("STORAGE_EMULATOR_HOST", strings.TrimPrefix(server.URL, "http://"))
+	credentialsFile := t.TempDir() + "/credentials.json"
+	assert.NoError(os.WriteFile(credentialsFile, []byte(`{"type":"authorized_user","client_id":"client","client_secret":"secret",`+
+		`"refresh_token":"refresh","token_uri":"`+server.URL+`/token"}`), 0o600))
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", credentialsFile)
+
+	// files are written compressed by their extension and read back through the storage of their URI
+	batch := ProofElements{Accounts: testData0.Accounts[:2], MerkleRoot: []byte{1, 2, 3}}
+	assert.NoError(writeJson("gs://proofs/round/secret/batch_0.json.gz", ConvertProofElementsToRawProofElements(batch)))
+	assert.Equal(batch, ReadDataFromFile[ProofElements]("gs://proofs/round/secret/batch_0.json"))
+	assert.True(fileExists("gs://proofs/round/secret/batch_0.json"))
+	assert.False(fileExists("gs://proofs/round/secret/batch_1.json"))
+
+	storage, prefix, err := OpenStorage("gs://proofs/round/")
+	assert.NoError(err)
+	assert.Equal("round/", prefix)
+	keys, err := storage.List(prefix)
+	assert.NoError(err)
+	assert.Equal([]string{"round/secret/batch_0.json.gz"}, keys)
+	assert.Greater(tokens, 1)
+}
+
+func TestHTTPSStorage(t *testing.T) {
+	assert := test.NewAssert(t)
+	roundDir := t.TempDir() + "/"
+	assert.NoError(os.CopyFS(roundDir+"public", os.DirFS(testOutDir+"public")))
+	elements := BuildUserVerificationElements(testData0.Accounts[0], 0, proofLower0, proofMid, proofTop)
+	assert.NoError(os.Mkdir(roundDir+"users", 0o755))
+	WriteDataToFile(roundDir+"users/user_0.json", elements)
+	server := httptest.NewTLSServer(http.FileServer(http.Dir(roundDir)))
+	defer server.Close()
+	RegisterStorageScheme(STORAGE_SCHEME_HTTPS, func(host string) (Storage, error) {
+		return HTTPSStorage{Host: host, Client: server.Client()}, nil
+	})
+	defer RegisterStorageScheme(STORAGE_SCHEME_HTTPS, NewHTTPSStorage)
+	roundURL := server.URL + "/"
+
+	// packages are read from their URL as from a file, and checked against the manifest of the published round
+	downloaded := ReadDataFromFile[UserVerificationElements](roundURL + "users/user_0.json")
+	assert.Equal(ReadDataFromFile[UserVerificationElements](roundDir+"users/user_0.json"), downloaded)
+	assert.NoError(VerifyUserWithError(downloaded))
+	manifestDigest, err := describeFile(roundDir, ROUND_MANIFEST_FILE)
+	assert.NoError(err)
+	digest := manifestDigest.Digest[PROVENANCE_DIGEST_ALGORITHM]
+	assert.NoError(VerifyUserAgainstManifest(downloaded, roundURL, nil, digest))
+	assert.True(fileExists(roundURL + ROUND_MANIFEST_FILE))
+	assert.False(fileExists(roundURL + "users/user_1.json"))
+	assert.Error(writeJson(roundURL+"users/user_1.json", elements))
+
+	// the package of another round, and a published top level proof that doesn't match the manifest, fail
+	altElements := downloaded
+	altElements.ProofInfo.TopProof = altProofTop
+	assert.Error(VerifyUserAgainstManifest(altElements, roundURL, nil, digest))
+	var manifest RoundManifest
+	assert.NoError(readJson(roundDir+ROUND_MANIFEST_FILE, &manifest))
+	for i := range manifest.Files {
+		manifest.Files[i].Digest[PROVENANCE_DIGEST_ALGORITHM] = strings.Repeat("0", 64)
+	}
+	assert.NoError(writeJson(roundDir+ROUND_MANIFEST_FILE, manifest))
+	err = VerifyUserAgainstManifest(downloaded, roundURL, nil, digest)
+	assert.ErrorContains(err, "does not match the pinned digest")
+	tamperedDigest, err := describeFile(roundDir, ROUND_MANIFEST_FILE)
+	assert.NoError(err)
+	err = VerifyUserAgainstManifest(downloaded, roundURL, nil, tamperedDigest.Digest[PROVENANCE_DIGEST_ALGORITHM])
+	assert.ErrorContains(err, "does not match the checksum recorded in the manifest")
+
+	// the manifest must be trusted: unsigned manifests without a pinned digest fail
+	err = VerifyUserAgainstManifest(downloaded, roundURL, nil, "")
+	assert.ErrorContains(err, "trusted public key or a pinned digest")
+}
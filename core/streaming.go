@@ -0,0 +1,164 @@
+package core
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"bitgo.com/proof_of_reserves/circuit"
+)
+
+// batchLoader reads the batches of a round from outDir one at a time, prepared to be proven the same way as by
+// prepareBatches, and collects the report entries of the accounts it excludes. Batches may be loaded concurrently.
+type batchLoader struct {
+	outDir     string
+	exclusions []ExcludedAccount
+
+	mutex sync.Mutex
+	// excludedAccounts are the report entries of the accounts excluded from each loaded batch, by batch index.
+	excludedAccounts map[int][]ExcludedAccountReport
+}
+
+// newBatchLoader returns a loader of the batches in outDir, which reads the exclusion list of the round.
+func newBatchLoader(outDir string) *batchLoader {
+	return &batchLoader{outDir: outDir, exclusions: readExclusionList(outDir), excludedAccounts: make(map[int][]ExcludedAccountReport)}
+}
+
+// load reads the batch with the given index and prepares it to be proven (see prepareBatches). Its dummy accounts are
+// new ones unless reuseDummyAccounts is set, in which case those written when it was last loaded are read back.
+func (loader *batchLoader) load(index int, reuseDummyAccounts bool) ProofElements {
+	options := GetOptions()
+	proofElements := []ProofElements{ReadDataFromFile[ProofElements](loader.outDir + options.OutputLayout.secretDataFile(index))}
+	applyAssetSubset(proofElements, circuit.GetAssetSubset())
+	if options.AggregateUserAccounts {
+		applyAccountAggregation(proofElements, circuit.GetConfig())
+	}
+	excludedAccounts := applyExclusionList(proofElements, index, loader.exclusions)
+	if reuseDummyAccounts {
+		readDummyAccountPadding(proofElements, index, options.DummyAccountPadding, loader.outDir)
+	} else {
+		applyDummyAccountPadding(proofElements, index, options.DummyAccountPadding, loader.outDir)
+	}
+
+	loader.mutex.Lock()
+	defer loader.mutex.Unlock()
+	loader.excludedAccounts[index] = excludedAccounts
+	return proofElements[0]
+}
+
+// excludedAccountReport returns the report entries of the accounts excluded from the first batchCount batches, in
+// batch order.
+func (loader *batchLoader) excludedAccountReport(batchCount int) []ExcludedAccountReport {
+	loader.mutex.Lock()
+	defer loader.mutex.Unlock()
+	var report []ExcludedAccountReport
+	for i := 0; i < batchCount; i++ {
+		report = append(report, loader.excludedAccounts[i]...)
+	}
+	return report
+}
+
+// ProveStreaming generates the proofs of the batches in outDir like Prove, but reads, proves, and releases one batch
+// at a time (or one per proof worker, see SetProofWorkers) instead of loading every batch first, so its memory does not
+// grow with the number of batches: each bottom level proof is written as soon as it is generated, and only what the
+// upper level proofs need (its root, asset sum, and proof) is kept. Once the upper level proofs are generated, the
+// bottom level proofs are written again with their merkle paths. If a sparse Merkle tree is built (see
+// Options.BuildSparseTree), the batches are read a second time, in order, to add their accounts to it. Identical batches are
+// proven separately. It is configured with the given options, and rolled back if it fails, like Prove.
+func ProveStreaming(batchCount int, outDir string, opts ...ProverOption) {
+	panicOnStageError(proveStreaming(batchCount, outDir, opts...))
+}
+
+// proveStreaming generates the proofs of a round, see ProveStreaming. Returns a *ProveError if a step of its own fails.
+func proveStreaming(batchCount int, outDir string, opts ...ProverOption) (err error) {
+	options := GetOptions()
+	startedOn := time.Now()
+	outDir = options.OutputLayout.RoundDir(outDir)
+	if err := createRoundDirectories(outDir); err != nil {
+		return &ProveError{Stage: "error creating round directories", Err: err}
+	}
+	settings := newProverSettings(opts)
+	if settings.dryRun {
+		proveDryRun(batchCount, outDir)
+		return nil
+	}
+	roundId := newRoundId()
+	defer beginProverAudit(outDir, roundId).end(&err)
+	defer beginRun(outDir, roundId).end(&err)
+	plan := NewLayerPlan(batchCount)
+	if err := plan.Validate(); err != nil {
+		return &ProveError{Stage: "invalid number of batches", Err: err}
+	}
+	if err := options.ProofPublicationPolicy.Validate(); err != nil {
+		return &ProveError{Stage: "invalid publication policy", Err: err}
+	}
+	if err := validateProofBackendConfig(); err != nil {
+		return &ProveError{Stage: "invalid configuration for the proof backend", Err: err}
+	}
+	migration, err := readRoundMigration(outDir, plan)
+	if err != nil {
+		return &ProveError{Stage: "invalid tree depth migration", Err: err}
+	}
+	progress := newProgressReporter(settings.progress, batchCount+plan.UpperLevelProofCount())
+
+	// prove each batch as soon as it is read, and only keep what the upper levels need
+	loader := newBatchLoader(outDir)
+	bottomLevelProofs := make([]CompletedProof, batchCount)
+	batches := make([]int, batchCount)
+	for i := range batches {
+		batches[i] = i
+	}
+	err = proveInParallel(context.Background(), batches, func(i int) {
+		proofElements := loader.load(i, false)
+		panicOnError(verifyDistinctWalletIds(proofElements.Accounts), "duplicate accounts in batch "+strconv.Itoa(i))
+		proof := generateProof(proofElements, progress.forBatch(bottomLevelLayer.name, i))
+		proof.RoundId = roundId
+		writeProof(proof, outDir, bottomLevelLayer, i)
+		bottomLevelProofs[i] = retainForUpperLevels(proof)
+	})
+	if err != nil {
+		return &ProveError{Stage: "error generating proofs", Err: err}
+	}
+
+	// the accounts are added to the sparse tree in batch order, with the dummy accounts the batches were proven with
+	sparseTree := newSparseTree()
+	if sparseTree != nil {
+		for i := 0; i < batchCount; i++ {
+			addToSparseTree(sparseTree, []ProofElements{loader.load(i, true)}, i)
+		}
+	}
+
+	upperLevelProofs := generateUpperLevelProofs(bottomLevelProofs, plan, progress)
+	writeBottomLevelMerklePaths(bottomLevelProofs, outDir)
+	writeRound(outDir, roundId, "prove-streaming", plan, migration, upperLevelProofs, loader.exclusions, loader.excludedAccountReport(batchCount), sparseTreeRoot(sparseTree), startedOn)
+	return nil
+}
+
+// retainForUpperLevels returns the fields of a bottom level proof that the upper level proofs need (including the
+// proof itself, which upper level proofs verify with BACKEND_GROTH16_RECURSIVE), so the rest can be released once it is
+// written.
+func retainForUpperLevels(proof CompletedProof) CompletedProof {
+	return CompletedProof{
+		Proof:                      proof.Proof,
+		VerificationKey:            proof.VerificationKey,
+		MerkleRoot:                 proof.MerkleRoot,
+		MerkleRootWithAssetSumHash: proof.MerkleRootWithAssetSumHash,
+		AssetSum:                   proof.AssetSum,
+		Backend:                    proof.Backend,
+		AccountCount:               proof.AccountCount,
+		Epoch:                      proof.Epoch,
+	}
+}
+
+// writeBottomLevelMerklePaths adds the merkle paths of the retained bottom level proofs (see retainForUpperLevels),
+// which are only known once the upper level proofs are generated, to the written proofs.
+func writeBottomLevelMerklePaths(bottomLevelProofs []CompletedProof, outDir string) {
+	for i, proof := range bottomLevelProofs {
+		writtenProof := readBottomLevelProof(outDir, i)
+		writtenProof.AssetSum = proof.AssetSum
+		writtenProof.MerklePath = proof.MerklePath
+		writtenProof.MerklePosition = proof.MerklePosition
+		writeProof(writtenProof, outDir, bottomLevelLayer, i)
+	}
+}
@@ -0,0 +1,45 @@
+package core
+
+import (
+	"os"
+	"testing"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"github.com/consensys/gnark/test"
+)
+
+func TestProveStreaming(t *testing.T) {
+	assert := test.NewAssert(t)
+	outDir := t.TempDir() + "/"
+	assert.NoError(os.MkdirAll(outDir+"secret", 0o755))
+	assert.NoError(os.MkdirAll(outDir+"public", 0o755))
+
+	// exclude an account of the second batch (and a user with no account in the round), and pad the batches with dummy
+	// accounts
+	GenerateData(3, 4, outDir)
+	excludedWalletId := circuit.ConvertGoAccountToRawGoAccount(ReadDataFromFile[ProofElements](outDir + SECRET_DATA_PREFIX + "1.json").Accounts[2]).WalletId
+	exclusions := []ExcludedAccount{{WalletId: excludedWalletId, Reason: "frozen"}, {WalletId: "closed-account", Reason: "closed"}}
+	assert.NoError(writeJson(outDir+EXCLUSION_LIST_FILE, exclusions))
+	setOptions(t, func(options *Options) {
+		options.DummyAccountPadding = 6
+		options.BuildSparseTree = true
+	})
+	assert.NoError(SetProofWorkers(2))
+	defer func() { assert.NoError(SetProofWorkers(1)) }()
+
+	// the round is proven one batch at a time, and verifies like a round proven by Prove
+	ProveStreaming(3, outDir)
+	for i := 0; i < 3; i++ {
+		proof := readBottomLevelProof(outDir, i)
+		assert.Equal(6, *proof.AccountCount)
+		assert.Equal(circuit.GetTreeDepth(), len(proof.MerklePath))
+		assert.Equal(i, proof.MerklePosition)
+	}
+	var report RoundReport
+	assert.NoError(readJson(outDir+ROUND_REPORT_FILE, &report))
+	assert.Equal([]ExcludedAccountReport{{WalletId: excludedWalletId, Reason: "frozen", Batch: 1}}, report.ExcludedAccounts)
+	assert.Equal(1, readRoundMetadata(outDir).ExcludedAccountCount, "only the accounts actually removed are counted")
+	assert.NotNil(readRoundMetadata(outDir).SparseTreeRoot)
+	assert.NotPanics(func() { VerifyFull(3, outDir) })
+	assert.NotPanics(func() { VerifyFullStreaming(3, outDir) })
+}
@@ -0,0 +1,200 @@
+package core
+
+import (
+	"fmt"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"bitgo.com/proof_of_reserves/verification"
+)
+
+// VerifyFullStreaming verifies a round like VerifyFull, but reads its batches one at a time: the accounts of a batch
+// and its bottom level proof are verified together, and only the running asset sum is kept once they are, so the
+// memory needed doesn't grow with the number of batches (the proofs of the levels above the bottom level, which are
+// far fewer, are kept). The parameters of each bottom level proof must be those of the upper level proofs. A round
+// with an exclusion list is read twice, as the excluded accounts of every batch must be known before the leaves of
+// the first bottom level proof are checked, and a round with a sparse Merkle tree keeps the tree of its accounts. Users
+// checked across batches with a set are kept too (see Options.CrossBatchDuplicateUsers), while with a bloom filter, only the
+// filter is kept, and the batches are read again if it finds users that may be in more than one.
+func VerifyFullStreaming(batchCount int, outDir string) {
+	checkRoundStreaming(&verificationChecks{}, batchCount, outDir)
+}
+
+// checkRoundStreaming runs the checks of VerifyFullStreaming with the given checks. The checks the others depend on end
+// verification if they fail.
+func checkRoundStreaming(checks *verificationChecks, batchCount int, outDir string) {
+	options := GetOptions()
+	outDir = options.OutputLayout.RoundDir(outDir)
+
+	// use the tree depth the top level proof was generated with, which the layer plan depends on
+	topLevelProof := readPublishedProof(outDir, topLevelLayer, 0)
+	treeDepth, err := verification.ProofsTreeDepth([]CompletedProof{topLevelProof})
+	if !checks.check(err, "tree depth verification failed", roundFailure(VERIFY_CHECK_PROOF_PARAMETERS)) {
+		return
+	}
+	config := circuit.GetConfig()
+	config.TreeDepth = treeDepth
+	metadata := readRoundMetadata(outDir)
+	plan := roundLayerPlan(batchCount, metadata, config)
+	if !checks.check(plan.Validate(), "invalid number of batches", roundFailure(VERIFY_CHECK_ROUND_METADATA)) ||
+		!checks.check(verifyLayerPlanMatchesMetadata(plan, metadata), "layer plan verification failed", roundFailure(VERIFY_CHECK_ROUND_METADATA)) ||
+		!checks.check(verifyTreeDepth(metadata, config), "tree depth verification failed", roundFailure(VERIFY_CHECK_ROUND_METADATA)) {
+		return
+	}
+
+	// read the proofs above the bottom level, which are verified first, and use the configuration they were generated
+	// with
+	levelProofs := append(append([][]CompletedProof{nil}, readMidLevelProofs(plan, outDir)...), []CompletedProof{topLevelProof})
+	upperProofs := make([]CompletedProof, 0)
+	for level, proofs := range levelProofs[1:] {
+		checks.check(verification.VerifyProofsBelongToRound(metadata.RoundId, levelLayer(level+1, plan.Levels()).name, proofs), "round id verification failed", roundFailure(VERIFY_CHECK_ROUND_ID))
+		upperProofs = append(upperProofs, proofs...)
+	}
+	_, err = verification.ProofsEpoch(upperProofs)
+	checks.check(err, "epoch verification failed", roundFailure(VERIFY_CHECK_PROOF_PARAMETERS))
+	config, assetSubset, ok := checkRoundProofsParameters(checks, upperProofs, metadata)
+	if !ok {
+		return
+	}
+
+	exclusions := readExclusionList(outDir)
+	checks.check(verifyDeploymentDomain(metadata), "deployment domain verification failed", roundFailure(VERIFY_CHECK_ROUND_METADATA))
+	checks.check(verifyExclusionListMatchesMetadata(exclusions, metadata), "exclusion list verification failed", roundFailure(VERIFY_CHECK_ROUND_METADATA))
+	checks.check(verifyProvenance(batchCount, outDir, metadata, true, config), "provenance verification failed", roundFailure(VERIFY_CHECK_PROVENANCE))
+	checks.check(verifyManifest(batchCount, outDir, metadata, topLevelProof, config), "manifest verification failed", roundFailure(VERIFY_CHECK_MANIFEST))
+	checks.check(verifyAssetSumSummary(outDir, topLevelProof, assetSubset), "asset sum summary verification failed", roundFailure(VERIFY_CHECK_ASSET_SUM_SUMMARY))
+	checks.check(verifySuccinctRoundProof(batchCount, outDir, metadata, topLevelProof), "succinct round proof verification failed", roundFailure(VERIFY_CHECK_SUCCINCT_PROOF))
+	checkUpperLevelProofs(checks, plan, levelProofs, config)
+
+	// collect the leaf hashes of the excluded accounts of every batch before checking any bottom level proof
+	var excludedHashes map[string]bool
+	excludedAccountCount := 0
+	if len(exclusions) > 0 {
+		excludedHashes = make(map[string]bool)
+		for i := 0; i < batchCount; i++ {
+			batch := readStreamedBatch(outDir, i, metadata, assetSubset, config)[0].Accounts
+			excludedAccountCount += addExcludedAccountHashes(excludedHashes, batch, exclusions, config)
+		}
+	}
+	checks.check(verifyExcludedAccountCount(excludedAccountCount, metadata), "exclusion list verification failed", roundFailure(VERIFY_CHECK_ROUND_METADATA))
+	var sparseTree *circuit.SparseMerkleTree
+	var sparseTreeErr error
+	if metadata.SparseTreeRoot != nil {
+		sparseTree, sparseTreeErr = circuit.NewSparseMerkleTreeWithConfig(config)
+	}
+
+	// verify the batches one at a time, keeping the sum of their accounts (and their users, if checked across batches,
+	// see Options.CrossBatchDuplicateUsers)
+	duplicates := newDuplicateUsers(options.CrossBatchDuplicateUsers, batchCount)
+	accountSum := config.ConstructGoBalance()
+	var accountSumErr error
+	for i := 0; i < batchCount; i++ {
+		bottomProof := readBottomLevelProof(outDir, i)
+		if bottomProof.RoundId != metadata.RoundId {
+			err := fmt.Errorf("%s level proof %d belongs to round %q, but the round is %q (artifacts of different rounds are mixed)", bottomLevelLayer.name, i, bottomProof.RoundId, metadata.RoundId)
+			checks.check(err, "round id verification failed", roundFailure(VERIFY_CHECK_ROUND_ID))
+		}
+		if !checks.check(verifyProofMatchesParameters(bottomProof, upperProofs), fmt.Sprintf("parameters of bottom level proof %d verification failed", i), batchFailure(VERIFY_CHECK_PROOF_PARAMETERS, i)) {
+			continue
+		}
+
+		accounts := readStreamedAccounts(outDir, i, metadata, exclusions, assetSubset, config)
+		if !hasAllMerkleNodes(bottomProof, config) {
+			rebuilt, err := RebuildMerkleNodes(bottomProof, accounts)
+			if checks.check(err, fmt.Sprintf("error rebuilding the merkle nodes of bottom level proof %d", i), batchFailure(VERIFY_CHECK_MERKLE_NODES, i)) {
+				bottomProof = rebuilt
+			}
+		}
+
+		checks.check(
+			verifyAccountCount(bottomProof, len(accounts)),
+			fmt.Sprintf("account count verification failed for bottom level proof %d", i),
+			batchFailure(VERIFY_CHECK_ACCOUNT_COUNT, i),
+		)
+		validMerkleNodes := checkBottomLevelProof(checks, i, bottomProof, levelProofs[1][plan.MidLevelProofIndex(i)], config)
+		checks.check(verifyDistinctWalletIds(accounts), fmt.Sprintf("duplicate accounts in batch %d", i), batchFailure(VERIFY_CHECK_DUPLICATE_ACCOUNTS, i))
+		if validMerkleNodes {
+			checkAccountInclusion(checks, i, accounts, bottomProof, config)
+		}
+		if excludedHashes != nil {
+			checks.check(verifyExcludedHashesNotIncluded(i, bottomProof, excludedHashes, config), "excluded account verification failed", roundFailure(VERIFY_CHECK_EXCLUDED_ACCOUNTS))
+		}
+		if duplicates != nil {
+			duplicates.add(checks, i, accounts)
+		}
+
+		if accountSumErr == nil {
+			var batchSum circuit.GoBalance
+			batchSum, accountSumErr = config.SumGoAccountBalancesWithError(accounts)
+			if accountSumErr == nil {
+				accountSum, accountSumErr = config.AddGoBalances(accountSum, batchSum)
+			}
+		}
+		if sparseTree != nil && sparseTreeErr == nil {
+			if err := sparseTree.AddAccounts(accounts); err != nil {
+				sparseTreeErr = fmt.Errorf("batch %d: %w", i, err)
+			}
+		}
+	}
+
+	// the users the bloom filter may have seen in more than one batch are found by going through the batches again
+	if duplicates != nil && duplicates.needsConfirmation() {
+		for i := 0; i < batchCount; i++ {
+			duplicates.confirm(checks, i, readStreamedAccounts(outDir, i, metadata, exclusions, assetSubset, config))
+		}
+	}
+
+	checkTopLevelAssetSum(checks, topLevelProof, config)
+	switch {
+	case accountSumErr != nil:
+	case topLevelProof.AssetSum == nil:
+		accountSumErr = fmt.Errorf("asset sum is nil")
+	case !config.EqualGoBalances(accountSum, *topLevelProof.AssetSum):
+		accountSumErr = fmt.Errorf("sum of all account balances does not match the asset sum")
+	}
+	checks.check(accountSumErr, "top layer published asset sum does not match the sum of all account balances", proofFailure(VERIFY_CHECK_ASSET_SUM, topLevelLayer, 0))
+	if sparseTree != nil && sparseTreeErr == nil {
+		sparseTreeErr = verifySparseTreeHasRoot(sparseTree, metadata.SparseTreeRoot)
+	}
+	checks.check(sparseTreeErr, "sparse merkle tree verification failed", roundFailure(VERIFY_CHECK_SPARSE_TREE))
+}
+
+// readStreamedBatch reads the batch with the given index of the round, restricted to the assets of the asset subset
+// (and with the accounts of each user merged) the same way the prover did, as the only batch of the returned slice.
+// The config is the configuration the proofs of the round were generated with.
+func readStreamedBatch(outDir string, i int, metadata RoundMetadata, assetSubset []int, config circuit.Config) []ProofElements {
+	proofElements := []ProofElements{ReadDataFromFile[ProofElements](outDir + GetOptions().OutputLayout.secretDataFile(i))}
+	applyAssetSubset(proofElements, assetSubset)
+	if metadata.AggregatedUserAccounts {
+		applyAccountAggregation(proofElements, config)
+	}
+	return proofElements
+}
+
+// readStreamedAccounts reads the accounts of the batch with the given index of the round the same way the prover did
+// (see checkRound): restricted to the asset subset, without the excluded accounts, and with the dummy accounts.
+func readStreamedAccounts(outDir string, i int, metadata RoundMetadata, exclusions []ExcludedAccount, assetSubset []int, config circuit.Config) []circuit.GoAccount {
+	proofElements := readStreamedBatch(outDir, i, metadata, assetSubset, config)
+	applyExclusionList(proofElements, i, exclusions)
+	readDummyAccountPadding(proofElements, i, metadata.DummyAccountPadding, outDir)
+	return proofElements[0].Accounts
+}
+
+// verifyProofMatchesParameters verifies the proof was generated with the parameters of the given proofs, which must
+// agree on them: its epoch, hash function, hash version, account bytes version, leaf hash mode, tree depth, asset
+// registry, and asset subset.
+func verifyProofMatchesParameters(proof CompletedProof, proofs []CompletedProof) error {
+	allProofs := append([]CompletedProof{proof}, proofs...)
+	_, epochErr := verification.ProofsEpoch(allProofs)
+	_, hashFunctionErr := verification.ProofsHashFunction(allProofs)
+	_, hashVersionErr := verification.ProofsHashVersion(allProofs)
+	_, accountBytesVersionErr := verification.ProofsAccountBytesVersion(allProofs)
+	_, leafHashModeErr := verification.ProofsLeafHashMode(allProofs)
+	_, treeDepthErr := verification.ProofsTreeDepth(allProofs)
+	_, assetSubsetErr := verification.ProofsAssetSubset(allProofs)
+	for _, err := range []error{epochErr, hashFunctionErr, hashVersionErr, accountBytesVersionErr, leafHashModeErr, treeDepthErr, verification.VerifyAssetRegistry(allProofs), assetSubsetErr} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
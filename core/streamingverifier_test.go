@@ -0,0 +1,37 @@
+package core
+
+import (
+	"os"
+	"testing"
+
+	"github.com/consensys/gnark/test"
+)
+
+func TestVerifyFullStreaming(t *testing.T) {
+	assert := test.NewAssert(t)
+	assert.NotPanics(func() { VerifyFullStreaming(batchCount, testOutDir) })
+	setOptions(t, func(options *Options) { options.CrossBatchDuplicateUsers = DUPLICATE_USER_CHECK_BLOOM })
+	assert.NotPanics(func() { VerifyFullStreaming(batchCount, testOutDir) })
+	setOptions(t, func(options *Options) { options.CrossBatchDuplicateUsers = DUPLICATE_USER_CHECK_NONE })
+
+	// a round with the first two accounts of its second batch swapped fails the same checks as with VerifyFull
+	outDir := t.TempDir() + "/"
+	assert.NoError(os.CopyFS(outDir, os.DirFS(testOutDir)))
+	batch := ReadDataFromFile[ProofElements](outDir + SECRET_DATA_PREFIX + "1.json")
+	batch.Accounts[0], batch.Accounts[1] = batch.Accounts[1], batch.Accounts[0]
+	WriteDataToFile(outDir+SECRET_DATA_PREFIX+"1.json", batch)
+
+	streamingChecks := &verificationChecks{collect: true}
+	checkRoundStreaming(streamingChecks, batchCount, outDir)
+	checks := &verificationChecks{collect: true}
+	checkRound(checks, batchCount, outDir)
+	assert.ElementsMatch(checks.failures, streamingChecks.failures)
+	inclusionFailures := 0
+	for _, failure := range streamingChecks.failures {
+		if failure.Check == VERIFY_CHECK_ACCOUNT_INCLUSION {
+			assert.Equal(1, *failure.Batch)
+			inclusionFailures++
+		}
+	}
+	assert.Equal(2, inclusionFailures)
+}
@@ -0,0 +1,103 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"bitgo.com/proof_of_reserves/verification"
+)
+
+// writeSuccinctRoundProof writes the succinct proof of the round in outDir (see SuccinctRoundProof) if its top level
+// proof was generated with BACKEND_GROTH16_RECURSIVE, with the published top level proof and round metadata, which
+// must already be written.
+func writeSuccinctRoundProof(outDir string, plan LayerPlan, topLevelProof CompletedProof) {
+	if topLevelProof.Backend != BACKEND_GROTH16_RECURSIVE {
+		return
+	}
+	panicOnError(
+		writeJson(outDir+SUCCINCT_ROUND_PROOF_FILE, RawSuccinctRoundProof{
+			BatchCount:    plan.BottomLevelProofCount,
+			Metadata:      readRoundMetadata(outDir),
+			TopLevelProof: ConvertCompletedProofToRawCompletedProof(readPublishedProof(outDir, topLevelLayer, 0)),
+		}),
+		"error writing succinct round proof",
+	)
+}
+
+// ReadSuccinctRoundProof reads the succinct round proof at the given path (SUCCINCT_ROUND_PROOF_FILE of a round).
+func ReadSuccinctRoundProof(path string) SuccinctRoundProof {
+	var proof RawSuccinctRoundProof
+	panicOnError(readJson(path, &proof), "error reading succinct round proof")
+	return SuccinctRoundProof{
+		BatchCount:    proof.BatchCount,
+		Metadata:      proof.Metadata,
+		TopLevelProof: ConvertRawCompletedProofToCompletedProof(proof.TopLevelProof),
+	}
+}
+
+// VerifySuccinctRoundProof verifies a round from its succinct proof alone: the top level proof attests every proof of
+// the round below it verified (see VerifyTopLevelProof), and must be the top level proof of the round described by the
+// round metadata, with its number of batches. The verification key of the top level proof must be pinned for the top
+// layer with the BACKEND_GROTH16_RECURSIVE backend (see GetPinnedVerificationKeys), as it fixes those of the lower level
+// proofs: a proof verified with the key it comes with, or with the backend it records, attests nothing.
+func VerifySuccinctRoundProof(proof SuccinctRoundProof) error {
+	if _, err := pinnedTopLevelVerificationKey(proof.TopLevelProof); err != nil {
+		return err
+	}
+	topLevelProof, metadata := proof.TopLevelProof, proof.Metadata
+	if topLevelProof.RoundId != metadata.RoundId {
+		return fmt.Errorf("top level proof belongs to round %q, but the round is %q", topLevelProof.RoundId, metadata.RoundId)
+	}
+	if err := verifyDeploymentDomain(metadata); err != nil {
+		return err
+	}
+
+	// the layer plan depends on the tree depth the round was generated with
+	treeDepth, err := verification.ProofsTreeDepth([]CompletedProof{topLevelProof})
+	if err != nil {
+		return err
+	}
+	config := circuit.GetConfig()
+	config.TreeDepth = treeDepth
+	if err := verifyTreeDepth(metadata, config); err != nil {
+		return err
+	}
+	plan := roundLayerPlan(proof.BatchCount, metadata, config)
+	if err := plan.Validate(); err != nil {
+		return err
+	}
+	if err := verifyLayerPlanMatchesMetadata(plan, metadata); err != nil {
+		return err
+	}
+	if err := verifyAccountCount(topLevelProof, plan.LevelProofCount(plan.Levels()-2)); err != nil {
+		return fmt.Errorf("top level proof does not include every proof of the level below: %w", err)
+	}
+	assetSubset, err := verification.ProofsAssetSubset([]CompletedProof{topLevelProof})
+	if err != nil {
+		return err
+	}
+	if err := verifyAssetSubsetMatchesMetadata(assetSubset, metadata); err != nil {
+		return err
+	}
+	return VerifyTopLevelProof(topLevelProof)
+}
+
+// verifySuccinctRoundProof verifies the succinct proof of the round in outDir, if any, is that of the round: its
+// number of batches, round metadata, and top level proof are the round's. Rounds without one pass.
+func verifySuccinctRoundProof(batchCount int, outDir string, metadata RoundMetadata, topLevelProof CompletedProof) error {
+	if !fileExists(outDir + SUCCINCT_ROUND_PROOF_FILE) {
+		return nil
+	}
+	proof := ReadSuccinctRoundProof(outDir + SUCCINCT_ROUND_PROOF_FILE)
+	switch {
+	case proof.BatchCount != batchCount:
+		return fmt.Errorf("succinct round proof has %d batches, expected %d", proof.BatchCount, batchCount)
+	case !reflect.DeepEqual(proof.Metadata, metadata):
+		return errors.New("succinct round proof has other round metadata than the round")
+	case !reflect.DeepEqual(proof.TopLevelProof, topLevelProof):
+		return errors.New("succinct round proof has another top level proof than the round")
+	}
+	return nil
+}
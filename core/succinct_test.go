@@ -0,0 +1,69 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"github.com/consensys/gnark/test"
+)
+
+func TestVerifySuccinctRoundProof(t *testing.T) {
+	assert := test.NewAssert(t)
+	config := circuit.GetConfig()
+	assert.NoError(circuit.SetConfig(circuit.Config{TreeDepth: 2}))
+	defer func() { assert.NoError(circuit.SetConfig(config)) }()
+
+	// rounds whose top level proof does not verify the lower level proofs publish no succinct proof
+	dir := t.TempDir() + "/"
+	GenerateData(2, 4, dir)
+	Prove(2, dir)
+	assert.False(fileExists(dir + SUCCINCT_ROUND_PROOF_FILE))
+
+	// a succinct proof must be the top level proof of its round metadata and number of batches, which must be of the
+	// recursive backend (proving a recursive round needs a setup of millions of constraints, which is too slow for a
+	// test)
+	proof := SuccinctRoundProof{BatchCount: 2, Metadata: readRoundMetadata(dir), TopLevelProof: readPublishedProof(dir, topLevelLayer, 0)}
+	unpinVerificationKeys(t)
+	err := VerifySuccinctRoundProof(proof)
+	assert.Error(err)
+	assert.True(strings.Contains(err.Error(), "no verification keys are pinned"), err.Error())
+	fingerprint, err := ComputeVerificationKeyFingerprint(proof.TopLevelProof.VerificationKey)
+	assert.NoError(err)
+	assert.NoError(PinVerificationKeyFingerprints(topLevelLayer.name, BACKEND_GROTH16, fingerprint))
+	err = VerifySuccinctRoundProof(proof)
+	assert.Error(err)
+	assert.True(strings.Contains(err.Error(), "do not verify their lower level proofs"), err.Error())
+	mislabeledProof := proof
+	mislabeledProof.TopLevelProof.Backend = BACKEND_GROTH16_RECURSIVE
+	err = VerifySuccinctRoundProof(mislabeledProof)
+	assert.Error(err)
+	assert.True(strings.Contains(err.Error(), "do not verify their lower level proofs"), err.Error())
+
+	// with the key pinned for the recursive backend, the proof must be of the round
+	assert.NoError(PinVerificationKeyFingerprints(topLevelLayer.name, BACKEND_GROTH16_RECURSIVE, fingerprint))
+	err = VerifySuccinctRoundProof(proof)
+	assert.Error(err)
+	assert.True(strings.Contains(err.Error(), "pinned verification key is of the groth16-recursive backend"), err.Error())
+	wrongBatchCount := proof
+	wrongBatchCount.BatchCount = 5
+	assert.Error(VerifySuccinctRoundProof(wrongBatchCount))
+	wrongRound := proof
+	wrongRound.Metadata.RoundId = "other"
+	err = VerifySuccinctRoundProof(wrongRound)
+	assert.Error(err)
+	assert.True(strings.Contains(err.Error(), "belongs to round"), err.Error())
+
+	// a published succinct proof is read back as written, and must be that of the round
+	assert.NoError(writeJson(dir+SUCCINCT_ROUND_PROOF_FILE, RawSuccinctRoundProof{
+		BatchCount:    proof.BatchCount,
+		Metadata:      proof.Metadata,
+		TopLevelProof: ConvertCompletedProofToRawCompletedProof(proof.TopLevelProof),
+	}))
+	assert.Equal(proof, ReadSuccinctRoundProof(dir+SUCCINCT_ROUND_PROOF_FILE))
+	assert.NoError(verifySuccinctRoundProof(2, dir, proof.Metadata, proof.TopLevelProof))
+	assert.Error(verifySuccinctRoundProof(3, dir, proof.Metadata, proof.TopLevelProof))
+	assert.Error(verifySuccinctRoundProof(2, dir, wrongRound.Metadata, proof.TopLevelProof))
+	midLevelProof := readPublishedProof(dir, midLevelLayer, 0)
+	assert.Error(verifySuccinctRoundProof(2, dir, proof.Metadata, midLevelProof))
+}
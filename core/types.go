@@ -1,19 +1,27 @@
 package core
 
 import (
+	"time"
+
 	"bitgo.com/proof_of_reserves/circuit"
 	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/backend/plonk"
 	"github.com/consensys/gnark/constraint"
 )
 
 // AccountLeaf is a []byte alias for readability.
 type Hash = circuit.Hash
 
-// PartialProof contains the results of compiling and setting up a circuit.
+// PartialProof contains the results of compiling and setting up a circuit with one of the backends.
 type PartialProof struct {
-	pk groth16.ProvingKey
-	vk groth16.VerifyingKey
-	cs constraint.ConstraintSystem
+	backend ProofBackend
+	// pk and vk are the keys of BACKEND_GROTH16 (and BACKEND_GROTH16_RECURSIVE), plonkPk and plonkVk the keys of
+	// BACKEND_PLONK
+	pk      groth16.ProvingKey
+	vk      groth16.VerifyingKey
+	plonkPk plonk.ProvingKey
+	plonkVk plonk.VerifyingKey
+	cs      constraint.ConstraintSystem
 }
 
 // ProofElements is an input to the prover. It contains sensitive data and should not be published.
@@ -32,89 +40,365 @@ type RawProofElements struct {
 	AssetSum                   *circuit.GoBalance
 	MerkleRoot                 []byte
 	MerkleRootWithAssetSumHash []byte
+	// WalletIdEncoding is the encoding of the WalletIds of the accounts (empty for files that do not record it, which
+	// are read with the configured encoding).
+	WalletIdEncoding circuit.WalletIdEncoding `json:",omitempty"`
 }
 
-// CompletedProof is an output of the prover. It contains the proof, public data, and (optionally) the full list of merkle nodes (hashes).
-// It can be published if it meets the following criteria:
-//  1. If this is a top level proof, the MerklePath are set to nil, MerklePosition is 0, and AssetSum is properly defined.
-//  2. If this is not a top level proof, the MerklePath and MerklePosition are properly defined, and AssetSum is nil.
-//  3. The MerkleNode field has been set to nil (we don't want to publish all the hashes).
-type CompletedProof struct {
-	Proof                      string
-	VerificationKey            string
-	MerkleRoot                 []byte
-	MerkleRootWithAssetSumHash []byte
+// Types for reading and writing raw user verification elements from/to files:
 
-	// MerklePath, MerklePosition, MerkleNodes, AssetSum are optional, depending on the case.
-	MerklePath     []Hash
-	MerklePosition int
-	MerkleNodes    [][]Hash
-	AssetSum       *circuit.GoBalance
+// ExcludedAccount is an entry of the exclusion list, identifying an account (by its raw WalletId) that must be
+// excluded from the liability sum, and the reason it is excluded.
+type ExcludedAccount struct {
+	WalletId string
+	Reason   string
 }
 
-// RawCompletedProof is a raw version of CompletedProof that is read from and written to files.
-type RawCompletedProof struct {
-	Proof                      string
-	VerificationKey            string
-	MerkleRoot                 []byte
-	MerkleRootWithAssetSumHash []byte
-	MerklePath                 []Hash
-	MerklePosition             int
-	MerkleNodes                [][]Hash
-	AssetSum                   *[]string
+// ExcludedAccountReport records an account that was excluded from the proofs and the batch it was removed from.
+type ExcludedAccountReport struct {
+	WalletId string
+	Reason   string
+	Batch    int
 }
 
-// Types for user verification elements:
-type UserProofInfo struct {
-	UserMerklePath     []Hash
-	UserMerklePosition int
-	BottomProof        CompletedProof
-	MiddleProof        CompletedProof
-	TopProof           CompletedProof
+// RoundMetadata is published alongside the proofs and describes how the proofs of a round were generated.
+type RoundMetadata struct {
+	// ExclusionListHash is the hex encoded SHA-256 hash of the exclusion list (empty if there is none).
+	ExclusionListHash string
+	// ExcludedAccountCount is the number of accounts left out of the proofs by the exclusion list, which can differ
+	// from the number of its entries, as an excluded WalletId may match no account of the round.
+	ExcludedAccountCount int
+	// ProvenanceHash is the hex encoded SHA-256 hash of the provenance statement of the round.
+	ProvenanceHash string
+	// DeploymentDomain is the domain mixed into the hashes of the round (see circuit.SetDeploymentDomain).
+	DeploymentDomain string
+	LayerPlan        LayerPlan
+	// TreeDepth is the depth of the Merkle trees of the round (circuit.TREE_DEPTH when it was generated).
+	TreeDepth int
+	// TreeDepthMigration documents how the batches were migrated from the previous tree depth, if they were.
+	TreeDepthMigration *TreeDepthMigration
+	// CircuitVersion is the version of the circuit the round was generated with (0 for rounds generated before it
+	// was recorded, which used version 1).
+	CircuitVersion int
+	// RoundId is the random identifier every artifact of the round is tagged with (empty for rounds generated before
+	// artifacts were tagged).
+	RoundId string
+	// WalletIdEncoding is the encoding of the WalletIds of the round's accounts (empty for rounds generated before it
+	// was recorded, which used base36).
+	WalletIdEncoding circuit.WalletIdEncoding `json:",omitempty"`
+	// AssetSubset are the indices of the assets of the asset registry the round proves the liabilities in (nil for
+	// every asset), which the proofs of the round must record.
+	AssetSubset []int `json:",omitempty"`
+	// AggregatedUserAccounts is set when the accounts of each user in each batch were merged into one account before
+	// proving (see Options.AggregateUserAccounts).
+	AggregatedUserAccounts bool `json:",omitempty"`
+	// SparseTreeRoot is the root of the sparse Merkle tree of the round's accounts, set when it was built alongside the
+	// trees of the batches (see Options.BuildSparseTree).
+	SparseTreeRoot Hash `json:",omitempty"`
+	// DummyAccountPadding is the number of accounts every batch was padded to with dummy accounts (0 if they were not
+	// padded, see Options.DummyAccountPadding).
+	DummyAccountPadding int `json:",omitempty"`
 }
 
-type UserVerificationElements struct {
-	AccountInfo circuit.GoAccount
-	ProofInfo   UserProofInfo
+// NonInclusionProof shows that a user is not in the sparse Merkle tree of a round (see Options.BuildSparseTree), i.e. that
+// none of their accounts are in the liabilities the round proves. See ProveNonInclusion and VerifyNonInclusion.
+type NonInclusionProof struct {
+	// WalletId is the raw WalletId of the user, in the WalletIdEncoding of the round (empty if the round does not
+	// record one).
+	WalletId         string
+	WalletIdEncoding circuit.WalletIdEncoding `json:",omitempty"`
+	RoundId          string
+	// HashFunction and HashVersion are those the nodes of the tree are hashed with, those of the round's proofs.
+	HashFunction circuit.HashFunction `json:",omitempty"`
+	HashVersion  circuit.HashVersion  `json:",omitempty"`
+	// SparseTreePath are the siblings of the path from the zero leaf at the user's key to the root of the tree, from
+	// the leaves up.
+	SparseTreePath []Hash
 }
 
-// Types for reading and writing raw user verification elements from/to files:
-type RawUVBalance struct {
-	Asset  string
-	Amount string
+// ProveProgress is the progress of a round being proven by ProveContext, written to the secret directory after every
+// bottom level proof, so a cancelled round can be resumed.
+type ProveProgress struct {
+	RoundId    string
+	BatchCount int
+	StartedOn  time.Time
+	// ProvenBatches are the hex encoded keys (see computeProofElementsKey) of the batches whose bottom level proof was
+	// written, by batch index, so batches that changed since are proven again.
+	ProvenBatches map[int]string
 }
 
-type RawLowerLevelProof struct {
-	Proof                      string
-	VerificationKey            string
-	MerkleRoot                 []byte
-	MerkleRootWithAssetSumHash []byte
-	MerklePosition             int
-	MerklePath                 []Hash
+// TreeDepthMigration documents a change of tree depth between rounds. It is written to the secret directory by
+// MigrateTreeDepth and recorded in the RoundMetadata of the next round, so verifiers comparing rounds know why
+// batch counts and user positions changed.
+type TreeDepthMigration struct {
+	PreviousTreeDepth  int
+	PreviousBatchCount int
+	TreeDepth          int
+	BatchCount         int
+	Note               string
+	// BatchesDigest is the hex encoded digest of the batches MigrateTreeDepth wrote (see migratedBatchesDigest), so a
+	// migration left from an earlier run is not applied to other batches. Empty for migrations written before it.
+	BatchesDigest string `json:",omitempty"`
 }
 
-type RawTopLevelProof struct {
-	Proof                      string
-	VerificationKey            string
-	MerkleRoot                 []byte
+// UserIndexEntry is the location of an account in the batch files of a round, and in the batch files of the
+// previous round it was migrated from. Positions are positions in the batch files, before excluded accounts are
+// removed.
+type UserIndexEntry struct {
+	WalletId         string
+	Batch            int
+	Position         int
+	PreviousBatch    int
+	PreviousPosition int
+}
+
+// LayerPlan is the number of proofs in each layer of a round. It is computed once from the number of batches with
+// NewLayerPlan, so the prover, verifier, and exporter agree on the size of each layer.
+type LayerPlan struct {
+	BottomLevelProofCount int
+	MidLevelProofCount    int
+	TopLevelProofCount    int
+	// LevelCount is the number of levels of proofs, from the bottom level to the top level (0 for rounds generated
+	// before it was recorded, which have MIN_HIERARCHY_LEVELS). The number of proofs of the intermediate levels between
+	// the mid and top levels follows from the number of mid level proofs (see LevelProofCount).
+	LevelCount int `json:",omitempty"`
+	// accountsPerBatch is the number of proofs of the level below each proof above the bottom level includes, i.e. the
+	// accounts per batch of the tree depth of the round (circuit.GetAccountsPerBatch() if zero, see withConfig). It is
+	// not recorded, as it follows from the tree depth recorded in the proofs.
+	accountsPerBatch int
+}
+
+// SuccinctRoundProof is published with the rounds generated with BACKEND_GROTH16_RECURSIVE, whose top level proof
+// attests every lower level proof verified, so that third parties can verify a round without downloading or verifying
+// any other proof (see VerifySuccinctRoundProof). Its size does not depend on the number of batches of the round.
+type SuccinctRoundProof struct {
+	BatchCount    int
+	Metadata      RoundMetadata
+	TopLevelProof CompletedProof
+}
+
+// RawSuccinctRoundProof is the format SuccinctRoundProof is written in.
+type RawSuccinctRoundProof struct {
+	BatchCount    int
+	Metadata      RoundMetadata
+	TopLevelProof RawCompletedProof
+}
+
+// RoundReport is written to the secret directory and documents the round for internal use. It should not be published.
+type RoundReport struct {
+	RoundId          string
+	ExcludedAccounts []ExcludedAccountReport
+	// LiabilitySum is the AssetSum of the top level proof, in base and display units.
+	LiabilitySum []RawUVBalance `json:",omitempty"`
+}
+
+// ValidationReport is written to the secret directory by a dry run of the prover (see DryRun), and lists every problem
+// found in the batches of the round that would make proving it fail. It should not be published.
+type ValidationReport struct {
+	BatchCount   int
+	AccountCount int
+	// AssetSum is the sum of the asset sums of the batches, in base and display units.
+	AssetSum []RawUVBalance `json:",omitempty"`
+	Batches  []BatchValidation
+	// Problems are the problems found with the round as a whole, e.g. a WalletId in several batches.
+	Problems []string `json:",omitempty"`
+	// Valid is set if no problem was found with the round or any of its batches.
+	Valid bool
+}
+
+// BatchValidation is the validation of a batch in a ValidationReport: the accounts it is proven with, its recomputed
+// merkle root, and the problems found with it.
+type BatchValidation struct {
+	Batch        int
+	AccountCount int
+	MerkleRoot   []byte   `json:",omitempty"`
+	Problems     []string `json:",omitempty"`
+}
+
+// AssetSumSummary discloses the asset sum of a proof as governed by the AssetSumDisclosure of its layer: the sums of
+// the disclosed assets, and the value of the sums of the other assets, aggregated into a single line.
+type AssetSumSummary struct {
+	RoundId string
+	Assets  []RawUVBalance
+	Other   *AggregatedAssetSum `json:",omitempty"`
+}
+
+// AggregatedAssetSum is the value of the sums of the assets of an AssetSumSummary not disclosed individually, at the
+// given prices of a display unit of each asset.
+type AggregatedAssetSum struct {
+	Name   string
+	Assets []string
+	Prices map[string]string
+	Value  string
+}
+
+// ProvenanceStatement is an in-toto statement (https://in-toto.io/Statement/v1) with a SLSA provenance predicate
+// (https://slsa.dev/provenance/v1), documenting how the published proofs of a round were generated.
+type ProvenanceStatement struct {
+	Type          string               `json:"_type"`
+	Subject       []ResourceDescriptor `json:"subject"`
+	PredicateType string               `json:"predicateType"`
+	Predicate     ProvenancePredicate  `json:"predicate"`
+}
+
+// RoundManifest describes a proving run and lists the checksums of the files it published, so a verifier can check
+// the published round is complete and unaltered. It is written last, after every other file of the round.
+type RoundManifest struct {
+	RoundId    string
+	Epoch      uint64 `json:",omitempty"`
+	BatchCount int
+	TreeDepth  int
+	// AssetRegistryHash is the hash of the asset registry the balances of the round are in (see circuit.AssetRegistry).
+	AssetRegistryHash string
+	CircuitVersion    int
+	// Files are the published files of the round (its proofs, published verification keys, provenance statement, and
+	// round metadata) with their SHA-256 digests.
+	Files      []ResourceDescriptor
+	StartedOn  time.Time
+	FinishedOn time.Time
+}
+
+// ArtifactSignatures are the signatures of the published files of a round (see Options.ArtifactSigner).
+type ArtifactSignatures struct {
+	RoundId string
+	// PublicKey is the hex encoded ed25519 public key the files are signed with.
+	PublicKey  string
+	Signatures []ArtifactSignature
+}
+
+// ArtifactSignature is the hex encoded ed25519 signature of the contents of the file at Name, relative to the output
+// directory.
+type ArtifactSignature struct {
+	Name      string
+	Signature string
+}
+
+// ResourceDescriptor identifies a file of the round by its path relative to the output directory and its digests.
+type ResourceDescriptor struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+type ProvenancePredicate struct {
+	BuildDefinition ProvenanceBuildDefinition `json:"buildDefinition"`
+	RunDetails      ProvenanceRunDetails      `json:"runDetails"`
+}
+
+type ProvenanceBuildDefinition struct {
+	BuildType            string                       `json:"buildType"`
+	ExternalParameters   ProvenanceExternalParameters `json:"externalParameters"`
+	InternalParameters   ProvenanceInternalParameters `json:"internalParameters"`
+	ResolvedDependencies []ResourceDescriptor         `json:"resolvedDependencies"`
+}
+
+// ProvenanceExternalParameters are the parameters the proofs were generated with.
+type ProvenanceExternalParameters struct {
+	Command    string `json:"command"`
+	BatchCount int    `json:"batchCount"`
+}
+
+// ProvenanceInternalParameters are the circuit parameters the prover was built with.
+type ProvenanceInternalParameters struct {
+	TreeDepth        int    `json:"treeDepth"`
+	AccountsPerBatch int    `json:"accountsPerBatch"`
+	AssetCount       int    `json:"assetCount"`
+	DeploymentDomain string `json:"deploymentDomain,omitempty"`
+}
+
+type ProvenanceRunDetails struct {
+	Builder  ProvenanceBuilder  `json:"builder"`
+	Metadata ProvenanceMetadata `json:"metadata"`
+}
+
+type ProvenanceBuilder struct {
+	Id      string            `json:"id"`
+	Version map[string]string `json:"version,omitempty"`
+}
+
+type ProvenanceMetadata struct {
+	InvocationId string `json:"invocationId"`
+	StartedOn    string `json:"startedOn"`
+	FinishedOn   string `json:"finishedOn"`
+}
+
+// BatchReceipt is issued to the team that exported a batch, confirming what was proven from it.
+type BatchReceipt struct {
+	// RoundId is the round the batch was proven in.
+	RoundId string
+	// Batch is the index of the batch.
+	Batch int
+	// InputDigest is the hex encoded SHA-256 hash of the batch file the proof was generated from.
+	InputDigest string
+	// AccountCount is the number of accounts in the batch file.
+	AccountCount int
+	// ExcludedAccountCount is the number of accounts of the batch left out of the proof by the exclusion list.
+	ExcludedAccountCount int
+	// MerkleRoot is the merkle root of the bottom level proof of the batch.
+	MerkleRoot []byte
+	// MerkleRootWithAssetSumHash is the hash of the merkle root with the asset subtotal of the batch (and the epoch).
 	MerkleRootWithAssetSumHash []byte
-	AssetSum                   *[]RawUVBalance
+	// Epoch is the epoch the proof of the batch is bound to (zero for none).
+	Epoch            uint64 `json:",omitempty"`
+	DeploymentDomain string
+	IssuedAt         time.Time
+}
+
+// SignedBatchReceipt is a BatchReceipt signed with ed25519, the same way as a SignedKeyBundle.
+type SignedBatchReceipt struct {
+	Payload   string
+	PublicKey string
+	Signature string
+}
+
+// SetupManifest lists the keys of the circuits set up ahead of proving by Setup, in SETUP_MANIFEST_FILE of their key
+// directory, so they can be audited before they are used (see SetSetupKeyDir).
+type SetupManifest struct {
+	Backend        ProofBackend
+	CircuitVersion int
+	Circuits       []SetupCircuit
 }
 
-type RawUserProofInfo struct {
-	UserMerklePath     []Hash
-	UserMerklePosition int
-	BottomProof        RawLowerLevelProof
-	MiddleProof        RawLowerLevelProof
-	TopProof           RawTopLevelProof
+// SetupCircuit records the keys of a circuit set up by Setup.
+type SetupCircuit struct {
+	// CircuitId identifies the circuit: its number of accounts, backend, hash function, hash version, leaf hash mode,
+	// circuit configuration, asset registry and subset, setup seed, and circuit version.
+	CircuitId    string
+	AccountCount int
+	// UpperLevel is whether the circuit is that of upper level proofs (see circuit.Config.UpperLevel).
+	UpperLevel bool `json:",omitempty"`
+	// Constraints and Wires are the size of the compiled circuit, which the circuit compiled by the prover must have.
+	Constraints int
+	Wires       int
+	// ProvingKeyFile and VerificationKeyFile are the names of the key files in the key directory, and ProvingKeyDigest
+	// the hex encoded SHA-256 hash of the proving key. VerificationKeyFingerprint is the fingerprint of the
+	// verification key (see ComputeVerificationKeyFingerprint), which the proofs generated with the keys have.
+	ProvingKeyFile             string
+	ProvingKeyDigest           string
+	VerificationKeyFile        string
+	VerificationKeyFingerprint string
+	// Ceremony records the MPC setup ceremony the keys were imported from (see ImportCeremony), or is nil for keys
+	// set up by Setup.
+	Ceremony *SetupCeremony `json:",omitempty"`
 }
 
-type RawUserAccountInfo struct {
-	WalletId  string
-	Balance []RawUVBalance
+// SetupCeremony records the MPC setup ceremony the keys of a circuit were imported from.
+type SetupCeremony struct {
+	// Phase1Hash is the hex encoded hash of the last contribution to the phase 1 (powers of tau) parameters.
+	Phase1Hash string
+	// ContributionHashes are the hex encoded hashes of the phase 2 contributions, in order, which each participant can
+	// find their own contribution among.
+	ContributionHashes []string
 }
 
-type RawUserVerificationElements struct {
-	AccountInfo RawUserAccountInfo
-	ProofInfo   RawUserProofInfo
+// CeremonyTranscript describes the files of an MPC setup ceremony for the keys of a circuit, in
+// CEREMONY_TRANSCRIPT_FILE of the ceremony directory (see InitCeremony).
+type CeremonyTranscript struct {
+	// CircuitId, AccountCount, and UpperLevel identify the circuit of the ceremony, like SetupCircuit.
+	CircuitId    string
+	AccountCount int
+	UpperLevel   bool `json:",omitempty"`
+	// Phase1 is the name of the file of the phase 1 parameters, and Phase2 the names of the files of the phase 2
+	// parameters, starting with the parameters derived from the circuit and followed by each contribution.
+	Phase1 string
+	Phase2 []string
 }
@@ -1,61 +1,124 @@
 package core
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
 	"encoding/json"
-	"math/big"
-	"os"
+	"fmt"
+	"io"
 	"strconv"
 
 	"bitgo.com/proof_of_reserves/circuit"
+	"bitgo.com/proof_of_reserves/verification"
 )
 
+// ConvertProofToGoAccount converts the proof to the pseudo-account that is its leaf in the next level proof, with
+// MerkleRoot as WalletId, AssetSum as Balance, and the epoch as Salt.
 func ConvertProofToGoAccount(proof CompletedProof) circuit.GoAccount {
 	if proof.AssetSum == nil {
 		panic("AssetSum is nil, cannot convert to GoAccount")
 	}
 	return circuit.GoAccount{
-		WalletId:  proof.MerkleRoot,
-		Balance: *proof.AssetSum,
+		WalletId: proof.MerkleRoot,
+		Balance:  *proof.AssetSum,
+		Salt:     circuit.EpochSalt(verification.EpochOf(proof)),
 	}
 }
 
+// ConvertProofElementsToRawProofElements converts the accounts with the configured WalletId encoding, which is
+// recorded in the RawProofElements.
 func ConvertProofElementsToRawProofElements(p ProofElements) RawProofElements {
 	return RawProofElements{
 		Accounts:                   circuit.ConvertGoAccountsToRawGoAccounts(p.Accounts),
 		AssetSum:                   p.AssetSum,
 		MerkleRoot:                 p.MerkleRoot,
 		MerkleRootWithAssetSumHash: p.MerkleRootWithAssetSumHash,
+		WalletIdEncoding:           circuit.GetWalletIdEncoding(),
 	}
 }
 
+// ConvertRawProofElementsToProofElements converts the accounts with the WalletId encoding recorded in the
+// RawProofElements, or the configured one if none is recorded.
 func ConvertRawProofElementsToProofElements(rp RawProofElements) ProofElements {
 	return ProofElements{
-		Accounts:                   circuit.ConvertRawGoAccountsToGoAccounts(rp.Accounts),
+		Accounts:                   circuit.ConvertRawGoAccountsToGoAccountsWithEncoding(rp.Accounts, verification.RecordedWalletIdEncoding(rp.WalletIdEncoding)),
 		AssetSum:                   rp.AssetSum,
 		MerkleRoot:                 rp.MerkleRoot,
 		MerkleRootWithAssetSumHash: rp.MerkleRootWithAssetSumHash,
 	}
 }
 
+// writeJson writes the data as indented JSON to filePath, compressed if filePath has the extension of a compression
+// (see writeFile).
 func writeJson(filePath string, data interface{}) error {
-	file, err := os.Create(filePath)
+	return writeFile(filePath, func(w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(data)
+	})
+}
+
+// writeCompletedProofStreaming writes a CompletedProof in the same JSON format as writeJson, except without
+// indentation, writing the MerkleNodes hash by hash through a buffer of the given size. Unlike json.Encoder, which
+// encodes the entire proof in memory before writing it, this keeps memory bounded for proofs of deep trees, and
+// blocks on the file whenever the buffer is full.
+func writeCompletedProofStreaming(filePath string, proof CompletedProof, bufferSize int) error {
+	return writeFile(filePath, func(w io.Writer) error {
+		return encodeCompletedProofStreaming(w, proof, bufferSize)
+	})
+}
+
+// encodeCompletedProofStreaming encodes a CompletedProof to the writer for writeCompletedProofStreaming.
+func encodeCompletedProofStreaming(w io.Writer, proof CompletedProof, bufferSize int) error {
+	// encode every field except the merkle nodes, and split the encoding where the merkle nodes belong
+	rawProof := ConvertCompletedProofToRawCompletedProof(proof)
+	rawProof.MerkleNodes = nil
+	encodedProof, err := json.Marshal(rawProof)
 	if err != nil {
 		return err
 	}
-	defer func(file *os.File) {
-		err := file.Close()
-		if err != nil {
-			panic("Couldn't close file" + err.Error())
+	placeholder := []byte(`"MerkleNodes":null`)
+	prefix, suffix, found := bytes.Cut(encodedProof, placeholder)
+	if !found {
+		return fmt.Errorf("MerkleNodes field not found in encoded proof")
+	}
+
+	writer := bufio.NewWriterSize(w, bufferSize)
+	writer.Write(prefix)
+	writer.WriteString(`"MerkleNodes":[`)
+	encodedHash := make([]byte, 0, base64.StdEncoding.EncodedLen(circuit.ModBytes))
+	for i, level := range proof.MerkleNodes {
+		if i > 0 {
+			writer.WriteByte(',')
 		}
-	}(file)
+		writer.WriteByte('[')
+		for j, hash := range level {
+			if j > 0 {
+				writer.WriteByte(',')
+			}
+			encodedHash = base64.StdEncoding.AppendEncode(encodedHash[:0], hash)
+			writer.WriteByte('"')
+			writer.Write(encodedHash)
+			writer.WriteByte('"')
+		}
+		writer.WriteByte(']')
+	}
+	writer.WriteByte(']')
+	writer.Write(suffix)
 
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "  ")
-	return encoder.Encode(data)
+	// bufio.Writer keeps the first write error, so it is enough to check it when flushing
+	writer.WriteByte('\n')
+	return writer.Flush()
 }
 
-func WriteDataToFile[D ProofElements | CompletedProof | circuit.GoAccount](filePath string, data D) {
-	// if writing GoAccount or ProofElements, first convert to corresponding raw data interface
+// WriteDataToFile writes the data to a JSON file, or, for a CompletedProof to a file with the PROOF_BINARY_EXTENSION,
+// to a file in the binary format (see writeCompletedProofBinary).
+func WriteDataToFile[D ProofElements | CompletedProof | circuit.GoAccount | UserVerificationElements](filePath string, data D) {
+	if _, isProof := any(data).(CompletedProof); isBinaryProofFile(filePath) && !isProof {
+		panic("Error writing data to file: only completed proofs can be written in the binary format: " + filePath)
+	}
+	// if writing GoAccount, ProofElements, or UserVerificationElements, first convert to corresponding raw data interface
 	// then write to file
 	switch v := any(data).(type) {
 	case circuit.GoAccount:
@@ -68,32 +131,26 @@ func WriteDataToFile[D ProofElements | CompletedProof | circuit.GoAccount](fileP
 			writeJson(filePath, ConvertProofElementsToRawProofElements(v)),
 			"error writing raw proof elements to file",
 		)
+	case UserVerificationElements:
+		panicOnError(
+			writeJson(filePath, ConvertUserVerificationElementsToRawUserVerificationElements(v)),
+			"error writing raw user verification elements to file",
+		)
 	case CompletedProof:
-		// convert the asset sum to a slice of strings before writing
-		var rawAssetSum *[]string
-		if v.AssetSum != nil {
-			convertedAssetSum := make([]string, len(*v.AssetSum))
-			for i, asset := range *v.AssetSum {
-				convertedAssetSum[i] = asset.String()
-			}
-			rawAssetSum = &convertedAssetSum
-		} else {
-			rawAssetSum = nil
+		if isBinaryProofFile(filePath) {
+			panicOnError(writeCompletedProofBinary(filePath, v), "error writing binary completed proof to file")
+			return
 		}
-
-		rawCompletedProof := RawCompletedProof{
-			Proof:                      v.Proof,
-			VerificationKey:            v.VerificationKey,
-			MerkleRoot:                 v.MerkleRoot,
-			MerkleRootWithAssetSumHash: v.MerkleRootWithAssetSumHash,
-			MerklePath:                 v.MerklePath,
-			MerklePosition:             v.MerklePosition,
-			MerkleNodes:                v.MerkleNodes,
-			AssetSum:                   rawAssetSum,
+		// proofs with merkle nodes can be very large, so stream them to the file instead of encoding them in memory
+		if len(v.MerkleNodes) > 0 {
+			panicOnError(
+				writeCompletedProofStreaming(filePath, v, STREAMING_WRITE_BUFFER_SIZE),
+				"error streaming raw completed proof to file",
+			)
+			return
 		}
-
 		panicOnError(
-			writeJson(filePath, rawCompletedProof),
+			writeJson(filePath, ConvertCompletedProofToRawCompletedProof(v)),
 			"error writing raw completed proof to file",
 		)
 	default:
@@ -101,12 +158,13 @@ func WriteDataToFile[D ProofElements | CompletedProof | circuit.GoAccount](fileP
 	}
 }
 
+// readJson reads the JSON data of filePath, decompressing it if it is compressed (see openFile).
 func readJson(filePath string, data interface{}) error {
-	file, err := os.Open(filePath)
+	file, err := openFile(filePath)
 	if err != nil {
 		return err
 	}
-	defer func(file *os.File) {
+	defer func(file io.ReadCloser) {
 		err := file.Close()
 		if err != nil {
 			panic("Error closing file: " + err.Error())
@@ -117,8 +175,13 @@ func readJson(filePath string, data interface{}) error {
 	return decoder.Decode(data)
 }
 
+// ReadDataFromFile reads the data from a JSON file, or, for a CompletedProof from a file with the
+// PROOF_BINARY_EXTENSION, from a file in the binary format (see readCompletedProofBinary).
 func ReadDataFromFile[D ProofElements | CompletedProof | circuit.GoAccount | UserVerificationElements](filePath string) D {
 	var data D
+	if _, isProof := any(data).(CompletedProof); isBinaryProofFile(filePath) && !isProof {
+		panic("Error reading data from file: only completed proofs can be read in the binary format: " + filePath)
+	}
 
 	// if data must be read in a "raw" format, handle the conversion accordingly
 	switch any(data).(type) {
@@ -134,98 +197,17 @@ func ReadDataFromFile[D ProofElements | CompletedProof | circuit.GoAccount | Use
 		var rawUserElements RawUserVerificationElements
 		panicOnError(readJson(filePath, &rawUserElements), "error reading raw user verification elements from file")
 
-		// convert the top proof's asset sum to a circuit.GoBalance
-		var actualTopProofAssetSum *circuit.GoBalance
-		if rawUserElements.ProofInfo.TopProof.AssetSum == nil {
-			panic("reading user verification elements failed: TopProof.AssetSum is nil")
-		} else {
-			convertedAssetSum := make(circuit.GoBalance, len(*rawUserElements.ProofInfo.TopProof.AssetSum))
-			for i, assetBalance := range *rawUserElements.ProofInfo.TopProof.AssetSum {
-				bigIntValue, ok := new(big.Int).SetString(assetBalance.Amount, 10)
-				if !ok {
-					panic("Error converting asset sum string to big.Int: " + assetBalance.Amount)
-				}
-				convertedAssetSum[i] = bigIntValue
-			}
-			actualTopProofAssetSum = &convertedAssetSum
-		}
-
-		// convert user account balance from []string to circuit.GoBalance
-		convertedBalance := make(circuit.GoBalance, len(rawUserElements.AccountInfo.Balance))
-		for i, assetBalance := range rawUserElements.AccountInfo.Balance {
-			bigIntValue, ok := new(big.Int).SetString(assetBalance.Amount, 10)
-			if !ok {
-				panic("Error converting account balance string to big.Int: " + assetBalance.Amount)
-			}
-			convertedBalance[i] = bigIntValue
-		}
-
-		// construct the UserVerificationElements from the raw data
-		actualUserElements := UserVerificationElements{
-			AccountInfo: circuit.ConvertRawGoAccountToGoAccount(circuit.RawGoAccount{
-				WalletId:  rawUserElements.AccountInfo.WalletId,
-				Balance: convertedBalance,
-			}),
-			ProofInfo: UserProofInfo{
-				UserMerklePath:     rawUserElements.ProofInfo.UserMerklePath,
-				UserMerklePosition: rawUserElements.ProofInfo.UserMerklePosition,
-				BottomProof: CompletedProof{
-					Proof:                      rawUserElements.ProofInfo.BottomProof.Proof,
-					VerificationKey:            rawUserElements.ProofInfo.BottomProof.VerificationKey,
-					MerkleRoot:                 rawUserElements.ProofInfo.BottomProof.MerkleRoot,
-					MerkleRootWithAssetSumHash: rawUserElements.ProofInfo.BottomProof.MerkleRootWithAssetSumHash,
-					MerklePath:                 rawUserElements.ProofInfo.BottomProof.MerklePath,
-					MerklePosition:             rawUserElements.ProofInfo.BottomProof.MerklePosition,
-				},
-				MiddleProof: CompletedProof{
-					Proof:                      rawUserElements.ProofInfo.MiddleProof.Proof,
-					VerificationKey:            rawUserElements.ProofInfo.MiddleProof.VerificationKey,
-					MerkleRoot:                 rawUserElements.ProofInfo.MiddleProof.MerkleRoot,
-					MerkleRootWithAssetSumHash: rawUserElements.ProofInfo.MiddleProof.MerkleRootWithAssetSumHash,
-					MerklePath:                 rawUserElements.ProofInfo.MiddleProof.MerklePath,
-					MerklePosition:             rawUserElements.ProofInfo.MiddleProof.MerklePosition,
-				},
-				TopProof: CompletedProof{
-					Proof:                      rawUserElements.ProofInfo.TopProof.Proof,
-					VerificationKey:            rawUserElements.ProofInfo.TopProof.VerificationKey,
-					MerkleRoot:                 rawUserElements.ProofInfo.TopProof.MerkleRoot,
-					MerkleRootWithAssetSumHash: rawUserElements.ProofInfo.TopProof.MerkleRootWithAssetSumHash,
-					AssetSum:                   actualTopProofAssetSum,
-				},
-			},
-		}
-		return any(actualUserElements).(D)
+		return any(ConvertRawUserVerificationElementsToUserVerificationElements(rawUserElements)).(D)
 	case CompletedProof:
+		if isBinaryProofFile(filePath) {
+			proof, err := readCompletedProofBinary(filePath)
+			panicOnError(err, "error reading binary completed proof from file")
+			return any(proof).(D)
+		}
 		var rawCompletedProof RawCompletedProof
 		panicOnError(readJson(filePath, &rawCompletedProof), "error reading raw completed proof from file")
 
-		// convert the raw asset sum to a circuit.GoBalance
-		var actualAssetSum *circuit.GoBalance
-		if rawCompletedProof.AssetSum == nil {
-			actualAssetSum = nil
-		} else {
-			convertedAssetSum := make(circuit.GoBalance, len(*rawCompletedProof.AssetSum))
-			for i, asset := range *rawCompletedProof.AssetSum {
-				bigIntValue, ok := new(big.Int).SetString(asset, 10)
-				if !ok {
-					panic("Error converting asset sum string to big.Int: " + asset)
-				}
-				convertedAssetSum[i] = bigIntValue
-			}
-			actualAssetSum = &convertedAssetSum
-		}
-
-		actualCompletedProof := CompletedProof{
-			Proof:                      rawCompletedProof.Proof,
-			VerificationKey:            rawCompletedProof.VerificationKey,
-			MerkleRoot:                 rawCompletedProof.MerkleRoot,
-			MerkleRootWithAssetSumHash: rawCompletedProof.MerkleRootWithAssetSumHash,
-			MerklePath:                 rawCompletedProof.MerklePath,
-			MerklePosition:             rawCompletedProof.MerklePosition,
-			MerkleNodes:                rawCompletedProof.MerkleNodes,
-			AssetSum:                   actualAssetSum,
-		}
-		return any(actualCompletedProof).(D)
+		return any(ConvertRawCompletedProofToCompletedProof(rawCompletedProof)).(D)
 
 	default:
 		err := readJson(filePath, &data)
@@ -262,8 +244,9 @@ func batchProofs(proofs []CompletedProof, batchSize int) [][]CompletedProof {
 	return batches
 }
 
+// panicOnError panics with err, prefixed with the step that failed in its message, if it is not nil (see stageError).
 func panicOnError(err error, messagePrefix string) {
 	if err != nil {
-		panic(messagePrefix + ": " + err.Error())
+		panic(&stageError{stage: messagePrefix, err: err})
 	}
 }
@@ -2,9 +2,11 @@ package core
 
 import (
 	"bytes"
+	"encoding/json"
 	"math/big"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 
 	"bitgo.com/proof_of_reserves/circuit"
@@ -33,12 +35,12 @@ func createTestProofElements() ProofElements {
 	// Create sample accounts
 	accounts := []circuit.GoAccount{
 		{
-			WalletId:  []byte{1, 2, 3},
-			Balance: circuit.ConstructGoBalance(big.NewInt(100), big.NewInt(200)),
+			WalletId: []byte{1, 2, 3},
+			Balance:  circuit.ConstructGoBalance(big.NewInt(100), big.NewInt(200)),
 		},
 		{
-			WalletId:  []byte{4, 5, 6},
-			Balance: circuit.ConstructGoBalance(big.NewInt(300), big.NewInt(400)),
+			WalletId: []byte{4, 5, 6},
+			Balance:  circuit.ConstructGoBalance(big.NewInt(300), big.NewInt(400)),
 		},
 	}
 
@@ -61,12 +63,12 @@ func createTestRawProofElements() RawProofElements {
 	// Create sample accounts
 	accounts := []circuit.RawGoAccount{
 		{
-			WalletId:  "user1",
-			Balance: circuit.ConstructGoBalance(big.NewInt(100), big.NewInt(200)),
+			WalletId: "user1",
+			Balance:  circuit.ConstructGoBalance(big.NewInt(100), big.NewInt(200)),
 		},
 		{
-			WalletId:  "user2",
-			Balance: circuit.ConstructGoBalance(big.NewInt(300), big.NewInt(400)),
+			WalletId: "user2",
+			Balance:  circuit.ConstructGoBalance(big.NewInt(300), big.NewInt(400)),
 		},
 	}
 
@@ -458,8 +460,8 @@ func TestReadDataFromFile(t *testing.T) {
 		filePath := "testutildata/test_account_0.json"
 		// Create RawGoAccount and write to file directly with writeJson
 		rawAccount := circuit.RawGoAccount{
-			WalletId:  "test-account-123",
-			Balance: circuit.ConstructGoBalance(big.NewInt(100), big.NewInt(200)),
+			WalletId: "test-account-123",
+			Balance:  circuit.ConstructGoBalance(big.NewInt(100), big.NewInt(200)),
 		}
 		err := writeJson(filePath, rawAccount)
 		if err != nil {
@@ -541,8 +543,8 @@ func TestReadDataFromFile(t *testing.T) {
 
 		// Verify AccountInfo
 		expectedAccount := circuit.ConvertRawGoAccountToGoAccount(circuit.RawGoAccount{
-			WalletId:  rawUserElements.AccountInfo.WalletId,
-			Balance: circuit.ConstructGoBalance(big.NewInt(500), big.NewInt(700)),
+			WalletId: rawUserElements.AccountInfo.WalletId,
+			Balance:  circuit.ConstructGoBalance(big.NewInt(500), big.NewInt(700)),
 		})
 		if !bytes.Equal(result.AccountInfo.WalletId, expectedAccount.WalletId) {
 			t.Errorf("WalletId not converted correctly: expected %v, got %v",
@@ -605,6 +607,74 @@ func TestReadDataFromFile(t *testing.T) {
 	})
 }
 
+func TestConvertRawUVBalancesToGoBalance(t *testing.T) {
+	tests := []struct {
+		name        string
+		balances    []RawUVBalance
+		expected    circuit.GoBalance
+		shouldError bool
+	}{
+		{"Amount only", []RawUVBalance{{Asset: "ALGO", Amount: "1500000"}}, circuit.GoBalance{big.NewInt(1500000)}, false},
+		{"DisplayAmount only", []RawUVBalance{{Asset: "ALGO", DisplayAmount: "1.5"}}, circuit.GoBalance{big.NewInt(1500000)}, false},
+		{"Matching amounts", []RawUVBalance{{Asset: "ALGO", Amount: "1500000", DisplayAmount: "1.5"}}, circuit.GoBalance{big.NewInt(1500000)}, false},
+		{"Mismatched amounts", []RawUVBalance{{Asset: "ALGO", Amount: "1500000", DisplayAmount: "1.6"}}, nil, true},
+		{"No amounts", []RawUVBalance{{Asset: "ALGO"}}, nil, true},
+		{"Invalid amount", []RawUVBalance{{Asset: "ALGO", Amount: "1.5"}}, nil, true},
+		{"Too many decimals", []RawUVBalance{{Asset: "ALGO", DisplayAmount: "1.0000001"}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ConvertRawUVBalancesToGoBalance(tt.balances)
+			if tt.shouldError {
+				if err == nil {
+					t.Errorf("expected error, got %v", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+
+	// converting a GoBalance to raw balances and back should be lossless
+	balance := circuit.ConstructGoBalance(big.NewInt(123), big.NewInt(456000000000000000))
+	rawBalances := ConvertGoBalanceToRawUVBalances(balance)
+	if rawBalances[1].Asset != circuit.GetAssetSymbols()[1] || rawBalances[1].DisplayAmount != "0.456" {
+		t.Errorf("unexpected raw balance: %+v", rawBalances[1])
+	}
+	result, err := ConvertRawUVBalancesToGoBalance(rawBalances)
+	if err != nil || !result.Equals(balance) {
+		t.Errorf("round trip of balance failed: got %v, error %v", result, err)
+	}
+}
+
+func TestWriteCompletedProofStreaming(t *testing.T) {
+	assert := test.NewAssert(t)
+	filePath := "testutildata/test_streamed_proof.json"
+	defer cleanupFiles(filePath)
+
+	// streamed proofs should decode to the same raw proof as proofs encoded in memory, for any buffer size
+	for _, bufferSize := range []int{16, STREAMING_WRITE_BUFFER_SIZE} {
+		assert.NoError(writeCompletedProofStreaming(filePath, proofLower0, bufferSize))
+		var rawProof RawCompletedProof
+		assert.NoError(readJson(filePath, &rawProof))
+		assert.Equal(ConvertCompletedProofToRawCompletedProof(proofLower0), rawProof)
+	}
+
+	// WriteDataToFile should stream proofs with merkle nodes
+	WriteDataToFile(filePath, proofLower0)
+	assert.Equal(proofLower0, ReadDataFromFile[CompletedProof](filePath))
+
+	// empty levels should be preserved
+	proofWithEmptyLevel := CompletedProof{Proof: "TestProof", MerkleNodes: [][]Hash{{}, {{1, 2}}}}
+	WriteDataToFile(filePath, proofWithEmptyLevel)
+	assert.Equal(proofWithEmptyLevel, ReadDataFromFile[CompletedProof](filePath))
+}
+
 func TestWriteReadDataRoundTrip(t *testing.T) {
 	t.Run("Round trip ProofElements", func(t *testing.T) {
 		// Create test data
@@ -716,11 +786,40 @@ func TestWriteReadDataRoundTrip(t *testing.T) {
 		}
 	})
 
+	t.Run("Round trip UserVerificationElements", func(t *testing.T) {
+		// Create test data
+		assetSum := circuit.ConstructGoBalance(big.NewInt(1000), big.NewInt(2000))
+		original := UserVerificationElements{
+			AccountInfo: circuit.ConvertRawGoAccountToGoAccount(circuit.RawGoAccount{
+				WalletId: "test-account-xyz",
+				Balance:  circuit.ConstructGoBalance(big.NewInt(123), big.NewInt(456)),
+			}),
+			ProofInfo: UserProofInfo{
+				UserMerklePath:     []Hash{{1, 2, 3}},
+				UserMerklePosition: 3,
+				BottomProof:        CompletedProof{Proof: "BottomProof", MerklePosition: 5, MerklePath: []Hash{{4, 5}}},
+				MiddleProof:        CompletedProof{Proof: "MiddleProof", MerklePosition: 6, MerklePath: []Hash{{6, 7}}},
+				TopProof:           CompletedProof{Proof: "TopProof", AssetSum: &assetSum},
+			},
+		}
+		filePath := "testutildata/test_write_user_verification.json"
+
+		// Write to file
+		WriteDataToFile(filePath, original)
+		defer cleanupFiles(filePath)
+
+		// Read back from file
+		result := ReadDataFromFile[UserVerificationElements](filePath)
+		if !reflect.DeepEqual(result, original) {
+			t.Errorf("UserVerificationElements don't match after round-trip: expected %+v, got %+v", original, result)
+		}
+	})
+
 	t.Run("Round trip GoAccount", func(t *testing.T) {
 		// Create test data
 		rawAccount := circuit.RawGoAccount{
-			WalletId:  "test-account-xyz",
-			Balance: circuit.ConstructGoBalance(big.NewInt(123), big.NewInt(456)),
+			WalletId: "test-account-xyz",
+			Balance:  circuit.ConstructGoBalance(big.NewInt(123), big.NewInt(456)),
 		}
 		original := circuit.ConvertRawGoAccountToGoAccount(rawAccount)
 		filePath := "testutildata/test_write_account.json"
@@ -745,3 +844,60 @@ func TestWriteReadDataRoundTrip(t *testing.T) {
 		}
 	})
 }
+
+func TestUserVerificationElementsKeepSalt(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	saltedAccount := testData0.Accounts[0]
+	saltedAccount.Salt = circuit.NewSalt()
+	for _, account := range []circuit.GoAccount{saltedAccount, testData0.Accounts[1]} {
+		elements := UserVerificationElements{
+			AccountInfo: account,
+			ProofInfo:   UserProofInfo{BottomProof: proofLower0, MiddleProof: proofMid, TopProof: proofTop},
+		}
+		raw := ConvertUserVerificationElementsToRawUserVerificationElements(elements)
+		encoded, err := json.Marshal(raw.AccountInfo)
+		assert.NoError(err)
+		assert.Equal(account.IsSalted(), strings.Contains(string(encoded), `"Salt"`))
+
+		// the user needs the salt to recompute their leaf
+		result := ConvertRawUserVerificationElementsToUserVerificationElements(raw)
+		assert.Equal(circuit.GoComputeMiMCHashForAccount(account), circuit.GoComputeMiMCHashForAccount(result.AccountInfo))
+	}
+}
+
+func TestWalletIdEncodingIsRecorded(t *testing.T) {
+	assert := test.NewAssert(t)
+	defer circuit.SetWalletIdEncoding(circuit.GetWalletIdEncoding())
+
+	// batches and user verification packages written with one encoding are read with it whatever the configured one
+	assert.NoError(circuit.SetWalletIdEncoding(circuit.WALLET_ID_UTF8))
+	account := circuit.ConvertRawGoAccountToGoAccount(circuit.RawGoAccount{WalletId: "Alice_Smith@example.com", Balance: circuit.ConstructGoBalance()})
+	rawElements := ConvertProofElementsToRawProofElements(ProofElements{Accounts: []circuit.GoAccount{account}})
+	assert.Equal(circuit.WALLET_ID_UTF8, rawElements.WalletIdEncoding)
+	rawPackage := ConvertUserVerificationElementsToRawUserVerificationElements(UserVerificationElements{
+		AccountInfo: account,
+		ProofInfo:   UserProofInfo{BottomProof: proofLower0, MiddleProof: proofMid, TopProof: proofTop},
+	})
+	assert.Equal(circuit.WALLET_ID_UTF8, rawPackage.AccountInfo.WalletIdEncoding)
+	assert.Equal("Alice_Smith@example.com", rawPackage.AccountInfo.WalletId)
+
+	assert.NoError(circuit.SetWalletIdEncoding(circuit.WALLET_ID_BASE36))
+	assert.Equal(account, ConvertRawProofElementsToProofElements(rawElements).Accounts[0])
+	assert.Equal(account.WalletId, ConvertRawUserVerificationElementsToUserVerificationElements(rawPackage).AccountInfo.WalletId)
+
+	// files that do not record an encoding are read with the configured one
+	rawElements.WalletIdEncoding = ""
+	assert.Panics(func() { ConvertRawProofElementsToProofElements(rawElements) })
+	rawElements.WalletIdEncoding = "base64"
+	assert.Panics(func() { ConvertRawProofElementsToProofElements(rawElements) })
+}
+
+func TestRoundReportLiabilitySum(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// the round report has the liability sum of the round generated in TestMain in display units
+	var report RoundReport
+	assert.NoError(readJson(testOutDir+ROUND_REPORT_FILE, &report))
+	assert.Equal(ConvertGoBalanceToRawUVBalances(*proofTop.AssetSum), report.LiabilitySum)
+}
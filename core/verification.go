@@ -0,0 +1,112 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"time"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"bitgo.com/proof_of_reserves/verification"
+)
+
+// The types of proofs, user verification packages, and verification keys, and the functions to decode and verify them,
+// are those of the verification package, which verifiers import without the prover.
+type (
+	CompletedProof              = verification.CompletedProof
+	RawCompletedProof           = verification.RawCompletedProof
+	UserProofInfo               = verification.UserProofInfo
+	UserVerificationElements    = verification.UserVerificationElements
+	RawUVBalance                = verification.RawUVBalance
+	RawLowerLevelProof          = verification.RawLowerLevelProof
+	RawTopLevelProof            = verification.RawTopLevelProof
+	RawUserProofInfo            = verification.RawUserProofInfo
+	RawUserAccountInfo          = verification.RawUserAccountInfo
+	RawUserVerificationElements = verification.RawUserVerificationElements
+
+	KeyBundle                 = verification.KeyBundle
+	KeyBundleEntry            = verification.KeyBundleEntry
+	SignedKeyBundle           = verification.SignedKeyBundle
+	PublishedVerificationKeys = verification.PublishedVerificationKeys
+	PublishedVerificationKey  = verification.PublishedVerificationKey
+	PinnedVerificationKey     = verification.PinnedVerificationKey
+	VerificationKeyPins       = verification.VerificationKeyPins
+
+	ProofBackend      = verification.ProofBackend
+	SigningContext    = verification.SigningContext
+	VerificationError = verification.VerificationError
+)
+
+const (
+	BACKEND_GROTH16           = verification.BACKEND_GROTH16
+	BACKEND_PLONK             = verification.BACKEND_PLONK
+	BACKEND_GROTH16_RECURSIVE = verification.BACKEND_GROTH16_RECURSIVE
+
+	SIGNING_CONTEXT_KEY_BUNDLE       = verification.SIGNING_CONTEXT_KEY_BUNDLE
+	SIGNING_CONTEXT_BATCH_RECEIPT    = verification.SIGNING_CONTEXT_BATCH_RECEIPT
+	SIGNING_CONTEXT_RELEASE_MANIFEST = verification.SIGNING_CONTEXT_RELEASE_MANIFEST
+	SIGNING_CONTEXT_ROUND_ARTIFACT   = verification.SIGNING_CONTEXT_ROUND_ARTIFACT
+)
+
+// ParseProofBackend returns the backend with the given name (see verification.ParseProofBackend).
+func ParseProofBackend(name string) (ProofBackend, error) {
+	return verification.ParseProofBackend(name)
+}
+
+// ComputeVerificationKeyFingerprint returns the fingerprint of a (base64 encoded) verification key (see
+// verification.ComputeVerificationKeyFingerprint).
+func ComputeVerificationKeyFingerprint(verificationKey string) (string, error) {
+	return verification.ComputeVerificationKeyFingerprint(verificationKey)
+}
+
+// VerifyUserWithPinnedKeysForDomain verifies a user verification package like VerifyUserWithPinnedKeys, for the given
+// deployment domain (see verification.VerifyUserWithPinnedKeysForDomain).
+func VerifyUserWithPinnedKeysForDomain(userVerifElements UserVerificationElements, pins VerificationKeyPins, domain string) error {
+	return verification.VerifyUserWithPinnedKeysForDomain(userVerifElements, pins, domain)
+}
+
+// VerifyKeyBundleForDomain verifies a signed key bundle like VerifyKeyBundle, for the given deployment domain (see
+// verification.VerifyKeyBundleForDomain).
+func VerifyKeyBundleForDomain(signed SignedKeyBundle, trustedPublicKeys []ed25519.PublicKey, now time.Time, domain string) (KeyBundle, error) {
+	return verification.VerifyKeyBundleForDomain(signed, trustedPublicKeys, now, domain)
+}
+
+// ResolveVerificationKeys sets the verification keys referenced by fingerprint in a user verification package (see
+// verification.ResolveVerificationKeys).
+func ResolveVerificationKeys(elements UserVerificationElements, verificationKeys map[string]string) (UserVerificationElements, error) {
+	return verification.ResolveVerificationKeys(elements, verificationKeys)
+}
+
+// ConvertRawUVBalancesToGoBalance converts the balances of a user verification package to a GoBalance in base units
+// (see verification.ConvertRawUVBalancesToGoBalance).
+func ConvertRawUVBalancesToGoBalance(rawBalances []RawUVBalance) (circuit.GoBalance, error) {
+	return verification.ConvertRawUVBalancesToGoBalance(rawBalances)
+}
+
+// ConvertGoBalanceToRawUVBalances converts a balance to the balances of a user verification package (see
+// verification.ConvertGoBalanceToRawUVBalances).
+func ConvertGoBalanceToRawUVBalances(balance circuit.GoBalance) []RawUVBalance {
+	return verification.ConvertGoBalanceToRawUVBalances(balance)
+}
+
+// ConvertUserVerificationElementsToRawUserVerificationElements converts a user verification package to the form it is
+// written to files in (see verification.ConvertUserVerificationElementsToRawUserVerificationElements).
+func ConvertUserVerificationElementsToRawUserVerificationElements(elements UserVerificationElements) RawUserVerificationElements {
+	return verification.ConvertUserVerificationElementsToRawUserVerificationElements(elements)
+}
+
+// ConvertRawUserVerificationElementsToUserVerificationElements converts a user verification package read from a file
+// (see verification.ConvertRawUserVerificationElementsToUserVerificationElements).
+func ConvertRawUserVerificationElementsToUserVerificationElements(rawElements RawUserVerificationElements) UserVerificationElements {
+	return verification.ConvertRawUserVerificationElementsToUserVerificationElements(rawElements)
+}
+
+// ConvertCompletedProofToRawCompletedProof converts a proof to the form it is written to files in (see
+// verification.ConvertCompletedProofToRawCompletedProof).
+func ConvertCompletedProofToRawCompletedProof(proof CompletedProof) RawCompletedProof {
+	return verification.ConvertCompletedProofToRawCompletedProof(proof)
+}
+
+// ConvertRawCompletedProofToCompletedProof converts a proof read from a file (see
+// verification.ConvertRawCompletedProofToCompletedProof).
+func ConvertRawCompletedProofToCompletedProof(rawProof RawCompletedProof) CompletedProof {
+	return verification.ConvertRawCompletedProofToCompletedProof(rawProof)
+}
@@ -0,0 +1,211 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sync"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"bitgo.com/proof_of_reserves/verification"
+)
+
+// pinnedVerificationKeys are the only verification keys proofs are verified with, by layer (as named in key bundles:
+// "bottom", "mid", "top", or "mid<level>" for the intermediate levels) and fingerprint: a proof is verified with the
+// pinned key of its fingerprint for its layer, whatever key it comes with, and fails verification if its key is not
+// pinned for its layer, so a key trusted for one layer can't verify the proofs of another. A proof of a layer without
+// pinned keys fails verification too. See PinPublishedVerificationKeys, PinKeyBundle, and
+// PinVerificationKeyFingerprints. The prover pins the keys of the proofs it writes, whose circuits it set up itself, so
+// it can verify the rounds it generates (see RunSelfTest and RunRound).
+var pinnedVerificationKeys VerificationKeyPins
+
+// pinnedVerificationKeysMutex guards pinnedVerificationKeys, which the prover pins keys in while proofs may be verified.
+var pinnedVerificationKeysMutex sync.RWMutex
+
+// GetPinnedVerificationKeys returns a copy of the pinned verification keys (see PinVerificationKeyFingerprints,
+// PinPublishedVerificationKeys, and PinKeyBundle).
+func GetPinnedVerificationKeys() VerificationKeyPins {
+	pinnedVerificationKeysMutex.RLock()
+	defer pinnedVerificationKeysMutex.RUnlock()
+	if pinnedVerificationKeys == nil {
+		return nil
+	}
+	pins := make(VerificationKeyPins, len(pinnedVerificationKeys))
+	for layer, keys := range pinnedVerificationKeys {
+		pins[layer] = make(map[string]PinnedVerificationKey, len(keys))
+		for fingerprint, key := range keys {
+			pins[layer][fingerprint] = key
+		}
+	}
+	return pins
+}
+
+// pinnedVerificationKey returns the pinned key to verify the proof of the given layer with, and the backend to verify
+// it with (see VerificationKeyPins.verificationKey).
+func pinnedVerificationKey(proof CompletedProof, layer string) (string, ProofBackend, error) {
+	pinnedVerificationKeysMutex.RLock()
+	defer pinnedVerificationKeysMutex.RUnlock()
+	return pinnedVerificationKeys.VerificationKey(proof, layer)
+}
+
+// pinVerificationKey pins the verification key of the given fingerprint for the layer, unless it is already pinned
+// with its key.
+func pinVerificationKey(layer string, fingerprint string, key PinnedVerificationKey) {
+	pinnedVerificationKeysMutex.Lock()
+	defer pinnedVerificationKeysMutex.Unlock()
+	if pinnedVerificationKeys == nil {
+		pinnedVerificationKeys = make(VerificationKeyPins)
+	}
+	if pinnedVerificationKeys[layer] == nil {
+		pinnedVerificationKeys[layer] = make(map[string]PinnedVerificationKey)
+	}
+	if pinned, ok := pinnedVerificationKeys[layer][fingerprint]; ok && pinned.VerificationKey != "" && key.VerificationKey == "" {
+		return
+	}
+	pinnedVerificationKeys[layer][fingerprint] = key
+}
+
+// pinProvenVerificationKey pins the verification key the proof of the given layer comes with, for its backend. It is
+// only for proofs the prover generated itself. Proofs that reference their key are ignored.
+func pinProvenVerificationKey(proof CompletedProof, layer string) {
+	if proof.VerificationKey == "" {
+		return
+	}
+	backend, err := ParseProofBackend(string(proof.Backend))
+	panicOnError(err, "error pinning verification key")
+	fingerprint, err := ComputeVerificationKeyFingerprint(proof.VerificationKey)
+	panicOnError(err, "error pinning verification key")
+	pinVerificationKey(layer, fingerprint, PinnedVerificationKey{VerificationKey: proof.VerificationKey, Backend: backend})
+}
+
+// PinVerificationKeyFingerprints pins the verification keys of the given hex encoded fingerprints (see
+// ComputeVerificationKeyFingerprint), obtained out of band, for the layer and backend, in addition to the keys already
+// pinned, so every proof of the layer must come with a key of a pinned fingerprint (or reference a pinned key), e.g. to
+// verify user verification packages against the fingerprints of the circuits of a round without its published keys.
+func PinVerificationKeyFingerprints(layer string, backend ProofBackend, fingerprints ...string) error {
+	parsedBackend, err := ParseProofBackend(string(backend))
+	if err != nil {
+		return err
+	}
+	for _, fingerprint := range fingerprints {
+		if decoded, err := hex.DecodeString(fingerprint); err != nil || len(decoded) != sha256.Size {
+			return fmt.Errorf("verification key fingerprint %q is not a hex encoded SHA-256 hash", fingerprint)
+		}
+	}
+	for _, fingerprint := range fingerprints {
+		pinVerificationKey(layer, fingerprint, PinnedVerificationKey{Backend: parsedBackend})
+	}
+	return nil
+}
+
+// PinPublishedVerificationKeys pins the published verification keys of a round (see ReadPublishedVerificationKeys),
+// obtained out of band, each for its layer and backend, in addition to the keys already pinned.
+func PinPublishedVerificationKeys(keys PublishedVerificationKeys) error {
+	for _, entry := range keys.Keys {
+		backend, err := ParseProofBackend(string(entry.Backend))
+		if err != nil {
+			return fmt.Errorf("%s level verification key %s: %w", entry.Layer, entry.Fingerprint, err)
+		}
+		pinVerificationKey(entry.Layer, entry.Fingerprint, PinnedVerificationKey{VerificationKey: entry.VerificationKey, Backend: backend})
+	}
+	return nil
+}
+
+// PinKeyBundle pins the verification keys of a verified key bundle (see VerifyKeyBundle), each for its layer and
+// backend, in addition to the keys already pinned.
+func PinKeyBundle(bundle KeyBundle) error {
+	for _, entry := range bundle.Keys {
+		backend, err := ParseProofBackend(string(entry.Backend))
+		if err != nil {
+			return fmt.Errorf("%s level verification key %s: %w", entry.Layer, entry.Fingerprint, err)
+		}
+		pinVerificationKey(entry.Layer, entry.Fingerprint, PinnedVerificationKey{VerificationKey: entry.VerificationKey, Backend: backend})
+	}
+	return nil
+}
+
+// pinRoundVerificationKeys pins the verification keys of the proofs of the round in outDir, each for its layer and
+// backend, for a prover verifying a round it generated itself, possibly in an earlier run (see RunRound). The keys are
+// those the proofs come with, so it must never be used for a round obtained from someone else.
+func pinRoundVerificationKeys(batchCount int, outDir string) {
+	outDir = GetOptions().OutputLayout.RoundDir(outDir)
+	plan := roundLayerPlan(batchCount, readRoundMetadata(outDir), circuit.GetConfig())
+	for level := 0; level < plan.Levels(); level++ {
+		layer := levelLayer(level, plan.Levels())
+		for _, proof := range readPublishedProofs(plan.LevelProofCount(level), outDir, layer) {
+			pinProvenVerificationKey(proof, layer.name)
+		}
+	}
+}
+
+// publishedVerificationKeysMutex guards VERIFICATION_KEYS_FILE, which bottom level proofs may be written to
+// concurrently.
+var publishedVerificationKeysMutex sync.Mutex
+
+// publishVerificationKey adds the verification key of the proof of the given layer to the keys published in outDir,
+// unless it was already published, and returns the proof with its key replaced by its fingerprint. Keys published for
+// another round are replaced.
+func publishVerificationKey(proof CompletedProof, outDir string, layer string) CompletedProof {
+	if proof.VerificationKey == "" {
+		return proof
+	}
+	publishedVerificationKeysMutex.Lock()
+	defer publishedVerificationKeysMutex.Unlock()
+
+	var keys PublishedVerificationKeys
+	err := readJson(outDir+VERIFICATION_KEYS_FILE, &keys)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		panicOnError(err, "error reading published verification keys")
+	}
+	if keys.RoundId != proof.RoundId {
+		keys = PublishedVerificationKeys{RoundId: proof.RoundId}
+	}
+
+	referencingProof := referenceVerificationKey(proof)
+	for _, entry := range keys.Keys {
+		if entry.Layer == layer && entry.Fingerprint == referencingProof.VerificationKeyFingerprint {
+			return referencingProof
+		}
+	}
+	keys.Keys = append(keys.Keys, PublishedVerificationKey{
+		Layer:           layer,
+		Fingerprint:     referencingProof.VerificationKeyFingerprint,
+		VerificationKey: proof.VerificationKey,
+		Backend:         proof.Backend,
+	})
+	panicOnError(writeJson(outDir+VERIFICATION_KEYS_FILE, keys), "error writing published verification keys")
+	return referencingProof
+}
+
+// resolvePublishedVerificationKeys sets the verification keys of the proofs of the given layer read from outDir that
+// reference their key by fingerprint, from the keys published in outDir, which must be those of the proofs' round.
+// Proofs that include their key are unchanged, and the published keys are only read if a proof references its key.
+func resolvePublishedVerificationKeys(proofs []CompletedProof, layer string, outDir string) {
+	var keys *PublishedVerificationKeys
+	for i := range proofs {
+		if proofs[i].VerificationKey != "" {
+			continue
+		}
+		if keys == nil {
+			published := ReadPublishedVerificationKeys(outDir + VERIFICATION_KEYS_FILE)
+			keys = &published
+		}
+		if keys.RoundId != proofs[i].RoundId {
+			panic(fmt.Sprintf("published verification keys belong to round %q, but the %s level proof belongs to round %q", keys.RoundId, layer, proofs[i].RoundId))
+		}
+		var err error
+		proofs[i], err = verification.ResolveVerificationKey(proofs[i], layer, keys.VerificationKeys())
+		panicOnError(err, "error resolving published verification key")
+	}
+}
+
+// ReadPublishedVerificationKeys reads the verification keys published by the prover (VERIFICATION_KEYS_FILE, or a
+// copy of it obtained out of band to pin them) from the given path, and checks they match their fingerprints.
+func ReadPublishedVerificationKeys(path string) PublishedVerificationKeys {
+	var keys PublishedVerificationKeys
+	panicOnError(readJson(path, &keys), "error reading published verification keys")
+	panicOnError(keys.Validate(), "invalid published verification keys")
+	return keys
+}
@@ -0,0 +1,116 @@
+package core
+
+import (
+	"os"
+	"testing"
+
+	"github.com/consensys/gnark/test"
+)
+
+func TestPublishVerificationKeys(t *testing.T) {
+	assert := test.NewAssert(t)
+	outDir := t.TempDir() + "/"
+	assert.NoError(os.MkdirAll(outDir+"secret", 0o755))
+	assert.NoError(os.MkdirAll(outDir+"public", 0o755))
+
+	// the published proofs reference their keys, which are published once
+	GenerateData(2, 4, outDir)
+	setOptions(t, func(options *Options) { options.PublishVerificationKeys = true })
+	Prove(2, outDir)
+	var rawProof RawCompletedProof
+	assert.NoError(readJson(outDir+BOTTOM_PROOF_PREFIX+"1.json", &rawProof))
+	assert.Equal("", rawProof.VerificationKey)
+	assert.NotEqual("", rawProof.VerificationKeyFingerprint)
+	published := ReadPublishedVerificationKeys(outDir + VERIFICATION_KEYS_FILE)
+	assert.Equal(3, len(published.Keys))
+	assert.Equal(readRoundMetadata(outDir).RoundId, published.RoundId)
+	assert.Equal(published.VerificationKeys()[rawProof.VerificationKeyFingerprint], readBottomLevelProof(outDir, 1).VerificationKey)
+	assert.NotPanics(func() { VerifyFull(2, outDir) })
+	manifest := ExportUserPackages(2, outDir, t.TempDir(), ExportOptions{ShardSize: 1})
+	assert.Equal(8, manifest.UserCount)
+
+	// the round verifies with its keys pinned, but not without them, or with the keys of another layer pinned
+	unpinVerificationKeys(t)
+	assert.Panics(func() { VerifyFull(2, outDir) })
+	assert.NoError(PinPublishedVerificationKeys(published))
+	assert.NotPanics(func() { VerifyFull(2, outDir) })
+	bottomKeys := pinnedVerificationKeys[bottomLevelLayer.name]
+	pinnedVerificationKeys[bottomLevelLayer.name] = pinnedVerificationKeys[midLevelLayer.name]
+	assert.Panics(func() { VerifyFull(2, outDir) })
+	pinnedVerificationKeys[bottomLevelLayer.name] = bottomKeys
+
+	// a published key that does not match its fingerprint is rejected
+	published.Keys[0].VerificationKey = published.Keys[1].VerificationKey
+	assert.NoError(writeJson(outDir+VERIFICATION_KEYS_FILE, published))
+	assert.Panics(func() { VerifyFull(2, outDir) })
+}
+
+func TestVerifyProofWithPinnedVerificationKey(t *testing.T) {
+	assert := test.NewAssert(t)
+	unpinVerificationKeys(t)
+
+	// proofs of a layer without pinned keys fail
+	err := verifyProof(proofLower0, bottomLevelLayer.name)
+	assert.Error(err)
+	assert.Contains(err.Error(), "no verification keys are pinned for the bottom layer")
+
+	// the pinned key is used for proofs of its layer that include it or reference it
+	fingerprint, err := ComputeVerificationKeyFingerprint(proofLower0.VerificationKey)
+	assert.NoError(err)
+	pinVerificationKey(bottomLevelLayer.name, fingerprint, PinnedVerificationKey{VerificationKey: proofLower0.VerificationKey, Backend: BACKEND_GROTH16})
+	assert.NoError(verifyProof(proofLower0, bottomLevelLayer.name))
+	assert.NoError(verifyProof(referenceVerificationKey(proofLower0), bottomLevelLayer.name))
+
+	// but not for proofs of another layer
+	err = verifyProof(proofLower0, midLevelLayer.name)
+	assert.Error(err)
+	assert.Contains(err.Error(), "no verification keys are pinned for the mid layer")
+	pinVerificationKey(midLevelLayer.name, "other", PinnedVerificationKey{VerificationKey: proofMid.VerificationKey, Backend: BACKEND_GROTH16})
+	assert.Error(verifyProof(proofLower0, midLevelLayer.name))
+
+	// a proof that comes with another key fails, even if the proof matches its key
+	assert.Error(verifyProof(proofMid, bottomLevelLayer.name))
+	mislabeledProof := proofMid
+	mislabeledProof.VerificationKeyFingerprint = fingerprint
+	assert.Error(verifyProof(mislabeledProof, bottomLevelLayer.name))
+
+	// the proof is verified with the backend of its pinned key, which the backend it records must match
+	mislabeledProof = proofLower0
+	mislabeledProof.Backend = BACKEND_PLONK
+	err = verifyProof(mislabeledProof, bottomLevelLayer.name)
+	assert.Error(err)
+	assert.Contains(err.Error(), "pinned verification key is of the groth16 backend")
+}
+
+func TestPinVerificationKeyFingerprints(t *testing.T) {
+	assert := test.NewAssert(t)
+	unpinVerificationKeys(t)
+	assert.Error(PinVerificationKeyFingerprints(bottomLevelLayer.name, BACKEND_GROTH16, "not a fingerprint"))
+	assert.Error(PinVerificationKeyFingerprints(bottomLevelLayer.name, BACKEND_GROTH16, "abcd"))
+	fingerprint, err := ComputeVerificationKeyFingerprint(proofLower0.VerificationKey)
+	assert.NoError(err)
+	assert.Error(PinVerificationKeyFingerprints(bottomLevelLayer.name, "unknown", fingerprint))
+	assert.Nil(GetPinnedVerificationKeys(), "nothing is pinned if a fingerprint or the backend is invalid")
+
+	// proofs of the layer that come with a key of a pinned fingerprint are verified with it
+	assert.NoError(PinVerificationKeyFingerprints(bottomLevelLayer.name, BACKEND_GROTH16, fingerprint))
+	assert.NoError(verifyProof(proofLower0, bottomLevelLayer.name))
+	assert.NoError(verifyProof(proofLower1, bottomLevelLayer.name))
+
+	// but not proofs that come with another key, or only reference the key, or proofs of another layer
+	assert.Error(verifyProof(proofMid, bottomLevelLayer.name))
+	assert.Error(verifyProof(referenceVerificationKey(proofLower0), bottomLevelLayer.name))
+	assert.Error(verifyProof(proofLower0, midLevelLayer.name))
+
+	// keys pinned by fingerprint are added to the keys already pinned, which are kept
+	midFingerprint, err := ComputeVerificationKeyFingerprint(proofMid.VerificationKey)
+	assert.NoError(err)
+	pinnedVerificationKeys = nil
+	pinVerificationKey(bottomLevelLayer.name, fingerprint, PinnedVerificationKey{VerificationKey: proofLower0.VerificationKey, Backend: BACKEND_GROTH16})
+	assert.NoError(PinVerificationKeyFingerprints(bottomLevelLayer.name, BACKEND_GROTH16, fingerprint))
+	assert.NoError(PinVerificationKeyFingerprints(midLevelLayer.name, BACKEND_GROTH16, midFingerprint))
+	assert.Equal(proofLower0.VerificationKey, GetPinnedVerificationKeys()[bottomLevelLayer.name][fingerprint].VerificationKey)
+	assert.NoError(verifyProof(referenceVerificationKey(proofLower0), bottomLevelLayer.name))
+	assert.NoError(verifyProof(proofMid, midLevelLayer.name))
+	assert.Error(verifyProof(proofTop, topLevelLayer.name))
+}
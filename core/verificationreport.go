@@ -0,0 +1,145 @@
+package core
+
+import "errors"
+
+// names of the checks of a full verification, which the failures of a VerificationReport are grouped by
+const (
+	VERIFY_CHECK_ROUND_METADATA     = "round metadata"
+	VERIFY_CHECK_ROUND_ID           = "round id"
+	VERIFY_CHECK_PROOF_PARAMETERS   = "proof parameters"
+	VERIFY_CHECK_PROVENANCE         = "provenance"
+	VERIFY_CHECK_MANIFEST           = "manifest"
+	VERIFY_CHECK_ASSET_SUM_SUMMARY  = "asset sum summary"
+	VERIFY_CHECK_SUCCINCT_PROOF     = "succinct proof"
+	VERIFY_CHECK_PROOF_COUNT        = "proof count"
+	VERIFY_CHECK_ACCOUNT_COUNT      = "account count"
+	VERIFY_CHECK_MERKLE_NODES       = "merkle nodes"
+	VERIFY_CHECK_CIRCUIT            = "circuit"
+	VERIFY_CHECK_BALANCE_RANGE      = "balance range"
+	VERIFY_CHECK_MERKLE_PATH        = "merkle path"
+	VERIFY_CHECK_DUPLICATE_ACCOUNTS = "duplicate accounts"
+	VERIFY_CHECK_ACCOUNT_INCLUSION  = "account inclusion"
+	VERIFY_CHECK_ASSET_SUM          = "asset sum"
+	VERIFY_CHECK_EXCLUDED_ACCOUNTS  = "excluded accounts"
+	VERIFY_CHECK_SPARSE_TREE        = "sparse tree"
+
+	// users in more than one batch, if checked (see Options.CrossBatchDuplicateUsers)
+	VERIFY_CHECK_CROSS_BATCH_DUPLICATES = "cross batch duplicates"
+)
+
+// VerificationReport is the result of verifying a round with VerifyFullReport: every check that failed, instead of
+// only the first.
+type VerificationReport struct {
+	BatchCount int
+	Failures   []VerificationFailure
+	// Passed is set if no check failed.
+	Passed bool
+}
+
+// VerificationFailure is a check that failed in a VerificationReport.
+type VerificationFailure struct {
+	// Check is the name of the check that failed (see the VERIFY_CHECK_ constants).
+	Check string
+	// Layer and Proof identify the proof the check failed for, e.g. "bottom" and 3, if it failed for a proof.
+	Layer string `json:",omitempty"`
+	Proof *int   `json:",omitempty"`
+	// Batch and Account identify the batch, and the account of the batch, the check failed for, if any.
+	Batch   *int `json:",omitempty"`
+	Account *int `json:",omitempty"`
+	// Expected and Actual are the hashes compared by the check, for checks comparing hashes, e.g. the merkle root of the
+	// upper level proof and the root the merkle path of the proof leads to.
+	Expected Hash `json:",omitempty"`
+	Actual   Hash `json:",omitempty"`
+	// Error is the message VerifyFull fails with for the check.
+	Error string
+}
+
+// verificationChecks runs the checks of a full verification. By default, the first check that fails panics with its
+// error, like panicOnError. If failures are collected (see VerifyFullReport), every failure is recorded instead, and
+// verification goes on with the checks that don't depend on the check that failed.
+type verificationChecks struct {
+	collect  bool
+	failures []VerificationFailure
+}
+
+// check records the failure of a check, with err and the stage it failed in, if err is not nil. Returns whether the
+// check passed.
+func (c *verificationChecks) check(err error, stage string, failure VerificationFailure) bool {
+	if err == nil {
+		return true
+	}
+	c.failWith(&stageError{stage: stage, err: err}, failure)
+	return false
+}
+
+// fail records the failure of a check with the given message.
+func (c *verificationChecks) fail(message string, failure VerificationFailure) {
+	c.failWith(errors.New(message), failure)
+}
+
+// failWith records the failure of a check with err.
+func (c *verificationChecks) failWith(err error, failure VerificationFailure) {
+	failure.Error = err.Error()
+	if !c.collect {
+		panic(err)
+	}
+	c.failures = append(c.failures, failure)
+}
+
+// inParallel runs verify for every batch index from 0 to count with verifyInParallel, each batch with checks of its
+// own, and records the failures of the batches in batch order once every batch is verified.
+func (c *verificationChecks) inParallel(count int, verify func(i int, checks *verificationChecks)) {
+	batchChecks := make([]verificationChecks, count)
+	err := verifyInParallel(count, func(i int) error {
+		batchChecks[i].collect = c.collect
+		verify(i, &batchChecks[i])
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	for _, checks := range batchChecks {
+		c.failures = append(c.failures, checks.failures...)
+	}
+}
+
+// roundFailure returns the failure of a check of the round as a whole.
+func roundFailure(check string) VerificationFailure {
+	return VerificationFailure{Check: check}
+}
+
+// proofFailure returns the failure of a check of the given proof of the layer.
+func proofFailure(check string, layer proofLayer, proof int) VerificationFailure {
+	return VerificationFailure{Check: check, Layer: layer.name, Proof: &proof}
+}
+
+// batchFailure returns the failure of a check of the given batch, and its bottom level proof.
+func batchFailure(check string, batch int) VerificationFailure {
+	failure := proofFailure(check, bottomLevelLayer, batch)
+	failure.Batch = &batch
+	return failure
+}
+
+// accountFailure returns the failure of a check of the given account of the batch.
+func accountFailure(check string, batch int, account int, expected Hash, actual Hash) VerificationFailure {
+	failure := batchFailure(check, batch)
+	failure.Account, failure.Expected, failure.Actual = &account, expected, actual
+	return failure
+}
+
+// VerifyFullReport verifies a round like VerifyFull, but runs every check and returns a report of every check that
+// failed (which batch or proof, which check, and the hashes compared where it compares hashes), so all the problems
+// of a round are found in one pass. Checks that depend on a check that failed, e.g. the proofs of a round whose hash
+// function can't be determined, are skipped. Returns a *VerificationError if the round can't be read.
+func VerifyFullReport(batchCount int, outDir string) (report *VerificationReport, err error) {
+	checks := &verificationChecks{collect: true}
+	if err := checkRound(checks, batchCount, outDir); err != nil {
+		return nil, err
+	}
+	return &VerificationReport{BatchCount: batchCount, Failures: checks.failures, Passed: len(checks.failures) == 0}, nil
+}
+
+// WriteVerificationReport writes the report to path as JSON.
+func WriteVerificationReport(path string, report VerificationReport) {
+	panicOnError(writeJson(path, report), "error writing verification report")
+}
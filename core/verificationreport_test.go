@@ -0,0 +1,61 @@
+package core
+
+import (
+	"slices"
+	"testing"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"bitgo.com/proof_of_reserves/verification"
+	"github.com/consensys/gnark/test"
+)
+
+func TestVerifyFullReport(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	report, err := VerifyFullReport(batchCount, testOutDir)
+	assert.NoError(err)
+	assert.True(report.Passed)
+	assert.Equal(0, len(report.Failures))
+
+	// a round that can't be read is an error, not a failure
+	_, err = VerifyFullReport(batchCount, t.TempDir()+"/")
+	var verificationError *VerificationError
+	assert.ErrorAs(err, &verificationError)
+}
+
+func TestCheckFullCollectsEveryFailure(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// a bottom level proof with a bad merkle path, and a batch with its first two accounts swapped
+	bottomProofs := []CompletedProof{proofLower0, proofLower1}
+	bottomProofs[0].MerklePath = slices.Clone(bottomProofs[0].MerklePath)
+	bottomProofs[0].MerklePath[0] = bottomProofs[0].MerklePath[1]
+	accountBatches := [][]circuit.GoAccount{testData0.Accounts, slices.Clone(testData1.Accounts)}
+	accountBatches[1][0], accountBatches[1][1] = accountBatches[1][1], accountBatches[1][0]
+
+	checks := &verificationChecks{collect: true}
+	checkFull(checks, [][]CompletedProof{bottomProofs, {proofMid}, {proofTop}}, accountBatches, circuit.GetConfig())
+	failures := checks.failures
+	assert.Equal(3, len(failures), "%v", failures)
+
+	assert.Equal(VERIFY_CHECK_MERKLE_PATH, failures[0].Check)
+	assert.Equal("bottom", failures[0].Layer)
+	assert.Equal(0, *failures[0].Batch)
+	assert.Equal(Hash(proofMid.MerkleRoot), failures[0].Expected)
+	actual, err := verification.ComputeMerklePathRoot(bottomProofs[0].MerkleRootWithAssetSumHash, bottomProofs[0].MerklePosition, bottomProofs[0].MerklePath, circuit.GetConfig())
+	assert.NoError(err)
+	assert.Equal(actual, failures[0].Actual)
+
+	for i, failure := range failures[1:] {
+		assert.Equal(VERIFY_CHECK_ACCOUNT_INCLUSION, failure.Check)
+		assert.Equal(1, *failure.Batch)
+		assert.Equal(i, *failure.Account)
+		assert.Equal(circuit.GoComputeMiMCHashForAccount(accountBatches[1][i]), failure.Expected)
+		assert.Equal(proofLower1.MerkleNodes[circuit.GetTreeDepth()][i], failure.Actual)
+	}
+
+	// without collecting, the first failure panics with the message VerifyFull fails with
+	assert.PanicsWithError(failures[0].Error, func() {
+		verifyFull([][]CompletedProof{bottomProofs, {proofMid}, {proofTop}}, accountBatches)
+	})
+}
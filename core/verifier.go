@@ -2,103 +2,113 @@ package core
 
 import (
 	"bytes"
-	"encoding/base64"
 	"fmt"
+	"runtime"
+	"slices"
 	"strconv"
+	"sync"
+	"sync/atomic"
 
 	"bitgo.com/proof_of_reserves/circuit"
-	"github.com/consensys/gnark-crypto/ecc"
-	"github.com/consensys/gnark-crypto/ecc/bn254/fr/mimc"
-	"github.com/consensys/gnark/backend/groth16"
-	"github.com/consensys/gnark/frontend"
+	"bitgo.com/proof_of_reserves/verification"
 )
 
-// verifyProof verifies that the proof is valid - returns nil if verification passes, error if it fails
-func verifyProof(proof CompletedProof) error {
-	// first, verify snark
-	// create the public witness
-	publicWitness, err := frontend.NewWitness(&circuit.Circuit{
-		MerkleRoot:                 proof.MerkleRoot,
-		MerkleRootWithAssetSumHash: proof.MerkleRootWithAssetSumHash,
-	}, ecc.BN254.ScalarField(), frontend.PublicOnly())
+// verifyProof verifies that the proof of the given layer is valid - returns nil if verification passes, error if it
+// fails. The proof is verified with its pinned verification key for the layer (see GetPinnedVerificationKeys), with the
+// backend of the pinned key, and fails if its key is not pinned.
+func verifyProof(proof CompletedProof, layer string) error {
+	pinnedVerificationKeysMutex.RLock()
+	verificationKey, backend, err := pinnedVerificationKey(proof, layer)
+	pinnedVerificationKeysMutex.RUnlock()
 	if err != nil {
-		return fmt.Errorf("error creating public witness: %v", err)
-	}
-
-	// read proof bytes into groth16 proof instance
-	grothProof := groth16.NewProof(ecc.BN254)
-	proofBytes, err := base64.StdEncoding.DecodeString(proof.Proof)
-	if err != nil {
-		return fmt.Errorf("error decoding proof: %v", err)
-	}
-	_, err = grothProof.ReadFrom(bytes.NewBuffer(proofBytes))
-	if err != nil {
-		return fmt.Errorf("error reading proof: %v", err)
-	}
-
-	// read verification key bytes into groth16 vk instance
-	grothVK := groth16.NewVerifyingKey(ecc.BN254)
-	vkBytes, err := base64.StdEncoding.DecodeString(proof.VerificationKey)
-	if err != nil {
-		return fmt.Errorf("error decoding verification key: %v", err)
-	}
-	_, err = grothVK.ReadFrom(bytes.NewBuffer(vkBytes))
-	if err != nil {
-		return fmt.Errorf("error reading verification key: %v", err)
+		return err
 	}
+	return verification.VerifyProofWithKey(proof, verificationKey, backend)
+}
 
-	// verify public witness with proof and VK
-	err = groth16.Verify(grothProof, grothVK, publicWitness)
-	if err != nil {
-		return fmt.Errorf("proof verification failed: %v", err)
-	}
+// VerifyMerklePathElements verifies that a particular hash and merkle path, with the side of each sibling (see
+// circuit.ComputeMerklePathElements), lead to the given merkle root. Unlike verification.VerifyMerklePath, it needs no
+// position.
+func VerifyMerklePathElements(hash Hash, path []circuit.MerklePathElement, root Hash) error {
+	return verification.VerifyMerklePathElements(hash, path, root, circuit.GetConfig())
+}
 
-	return nil
+// VerifyMerkleMultiPath verifies that the hashes at the given positions (hashes[i] is at positions[i]) and their merkle
+// multi-path (see circuit.ComputeMerkleMultiPath) lead to the given merkle root. It verifies the inclusion of many
+// leaves at once, e.g. every account of a customer, with the siblings shared between their paths included only once.
+func VerifyMerkleMultiPath(hashes []Hash, positions []int, path []Hash, root Hash) error {
+	return verifyMerkleMultiPath(hashes, positions, path, root, circuit.GetConfig())
 }
 
-// verifyMerklePath verifies that a particular hash and merkle path lead to the given merkle root
-func verifyMerklePath(hash Hash, hashPosition int, path []Hash, root Hash) error {
-	if len(path) != circuit.TREE_DEPTH {
-		return fmt.Errorf("merkle path is not of depth of tree: expected length %d, found %d", circuit.TREE_DEPTH, len(path))
+// verifyMerkleMultiPath verifies the merkle multi-path like VerifyMerkleMultiPath, in a tree of the depth and hashing
+// of the configuration.
+func verifyMerkleMultiPath(hashes []Hash, positions []int, path []Hash, root Hash, config circuit.Config) error {
+	if len(hashes) != len(positions) {
+		return fmt.Errorf("expected a position for each of the %d hashes, found %d", len(hashes), len(positions))
 	}
-	if hashPosition < 0 || hashPosition >= circuit.PowOfTwo(circuit.TREE_DEPTH) {
-		return fmt.Errorf("hashPosition out of bounds")
+	sortedPositions, err := circuit.SortMerkleMultiPathPositions(positions, config.TreeDepth)
+	if err != nil {
+		return err
 	}
 
-	hasher := mimc.NewMiMC()
-	curr := hash
-	currPos := hashPosition
-	var err error
-	for i, sibling := range path {
-		depth := strconv.Itoa(len(path) - i)
+	// hashes of the known nodes of the current layer, by position
+	known := make(map[int]Hash, len(hashes))
+	for i, hash := range hashes {
+		known[positions[i]] = hash
+	}
 
-		// if currPos odd, should hash sibling first, so swap them
-		if currPos%2 == 1 {
-			curr, sibling = sibling, curr
-		}
-		curr, err = circuit.GoComputeHashOfTwoNodes(hasher, curr, sibling, "current node at depth "+depth, "sibling node at depth "+depth)
+	hasher := circuit.NewGoHasher(config.GetHashFunction())
+	currPositions := sortedPositions
+	for i := config.TreeDepth; i > 0; i-- {
+		depth := strconv.Itoa(i)
+		parents := make(map[int]Hash, len(currPositions))
+		parentPositions := make([]int, 0, len(currPositions))
+		for j := 0; j < len(currPositions); j++ {
+			currPos := currPositions[j]
+			curr := known[currPos]
+
+			// the sibling is either known (the next position) or the next element of the path
+			var sibling Hash
+			if currPos%2 == 0 && j+1 < len(currPositions) && currPositions[j+1] == currPos+1 {
+				sibling = known[currPos+1]
+				j++
+			} else {
+				if len(path) == 0 {
+					return fmt.Errorf("merkle multi-path is too short")
+				}
+				sibling, path = path[0], path[1:]
+			}
 
-		if err != nil {
-			return err
+			// if currPos odd, should hash sibling first, so swap them
+			if currPos%2 == 1 {
+				curr, sibling = sibling, curr
+			}
+			parent, err := config.GoComputeHashOfTwoNodes(hasher, curr, sibling, "left node at depth "+depth, "right node at depth "+depth)
+			if err != nil {
+				return err
+			}
+			parents[currPos/2] = parent
+			parentPositions = append(parentPositions, currPos/2)
 		}
-
-		// update currPos to be the index of the parent of curr and sibling
-		currPos /= 2
+		known, currPositions = parents, parentPositions
+	}
+	if len(path) != 0 {
+		return fmt.Errorf("merkle multi-path is too long: %d unused hashes", len(path))
 	}
-	if !bytes.Equal(curr, root) {
-		return fmt.Errorf("merkle proof path verification failed")
+	if !bytes.Equal(known[0], root) {
+		return fmt.Errorf("merkle multi-path verification failed")
 	}
 	return nil
 }
 
-// verifyBuild verifies that the given merkle nodes are indeed part of the merkle tree with the given root.
-func verifyBuild(nodes [][]Hash, root Hash, treeDepth int) error {
+// verifyBuild verifies that the given merkle nodes are indeed part of the merkle tree with the given root, of the depth
+// and hashing of the configuration.
+func verifyBuild(nodes [][]Hash, root Hash, config circuit.Config) error {
+	treeDepth := config.TreeDepth
 	if len(nodes)-1 != treeDepth {
 		return fmt.Errorf("expected %d layers of nodes, found %d", treeDepth+1, len(nodes))
 	}
 
-	hasher := mimc.NewMiMC()
-
 	// verify correct number of hashes/nodes in bottom layer
 	if len(nodes[treeDepth]) != circuit.PowOfTwo(treeDepth) {
 		return fmt.Errorf("invalid number of nodes for depth %d in the tree: expected %d, found %d", treeDepth, circuit.PowOfTwo(treeDepth), len(nodes[treeDepth]))
@@ -110,13 +120,10 @@ func verifyBuild(nodes [][]Hash, root Hash, treeDepth int) error {
 			return fmt.Errorf("invalid number of nodes for depth %d in the tree: expected %d, found %d", i-1, circuit.PowOfTwo(i-1), len(nodes[i-1]))
 		}
 
-		// iteratively compute hash with children and compare with parent
+		// compute the hashes of the children (with the hash workers) and compare with the parents
+		parents := config.GoComputeParentNodes(nodes[i])
 		for j := 0; j < circuit.PowOfTwo(i-1); j++ {
-			curr, err := circuit.GoComputeHashOfTwoNodes(hasher, nodes[i][2*j], nodes[i][2*j+1], fmt.Sprintf("node[%d][%d]", i, 2*j), fmt.Sprintf("node[%d][%d]", i, 2*j+1))
-			if err != nil {
-				return err
-			}
-			if !bytes.Equal(curr, nodes[i-1][j]) {
+			if !bytes.Equal(parents[j], nodes[i-1][j]) {
 				return fmt.Errorf("incorrect hash found at depth %d, position %d", i-1, j)
 			}
 		}
@@ -130,145 +137,551 @@ func verifyBuild(nodes [][]Hash, root Hash, treeDepth int) error {
 	return nil
 }
 
-// verifies the MerkleRootAssetSumHash of the top layer proof is indeed the hash of its merkleRoot and assetSum
-// Returns nil if verification passes, error if it fails
-func verifyTopLayerProofMatchesAssetSum(topLayerProof CompletedProof) error {
-	if topLayerProof.AssetSum == nil {
-		return fmt.Errorf("top layer proof's AssetSum is nil")
+// RebuildMerkleNodes returns the bottom level proof with the merkle nodes of the tree of the given accounts, so an
+// auditor with the accounts of a round can verify its published bottom level proofs without their secret copies when
+// the publication policy kept their merkle nodes out of the public directory (see PublicationPolicy). The accounts
+// must be those of the proof in the order they were proven, i.e. as the prover restricted them to the asset subset,
+// merged them by user, excluded, and padded them, and are hashed with the hash function, hash version, account bytes
+// version, leaf hash mode, and tree depth the proof was generated with.
+// Returns an error if the root of the rebuilt tree is not the merkle root of the proof
+func RebuildMerkleNodes(proof CompletedProof, accounts []circuit.GoAccount) (CompletedProof, error) {
+	config, err := verification.ProofsConfig([]CompletedProof{proof})
+	if err != nil {
+		return CompletedProof{}, err
+	}
+	if err := verifyAccountCount(proof, len(accounts)); err != nil {
+		return CompletedProof{}, err
+	}
+
+	nodes, err := config.GoComputeMerkleTreeNodesFromAccountsWithError(accounts)
+	if err != nil {
+		return CompletedProof{}, fmt.Errorf("error hashing the accounts: %w", err)
+	}
+	if !bytes.Equal(nodes[0][0], proof.MerkleRoot) {
+		return CompletedProof{}, fmt.Errorf("the root of the merkle tree of the accounts is not the merkle root of the proof")
+	}
+	proof.MerkleNodes = nodes
+	return proof, nil
+}
+
+// verifyAccountsSumToAssetSum verifies the sum of every account balance across all batches equals the given asset sum,
+// with the number of assets of the configuration.
+func verifyAccountsSumToAssetSum(accountBatches [][]circuit.GoAccount, assetSum *circuit.GoBalance, config circuit.Config) error {
+	if assetSum == nil {
+		return fmt.Errorf("asset sum is nil")
 	}
 
-	computedHash := circuit.GoComputeMiMCHashForAccount(ConvertProofToGoAccount(topLayerProof))
-	if !bytes.Equal(computedHash, topLayerProof.MerkleRootWithAssetSumHash) {
-		return fmt.Errorf("top layer proof's MerkleRootWithAssetSumHash does not match the hash computed from MerkleRoot and AssetSum")
+	allAccounts := make([]circuit.GoAccount, 0)
+	for _, batch := range accountBatches {
+		allAccounts = append(allAccounts, batch...)
+	}
+	computedSum, err := config.SumGoAccountBalancesWithError(allAccounts)
+	if err != nil {
+		return err
+	}
+	if !config.EqualGoBalances(computedSum, *assetSum) {
+		return fmt.Errorf("sum of all account balances does not match the asset sum")
 	}
 	return nil
 }
 
 // VerifyUser is the primary verification method for a user.
-// It verifies that the provided user account is included in the bottom layer proof,
-// that the bottom layer proof is included in the mid layer proof, and that the mid layer
-// proof is included in the top layer proof, and that all the proofs are valid.
+// It verifies that the provided user account is included in the bottom layer proof, and that each proof of the
+// package is included in the next, from the bottom layer proof through the mid layer proof (and the intermediate
+// layer proofs of rounds with more than MIN_HIERARCHY_LEVELS levels) to the top layer proof, and that all the proofs
+// are valid.
 // It also verifies that the top layer proof's MerkleRootWithAssetSumHash matches the MerkleRoot and published AssetSum.
+// Verification keys referenced by fingerprint must be resolved with ResolveVerificationKeys first. Panics if verification
+// fails (see VerifyUserWithError).
 func VerifyUser(userVerifElements UserVerificationElements) {
+	panicOnStageError(VerifyUserWithError(userVerifElements))
+}
 
-	// extract proofs from verification elements
-	bottomProof := &userVerifElements.ProofInfo.BottomProof
-	middleProof := &userVerifElements.ProofInfo.MiddleProof
-	topProof := &userVerifElements.ProofInfo.TopProof
-
-	// create hash of account
-	accountHash := circuit.GoComputeMiMCHashForAccount(userVerifElements.AccountInfo)
-
-	// verify proofs
-	panicOnError(verifyProof(*bottomProof), "bottom layer proof verification failed")
-	panicOnError(verifyProof(*middleProof), "mid layer proof verification failed")
-	panicOnError(verifyProof(*topProof), "top layer proof verification failed")
-
-	// verify inclusion of account -> bottom proof -> middle proof -> top
-	panicOnError(
-		verifyMerklePath(
-			accountHash,
-			userVerifElements.ProofInfo.UserMerklePosition,
-			userVerifElements.ProofInfo.UserMerklePath,
-			bottomProof.MerkleRoot,
-		),
-		"failed to verify if account included in bottom proof",
-	)
-	panicOnError(
-		verifyMerklePath(
-			bottomProof.MerkleRootWithAssetSumHash,
-			bottomProof.MerklePosition,
-			bottomProof.MerklePath,
-			middleProof.MerkleRoot,
-		),
-		"failed to verify if bottom proof included in middle proof",
-	)
-	panicOnError(
-		verifyMerklePath(
-			middleProof.MerkleRootWithAssetSumHash,
-			middleProof.MerklePosition,
-			middleProof.MerklePath,
-			topProof.MerkleRoot,
-		),
-		"failed to verify if middle proof included in top proof",
-	)
+// VerifyTopLevelProof verifies a round from its top level proof alone, which attests the whole hierarchy when the
+// proofs were generated with BACKEND_GROTH16_RECURSIVE: the top level proof verifies the mid level proofs in its
+// circuit, which verify the bottom level proofs in theirs. It also verifies the published asset sum is the sum hashed
+// in MerkleRootWithAssetSumHash. The verification key of the proof must be pinned for the top layer with the
+// BACKEND_GROTH16_RECURSIVE backend (see GetPinnedVerificationKeys), as it fixes the verification keys of the lower level
+// proofs: the backend the proof records is not trusted.
+func VerifyTopLevelProof(topLevelProof CompletedProof) error {
+	verificationKey, err := pinnedTopLevelVerificationKey(topLevelProof)
+	if err != nil {
+		return err
+	}
+	config, err := verification.ProofsConfig([]CompletedProof{topLevelProof})
+	if err != nil {
+		return err
+	}
+	if err := verification.VerifyAssetRegistry([]CompletedProof{topLevelProof}); err != nil {
+		return err
+	}
+	if err := verification.VerifyProofWithKey(topLevelProof, verificationKey, BACKEND_GROTH16_RECURSIVE); err != nil {
+		return fmt.Errorf("top level proof verification failed: %w", err)
+	}
+	return verification.VerifyTopLayerProofMatchesAssetSum(topLevelProof, config)
+}
 
-	// verify top layer asset sum (encoded in MerkleRootWithAssetSumHash) matches the published asset sum
-	panicOnError(
-		verifyTopLayerProofMatchesAssetSum(*topProof),
-		"top layer hashed asset sum does not match published asset sum",
-	)
+// pinnedTopLevelVerificationKey returns the verification key pinned for the top layer that the top level proof is
+// verified with, which must be of the BACKEND_GROTH16_RECURSIVE backend for the proof to verify its lower level proofs.
+func pinnedTopLevelVerificationKey(topLevelProof CompletedProof) (string, error) {
+	pinnedVerificationKeysMutex.RLock()
+	verificationKey, backend, err := pinnedVerificationKey(topLevelProof, topLevelLayer.name)
+	pinnedVerificationKeysMutex.RUnlock()
+	if err != nil {
+		return "", fmt.Errorf("top level proof verification failed: %w", err)
+	}
+	if backend != BACKEND_GROTH16_RECURSIVE {
+		return "", fmt.Errorf("the pinned verification key of the top level proof is of the %s backend, whose top level proofs do not verify their lower level proofs, the round must be verified in full", backend)
+	}
+	return verificationKey, nil
+}
+
+// SetVerificationWorkers sets the number of batches VerifyFull verifies concurrently: the proofs, merkle nodes, and
+// merkle paths of their bottom level proofs, and the inclusion of their accounts (1 by default, which verifies the
+// batches one at a time). Whatever the number of workers, verification fails with the first failure of the first
+// batch that fails, in the order of the batches.
+func SetVerificationWorkers(workers int) error {
+	if workers < 1 {
+		return fmt.Errorf("number of verification workers must be at least 1, got %d", workers)
+	}
+	return updateSettings(func(s *settings) error {
+		s.verificationWorkers = workers
+		return nil
+	})
+}
+
+func GetVerificationWorkers() int {
+	return getSettings().verificationWorkers
+}
+
+// verifyInParallel calls verify for every batch index from 0 to count on up to verificationWorkers goroutines, and
+// returns the error of the first batch that fails, in the order of the batches (a panic in a batch being its error),
+// whatever the number of workers. Once a batch fails, the batches after it are skipped, while those before it are
+// still verified, so the result does not depend on which batches were verified first. A runtime error in a batch is
+// a bug, and panics again once the batches being verified are done.
+func verifyInParallel(count int, verify func(i int) error) error {
+	errs := make([]error, count)
+	runtimeErrors := make([]runtime.Error, count)
+	var firstFailure atomic.Int64
+	firstFailure.Store(int64(count))
+	verifyBatch := func(i int) {
+		if int64(i) > firstFailure.Load() {
+			return
+		}
+		errs[i] = func() (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					// runtime errors are bugs, which panic again on the calling goroutine below
+					if runtimeError, ok := r.(runtime.Error); ok {
+						runtimeErrors[i] = runtimeError
+					}
+					if panicErr, ok := r.(error); ok {
+						err = panicErr
+					} else {
+						err = fmt.Errorf("%v", r)
+					}
+				}
+			}()
+			return verify(i)
+		}()
+		for errs[i] != nil {
+			failure := firstFailure.Load()
+			if int64(i) >= failure || firstFailure.CompareAndSwap(failure, int64(i)) {
+				break
+			}
+		}
+	}
+
+	workers := min(GetVerificationWorkers(), count)
+	if workers <= 1 {
+		for i := 0; i < count; i++ {
+			verifyBatch(i)
+		}
+	} else {
+		pending := make(chan int, count)
+		for i := 0; i < count; i++ {
+			pending <- i
+		}
+		close(pending)
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range pending {
+					verifyBatch(i)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+	for _, runtimeError := range runtimeErrors {
+		if runtimeError != nil {
+			panic(runtimeError)
+		}
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // verifyFull is used to perform full verification of generated proofs.
 // It verifies that every account is included in one of the bottom level proofs, and that every proof is valid,
 // has a valid Merkle path leading to the upper level proof, and has the correct merkle nodes for its merkle root.
-// It also verifies the published asset sum in the top level proof matches the sum hashed with the merkle root.
+// It also verifies the published asset sum in the top level proof matches the sum hashed with the merkle root,
+// and that it equals the sum of all account balances given.
+// The proofs are given by level, from the bottom level proofs to the top level proof, which is the only proof of the
+// last level.
 // Expects that all the CompletedProofs read will contain MerkleNodes to be verified, and expects accounts to be in batches
 // and in the same order they were fed into the proof generator, both at batch level and individual level.
-func verifyFull(bottomLevelProofs, midLevelProofs []CompletedProof, topLevelProof CompletedProof, accountBatches [][]circuit.GoAccount) {
-
-	// bottom level proofs (verify merkle nodes, proofs, merkle paths)
-	for i, bottomProof := range bottomLevelProofs {
-		panicOnError(
-			verifyBuild(bottomProof.MerkleNodes, bottomProof.MerkleRoot, circuit.TREE_DEPTH),
-			fmt.Sprintf("merkle nodes for bottom level proof %d inconsistent with its merkle root", i),
-		)
-		panicOnError(verifyProof(bottomProof), fmt.Sprintf("circuit verification failed for bottom level proof %d", i))
-		panicOnError(
-			verifyMerklePath(
-				bottomProof.MerkleRootWithAssetSumHash,
-				bottomProof.MerklePosition,
-				bottomProof.MerklePath,
-				midLevelProofs[i/circuit.ACCOUNTS_PER_BATCH].MerkleRoot,
-			),
-			fmt.Sprintf("merkle path verification failed for bottom level proof %d", i),
-		)
-	}
-
-	// mid level proofs (verify proofs, merkle paths)
-	for i, middleProof := range midLevelProofs {
-		panicOnError(verifyProof(middleProof), fmt.Sprintf("circuit verification failed for mid level proof %d", i))
-		panicOnError(
-			verifyMerklePath(middleProof.MerkleRootWithAssetSumHash, middleProof.MerklePosition, middleProof.MerklePath, topLevelProof.MerkleRoot),
-			fmt.Sprintf("merkle path verification failed for mid level proof %d", i),
-		)
+func verifyFull(levelProofs [][]CompletedProof, accountBatches [][]circuit.GoAccount) {
+	checkFull(&verificationChecks{}, levelProofs, accountBatches, circuit.GetConfig())
+}
+
+// checkFull runs the checks of verifyFull with the given checks, for proofs of the configuration.
+func checkFull(checks *verificationChecks, levelProofs [][]CompletedProof, accountBatches [][]circuit.GoAccount, config circuit.Config) {
+	// check the layers have the expected sizes, so a missing proof is reported instead of an index out of range
+	if len(levelProofs) < MIN_HIERARCHY_LEVELS {
+		checks.fail(fmt.Sprintf("expected at least %d levels of proofs, found %d", MIN_HIERARCHY_LEVELS, len(levelProofs)), roundFailure(VERIFY_CHECK_PROOF_COUNT))
+		return
 	}
+	bottomLevelProofs := levelProofs[0]
+	plan := LayerPlan{}.withConfig(config).withLevels(len(bottomLevelProofs), len(levelProofs))
+	for level := 1; level < plan.Levels(); level++ {
+		if len(levelProofs[level]) != plan.LevelProofCount(level) {
+			message := fmt.Sprintf("expected %d %s level proofs for %d bottom level proofs, found %d",
+				plan.LevelProofCount(level), levelLayer(level, plan.Levels()).name, len(bottomLevelProofs), len(levelProofs[level]))
+			checks.fail(message, roundFailure(VERIFY_CHECK_PROOF_COUNT))
+			return
+		}
+	}
+	topLevelProof := levelProofs[plan.Levels()-1][0]
+	topLayer := levelLayer(plan.Levels()-1, plan.Levels())
 
-	// top level proof
-	panicOnError(verifyProof(topLevelProof), "top level proof circuit verification failed")
-
-	// verify account inclusion
-	for i, batch := range accountBatches {
-		for j, account := range batch {
-			accountHash := circuit.GoComputeMiMCHashForAccount(account)
-			if !bytes.Equal(accountHash, bottomLevelProofs[i].MerkleNodes[circuit.TREE_DEPTH][j]) {
-				panic(fmt.Sprintf("account %d of batch %d not found in bottom level proofs (or accounts not given in the order given to prover)", j, i))
+	// check every proof is bound to the same snapshot
+	allProofs := make([]CompletedProof, 0)
+	for _, proofs := range levelProofs {
+		allProofs = append(allProofs, proofs...)
+	}
+	_, err := verification.ProofsEpoch(allProofs)
+	checks.check(err, "epoch verification failed", roundFailure(VERIFY_CHECK_PROOF_PARAMETERS))
+
+	// bottom level proofs (verify merkle nodes, proofs, merkle paths, account counts), by as many workers as configured
+	// (see SetVerificationWorkers)
+	midLevelProofs := levelProofs[1]
+	validMerkleNodes := make([]bool, len(bottomLevelProofs))
+	checks.inParallel(len(bottomLevelProofs), func(i int, checks *verificationChecks) {
+		bottomProof := bottomLevelProofs[i]
+		midLevelProof := midLevelProofs[plan.MidLevelProofIndex(i)]
+		if i < len(accountBatches) {
+			checks.check(
+				verifyAccountCount(bottomProof, len(accountBatches[i])),
+				fmt.Sprintf("account count verification failed for bottom level proof %d", i),
+				batchFailure(VERIFY_CHECK_ACCOUNT_COUNT, i),
+			)
+		}
+		validMerkleNodes[i] = checkBottomLevelProof(checks, i, bottomProof, midLevelProof, config)
+	})
+
+	checkUpperLevelProofs(checks, plan, levelProofs, config)
+
+	// verify account inclusion, by as many workers as the bottom level proofs (in the bottom level proofs whose merkle
+	// nodes are valid, as the others have no leaves to compare the accounts with)
+	checks.inParallel(len(accountBatches), func(i int, checks *verificationChecks) {
+		batch := accountBatches[i]
+		checks.check(verifyDistinctWalletIds(batch), fmt.Sprintf("duplicate accounts in batch %d", i), batchFailure(VERIFY_CHECK_DUPLICATE_ACCOUNTS, i))
+		if i >= len(bottomLevelProofs) {
+			checks.fail(fmt.Sprintf("batch %d has no bottom level proof, found %d", i, len(bottomLevelProofs)), batchFailure(VERIFY_CHECK_ACCOUNT_INCLUSION, i))
+			return
+		}
+		if validMerkleNodes[i] {
+			checkAccountInclusion(checks, i, batch, bottomLevelProofs[i], config)
+		}
+	})
+
+	// verify no user is in more than one batch, if configured (see Options.CrossBatchDuplicateUsers)
+	checkCrossBatchDuplicateUsers(checks, GetOptions().CrossBatchDuplicateUsers, accountBatches)
+
+	checkTopLevelAssetSum(checks, topLevelProof, config)
+
+	// verify the published asset sum is the sum of every account balance across all batches
+	checks.check(
+		verifyAccountsSumToAssetSum(accountBatches, topLevelProof.AssetSum, config),
+		"top layer published asset sum does not match the sum of all account balances",
+		proofFailure(VERIFY_CHECK_ASSET_SUM, topLayer, 0),
+	)
+}
+
+// checkBottomLevelProof runs the checks of the bottom level proof of batch i, whose merkle path leads to the root of
+// the mid level proof: its merkle nodes, circuit, balance range, and merkle path. Returns whether
+// its merkle nodes are valid, so its leaves can be compared with the accounts of the batch.
+func checkBottomLevelProof(checks *verificationChecks, i int, bottomProof CompletedProof, midLevelProof CompletedProof, config circuit.Config) bool {
+	validMerkleNodes := checks.check(
+		verifyBuild(bottomProof.MerkleNodes, bottomProof.MerkleRoot, config),
+		fmt.Sprintf("merkle nodes for bottom level proof %d inconsistent with its merkle root", i),
+		batchFailure(VERIFY_CHECK_MERKLE_NODES, i),
+	)
+	checks.check(verifyProof(bottomProof, bottomLevelLayer.name), fmt.Sprintf("circuit verification failed for bottom level proof %d", i), batchFailure(VERIFY_CHECK_CIRCUIT, i))
+	checks.check(
+		verification.VerifyBalanceBits(bottomProof, midLevelProof),
+		fmt.Sprintf("balance range verification failed for bottom level proof %d", i),
+		batchFailure(VERIFY_CHECK_BALANCE_RANGE, i),
+	)
+	err := verification.VerifyMerklePath(bottomProof.MerkleRootWithAssetSumHash, bottomProof.MerklePosition, bottomProof.MerklePath, midLevelProof.MerkleRoot, config)
+	if err != nil {
+		failure := merklePathFailure(batchFailure(VERIFY_CHECK_MERKLE_PATH, i), bottomProof, midLevelProof, config)
+		checks.check(err, fmt.Sprintf("merkle path verification failed for bottom level proof %d", i), failure)
+	}
+	return validMerkleNodes
+}
+
+// checkUpperLevelProofs runs the checks of the proofs of the levels above the bottom level of a round with the plan,
+// given by level as to checkFull (its bottom level proofs are not used): their account counts, circuits, balance
+// ranges, and merkle paths.
+func checkUpperLevelProofs(checks *verificationChecks, plan LayerPlan, levelProofs [][]CompletedProof, config circuit.Config) {
+	topLevelProof := levelProofs[plan.Levels()-1][0]
+	topLayer := levelLayer(plan.Levels()-1, plan.Levels())
+
+	// mid and intermediate level proofs (verify proofs, merkle paths, and that each covers its lower level proofs)
+	for level := 1; level < plan.Levels()-1; level++ {
+		layer := levelLayer(level, plan.Levels())
+		for i, proof := range levelProofs[level] {
+			upperProof := levelProofs[level+1][plan.UpperLevelProofIndex(i)]
+			checks.check(
+				verifyAccountCount(proof, plan.LevelProofSize(level, i)),
+				fmt.Sprintf("account count verification failed for %s level proof %d", layer.name, i),
+				proofFailure(VERIFY_CHECK_ACCOUNT_COUNT, layer, i),
+			)
+			checks.check(verifyProof(proof, layer.name), fmt.Sprintf("circuit verification failed for %s level proof %d", layer.name, i), proofFailure(VERIFY_CHECK_CIRCUIT, layer, i))
+			checks.check(
+				verification.VerifyBalanceBits(proof, upperProof),
+				fmt.Sprintf("balance range verification failed for %s level proof %d", layer.name, i),
+				proofFailure(VERIFY_CHECK_BALANCE_RANGE, layer, i),
+			)
+			err := verification.VerifyMerklePath(proof.MerkleRootWithAssetSumHash, proof.MerklePosition, proof.MerklePath, upperProof.MerkleRoot, config)
+			if err != nil {
+				failure := merklePathFailure(proofFailure(VERIFY_CHECK_MERKLE_PATH, layer, i), proof, upperProof, config)
+				checks.check(err, fmt.Sprintf("merkle path verification failed for %s level proof %d", layer.name, i), failure)
 			}
 		}
 	}
 
-	// verify top layer asset sum (encoded in MerkleRootWithAssetSumHash) matches the published asset sum
-	panicOnError(verifyTopLayerProofMatchesAssetSum(topLevelProof), "top layer hashed asset sum does not match published asset sum")
+	// top level proof
+	checks.check(
+		verifyAccountCount(topLevelProof, len(levelProofs[plan.Levels()-2])),
+		"account count verification failed for top level proof",
+		proofFailure(VERIFY_CHECK_ACCOUNT_COUNT, topLayer, 0),
+	)
+	checks.check(verifyProof(topLevelProof, topLayer.name), "top level proof circuit verification failed", proofFailure(VERIFY_CHECK_CIRCUIT, topLayer, 0))
+}
+
+// checkAccountInclusion checks the accounts of batch i are the leaves of its bottom level proof, whose merkle nodes are
+// valid, in order, and the leaves past them are padding.
+func checkAccountInclusion(checks *verificationChecks, i int, batch []circuit.GoAccount, bottomProof CompletedProof, config circuit.Config) {
+	leaves := bottomProof.MerkleNodes[config.TreeDepth]
+	if len(batch) > len(leaves) {
+		checks.fail(fmt.Sprintf("expected at most %d accounts in batch %d, found %d", len(leaves), i, len(batch)), batchFailure(VERIFY_CHECK_ACCOUNT_INCLUSION, i))
+		return
+	}
+	for j, accountHash := range config.GoComputeMiMCHashesForAccounts(batch) {
+		if !bytes.Equal(accountHash, leaves[j]) {
+			checks.fail(
+				fmt.Sprintf("account %d of batch %d not found in bottom level proofs (or accounts not given in the order given to prover)", j, i),
+				accountFailure(VERIFY_CHECK_ACCOUNT_INCLUSION, i, j, accountHash, leaves[j]),
+			)
+		}
+	}
+	// the leaves past the accounts of a partial batch must be padding, whether or not its circuit was padded
+	for j := len(batch); j < len(leaves); j++ {
+		if !bytes.Equal(leaves[j], circuit.GoPaddingLeaf()) {
+			checks.fail(
+				fmt.Sprintf("leaf %d of bottom level proof %d is past the %d accounts of batch %d, but is not padding", j, i, len(batch), i),
+				accountFailure(VERIFY_CHECK_ACCOUNT_INCLUSION, i, j, circuit.GoPaddingLeaf(), leaves[j]),
+			)
+		}
+	}
+}
+
+// checkTopLevelAssetSum checks the asset sum published in the top level proof is the sum hashed in its
+// MerkleRootWithAssetSumHash.
+func checkTopLevelAssetSum(checks *verificationChecks, topLevelProof CompletedProof, config circuit.Config) {
+	if err := verification.VerifyTopLayerProofMatchesAssetSum(topLevelProof, config); err != nil {
+		failure := proofFailure(VERIFY_CHECK_ASSET_SUM, topLevelLayer, 0)
+		if topLevelProof.AssetSum != nil {
+			failure.Expected = config.GoComputeRootWithAssetSumHash(topLevelProof.MerkleRoot, *topLevelProof.AssetSum, verification.EpochOf(topLevelProof))
+			failure.Actual = topLevelProof.MerkleRootWithAssetSumHash
+		}
+		checks.check(err, "top layer hashed asset sum does not match published asset sum", failure)
+	}
+}
+
+// merklePathFailure returns the failure of the merkle path check of the proof, with the merkle root of the upper level
+// proof it should lead to, and the root it leads to, if any.
+func merklePathFailure(failure VerificationFailure, proof CompletedProof, upperProof CompletedProof, config circuit.Config) VerificationFailure {
+	failure.Expected = upperProof.MerkleRoot
+	failure.Actual, _ = verification.ComputeMerklePathRoot(proof.MerkleRootWithAssetSumHash, proof.MerklePosition, proof.MerklePath, config)
+	return failure
+}
+
+// verifyDeploymentDomain verifies that the round was generated for the configured deployment domain, so proofs of
+// another deployment (e.g. testnet) are rejected with a clear error rather than a hash mismatch.
+func verifyDeploymentDomain(metadata RoundMetadata) error {
+	if metadata.DeploymentDomain != circuit.GetDeploymentDomain() {
+		return fmt.Errorf("round was generated for deployment domain %q, expected %q", metadata.DeploymentDomain, circuit.GetDeploymentDomain())
+	}
+	return nil
+}
+
+// verifyAccountCount verifies that the proof covers the given number of accounts (or lower level proofs). Proofs
+// that do not record an account count pass.
+func verifyAccountCount(proof CompletedProof, count int) error {
+	if proof.AccountCount != nil && *proof.AccountCount != count {
+		return fmt.Errorf("proof covers %d accounts, expected %d", *proof.AccountCount, count)
+	}
+	return nil
+}
+
+// verifyDistinctWalletIds verifies that no two accounts of the batch have the same WalletId, as an account included
+// twice would be shown to its user twice. The circuit does not enforce this (comparing every pair of accounts would
+// need quadratically many constraints), so the prover and the full verifier check it.
+func verifyDistinctWalletIds(accounts []circuit.GoAccount) error {
+	positions := make(map[string]int, len(accounts))
+	for i, account := range accounts {
+		walletId := string(account.WalletId)
+		if j, ok := positions[walletId]; ok {
+			return fmt.Errorf("accounts %d and %d have the same WalletId", j, i)
+		}
+		positions[walletId] = i
+	}
+	return nil
+}
+
+// verifyAssetSubsetMatchesMetadata verifies the proofs were generated with the asset subset the round metadata
+// publishes, so a round can't claim to prove the liabilities in more assets than its proofs do.
+func verifyAssetSubsetMatchesMetadata(assetSubset []int, metadata RoundMetadata) error {
+	if !slices.Equal(assetSubset, metadata.AssetSubset) {
+		return fmt.Errorf("the round metadata claims asset subset %v, but the proofs have asset subset %v", metadata.AssetSubset, assetSubset)
+	}
+	return nil
 }
 
 // VerifyFull should primarily be used to perform a full verification of the proofs after running prover.
 // Is a wrapper around the private verifyFull and uses hardcoded file names to read the proofs and accounts from disk.
+// If there is an exclusion list, it also verifies the list matches the round metadata and that no excluded account
+// was included in the bottom level proofs. If the round has a sparse Merkle tree (see Options.BuildSparseTree), it also
+// verifies its root is the root of the tree of the proven accounts. The round of Options.ProofEpoch is read from its directory of
+// outDir (see Options.OutputLayout). Panics if verification fails (see VerifyFullWithError).
 func VerifyFull(batchCount int, outDir string) {
+	panicOnStageError(VerifyFullWithError(batchCount, outDir))
+}
 
-	// read accounts
-	proofElements := ReadDataFromFiles[ProofElements](batchCount, outDir+SECRET_DATA_PREFIX)
+// checkRound runs the checks of VerifyFull with the given checks. The checks the others depend on end verification if
+// they fail. Returns a *VerificationError if a check that isn't collected fails, or the round can't be read.
+func checkRound(checks *verificationChecks, batchCount int, outDir string) (err error) {
+	defer recoverVerificationError(&err)
+	options := GetOptions()
+	outDir = options.OutputLayout.RoundDir(outDir)
+
+	// use the tree depth the top level proof was generated with, which the layer plan depends on
+	topLevelProof := readPublishedProof(outDir, topLevelLayer, 0)
+	treeDepth, err := verification.ProofsTreeDepth([]CompletedProof{topLevelProof})
+	if !checks.check(err, "tree depth verification failed", roundFailure(VERIFY_CHECK_PROOF_PARAMETERS)) {
+		return nil
+	}
+	config := circuit.GetConfig()
+	config.TreeDepth = treeDepth
+
+	// check the number of batches against the round before reading the batches, which is read with the number of
+	// levels it was generated with
+	metadata := readRoundMetadata(outDir)
+	plan := roundLayerPlan(batchCount, metadata, config)
+	if !checks.check(plan.Validate(), "invalid number of batches", roundFailure(VERIFY_CHECK_ROUND_METADATA)) ||
+		!checks.check(verifyLayerPlanMatchesMetadata(plan, metadata), "layer plan verification failed", roundFailure(VERIFY_CHECK_ROUND_METADATA)) ||
+		!checks.check(verifyTreeDepth(metadata, config), "tree depth verification failed", roundFailure(VERIFY_CHECK_ROUND_METADATA)) {
+		return nil
+	}
+
+	// read proofs from files, and check they all belong to the round before any other verification
+	bottomLevelProofs := readBottomLevelProofs(batchCount, outDir)
+	levelProofs := append(append([][]CompletedProof{bottomLevelProofs}, readMidLevelProofs(plan, outDir)...), []CompletedProof{topLevelProof})
+	allProofs := make([]CompletedProof, 0)
+	for level, proofs := range levelProofs {
+		checks.check(verification.VerifyProofsBelongToRound(metadata.RoundId, levelLayer(level, plan.Levels()).name, proofs), "round id verification failed", roundFailure(VERIFY_CHECK_ROUND_ID))
+		allProofs = append(allProofs, proofs...)
+	}
+
+	config, assetSubset, ok := checkRoundProofsParameters(checks, allProofs, metadata)
+	if !ok {
+		return nil
+	}
+
+	// read accounts, restricted to the assets of the asset subset (and with the accounts of each user merged) the same
+	// way the prover did
+	proofElements := ReadDataFromFiles[ProofElements](batchCount, outDir+options.OutputLayout.secretDataPrefix())
+	applyAssetSubset(proofElements, assetSubset)
+	if metadata.AggregatedUserAccounts {
+		applyAccountAggregation(proofElements, config)
+	}
+	unfilteredAccounts := make([][]circuit.GoAccount, batchCount)
+	for i, proofElement := range proofElements {
+		unfilteredAccounts[i] = proofElement.Accounts
+	}
+
+	// remove excluded accounts the same way the prover did
+	exclusions := readExclusionList(outDir)
+	checks.check(verifyDeploymentDomain(metadata), "deployment domain verification failed", roundFailure(VERIFY_CHECK_ROUND_METADATA))
+	checks.check(verifyExclusionListMatchesMetadata(exclusions, metadata), "exclusion list verification failed", roundFailure(VERIFY_CHECK_ROUND_METADATA))
+	checks.check(verifyProvenance(batchCount, outDir, metadata, true, config), "provenance verification failed", roundFailure(VERIFY_CHECK_PROVENANCE))
+	checks.check(verifyManifest(batchCount, outDir, metadata, topLevelProof, config), "manifest verification failed", roundFailure(VERIFY_CHECK_MANIFEST))
+	checks.check(verifyAssetSumSummary(outDir, topLevelProof, assetSubset), "asset sum summary verification failed", roundFailure(VERIFY_CHECK_ASSET_SUM_SUMMARY))
+	checks.check(verifySuccinctRoundProof(batchCount, outDir, metadata, topLevelProof), "succinct round proof verification failed", roundFailure(VERIFY_CHECK_SUCCINCT_PROOF))
+	excludedAccounts := applyExclusionList(proofElements, 0, exclusions)
+	checks.check(verifyExcludedAccountCount(len(excludedAccounts), metadata), "exclusion list verification failed", roundFailure(VERIFY_CHECK_ROUND_METADATA))
+	readDummyAccountPadding(proofElements, 0, metadata.DummyAccountPadding, outDir)
 	accounts := make([][]circuit.GoAccount, batchCount)
 	for i, proofElement := range proofElements {
 		accounts[i] = proofElement.Accounts
 	}
 
-	// read proofs from files
-	bottomLevelProofs := ReadDataFromFiles[CompletedProof](batchCount, outDir+BOTTOM_PROOF_PREFIX)
-	// the number of mid level proofs is ceil(batchCount / ACCOUNTS_PER_BATCH),
-	midLevelProofs := ReadDataFromFiles[CompletedProof]((batchCount+circuit.ACCOUNTS_PER_BATCH-1)/circuit.ACCOUNTS_PER_BATCH, outDir+MIDDLE_PROOF_PREFIX)
-	topLevelProof := ReadDataFromFiles[CompletedProof](1, outDir+TOP_PROOF_PREFIX)[0]
+	// rebuild the merkle nodes the publication policy kept out of the bottom level proofs without a secret copy
+	for i := range bottomLevelProofs {
+		if !hasAllMerkleNodes(bottomLevelProofs[i], config) {
+			var rebuilt CompletedProof
+			rebuilt, err = RebuildMerkleNodes(bottomLevelProofs[i], accounts[i])
+			if checks.check(err, fmt.Sprintf("error rebuilding the merkle nodes of bottom level proof %d", i), batchFailure(VERIFY_CHECK_MERKLE_NODES, i)) {
+				bottomLevelProofs[i] = rebuilt
+			}
+		}
+	}
 
 	// verify
-	verifyFull(bottomLevelProofs, midLevelProofs, topLevelProof, accounts)
+	checkFull(checks, levelProofs, accounts, config)
+	checks.check(
+		verifyExcludedAccountsNotIncluded(bottomLevelProofs, unfilteredAccounts, exclusions, config),
+		"excluded account verification failed",
+		roundFailure(VERIFY_CHECK_EXCLUDED_ACCOUNTS),
+	)
+	checks.check(verifySparseTreeRoot(accounts, metadata.SparseTreeRoot, config), "sparse merkle tree verification failed", roundFailure(VERIFY_CHECK_SPARSE_TREE))
+	return nil
+}
+
+// checkRoundProofsParameters checks the proofs of the round agree on the hash function, hash version, account bytes
+// version, leaf hash mode, tree depth, asset registry, and asset subset they were generated with, and returns the
+// configuration they were generated with (see verification.ProofsConfig) and their asset subset. Returns false if a
+// check the others depend on failed.
+func checkRoundProofsParameters(checks *verificationChecks, allProofs []CompletedProof, metadata RoundMetadata) (config circuit.Config, assetSubset []int, ok bool) {
+	config, err := verification.ProofsConfig(allProofs)
+	if !checks.check(err, "proof parameters verification failed", roundFailure(VERIFY_CHECK_PROOF_PARAMETERS)) ||
+		!checks.check(verification.VerifyAssetRegistry(allProofs), "asset registry verification failed", roundFailure(VERIFY_CHECK_PROOF_PARAMETERS)) {
+		return circuit.Config{}, nil, false
+	}
+	assetSubset, err = verification.ProofsAssetSubset(allProofs)
+	if !checks.check(err, "asset subset verification failed", roundFailure(VERIFY_CHECK_PROOF_PARAMETERS)) {
+		return circuit.Config{}, nil, false
+	}
+	checks.check(verifyAssetSubsetMatchesMetadata(assetSubset, metadata), "asset subset verification failed", roundFailure(VERIFY_CHECK_ROUND_METADATA))
+	return config, assetSubset, true
 }
@@ -1,12 +1,17 @@
 package core
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"math/big"
 	"os"
+	"slices"
+	"strings"
 	"testing"
 
 	"bitgo.com/proof_of_reserves/circuit"
+	"bitgo.com/proof_of_reserves/verification"
 	"github.com/consensys/gnark-crypto/ecc/bn254/fr/mimc"
 	"github.com/consensys/gnark/test"
 )
@@ -18,70 +23,95 @@ const countPerBatch = 16
 var proofLower0, proofLower1, proofMid, proofTop, altProofLower0, altProofMid, altProofTop CompletedProof
 var testData0, testData1, altTestData0 ProofElements
 
+// testOutDir and testAltDir are the directories of the rounds TestMain generates, in a temporary directory so that
+// running the tests leaves no output in the package.
+var testOutDir, testAltDir string
+
 // TestMain sets up the test environment by generating test data and proofs once
 // for all tests to use.
 func TestMain(m *testing.M) {
-	// clean up out and alt directory before running tests
-	os.RemoveAll("out")
-	os.RemoveAll("alt")
+	testDir, err := os.MkdirTemp("", "proof_of_reserves_core_test")
+	panicOnError(err, "failed to create test directory")
+	testOutDir = testDir + "/out/"
+	testAltDir = testDir + "/alt/"
 
 	// create out and alt directory structure
-	panicOnError(os.MkdirAll("out/secret", 0755), "failed to create out/secret directory")
-	panicOnError(os.MkdirAll("out/public", 0755), "failed to create out/public directory")
-	panicOnError(os.MkdirAll("alt/secret", 0755), "failed to create alt/secret directory")
-	panicOnError(os.MkdirAll("alt/public", 0755), "failed to create alt/public directory")
+	panicOnError(os.MkdirAll(testOutDir+"secret", 0755), "failed to create out/secret directory")
+	panicOnError(os.MkdirAll(testOutDir+"public", 0755), "failed to create out/public directory")
+	panicOnError(os.MkdirAll(testAltDir+"secret", 0755), "failed to create alt/secret directory")
+	panicOnError(os.MkdirAll(testAltDir+"public", 0755), "failed to create alt/public directory")
 
 	// create testutildata directory
 	panicOnError(os.MkdirAll("testutildata", 0o755), "failed to create testutildata directory")
 
 	// generate test data and proofs in out directory
-	GenerateData(batchCount, countPerBatch, OUT_DIR)
-	Prove(batchCount, OUT_DIR)
+	GenerateData(batchCount, countPerBatch, testOutDir)
+	Prove(batchCount, testOutDir)
 
 	// generate test data and proofs in alt directory
-	GenerateData(1, countPerBatch, "alt/")
-	Prove(1, "alt/")
+	GenerateData(1, countPerBatch, testAltDir)
+	Prove(1, testAltDir)
 
 	// read generated proofs and test data files from out directory
-	proofLower0 = ReadDataFromFile[CompletedProof](OUT_DIR + BOTTOM_PROOF_PREFIX + "0.json")
-	proofLower1 = ReadDataFromFile[CompletedProof](OUT_DIR + BOTTOM_PROOF_PREFIX + "1.json")
-	proofMid = ReadDataFromFile[CompletedProof](OUT_DIR + MIDDLE_PROOF_PREFIX + "0.json")
-	proofTop = ReadDataFromFile[CompletedProof](OUT_DIR + TOP_PROOF_PREFIX + "0.json")
-	testData0 = ReadDataFromFile[ProofElements](OUT_DIR + SECRET_DATA_PREFIX + "0.json")
-	testData1 = ReadDataFromFile[ProofElements](OUT_DIR + SECRET_DATA_PREFIX + "1.json")
+	proofLower0 = ReadDataFromFile[CompletedProof](testOutDir + BOTTOM_PROOF_PREFIX + "0.json")
+	proofLower1 = ReadDataFromFile[CompletedProof](testOutDir + BOTTOM_PROOF_PREFIX + "1.json")
+	proofMid = ReadDataFromFile[CompletedProof](testOutDir + MIDDLE_PROOF_PREFIX + "0.json")
+	proofTop = ReadDataFromFile[CompletedProof](testOutDir + TOP_PROOF_PREFIX + "0.json")
+	testData0 = ReadDataFromFile[ProofElements](testOutDir + SECRET_DATA_PREFIX + "0.json")
+	testData1 = ReadDataFromFile[ProofElements](testOutDir + SECRET_DATA_PREFIX + "1.json")
 
 	// read generated proofs and test data files from alt directory
-	altProofLower0 = ReadDataFromFile[CompletedProof]("alt/" + BOTTOM_PROOF_PREFIX + "0.json")
-	altProofMid = ReadDataFromFile[CompletedProof]("alt/" + MIDDLE_PROOF_PREFIX + "0.json")
-	altProofTop = ReadDataFromFile[CompletedProof]("alt/" + TOP_PROOF_PREFIX + "0.json")
-	altTestData0 = ReadDataFromFile[ProofElements]("alt/" + SECRET_DATA_PREFIX + "0.json")
+	altProofLower0 = ReadDataFromFile[CompletedProof](testAltDir + BOTTOM_PROOF_PREFIX + "0.json")
+	altProofMid = ReadDataFromFile[CompletedProof](testAltDir + MIDDLE_PROOF_PREFIX + "0.json")
+	altProofTop = ReadDataFromFile[CompletedProof](testAltDir + TOP_PROOF_PREFIX + "0.json")
+	altTestData0 = ReadDataFromFile[ProofElements](testAltDir + SECRET_DATA_PREFIX + "0.json")
 
 	// run tests
 	exitCode := m.Run()
+	os.RemoveAll(testDir)
 
 	// exit with test status code
 	os.Exit(exitCode)
 }
 
+// verifyGeneratedProof verifies a proof of the layer the test generated without writing it, whose key it pins first
+// like the prover pins the keys of the proofs it writes.
+func verifyGeneratedProof(proof CompletedProof, layer string) error {
+	pinProvenVerificationKey(proof, layer)
+	return verifyProof(proof, layer)
+}
+
+// unpinVerificationKeys clears the pinned verification keys for the test, and restores them once it is done.
+func unpinVerificationKeys(t *testing.T) {
+	pinned := pinnedVerificationKeys
+	pinnedVerificationKeys = nil
+	t.Cleanup(func() { pinnedVerificationKeys = pinned })
+}
+
 func TestVerifyProofPasses(t *testing.T) {
+	// proofs record the circuit version they were generated with
+	if proofLower0.CircuitVersion != circuit.CIRCUIT_VERSION {
+		t.Errorf("expected lower proof 0 to record circuit version %d, got %d", circuit.CIRCUIT_VERSION, proofLower0.CircuitVersion)
+	}
+
 	// should return nil for valid proofs
-	if err := verifyProof(proofLower0); err != nil {
+	if err := verifyProof(proofLower0, bottomLevelLayer.name); err != nil {
 		t.Errorf("expected verifyProof to return nil for valid lower proof 0, got error: %v", err)
 	}
-	if err := verifyProof(proofLower1); err != nil {
+	if err := verifyProof(proofLower1, bottomLevelLayer.name); err != nil {
 		t.Errorf("expected verifyProof to return nil for valid lower proof 1, got error: %v", err)
 	}
-	if err := verifyProof(proofMid); err != nil {
+	if err := verifyProof(proofMid, midLevelLayer.name); err != nil {
 		t.Errorf("expected verifyProof to return nil for valid mid proof, got error: %v", err)
 	}
-	if err := verifyProof(proofTop); err != nil {
+	if err := verifyProof(proofTop, topLevelLayer.name); err != nil {
 		t.Errorf("expected verifyProof to return nil for valid top proof, got error: %v", err)
 	}
 
 	// also check with random merkle nodes (should pass)
 	proofLowerModifiedMerkleNodes := proofLower0
 	proofLowerModifiedMerkleNodes.MerkleNodes = [][]Hash{{{0x56, 0x78}}}
-	if err := verifyProof(proofLowerModifiedMerkleNodes); err != nil {
+	if err := verifyProof(proofLowerModifiedMerkleNodes, bottomLevelLayer.name); err != nil {
 		t.Errorf("expected verifyProof to return nil for valid lower proof 0 with random merkle nodes, got error: %v", err)
 	}
 }
@@ -111,6 +141,13 @@ func TestVerifyProofFails(t *testing.T) {
 	modifiedProof := proofLower0
 	modifiedProof.Proof = "AAAA" + modifiedProof.Proof[4:]
 
+	// circuit version the verifier does not support, or removed from the proof (so it would be verified as a proof of
+	// circuit version 6)
+	proofLowerFutureCircuitVersion := proofLower0
+	proofLowerFutureCircuitVersion.CircuitVersion = circuit.CIRCUIT_VERSION + 1
+	proofLowerNoCircuitVersion := proofLower0
+	proofLowerNoCircuitVersion.CircuitVersion = 0
+
 	// test cases
 	tests := []struct {
 		name  string
@@ -121,17 +158,35 @@ func TestVerifyProofFails(t *testing.T) {
 		{"Invalid merkle root with asset sum hash", proofLowerModifiedMerkleRootAssetSumHash},
 		{"Invalid verification key", proofLowerModifiedVK},
 		{"Modified proof string", modifiedProof},
+		{"Unsupported circuit version", proofLowerFutureCircuitVersion},
+		{"Missing circuit version", proofLowerNoCircuitVersion},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if err := verifyProof(tt.proof); err == nil {
+			if err := verifyProof(tt.proof, bottomLevelLayer.name); err == nil {
 				t.Errorf("expected verifyProof to return error for %s", tt.name)
 			}
 		})
 	}
 }
 
+func TestVerifyLegacyProofs(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// a proof that does not record its circuit version is verified with the public inputs of circuit version 6, which
+	// the proof of this circuit version fails, even with its key pinned
+	legacyProof := proofLower0
+	legacyProof.CircuitVersion = 0
+	assert.Error(verifyProof(legacyProof, bottomLevelLayer.name))
+
+	// and it fails without its key pinned, like any other proof
+	unpinVerificationKeys(t)
+	err := verifyProof(legacyProof, bottomLevelLayer.name)
+	assert.Error(err)
+	assert.Contains(err.Error(), "no verification keys are pinned")
+}
+
 func TestVerifyMerklePathPasses(t *testing.T) {
 	// generate valid merkle tree and valid paths for all accounts
 	accounts := testData0.Accounts
@@ -142,8 +197,8 @@ func TestVerifyMerklePathPasses(t *testing.T) {
 	for i := range testData0.Accounts {
 		accountHash := circuit.GoComputeMiMCHashForAccount(accounts[i])
 		accountPath := circuit.ComputeMerklePath(i, merkleNodes)
-		if err := verifyMerklePath(accountHash, i, accountPath, merkleRoot); err != nil {
-			t.Errorf("expected verifyMerklePath to return nil for valid path for account %d, got error: %v", i, err)
+		if err := verification.VerifyMerklePath(accountHash, i, accountPath, merkleRoot, circuit.GetConfig()); err != nil {
+			t.Errorf("expected VerifyMerklePath to return nil for valid path for account %d, got error: %v", i, err)
 		}
 	}
 
@@ -185,8 +240,103 @@ func TestVerifyMerklePathFails(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if err := verifyMerklePath(tt.hash, tt.position, tt.path, tt.root); err == nil {
-				t.Errorf("expected verifyMerklePath to fail for %s", tt.name)
+			if err := verification.VerifyMerklePath(tt.hash, tt.position, tt.path, tt.root, circuit.GetConfig()); err == nil {
+				t.Errorf("expected VerifyMerklePath to fail for %s", tt.name)
+			}
+		})
+	}
+}
+
+func TestVerifyMerklePathElements(t *testing.T) {
+	// generate valid merkle tree and valid paths with sides for all accounts
+	accounts := testData0.Accounts
+	merkleNodes := circuit.GoComputeMerkleTreeNodesFromAccounts(accounts)
+	merkleRoot := merkleNodes[0][0]
+	for i := range accounts {
+		accountHash := circuit.GoComputeMiMCHashForAccount(accounts[i])
+		path := circuit.ComputeMerklePathElements(i, merkleNodes)
+		if err := VerifyMerklePathElements(accountHash, path, merkleRoot); err != nil {
+			t.Errorf("expected VerifyMerklePathElements to return nil for valid path for account %d, got error: %v", i, err)
+		}
+	}
+
+	// generate invalid paths
+	accountHash := circuit.GoComputeMiMCHashForAccount(accounts[0])
+	path := circuit.ComputeMerklePathElements(0, merkleNodes)
+	pathSideFlipped := append([]circuit.MerklePathElement{}, path...)
+	pathSideFlipped[2].IsLeft = true
+	pathSiblingModified := append([]circuit.MerklePathElement{}, path...)
+	pathSiblingModified[0].Sibling = circuit.GoComputeMiMCHashForAccount(accounts[2])
+
+	tests := []struct {
+		name string
+		hash Hash
+		path []circuit.MerklePathElement
+		root Hash
+	}{
+		{"Invalid hash", circuit.GoComputeMiMCHashForAccount(accounts[1]), path, merkleRoot},
+		{"Path too short", accountHash, path[:len(path)-1], merkleRoot},
+		{"Side flipped", accountHash, pathSideFlipped, merkleRoot},
+		{"Sibling modified", accountHash, pathSiblingModified, merkleRoot},
+		{"Invalid root", accountHash, path, Hash{0x90, 0xab, 0xcd, 0xef}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := VerifyMerklePathElements(tt.hash, tt.path, tt.root); err == nil {
+				t.Errorf("expected VerifyMerklePathElements to fail for %s", tt.name)
+			}
+		})
+	}
+}
+
+func TestVerifyMerkleMultiPath(t *testing.T) {
+	// generate valid merkle tree and a valid multi-path for some of the accounts
+	accounts := testData0.Accounts
+	merkleNodes := circuit.GoComputeMerkleTreeNodesFromAccounts(accounts)
+	merkleRoot := merkleNodes[0][0]
+	positions := []int{3, 0, 1}
+	hashes := make([]Hash, len(positions))
+	for i, position := range positions {
+		hashes[i] = circuit.GoComputeMiMCHashForAccount(accounts[position])
+	}
+	path := circuit.ComputeMerkleMultiPath(positions, merkleNodes)
+
+	// make sure passes, and is smaller than the paths of each account
+	if err := VerifyMerkleMultiPath(hashes, positions, path, merkleRoot); err != nil {
+		t.Errorf("expected VerifyMerkleMultiPath to return nil for valid multi-path, got error: %v", err)
+	}
+	if len(path) >= len(positions)*circuit.GetTreeDepth() {
+		t.Errorf("expected multi-path to be smaller than %d individual paths, found %d hashes", len(positions), len(path))
+	}
+
+	// generate invalid multi-paths
+	pathTooShort := path[:len(path)-1]
+	pathTooLong := append(append([]Hash{}, path...), path[0])
+	pathModified := append([]Hash{circuit.GoComputeMiMCHashForAccount(accounts[4])}, path[1:]...)
+	swappedHashes := []Hash{hashes[1], hashes[0], hashes[2]}
+
+	tests := []struct {
+		name      string
+		hashes    []Hash
+		positions []int
+		path      []Hash
+		root      Hash
+	}{
+		{"Path too short", hashes, positions, pathTooShort, merkleRoot},
+		{"Path too long", hashes, positions, pathTooLong, merkleRoot},
+		{"Path modified", hashes, positions, pathModified, merkleRoot},
+		{"Hashes swapped", swappedHashes, positions, path, merkleRoot},
+		{"Missing position", hashes, positions[:2], path, merkleRoot},
+		{"Repeated position", hashes, []int{3, 0, 0}, path, merkleRoot},
+		{"Position out of bounds", hashes, []int{3, 0, circuit.PowOfTwo(circuit.GetTreeDepth())}, path, merkleRoot},
+		{"Invalid root", hashes, positions, path, Hash{0x90, 0xab, 0xcd, 0xef}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := VerifyMerkleMultiPath(tt.hashes, tt.positions, tt.path, tt.root); err == nil {
+				t.Errorf("expected VerifyMerkleMultiPath to fail for %s", tt.name)
 			}
 		})
 	}
@@ -228,7 +378,9 @@ func TestVerifyBuild(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := verifyBuild(tt.nodes, tt.root, tt.depth)
+			config := circuit.GetConfig()
+			config.TreeDepth = tt.depth
+			err := verifyBuild(tt.nodes, tt.root, config)
 			if tt.shouldError && err == nil {
 				t.Errorf("expected verifyBuild to error for test %s, but it didn't.", tt.name)
 			}
@@ -241,14 +393,80 @@ func TestVerifyBuild(t *testing.T) {
 
 func TestVerifyTopLayerProofMatchesAssetSum(t *testing.T) {
 	// the top layer proof should already have a valid asset sum hash and merkle root
-	if err := verifyTopLayerProofMatchesAssetSum(proofTop); err != nil {
-		t.Errorf("expected verifyTopLayerProofMatchesAssetSum to pass for valid proof, got error: %v", err)
+	if err := verification.VerifyTopLayerProofMatchesAssetSum(proofTop, circuit.GetConfig()); err != nil {
+		t.Errorf("expected VerifyTopLayerProofMatchesAssetSum to pass for valid proof, got error: %v", err)
 	}
 
 	// check failure case
 	emptySum := circuit.ConstructGoBalance()
-	if err := verifyTopLayerProofMatchesAssetSum(CompletedProof{MerkleRoot: Hash{0x23, 0x98}, MerkleRootWithAssetSumHash: Hash{0x23, 0x98}, AssetSum: &emptySum}); err == nil {
-		t.Error("expected verifyTopLayerProofMatchesAssetSum to fail for bad proof")
+	if err := verification.VerifyTopLayerProofMatchesAssetSum(CompletedProof{MerkleRoot: Hash{0x23, 0x98}, MerkleRootWithAssetSumHash: Hash{0x23, 0x98}, AssetSum: &emptySum}, circuit.GetConfig()); err == nil {
+		t.Error("expected VerifyTopLayerProofMatchesAssetSum to fail for bad proof")
+	}
+}
+
+func TestVerifyTopLevelProof(t *testing.T) {
+	// top level proofs of the groth16 backend do not attest their lower level proofs
+	if err := VerifyTopLevelProof(proofTop); err == nil {
+		t.Error("expected VerifyTopLevelProof to fail for a proof that does not verify its lower level proofs")
+	}
+
+	// the backend is that of the pinned key, whatever backend the proof records
+	mislabeledProof := proofTop
+	mislabeledProof.Backend = BACKEND_GROTH16_RECURSIVE
+	if err := VerifyTopLevelProof(mislabeledProof); err == nil || !strings.Contains(err.Error(), "do not verify their lower level proofs") {
+		t.Errorf("expected VerifyTopLevelProof to fail for a proof whose pinned key is of the groth16 backend, got: %v", err)
+	}
+
+	// a top level proof of a key pinned for the recursive backend must be a valid recursive proof
+	unpinVerificationKeys(t)
+	if err := VerifyTopLevelProof(proofTop); err == nil || !strings.Contains(err.Error(), "no verification keys are pinned for the top layer") {
+		t.Errorf("expected VerifyTopLevelProof to fail without a pinned key, got: %v", err)
+	}
+	fingerprint, err := ComputeVerificationKeyFingerprint(proofTop.VerificationKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PinVerificationKeyFingerprints(topLevelLayer.name, BACKEND_GROTH16_RECURSIVE, fingerprint); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyTopLevelProof(mislabeledProof); err == nil {
+		t.Error("expected VerifyTopLevelProof to fail for a proof that is not a valid recursive proof")
+	}
+}
+
+func TestVerifyDeploymentDomain(t *testing.T) {
+	defer circuit.SetDeploymentDomain("")
+
+	// the test proofs were generated without a deployment domain
+	metadata := readRoundMetadata(testOutDir)
+	if err := verifyDeploymentDomain(metadata); err != nil {
+		t.Errorf("expected round to match the default deployment domain, got error: %v", err)
+	}
+
+	circuit.SetDeploymentDomain("production")
+	if err := verifyDeploymentDomain(metadata); err == nil {
+		t.Error("expected round without deployment domain to fail for the production domain")
+	}
+	metadata.DeploymentDomain = "production"
+	if err := verifyDeploymentDomain(metadata); err != nil {
+		t.Errorf("expected round to match the production domain, got error: %v", err)
+	}
+}
+
+func TestVerifyAccountsSumToAssetSum(t *testing.T) {
+	accountBatches := [][]circuit.GoAccount{testData0.Accounts, testData1.Accounts}
+
+	// the top layer proof's asset sum should be the sum of all accounts
+	if err := verifyAccountsSumToAssetSum(accountBatches, proofTop.AssetSum, circuit.GetConfig()); err != nil {
+		t.Errorf("expected verifyAccountsSumToAssetSum to pass for all accounts, got error: %v", err)
+	}
+
+	// check failure cases
+	if err := verifyAccountsSumToAssetSum(accountBatches[:1], proofTop.AssetSum, circuit.GetConfig()); err == nil {
+		t.Error("expected verifyAccountsSumToAssetSum to fail when a batch is missing")
+	}
+	if err := verifyAccountsSumToAssetSum(accountBatches, nil, circuit.GetConfig()); err == nil {
+		t.Error("expected verifyAccountsSumToAssetSum to fail for nil asset sum")
 	}
 }
 
@@ -322,8 +540,8 @@ func TestVerifyUser(t *testing.T) {
 			"Invalid balance",
 			UserVerificationElements{
 				AccountInfo: circuit.GoAccount{
-					WalletId:  account.WalletId,
-					Balance: append(circuit.GoBalance{new(big.Int).Add(new(big.Int).Set(account.Balance[0]), big.NewInt(2))}, account.Balance[1:]...),
+					WalletId: account.WalletId,
+					Balance:  append(circuit.GoBalance{new(big.Int).Add(new(big.Int).Set(account.Balance[0]), big.NewInt(2))}, account.Balance[1:]...),
 				},
 				ProofInfo: UserProofInfo{
 					UserMerklePath:     accountMerklePath,
@@ -689,24 +907,569 @@ func TestVerifyFull(t *testing.T) {
 		{"Bad bottom proof merkle path", bottomProofsWithBadPath, validMidProofs, validTopProof, validAccountBatches, true},
 		{"Bad top proof asset sum", validBottomProofs, validMidProofs, topProofWithBadAssetSum, validAccountBatches, true},
 		{"Bad bottom proof merkle nodes", bottomProofsWithBadNodes, validMidProofs, validTopProof, validAccountBatches, true},
+		{"Missing account batch", validBottomProofs, validMidProofs, validTopProof, validAccountBatches[:1], true},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			if tt.shouldPanic {
 				assert.Panics(func() {
-					verifyFull(tt.bottomProofs, tt.midProofs, tt.topProof, tt.accountBatches)
+					verifyFull([][]CompletedProof{tt.bottomProofs, tt.midProofs, {tt.topProof}}, tt.accountBatches)
 				})
 			} else {
 				assert.NotPanics(func() {
-					verifyFull(tt.bottomProofs, tt.midProofs, tt.topProof, tt.accountBatches)
+					verifyFull([][]CompletedProof{tt.bottomProofs, tt.midProofs, {tt.topProof}}, tt.accountBatches)
 				})
 			}
 		})
 	}
 }
 
+func TestVerifyFullWithWorkers(t *testing.T) {
+	assert := test.NewAssert(t)
+	assert.Error(SetVerificationWorkers(0))
+	assert.NoError(SetVerificationWorkers(4))
+	defer func() { assert.NoError(SetVerificationWorkers(1)) }()
+	accountBatches := [][]circuit.GoAccount{testData0.Accounts, testData1.Accounts}
+	assert.NotPanics(func() {
+		verifyFull([][]CompletedProof{{proofLower0, proofLower1}, {proofMid}, {proofTop}}, accountBatches)
+	})
+
+	// the first batch that fails is reported, in batch order, with its first failure
+	bottomProofs := []CompletedProof{proofLower0, proofLower1}
+	for i := range bottomProofs {
+		bottomProofs[i].MerklePath = slices.Clone(bottomProofs[i].MerklePath)
+		bottomProofs[i].MerklePath[0] = []byte{0xde, 0xad, 0xbe, 0xef}
+	}
+	verifyBadPaths := func() (message string) {
+		defer func() { message = fmt.Sprint(recover()) }()
+		verifyFull([][]CompletedProof{bottomProofs, {proofMid}, {proofTop}}, accountBatches)
+		return ""
+	}
+	assert.Equal("merkle path verification failed for bottom level proof 0: merkle proof path verification failed", verifyBadPaths())
+
+	// and so it is with a single worker
+	assert.NoError(SetVerificationWorkers(1))
+	assert.Equal("merkle path verification failed for bottom level proof 0: merkle proof path verification failed", verifyBadPaths())
+}
+
+func TestVerifyInParallel(t *testing.T) {
+	assert := test.NewAssert(t)
+	defer func() { assert.NoError(SetVerificationWorkers(1)) }()
+
+	// whatever the number of workers, the error of the first batch that fails is returned, as it was failed with
+	for _, workers := range []int{1, 4} {
+		assert.NoError(SetVerificationWorkers(workers))
+		err := verifyInParallel(8, func(i int) error {
+			switch i {
+			case 3:
+				return fmt.Errorf("batch %d: %w", i, fs.ErrNotExist)
+			case 5:
+				panicOnError(errors.New("mismatch"), "batch 5")
+			}
+			return nil
+		})
+		assert.EqualError(err, "batch 3: "+fs.ErrNotExist.Error())
+		assert.True(errors.Is(err, fs.ErrNotExist))
+	}
+}
+
 func TestVerifyFullPublic(t *testing.T) {
 	assert := test.NewAssert(t)
-	assert.NotPanics(func() { VerifyFull(batchCount, OUT_DIR) })
+	assert.NotPanics(func() { VerifyFull(batchCount, testOutDir) })
+}
+
+func TestVerifyProofsBelongToRound(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	roundId := readRoundMetadata(testOutDir).RoundId
+	assert.NotEmpty(roundId)
+	assert.NoError(verification.VerifyProofsBelongToRound(roundId, "bottom", []CompletedProof{proofLower0, proofLower1}))
+	assert.Error(verification.VerifyProofsBelongToRound(roundId, "top", []CompletedProof{altProofTop}))
+
+	// rounds generated before proofs were tagged have no round id
+	untaggedProof := proofLower0
+	untaggedProof.RoundId = ""
+	assert.NoError(verification.VerifyProofsBelongToRound("", "bottom", []CompletedProof{untaggedProof}))
+	assert.Error(verification.VerifyProofsBelongToRound(roundId, "bottom", []CompletedProof{untaggedProof}))
+
+	t.Run("Misplaced top level proof", func(t *testing.T) {
+		outDir := t.TempDir() + "/"
+		assert.NoError(os.CopyFS(outDir, os.DirFS(testOutDir)))
+		WriteDataToFile(outDir+TOP_PROOF_PREFIX+"0.json", altProofTop)
+		defer func() {
+			r := recover()
+			assert.NotNil(r, "expected VerifyFull to fail for a top level proof of another round")
+			assert.Contains(fmt.Sprint(r), "artifacts of different rounds are mixed")
+		}()
+		VerifyFull(batchCount, outDir)
+	})
+
+	t.Run("User package with proofs of different rounds", func(t *testing.T) {
+		elements := BuildUserVerificationElements(testData0.Accounts[2], 2, proofLower0, altProofMid, proofTop)
+		assert.Panics(func() { VerifyUser(elements) })
+	})
+}
+
+func TestVerifyRoundWithPoseidon2(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// generate a round with poseidon2, then verify it with the default hash function configured
+	outDir := t.TempDir() + "/"
+	assert.NoError(os.MkdirAll(outDir+"secret", 0o755))
+	assert.NoError(os.MkdirAll(outDir+"public", 0o755))
+	assert.NoError(circuit.SetHashFunction(circuit.HASH_POSEIDON2))
+	GenerateData(1, countPerBatch, outDir)
+	Prove(1, outDir)
+	assert.NoError(circuit.SetHashFunction(circuit.HASH_MIMC))
+
+	bottomProof := ReadDataFromFile[CompletedProof](outDir + BOTTOM_PROOF_PREFIX + "0.json")
+	midProof := ReadDataFromFile[CompletedProof](outDir + MIDDLE_PROOF_PREFIX + "0.json")
+	topProof := ReadDataFromFile[CompletedProof](outDir + TOP_PROOF_PREFIX + "0.json")
+	accounts := ReadDataFromFile[ProofElements](outDir + SECRET_DATA_PREFIX + "0.json").Accounts
+	assert.Equal(circuit.HASH_POSEIDON2, topProof.HashFunction)
+	assert.NotPanics(func() { VerifyFull(1, outDir) })
+	assert.NotPanics(func() { VerifyUser(BuildUserVerificationElements(accounts[0], 0, bottomProof, midProof, topProof)) })
+	assert.Equal(circuit.HASH_MIMC, circuit.GetHashFunction(), "verification should restore the hash function")
+
+	// proofs of different hash functions can't be verified together
+	mixedTopProof := topProof
+	mixedTopProof.HashFunction = circuit.HASH_MIMC
+	defer func() {
+		r := recover()
+		assert.NotNil(r, "expected VerifyUser to fail for proofs of different hash functions")
+		assert.Contains(fmt.Sprint(r), "proofs of different hash functions are mixed")
+	}()
+	VerifyUser(BuildUserVerificationElements(accounts[0], 0, bottomProof, midProof, mixedTopProof))
+}
+
+func TestProofsHashFunction(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// proofs that do not record a hash function used MiMC
+	legacyProof := proofLower0
+	legacyProof.HashFunction = ""
+	hashFunction, err := verification.ProofsHashFunction([]CompletedProof{proofLower0, legacyProof})
+	assert.NoError(err)
+	assert.Equal(circuit.HASH_MIMC, hashFunction)
+
+	poseidon2Proof := proofLower0
+	poseidon2Proof.HashFunction = circuit.HASH_POSEIDON2
+	hashFunction, err = verification.ProofsHashFunction([]CompletedProof{poseidon2Proof})
+	assert.NoError(err)
+	assert.Equal(circuit.HASH_POSEIDON2, hashFunction)
+	_, err = verification.ProofsHashFunction([]CompletedProof{legacyProof, poseidon2Proof})
+	assert.Error(err)
+
+	unknownProof := proofLower0
+	unknownProof.HashFunction = "sha256"
+	_, err = verification.ProofsHashFunction([]CompletedProof{unknownProof})
+	assert.Error(err)
+}
+
+func TestVerifyRoundWithUntaggedHashes(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// generate a round with untagged hashes (like rounds generated before hashes were tagged), then verify it with
+	// tagged hashes configured
+	outDir := t.TempDir() + "/"
+	assert.NoError(os.MkdirAll(outDir+"secret", 0o755))
+	assert.NoError(os.MkdirAll(outDir+"public", 0o755))
+	assert.NoError(circuit.SetHashVersion(circuit.HASH_VERSION_UNTAGGED))
+	GenerateData(1, countPerBatch, outDir)
+	Prove(1, outDir)
+	assert.NoError(circuit.SetHashVersion(circuit.HASH_VERSION_ROOT_TAGGED))
+
+	bottomProof := ReadDataFromFile[CompletedProof](outDir + BOTTOM_PROOF_PREFIX + "0.json")
+	midProof := ReadDataFromFile[CompletedProof](outDir + MIDDLE_PROOF_PREFIX + "0.json")
+	topProof := ReadDataFromFile[CompletedProof](outDir + TOP_PROOF_PREFIX + "0.json")
+	accounts := ReadDataFromFile[ProofElements](outDir + SECRET_DATA_PREFIX + "0.json").Accounts
+	assert.Equal(circuit.HASH_VERSION_UNTAGGED, topProof.HashVersion)
+	assert.Equal(circuit.HASH_VERSION_ROOT_TAGGED, proofTop.HashVersion)
+	assert.NotPanics(func() { VerifyFull(1, outDir) })
+	assert.NotPanics(func() { VerifyUser(BuildUserVerificationElements(accounts[0], 0, bottomProof, midProof, topProof)) })
+	assert.Equal(circuit.HASH_VERSION_ROOT_TAGGED, circuit.GetHashVersion(), "verification should restore the hash version")
+
+	// proofs that do not record a hash version are untagged
+	for _, proof := range []*CompletedProof{&bottomProof, &midProof, &topProof} {
+		proof.HashVersion = 0
+	}
+	assert.NotPanics(func() { VerifyUser(BuildUserVerificationElements(accounts[0], 0, bottomProof, midProof, topProof)) })
+
+	// proofs of different hash versions can't be verified together
+	mixedTopProof := topProof
+	mixedTopProof.HashVersion = circuit.HASH_VERSION_TAGGED
+	defer func() {
+		r := recover()
+		assert.NotNil(r, "expected VerifyUser to fail for proofs of different hash versions")
+		assert.Contains(fmt.Sprint(r), "proofs of different hash versions are mixed")
+	}()
+	VerifyUser(BuildUserVerificationElements(accounts[0], 0, bottomProof, midProof, mixedTopProof))
+}
+
+func TestVerifyRoundWithAssetSubset(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// generate a round of BTC and ETH only out of batches of every asset, then verify it without a subset configured
+	outDir := t.TempDir() + "/"
+	assert.NoError(os.MkdirAll(outDir+"secret", 0o755))
+	assert.NoError(os.MkdirAll(outDir+"public", 0o755))
+	GenerateData(1, countPerBatch, outDir)
+	assert.NoError(circuit.SetAssetSubset([]int{3, 12}))
+	Prove(1, outDir)
+	assert.NoError(circuit.SetAssetSubset(nil))
+
+	bottomProof := ReadDataFromFile[CompletedProof](outDir + BOTTOM_PROOF_PREFIX + "0.json")
+	midProof := ReadDataFromFile[CompletedProof](outDir + MIDDLE_PROOF_PREFIX + "0.json")
+	topProof := ReadDataFromFile[CompletedProof](outDir + TOP_PROOF_PREFIX + "0.json")
+	batch := ReadDataFromFile[ProofElements](outDir + SECRET_DATA_PREFIX + "0.json")
+	assert.Equal([]int{3, 12}, topProof.AssetSubset)
+	assert.Equal([]int{3, 12}, readRoundMetadata(outDir).AssetSubset)
+	assert.Equal(circuit.GoBalance{(*batch.AssetSum)[3], (*batch.AssetSum)[12]}, *topProof.AssetSum)
+	assert.NotPanics(func() { VerifyFull(1, outDir) })
+	assert.Nil(circuit.GetAssetSubset(), "verification should restore the asset subset")
+
+	// user verification packages have the balances of the subset, labeled with its assets
+	account := batch.Accounts[0]
+	account.Balance = circuit.GoBalance{account.Balance[3], account.Balance[12]}
+	elements := BuildUserVerificationElements(account, 0, bottomProof, midProof, topProof)
+	raw := ConvertUserVerificationElementsToRawUserVerificationElements(elements)
+	assert.Equal("ETH", raw.AccountInfo.Balance[1].Asset)
+	assert.NotPanics(func() { VerifyUser(ConvertRawUserVerificationElementsToUserVerificationElements(raw)) })
+
+	// the round metadata must claim the subset of the proofs
+	metadata := readRoundMetadata(outDir)
+	metadata.AssetSubset = nil
+	assert.NoError(writeJson(outDir+ROUND_METADATA_FILE, metadata))
+	defer func() {
+		r := recover()
+		assert.NotNil(r, "expected VerifyFull to fail for a round claiming every asset")
+		assert.Contains(fmt.Sprint(r), "the round metadata claims asset subset []")
+	}()
+	VerifyFull(1, outDir)
+}
+
+func TestProofsAssetSubset(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// proofs that do not record an asset subset have every asset
+	assetSubset, err := verification.ProofsAssetSubset([]CompletedProof{proofLower0, proofTop})
+	assert.NoError(err)
+	assert.Nil(assetSubset)
+
+	subsetProof := proofLower0
+	subsetProof.AssetSubset = []int{3, 12}
+	assetSubset, err = verification.ProofsAssetSubset([]CompletedProof{subsetProof, subsetProof})
+	assert.NoError(err)
+	assert.Equal([]int{3, 12}, assetSubset)
+
+	// proofs of different asset subsets can't be verified together
+	_, err = verification.ProofsAssetSubset([]CompletedProof{subsetProof, proofTop})
+	assert.ErrorContains(err, "proofs of different asset subsets are mixed")
+	invalidProof := proofLower0
+	invalidProof.AssetSubset = []int{12, 3}
+	_, err = verification.ProofsAssetSubset([]CompletedProof{invalidProof})
+	assert.Error(err)
+}
+
+func TestProofsHashVersion(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// proofs that do not record a hash version are untagged
+	legacyProof := proofLower0
+	legacyProof.HashVersion = 0
+	hashVersion, err := verification.ProofsHashVersion([]CompletedProof{legacyProof})
+	assert.NoError(err)
+	assert.Equal(circuit.HASH_VERSION_UNTAGGED, hashVersion)
+
+	hashVersion, err = verification.ProofsHashVersion([]CompletedProof{proofLower0, proofMid, proofTop})
+	assert.NoError(err)
+	assert.Equal(circuit.HASH_VERSION_ROOT_TAGGED, hashVersion)
+	_, err = verification.ProofsHashVersion([]CompletedProof{legacyProof, proofLower0})
+	assert.Error(err)
+
+	unknownProof := proofLower0
+	unknownProof.HashVersion = 4
+	_, err = verification.ProofsHashVersion([]CompletedProof{unknownProof})
+	assert.Error(err)
+}
+
+func TestProofsAccountBytesVersion(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// proofs record the account bytes version, and proofs that do not record one use version 1
+	assert.Equal(circuit.ACCOUNT_BYTES_VERSION_1, proofLower0.AccountBytesVersion)
+	legacyProof := proofLower0
+	legacyProof.AccountBytesVersion = 0
+	accountBytesVersion, err := verification.ProofsAccountBytesVersion([]CompletedProof{legacyProof, proofMid, proofTop})
+	assert.NoError(err)
+	assert.Equal(circuit.ACCOUNT_BYTES_VERSION_1, accountBytesVersion)
+
+	// unknown versions can't be verified
+	unknownProof := proofLower0
+	unknownProof.AccountBytesVersion = 2
+	_, err = verification.ProofsAccountBytesVersion([]CompletedProof{unknownProof})
+	assert.Error(err)
+	_, err = verification.ProofsAccountBytesVersion([]CompletedProof{proofTop, unknownProof})
+	assert.Error(err)
+	elements := BuildUserVerificationElements(testData0.Accounts[2], 2, unknownProof, proofMid, proofTop)
+	assert.Panics(func() { VerifyUser(elements) })
+}
+
+func TestVerifyRoundWithSHA256Leaves(t *testing.T) {
+	assert := test.NewAssert(t)
+	defer circuit.SetAssetRegistry(circuit.DefaultAssetRegistry())
+
+	// generate a round with SHA-256 leaves (of two assets and a single account per proof, as they are expensive), then
+	// verify it with native leaves configured
+	outDir := t.TempDir() + "/"
+	assert.NoError(os.MkdirAll(outDir+"secret", 0o755))
+	assert.NoError(os.MkdirAll(outDir+"public", 0o755))
+	assert.NoError(circuit.SetAssetRegistry(circuit.AssetRegistry{Assets: []circuit.Asset{{Symbol: "BTC", Decimals: 8}, {Symbol: "ETH", Decimals: 18}}}))
+	assert.NoError(circuit.SetLeafHashMode(circuit.LEAF_HASH_SHA256))
+	GenerateData(1, 1, outDir)
+	Prove(1, outDir)
+	assert.NoError(circuit.SetLeafHashMode(circuit.LEAF_HASH_NATIVE))
+
+	bottomProof := ReadDataFromFile[CompletedProof](outDir + BOTTOM_PROOF_PREFIX + "0.json")
+	midProof := ReadDataFromFile[CompletedProof](outDir + MIDDLE_PROOF_PREFIX + "0.json")
+	topProof := ReadDataFromFile[CompletedProof](outDir + TOP_PROOF_PREFIX + "0.json")
+	accounts := ReadDataFromFile[ProofElements](outDir + SECRET_DATA_PREFIX + "0.json").Accounts
+	assert.Equal(circuit.LEAF_HASH_SHA256, topProof.LeafHashMode)
+	assert.NotPanics(func() { VerifyFull(1, outDir) })
+	assert.NotPanics(func() { VerifyUser(BuildUserVerificationElements(accounts[0], 0, bottomProof, midProof, topProof)) })
+	assert.Equal(circuit.LEAF_HASH_NATIVE, circuit.GetLeafHashMode(), "verification should restore the leaf hash mode")
+
+	// proofs that do not record a leaf hash mode have native leaves
+	for _, proof := range []*CompletedProof{&bottomProof, &midProof, &topProof} {
+		proof.LeafHashMode = ""
+	}
+	assert.Panics(func() { VerifyUser(BuildUserVerificationElements(accounts[0], 0, bottomProof, midProof, topProof)) })
+}
+
+func TestProofsLeafHashMode(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// proofs that do not record a leaf hash mode have native leaves
+	leafHashMode, err := verification.ProofsLeafHashMode([]CompletedProof{proofLower0, proofMid, proofTop})
+	assert.NoError(err)
+	assert.Equal(circuit.LEAF_HASH_NATIVE, leafHashMode)
+
+	sha256Proof := proofLower0
+	sha256Proof.LeafHashMode = circuit.LEAF_HASH_SHA256
+	leafHashMode, err = verification.ProofsLeafHashMode([]CompletedProof{sha256Proof})
+	assert.NoError(err)
+	assert.Equal(circuit.LEAF_HASH_SHA256, leafHashMode)
+	_, err = verification.ProofsLeafHashMode([]CompletedProof{sha256Proof, proofTop})
+	assert.ErrorContains(err, "proofs of different leaf hash modes are mixed")
+
+	unknownProof := proofLower0
+	unknownProof.LeafHashMode = "sha3"
+	_, err = verification.ProofsLeafHashMode([]CompletedProof{unknownProof})
+	assert.Error(err)
+}
+
+func TestVerifyRoundWithTreeDepth(t *testing.T) {
+	assert := test.NewAssert(t)
+	defer circuit.SetConfig(circuit.DefaultConfig())
+
+	// generate a round with trees of depth 4, then verify it with the default tree depth configured
+	outDir := t.TempDir() + "/"
+	assert.NoError(os.MkdirAll(outDir+"secret", 0o755))
+	assert.NoError(os.MkdirAll(outDir+"public", 0o755))
+	assert.NoError(circuit.SetConfig(circuit.Config{TreeDepth: 4}))
+	GenerateData(2, countPerBatch, outDir)
+	Prove(2, outDir)
+	assert.NoError(circuit.SetConfig(circuit.DefaultConfig()))
+
+	bottomProof := ReadDataFromFile[CompletedProof](outDir + BOTTOM_PROOF_PREFIX + "1.json")
+	midProof := ReadDataFromFile[CompletedProof](outDir + MIDDLE_PROOF_PREFIX + "0.json")
+	topProof := ReadDataFromFile[CompletedProof](outDir + TOP_PROOF_PREFIX + "0.json")
+	accounts := ReadDataFromFile[ProofElements](outDir + SECRET_DATA_PREFIX + "1.json").Accounts
+	assert.Equal(4, topProof.TreeDepth)
+	assert.Equal(4, len(bottomProof.MerklePath))
+	assert.Equal(4, readRoundMetadata(outDir).TreeDepth)
+	assert.NotPanics(func() { VerifyFull(2, outDir) })
+	assert.NotPanics(func() { VerifyUser(BuildUserVerificationElements(accounts[3], 3, bottomProof, midProof, topProof)) })
+	assert.Equal(circuit.TREE_DEPTH, circuit.GetTreeDepth(), "verification should restore the tree depth")
+
+	// proofs of different tree depths can't be verified together
+	assert.Panics(func() { VerifyUser(BuildUserVerificationElements(accounts[3], 3, bottomProof, midProof, proofTop)) })
+}
+
+func TestProofsTreeDepth(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// proofs that do not record a tree depth used circuit.TREE_DEPTH
+	legacyProof := proofLower0
+	legacyProof.TreeDepth = 0
+	treeDepth, err := verification.ProofsTreeDepth([]CompletedProof{proofLower0, legacyProof})
+	assert.NoError(err)
+	assert.Equal(circuit.TREE_DEPTH, treeDepth)
+
+	shallowProof := proofLower0
+	shallowProof.TreeDepth = 4
+	treeDepth, err = verification.ProofsTreeDepth([]CompletedProof{shallowProof})
+	assert.NoError(err)
+	assert.Equal(4, treeDepth)
+	_, err = verification.ProofsTreeDepth([]CompletedProof{legacyProof, shallowProof})
+	assert.Error(err)
+
+	invalidProof := proofLower0
+	invalidProof.TreeDepth = circuit.MAX_TREE_DEPTH + 1
+	_, err = verification.ProofsTreeDepth([]CompletedProof{invalidProof})
+	assert.Error(err)
+}
+
+func TestVerifyBalanceBits(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// proofs that do not record balance bits range checked everything to circuit.BALANCE_BITS
+	legacyProof := proofLower0
+	legacyProof.BalanceBits, legacyProof.AssetSumBits = 0, 0
+	assert.NoError(verification.VerifyBalanceBits(legacyProof, proofMid))
+	assert.NoError(verification.VerifyBalanceBits(proofLower0, proofMid))
+
+	// the upper level proof must range check its balances to the asset sum bits of the lower level proof
+	narrowProof := proofLower0
+	narrowProof.BalanceBits, narrowProof.AssetSumBits = 64, 96
+	wideProof := proofMid
+	wideProof.BalanceBits, wideProof.AssetSumBits = 96, 96
+	assert.NoError(verification.VerifyBalanceBits(narrowProof, wideProof))
+	assert.Error(verification.VerifyBalanceBits(narrowProof, proofMid))
+	assert.Error(verification.VerifyBalanceBits(proofLower0, wideProof))
+}
+
+func TestVerifyRoundWithEpoch(t *testing.T) {
+	assert := test.NewAssert(t)
+	defer circuit.SetConfig(circuit.DefaultConfig())
+
+	// generate a round bound to an epoch (with trees of depth 4 to keep it fast)
+	outDir := t.TempDir() + "/"
+	assert.NoError(os.MkdirAll(outDir+"secret", 0o755))
+	assert.NoError(os.MkdirAll(outDir+"public", 0o755))
+	assert.NoError(circuit.SetConfig(circuit.Config{TreeDepth: 4}))
+	setOptions(t, func(options *Options) { options.ProofEpoch = 20240131 })
+	GenerateData(2, countPerBatch, outDir)
+	Prove(2, outDir)
+	setOptions(t, func(options *Options) { options.ProofEpoch = 0 })
+
+	bottomProof := ReadDataFromFile[CompletedProof](outDir + BOTTOM_PROOF_PREFIX + "1.json")
+	midProof := ReadDataFromFile[CompletedProof](outDir + MIDDLE_PROOF_PREFIX + "0.json")
+	topProof := ReadDataFromFile[CompletedProof](outDir + TOP_PROOF_PREFIX + "0.json")
+	accounts := ReadDataFromFile[ProofElements](outDir + SECRET_DATA_PREFIX + "1.json").Accounts
+	for _, proof := range []CompletedProof{bottomProof, midProof, topProof} {
+		assert.NotNil(proof.Epoch)
+		assert.Equal(uint64(20240131), *proof.Epoch)
+	}
+	assert.NotPanics(func() { VerifyFull(2, outDir) })
+	assert.NotPanics(func() { VerifyUser(BuildUserVerificationElements(accounts[3], 3, bottomProof, midProof, topProof)) })
+
+	// the epoch is a public input, so proofs can't be moved to another epoch, even all layers at once
+	otherEpoch := uint64(20240229)
+	movedBottomProof, movedMidProof, movedTopProof := bottomProof, midProof, topProof
+	movedBottomProof.Epoch, movedMidProof.Epoch, movedTopProof.Epoch = &otherEpoch, &otherEpoch, &otherEpoch
+	assert.Panics(func() {
+		VerifyUser(BuildUserVerificationElements(accounts[3], 3, movedBottomProof, movedMidProof, movedTopProof))
+	})
+
+	// proofs of different epochs can't be verified together
+	assert.Panics(func() {
+		VerifyUser(BuildUserVerificationElements(accounts[3], 3, bottomProof, midProof, movedTopProof))
+	})
+}
+
+func TestProofsEpoch(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// proofs generated without an epoch configured are bound to epoch zero
+	epoch, err := verification.ProofsEpoch([]CompletedProof{proofLower0, proofMid, proofTop})
+	assert.NoError(err)
+	assert.Equal(uint64(0), *epoch)
+
+	// proofs that predate epochs record none
+	legacyProof := proofLower0
+	legacyProof.Epoch = nil
+	epoch, err = verification.ProofsEpoch([]CompletedProof{legacyProof, legacyProof})
+	assert.NoError(err)
+	assert.Nil(epoch)
+	assert.Equal(uint64(0), verification.EpochOf(legacyProof))
+
+	epochProof, otherEpochProof := proofLower0, proofLower0
+	epochValue, otherEpochValue := uint64(7), uint64(8)
+	epochProof.Epoch, otherEpochProof.Epoch = &epochValue, &otherEpochValue
+	epoch, err = verification.ProofsEpoch([]CompletedProof{epochProof, epochProof})
+	assert.NoError(err)
+	assert.Equal(uint64(7), *epoch)
+	assert.Equal(uint64(7), verification.EpochOf(epochProof))
+
+	// proofs of different epochs, or with and without an epoch, are mixed
+	_, err = verification.ProofsEpoch([]CompletedProof{epochProof, otherEpochProof})
+	assert.Error(err)
+	_, err = verification.ProofsEpoch([]CompletedProof{legacyProof, epochProof})
+	assert.Error(err)
+	_, err = verification.ProofsEpoch([]CompletedProof{epochProof, legacyProof})
+	assert.Error(err)
+}
+
+func TestVerifyAssetRegistry(t *testing.T) {
+	assert := test.NewAssert(t)
+	defer circuit.SetAssetRegistry(circuit.DefaultAssetRegistry())
+
+	// proofs that do not record an asset registry pass
+	legacyProof := proofLower0
+	legacyProof.AssetRegistryHash = ""
+	assert.NoError(verification.VerifyAssetRegistry([]CompletedProof{legacyProof}))
+
+	registryProof := proofLower0
+	registryProof.AssetRegistryHash = circuit.DefaultAssetRegistry().Hash()
+	assert.NoError(verification.VerifyAssetRegistry([]CompletedProof{legacyProof, registryProof}))
+
+	// proofs of another asset registry fail
+	assert.NoError(circuit.SetAssetRegistry(circuit.AssetRegistry{Assets: []circuit.Asset{{Symbol: "BTC", Decimals: 8}}}))
+	assert.Error(verification.VerifyAssetRegistry([]CompletedProof{legacyProof, registryProof}))
+}
+
+func TestVerifyDistinctWalletIds(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	accounts := testData0.Accounts
+	assert.NoError(verifyDistinctWalletIds(accounts))
+	assert.NoError(verifyDistinctWalletIds(nil))
+
+	// the same account twice, or two accounts with the same WalletId, fail
+	assert.Error(verifyDistinctWalletIds(append(append([]circuit.GoAccount{}, accounts...), accounts[1])))
+	sameWalletId := circuit.GoAccount{WalletId: accounts[0].WalletId, Balance: accounts[1].Balance}
+	assert.Error(verifyDistinctWalletIds([]circuit.GoAccount{accounts[0], sameWalletId}))
+
+}
+
+func TestVerifyAccountCount(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// every proof records the number of accounts (or lower level proofs) it covers
+	assert.Equal(len(testData0.Accounts), *proofLower0.AccountCount)
+	assert.Equal(len(testData1.Accounts), *proofLower1.AccountCount)
+	assert.Equal(2, *proofMid.AccountCount)
+	assert.Equal(1, *proofTop.AccountCount)
+	assert.NoError(verifyAccountCount(proofLower0, len(testData0.Accounts)))
+	assert.Error(verifyAccountCount(proofLower0, len(testData0.Accounts)-1))
+	assert.NoError(verification.VerifyMerklePositionCovered(proofLower0, len(testData0.Accounts)-1))
+	assert.Error(verification.VerifyMerklePositionCovered(proofLower0, len(testData0.Accounts)))
+
+	// the account count is a public input, so changing it fails verification
+	wrongCount := *proofLower0.AccountCount + 1
+	tamperedProof := proofLower0
+	tamperedProof.AccountCount = &wrongCount
+	assert.Error(verifyProof(tamperedProof, bottomLevelLayer.name))
+	assert.Panics(func() {
+		verifyFull([][]CompletedProof{{proofLower0, proofLower1}, {proofMid}, {proofTop}}, [][]circuit.GoAccount{testData0.Accounts[1:], testData1.Accounts})
+	})
+
+	// proofs that do not record an account count pass, but can't be verified without it
+	legacyProof := proofLower0
+	legacyProof.AccountCount = nil
+	assert.NoError(verifyAccountCount(legacyProof, 0))
+	assert.NoError(verification.VerifyMerklePositionCovered(legacyProof, circuit.ACCOUNTS_PER_BATCH-1))
+	assert.Error(verifyProof(legacyProof, bottomLevelLayer.name))
 }
@@ -0,0 +1,97 @@
+package core
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sync"
+
+	"bitgo.com/proof_of_reserves/verification"
+)
+
+// UserVerificationResult is the result of verifying a user verification package with VerifyUsers.
+type UserVerificationResult struct {
+	Path string
+	// Error is the error verification failed with (empty if it passed).
+	Error  string `json:",omitempty"`
+	Passed bool
+}
+
+// VerifyUsers verifies the user verification packages at the given paths like VerifyUser, as many at once as there
+// are verification workers (see SetVerificationWorkers), and returns the result of each, in the order of the paths.
+// Each distinct proof is only verified once, however many packages include it (e.g. the bottom level proof of the
+// users of a batch, or the upper level proofs of a round). Like VerifyUser, the packages must include their
+// verification keys (see ResolveVerificationKeys), and the proofs are verified with the keys pinned for their layers.
+func VerifyUsers(paths []string) []UserVerificationResult {
+	results := make([]UserVerificationResult, len(paths))
+	cache := &proofVerificationCache{results: make(map[string]*cachedProofVerification)}
+	_ = verifyInParallel(len(paths), func(i int) error {
+		results[i] = UserVerificationResult{Path: paths[i], Passed: true}
+		results[i].run(func() error {
+			userVerifElements := ReadDataFromFile[UserVerificationElements](paths[i])
+			proofs, config, err := verification.UserProofChain(userVerifElements)
+			if err != nil {
+				return err
+			}
+			return verification.VerifyUserProofChain(userVerifElements, proofs, config, cache.verify)
+		})
+		return nil
+	})
+	return results
+}
+
+// run runs a step of the verification of the package, and records the error it fails with, if any. Returns whether
+// the step passed.
+func (result *UserVerificationResult) run(step func() error) bool {
+	var err error
+	func() {
+		defer recoverVerificationError(&err)
+		err = step()
+	}()
+	if err != nil {
+		result.Passed, result.Error = false, err.Error()
+	}
+	return err == nil
+}
+
+// proofVerificationCache verifies each distinct proof once, with verifyProof, however many times it is verified.
+type proofVerificationCache struct {
+	mutex   sync.Mutex
+	results map[string]*cachedProofVerification
+}
+
+// cachedProofVerification is the result of verifying a proof, once it was verified.
+type cachedProofVerification struct {
+	once sync.Once
+	err  error
+}
+
+// verify verifies the proof of the layer, unless the same proof was verified before, in which case it returns the same result.
+// Proofs that are verified concurrently are only verified once.
+func (cache *proofVerificationCache) verify(proof CompletedProof, layer string) error {
+	key := layer + "|" + proofVerificationCacheKey(proof)
+	cache.mutex.Lock()
+	result, ok := cache.results[key]
+	if !ok {
+		result = &cachedProofVerification{}
+		cache.results[key] = result
+	}
+	cache.mutex.Unlock()
+	result.once.Do(func() { result.err = verifyProof(proof, layer) })
+	return result.err
+}
+
+// proofVerificationCacheKey returns the SHA-256 of everything verifyProof verifies of the proof: the proof, its
+// backend and verification key, and its public inputs.
+func proofVerificationCacheKey(proof CompletedProof) string {
+	accountCount, epoch := "", ""
+	if proof.AccountCount != nil {
+		accountCount = fmt.Sprint(*proof.AccountCount)
+	}
+	if proof.Epoch != nil {
+		epoch = fmt.Sprint(*proof.Epoch)
+	}
+	key := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%s|%s|%s|%x|%x|%s|%s",
+		proof.Backend, proof.CircuitVersion, proof.Proof, proof.VerificationKey, proof.VerificationKeyFingerprint,
+		proof.MerkleRoot, proof.MerkleRootWithAssetSumHash, accountCount, epoch)))
+	return string(key[:])
+}
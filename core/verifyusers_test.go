@@ -0,0 +1,65 @@
+package core
+
+import (
+	"math/big"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"github.com/consensys/gnark/test"
+)
+
+func TestVerifyUsers(t *testing.T) {
+	assert := test.NewAssert(t)
+	userOutDir := t.TempDir()
+	assert.Equal(batchCount*countPerBatch, GenerateUserPackages(batchCount, testOutDir, userOutDir))
+	paths, err := filepath.Glob(filepath.Join(userOutDir, "*.json"))
+	assert.NoError(err)
+	assert.NoError(SetVerificationWorkers(4))
+	defer func() { assert.NoError(SetVerificationWorkers(1)) }()
+
+	// every exported package passes, in the order of the paths
+	results := VerifyUsers(paths)
+	assert.Equal(len(paths), len(results))
+	for i, result := range results {
+		assert.Equal(paths[i], result.Path)
+		assert.True(result.Passed, result.Error)
+	}
+
+	// a package with a tampered balance, and a package that can't be read, fail without failing the others
+	account := testData0.Accounts[2]
+	account.Balance = slices.Clone(account.Balance)
+	account.Balance[0] = new(big.Int).Add(account.Balance[0], big.NewInt(1))
+	tamperedPath := filepath.Join(t.TempDir(), "tampered.json")
+	WriteDataToFile(tamperedPath, BuildUserVerificationElements(account, 2, proofLower0, proofMid, proofTop))
+	results = VerifyUsers([]string{paths[0], tamperedPath, filepath.Join(userOutDir, "missing.json"), paths[1]})
+	assert.True(results[0].Passed, results[0].Error)
+	assert.False(results[1].Passed)
+	assert.Contains(results[1].Error, "failed to verify if account included in bottom proof")
+	assert.False(results[2].Passed)
+	assert.NotEmpty(results[2].Error)
+	assert.True(results[3].Passed, results[3].Error)
+
+	// the packages are verified with the parameters of their proofs, without changing the global ones
+	assert.Equal(circuit.HASH_MIMC, circuit.GetHashFunction())
+}
+
+func TestProofVerificationCache(t *testing.T) {
+	assert := test.NewAssert(t)
+	cache := &proofVerificationCache{results: make(map[string]*cachedProofVerification)}
+	assert.NoError(cache.verify(proofLower0, bottomLevelLayer.name))
+	assert.NoError(cache.verify(proofLower0, bottomLevelLayer.name))
+	assert.Equal(1, len(cache.results))
+
+	// a proof with other public inputs is another proof
+	tamperedProof := proofLower0
+	tamperedProof.MerkleRoot = proofLower1.MerkleRoot
+	assert.Error(cache.verify(tamperedProof, bottomLevelLayer.name))
+	assert.Equal(2, len(cache.results))
+	assert.NoError(cache.verify(proofLower1, bottomLevelLayer.name))
+
+	// and so is the same proof of another layer
+	assert.Error(cache.verify(proofLower0, midLevelLayer.name))
+	assert.Equal(4, len(cache.results))
+}
@@ -0,0 +1,220 @@
+// Package merklepath is a minimal reference implementation of Merkle path verification for the liability proofs.
+// It only depends on the MiMC hash and is intended to be transliterated into other languages (e.g. TypeScript
+// SDKs) and cross-checked against the test vectors in testdata/vectors.json.
+//
+// The algorithm is:
+//  1. Start with curr = leaf hash and pos = leaf position (0-indexed from the left of the bottom layer).
+//  2. For each sibling in the path (ordered from the bottom layer to the layer below the root):
+//     - if pos is even, curr is the left child: curr = HashNodes(curr, sibling)
+//     - if pos is odd, curr is the right child: curr = HashNodes(sibling, curr)
+//     - pos = pos / 2 (integer division)
+//  3. The path is valid if curr equals the root.
+//
+// HashNodes(left, right) is the BN254 MiMC hash of the two nodes, each interpreted as a big-endian field element
+// (inputs shorter than 32 bytes are left-padded with zeros, and inputs must be smaller than the field modulus).
+//
+// Account leaves are computed from the canonical account bytes (circuit.CanonicalAccountBytes) of version 1, the
+// version of every proof so far, which are 32 byte big-endian field elements: the WalletId followed by the balance of
+// each asset. The leaf is
+// HashNodes(walletId, balanceHash), where balanceHash is the MiMC hash of all the balances (see HashAccount). The
+// leaf of a salted account (whose user verification package has a Salt) is the MiMC hash of walletId, salt, and
+// balanceHash instead (see HashSaltedAccount).
+//
+// Deployments with a domain (e.g. "testnet") hash the domain element first, i.e. HashNodes(domain, left, right),
+// where the domain element is SHA-256(domain) reduced modulo the BN254 scalar field (see DomainElement).
+//
+// Proofs with hash version 2 (see circuit.HASH_VERSION_TAGGED) also hash a tag right after the domain element (if
+// any): LeafTag in account leaves, i.e. MiMC(domain, LeafTag, walletId, [salt,] balanceHash), and NodeTag in node
+// hashes, i.e. MiMC(domain, NodeTag, left, right). See HashTaggedAccount and VerifyTaggedPath. Proofs with hash
+// version 3 (see circuit.HASH_VERSION_ROOT_TAGGED, the default of new proofs) hash account leaves and nodes like
+// version 2 (they only tag the leaves of lower level proofs in upper level proofs apart, which are given to the
+// verifier rather than computed from account bytes). Proofs that record no hash version (or version 1) are untagged.
+//
+// Only proofs with the MiMC hash function (the default, see circuit.SetHashFunction) and native leaf hashes (see
+// circuit.SetLeafHashMode) are covered. Proofs with the Poseidon2 hash function or SHA-256 leaves must be verified with
+// the main code.
+package merklepath
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr/mimc"
+)
+
+// LeafTag and NodeTag are the tags of account leaves and node hashes of proofs with hash version 2, as 32 big-endian
+// bytes (the field elements 1 and 2).
+var (
+	LeafTag = new(big.Int).SetInt64(1).FillBytes(make([]byte, fr.Bytes))
+	NodeTag = new(big.Int).SetInt64(2).FillBytes(make([]byte, fr.Bytes))
+)
+
+// DomainElement returns the field element the given deployment domain is hashed as, as 32 big-endian bytes.
+// The empty domain has no element and returns nil.
+func DomainElement(domain string) []byte {
+	if domain == "" {
+		return nil
+	}
+	digest := sha256.Sum256([]byte(domain))
+	element := new(big.Int).SetBytes(digest[:])
+	element.Mod(element, fr.Modulus())
+	return element.FillBytes(make([]byte, fr.Bytes))
+}
+
+// HashAccount computes the leaf of an account from its canonical bytes in the deployment domain with the given
+// element (nil for no domain).
+func HashAccount(domain, accountBytes []byte) ([]byte, error) {
+	return hashAccount(domain, nil, nil, accountBytes)
+}
+
+// HashSaltedAccount computes the leaf of a salted account from its salt (a big-endian field element) and canonical
+// bytes in the deployment domain with the given element (nil for no domain). A zero salt is no salt (see HashAccount).
+func HashSaltedAccount(domain, salt, accountBytes []byte) ([]byte, error) {
+	return hashAccount(domain, nil, salt, accountBytes)
+}
+
+// HashTaggedAccount computes the leaf of an account of a proof with hash version 2 from its salt (nil or zero for an
+// unsalted account) and canonical bytes in the deployment domain with the given element (nil for no domain).
+func HashTaggedAccount(domain, salt, accountBytes []byte) ([]byte, error) {
+	return hashAccount(domain, LeafTag, salt, accountBytes)
+}
+
+// hashAccount computes the leaf of an account with the given tag (nil for untagged leaves) and salt (nil or zero for
+// an unsalted account).
+func hashAccount(domain, tag, salt, accountBytes []byte) ([]byte, error) {
+	if len(accountBytes) < 2*fr.Bytes || len(accountBytes)%fr.Bytes != 0 {
+		return nil, fmt.Errorf("invalid account bytes length %d", len(accountBytes))
+	}
+	if len(salt) > fr.Bytes {
+		return nil, fmt.Errorf("invalid salt length %d", len(salt))
+	}
+	hasher := mimc.NewMiMC()
+	if _, err := hasher.Write(accountBytes[fr.Bytes:]); err != nil {
+		return nil, fmt.Errorf("error writing balances to hasher: %w", err)
+	}
+	balanceHash := hasher.Sum(nil)
+
+	elements := [][]byte{domain, tag, accountBytes[:fr.Bytes]}
+	if new(big.Int).SetBytes(salt).Sign() != 0 {
+		elements = append(elements, append(make([]byte, fr.Bytes-len(salt)), salt...))
+	}
+	hasher.Reset()
+	for _, element := range append(elements, balanceHash) {
+		if element == nil {
+			continue
+		}
+		if _, err := hasher.Write(element); err != nil {
+			return nil, fmt.Errorf("error writing account to hasher: %w", err)
+		}
+	}
+	return hasher.Sum(nil), nil
+}
+
+// HashNodes computes the hash of a parent node from its left and right children.
+func HashNodes(left, right []byte) ([]byte, error) {
+	return HashNodesInDomain(nil, left, right)
+}
+
+// HashNodesInDomain computes the hash of a parent node from its left and right children in the deployment domain
+// with the given element (nil for no domain).
+func HashNodesInDomain(domain, left, right []byte) ([]byte, error) {
+	return hashNodes(domain, nil, left, right)
+}
+
+// HashTaggedNodes computes the hash of a parent node of a proof with hash version 2 from its left and right children
+// in the deployment domain with the given element (nil for no domain).
+func HashTaggedNodes(domain, left, right []byte) ([]byte, error) {
+	return hashNodes(domain, NodeTag, left, right)
+}
+
+// hashNodes computes the hash of a parent node with the given tag (nil for untagged nodes).
+func hashNodes(domain, tag, left, right []byte) ([]byte, error) {
+	hasher := mimc.NewMiMC()
+	if domain != nil {
+		if _, err := hasher.Write(domain); err != nil {
+			return nil, fmt.Errorf("error writing domain to hasher: %w", err)
+		}
+	}
+	if tag != nil {
+		if _, err := hasher.Write(tag); err != nil {
+			return nil, fmt.Errorf("error writing tag to hasher: %w", err)
+		}
+	}
+	if _, err := hasher.Write(left); err != nil {
+		return nil, fmt.Errorf("error writing left node to hasher: %w", err)
+	}
+	if _, err := hasher.Write(right); err != nil {
+		return nil, fmt.Errorf("error writing right node to hasher: %w", err)
+	}
+	return hasher.Sum(nil), nil
+}
+
+// FoldPath computes the root of the Merkle tree given a leaf hash, its position in the bottom layer, and the
+// sibling hashes on the path from the leaf to the root.
+func FoldPath(leaf []byte, position int, path [][]byte) ([]byte, error) {
+	return FoldPathInDomain(nil, leaf, position, path)
+}
+
+// FoldPathInDomain is FoldPath in the deployment domain with the given element (nil for no domain).
+func FoldPathInDomain(domain []byte, leaf []byte, position int, path [][]byte) ([]byte, error) {
+	return foldPath(domain, nil, leaf, position, path)
+}
+
+// FoldTaggedPath is FoldPathInDomain for proofs with hash version 2, whose node hashes are tagged.
+func FoldTaggedPath(domain []byte, leaf []byte, position int, path [][]byte) ([]byte, error) {
+	return foldPath(domain, NodeTag, leaf, position, path)
+}
+
+// foldPath computes the root of the Merkle tree with node hashes of the given tag (nil for untagged nodes).
+func foldPath(domain, tag []byte, leaf []byte, position int, path [][]byte) ([]byte, error) {
+	if position < 0 || position >= 1<<len(path) {
+		return nil, fmt.Errorf("position %d out of bounds for path of length %d", position, len(path))
+	}
+
+	curr := leaf
+	pos := position
+	var err error
+	for i, sibling := range path {
+		if pos%2 == 0 {
+			curr, err = hashNodes(domain, tag, curr, sibling)
+		} else {
+			curr, err = hashNodes(domain, tag, sibling, curr)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error hashing path element %d: %w", i, err)
+		}
+		pos /= 2
+	}
+	return curr, nil
+}
+
+// VerifyPath verifies that the leaf hash at the given position and the sibling hashes on its path lead to the
+// given root.
+func VerifyPath(leaf []byte, position int, path [][]byte, root []byte) error {
+	return VerifyPathInDomain(nil, leaf, position, path, root)
+}
+
+// VerifyPathInDomain is VerifyPath in the deployment domain with the given element (nil for no domain).
+func VerifyPathInDomain(domain []byte, leaf []byte, position int, path [][]byte, root []byte) error {
+	return verifyPath(domain, nil, leaf, position, path, root)
+}
+
+// VerifyTaggedPath is VerifyPathInDomain for proofs with hash version 2, whose node hashes are tagged.
+func VerifyTaggedPath(domain []byte, leaf []byte, position int, path [][]byte, root []byte) error {
+	return verifyPath(domain, NodeTag, leaf, position, path, root)
+}
+
+// verifyPath verifies a path with node hashes of the given tag (nil for untagged nodes).
+func verifyPath(domain, tag []byte, leaf []byte, position int, path [][]byte, root []byte) error {
+	computedRoot, err := foldPath(domain, tag, leaf, position, path)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(computedRoot, root) {
+		return fmt.Errorf("merkle path does not lead to root")
+	}
+	return nil
+}
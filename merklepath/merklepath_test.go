@@ -0,0 +1,205 @@
+package merklepath
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"math/big"
+	"os"
+	"reflect"
+	"testing"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"github.com/consensys/gnark-crypto/ecc/bn254/fr"
+)
+
+// run `go test ./merklepath -update` to regenerate testdata/vectors.json from the main code.
+var update = flag.Bool("update", false, "regenerate test vectors")
+
+const vectorsPath = "testdata/vectors.json"
+
+// testVector is a hex encoded path verification case for cross-checking other implementations.
+type testVector struct {
+	Description string
+	Domain      string
+	// HashVersion is the hash version of the tree (see circuit.HashVersion).
+	HashVersion int
+	// AccountBytes are the canonical bytes of the account the leaf was computed from (empty for padding leaves).
+	AccountBytes string
+	// Salt is the salt of the account (empty for unsalted accounts).
+	Salt     string `json:",omitempty"`
+	Leaf     string
+	Position int
+	Path     []string
+	Root     string
+	Valid    bool
+}
+
+func encodeHashes(hashes []circuit.Hash) []string {
+	encoded := make([]string, len(hashes))
+	for i, h := range hashes {
+		encoded[i] = hex.EncodeToString(h)
+	}
+	return encoded
+}
+
+func decodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("invalid hex in test vector: %v", err)
+	}
+	return b
+}
+
+// generateVectors builds test vectors from trees computed by the circuit package, of every hash version.
+func generateVectors() []testVector {
+	vectors := make([]testVector, 0)
+	defer circuit.SetHashVersion(circuit.GetHashVersion())
+	for _, hashVersion := range []circuit.HashVersion{circuit.HASH_VERSION_UNTAGGED, circuit.HASH_VERSION_TAGGED, circuit.HASH_VERSION_ROOT_TAGGED} {
+		if err := circuit.SetHashVersion(hashVersion); err != nil {
+			panic(err)
+		}
+		vectors = append(vectors, generateVectorsInDomain("")...)
+
+		// a deployment domain changes every node hash
+		circuit.SetDeploymentDomain("testnet")
+		vectors = append(vectors, generateVectorsInDomain("testnet")...)
+		circuit.SetDeploymentDomain("")
+	}
+	return vectors
+}
+
+// generateVectorsInDomain builds test vectors in the given domain, which must be set in the circuit package, with the
+// configured hash version.
+func generateVectorsInDomain(domain string) []testVector {
+	hashVersion := int(circuit.GetHashVersion())
+	accounts, _, _, _ := circuit.GenerateTestData(5, 0)
+	// fixed salts keep the vectors reproducible
+	accounts[1].Salt = big.NewInt(1).Bytes()
+	accounts[3].Salt = new(big.Int).Sub(fr.Modulus(), big.NewInt(1)).Bytes()
+	nodes := circuit.GoComputeMerkleTreeNodesFromAccounts(accounts)
+	root := hex.EncodeToString(nodes[0][0])
+	leaves := nodes[circuit.TREE_DEPTH]
+
+	vectors := make([]testVector, 0)
+	for _, position := range []int{0, 1, 2, 3, 4, 5, 511, 512, circuit.ACCOUNTS_PER_BATCH - 1} {
+		path := encodeHashes(circuit.ComputeMerklePath(position, nodes))
+		leaf := hex.EncodeToString(leaves[position])
+		if position >= len(accounts) {
+			vectors = append(vectors, testVector{"padding leaf", domain, hashVersion, "", "", leaf, position, path, root, true})
+			continue
+		}
+		accountBytes := hex.EncodeToString(circuit.CanonicalAccountBytes(circuit.ACCOUNT_BYTES_VERSION_1, accounts[position]))
+		salt := hex.EncodeToString(accounts[position].Salt)
+		vectors = append(vectors,
+			testVector{"account leaf", domain, hashVersion, accountBytes, salt, leaf, position, path, root, true},
+			// the most common mistake is getting the sibling order wrong, which is equivalent to flipping the position
+			// (padding leaves are excluded as their sibling is identical to them)
+			testVector{"account leaf with flipped sibling order", domain, hashVersion, accountBytes, salt, leaf, position ^ 1, path, root, false},
+		)
+	}
+
+	// a path with a corrupted sibling
+	path := encodeHashes(circuit.ComputeMerklePath(1, nodes))
+	path[circuit.TREE_DEPTH-1] = path[0]
+	vectors = append(vectors, testVector{"corrupted sibling", domain, hashVersion, "", "", hex.EncodeToString(leaves[1]), 1, path, root, false})
+	return vectors
+}
+
+func TestVectors(t *testing.T) {
+	generated := generateVectors()
+	if *update {
+		data, err := json.MarshalIndent(generated, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(vectorsPath, append(data, '\n'), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(vectorsPath)
+	if err != nil {
+		t.Fatalf("failed to read test vectors: %v", err)
+	}
+	var vectors []testVector
+	if err := json.Unmarshal(data, &vectors); err != nil {
+		t.Fatalf("failed to parse test vectors: %v", err)
+	}
+
+	// the published vectors must match the main code
+	if !reflect.DeepEqual(vectors, generated) {
+		t.Fatalf("%s is out of date with the main code, regenerate it with -update", vectorsPath)
+	}
+
+	for i, v := range vectors {
+		path := make([][]byte, len(v.Path))
+		for j, sibling := range v.Path {
+			path[j] = decodeHex(t, sibling)
+		}
+		hashAccount, verifyPath := HashSaltedAccount, VerifyPathInDomain
+		// version 3 only differs from version 2 in the hashes binding roots to asset sums, which paths don't cover
+		if v.HashVersion >= int(circuit.HASH_VERSION_TAGGED) {
+			hashAccount, verifyPath = HashTaggedAccount, VerifyTaggedPath
+		}
+		if v.AccountBytes != "" {
+			leaf, err := hashAccount(DomainElement(v.Domain), decodeHex(t, v.Salt), decodeHex(t, v.AccountBytes))
+			if err != nil || !bytes.Equal(leaf, decodeHex(t, v.Leaf)) {
+				t.Errorf("vector %d (%s): account bytes do not hash to the leaf (error: %v)", i, v.Description, err)
+			}
+		}
+		err := verifyPath(DomainElement(v.Domain), decodeHex(t, v.Leaf), v.Position, path, decodeHex(t, v.Root))
+		if v.Valid && err != nil {
+			t.Errorf("vector %d (%s): expected valid path, got error: %v", i, v.Description, err)
+		}
+		if !v.Valid && err == nil {
+			t.Errorf("vector %d (%s): expected invalid path to fail", i, v.Description)
+		}
+	}
+}
+
+func TestVerifyPathAgainstCircuitForAllPositions(t *testing.T) {
+	accounts, _, merkleRoot, _ := circuit.GenerateTestData(16, 1)
+	nodes := circuit.GoComputeMerkleTreeNodesFromAccounts(accounts)
+
+	for position := 0; position < circuit.ACCOUNTS_PER_BATCH; position++ {
+		path := circuit.ComputeMerklePath(position, nodes)
+		if err := VerifyTaggedPath(nil, nodes[circuit.TREE_DEPTH][position], position, path, merkleRoot); err != nil {
+			t.Fatalf("position %d: expected valid path, got error: %v", position, err)
+		}
+		if position >= len(accounts) {
+			continue
+		}
+		if err := VerifyTaggedPath(nil, nodes[circuit.TREE_DEPTH][position], position^1, path, merkleRoot); err == nil {
+			t.Fatalf("position %d: expected path with flipped sibling order to fail", position)
+		}
+	}
+}
+
+func TestFoldPathPositionOutOfBounds(t *testing.T) {
+	path := [][]byte{{0x01}, {0x02}}
+	for _, position := range []int{-1, 4} {
+		if _, err := FoldPath([]byte{0x03}, position, path); err == nil {
+			t.Errorf("expected error for position %d", position)
+		}
+	}
+}
+
+func TestDomainElementMatchesCircuit(t *testing.T) {
+	defer circuit.SetDeploymentDomain("")
+	for _, domain := range []string{"", "testnet", "production"} {
+		circuit.SetDeploymentDomain(domain)
+		if !bytes.Equal(DomainElement(domain), circuit.GetDeploymentDomainElement()) {
+			t.Errorf("domain element of %q does not match the circuit package", domain)
+		}
+	}
+}
+
+func TestHashAccountInvalidLength(t *testing.T) {
+	for _, length := range []int{0, 32, 65} {
+		if _, err := HashAccount(nil, make([]byte, length)); err == nil {
+			t.Errorf("expected error for account bytes of length %d", length)
+		}
+	}
+}
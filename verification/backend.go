@@ -0,0 +1,194 @@
+package verification
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"github.com/consensys/gnark-crypto/ecc"
+	gnarkbackend "github.com/consensys/gnark/backend"
+	"github.com/consensys/gnark/backend/groth16"
+	"github.com/consensys/gnark/backend/plonk"
+	"github.com/consensys/gnark/backend/witness"
+	"github.com/consensys/gnark/frontend"
+	stdgroth16 "github.com/consensys/gnark/std/recursion/groth16"
+)
+
+// ProofBackend identifies the proof system proofs are generated with. It is recorded in every proof, so proofs are
+// verified with the backend they were generated with.
+type ProofBackend string
+
+const (
+	// BACKEND_GROTH16 is Groth16 (the default, and the backend of proofs that do not record one). It needs a trusted
+	// setup per circuit shape.
+	BACKEND_GROTH16 ProofBackend = "groth16"
+	// BACKEND_PLONK is PLONK with KZG commitments. It uses a universal SRS for every circuit shape (see
+	// core.SetPlonkSRS).
+	BACKEND_PLONK ProofBackend = "plonk"
+	// BACKEND_GROTH16_RECURSIVE is Groth16 with upper level proofs that verify their lower level proofs in the circuit
+	// (see circuit.AggregationCircuit), so the top level proof alone attests the whole hierarchy. Proofs hash their
+	// commitments the way the recursive verifier does, so they are not interchangeable with BACKEND_GROTH16 proofs.
+	BACKEND_GROTH16_RECURSIVE ProofBackend = "groth16-recursive"
+)
+
+// ParseProofBackend returns the backend with the given name. The empty name is Groth16, the backend of proofs
+// generated before the backend was recorded.
+func ParseProofBackend(name string) (ProofBackend, error) {
+	switch ProofBackend(name) {
+	case "", BACKEND_GROTH16:
+		return BACKEND_GROTH16, nil
+	case BACKEND_PLONK:
+		return BACKEND_PLONK, nil
+	case BACKEND_GROTH16_RECURSIVE:
+		return BACKEND_GROTH16_RECURSIVE, nil
+	}
+	return "", fmt.Errorf("unknown proof backend %q (expected %s, %s, or %s)", name, BACKEND_GROTH16, BACKEND_PLONK, BACKEND_GROTH16_RECURSIVE)
+}
+
+// publicInputsV1 are the public inputs of proofs generated before the account count was a public input (circuit
+// versions 1 and 2), in the order of circuit.Circuit.
+type publicInputsV1 struct {
+	MerkleRoot                 frontend.Variable `gnark:",public"`
+	MerkleRootWithAssetSumHash frontend.Variable `gnark:",public"`
+}
+
+func (*publicInputsV1) Define(frontend.API) error {
+	return nil
+}
+
+// publicInputsV3 are the public inputs of proofs generated before the epoch was a public input (circuit version 3),
+// in the order of circuit.Circuit.
+type publicInputsV3 struct {
+	MerkleRoot                 frontend.Variable `gnark:",public"`
+	MerkleRootWithAssetSumHash frontend.Variable `gnark:",public"`
+	AccountCount               frontend.Variable `gnark:",public"`
+}
+
+func (*publicInputsV3) Define(frontend.API) error {
+	return nil
+}
+
+// publicInputsV4 are the public inputs of proofs generated before the circuit version was a public input (circuit
+// versions 4 to 6), in the order of circuit.Circuit.
+type publicInputsV4 struct {
+	MerkleRoot                 frontend.Variable `gnark:",public"`
+	MerkleRootWithAssetSumHash frontend.Variable `gnark:",public"`
+	AccountCount               frontend.Variable `gnark:",public"`
+	Epoch                      frontend.Variable `gnark:",public"`
+}
+
+func (*publicInputsV4) Define(frontend.API) error {
+	return nil
+}
+
+// VerifyProofWithKey verifies the proof with the verification key, using the backend, which must be the one the
+// proof records.
+func VerifyProofWithKey(proof CompletedProof, verificationKey string, backend ProofBackend) error {
+	// first, verify snark
+	// create the public witness (without the inputs that were not public yet when the proof was generated)
+	var publicInputs frontend.Circuit
+	switch {
+	case proof.CircuitVersion != 0 && proof.CircuitVersion != circuit.CIRCUIT_VERSION:
+		return fmt.Errorf("proof was generated with circuit version %d, but this verifier supports circuit version %d and proofs that do not record one",
+			proof.CircuitVersion, circuit.CIRCUIT_VERSION)
+	case proof.CircuitVersion != 0 && (proof.Epoch == nil || proof.AccountCount == nil):
+		return fmt.Errorf("proof has a circuit version but no epoch or account count")
+	case proof.CircuitVersion != 0:
+		publicInputs = &circuit.Circuit{
+			MerkleRoot:                 proof.MerkleRoot,
+			MerkleRootWithAssetSumHash: proof.MerkleRootWithAssetSumHash,
+			AccountCount:               *proof.AccountCount,
+			Epoch:                      *proof.Epoch,
+			CircuitVersion:             proof.CircuitVersion,
+		}
+	case proof.Epoch != nil && proof.AccountCount != nil:
+		publicInputs = &publicInputsV4{
+			MerkleRoot:                 proof.MerkleRoot,
+			MerkleRootWithAssetSumHash: proof.MerkleRootWithAssetSumHash,
+			AccountCount:               *proof.AccountCount,
+			Epoch:                      *proof.Epoch,
+		}
+	case proof.Epoch != nil:
+		return fmt.Errorf("proof has an epoch but no account count")
+	case proof.AccountCount != nil:
+		publicInputs = &publicInputsV3{
+			MerkleRoot:                 proof.MerkleRoot,
+			MerkleRootWithAssetSumHash: proof.MerkleRootWithAssetSumHash,
+			AccountCount:               *proof.AccountCount,
+		}
+	default:
+		publicInputs = &publicInputsV1{
+			MerkleRoot:                 proof.MerkleRoot,
+			MerkleRootWithAssetSumHash: proof.MerkleRootWithAssetSumHash,
+		}
+	}
+	publicWitness, err := frontend.NewWitness(publicInputs, ecc.BN254.ScalarField(), frontend.PublicOnly())
+	if err != nil {
+		return fmt.Errorf("error creating public witness: %v", err)
+	}
+
+	// decode proof and verification key, and verify them with the public witness using the backend of the key
+	if proofBackend, err := ParseProofBackend(string(proof.Backend)); err != nil || proofBackend != backend {
+		return fmt.Errorf("proof records the %s backend, but its pinned verification key is of the %s backend", proof.Backend, backend)
+	}
+	proofBytes, err := base64.StdEncoding.DecodeString(proof.Proof)
+	if err != nil {
+		return fmt.Errorf("error decoding proof: %v", err)
+	}
+	vkBytes, err := base64.StdEncoding.DecodeString(verificationKey)
+	if err != nil {
+		return fmt.Errorf("error decoding verification key: %v", err)
+	}
+	return verifyCircuitProof(backend, bytes.NewBuffer(proofBytes), bytes.NewBuffer(vkBytes), publicWitness)
+}
+
+// verifyCircuitProof verifies the serialized proof against the serialized verification key and the public witness
+// with the backend the proof was generated with.
+func verifyCircuitProof(backend ProofBackend, proofReader io.Reader, vkReader io.Reader, publicWitness witness.Witness) error {
+	if backend == BACKEND_PLONK {
+		plonkProof := plonk.NewProof(ecc.BN254)
+		if _, err := plonkProof.ReadFrom(proofReader); err != nil {
+			return fmt.Errorf("error reading proof: %v", err)
+		}
+		plonkVK := plonk.NewVerifyingKey(ecc.BN254)
+		if _, err := plonkVK.ReadFrom(vkReader); err != nil {
+			return fmt.Errorf("error reading verification key: %v", err)
+		}
+		if err := plonk.Verify(plonkProof, plonkVK, publicWitness); err != nil {
+			return fmt.Errorf("proof verification failed: %v", err)
+		}
+		return nil
+	}
+
+	grothProof := groth16.NewProof(ecc.BN254)
+	if _, err := grothProof.ReadFrom(proofReader); err != nil {
+		return fmt.Errorf("error reading proof: %v", err)
+	}
+	grothVK := groth16.NewVerifyingKey(ecc.BN254)
+	if _, err := grothVK.ReadFrom(vkReader); err != nil {
+		return fmt.Errorf("error reading verification key: %v", err)
+	}
+	var opts []gnarkbackend.VerifierOption
+	if backend == BACKEND_GROTH16_RECURSIVE {
+		opts = append(opts, stdgroth16.GetNativeVerifierOptions(ecc.BN254.ScalarField(), ecc.BN254.ScalarField()))
+	}
+	if err := groth16.Verify(grothProof, grothVK, publicWitness, opts...); err != nil {
+		return fmt.Errorf("proof verification failed: %v", err)
+	}
+	return nil
+}
+
+// ComputeVerificationKeyFingerprint returns the hex encoded SHA-256 hash of the (base64 encoded) verification key
+// of a proof. It can be used to pin the verification keys trusted for a round.
+func ComputeVerificationKeyFingerprint(verificationKey string) (string, error) {
+	vkBytes, err := base64.StdEncoding.DecodeString(verificationKey)
+	if err != nil {
+		return "", fmt.Errorf("error decoding verification key: %v", err)
+	}
+	fingerprint := sha256.Sum256(vkBytes)
+	return hex.EncodeToString(fingerprint[:]), nil
+}
@@ -0,0 +1,361 @@
+package verification
+
+import (
+	"fmt"
+	"math/big"
+
+	"bitgo.com/proof_of_reserves/circuit"
+)
+
+// RecordedWalletIdEncoding returns the WalletId encoding recorded in a file, or the configured one (see
+// circuit.SetWalletIdEncoding) if the file does not record one.
+func RecordedWalletIdEncoding(recorded circuit.WalletIdEncoding) circuit.WalletIdEncoding {
+	if recorded == "" {
+		return circuit.GetWalletIdEncoding()
+	}
+	encoding, err := circuit.ParseWalletIdEncoding(string(recorded))
+	panicOnError(err, "invalid WalletId encoding")
+	return encoding
+}
+
+// ConvertRawUVBalancesToGoBalance converts the balances of a user verification package to a GoBalance in base units.
+// The balance at index i is the balance of the asset at index i of the asset registry. Display amounts are converted
+// to base units using the decimals of that asset.
+func ConvertRawUVBalancesToGoBalance(rawBalances []RawUVBalance) (circuit.GoBalance, error) {
+	return ConvertRawUVBalancesToGoBalanceOfAssets(rawBalances, ConfiguredAssets())
+}
+
+// ConvertRawUVBalancesToGoBalanceOfAssets converts the balances like ConvertRawUVBalancesToGoBalance, the balance at
+// index i being the balance of the asset at index i of assets.
+func ConvertRawUVBalancesToGoBalanceOfAssets(rawBalances []RawUVBalance, assets []circuit.Asset) (circuit.GoBalance, error) {
+	balance := make(circuit.GoBalance, len(rawBalances))
+	for i, rawBalance := range rawBalances {
+		var amount, displayAmount *big.Int
+		if rawBalance.Amount != "" {
+			var ok bool
+			amount, ok = new(big.Int).SetString(rawBalance.Amount, 10)
+			if !ok {
+				return nil, fmt.Errorf("error converting amount of %s to big.Int: %s", rawBalance.Asset, rawBalance.Amount)
+			}
+		}
+		if rawBalance.DisplayAmount != "" {
+			if i >= len(assets) {
+				return nil, fmt.Errorf("no decimals known for asset at index %d", i)
+			}
+			var err error
+			displayAmount, err = circuit.ConvertDisplayAmountToBaseUnits(rawBalance.DisplayAmount, assets[i].Decimals)
+			if err != nil {
+				return nil, fmt.Errorf("error converting display amount of %s: %w", rawBalance.Asset, err)
+			}
+		}
+
+		switch {
+		case amount == nil && displayAmount == nil:
+			return nil, fmt.Errorf("neither Amount nor DisplayAmount set for %s", rawBalance.Asset)
+		case amount != nil && displayAmount != nil && amount.Cmp(displayAmount) != 0:
+			return nil, fmt.Errorf("amount %s of %s does not match display amount %s", rawBalance.Amount, rawBalance.Asset, rawBalance.DisplayAmount)
+		case amount != nil:
+			balance[i] = amount
+		default:
+			balance[i] = displayAmount
+		}
+	}
+	return balance, nil
+}
+
+// ConvertGoBalanceToRawUVBalances converts a GoBalance to the balances of a user verification package, setting both
+// the Amount in base units and the DisplayAmount in display units of each asset.
+func ConvertGoBalanceToRawUVBalances(balance circuit.GoBalance) []RawUVBalance {
+	return ConvertGoBalanceToRawUVBalancesOfAssets(balance, ConfiguredAssets())
+}
+
+// ConvertGoBalanceToRawUVBalancesOfAssets converts the balance like ConvertGoBalanceToRawUVBalances, the balance at
+// index i being the balance of the asset at index i of assets.
+func ConvertGoBalanceToRawUVBalancesOfAssets(balance circuit.GoBalance, assets []circuit.Asset) []RawUVBalance {
+	if len(balance) != len(assets) {
+		panic(circuit.INVALID_BALANCE_LENGTH_MESSAGE)
+	}
+	rawBalances := make([]RawUVBalance, len(balance))
+	for i, amount := range balance {
+		rawBalances[i] = RawUVBalance{
+			Asset:         assets[i].Symbol,
+			Amount:        amount.String(),
+			DisplayAmount: circuit.ConvertBaseUnitsToDisplayAmount(amount, assets[i].Decimals),
+		}
+	}
+	return rawBalances
+}
+
+// ConfiguredAssets returns the assets of every balance: those of the configured asset subset, or of the asset registry
+// if there is none.
+func ConfiguredAssets() []circuit.Asset {
+	return circuit.GetAssetRegistry().SubsetAssets(circuit.GetAssetSubset())
+}
+
+// SubsetAssets returns the assets of the balances of proofs of the given asset subset (see ProofsAssetSubset): those of
+// the subset, or of the asset registry if there is none.
+// Returns an error if the asset subset is invalid
+func SubsetAssets(assetSubset []int) ([]circuit.Asset, error) {
+	if err := circuit.ValidateAssetSubset(assetSubset); err != nil {
+		return nil, err
+	}
+	return circuit.GetAssetRegistry().SubsetAssets(assetSubset), nil
+}
+
+// ConvertUserVerificationElementsToRawUserVerificationElements converts UserVerificationElements to the raw format
+// that is written to a user verification package.
+func ConvertUserVerificationElementsToRawUserVerificationElements(elements UserVerificationElements) RawUserVerificationElements {
+	if elements.ProofInfo.TopProof.AssetSum == nil {
+		panic("TopProof.AssetSum is nil, cannot convert to RawUserVerificationElements")
+	}
+	// the balances are labeled with the assets of the asset subset of the proofs
+	assets, err := SubsetAssets(elements.ProofInfo.TopProof.AssetSubset)
+	panicOnError(err, "asset subset verification failed")
+	convertLowerLevelProof := func(proof CompletedProof) RawLowerLevelProof {
+		return RawLowerLevelProof{
+			Proof:                      proof.Proof,
+			VerificationKey:            proof.VerificationKey,
+			MerkleRoot:                 proof.MerkleRoot,
+			MerkleRootWithAssetSumHash: proof.MerkleRootWithAssetSumHash,
+			MerklePosition:             proof.MerklePosition,
+			MerklePath:                 proof.MerklePath,
+			RoundId:                    proof.RoundId,
+			HashFunction:               proof.HashFunction,
+			HashVersion:                proof.HashVersion,
+			AccountBytesVersion:        proof.AccountBytesVersion,
+			LeafHashMode:               proof.LeafHashMode,
+			Backend:                    proof.Backend,
+			TreeDepth:                  proof.TreeDepth,
+			BalanceBits:                proof.BalanceBits,
+			AssetSumBits:               proof.AssetSumBits,
+			AssetRegistryHash:          proof.AssetRegistryHash,
+			AssetSubset:                proof.AssetSubset,
+			AccountCount:               proof.AccountCount,
+			Epoch:                      proof.Epoch,
+			CircuitVersion:             proof.CircuitVersion,
+			VerificationKeyFingerprint: proof.VerificationKeyFingerprint,
+		}
+	}
+	var intermediateProofs []RawLowerLevelProof
+	for _, proof := range elements.ProofInfo.IntermediateProofs {
+		intermediateProofs = append(intermediateProofs, convertLowerLevelProof(proof))
+	}
+	topProofAssetSum := ConvertGoBalanceToRawUVBalancesOfAssets(*elements.ProofInfo.TopProof.AssetSum, assets)
+
+	rawAccount := circuit.ConvertGoAccountToRawGoAccount(elements.AccountInfo)
+	return RawUserVerificationElements{
+		AccountInfo: RawUserAccountInfo{
+			WalletId:         rawAccount.WalletId,
+			Balance:          ConvertGoBalanceToRawUVBalancesOfAssets(elements.AccountInfo.Balance, assets),
+			Salt:             rawAccount.Salt,
+			HashedWalletId:   rawAccount.HashedWalletId,
+			WalletIdEncoding: circuit.GetWalletIdEncoding(),
+		},
+		ProofInfo: RawUserProofInfo{
+			UserMerklePath:         elements.ProofInfo.UserMerklePath,
+			UserMerklePosition:     elements.ProofInfo.UserMerklePosition,
+			UserMerklePathElements: elements.ProofInfo.UserMerklePathElements,
+			BottomProof:            convertLowerLevelProof(elements.ProofInfo.BottomProof),
+			MiddleProof:            convertLowerLevelProof(elements.ProofInfo.MiddleProof),
+			IntermediateProofs:     intermediateProofs,
+			TopProof: RawTopLevelProof{
+				Proof:                      elements.ProofInfo.TopProof.Proof,
+				VerificationKey:            elements.ProofInfo.TopProof.VerificationKey,
+				MerkleRoot:                 elements.ProofInfo.TopProof.MerkleRoot,
+				MerkleRootWithAssetSumHash: elements.ProofInfo.TopProof.MerkleRootWithAssetSumHash,
+				AssetSum:                   &topProofAssetSum,
+				RoundId:                    elements.ProofInfo.TopProof.RoundId,
+				HashFunction:               elements.ProofInfo.TopProof.HashFunction,
+				HashVersion:                elements.ProofInfo.TopProof.HashVersion,
+				AccountBytesVersion:        elements.ProofInfo.TopProof.AccountBytesVersion,
+				LeafHashMode:               elements.ProofInfo.TopProof.LeafHashMode,
+				Backend:                    elements.ProofInfo.TopProof.Backend,
+				TreeDepth:                  elements.ProofInfo.TopProof.TreeDepth,
+				BalanceBits:                elements.ProofInfo.TopProof.BalanceBits,
+				AssetSumBits:               elements.ProofInfo.TopProof.AssetSumBits,
+				AssetRegistryHash:          elements.ProofInfo.TopProof.AssetRegistryHash,
+				AssetSubset:                elements.ProofInfo.TopProof.AssetSubset,
+				AccountCount:               elements.ProofInfo.TopProof.AccountCount,
+				Epoch:                      elements.ProofInfo.TopProof.Epoch,
+				CircuitVersion:             elements.ProofInfo.TopProof.CircuitVersion,
+				VerificationKeyFingerprint: elements.ProofInfo.TopProof.VerificationKeyFingerprint,
+			},
+		},
+	}
+}
+
+// ConvertCompletedProofToRawCompletedProof converts a CompletedProof to the raw format that is written to files.
+func ConvertCompletedProofToRawCompletedProof(proof CompletedProof) RawCompletedProof {
+	// convert the asset sum to a slice of strings before writing
+	var rawAssetSum *[]string
+	if proof.AssetSum != nil {
+		convertedAssetSum := make([]string, len(*proof.AssetSum))
+		for i, asset := range *proof.AssetSum {
+			convertedAssetSum[i] = asset.String()
+		}
+		rawAssetSum = &convertedAssetSum
+	} else {
+		rawAssetSum = nil
+	}
+
+	return RawCompletedProof{
+		Proof:                      proof.Proof,
+		VerificationKey:            proof.VerificationKey,
+		MerkleRoot:                 proof.MerkleRoot,
+		MerkleRootWithAssetSumHash: proof.MerkleRootWithAssetSumHash,
+		MerklePath:                 proof.MerklePath,
+		MerklePosition:             proof.MerklePosition,
+		MerkleNodes:                proof.MerkleNodes,
+		AssetSum:                   rawAssetSum,
+		RoundId:                    proof.RoundId,
+		HashFunction:               proof.HashFunction,
+		HashVersion:                proof.HashVersion,
+		AccountBytesVersion:        proof.AccountBytesVersion,
+		LeafHashMode:               proof.LeafHashMode,
+		Backend:                    proof.Backend,
+		TreeDepth:                  proof.TreeDepth,
+		BalanceBits:                proof.BalanceBits,
+		AssetSumBits:               proof.AssetSumBits,
+		AssetRegistryHash:          proof.AssetRegistryHash,
+		AssetSubset:                proof.AssetSubset,
+		AccountCount:               proof.AccountCount,
+		Epoch:                      proof.Epoch,
+		CircuitVersion:             proof.CircuitVersion,
+		VerificationKeyFingerprint: proof.VerificationKeyFingerprint,
+	}
+}
+
+// ConvertRawCompletedProofToCompletedProof converts a RawCompletedProof read from a file to a CompletedProof.
+func ConvertRawCompletedProofToCompletedProof(rawProof RawCompletedProof) CompletedProof {
+	// convert the raw asset sum to a circuit.GoBalance
+	var actualAssetSum *circuit.GoBalance
+	if rawProof.AssetSum == nil {
+		actualAssetSum = nil
+	} else {
+		convertedAssetSum := make(circuit.GoBalance, len(*rawProof.AssetSum))
+		for i, asset := range *rawProof.AssetSum {
+			bigIntValue, ok := new(big.Int).SetString(asset, 10)
+			if !ok {
+				panic("Error converting asset sum string to big.Int: " + asset)
+			}
+			convertedAssetSum[i] = bigIntValue
+		}
+		actualAssetSum = &convertedAssetSum
+	}
+
+	return CompletedProof{
+		Proof:                      rawProof.Proof,
+		VerificationKey:            rawProof.VerificationKey,
+		MerkleRoot:                 rawProof.MerkleRoot,
+		MerkleRootWithAssetSumHash: rawProof.MerkleRootWithAssetSumHash,
+		MerklePath:                 rawProof.MerklePath,
+		MerklePosition:             rawProof.MerklePosition,
+		MerkleNodes:                rawProof.MerkleNodes,
+		AssetSum:                   actualAssetSum,
+		RoundId:                    rawProof.RoundId,
+		HashFunction:               rawProof.HashFunction,
+		HashVersion:                rawProof.HashVersion,
+		AccountBytesVersion:        rawProof.AccountBytesVersion,
+		LeafHashMode:               rawProof.LeafHashMode,
+		Backend:                    rawProof.Backend,
+		TreeDepth:                  rawProof.TreeDepth,
+		BalanceBits:                rawProof.BalanceBits,
+		AssetSumBits:               rawProof.AssetSumBits,
+		AssetRegistryHash:          rawProof.AssetRegistryHash,
+		AssetSubset:                rawProof.AssetSubset,
+		AccountCount:               rawProof.AccountCount,
+		Epoch:                      rawProof.Epoch,
+		CircuitVersion:             rawProof.CircuitVersion,
+		VerificationKeyFingerprint: rawProof.VerificationKeyFingerprint,
+	}
+}
+
+// ConvertRawUserVerificationElementsToUserVerificationElements converts the raw format of a user verification
+// package read from a file to UserVerificationElements.
+func ConvertRawUserVerificationElementsToUserVerificationElements(rawElements RawUserVerificationElements) UserVerificationElements {
+	// convert the top proof's asset sum to a circuit.GoBalance
+	if rawElements.ProofInfo.TopProof.AssetSum == nil {
+		panic("reading user verification elements failed: TopProof.AssetSum is nil")
+	}
+	// the balances are of the assets of the asset subset of the proofs, whose decimals convert display amounts
+	assets, err := SubsetAssets(rawElements.ProofInfo.TopProof.AssetSubset)
+	panicOnError(err, "asset subset verification failed")
+	convertedAssetSum, err := ConvertRawUVBalancesToGoBalanceOfAssets(*rawElements.ProofInfo.TopProof.AssetSum, assets)
+	panicOnError(err, "error converting top proof asset sum")
+	actualTopProofAssetSum := &convertedAssetSum
+
+	// convert user account balance from []RawUVBalance to circuit.GoBalance
+	convertedBalance, err := ConvertRawUVBalancesToGoBalanceOfAssets(rawElements.AccountInfo.Balance, assets)
+	panicOnError(err, "error converting account balance")
+
+	// convert the proofs below the top proof, which have no AssetSum
+	convertRawLowerLevelProof := func(proof RawLowerLevelProof) CompletedProof {
+		return CompletedProof{
+			Proof:                      proof.Proof,
+			VerificationKey:            proof.VerificationKey,
+			MerkleRoot:                 proof.MerkleRoot,
+			MerkleRootWithAssetSumHash: proof.MerkleRootWithAssetSumHash,
+			MerklePath:                 proof.MerklePath,
+			MerklePosition:             proof.MerklePosition,
+			RoundId:                    proof.RoundId,
+			HashFunction:               proof.HashFunction,
+			HashVersion:                proof.HashVersion,
+			AccountBytesVersion:        proof.AccountBytesVersion,
+			LeafHashMode:               proof.LeafHashMode,
+			Backend:                    proof.Backend,
+			TreeDepth:                  proof.TreeDepth,
+			BalanceBits:                proof.BalanceBits,
+			AssetSumBits:               proof.AssetSumBits,
+			AssetRegistryHash:          proof.AssetRegistryHash,
+			AssetSubset:                proof.AssetSubset,
+			AccountCount:               proof.AccountCount,
+			Epoch:                      proof.Epoch,
+			CircuitVersion:             proof.CircuitVersion,
+			VerificationKeyFingerprint: proof.VerificationKeyFingerprint,
+		}
+	}
+	var intermediateProofs []CompletedProof
+	for _, proof := range rawElements.ProofInfo.IntermediateProofs {
+		intermediateProofs = append(intermediateProofs, convertRawLowerLevelProof(proof))
+	}
+
+	// construct the UserVerificationElements from the raw data
+	return UserVerificationElements{
+		AccountInfo: circuit.ConvertRawGoAccountToGoAccountWithEncoding(circuit.RawGoAccount{
+			WalletId:       rawElements.AccountInfo.WalletId,
+			Balance:        convertedBalance,
+			Salt:           rawElements.AccountInfo.Salt,
+			HashedWalletId: rawElements.AccountInfo.HashedWalletId,
+		}, RecordedWalletIdEncoding(rawElements.AccountInfo.WalletIdEncoding)),
+		ProofInfo: UserProofInfo{
+			UserMerklePath:         rawElements.ProofInfo.UserMerklePath,
+			UserMerklePosition:     rawElements.ProofInfo.UserMerklePosition,
+			UserMerklePathElements: rawElements.ProofInfo.UserMerklePathElements,
+			BottomProof:            convertRawLowerLevelProof(rawElements.ProofInfo.BottomProof),
+			MiddleProof:            convertRawLowerLevelProof(rawElements.ProofInfo.MiddleProof),
+			IntermediateProofs:     intermediateProofs,
+			TopProof: CompletedProof{
+				Proof:                      rawElements.ProofInfo.TopProof.Proof,
+				VerificationKey:            rawElements.ProofInfo.TopProof.VerificationKey,
+				MerkleRoot:                 rawElements.ProofInfo.TopProof.MerkleRoot,
+				MerkleRootWithAssetSumHash: rawElements.ProofInfo.TopProof.MerkleRootWithAssetSumHash,
+				AssetSum:                   actualTopProofAssetSum,
+				RoundId:                    rawElements.ProofInfo.TopProof.RoundId,
+				HashFunction:               rawElements.ProofInfo.TopProof.HashFunction,
+				HashVersion:                rawElements.ProofInfo.TopProof.HashVersion,
+				AccountBytesVersion:        rawElements.ProofInfo.TopProof.AccountBytesVersion,
+				LeafHashMode:               rawElements.ProofInfo.TopProof.LeafHashMode,
+				Backend:                    rawElements.ProofInfo.TopProof.Backend,
+				TreeDepth:                  rawElements.ProofInfo.TopProof.TreeDepth,
+				BalanceBits:                rawElements.ProofInfo.TopProof.BalanceBits,
+				AssetSumBits:               rawElements.ProofInfo.TopProof.AssetSumBits,
+				AssetRegistryHash:          rawElements.ProofInfo.TopProof.AssetRegistryHash,
+				AssetSubset:                rawElements.ProofInfo.TopProof.AssetSubset,
+				AccountCount:               rawElements.ProofInfo.TopProof.AccountCount,
+				Epoch:                      rawElements.ProofInfo.TopProof.Epoch,
+				CircuitVersion:             rawElements.ProofInfo.TopProof.CircuitVersion,
+				VerificationKeyFingerprint: rawElements.ProofInfo.TopProof.VerificationKeyFingerprint,
+			},
+		},
+	}
+}
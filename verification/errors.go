@@ -0,0 +1,75 @@
+package verification
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+)
+
+// VerificationError is returned instead of panicking when a round or a user verification package fails verification,
+// or can't be read (see core.VerifyFullWithError and core.VerifyUserWithError).
+type VerificationError struct {
+	// Stage is the check that failed, e.g. "round id verification failed" (empty if it is not known).
+	Stage string
+	Err   error
+}
+
+func (e *VerificationError) Error() string {
+	return "verification failed: " + joinStage(e.Stage, e.Err)
+}
+
+func (e *VerificationError) Unwrap() error {
+	return e.Err
+}
+
+// StageError is the error the functions of this package that panic panic with: the error of the check that failed,
+// with the check (e.g. "asset subset verification failed"), so recovering callers keep both (see
+// recoverVerificationError).
+type StageError struct {
+	Stage string
+	Err   error
+}
+
+func (e *StageError) Error() string {
+	return joinStage(e.Stage, e.Err)
+}
+
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+// joinStage returns the message of the error prefixed with the stage it happened in, if known.
+func joinStage(stage string, err error) string {
+	if stage == "" {
+		return err.Error()
+	}
+	return stage + ": " + err.Error()
+}
+
+func panicOnError(err error, messagePrefix string) {
+	if err != nil {
+		panic(&StageError{Stage: messagePrefix, Err: err})
+	}
+}
+
+// recoverVerificationError sets err to a *VerificationError if verification failed with a panic in a check of its
+// own. Runtime errors are bugs rather than failures to verify, so they keep panicking.
+func recoverVerificationError(err *error) {
+	if r := recover(); r != nil {
+		var stage string
+		var cause error
+		switch value := r.(type) {
+		case runtime.Error:
+			panic(value)
+		case *StageError:
+			stage, cause = value.Stage, value.Err
+		case error:
+			cause = value
+		case string:
+			cause = errors.New(value)
+		default:
+			cause = fmt.Errorf("%v", value)
+		}
+		*err = &VerificationError{Stage: stage, Err: cause}
+	}
+}
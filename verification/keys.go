@@ -0,0 +1,172 @@
+package verification
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"time"
+
+	"bitgo.com/proof_of_reserves/circuit"
+)
+
+// PinnedVerificationKey is a verification key trusted for the proofs of a layer, obtained out of band.
+type PinnedVerificationKey struct {
+	// VerificationKey is the (base64 encoded) key, or empty if it is pinned by its fingerprint alone (see
+	// core.PinVerificationKeyFingerprints), in which case it is the key the proof comes with.
+	VerificationKey string
+	// Backend is the backend the proofs of the key are verified with, whatever backend they record.
+	Backend ProofBackend
+}
+
+// VerificationKeyPins are trusted verification keys by layer and fingerprint (see core.GetPinnedVerificationKeys).
+type VerificationKeyPins map[string]map[string]PinnedVerificationKey
+
+// verificationKey returns the key to verify the proof of the given layer with, and the backend to verify it with: the
+// pinned key of its fingerprint for the layer. The fingerprint of a proof that includes its key is that of the key
+// (which must match the proof's VerificationKeyFingerprint, if it has one).
+func (pins VerificationKeyPins) VerificationKey(proof CompletedProof, layer string) (string, ProofBackend, error) {
+	fingerprint := proof.VerificationKeyFingerprint
+	if proof.VerificationKey != "" {
+		keyFingerprint, err := ComputeVerificationKeyFingerprint(proof.VerificationKey)
+		if err != nil {
+			return "", "", err
+		}
+		if fingerprint != "" && fingerprint != keyFingerprint {
+			return "", "", fmt.Errorf("verification key does not match its fingerprint %s", fingerprint)
+		}
+		fingerprint = keyFingerprint
+	}
+	if len(pins[layer]) == 0 {
+		return "", "", fmt.Errorf("no verification keys are pinned for the %s layer, so its proofs could only be verified with the keys they come with", layer)
+	}
+	pinned, ok := pins[layer][fingerprint]
+	if !ok {
+		return "", "", fmt.Errorf("verification key %s is not pinned for the %s layer", fingerprint, layer)
+	}
+	if pinned.VerificationKey == "" {
+		if proof.VerificationKey == "" {
+			return "", "", fmt.Errorf("proof references verification key %s, which is only pinned by its fingerprint", fingerprint)
+		}
+		return proof.VerificationKey, pinned.Backend, nil
+	}
+	return pinned.VerificationKey, pinned.Backend, nil
+}
+
+// VerificationKeys returns the published verification keys by fingerprint.
+func (keys PublishedVerificationKeys) VerificationKeys() map[string]string {
+	verificationKeys := make(map[string]string, len(keys.Keys))
+	for _, entry := range keys.Keys {
+		verificationKeys[entry.Fingerprint] = entry.VerificationKey
+	}
+	return verificationKeys
+}
+
+// Validate checks every published verification key matches its fingerprint.
+func (keys PublishedVerificationKeys) Validate() error {
+	for _, entry := range keys.Keys {
+		fingerprint, err := ComputeVerificationKeyFingerprint(entry.VerificationKey)
+		if err != nil {
+			return fmt.Errorf("%s level verification key %s: %w", entry.Layer, entry.Fingerprint, err)
+		}
+		if fingerprint != entry.Fingerprint {
+			return fmt.Errorf("%s level verification key does not match its fingerprint %s", entry.Layer, entry.Fingerprint)
+		}
+	}
+	return nil
+}
+
+// VerifyKeyBundleForDomain verifies a signed key bundle like core.VerifyKeyBundle, for the given deployment domain
+// instead of the configured one.
+func VerifyKeyBundleForDomain(signed SignedKeyBundle, trustedPublicKeys []ed25519.PublicKey, now time.Time, domain string) (KeyBundle, error) {
+	var bundle KeyBundle
+	if err := VerifySignedJson("key bundle", SIGNING_CONTEXT_KEY_BUNDLE, signed.Payload, signed.PublicKey, signed.Signature, trustedPublicKeys, &bundle); err != nil {
+		return KeyBundle{}, err
+	}
+
+	if now.Before(bundle.NotBefore) || !now.Before(bundle.NotAfter) {
+		return KeyBundle{}, fmt.Errorf("key bundle is only valid from %s to %s", bundle.NotBefore.Format(time.RFC3339), bundle.NotAfter.Format(time.RFC3339))
+	}
+	if bundle.CircuitVersion != circuit.CIRCUIT_VERSION || bundle.TreeDepth != circuit.GetTreeDepth() || bundle.AssetCount != circuit.GetNumberOfAssets() {
+		return KeyBundle{}, fmt.Errorf("key bundle is for circuit version %d with tree depth %d and %d assets, expected version %d with tree depth %d and %d assets",
+			bundle.CircuitVersion, bundle.TreeDepth, bundle.AssetCount, circuit.CIRCUIT_VERSION, circuit.GetTreeDepth(), circuit.GetNumberOfAssets())
+	}
+	if bundle.DeploymentDomain != domain {
+		return KeyBundle{}, fmt.Errorf("key bundle is for deployment domain %q, expected %q", bundle.DeploymentDomain, domain)
+	}
+	for _, entry := range bundle.Keys {
+		fingerprint, err := ComputeVerificationKeyFingerprint(entry.VerificationKey)
+		if err != nil || fingerprint != entry.Fingerprint {
+			return KeyBundle{}, fmt.Errorf("fingerprint of %s level key %s does not match its verification key", entry.Layer, entry.Fingerprint)
+		}
+	}
+	return bundle, nil
+}
+
+// Fingerprints returns the fingerprints of every key in the bundle.
+func (bundle KeyBundle) Fingerprints() []string {
+	fingerprints := make([]string, len(bundle.Keys))
+	for i, entry := range bundle.Keys {
+		fingerprints[i] = entry.Fingerprint
+	}
+	return fingerprints
+}
+
+// VerificationKeys returns the verification keys of the bundle by fingerprint, to resolve the keys referenced by user
+// verification packages with ResolveVerificationKeys.
+func (bundle KeyBundle) VerificationKeys() map[string]string {
+	verificationKeys := make(map[string]string, len(bundle.Keys))
+	for _, entry := range bundle.Keys {
+		verificationKeys[entry.Fingerprint] = entry.VerificationKey
+	}
+	return verificationKeys
+}
+
+// ResolveVerificationKey sets the verification key of a proof that references it by fingerprint. Proofs that include
+// their verification key are returned unchanged, as long as it matches the fingerprint (if there is one).
+func ResolveVerificationKey(proof CompletedProof, layer string, verificationKeys map[string]string) (CompletedProof, error) {
+	if proof.VerificationKey != "" {
+		if proof.VerificationKeyFingerprint != "" {
+			fingerprint, err := ComputeVerificationKeyFingerprint(proof.VerificationKey)
+			if err != nil || fingerprint != proof.VerificationKeyFingerprint {
+				return CompletedProof{}, fmt.Errorf("%s level verification key does not match its fingerprint %s", layer, proof.VerificationKeyFingerprint)
+			}
+		}
+		return proof, nil
+	}
+	if proof.VerificationKeyFingerprint == "" {
+		return CompletedProof{}, fmt.Errorf("%s level proof has no verification key or fingerprint", layer)
+	}
+	verificationKey, ok := verificationKeys[proof.VerificationKeyFingerprint]
+	if !ok {
+		return CompletedProof{}, fmt.Errorf("%s level verification key %s is not in the key bundle", layer, proof.VerificationKeyFingerprint)
+	}
+	proof.VerificationKey = verificationKey
+	return proof, nil
+}
+
+// ResolveVerificationKeys sets the verification keys referenced by fingerprint in a user verification package (see
+// core.ReferenceVerificationKeys) from the given keys by fingerprint, e.g. the VerificationKeys of a verified key
+// bundle. Packages that include their verification keys are returned unchanged, so both forms can be resolved before
+// core.VerifyUser.
+func ResolveVerificationKeys(elements UserVerificationElements, verificationKeys map[string]string) (UserVerificationElements, error) {
+	var err error
+	if elements.ProofInfo.BottomProof, err = ResolveVerificationKey(elements.ProofInfo.BottomProof, "bottom", verificationKeys); err != nil {
+		return UserVerificationElements{}, err
+	}
+	if elements.ProofInfo.MiddleProof, err = ResolveVerificationKey(elements.ProofInfo.MiddleProof, "mid", verificationKeys); err != nil {
+		return UserVerificationElements{}, err
+	}
+	if len(elements.ProofInfo.IntermediateProofs) > 0 {
+		levelCount := len(elements.ProofInfo.IntermediateProofs) + MIN_HIERARCHY_LEVELS
+		intermediateProofs := make([]CompletedProof, len(elements.ProofInfo.IntermediateProofs))
+		for i, proof := range elements.ProofInfo.IntermediateProofs {
+			if intermediateProofs[i], err = ResolveVerificationKey(proof, LayerName(i+2, levelCount), verificationKeys); err != nil {
+				return UserVerificationElements{}, err
+			}
+		}
+		elements.ProofInfo.IntermediateProofs = intermediateProofs
+	}
+	if elements.ProofInfo.TopProof, err = ResolveVerificationKey(elements.ProofInfo.TopProof, "top", verificationKeys); err != nil {
+		return UserVerificationElements{}, err
+	}
+	return elements, nil
+}
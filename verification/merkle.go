@@ -0,0 +1,82 @@
+package verification
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+
+	"bitgo.com/proof_of_reserves/circuit"
+)
+
+// VerifyMerklePath verifies that a particular hash and merkle path lead to the given merkle root, in a tree of the
+// depth and hashing of the configuration
+func VerifyMerklePath(hash Hash, hashPosition int, path []Hash, root Hash, config circuit.Config) error {
+	computedRoot, err := ComputeMerklePathRoot(hash, hashPosition, path, config)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(computedRoot, root) {
+		return fmt.Errorf("merkle proof path verification failed")
+	}
+	return nil
+}
+
+// ComputeMerklePathRoot returns the merkle root a particular hash and merkle path lead to, in a tree of the depth and
+// hashing of the configuration
+func ComputeMerklePathRoot(hash Hash, hashPosition int, path []Hash, config circuit.Config) (Hash, error) {
+	if len(path) != config.TreeDepth {
+		return nil, fmt.Errorf("merkle path is not of depth of tree: expected length %d, found %d", config.TreeDepth, len(path))
+	}
+	if hashPosition < 0 || hashPosition >= circuit.PowOfTwo(config.TreeDepth) {
+		return nil, fmt.Errorf("hashPosition out of bounds")
+	}
+
+	hasher := circuit.NewGoHasher(config.GetHashFunction())
+	curr := hash
+	currPos := hashPosition
+	var err error
+	for i, sibling := range path {
+		depth := strconv.Itoa(len(path) - i)
+
+		// if currPos odd, should hash sibling first, so swap them
+		if currPos%2 == 1 {
+			curr, sibling = sibling, curr
+		}
+		curr, err = config.GoComputeHashOfTwoNodes(hasher, curr, sibling, "current node at depth "+depth, "sibling node at depth "+depth)
+
+		if err != nil {
+			return nil, err
+		}
+
+		// update currPos to be the index of the parent of curr and sibling
+		currPos /= 2
+	}
+	return curr, nil
+}
+
+// VerifyMerklePathElements verifies the merkle path elements like core.VerifyMerklePathElements, in a tree of the depth
+// and hashing of the configuration.
+func VerifyMerklePathElements(hash Hash, path []circuit.MerklePathElement, root Hash, config circuit.Config) error {
+	if len(path) != config.TreeDepth {
+		return fmt.Errorf("merkle path is not of depth of tree: expected length %d, found %d", config.TreeDepth, len(path))
+	}
+
+	hasher := circuit.NewGoHasher(config.GetHashFunction())
+	curr := hash
+	var err error
+	for i, element := range path {
+		depth := strconv.Itoa(len(path) - i)
+		left, right := curr, element.Sibling
+		if element.IsLeft {
+			left, right = right, left
+		}
+		curr, err = config.GoComputeHashOfTwoNodes(hasher, left, right, "left node at depth "+depth, "right node at depth "+depth)
+		if err != nil {
+			return err
+		}
+	}
+	if !bytes.Equal(curr, root) {
+		return fmt.Errorf("merkle proof path verification failed")
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package verification
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+
+	"bitgo.com/proof_of_reserves/circuit"
+)
+
+// verifies the MerkleRootAssetSumHash of the top layer proof is indeed the hash of its merkleRoot and assetSum, hashed
+// as the configuration hashes pseudo-accounts
+// Returns nil if verification passes, error if it fails
+func VerifyTopLayerProofMatchesAssetSum(topLayerProof CompletedProof, config circuit.Config) error {
+	if topLayerProof.AssetSum == nil {
+		return fmt.Errorf("top layer proof's AssetSum is nil")
+	}
+
+	computedHash := config.GoComputeRootWithAssetSumHash(topLayerProof.MerkleRoot, *topLayerProof.AssetSum, EpochOf(topLayerProof))
+	if !bytes.Equal(computedHash, topLayerProof.MerkleRootWithAssetSumHash) {
+		return fmt.Errorf("top layer proof's MerkleRootWithAssetSumHash does not match the hash computed from MerkleRoot and AssetSum")
+	}
+	return nil
+}
+
+// VerifyProofsBelongToRound verifies that every proof of the layer is tagged with the id of the round (given by the
+// round metadata), so artifacts of different rounds that were misplaced are reported before verifying any proof.
+// Proofs of rounds generated before artifacts were tagged have no round id, and neither does their metadata.
+func VerifyProofsBelongToRound(roundId string, layer string, proofs []CompletedProof) error {
+	for i, proof := range proofs {
+		if proof.RoundId != roundId {
+			return fmt.Errorf("%s level proof %d belongs to round %q, but the round is %q (artifacts of different rounds are mixed)", layer, i, proof.RoundId, roundId)
+		}
+	}
+	return nil
+}
+
+// VerifyBalanceBits verifies that the upper level proof range checks its balances to the bits the lower level proof
+// range checks its asset sum to, as the asset sum is the balance of the lower level proof's pseudo-account. Proofs
+// that do not record them range checked both to circuit.BALANCE_BITS.
+func VerifyBalanceBits(lowerProof CompletedProof, upperProof CompletedProof) error {
+	assetSumBits := circuit.Config{BalanceBits: lowerProof.BalanceBits, AssetSumBits: lowerProof.AssetSumBits}.GetAssetSumBits()
+	balanceBits := circuit.Config{BalanceBits: upperProof.BalanceBits}.GetBalanceBits()
+	if assetSumBits != balanceBits {
+		return fmt.Errorf("proof range checks its asset sum to %d bits, but its upper level proof range checks balances to %d bits", assetSumBits, balanceBits)
+	}
+	return nil
+}
+
+// VerifyMerklePositionCovered verifies that the merkle position is one of the leaves the proof covers, i.e. not a
+// padding leaf. Proofs that do not record an account count pass.
+func VerifyMerklePositionCovered(proof CompletedProof, position int) error {
+	if proof.AccountCount != nil && position >= *proof.AccountCount {
+		return fmt.Errorf("merkle position %d is a padding leaf of a proof covering %d accounts", position, *proof.AccountCount)
+	}
+	return nil
+}
+
+// ProofsHashFunction returns the hash function the proofs were generated with, which must be the same for every proof
+// (proofs that do not record a hash function used MiMC).
+// Returns an error if the proofs have different or unknown hash functions
+func ProofsHashFunction(proofs []CompletedProof) (circuit.HashFunction, error) {
+	hashFunction := circuit.HASH_MIMC
+	for i, proof := range proofs {
+		parsed, err := circuit.ParseHashFunction(string(proof.HashFunction))
+		if err != nil {
+			return "", fmt.Errorf("proof %d: %w", i, err)
+		}
+		if i > 0 && parsed != hashFunction {
+			return "", fmt.Errorf("proof %d uses hash function %s, but proof 0 uses %s (proofs of different hash functions are mixed)", i, parsed, hashFunction)
+		}
+		hashFunction = parsed
+	}
+	return hashFunction, nil
+}
+
+// ProofsHashVersion returns the hash version the proofs were generated with, which must be the same for every proof
+// (proofs that do not record a hash version are untagged).
+// Returns an error if the proofs have different or unknown hash versions
+func ProofsHashVersion(proofs []CompletedProof) (circuit.HashVersion, error) {
+	hashVersion := circuit.HASH_VERSION_UNTAGGED
+	for i, proof := range proofs {
+		parsed, err := circuit.ParseHashVersion(int(proof.HashVersion))
+		if err != nil {
+			return 0, fmt.Errorf("proof %d: %w", i, err)
+		}
+		if i > 0 && parsed != hashVersion {
+			return 0, fmt.Errorf("proof %d uses hash version %d, but proof 0 uses %d (proofs of different hash versions are mixed)", i, parsed, hashVersion)
+		}
+		hashVersion = parsed
+	}
+	return hashVersion, nil
+}
+
+// ProofsAccountBytesVersion returns the account bytes version the proofs were generated with, which must be the same
+// for every proof (proofs that do not record an account bytes version use version 1).
+// Returns an error if the proofs have different or unknown account bytes versions
+func ProofsAccountBytesVersion(proofs []CompletedProof) (circuit.AccountBytesVersion, error) {
+	accountBytesVersion := circuit.ACCOUNT_BYTES_VERSION_1
+	for i, proof := range proofs {
+		parsed, err := circuit.ParseAccountBytesVersion(int(proof.AccountBytesVersion))
+		if err != nil {
+			return 0, fmt.Errorf("proof %d: %w", i, err)
+		}
+		if i > 0 && parsed != accountBytesVersion {
+			return 0, fmt.Errorf("proof %d uses account bytes version %d, but proof 0 uses %d (proofs of different account bytes versions are mixed)", i, parsed, accountBytesVersion)
+		}
+		accountBytesVersion = parsed
+	}
+	return accountBytesVersion, nil
+}
+
+// ProofsLeafHashMode returns the leaf hash mode the proofs were generated with, which must be the same for every proof
+// (proofs that do not record a leaf hash mode hash their leaves with the hash function).
+// Returns an error if the proofs have different or unknown leaf hash modes
+func ProofsLeafHashMode(proofs []CompletedProof) (circuit.LeafHashMode, error) {
+	leafHashMode := circuit.LEAF_HASH_NATIVE
+	for i, proof := range proofs {
+		parsed, err := circuit.ParseLeafHashMode(string(proof.LeafHashMode))
+		if err != nil {
+			return "", fmt.Errorf("proof %d: %w", i, err)
+		}
+		if i > 0 && parsed != leafHashMode {
+			return "", fmt.Errorf("proof %d uses leaf hash mode %s, but proof 0 uses %s (proofs of different leaf hash modes are mixed)", i, parsed, leafHashMode)
+		}
+		leafHashMode = parsed
+	}
+	return leafHashMode, nil
+}
+
+// ProofsTreeDepth returns the tree depth the proofs were generated with, which must be the same for every proof
+// (proofs that do not record a tree depth used circuit.TREE_DEPTH).
+// Returns an error if the proofs have different or invalid tree depths
+func ProofsTreeDepth(proofs []CompletedProof) (int, error) {
+	treeDepth := circuit.TREE_DEPTH
+	for i, proof := range proofs {
+		proofTreeDepth := proof.TreeDepth
+		if proofTreeDepth == 0 {
+			proofTreeDepth = circuit.TREE_DEPTH
+		}
+		if err := (circuit.Config{TreeDepth: proofTreeDepth}).Validate(); err != nil {
+			return 0, fmt.Errorf("proof %d: %w", i, err)
+		}
+		if i > 0 && proofTreeDepth != treeDepth {
+			return 0, fmt.Errorf("proof %d has tree depth %d, but proof 0 has tree depth %d (proofs of different tree depths are mixed)", i, proofTreeDepth, treeDepth)
+		}
+		treeDepth = proofTreeDepth
+	}
+	return treeDepth, nil
+}
+
+// ProofsEpoch returns the epoch the proofs are bound to, which must be the same for every proof, so the layers of
+// a round attest to a single snapshot (nil if no proof records an epoch, i.e. the proofs predate epochs).
+// Returns an error if the proofs have different epochs
+func ProofsEpoch(proofs []CompletedProof) (*uint64, error) {
+	var epoch *uint64
+	for i, proof := range proofs {
+		if i > 0 && (proof.Epoch == nil) != (epoch == nil) {
+			return nil, fmt.Errorf("proof %d and proof 0 disagree on recording an epoch (proofs of different epochs are mixed)", i)
+		}
+		if i > 0 && proof.Epoch != nil && *proof.Epoch != *epoch {
+			return nil, fmt.Errorf("proof %d has epoch %d, but proof 0 has epoch %d (proofs of different epochs are mixed)", i, *proof.Epoch, *epoch)
+		}
+		epoch = proof.Epoch
+	}
+	return epoch, nil
+}
+
+// EpochOf returns the epoch the proof is bound to (zero for proofs bound to no epoch).
+func EpochOf(proof CompletedProof) uint64 {
+	if proof.Epoch == nil {
+		return 0
+	}
+	return *proof.Epoch
+}
+
+// VerifyAssetRegistry verifies that the proofs were generated with the configured asset registry, so balances are
+// not attributed to the wrong assets (proofs that do not record an asset registry pass).
+func VerifyAssetRegistry(proofs []CompletedProof) error {
+	registryHash := circuit.GetAssetRegistry().Hash()
+	for i, proof := range proofs {
+		if proof.AssetRegistryHash != "" && proof.AssetRegistryHash != registryHash {
+			return fmt.Errorf("proof %d was generated with asset registry %s, but the configured asset registry is %s", i, proof.AssetRegistryHash, registryHash)
+		}
+	}
+	return nil
+}
+
+// ProofsAssetSubset returns the asset subset the proofs were generated with (nil for every asset), which must be the
+// same for every proof.
+// Returns an error if the proofs have different or invalid asset subsets
+func ProofsAssetSubset(proofs []CompletedProof) ([]int, error) {
+	var assetSubset []int
+	for i, proof := range proofs {
+		if err := circuit.ValidateAssetSubset(proof.AssetSubset); err != nil {
+			return nil, fmt.Errorf("proof %d: %w", i, err)
+		}
+		if i > 0 && !slices.Equal(proof.AssetSubset, assetSubset) {
+			return nil, fmt.Errorf("proof %d has asset subset %v, but proof 0 has %v (proofs of different asset subsets are mixed)", i, proof.AssetSubset, assetSubset)
+		}
+		assetSubset = proof.AssetSubset
+	}
+	return assetSubset, nil
+}
+
+// ProofsConfig returns the configuration the proofs were generated with: the configured one, with the hash function,
+// hash version, account bytes version, leaf hash mode, tree depth, and number of assets of their asset subset they
+// record, which must be the same for every proof.
+// Returns an error if the proofs have different or invalid parameters
+func ProofsConfig(proofs []CompletedProof) (circuit.Config, error) {
+	config := circuit.GetConfig()
+	var err error
+	if config.HashFunction, err = ProofsHashFunction(proofs); err != nil {
+		return circuit.Config{}, err
+	}
+	if config.HashVersion, err = ProofsHashVersion(proofs); err != nil {
+		return circuit.Config{}, err
+	}
+	if config.AccountBytesVersion, err = ProofsAccountBytesVersion(proofs); err != nil {
+		return circuit.Config{}, err
+	}
+	if config.LeafHashMode, err = ProofsLeafHashMode(proofs); err != nil {
+		return circuit.Config{}, err
+	}
+	if config.TreeDepth, err = ProofsTreeDepth(proofs); err != nil {
+		return circuit.Config{}, err
+	}
+	assetSubset, err := ProofsAssetSubset(proofs)
+	if err != nil {
+		return circuit.Config{}, err
+	}
+	config.AssetCount = len(circuit.GetAssetRegistry().SubsetAssets(assetSubset))
+	return config, nil
+}
@@ -0,0 +1,63 @@
+package verification
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// SigningContext identifies the type of a signed payload. It is prefixed to every signed message, so a signature of
+// one type of payload (e.g. a batch receipt) can't be passed off as a signature of another (e.g. a key bundle) when
+// the same key signs both.
+type SigningContext string
+
+const (
+	SIGNING_CONTEXT_KEY_BUNDLE       SigningContext = "bitgo.com/proof_of_reserves/key_bundle/v1"
+	SIGNING_CONTEXT_BATCH_RECEIPT    SigningContext = "bitgo.com/proof_of_reserves/batch_receipt/v1"
+	SIGNING_CONTEXT_RELEASE_MANIFEST SigningContext = "bitgo.com/proof_of_reserves/release_manifest/v1"
+	SIGNING_CONTEXT_ROUND_ARTIFACT   SigningContext = "bitgo.com/proof_of_reserves/round_artifact/v1"
+)
+
+// message returns the message signed for the given payload in the context: the context, and each part of the payload
+// (e.g. a file name and its contents), separated by zero bytes.
+func (c SigningContext) Message(parts ...[]byte) []byte {
+	message := []byte(c)
+	for _, part := range parts {
+		message = append(append(message, 0), part...)
+	}
+	return message
+}
+
+// VerifySignedJson verifies that the payload is signed in the given context by one of the trusted public keys and
+// decodes it into value. name describes the payload in errors.
+func VerifySignedJson(name string, context SigningContext, payload string, publicKey string, signature string, trustedPublicKeys []ed25519.PublicKey, value interface{}) error {
+	decodedPublicKey, err := hex.DecodeString(publicKey)
+	if err != nil || len(decodedPublicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid %s public key", name)
+	}
+	trusted := false
+	for _, trustedPublicKey := range trustedPublicKeys {
+		if bytes.Equal(decodedPublicKey, trustedPublicKey) {
+			trusted = true
+		}
+	}
+	if !trusted {
+		return fmt.Errorf("%s is signed by untrusted key %s", name, publicKey)
+	}
+
+	decodedPayload, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return fmt.Errorf("error decoding %s payload: %w", name, err)
+	}
+	decodedSignature, err := hex.DecodeString(signature)
+	if err != nil || !ed25519.Verify(decodedPublicKey, context.Message(decodedPayload), decodedSignature) {
+		return fmt.Errorf("%s signature verification failed", name)
+	}
+	if err := json.Unmarshal(decodedPayload, value); err != nil {
+		return fmt.Errorf("error decoding %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,270 @@
+package verification
+
+import (
+	"time"
+
+	"bitgo.com/proof_of_reserves/circuit"
+)
+
+// Hash is a node of a Merkle tree.
+type Hash = circuit.Hash
+
+// CompletedProof is an output of the prover. It contains the proof, public data, and (optionally) the full list of merkle nodes (hashes).
+// It can be published if it meets the following criteria:
+//  1. If this is a top level proof, the MerklePath are set to nil, MerklePosition is 0, and AssetSum is properly defined.
+//  2. If this is not a top level proof, the MerklePath and MerklePosition are properly defined, and AssetSum is nil.
+//  3. The MerkleNode field has been set to nil (we don't want to publish all the hashes).
+type CompletedProof struct {
+	Proof                      string
+	VerificationKey            string
+	MerkleRoot                 []byte
+	MerkleRootWithAssetSumHash []byte
+
+	// MerklePath, MerklePosition, MerkleNodes, AssetSum are optional, depending on the case.
+	MerklePath     []Hash
+	MerklePosition int
+	MerkleNodes    [][]Hash
+	AssetSum       *circuit.GoBalance
+
+	// RoundId identifies the round the proof belongs to (empty for rounds generated before proofs were tagged).
+	RoundId string
+
+	// HashFunction is the hash function of the proof's accounts and nodes (empty for proofs generated before it was
+	// recorded, which used MiMC). See circuit.SetHashFunction.
+	HashFunction circuit.HashFunction
+
+	// HashVersion is the hash version of the proof's accounts and nodes (0 for proofs generated before it was recorded,
+	// whose hashes are untagged). See circuit.SetHashVersion.
+	HashVersion circuit.HashVersion
+	// AccountBytesVersion is the canonical byte layout the proof's accounts are hashed from (0 for proofs generated
+	// before it was recorded, which used version 1). See circuit.CanonicalAccountBytes.
+	AccountBytesVersion circuit.AccountBytesVersion
+	// LeafHashMode is the leaf hash mode of the proof's accounts (empty for proofs generated before it was recorded,
+	// whose leaves use the hash function). See circuit.SetLeafHashMode.
+	LeafHashMode circuit.LeafHashMode
+
+	// Backend is the proof system the proof was generated with (empty for proofs generated before it was recorded,
+	// which used Groth16). See core.SetProofBackend.
+	Backend ProofBackend
+
+	// TreeDepth is the depth of the proof's Merkle tree (0 for proofs generated before it was recorded, which used
+	// circuit.TREE_DEPTH). See circuit.SetConfig.
+	TreeDepth int
+	// BalanceBits and AssetSumBits are the numbers of bits the proof's balances and asset sum are range checked to,
+	// except for assets with their own (see circuit.Asset.BalanceBits, which AssetRegistryHash covers). They are 0 for
+	// proofs generated before they were recorded, which range checked both to circuit.BALANCE_BITS. See
+	// circuit.Config.
+	BalanceBits  int
+	AssetSumBits int
+
+	// AssetRegistryHash is the hash of the asset registry of the proof's balances (empty for proofs generated before it
+	// was recorded, which used circuit.DefaultAssetRegistry()). See circuit.SetAssetRegistry.
+	AssetRegistryHash string
+	// AssetSubset are the indices of the assets of the asset registry the proof's balances have (nil for every asset),
+	// which are public data of the proof, so a proof over a subset of the assets is not mistaken for a proof over all of
+	// them. See circuit.SetAssetSubset.
+	AssetSubset []int
+
+	// AccountCount is the number of accounts (or lower level proofs) the proof covers, which is a public input of the
+	// proof (nil for proofs generated before it was, i.e. before circuit version 3).
+	AccountCount *int
+	// Epoch identifies the snapshot the proof attests to, which is a public input of the proof and is hashed into
+	// MerkleRootWithAssetSumHash, so every layer of a round is bound to it (nil for proofs generated before it was, i.e.
+	// before circuit version 4). See core.Options.ProofEpoch.
+	Epoch *uint64
+	// CircuitVersion is the version of the circuit the proof was generated with (see circuit.CIRCUIT_VERSION), which is
+	// a public input of the proof (0 for proofs generated before it was, i.e. before circuit version 7).
+	CircuitVersion int
+
+	// VerificationKeyFingerprint is set instead of VerificationKey in user verification packages that reference
+	// verification keys (see core.ReferenceVerificationKeys), and is resolved from a key bundle before verification.
+	VerificationKeyFingerprint string
+}
+
+// RawCompletedProof is a raw version of CompletedProof that is read from and written to files.
+type RawCompletedProof struct {
+	Proof string
+	// VerificationKey is empty in published proofs that reference their key by VerificationKeyFingerprint (see
+	// core.Options.PublishVerificationKeys).
+	VerificationKey            string `json:",omitempty"`
+	MerkleRoot                 []byte
+	MerkleRootWithAssetSumHash []byte
+	MerklePath                 []Hash
+	MerklePosition             int
+	MerkleNodes                [][]Hash
+	AssetSum                   *[]string
+	RoundId                    string
+	HashFunction               circuit.HashFunction        `json:",omitempty"`
+	HashVersion                circuit.HashVersion         `json:",omitempty"`
+	AccountBytesVersion        circuit.AccountBytesVersion `json:",omitempty"`
+	LeafHashMode               circuit.LeafHashMode        `json:",omitempty"`
+	Backend                    ProofBackend                `json:",omitempty"`
+	TreeDepth                  int                         `json:",omitempty"`
+	BalanceBits                int                         `json:",omitempty"`
+	AssetSumBits               int                         `json:",omitempty"`
+	AssetRegistryHash          string                      `json:",omitempty"`
+	AssetSubset                []int                       `json:",omitempty"`
+	AccountCount               *int                        `json:",omitempty"`
+	Epoch                      *uint64                     `json:",omitempty"`
+	CircuitVersion             int                         `json:",omitempty"`
+	VerificationKeyFingerprint string                      `json:",omitempty"`
+}
+
+// Types for user verification elements:
+type UserProofInfo struct {
+	UserMerklePath     []Hash
+	UserMerklePosition int
+	// UserMerklePathElements is the user's merkle path with the side of each sibling (see
+	// circuit.ComputeMerklePathElements), which packages exported with core.ExportOptions.MerklePathElements have in
+	// addition to UserMerklePath, so the path can be verified without relying on the position.
+	UserMerklePathElements []circuit.MerklePathElement
+	BottomProof            CompletedProof
+	MiddleProof            CompletedProof
+	// IntermediateProofs are the proofs of the levels between the middle and top proofs of rounds with more than
+	// MIN_HIERARCHY_LEVELS levels, from the lowest level up (see core.Options.HierarchyLevels).
+	IntermediateProofs []CompletedProof `json:",omitempty"`
+	TopProof           CompletedProof
+}
+
+type UserVerificationElements struct {
+	AccountInfo circuit.GoAccount
+	ProofInfo   UserProofInfo
+}
+
+// RawUVBalance is the balance of a single asset. Amount is in base units (what is committed to in the proofs) and
+// DisplayAmount is in display units (e.g. "1.5" for 1.5 BTC). At least one of them must be set, and if both are set,
+// they must agree.
+type RawUVBalance struct {
+	Asset         string
+	Amount        string
+	DisplayAmount string
+}
+
+// RawLowerLevelProof and RawTopLevelProof have either a VerificationKey, or the VerificationKeyFingerprint of a key
+// distributed in a key bundle.
+type RawLowerLevelProof struct {
+	Proof                      string
+	VerificationKey            string `json:",omitempty"`
+	MerkleRoot                 []byte
+	MerkleRootWithAssetSumHash []byte
+	MerklePosition             int
+	MerklePath                 []Hash
+	RoundId                    string
+	VerificationKeyFingerprint string                      `json:",omitempty"`
+	HashFunction               circuit.HashFunction        `json:",omitempty"`
+	HashVersion                circuit.HashVersion         `json:",omitempty"`
+	AccountBytesVersion        circuit.AccountBytesVersion `json:",omitempty"`
+	LeafHashMode               circuit.LeafHashMode        `json:",omitempty"`
+	Backend                    ProofBackend                `json:",omitempty"`
+	TreeDepth                  int                         `json:",omitempty"`
+	BalanceBits                int                         `json:",omitempty"`
+	AssetSumBits               int                         `json:",omitempty"`
+	AssetRegistryHash          string                      `json:",omitempty"`
+	AssetSubset                []int                       `json:",omitempty"`
+	AccountCount               *int                        `json:",omitempty"`
+	Epoch                      *uint64                     `json:",omitempty"`
+	CircuitVersion             int                         `json:",omitempty"`
+}
+
+type RawTopLevelProof struct {
+	Proof                      string
+	VerificationKey            string `json:",omitempty"`
+	MerkleRoot                 []byte
+	MerkleRootWithAssetSumHash []byte
+	AssetSum                   *[]RawUVBalance
+	RoundId                    string
+	VerificationKeyFingerprint string                      `json:",omitempty"`
+	HashFunction               circuit.HashFunction        `json:",omitempty"`
+	HashVersion                circuit.HashVersion         `json:",omitempty"`
+	AccountBytesVersion        circuit.AccountBytesVersion `json:",omitempty"`
+	LeafHashMode               circuit.LeafHashMode        `json:",omitempty"`
+	Backend                    ProofBackend                `json:",omitempty"`
+	TreeDepth                  int                         `json:",omitempty"`
+	BalanceBits                int                         `json:",omitempty"`
+	AssetSumBits               int                         `json:",omitempty"`
+	AssetRegistryHash          string                      `json:",omitempty"`
+	AssetSubset                []int                       `json:",omitempty"`
+	AccountCount               *int                        `json:",omitempty"`
+	Epoch                      *uint64                     `json:",omitempty"`
+	CircuitVersion             int                         `json:",omitempty"`
+}
+
+type RawUserProofInfo struct {
+	UserMerklePath         []Hash
+	UserMerklePosition     int
+	UserMerklePathElements []circuit.MerklePathElement `json:",omitempty"`
+	BottomProof            RawLowerLevelProof
+	MiddleProof            RawLowerLevelProof
+	IntermediateProofs     []RawLowerLevelProof `json:",omitempty"`
+	TopProof               RawTopLevelProof
+}
+
+type RawUserAccountInfo struct {
+	WalletId string
+	Balance  []RawUVBalance
+	// Salt is the hex encoded salt the account was hashed with (empty for unsalted accounts), which only the user
+	// receives. See circuit.GoAccount.
+	Salt string `json:",omitempty"`
+	// HashedWalletId is set when WalletId is the field element a long WalletId was hashed to. See
+	// circuit.RawGoAccount.
+	HashedWalletId bool `json:",omitempty"`
+	// WalletIdEncoding is the encoding of WalletId (empty for packages that do not record it, which are read with the
+	// configured encoding).
+	WalletIdEncoding circuit.WalletIdEncoding `json:",omitempty"`
+}
+
+type RawUserVerificationElements struct {
+	AccountInfo RawUserAccountInfo
+	ProofInfo   RawUserProofInfo
+}
+
+// KeyBundle lists the verification keys of a round for distribution to verifiers, along with the circuit parameters
+// they were generated with and the window in which they are valid. It is distributed as a SignedKeyBundle.
+type KeyBundle struct {
+	CircuitVersion   int
+	TreeDepth        int
+	AssetCount       int
+	DeploymentDomain string
+	NotBefore        time.Time
+	NotAfter         time.Time
+	Keys             []KeyBundleEntry
+}
+
+// KeyBundleEntry is a distinct verification key of a layer (there is one per circuit shape, i.e. number of accounts).
+type KeyBundleEntry struct {
+	Layer           string
+	Fingerprint     string
+	VerificationKey string
+	// ProofCount is the number of proofs of the layer that use the key.
+	ProofCount int
+	// Backend is the backend of the proofs of the key (BACKEND_GROTH16 if empty), which they are verified with once the
+	// key is pinned (see core.PinKeyBundle).
+	Backend ProofBackend `json:",omitempty"`
+}
+
+// PublishedVerificationKeys are the distinct verification keys of a round, written once to VERIFICATION_KEYS_FILE
+// when the published proofs reference their keys by fingerprint instead of including them (see
+// core.Options.PublishVerificationKeys).
+type PublishedVerificationKeys struct {
+	RoundId string
+	Keys    []PublishedVerificationKey
+}
+
+// PublishedVerificationKey is a distinct verification key of a layer (there is one per circuit shape, i.e. number of
+// accounts).
+type PublishedVerificationKey struct {
+	Layer           string
+	Fingerprint     string
+	VerificationKey string
+	// Backend is the backend of the proofs of the key (BACKEND_GROTH16 if empty), which they are verified with once the
+	// key is pinned (see core.PinPublishedVerificationKeys).
+	Backend ProofBackend `json:",omitempty"`
+}
+
+// SignedKeyBundle is a KeyBundle signed with ed25519. The signature is over the payload bytes, which are the
+// JSON encoding of the bundle (base64 encoded, so the signed bytes survive reformatting of the file).
+type SignedKeyBundle struct {
+	Payload   string
+	PublicKey string
+	Signature string
+}
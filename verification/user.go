@@ -0,0 +1,145 @@
+package verification
+
+import (
+	"fmt"
+
+	"bitgo.com/proof_of_reserves/circuit"
+)
+
+// ProofChain returns the proofs of the user verification package, from the bottom proof up to the top proof, with
+// each proof included in the next.
+func (proofInfo UserProofInfo) ProofChain() []CompletedProof {
+	proofs := append([]CompletedProof{proofInfo.BottomProof, proofInfo.MiddleProof}, proofInfo.IntermediateProofs...)
+	return append(proofs, proofInfo.TopProof)
+}
+
+// userProofName returns how the proof of the given level of a user verification package with levelCount levels is
+// referred to in errors.
+func userProofName(level int, levelCount int) string {
+	if level == 1 && levelCount > 2 {
+		return "middle"
+	}
+	return LayerName(level, levelCount)
+}
+
+// UserProofChain returns the proofs of the user verification package, from the bottom proof up to the top proof, once
+// it checked their verification keys are resolved and that they belong to the same round, and the configuration they
+// were generated with (see ProofsConfig).
+func UserProofChain(userVerifElements UserVerificationElements) ([]CompletedProof, circuit.Config, error) {
+	// check the verification keys were resolved (without them, the proofs can't be verified)
+	resolvedElements, err := ResolveVerificationKeys(userVerifElements, nil)
+	if err != nil {
+		return nil, circuit.Config{}, &VerificationError{Stage: "unresolved verification key in user verification package", Err: err}
+	}
+
+	// extract proofs from verification elements, from the bottom proof up to the top proof
+	proofs := resolvedElements.ProofInfo.ProofChain()
+	topProof := proofs[len(proofs)-1]
+
+	// check the proofs belong to the same round before verifying them
+	for level, proof := range proofs[:len(proofs)-1] {
+		if err := VerifyProofsBelongToRound(topProof.RoundId, LayerName(level, len(proofs)), []CompletedProof{proof}); err != nil {
+			return nil, circuit.Config{}, &VerificationError{Stage: "round id verification failed", Err: err}
+		}
+	}
+	config, err := ProofsConfig(proofs)
+	if err != nil {
+		return nil, circuit.Config{}, &VerificationError{Stage: "proof parameters verification failed", Err: err}
+	}
+	return proofs, config, nil
+}
+
+// VerifyUserProofChain verifies the user verification package with its proof chain (see UserProofChain), hashing its
+// account and nodes with the configuration of its proofs (see ProofsConfig), verifying each proof with verify. Returns
+// a *VerificationError if the package fails verification.
+func VerifyUserProofChain(userVerifElements UserVerificationElements, proofs []CompletedProof, config circuit.Config, verify func(proof CompletedProof, layer string) error) error {
+	bottomProof := proofs[0]
+	topProof := proofs[len(proofs)-1]
+	names := make([]string, len(proofs))
+	for level := range proofs {
+		names[level] = userProofName(level, len(proofs))
+	}
+
+	if err := VerifyAssetRegistry(proofs); err != nil {
+		return &VerificationError{Stage: "asset registry verification failed", Err: err}
+	}
+	if _, err := ProofsEpoch(proofs); err != nil {
+		return &VerificationError{Stage: "epoch verification failed", Err: err}
+	}
+	for level := 0; level < len(proofs)-1; level++ {
+		if err := VerifyBalanceBits(proofs[level], proofs[level+1]); err != nil {
+			return &VerificationError{Stage: "balance range verification failed for " + names[level] + " proof", Err: err}
+		}
+	}
+
+	// create hash of account
+	accountHash := config.GoComputeMiMCHashForAccount(userVerifElements.AccountInfo)
+
+	// verify proofs
+	for level, proof := range proofs {
+		if err := verify(proof, LayerName(level, len(proofs))); err != nil {
+			return &VerificationError{Stage: names[level] + " layer proof verification failed", Err: err}
+		}
+	}
+
+	// verify inclusion of account -> bottom proof -> middle proof (-> intermediate proofs) -> top
+	if err := verifyUserAccountInclusion(accountHash, userVerifElements.ProofInfo, bottomProof, config); err != nil {
+		return &VerificationError{Stage: "failed to verify if account included in bottom proof", Err: err}
+	}
+	if err := VerifyMerklePositionCovered(bottomProof, userVerifElements.ProofInfo.UserMerklePosition); err != nil {
+		return &VerificationError{Stage: "account position verification failed", Err: err}
+	}
+	for level := 0; level < len(proofs)-1; level++ {
+		proof, upperProof := proofs[level], proofs[level+1]
+		err := VerifyMerklePath(
+			proof.MerkleRootWithAssetSumHash,
+			proof.MerklePosition,
+			proof.MerklePath,
+			upperProof.MerkleRoot,
+			config,
+		)
+		if err != nil {
+			return &VerificationError{Stage: fmt.Sprintf("failed to verify if %s proof included in %s proof", names[level], names[level+1]), Err: err}
+		}
+		if err := VerifyMerklePositionCovered(upperProof, proof.MerklePosition); err != nil {
+			return &VerificationError{Stage: names[level] + " proof position verification failed", Err: err}
+		}
+	}
+
+	// verify top layer asset sum (encoded in MerkleRootWithAssetSumHash) matches the published asset sum
+	if err := VerifyTopLayerProofMatchesAssetSum(topProof, config); err != nil {
+		return &VerificationError{Stage: "top layer hashed asset sum does not match published asset sum", Err: err}
+	}
+	return nil
+}
+
+// verifyUserAccountInclusion verifies that the account hash is included in the bottom proof at the user's position,
+// with the user's merkle path (or its elements, if the package has them).
+func verifyUserAccountInclusion(accountHash Hash, proofInfo UserProofInfo, bottomProof CompletedProof, config circuit.Config) error {
+	if proofInfo.UserMerklePathElements != nil {
+		if position := circuit.GetMerklePathElementsPosition(proofInfo.UserMerklePathElements); position != proofInfo.UserMerklePosition {
+			return fmt.Errorf("merkle path elements are of position %d, but the user's position is %d", position, proofInfo.UserMerklePosition)
+		}
+		return VerifyMerklePathElements(accountHash, proofInfo.UserMerklePathElements, bottomProof.MerkleRoot, config)
+	}
+	return VerifyMerklePath(accountHash, proofInfo.UserMerklePosition, proofInfo.UserMerklePath, bottomProof.MerkleRoot, config)
+}
+
+// VerifyUserWithPinnedKeysForDomain verifies a user verification package like core.VerifyUserWithPinnedKeys, hashing
+// its account and nodes with the given deployment domain instead of the configured one, for services that verify the
+// packages of several deployments.
+func VerifyUserWithPinnedKeysForDomain(userVerifElements UserVerificationElements, pins VerificationKeyPins, domain string) (err error) {
+	defer recoverVerificationError(&err)
+	proofs, config, err := UserProofChain(userVerifElements)
+	if err != nil {
+		return err
+	}
+	config.DeploymentDomain = domain
+	return VerifyUserProofChain(userVerifElements, proofs, config, func(proof CompletedProof, layer string) error {
+		verificationKey, backend, err := pins.VerificationKey(proof, layer)
+		if err != nil {
+			return err
+		}
+		return VerifyProofWithKey(proof, verificationKey, backend)
+	})
+}
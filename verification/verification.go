@@ -0,0 +1,47 @@
+// Package verification verifies the published proofs of a round and user verification packages. It has the types of
+// proofs, packages, and verification keys, and the functions to decode and verify them, and nothing of the prover,
+// so verifiers (e.g. the client package) import it without the prover, its storages, and its setup. The core package
+// proves rounds with the same types and verifies them with these functions.
+package verification
+
+import "strconv"
+
+// published files of a round, relative to the output directory of the prover
+const (
+	BOTTOM_PROOF_PREFIX = "public/bottom_level_proof_"
+	MIDDLE_PROOF_PREFIX = "public/mid_level_proof_"
+	TOP_PROOF_PREFIX    = "public/top_level_proof_"
+	ROUND_METADATA_FILE = "public/round_metadata.json"
+
+	// proofs of the levels between the mid and top levels of rounds with more than MIN_HIERARCHY_LEVELS levels, which
+	// are followed by the level and "_level_proof_" (see IntermediateLevelProofPrefix)
+	INTERMEDIATE_PROOF_PREFIX = "public/mid"
+
+	// MIN_HIERARCHY_LEVELS is the number of levels of proofs of a round (bottom, mid, and top) unless more are needed
+	// or configured (see core.Options.HierarchyLevels).
+	MIN_HIERARCHY_LEVELS = 3
+
+	// verification keys the published proofs reference by fingerprint (see core.Options.PublishVerificationKeys)
+	VERIFICATION_KEYS_FILE = "public/vk.json"
+)
+
+// IntermediateLevelProofPrefix returns the prefix of the published proofs of the given intermediate level, between
+// the mid level (1) and the top level of a round with more than MIN_HIERARCHY_LEVELS levels.
+func IntermediateLevelProofPrefix(level int) string {
+	return INTERMEDIATE_PROOF_PREFIX + strconv.Itoa(level) + "_level_proof_"
+}
+
+// LayerName returns the name of the layer of the proofs of the given level of a round with levelCount levels, from the
+// bottom level (0) to the top level (levelCount - 1), as in key bundles and pinned verification keys: "bottom", "mid",
+// "top", or "mid<level>" for the intermediate levels.
+func LayerName(level int, levelCount int) string {
+	switch level {
+	case 0:
+		return "bottom"
+	case levelCount - 1:
+		return "top"
+	case 1:
+		return "mid"
+	}
+	return "mid" + strconv.Itoa(level)
+}
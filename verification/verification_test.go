@@ -0,0 +1,65 @@
+package verification
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"bitgo.com/proof_of_reserves/circuit"
+	"github.com/consensys/gnark/test"
+)
+
+func TestParseProofBackend(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	for name, expected := range map[string]ProofBackend{
+		"":                  BACKEND_GROTH16,
+		"groth16":           BACKEND_GROTH16,
+		"plonk":             BACKEND_PLONK,
+		"groth16-recursive": BACKEND_GROTH16_RECURSIVE,
+	} {
+		backend, err := ParseProofBackend(name)
+		assert.NoError(err)
+		assert.Equal(expected, backend)
+	}
+	_, err := ParseProofBackend("stark")
+	assert.Error(err)
+}
+
+func TestLayerName(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	assert.Equal([]string{"bottom", "mid", "top"}, []string{LayerName(0, 3), LayerName(1, 3), LayerName(2, 3)})
+	assert.Equal([]string{"bottom", "mid", "mid2", "mid3", "top"}, []string{LayerName(0, 5), LayerName(1, 5), LayerName(2, 5), LayerName(3, 5), LayerName(4, 5)})
+	assert.Equal("public/mid2_level_proof_", IntermediateLevelProofPrefix(2))
+}
+
+func TestConvertCompletedProofRoundTrip(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	accountCount, epoch := 4, uint64(7)
+	assetSum := circuit.ConstructGoBalance(big.NewInt(1), big.NewInt(2))
+	proof := CompletedProof{
+		Proof:                      "proof",
+		VerificationKey:            "key",
+		MerkleRoot:                 []byte{1},
+		MerkleRootWithAssetSumHash: []byte{2},
+		AssetSum:                   &assetSum,
+		RoundId:                    "round",
+		Backend:                    BACKEND_PLONK,
+		TreeDepth:                  4,
+		AccountCount:               &accountCount,
+		Epoch:                      &epoch,
+		CircuitVersion:             circuit.CIRCUIT_VERSION,
+	}
+	assert.Equal(proof, ConvertRawCompletedProofToCompletedProof(ConvertCompletedProofToRawCompletedProof(proof)))
+}
+
+func TestVerifyUserWithPinnedKeysRecoversPanics(t *testing.T) {
+	assert := test.NewAssert(t)
+
+	// a package without its proofs fails verification with an error instead of panicking
+	err := VerifyUserWithPinnedKeysForDomain(UserVerificationElements{}, VerificationKeyPins{}, "")
+	var verificationError *VerificationError
+	assert.True(errors.As(err, &verificationError), "expected a *VerificationError, got %v", err)
+}